@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/riftdata/rift/internal/storage"
+	"github.com/riftdata/rift/internal/ui"
+)
+
+// auditRow is one row of 'rift audit' output.
+type auditRow struct {
+	Time      time.Time      `json:"time" yaml:"time"`
+	Branch    string         `json:"branch" yaml:"branch"`
+	Operation string         `json:"operation" yaml:"operation"`
+	User      string         `json:"user" yaml:"user"`
+	Details   map[string]any `json:"details" yaml:"details"`
+}
+
+func runAudit(cmd *cobra.Command, args []string) error {
+	if cfg == nil {
+		return fmt.Errorf("rift not initialized. Run 'rift init' first")
+	}
+	if auditLimit < 0 {
+		return fmt.Errorf("--limit must not be negative")
+	}
+
+	q := storage.AuditQuery{Limit: auditLimit}
+	if len(args) > 0 {
+		q.BranchName = args[0]
+	}
+	if auditSince != "" {
+		since, err := parseSince("--since", auditSince, time.Now())
+		if err != nil {
+			return err
+		}
+		q.Since = since
+	}
+
+	store, err := storage.New(cmd.Context(), cfg.Upstream.URL)
+	if err != nil {
+		return fmt.Errorf("connect to upstream: %w", err)
+	}
+	defer store.Close()
+
+	entries, err := store.ListAudit(cmd.Context(), q)
+	if err != nil {
+		return err
+	}
+
+	rows := make([]auditRow, len(entries))
+	for i, e := range entries {
+		rows[i] = auditRow{Time: e.OccurredAt, Branch: e.BranchName, Operation: e.Operation, User: e.UserName, Details: e.Details}
+	}
+
+	if output == "json" || output == "yaml" {
+		return out.Data(rows)
+	}
+
+	if len(rows) == 0 {
+		out.Info("No operations recorded")
+		return nil
+	}
+
+	table := ui.NewTable(out, "TIME", "BRANCH", "OPERATION", "USER", "DETAILS")
+	for _, r := range rows {
+		userName := r.User
+		if userName == "" {
+			userName = "-"
+		}
+		table.AddRow(r.Time.Local().Format("2006-01-02 15:04:05"), r.Branch, r.Operation, userName, formatDetails(r.Details))
+	}
+	table.Render()
+	return nil
+}
+
+// parseSince parses an RFC 3339 timestamp, a YYYY-MM-DD date or a duration
+// before now (a Go duration such as 36h, or whole days such as 7d). flag
+// names the option in errors.
+func parseSince(flag, s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid %s %q: expected a timestamp, YYYY-MM-DD or a duration like 24h or 7d", flag, s)
+}
+
+// formatDetails renders audit details as sorted key=value pairs.
+func formatDetails(details map[string]any) string {
+	keys := make([]string, 0, len(details))
+	for k := range details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%v", k, details[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// localUser returns the OS user running the CLI, for the audit log.
+func localUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
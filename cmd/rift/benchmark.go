@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+
+	"github.com/riftdata/rift/internal/cow"
+	"github.com/riftdata/rift/internal/ui"
+)
+
+// benchmarkSampleSize caps the primary keys read from the table to draw
+// random lookups from.
+const benchmarkSampleSize = 10000
+
+// latencyStats summarizes the latencies of one benchmark run.
+type latencyStats struct {
+	Target  string  `json:"target" yaml:"target"`
+	Queries int     `json:"queries" yaml:"queries"`
+	Errors  int     `json:"errors" yaml:"errors"`
+	MeanMs  float64 `json:"mean_ms" yaml:"mean_ms"`
+	P50Ms   float64 `json:"p50_ms" yaml:"p50_ms"`
+	P95Ms   float64 `json:"p95_ms" yaml:"p95_ms"`
+	P99Ms   float64 `json:"p99_ms" yaml:"p99_ms"`
+}
+
+// benchmarkReport compares direct upstream lookups with lookups through the
+// proxy on a branch.
+type benchmarkReport struct {
+	Branch          string       `json:"branch" yaml:"branch"`
+	Table           string       `json:"table" yaml:"table"`
+	Concurrency     int          `json:"concurrency" yaml:"concurrency"`
+	Direct          latencyStats `json:"direct" yaml:"direct"`
+	Proxy           latencyStats `json:"proxy" yaml:"proxy"`
+	OverheadMeanMs  float64      `json:"overhead_mean_ms" yaml:"overhead_mean_ms"`
+	OverheadMeanPct float64      `json:"overhead_mean_pct" yaml:"overhead_mean_pct"`
+	OverheadP95Ms   float64      `json:"overhead_p95_ms" yaml:"overhead_p95_ms"`
+}
+
+func runBenchmark(cmd *cobra.Command, args []string) error {
+	if cfg == nil {
+		return fmt.Errorf("rift not initialized. Run 'rift init' first")
+	}
+	if benchQueries < 1 {
+		return fmt.Errorf("--queries must be at least 1")
+	}
+	if benchConcurrency < 1 || benchConcurrency > 256 {
+		return fmt.Errorf("--concurrency must be between 1 and 256")
+	}
+
+	ctx := cmd.Context()
+	branchName := args[0]
+	schema, table := "public", args[1]
+	if i := strings.IndexByte(table, '.'); i != -1 {
+		schema, table = table[:i], table[i+1:]
+	}
+
+	proxyURL, err := proxyConnURL(cfg.Upstream.URL, cfg.Proxy.ListenAddr, branchName)
+	if err != nil {
+		return err
+	}
+
+	direct, err := newBenchmarkPool(ctx, cfg.Upstream.URL, benchConcurrency)
+	if err != nil {
+		return fmt.Errorf("connect to upstream: %w", err)
+	}
+	defer direct.Close()
+
+	pkCols, err := cow.GetTablePrimaryKeys(ctx, direct, schema, table)
+	if err != nil {
+		return fmt.Errorf("get primary key of %s.%s: %w", schema, table, err)
+	}
+	if len(pkCols) == 0 {
+		return fmt.Errorf("table %s.%s has no primary key", schema, table)
+	}
+
+	keys, err := benchmarkKeys(ctx, direct, schema, table, pkCols, benchQueries)
+	if err != nil {
+		return err
+	}
+
+	proxied, err := newBenchmarkPool(ctx, proxyURL, benchConcurrency)
+	if err != nil {
+		return fmt.Errorf("connect to proxy (is 'rift serve' running?): %w", err)
+	}
+	defer proxied.Close()
+
+	query := lookupQuery(schema, table, pkCols)
+	spinner := ui.NewSimpleSpinner(fmt.Sprintf("Running %d lookups directly against upstream", len(keys)))
+	spinner.Start()
+	directStats, err := runLookups(ctx, direct, query, keys, benchConcurrency)
+	if err != nil {
+		spinner.Stop("Failed")
+		return fmt.Errorf("direct lookups: %w", err)
+	}
+	spinner.Stop("Direct lookups done")
+
+	spinner = ui.NewSimpleSpinner(fmt.Sprintf("Running %d lookups through the proxy on '%s'", len(keys), branchName))
+	spinner.Start()
+	proxyStats, err := runLookups(ctx, proxied, query, keys, benchConcurrency)
+	if err != nil {
+		spinner.Stop("Failed")
+		return fmt.Errorf("proxy lookups: %w", err)
+	}
+	spinner.Stop("Proxy lookups done")
+
+	report := benchmarkReport{
+		Branch:      branchName,
+		Table:       schema + "." + table,
+		Concurrency: benchConcurrency,
+		Direct:      summarizeLatencies("direct", directStats.durations, directStats.errors),
+		Proxy:       summarizeLatencies("proxy ("+branchName+")", proxyStats.durations, proxyStats.errors),
+	}
+	report.OverheadMeanMs = report.Proxy.MeanMs - report.Direct.MeanMs
+	report.OverheadP95Ms = report.Proxy.P95Ms - report.Direct.P95Ms
+	if report.Direct.MeanMs > 0 {
+		report.OverheadMeanPct = report.OverheadMeanMs / report.Direct.MeanMs * 100
+	}
+
+	if output == "json" || output == "yaml" {
+		return out.Data(report)
+	}
+
+	out.Title(fmt.Sprintf("Benchmark: %s on branch %s", report.Table, branchName))
+	t := ui.NewTable(out, "TARGET", "QUERIES", "ERRORS", "MEAN", "P50", "P95", "P99")
+	for _, s := range []latencyStats{report.Direct, report.Proxy} {
+		t.AddRow(s.Target, fmt.Sprintf("%d", s.Queries), fmt.Sprintf("%d", s.Errors),
+			formatMs(s.MeanMs), formatMs(s.P50Ms), formatMs(s.P95Ms), formatMs(s.P99Ms))
+	}
+	t.Render()
+
+	out.Print("")
+	out.KeyValue("Overhead (mean)", fmt.Sprintf("%+.2fms (%+.1f%%)", report.OverheadMeanMs, report.OverheadMeanPct))
+	out.KeyValue("Overhead (p95)", fmt.Sprintf("%+.2fms", report.OverheadP95Ms))
+	return nil
+}
+
+// proxyConnURL returns a connection URL for branchName through the proxy
+// listening on listenAddr, reusing the upstream URL's credentials.
+func proxyConnURL(upstreamURL, listenAddr, branchName string) (string, error) {
+	u, err := url.Parse(upstreamURL)
+	if err != nil {
+		return "", fmt.Errorf("parse upstream url: %w", err)
+	}
+
+	if listenAddr == "" {
+		listenAddr = ":6432"
+	}
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return "", fmt.Errorf("parse proxy listen address %q: %w", listenAddr, err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	u.Host = net.JoinHostPort(host, port)
+	u.Path = "/" + branchName
+	// The proxy does not terminate TLS.
+	q := u.Query()
+	q.Set("sslmode", "disable")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// newBenchmarkPool opens a pool with one connection per worker. Queries use
+// the simple protocol on both sides so that direct and proxied lookups do
+// the same round trips.
+func newBenchmarkPool(ctx context.Context, connURL string, workers int) (*pgxpool.Pool, error) {
+	poolCfg, err := pgxpool.ParseConfig(connURL)
+	if err != nil {
+		return nil, err
+	}
+	poolCfg.MaxConns = int32(workers) // #nosec G115 -- workers is validated to be at most 256
+	poolCfg.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// benchmarkKeys samples primary keys from the table and returns n of them,
+// chosen at random (with repetition).
+func benchmarkKeys(ctx context.Context, pool *pgxpool.Pool, schema, table string, pkCols []string, n int) ([][]interface{}, error) {
+	cols := make([]string, len(pkCols))
+	for i, c := range pkCols {
+		cols[i] = pgx.Identifier{c}.Sanitize()
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s LIMIT %d",
+		strings.Join(cols, ", "), pgx.Identifier{schema, table}.Sanitize(), benchmarkSampleSize)
+
+	rows, err := pool.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("sample primary keys: %w", err)
+	}
+	sample, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) ([]interface{}, error) {
+		return row.Values()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sample primary keys: %w", err)
+	}
+	if len(sample) == 0 {
+		return nil, fmt.Errorf("table %s.%s is empty", schema, table)
+	}
+
+	keys := make([][]interface{}, n)
+	for i := range keys {
+		keys[i] = sample[rand.IntN(len(sample))] // #nosec G404 -- benchmark key choice needs no cryptographic randomness
+	}
+	return keys, nil
+}
+
+// lookupQuery returns a primary key lookup with one parameter per key column.
+func lookupQuery(schema, table string, pkCols []string) string {
+	conds := make([]string, len(pkCols))
+	for i, c := range pkCols {
+		conds[i] = fmt.Sprintf("%s = $%d", pgx.Identifier{c}.Sanitize(), i+1)
+	}
+	return fmt.Sprintf("SELECT * FROM %s WHERE %s",
+		pgx.Identifier{schema, table}.Sanitize(), strings.Join(conds, " AND "))
+}
+
+// lookupResult holds the latencies of successful lookups and the number of
+// failed ones.
+type lookupResult struct {
+	durations []time.Duration
+	errors    int
+}
+
+// runLookups runs query once per key, spread over workers goroutines that
+// each hold their own pooled connection. It fails only if every lookup fails.
+func runLookups(ctx context.Context, pool *pgxpool.Pool, query string, keys [][]interface{}, workers int) (lookupResult, error) {
+	jobs := make(chan int)
+	durations := make([]time.Duration, len(keys))
+	var failed atomic.Int64
+
+	var errOnce sync.Once
+	var firstErr error
+	recordErr := func(err error) { errOnce.Do(func() { firstErr = err }) }
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := pool.Acquire(ctx)
+			if err != nil {
+				recordErr(err)
+				for range jobs {
+					failed.Add(1)
+				}
+				return
+			}
+			defer conn.Release()
+
+			for i := range jobs {
+				start := time.Now()
+				if err := lookup(ctx, conn, query, keys[i]); err != nil {
+					recordErr(err)
+					failed.Add(1)
+					durations[i] = -1
+					continue
+				}
+				durations[i] = time.Since(start)
+			}
+		}()
+	}
+
+	for i := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return lookupResult{}, err
+	}
+
+	res := lookupResult{errors: int(failed.Load())}
+	for _, d := range durations {
+		if d > 0 {
+			res.durations = append(res.durations, d)
+		}
+	}
+	if len(res.durations) == 0 {
+		if firstErr != nil {
+			return lookupResult{}, firstErr
+		}
+		return lookupResult{}, errors.New("no lookups completed")
+	}
+	return res, nil
+}
+
+func lookup(ctx context.Context, conn *pgxpool.Conn, query string, key []interface{}) error {
+	rows, err := conn.Query(ctx, query, key...)
+	if err != nil {
+		return err
+	}
+	// Read the whole result so the timing includes transferring it.
+	for rows.Next() {
+	}
+	rows.Close()
+	return rows.Err()
+}
+
+// summarizeLatencies computes the mean and percentiles of durations.
+func summarizeLatencies(target string, durations []time.Duration, errs int) latencyStats {
+	s := latencyStats{Target: target, Queries: len(durations) + errs, Errors: errs}
+	if len(durations) == 0 {
+		return s
+	}
+
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	s.MeanMs = toMs(total / time.Duration(len(sorted)))
+	s.P50Ms = toMs(percentile(sorted, 50))
+	s.P95Ms = toMs(percentile(sorted, 95))
+	s.P99Ms = toMs(percentile(sorted, 99))
+	return s
+}
+
+// percentile returns the p-th percentile of sorted using the nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func formatMs(ms float64) string {
+	return fmt.Sprintf("%.2fms", ms)
+}
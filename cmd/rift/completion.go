@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/riftdata/rift/internal/ui"
+)
+
+// bashrcSnippet loads the scripts in ~/.bash_completion.d, where
+// rift completion --install puts bash's.
+const bashrcSnippet = `
+# Shell completions installed in ~/.bash_completion.d (added by rift)
+for f in ~/.bash_completion.d/*; do [ -r "$f" ] && . "$f"; done
+`
+
+// writeCompletion writes the completion script for shell to w.
+func writeCompletion(cmd *cobra.Command, shell string, w io.Writer) error {
+	switch shell {
+	case "bash":
+		return cmd.Root().GenBashCompletion(w)
+	case "zsh":
+		return cmd.Root().GenZshCompletion(w)
+	case "fish":
+		return cmd.Root().GenFishCompletion(w, true)
+	case "powershell":
+		return cmd.Root().GenPowerShellCompletionWithDesc(w)
+	}
+	return fmt.Errorf("unsupported shell %q", shell)
+}
+
+// installCompletion writes the completion script for the shell named in
+// args, or $SHELL, to where that shell loads completions from, asking
+// before it writes and before it replaces an existing script.
+func installCompletion(cmd *cobra.Command, args []string) error {
+	// The root command doesn't set up output for completion
+	out = ui.NewOutput(ui.OutputFormat(output), noColor, quiet)
+
+	shell := filepath.Base(os.Getenv("SHELL"))
+	if len(args) > 0 {
+		shell = args[0]
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("find home directory: %w", err)
+	}
+	path, err := completionPath(shell, home)
+	if err != nil {
+		return err
+	}
+
+	ok, err := ui.Confirm(fmt.Sprintf("Install %s completions to %s?", shell, path), true)
+	if err != nil || !ok {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		ok, err := ui.Confirm(fmt.Sprintf("%s already exists. Overwrite it?", path), false)
+		if err != nil || !ok {
+			return err
+		}
+	}
+
+	var script bytes.Buffer
+	if err := writeCompletion(cmd, shell, &script); err != nil {
+		return fmt.Errorf("generate %s completions: %w", shell, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, script.Bytes(), 0o644); err != nil { // #nosec G306 -- shell scripts are world-readable
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	out.Success(fmt.Sprintf("Installed %s completions to %s", shell, path))
+
+	switch shell {
+	case "bash":
+		if err := sourceBashCompletions(home); err != nil {
+			return err
+		}
+		out.Info(fmt.Sprintf("Restart your shell or run: source %s", path))
+	case "zsh":
+		out.Info("Restart your shell to load them. Completions need compinit; if they don't load, add to ~/.zshrc:")
+		out.Print(fmt.Sprintf("  fpath=(%s $fpath); autoload -U compinit; compinit", filepath.Dir(path)))
+	case "fish":
+		out.Info(fmt.Sprintf("Restart your shell or run: source %s", path))
+	}
+	return nil
+}
+
+// completionPath returns where shell loads completion scripts from: a
+// script in ~/.bash_completion.d for bash, the first directory of zsh's
+// fpath, and fish's per-user completions directory.
+func completionPath(shell, home string) (string, error) {
+	switch shell {
+	case "bash":
+		return filepath.Join(home, ".bash_completion.d", "rift"), nil
+	case "zsh":
+		return filepath.Join(zshCompletionDir(home), "_rift"), nil
+	case "fish":
+		return filepath.Join(home, ".config", "fish", "completions", "rift.fish"), nil
+	case "", ".":
+		return "", errors.New("can't tell your shell from $SHELL; name it, e.g. rift completion bash --install")
+	case "powershell", "pwsh":
+		return "", errors.New("--install doesn't support PowerShell; see rift completion --help")
+	}
+	return "", fmt.Errorf("--install doesn't support %s: must be bash, zsh or fish", shell)
+}
+
+// zshCompletionDir returns the first directory of the user's zsh fpath, or
+// ~/.zsh/completions when zsh can't say or that directory isn't writable
+// without root.
+func zshCompletionDir(home string) string {
+	fallback := filepath.Join(home, ".zsh", "completions")
+	res, err := exec.Command("zsh", "-ic", `print -r -- "${fpath[1]}"`).Output()
+	if err != nil {
+		return fallback
+	}
+	// Interactive shells may print their own output first; fpath is last
+	lines := strings.Split(strings.TrimSpace(string(res)), "\n")
+	dir := strings.TrimSpace(lines[len(lines)-1])
+	if dir == "" || !strings.HasPrefix(dir, home+string(filepath.Separator)) {
+		return fallback
+	}
+	return dir
+}
+
+// sourceBashCompletions adds a loop loading ~/.bash_completion.d to
+// ~/.bashrc, after asking, unless .bashrc already mentions the directory.
+func sourceBashCompletions(home string) error {
+	bashrc := filepath.Join(home, ".bashrc")
+	existing, err := os.ReadFile(bashrc) // #nosec G304 -- the user's own .bashrc
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read %s: %w", bashrc, err)
+	}
+	if bytes.Contains(existing, []byte(".bash_completion.d")) {
+		return nil
+	}
+
+	ok, err := ui.Confirm(fmt.Sprintf("%s doesn't load ~/.bash_completion.d. Add it?", bashrc), true)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		out.Warning("Completions won't load in new shells until ~/.bash_completion.d/rift is sourced from " + bashrc)
+		return nil
+	}
+
+	f, err := os.OpenFile(bashrc, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644) // #nosec G302 G304 -- the user's own .bashrc
+	if err != nil {
+		return fmt.Errorf("open %s: %w", bashrc, err)
+	}
+	if _, err := f.WriteString(bashrcSnippet); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("update %s: %w", bashrc, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("update %s: %w", bashrc, err)
+	}
+	out.Success("Added ~/.bash_completion.d to " + bashrc)
+	return nil
+}
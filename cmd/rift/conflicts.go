@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/riftdata/rift/internal/cow"
+	"github.com/riftdata/rift/internal/ui"
+)
+
+// Values of rift merge --conflict-resolution.
+const (
+	conflictsBranch      = "branch"
+	conflictsParent      = "parent"
+	conflictsInteractive = "interactive"
+)
+
+// checkConflictResolution validates --conflict-resolution against the
+// other merge flags.
+func checkConflictResolution() error {
+	switch mergeResolve {
+	case conflictsBranch:
+		return nil
+	case conflictsParent, conflictsInteractive:
+	default:
+		return fmt.Errorf("invalid --conflict-resolution %q: must be branch, parent or interactive", mergeResolve)
+	}
+	switch {
+	case mergeTarget != "":
+		return fmt.Errorf("--conflict-resolution can't be used with --to")
+	case mergeNoTx:
+		return fmt.Errorf("--conflict-resolution can't be used with --no-transaction")
+	case mergeSize:
+		return fmt.Errorf("--conflict-resolution can't be used with --preview-size")
+	case mergeOrder:
+		return fmt.Errorf("--conflict-resolution can't be used with --table-order")
+	case mergeVerify:
+		return fmt.Errorf("--conflict-resolution can't be used with --verify")
+	case mergeResolve == conflictsInteractive && (output == "json" || output == "yaml"):
+		return fmt.Errorf("--conflict-resolution interactive can't be used with --output %s", output)
+	}
+	return nil
+}
+
+// resolveMergeConflicts finds the rows changed on both the branch and its
+// parent and decides each one as --conflict-resolution says: all for the
+// parent, or one by one with interactive. It returns nil with the default,
+// branch, which merges the branch's rows as usual.
+func resolveMergeConflicts(ctx context.Context, engine *cow.Engine, branchName string) ([]cow.ConflictResolution, error) {
+	if mergeResolve == conflictsBranch {
+		return nil, nil
+	}
+
+	conflicts, err := engine.DetectMergeConflicts(ctx, branchName, mergeTables)
+	if err != nil {
+		return nil, fmt.Errorf("detect conflicts: %w", err)
+	}
+	if len(conflicts) == 0 {
+		out.Info("No conflicts with the parent")
+		return nil, nil
+	}
+
+	resolutions := make([]cow.ConflictResolution, 0, len(conflicts))
+	if mergeResolve == conflictsParent {
+		for i := range conflicts {
+			resolutions = append(resolutions, conflicts[i].Resolution(cow.ResolveParent))
+		}
+		out.Info(fmt.Sprintf("Keeping the parent's version of %d conflicting row(s)", len(conflicts)))
+		return resolutions, nil
+	}
+
+	counts := make(map[string]int)
+	for i := range conflicts {
+		c := &conflicts[i]
+		r, err := promptConflict(c, i+1, len(conflicts))
+		if err != nil {
+			return nil, err
+		}
+		counts[r.Choice]++
+		resolutions = append(resolutions, r)
+	}
+	out.Info(fmt.Sprintf("Resolved %d conflict(s): %d branch, %d parent, %d edited, %d skipped",
+		len(conflicts), counts[cow.ResolveBranch], counts[cow.ResolveParent], counts[cow.ResolveEdit], counts[cow.ResolveSkip]))
+	return resolutions, nil
+}
+
+// promptConflict shows a conflict and asks how to resolve it.
+func promptConflict(c *cow.MergeConflict, n, total int) (cow.ConflictResolution, error) {
+	out.Print("")
+	out.Box(formatConflict(c))
+
+	for {
+		choice, err := ui.SelectOption(
+			fmt.Sprintf("Conflict %d of %d: %s.%s %s", n, total, c.SourceSchema, c.Table, formatRow(c.PK)),
+			fmt.Sprintf("Changed on the branch and in the parent (at %s)", c.ParentChangedAt.Local().Format("2006-01-02 15:04:05")),
+			[]ui.Option{
+				{Key: cow.ResolveBranch, Label: "(k)eep branch version"},
+				{Key: cow.ResolveParent, Label: "(p)arent version"},
+				{Key: cow.ResolveEdit, Label: "(e)dit manually"},
+				{Key: cow.ResolveSkip, Label: "(s)kip"},
+			})
+		if err != nil {
+			return cow.ConflictResolution{}, err
+		}
+
+		r := c.Resolution(choice)
+		if choice != cow.ResolveEdit {
+			return r, nil
+		}
+		if r.Values, err = editConflict(c); err != nil {
+			out.Warning(fmt.Sprintf("Edit failed: %v", err))
+			continue
+		}
+		return r, nil
+	}
+}
+
+// formatConflict lists the columns whose values differ between the
+// branch's and the parent's version of a conflicting row.
+func formatConflict(c *cow.MergeConflict) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s.%s %s\n", c.SourceSchema, c.Table, formatRow(c.PK))
+	if c.Branch == nil {
+		b.WriteString("  deleted on the branch, changed in the parent")
+		return b.String()
+	}
+	var lines []string
+	for _, col := range slices.Sorted(maps.Keys(c.Parent)) {
+		branch, parent := c.Branch[col], c.Parent[col]
+		if !bytes.Equal(branch, parent) {
+			lines = append(lines, fmt.Sprintf("  %s: branch %s, parent %s", col, branch, parent))
+		}
+	}
+	b.WriteString(strings.Join(lines, "\n"))
+	return b.String()
+}
+
+// formatRow renders JSON-encoded column values as {"id":1}.
+func formatRow(row map[string]json.RawMessage) string {
+	b, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Sprint(row)
+	}
+	return string(b)
+}
+
+// conflictFile is the YAML document 'e' opens in $EDITOR: both versions of
+// the row, and the resolved version to edit, which starts as the branch's.
+type conflictFile struct {
+	Branch   map[string]any `yaml:"branch"`
+	Parent   map[string]any `yaml:"parent"`
+	Resolved map[string]any `yaml:"resolved"`
+}
+
+// editConflict opens both versions of a conflicting row in $EDITOR and
+// returns the values the user saves under "resolved".
+func editConflict(c *cow.MergeConflict) (map[string]json.RawMessage, error) {
+	doc := conflictFile{Parent: yamlRow(c.Parent)}
+	doc.Resolved = yamlRow(c.Parent)
+	if c.Branch != nil {
+		doc.Branch = yamlRow(c.Branch)
+		doc.Resolved = yamlRow(c.Branch)
+	}
+	body, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("encode conflict: %w", err)
+	}
+
+	f, err := os.CreateTemp("", "rift-conflict-*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	header := fmt.Sprintf("# Conflict in %s.%s %s\n# Edit the values under \"resolved\", save and quit. Columns removed from it\n# keep the parent's value.\n", c.SourceSchema, c.Table, formatRow(c.PK))
+	if c.Branch == nil {
+		header += "# The branch deleted this row; \"branch\" is empty.\n"
+	}
+	if _, err := f.WriteString(header + string(body)); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := runEditor(f.Name()); err != nil {
+		return nil, err
+	}
+
+	edited, err := os.ReadFile(f.Name()) // #nosec G304 -- temp file created above
+	if err != nil {
+		return nil, err
+	}
+	var saved conflictFile
+	if err := yaml.Unmarshal(edited, &saved); err != nil {
+		return nil, fmt.Errorf("parse edited conflict: %w", err)
+	}
+	if len(saved.Resolved) == 0 {
+		return nil, errors.New(`no values under "resolved"`)
+	}
+	values := make(map[string]json.RawMessage, len(saved.Resolved))
+	for col, v := range saved.Resolved {
+		if values[col], err = json.Marshal(v); err != nil {
+			return nil, fmt.Errorf("column %s: %w", col, err)
+		}
+	}
+	return values, nil
+}
+
+// runEditor opens path in $VISUAL or $EDITOR, falling back to vi.
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], path)...) // #nosec G204 -- the user's own editor
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("run %s: %w", args[0], err)
+	}
+	return nil
+}
+
+// yamlRow decodes JSON-encoded column values for editing as YAML. Integers
+// stay exact; other numbers are kept as strings, which Postgres parses back.
+func yamlRow(row map[string]json.RawMessage) map[string]any {
+	decoded := make(map[string]any, len(row))
+	for col, raw := range row {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			decoded[col] = string(raw)
+			continue
+		}
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				v = i
+			} else {
+				v = n.String()
+			}
+		}
+		decoded[col] = v
+	}
+	return decoded
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/riftdata/rift/internal/cow"
+	"github.com/riftdata/rift/internal/storage"
+	"github.com/riftdata/rift/internal/ui"
+)
+
+// branchDescription is 'rift branches describe' output.
+type branchDescription struct {
+	Name          string     `json:"name" yaml:"name"`
+	Parent        string     `json:"parent,omitempty" yaml:"parent,omitempty"`
+	Status        string     `json:"status" yaml:"status"`
+	CreatedAt     time.Time  `json:"created_at" yaml:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" yaml:"updated_at"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+	TTLSeconds    *int       `json:"ttl_seconds,omitempty" yaml:"ttl_seconds,omitempty"`
+	Pinned        bool       `json:"pinned" yaml:"pinned"`
+	Protected     bool       `json:"protected" yaml:"protected"`
+	Frozen        bool       `json:"frozen" yaml:"frozen"`
+	RowsChanged   int64      `json:"rows_changed" yaml:"rows_changed"`
+	DeltaSize     int64      `json:"delta_size" yaml:"delta_size"`
+	MaxDeltaBytes *int64     `json:"max_delta_bytes,omitempty" yaml:"max_delta_bytes,omitempty"`
+	AllowedHosts  []string   `json:"allowed_hosts,omitempty" yaml:"allowed_hosts,omitempty"`
+
+	// Ancestry is the branch followed by its ancestors, nearest first.
+	Ancestry      []string `json:"ancestry" yaml:"ancestry"`
+	OverlaySchema string   `json:"overlay_schema" yaml:"overlay_schema"`
+
+	Tables     []describedTable     `json:"tables" yaml:"tables"`
+	Migrations []describedMigration `json:"migrations" yaml:"migrations"`
+
+	// CreateCommand recreates the branch; empty for main.
+	CreateCommand string              `json:"create_command,omitempty" yaml:"create_command,omitempty"`
+	Connections   describedConnection `json:"connections" yaml:"connections"`
+}
+
+type describedTable struct {
+	Schema      string `json:"schema" yaml:"schema"`
+	Table       string `json:"table" yaml:"table"`
+	OverlayRows int64  `json:"overlay_rows" yaml:"overlay_rows"`
+	Tombstones  int64  `json:"tombstones" yaml:"tombstones"`
+}
+
+type describedMigration struct {
+	File      string     `json:"file" yaml:"file"`
+	Hash      string     `json:"hash" yaml:"hash"`
+	AppliedAt time.Time  `json:"applied_at" yaml:"applied_at"`
+	MergedAt  *time.Time `json:"merged_at,omitempty" yaml:"merged_at,omitempty"`
+}
+
+type describedConnection struct {
+	Proxy    string `json:"proxy" yaml:"proxy"`
+	Upstream string `json:"upstream" yaml:"upstream"`
+}
+
+func runDescribe(cmd *cobra.Command, args []string) error {
+	if cfg == nil {
+		return fmt.Errorf("rift not initialized. Run 'rift init' first")
+	}
+	ctx := cmd.Context()
+	branchName := args[0]
+
+	store, engine, err := connectAndInit(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	b, err := store.GetBranch(ctx, branchName)
+	if err != nil {
+		return fmt.Errorf("branch %q not found", branchName)
+	}
+	d := &branchDescription{
+		Name:          b.Name,
+		Parent:        b.Parent,
+		Status:        b.Status,
+		CreatedAt:     b.CreatedAt,
+		UpdatedAt:     b.UpdatedAt,
+		TTLSeconds:    b.TTLSeconds,
+		Pinned:        b.Pinned,
+		Protected:     b.Protected,
+		Frozen:        b.Frozen,
+		RowsChanged:   b.RowsChanged,
+		DeltaSize:     b.DeltaSize,
+		MaxDeltaBytes: b.MaxDeltaBytes,
+		AllowedHosts:  b.AllowedHosts,
+		OverlaySchema: store.BranchSchemaName(b.Name),
+		Tables:        []describedTable{},
+		Migrations:    []describedMigration{},
+		CreateCommand: createCommand(b),
+		Connections: describedConnection{
+			Proxy:    proxyURL(cfg.Proxy.ListenAddr, b.Name),
+			Upstream: maskPassword(cfg.Upstream.URL),
+		},
+	}
+	if b.TTLSeconds != nil {
+		expiresAt := b.CreatedAt.Add(time.Duration(*b.TTLSeconds) * time.Second)
+		d.ExpiresAt = &expiresAt
+	}
+
+	if d.Ancestry, err = engine.Lineage(ctx, b.Name); err != nil {
+		return fmt.Errorf("ancestry: %w", err)
+	}
+	if d.Tables, err = describeTables(ctx, store, b.Name); err != nil {
+		return err
+	}
+	migrations, err := store.ListMigrations(ctx, b.Name)
+	if err != nil {
+		return fmt.Errorf("list migrations: %w", err)
+	}
+	for _, m := range migrations {
+		d.Migrations = append(d.Migrations, describedMigration{
+			File:      m.FileName,
+			Hash:      m.FileHash,
+			AppliedAt: m.AppliedAt,
+			MergedAt:  m.MergedAt,
+		})
+	}
+
+	if output == "json" || output == "yaml" {
+		return out.Data(d)
+	}
+	printDescription(d)
+	return nil
+}
+
+// describeTables counts the overlay rows and tombstones of each table a
+// branch tracks.
+func describeTables(ctx context.Context, store storage.Store, branchName string) ([]describedTable, error) {
+	tables, err := store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return nil, fmt.Errorf("list tracked tables: %w", err)
+	}
+	pool := store.Pool()
+	branchSchema := store.BranchSchemaName(branchName)
+	described := make([]describedTable, 0, len(tables))
+	for _, t := range tables {
+		rows, err := cow.OverlayRowCount(ctx, pool, branchSchema, t.TableName)
+		if err != nil {
+			return nil, fmt.Errorf("count overlay rows of %s.%s: %w", t.SourceSchema, t.TableName, err)
+		}
+		tombstones, err := cow.TombstoneCount(ctx, pool, branchSchema, t.TableName)
+		if err != nil {
+			return nil, fmt.Errorf("count tombstones of %s.%s: %w", t.SourceSchema, t.TableName, err)
+		}
+		described = append(described, describedTable{
+			Schema:      t.SourceSchema,
+			Table:       t.TableName,
+			OverlayRows: rows,
+			Tombstones:  tombstones,
+		})
+	}
+	return described, nil
+}
+
+func printDescription(d *branchDescription) {
+	out.Title(fmt.Sprintf("Branch: %s", d.Name))
+
+	out.KeyValue("Ancestry", strings.Join(d.Ancestry, " → "))
+	out.KeyValue("Status", ui.Success.Render(d.Status))
+	out.KeyValue("Created", d.CreatedAt.Format("2006-01-02 15:04:05"))
+	out.KeyValue("Updated", d.UpdatedAt.Format("2006-01-02 15:04:05"))
+	if d.ExpiresAt != nil {
+		out.KeyValue("Expires", d.ExpiresAt.Format("2006-01-02 15:04:05"))
+	}
+	out.KeyValue("Rows changed", fmt.Sprintf("%d", d.RowsChanged))
+	out.KeyValue("Delta size", formatBytes(d.DeltaSize))
+	if d.MaxDeltaBytes != nil {
+		out.KeyValue("Size limit", formatBytes(*d.MaxDeltaBytes))
+	}
+	out.KeyValue("Pinned", fmt.Sprintf("%v", d.Pinned))
+	out.KeyValue("Protected", fmt.Sprintf("%v", d.Protected))
+	out.KeyValue("Frozen", fmt.Sprintf("%v", d.Frozen))
+	if len(d.AllowedHosts) > 0 {
+		out.KeyValue("Allowed hosts", strings.Join(d.AllowedHosts, ", "))
+	}
+	out.KeyValue("Overlay schema", d.OverlaySchema)
+
+	out.Print("")
+	if len(d.Tables) == 0 {
+		out.Info("No tracked tables")
+	} else {
+		out.Info("Tracked tables:")
+		table := ui.NewTable(out, "TABLE", "OVERLAY ROWS", "TOMBSTONES")
+		for _, t := range d.Tables {
+			table.AddRow(t.Schema+"."+t.Table, fmt.Sprintf("%d", t.OverlayRows), fmt.Sprintf("%d", t.Tombstones))
+		}
+		table.Render()
+	}
+
+	out.Print("")
+	if len(d.Migrations) == 0 {
+		out.Info("No migrations applied")
+	} else {
+		out.Info("Migrations:")
+		table := ui.NewTable(out, "FILE", "APPLIED", "MERGED")
+		for _, m := range d.Migrations {
+			merged := "-"
+			if m.MergedAt != nil {
+				merged = m.MergedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			table.AddRow(m.File, m.AppliedAt.Local().Format("2006-01-02 15:04:05"), merged)
+		}
+		table.Render()
+	}
+
+	out.Print("")
+	box := fmt.Sprintf("%s Proxy:     %s\n%s Upstream:  %s", ui.IconDatabase, d.Connections.Proxy, ui.IconArrow, d.Connections.Upstream)
+	if d.CreateCommand != "" {
+		box = fmt.Sprintf("%s Recreate:  %s\n", ui.IconInfo, d.CreateCommand) + box
+	}
+	out.Box(box)
+}
+
+// createCommand reconstructs the rift create command for a branch, or
+// returns "" for main, which rift init creates.
+func createCommand(b *storage.Branch) string {
+	if b.Parent == "" {
+		return ""
+	}
+	command := fmt.Sprintf("rift create %s --parent %s", b.Name, b.Parent)
+	if b.TTLSeconds != nil {
+		command += " --ttl " + formatTTL(*b.TTLSeconds)
+	}
+	return command
+}
+
+// formatTTL renders a TTL as a duration rift create's --ttl takes, without
+// trailing zero units: 24h rather than 24h0m0s.
+func formatTTL(seconds int) string {
+	s := (time.Duration(seconds) * time.Second).String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// proxyURL returns the connection string for a branch through the proxy
+// listening on listenAddr.
+func proxyURL(listenAddr, branchName string) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		host, port = "", "6432"
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return fmt.Sprintf("postgres://%s/%s", net.JoinHostPort(host, port), url.PathEscape(branchName))
+}
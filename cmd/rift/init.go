@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testConnectionTimeout bounds rift init --test-connection.
+const testConnectionTimeout = 10 * time.Second
+
+// runTestConnection checks that the database at rawURL is reachable and
+// reports its server version, without creating the _rift schema or writing
+// the config.
+func runTestConnection(ctx context.Context, rawURL string) error {
+	poolCfg, err := pgxpool.ParseConfig(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid upstream URL: %w", err)
+	}
+	poolCfg.MinConns = 0
+	poolCfg.MaxConns = 1
+	host := poolCfg.ConnConfig.Host
+	if poolCfg.ConnConfig.Port != 0 {
+		host = net.JoinHostPort(host, strconv.Itoa(int(poolCfg.ConnConfig.Port)))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, testConnectionTimeout)
+	defer cancel()
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", host, err)
+	}
+	defer pool.Close()
+
+	if err := pool.Ping(ctx); err != nil {
+		return fmt.Errorf("connecting to %s: %w", host, err)
+	}
+	var one int
+	if err := pool.QueryRow(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("running SELECT 1: %w", err)
+	}
+	var version string
+	if err := pool.QueryRow(ctx, "SHOW server_version").Scan(&version); err != nil {
+		return fmt.Errorf("reading server version: %w", err)
+	}
+
+	out.Success(fmt.Sprintf("Connected to PostgreSQL %s at %s", version, host))
+	return nil
+}
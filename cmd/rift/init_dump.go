@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/riftdata/rift/internal/config"
+)
+
+const (
+	dumpDatabase      = "rift"
+	dumpDockerImage   = "postgres:16"
+	dumpStartTimeout  = 60 * time.Second
+	dumpContainerUser = "postgres"
+)
+
+// initFromDump starts a local PostgreSQL instance, restores dumpPath into it,
+// and returns a connection URL for the restored database. It uses pg_ctl and
+// initdb when available and falls back to a Docker container otherwise.
+//
+// The returned cleanup function stops the instance and removes its data. The
+// instance is meant to become rift's permanent upstream, so callers outside of
+// tests should not run it.
+func initFromDump(ctx context.Context, dumpPath string) (connURL string, cleanup func(), err error) {
+	info, err := os.Stat(dumpPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading dump: %w", err)
+	}
+
+	port, err := freeLocalPort()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if _, err := findExecutable("pg_ctl"); err == nil {
+		if _, err := findExecutable("initdb"); err == nil {
+			return initFromDumpLocal(ctx, dumpPath, info.IsDir(), port)
+		}
+	}
+	if _, err := findExecutable("docker"); err == nil {
+		return initFromDumpDocker(ctx, dumpPath, info.IsDir(), port)
+	}
+
+	return "", nil, fmt.Errorf("restoring a dump requires either pg_ctl and initdb or docker in PATH")
+}
+
+// initFromDumpLocal creates a new cluster under the rift data directory with
+// initdb and starts it with pg_ctl.
+func initFromDumpLocal(ctx context.Context, dumpPath string, isDir bool, port int) (string, func(), error) {
+	baseDir := dataDir
+	if baseDir == "" {
+		baseDir = config.DefaultConfig().Storage.DataDir
+	}
+	pgData := filepath.Join(baseDir, "pgdata")
+
+	if _, err := os.Stat(pgData); err == nil {
+		return "", nil, fmt.Errorf("%s already exists; remove it or choose another --data-dir", pgData)
+	}
+	if err := os.MkdirAll(baseDir, 0o750); err != nil {
+		return "", nil, fmt.Errorf("creating data directory: %w", err)
+	}
+
+	if err := runCommand(ctx, "initdb", "-D", pgData, "-U", dumpContainerUser, "--auth=trust"); err != nil {
+		return "", nil, fmt.Errorf("initializing cluster: %w", err)
+	}
+
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1", port, pgData)
+	logFile := filepath.Join(pgData, "postgres.log")
+	if err := runCommand(ctx, "pg_ctl", "-D", pgData, "-o", opts, "-l", logFile, "-w", "start"); err != nil {
+		_ = os.RemoveAll(pgData)
+		return "", nil, fmt.Errorf("starting cluster: %w (see %s)", err, logFile)
+	}
+
+	cleanup := func() {
+		_ = runCommand(context.Background(), "pg_ctl", "-D", pgData, "-m", "fast", "-w", "stop")
+		_ = os.RemoveAll(pgData)
+	}
+
+	connURL := fmt.Sprintf("postgres://%s@127.0.0.1:%d/%s?sslmode=disable", dumpContainerUser, port, dumpDatabase)
+	adminURL := fmt.Sprintf("postgres://%s@127.0.0.1:%d/postgres?sslmode=disable", dumpContainerUser, port)
+
+	if err := runCommand(ctx, "psql", adminURL, "-v", "ON_ERROR_STOP=1", "-c", "CREATE DATABASE "+dumpDatabase); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("creating database: %w", err)
+	}
+
+	if err := restoreDump(dumpPath, isDir, func(args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, args[0], append(args[1:], "--dbname", connURL)...) // #nosec G204 -- fixed client binary, arguments built by rift
+	}); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return connURL, cleanup, nil
+}
+
+// initFromDumpDocker runs the official postgres image with the port published
+// on localhost and restores the dump through docker exec.
+func initFromDumpDocker(ctx context.Context, dumpPath string, isDir bool, port int) (string, func(), error) {
+	if isDir {
+		return "", nil, fmt.Errorf("directory-format dumps are not supported with docker; use a plain or custom-format dump")
+	}
+
+	name := fmt.Sprintf("rift-dump-%d", time.Now().Unix())
+	password := "rift"
+
+	if err := runCommand(ctx, "docker", "run", "-d",
+		"--name", name,
+		"-e", "POSTGRES_PASSWORD="+password,
+		"-e", "POSTGRES_DB="+dumpDatabase,
+		"-p", fmt.Sprintf("127.0.0.1:%d:5432", port),
+		dumpDockerImage,
+	); err != nil {
+		return "", nil, fmt.Errorf("starting container: %w", err)
+	}
+
+	cleanup := func() {
+		_ = runCommand(context.Background(), "docker", "rm", "-f", name)
+	}
+
+	if err := waitForDocker(ctx, name); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	if err := restoreDump(dumpPath, false, func(args ...string) *exec.Cmd {
+		dockerArgs := append([]string{"exec", "-i", name}, args...)
+		dockerArgs = append(dockerArgs, "--username", dumpContainerUser, "--dbname", dumpDatabase)
+		return exec.CommandContext(ctx, "docker", dockerArgs...) // #nosec G204 -- container name and arguments built by rift
+	}); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	connURL := fmt.Sprintf("postgres://%s:%s@127.0.0.1:%d/%s?sslmode=disable", dumpContainerUser, password, port, dumpDatabase)
+	return connURL, cleanup, nil
+}
+
+// waitForDocker polls pg_isready inside the container until the server
+// accepts connections.
+func waitForDocker(ctx context.Context, name string) error {
+	deadline := time.Now().Add(dumpStartTimeout)
+	for time.Now().Before(deadline) {
+		if err := runCommand(ctx, "docker", "exec", name, "pg_isready", "-U", dumpContainerUser, "-d", dumpDatabase); err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	return fmt.Errorf("postgres container %s did not become ready within %s", name, dumpStartTimeout)
+}
+
+// restoreDump loads the dump with psql for plain SQL dumps and pg_restore for
+// custom and directory formats. command builds the client invocation, adding
+// connection arguments.
+func restoreDump(dumpPath string, isDir bool, command func(args ...string) *exec.Cmd) error {
+	if isDir {
+		cmd := command("pg_restore", "--no-owner", dumpPath)
+		return runPrepared(cmd, "pg_restore")
+	}
+
+	custom, err := isCustomDump(dumpPath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(dumpPath) // #nosec G304 -- path supplied by the user on the command line
+	if err != nil {
+		return fmt.Errorf("opening dump: %w", err)
+	}
+	defer f.Close()
+
+	var cmd *exec.Cmd
+	var tool string
+	if custom {
+		tool = "pg_restore"
+		cmd = command("pg_restore", "--no-owner")
+	} else {
+		tool = "psql"
+		cmd = command("psql", "-v", "ON_ERROR_STOP=1", "--quiet")
+	}
+	cmd.Stdin = f
+
+	return runPrepared(cmd, tool)
+}
+
+// isCustomDump reports whether the file is a pg_dump custom-format archive.
+func isCustomDump(path string) (bool, error) {
+	f, err := os.Open(path) // #nosec G304 -- path supplied by the user on the command line
+	if err != nil {
+		return false, fmt.Errorf("opening dump: %w", err)
+	}
+	defer f.Close()
+
+	header := make([]byte, 5)
+	n, _ := f.Read(header)
+	return bytes.Equal(header[:n], []byte("PGDMP")), nil
+}
+
+// freeLocalPort asks the kernel for an unused port on 127.0.0.1.
+func freeLocalPort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("finding free port: %w", err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// runCommand runs an external tool.
+func runCommand(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...) // #nosec G204 -- tool names are constants, arguments built by rift
+	return runPrepared(cmd, name)
+}
+
+// runPrepared runs cmd and includes its output in the error.
+func runPrepared(cmd *exec.Cmd, name string) error {
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		msg := bytes.TrimSpace(output)
+		if len(msg) > 0 {
+			return fmt.Errorf("%s: %w: %s", name, err, msg)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
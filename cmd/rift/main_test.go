@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/riftdata/rift/internal/storage"
+	"github.com/riftdata/rift/internal/storage/mock"
+)
+
+func TestCheckOrphanedSchemas(t *testing.T) {
+	ctx := context.Background()
+	store := mock.New()
+	for _, name := range []string{"feature", "old"} {
+		if err := store.CreateBranch(ctx, &storage.Branch{Name: name, Parent: "main", Status: "active"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.CreateBranchSchema(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Soft-deleted branches keep their overlay until they are purged
+	if err := store.SetBranchDeleted(ctx, "old", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := checkOrphanedSchemas(ctx, store); c.Status != checkPass {
+		t.Errorf("with a soft-deleted branch got %s: %s", c.Status, c.Detail)
+	}
+
+	if err := store.CreateBranchSchema(ctx, "gone"); err != nil {
+		t.Fatal(err)
+	}
+	c := checkOrphanedSchemas(ctx, store)
+	orphan := storage.BranchSchemaName("gone")
+	if c.Status != checkWarn || !strings.Contains(c.Detail, orphan) {
+		t.Errorf("with an orphaned overlay got %s: %s, want a warning naming %s", c.Status, c.Detail, orphan)
+	}
+	if strings.Contains(c.Fix, storage.BranchSchemaName("old")) {
+		t.Errorf("fix drops a soft-deleted branch's overlay:\n%s", c.Fix)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+
+	"github.com/riftdata/rift/internal/storage"
+	"github.com/riftdata/rift/internal/ui"
+)
+
+// migrationRow is one row of 'rift migrate --list' output.
+type migrationRow struct {
+	File      string     `json:"file" yaml:"file"`
+	Hash      string     `json:"hash" yaml:"hash"`
+	AppliedAt time.Time  `json:"applied_at" yaml:"applied_at"`
+	MergedAt  *time.Time `json:"merged_at,omitempty" yaml:"merged_at,omitempty"`
+}
+
+func runMigrate(cmd *cobra.Command, args []string) error {
+	if cfg == nil {
+		return fmt.Errorf("rift not initialized. Run 'rift init' first")
+	}
+
+	ctx := cmd.Context()
+	branchName := args[0]
+
+	store, err := storage.New(ctx, cfg.Upstream.URL)
+	if err != nil {
+		return fmt.Errorf("connect to upstream: %w", err)
+	}
+	defer store.Close()
+
+	if _, err := store.GetBranch(ctx, branchName); err != nil {
+		return err
+	}
+
+	if migrateList {
+		return listMigrations(ctx, store, branchName)
+	}
+
+	// #nosec G304 -- the migration file is named by the user on the command line
+	data, err := os.ReadFile(migrateFile)
+	if err != nil {
+		return fmt.Errorf("read migration: %w", err)
+	}
+	sql := string(data)
+	if strings.TrimSpace(sql) == "" {
+		return fmt.Errorf("migration %s is empty", migrateFile)
+	}
+
+	m := &storage.AppliedMigration{
+		BranchName: branchName,
+		FileHash:   migrationHash(data),
+		FileName:   filepath.Base(migrateFile),
+		SQL:        sql,
+	}
+
+	applied, err := store.ListMigrations(ctx, branchName)
+	if err != nil {
+		return err
+	}
+	for _, a := range applied {
+		if a.FileHash == m.FileHash {
+			return fmt.Errorf("%w: %s was applied to '%s' on %s as %s", storage.ErrMigrationApplied,
+				m.FileName, branchName, a.AppliedAt.Format("2006-01-02 15:04"), a.FileName)
+		}
+	}
+
+	proxyURL, err := proxyConnURL(cfg.Upstream.URL, cfg.Proxy.ListenAddr, branchName)
+	if err != nil {
+		return err
+	}
+
+	spinner := ui.NewSimpleSpinner(fmt.Sprintf("Applying %s to '%s'", m.FileName, branchName))
+	spinner.Start()
+	if err := applyMigration(ctx, proxyURL, sql); err != nil {
+		spinner.Stop("Migration failed")
+		return err
+	}
+	if err := store.RecordMigration(ctx, m); err != nil {
+		spinner.Stop("Migration applied but not recorded")
+		return err
+	}
+	spinner.Stop(fmt.Sprintf("Applied %s to '%s'", m.FileName, branchName))
+
+	out.KeyValue("Hash", shortHash(m.FileHash))
+	return nil
+}
+
+// applyMigration runs sql on a branch through the proxy in a single transaction.
+func applyMigration(ctx context.Context, proxyURL, sql string) error {
+	connCfg, err := pgx.ParseConfig(proxyURL)
+	if err != nil {
+		return fmt.Errorf("parse proxy url: %w", err)
+	}
+	connCfg.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+
+	conn, err := pgx.ConnectConfig(ctx, connCfg)
+	if err != nil {
+		return fmt.Errorf("connect to proxy (is 'rift serve' running?): %w", err)
+	}
+	defer func() { _ = conn.Close(context.Background()) }()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin migration: %w", err)
+	}
+	defer func() { _ = tx.Rollback(context.Background()) }() // no-op after commit
+
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("run migration (rolled back): %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit migration: %w", err)
+	}
+	return nil
+}
+
+func listMigrations(ctx context.Context, store storage.Store, branchName string) error {
+	migrations, err := store.ListMigrations(ctx, branchName)
+	if err != nil {
+		return err
+	}
+
+	rows := make([]migrationRow, len(migrations))
+	for i, m := range migrations {
+		rows[i] = migrationRow{File: m.FileName, Hash: m.FileHash, AppliedAt: m.AppliedAt, MergedAt: m.MergedAt}
+	}
+
+	if output == "json" || output == "yaml" {
+		return out.Data(rows)
+	}
+
+	if len(rows) == 0 {
+		out.Info(fmt.Sprintf("No migrations applied to '%s'", branchName))
+		return nil
+	}
+
+	table := ui.NewTable(out, "FILE", "HASH", "APPLIED", "MERGED")
+	for _, r := range rows {
+		merged := ui.Muted.Render("pending")
+		if r.MergedAt != nil {
+			merged = r.MergedAt.Format("2006-01-02 15:04")
+		}
+		table.AddRow(r.File, shortHash(r.Hash), r.AppliedAt.Format("2006-01-02 15:04"), merged)
+	}
+	table.Render()
+	return nil
+}
+
+// migrationHash identifies a migration by the SHA-256 of its contents, so a
+// renamed file is still recognized as applied.
+func migrationHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/riftdata/rift/internal/config"
+	"github.com/riftdata/rift/internal/notify"
+	"github.com/riftdata/rift/internal/storage"
+	"github.com/riftdata/rift/internal/ui"
+)
+
+// staleRow is one branch of 'rift notify' output.
+type staleRow struct {
+	Name      string    `json:"name" yaml:"name"`
+	Parent    string    `json:"parent" yaml:"parent"`
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+	Age       string    `json:"age" yaml:"age"`
+	Owner     string    `json:"owner,omitempty" yaml:"owner,omitempty"`
+	URL       string    `json:"url" yaml:"url"`
+}
+
+func runNotify(cmd *cobra.Command, args []string) error {
+	if cfg == nil {
+		return fmt.Errorf("rift not initialized. Run 'rift init' first")
+	}
+
+	settings := cfg.Notifications
+	if notifyChannel != "" {
+		settings.Channel = notifyChannel
+	}
+	if notifyWebhook != "" {
+		settings.WebhookURL = notifyWebhook
+	}
+	if notifyOlderThan != "" {
+		d, err := parseAge("--older-than", notifyOlderThan)
+		if err != nil {
+			return err
+		}
+		settings.OlderThan = d
+	}
+	if settings.Channel == "" {
+		return fmt.Errorf("no notification channel: pass --channel or set notifications.channel")
+	}
+	notifier, err := newNotifier(settings)
+	if err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+	store, err := storage.New(ctx, cfg.Upstream.URL)
+	if err != nil {
+		return fmt.Errorf("connect to upstream: %w", err)
+	}
+	defer store.Close()
+
+	stale, err := notifier.Notify(ctx, store, time.Now())
+	if err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+
+	rows := make([]staleRow, len(stale))
+	for i, s := range stale {
+		rows[i] = staleRow{
+			Name:      s.Name,
+			Parent:    s.Parent,
+			CreatedAt: s.CreatedAt,
+			Age:       notify.FormatAge(s.Age),
+			Owner:     s.Owner,
+			URL:       s.URL,
+		}
+	}
+	if output == "json" || output == "yaml" {
+		return out.Data(rows)
+	}
+
+	if len(rows) == 0 {
+		out.Info(fmt.Sprintf("No branches older than %s; nothing sent", notify.FormatAge(notifier.OlderThan)))
+		return nil
+	}
+	table := ui.NewTable(out, "BRANCH", "PARENT", "AGE", "OWNER")
+	for _, r := range rows {
+		owner := r.Owner
+		if owner == "" {
+			owner = "-"
+		}
+		table.AddRow(r.Name, r.Parent, r.Age, owner)
+	}
+	table.Render()
+	out.Success(fmt.Sprintf("Reported %d stale branch(es) by %s", len(rows), notifier.Channel))
+	return nil
+}
+
+// newNotifier creates a Notifier from notification settings, checking it
+// has what its channel needs.
+func newNotifier(settings config.NotificationsConfig) (*notify.Notifier, error) {
+	n := notify.NewNotifier(settings.Channel, settings.OlderThan)
+	n.WebhookURL = settings.WebhookURL
+	n.SMTP = notify.SMTPConfig{
+		Host:     settings.SMTPHost,
+		Port:     settings.SMTPPort,
+		Username: settings.SMTPUsername,
+		Password: settings.SMTPPassword,
+		From:     settings.SMTPFrom,
+		To:       settings.SMTPTo,
+	}
+	n.ConnURL = func(branchName string) string {
+		return proxyURL(cfg.Proxy.ListenAddr, branchName)
+	}
+	if err := n.Check(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// parseAge parses a positive duration: a Go duration such as 36h, or whole
+// days such as 7d. flag names the option in errors.
+func parseAge(flag, s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid %s %q: expected a duration like 36h or 7d", flag, s)
+}
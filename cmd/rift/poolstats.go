@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/riftdata/rift/internal/config"
+	"github.com/riftdata/rift/internal/storage"
+	"github.com/riftdata/rift/internal/ui"
+)
+
+// poolStatsTimeout bounds the request to a running rift serve's API.
+const poolStatsTimeout = 5 * time.Second
+
+// printPoolStats shows the connection pool utilization that a running
+// rift serve reports on /api/v1/pool. Pools live in the server process, so
+// there is nothing to show without one.
+func printPoolStats(ctx context.Context) error {
+	stats, err := fetchPoolStats(ctx, cfg.API)
+	if err != nil {
+		return fmt.Errorf("pool stats come from a running 'rift serve': %w", err)
+	}
+
+	if output == "json" || output == "yaml" {
+		return out.Data(stats)
+	}
+
+	table := ui.NewTable(out, "POOL", "IN USE", "IDLE", "TOTAL", "MAX", "ACQUIRES", "WAITED", "AVG WAIT")
+	for _, p := range stats {
+		avgWait := "-"
+		if p.AcquireCount > 0 {
+			avgWait = (p.AcquireDuration / time.Duration(p.AcquireCount)).Round(time.Microsecond).String()
+		}
+		table.AddRow(p.Name,
+			fmt.Sprintf("%d", p.AcquiredConns),
+			fmt.Sprintf("%d", p.IdleConns),
+			fmt.Sprintf("%d", p.TotalConns),
+			fmt.Sprintf("%d", p.MaxConns),
+			fmt.Sprintf("%d", p.AcquireCount),
+			fmt.Sprintf("%d", p.EmptyAcquireCount),
+			avgWait)
+	}
+	table.Render()
+	return nil
+}
+
+// fetchPoolStats requests /api/v1/pool from the API listening on
+// apiCfg.ListenAddr.
+func fetchPoolStats(ctx context.Context, apiCfg config.APIConfig) ([]storage.PoolStats, error) {
+	host, port, err := net.SplitHostPort(apiCfg.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("parse api listen address %q: %w", apiCfg.ListenAddr, err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, poolStatsTimeout)
+	defer cancel()
+	url := "http://" + net.JoinHostPort(host, port) + "/api/v1/pool"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if apiCfg.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+apiCfg.AuthToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	var body struct {
+		Pools []storage.PoolStats `json:"pools"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode pool stats: %w", err)
+	}
+	return body.Pools, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/riftdata/rift/internal/cow"
+	"github.com/riftdata/rift/internal/storage"
+	"github.com/riftdata/rift/internal/ui"
+)
+
+// watchInterval is how often 'rift watch' polls the branch's overlay tables.
+const watchInterval = 500 * time.Millisecond
+
+// watchEvent is one line of 'rift watch -o json' output.
+type watchEvent struct {
+	Time      time.Time `json:"time" yaml:"time"`
+	Operation string    `json:"operation" yaml:"operation"`
+	Schema    string    `json:"schema" yaml:"schema"`
+	Table     string    `json:"table" yaml:"table"`
+	Key       []string  `json:"key" yaml:"key"`
+}
+
+func runWatch(cmd *cobra.Command, args []string) error {
+	if cfg == nil {
+		return fmt.Errorf("rift not initialized. Run 'rift init' first")
+	}
+
+	ctx := cmd.Context()
+	branchName := args[0]
+
+	store, engine, err := connectAndInit(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if output != "json" && output != "yaml" {
+		out.Info(fmt.Sprintf("Watching '%s' for changes (Ctrl+C to stop)", branchName))
+	}
+
+	return engine.WatchBranch(ctx, branchName, watchTable, watchInterval, func(ev cow.OverlayEvent) {
+		if output == "json" || output == "yaml" {
+			_ = out.Data(watchEvent{Time: ev.Time, Operation: ev.Operation, Schema: ev.SourceSchema, Table: ev.Table, Key: ev.Key})
+			return
+		}
+		out.Print(formatWatchEvent(ev))
+	})
+}
+
+// formatWatchEvent renders an event as "+ users(id=42)", "~ users(id=42)" or
+// "- users(id=42)", colored like success, warning and error messages.
+func formatWatchEvent(ev cow.OverlayEvent) string {
+	symbol, style := "+", ui.Success
+	switch ev.Operation {
+	case cow.ChangeUpdate:
+		symbol, style = "~", ui.Warning
+	case cow.ChangeDelete:
+		symbol, style = "-", ui.Error
+	}
+
+	table := ev.Table
+	if ev.SourceSchema != "public" {
+		table = ev.SourceSchema + "." + ev.Table
+	}
+	line := fmt.Sprintf("%s %s(%s)", symbol, table, strings.Join(ev.Key, ", "))
+	stamp := ev.Time.Local().Format("15:04:05")
+	if noColor {
+		return stamp + " " + line
+	}
+	return ui.Muted.Render(stamp) + " " + style.Render(line)
+}
+
+// sizeAlert is 'rift branches watch-size -o json' output when the alert fires.
+type sizeAlert struct {
+	Time       time.Time `json:"time" yaml:"time"`
+	Branch     string    `json:"branch" yaml:"branch"`
+	DeltaSize  int64     `json:"delta_size" yaml:"delta_size"`
+	AlertBytes int64     `json:"alert_bytes" yaml:"alert_bytes"`
+}
+
+func runWatchSize(cmd *cobra.Command, args []string) error {
+	if cfg == nil {
+		return fmt.Errorf("rift not initialized. Run 'rift init' first")
+	}
+
+	branchName := args[0]
+	if branchName == "main" {
+		return fmt.Errorf("main has no overlay to watch")
+	}
+	alertBytes, err := parseBytes(watchAlertBytes)
+	if err != nil {
+		return fmt.Errorf("invalid --alert-bytes: %w", err)
+	}
+	if alertBytes <= 0 {
+		return fmt.Errorf("--alert-bytes must be positive")
+	}
+	if watchSizeEvery <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+	if watchSizeFor < 0 {
+		return fmt.Errorf("--duration must not be negative")
+	}
+
+	store, err := storage.New(cmd.Context(), cfg.Upstream.URL)
+	if err != nil {
+		return fmt.Errorf("connect to upstream: %w", err)
+	}
+	defer store.Close()
+
+	ctx := cmd.Context()
+	if watchSizeFor > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, watchSizeFor)
+		defer cancel()
+	}
+
+	if output != "json" && output != "yaml" {
+		until := "Ctrl+C to stop"
+		if watchSizeFor > 0 {
+			until = "for " + watchSizeFor.String()
+		}
+		out.Info(fmt.Sprintf("Watching the delta size of '%s' for %s (%s)", branchName, formatBytes(alertBytes), until))
+		if !cfg.Cow.TrackDeltaSizeRealtime {
+			out.Warning("cow.track_delta_size_realtime is off, so the delta size is not updated as the branch is written to")
+		}
+	}
+
+	ticker := time.NewTicker(watchSizeEvery)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		b, err := store.GetBranch(ctx, branchName)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+		if b.DeltaSize >= alertBytes {
+			return fireSizeAlert(cmd.Context(), b, alertBytes)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+
+	if output != "json" && output != "yaml" {
+		out.Success(fmt.Sprintf("The delta size of '%s' stayed under %s", branchName, formatBytes(alertBytes)))
+	}
+	return nil
+}
+
+// fireSizeAlert reports that a branch's delta reached alertBytes, runs
+// --exec, and returns the error that makes watch-size exit 1.
+func fireSizeAlert(ctx context.Context, b *storage.Branch, alertBytes int64) error {
+	if output == "json" || output == "yaml" {
+		_ = out.Data(sizeAlert{Time: time.Now(), Branch: b.Name, DeltaSize: b.DeltaSize, AlertBytes: alertBytes})
+	} else {
+		out.Warning(fmt.Sprintf("The delta size of '%s' is %s, past %s", b.Name, formatBytes(b.DeltaSize), formatBytes(alertBytes)))
+	}
+
+	if watchSizeExec != "" {
+		hook := exec.CommandContext(ctx, "sh", "-c", watchSizeExec) // #nosec G204 -- command given by the user
+		hook.Env = append(os.Environ(),
+			"RIFT_BRANCH="+b.Name,
+			"RIFT_DELTA_SIZE="+strconv.FormatInt(b.DeltaSize, 10),
+			"RIFT_ALERT_BYTES="+strconv.FormatInt(alertBytes, 10))
+		hook.Stdout, hook.Stderr = os.Stdout, os.Stderr
+		if err := hook.Run(); err != nil {
+			out.Warning(fmt.Sprintf("--exec command failed: %v", err))
+		}
+	}
+	return fmt.Errorf("branch '%s' reached the %s size alert", b.Name, formatBytes(alertBytes))
+}
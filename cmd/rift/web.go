@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/riftdata/rift/internal/api"
+	"github.com/riftdata/rift/internal/branch"
+	"github.com/riftdata/rift/internal/browser"
+	"github.com/riftdata/rift/internal/web"
+)
+
+// webShutdownTimeout bounds how long 'rift web' waits for open requests,
+// such as a running merge, when interrupted.
+const webShutdownTimeout = 10 * time.Second
+
+func runWeb(cmd *cobra.Command, args []string) error {
+	if cfg == nil {
+		return fmt.Errorf("rift not initialized. Run 'rift init' first")
+	}
+	if webPort < 0 || webPort > 65535 {
+		return fmt.Errorf("invalid --port %d", webPort)
+	}
+
+	ctx := cmd.Context()
+	store, engine, err := connectAndInit(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	srv := api.New(&api.Config{
+		ListenAddr: net.JoinHostPort("localhost", strconv.Itoa(webPort)),
+		AuthToken:  cfg.API.AuthToken,
+		UI:         web.Handler(),
+	}, store, engine, branch.NewStorageBackedManager(store))
+	if err := srv.Start(); err != nil {
+		return err
+	}
+
+	dashboard := "http://" + srv.Addr() + "/"
+	out.Success("Dashboard running at " + dashboard)
+	if webOpen {
+		// The token travels in the fragment, which browsers don't send
+		link := dashboard
+		if cfg.API.AuthToken != "" {
+			link += "#token=" + url.QueryEscape(cfg.API.AuthToken)
+		}
+		if err := browser.OpenURL(link); err != nil {
+			out.Warning(fmt.Sprintf("Could not open a browser: %v", err))
+		}
+	}
+	out.Info("Press Ctrl+C to stop")
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), webShutdownTimeout)
+	defer cancel()
+	return srv.Stop(shutdownCtx)
+}
@@ -2,11 +2,16 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/riftdata/rift/internal/branch"
@@ -21,11 +26,57 @@ type Server struct {
 	manager *branch.StorageBackedManager
 	server  *http.Server
 	addr    string
+	logger  *slog.Logger
+
+	proxyAddr   string
+	readOnly    bool
+	poolStats   func() []storage.PoolStats
+	maintenance MaintenanceSwitch
+
+	// stopping is closed by Stop to end event streams, which Shutdown
+	// would otherwise wait for.
+	stopping chan struct{}
+	stopOnce sync.Once
 }
 
 // Config holds API server configuration.
 type Config struct {
 	ListenAddr string
+
+	// AuthToken, if set, must be presented as a bearer token on every
+	// endpoint except the health endpoints (/health, /health/deep, /ready).
+	AuthToken string
+
+	// CORSOrigins enables CORS for these origins ("*" for any). Empty
+	// disables CORS.
+	CORSOrigins []string
+
+	// ProxyAddr is the proxy's listen address, which /ready checks is
+	// accepting connections. Empty skips the check.
+	ProxyAddr string
+
+	// ReadOnly reports, in /health, that the proxy rejects writes.
+	ReadOnly bool
+
+	// PoolStats, if set, reports upstream connection pool utilization on
+	// /api/v1/pool.
+	PoolStats func() []storage.PoolStats
+
+	// Maintenance, if set, is the proxy's maintenance mode, toggled with
+	// POST /api/v1/maintenance. /health returns 503 while it is on.
+	Maintenance MaintenanceSwitch
+
+	// UI, if set, serves the web dashboard on every path outside /api/.
+	UI http.Handler
+
+	// Logger receives the server's logs; nil uses slog.Default().
+	Logger *slog.Logger
+}
+
+// MaintenanceSwitch turns a proxy's maintenance mode on and off.
+type MaintenanceSwitch interface {
+	Maintenance() bool
+	SetMaintenance(enabled bool)
 }
 
 // New creates a new API server.
@@ -35,6 +86,16 @@ func New(cfg *Config, store storage.Store, engine *cow.Engine, manager *branch.S
 		engine:  engine,
 		manager: manager,
 		addr:    cfg.ListenAddr,
+		logger:  cfg.Logger,
+
+		proxyAddr:   cfg.ProxyAddr,
+		readOnly:    cfg.ReadOnly,
+		poolStats:   cfg.PoolStats,
+		maintenance: cfg.Maintenance,
+		stopping:    make(chan struct{}),
+	}
+	if s.logger == nil {
+		s.logger = slog.Default()
 	}
 
 	mux := http.NewServeMux()
@@ -42,17 +103,39 @@ func New(cfg *Config, store storage.Store, engine *cow.Engine, manager *branch.S
 	// Health endpoints
 	mux.HandleFunc("GET /health", s.handleHealth)
 	mux.HandleFunc("GET /ready", s.handleReady)
+	mux.HandleFunc("GET /health/deep", s.handleDeepHealth)
 
 	// Branch API
+	mux.HandleFunc("GET /api/v1/pool", s.handlePoolStats)
+	mux.HandleFunc("GET /api/v1/maintenance", s.handleGetMaintenance)
+	mux.HandleFunc("POST /api/v1/maintenance", s.handleSetMaintenance)
 	mux.HandleFunc("GET /api/v1/branches", s.handleListBranches)
 	mux.HandleFunc("POST /api/v1/branches", s.handleCreateBranch)
 	mux.HandleFunc("GET /api/v1/branches/{name}", s.handleGetBranch)
+	mux.HandleFunc("PATCH /api/v1/branches/{name}", s.handleUpdateBranch)
 	mux.HandleFunc("DELETE /api/v1/branches/{name}", s.handleDeleteBranch)
+	mux.HandleFunc("DELETE /api/v1/branches/{name}/tables/{schema}/{table}", s.handleRevertTable)
 	mux.HandleFunc("GET /api/v1/branches/{name}/status", s.handleBranchStatus)
 	mux.HandleFunc("GET /api/v1/branches/{name}/diff", s.handleBranchDiff)
+	mux.HandleFunc("GET /api/v1/branches/{name}/merge-sql", s.handleMergeSQL)
+	mux.HandleFunc("POST /api/v1/branches/{name}/merge", s.handleMerge)
+	mux.HandleFunc("GET /api/v1/branches/{name}/conflicts", s.handleMergeConflicts)
+	mux.HandleFunc("GET /api/v1/branches/{name}/stats", s.handleBranchStats)
+	mux.HandleFunc("GET /api/v1/branches/{name}/audit", s.handleBranchAudit)
+	mux.HandleFunc("GET /api/v1/events", s.handleEvents)
+
+	// Dashboard
+	if cfg.UI != nil {
+		mux.Handle("GET /", cfg.UI)
+	}
+
+	handler := authMiddleware(cfg.AuthToken)(actorMiddleware(mux))
+	if len(cfg.CORSOrigins) > 0 {
+		handler = corsMiddleware(cfg.CORSOrigins)(handler)
+	}
 
 	s.server = &http.Server{
-		Handler:           mux,
+		Handler:           handler,
 		ReadHeaderTimeout: 10 * time.Second,
 		ReadTimeout:       30 * time.Second,
 		WriteTimeout:      30 * time.Second,
@@ -72,7 +155,7 @@ func (s *Server) Start() error {
 
 	go func() {
 		if err := s.server.Serve(ln); err != nil && err != http.ErrServerClosed {
-			fmt.Printf("api server error: %v\n", err)
+			s.logger.Error("api server failed", "error", err)
 		}
 	}()
 
@@ -81,6 +164,7 @@ func (s *Server) Start() error {
 
 // Stop gracefully shuts down the HTTP server.
 func (s *Server) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stopping) })
 	return s.server.Shutdown(ctx)
 }
 
@@ -92,8 +176,16 @@ func (s *Server) Addr() string {
 // --- Health endpoints ---
 
 func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{
-		"status": "ok",
+	if s.inMaintenance() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"status":    "maintenance",
+			"read_only": s.readOnly,
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":    "ok",
+		"read_only": s.readOnly,
 	})
 }
 
@@ -101,7 +193,9 @@ func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	// Check database connectivity
-	if err := s.store.Pool().Ping(ctx); err != nil {
+	pingCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	if err := s.store.Pool().Ping(pingCtx); err != nil {
 		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
 			"status": "not ready",
 			"error":  "database connection failed",
@@ -109,11 +203,71 @@ func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check that the proxy accepts connections
+	dialCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	if err := s.checkProxy(dialCtx); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"status": "not ready",
+			"error":  "proxy is not accepting connections",
+		})
+		return
+	}
+
 	writeJSON(w, http.StatusOK, map[string]string{
 		"status": "ready",
 	})
 }
 
+// handlePoolStats reports upstream connection pool utilization: the shared
+// pool's, then each branch pool's.
+func (s *Server) handlePoolStats(w http.ResponseWriter, _ *http.Request) {
+	stats := []storage.PoolStats{storage.StatsOf("shared", s.store.Pool())}
+	if s.poolStats != nil {
+		stats = s.poolStats()
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"pools": stats})
+}
+
+// inMaintenance reports whether the proxy is in maintenance mode.
+func (s *Server) inMaintenance() bool {
+	return s.maintenance != nil && s.maintenance.Maintenance()
+}
+
+type maintenanceRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+type maintenanceResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (s *Server) handleGetMaintenance(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, maintenanceResponse{Enabled: s.inMaintenance()})
+}
+
+// handleSetMaintenance turns the proxy's maintenance mode on or off. Open
+// sessions are not affected; only new connections are rejected.
+func (s *Server) handleSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	if s.maintenance == nil {
+		writeError(w, http.StatusNotImplemented, "maintenance mode requires the proxy ('rift serve')")
+		return
+	}
+
+	var req maintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	if req.Enabled == nil {
+		writeError(w, http.StatusBadRequest, "enabled is required")
+		return
+	}
+
+	s.maintenance.SetMaintenance(*req.Enabled)
+	writeJSON(w, http.StatusOK, maintenanceResponse{Enabled: *req.Enabled})
+}
+
 // --- Branch API ---
 
 type branchResponse struct {
@@ -123,34 +277,76 @@ type branchResponse struct {
 	CreatedAt   string `json:"created_at"`
 	UpdatedAt   string `json:"updated_at"`
 	Pinned      bool   `json:"pinned"`
+	Protected   bool   `json:"protected"`
+	Frozen      bool   `json:"frozen"`
 	DeltaSize   int64  `json:"delta_size"`
 	RowsChanged int64  `json:"rows_changed"`
 	TTLSeconds  *int   `json:"ttl_seconds,omitempty"`
 	Status      string `json:"status"`
+
+	AllowedHosts  []string `json:"allowed_hosts,omitempty"`
+	MaxDeltaBytes *int64   `json:"max_delta_bytes,omitempty"`
+
+	// LastQueryAt is when the branch was last queried, to the minute.
+	LastQueryAt *string `json:"last_query_at,omitempty"`
 }
 
 func toBranchResponse(b *storage.Branch) branchResponse {
-	return branchResponse{
+	resp := branchResponse{
 		Name:        b.Name,
 		Parent:      b.Parent,
 		Database:    b.Database,
 		CreatedAt:   b.CreatedAt.Format(time.RFC3339),
 		UpdatedAt:   b.UpdatedAt.Format(time.RFC3339),
 		Pinned:      b.Pinned,
+		Protected:   b.Protected,
+		Frozen:      b.Frozen,
 		DeltaSize:   b.DeltaSize,
 		RowsChanged: b.RowsChanged,
 		TTLSeconds:  b.TTLSeconds,
 		Status:      b.Status,
+
+		AllowedHosts:  b.AllowedHosts,
+		MaxDeltaBytes: b.MaxDeltaBytes,
+	}
+	if b.LastQueryAt != nil {
+		t := b.LastQueryAt.Format(time.RFC3339)
+		resp.LastQueryAt = &t
 	}
+	return resp
 }
 
 func (s *Server) handleListBranches(w http.ResponseWriter, r *http.Request) {
-	branches, err := s.store.ListBranches(r.Context())
+	// Filters use the same keys as 'rift list --filter', as query parameters.
+	var filter storage.BranchFilter
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "prometheus" {
+		writeError(w, http.StatusBadRequest, "invalid format %q: must be json or prometheus", format)
+		return
+	}
+	for _, key := range storage.BranchFilterKeys {
+		if !query.Has(key) {
+			continue
+		}
+		if err := filter.Set(key, query.Get(key)); err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+	}
+
+	branches, err := s.store.ListBranchesFilter(r.Context(), filter)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "list branches: %v", err)
 		return
 	}
 
+	if format == "prometheus" {
+		w.Header().Set("Content-Type", branch.PrometheusContentType)
+		_ = branch.WritePrometheus(w, branches, time.Now())
+		return
+	}
+
 	resp := make([]branchResponse, len(branches))
 	for i, b := range branches {
 		resp[i] = toBranchResponse(b)
@@ -220,6 +416,51 @@ func (s *Server) handleGetBranch(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, toBranchResponse(b))
 }
 
+// updateBranchRequest holds the settings changed by PATCH. Absent fields are
+// left alone; "max_delta_bytes": null removes the limit.
+type updateBranchRequest struct {
+	MaxDeltaBytes json.RawMessage `json:"max_delta_bytes"`
+}
+
+func (s *Server) handleUpdateBranch(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ctx := r.Context()
+
+	var req updateBranchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+
+	if req.MaxDeltaBytes != nil {
+		if name == "main" {
+			writeError(w, http.StatusBadRequest, "cannot limit the size of main branch")
+			return
+		}
+		var maxBytes *int64
+		if err := json.Unmarshal(req.MaxDeltaBytes, &maxBytes); err != nil || (maxBytes != nil && *maxBytes < 1) {
+			writeError(w, http.StatusBadRequest, "invalid max_delta_bytes %s (expected a positive integer or null)", req.MaxDeltaBytes)
+			return
+		}
+		if err := s.store.SetBranchMaxDeltaBytes(ctx, name, maxBytes); err != nil {
+			if errors.Is(err, storage.ErrBranchNotFound) {
+				writeError(w, http.StatusNotFound, "branch %q not found", name)
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "update branch: %v", err)
+			return
+		}
+	}
+
+	b, err := s.store.GetBranch(ctx, name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "branch %q not found", name)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toBranchResponse(b))
+}
+
 func (s *Server) handleDeleteBranch(w http.ResponseWriter, r *http.Request) {
 	name := r.PathValue("name")
 
@@ -228,7 +469,14 @@ func (s *Server) handleDeleteBranch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.engine.DeleteBranch(r.Context(), name); err != nil {
+	// Branches are soft-deleted unless ?purge=true
+	purge := r.URL.Query().Get("purge") == "true"
+	deleteBranch, status := s.engine.DeleteBranch, "deleted"
+	if purge {
+		deleteBranch, status = s.engine.PurgeBranch, "purged"
+	}
+
+	if err := deleteBranch(r.Context(), name); err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			writeError(w, http.StatusNotFound, "branch %q not found", name)
 			return
@@ -238,8 +486,39 @@ func (s *Server) handleDeleteBranch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	writeJSON(w, http.StatusOK, map[string]string{
-		"status": "deleted",
+		"status": status,
+		"branch": name,
+	})
+}
+
+// handleRevertTable discards a branch's changes to one table.
+func (s *Server) handleRevertTable(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	schema := r.PathValue("schema")
+	table := r.PathValue("table")
+
+	if name == "main" {
+		writeError(w, http.StatusBadRequest, "main has no overlay to revert")
+		return
+	}
+
+	if err := s.engine.RevertTable(r.Context(), name, schema, table); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrBranchNotFound), errors.Is(err, cow.ErrTableNotFound):
+			writeError(w, http.StatusNotFound, "%v", err)
+		case errors.Is(err, cow.ErrBranchProtected), errors.Is(err, cow.ErrBranchFrozen):
+			writeError(w, http.StatusConflict, "%v", err)
+		default:
+			writeError(w, http.StatusInternalServerError, "revert table: %v", err)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status": "reverted",
 		"branch": name,
+		"schema": schema,
+		"table":  table,
 	})
 }
 
@@ -266,7 +545,7 @@ func (s *Server) handleBranchStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tables, err := s.store.ListTrackedTables(ctx, name)
+	tables, err := s.store.ListTrackedTables(ctx, name, "")
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "list tables: %v", err)
 		return
@@ -307,7 +586,7 @@ type tableDiffInfo struct {
 func (s *Server) handleBranchDiff(w http.ResponseWriter, r *http.Request) {
 	name := r.PathValue("name")
 
-	diff, err := s.engine.Diff(r.Context(), name)
+	diff, err := s.engine.Diff(r.Context(), name, r.URL.Query().Get("schema"))
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			writeError(w, http.StatusNotFound, "branch %q not found", name)
@@ -336,8 +615,379 @@ func (s *Server) handleBranchDiff(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+type mergeSQLResponse struct {
+	Branch         string   `json:"branch"`
+	Tables         []string `json:"tables"`
+	Migrations     []string `json:"migrations,omitempty"`
+	SQL            string   `json:"sql"`
+	StatementCount int      `json:"statement_count"`
+}
+
+func (s *Server) handleMergeSQL(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ctx := r.Context()
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "text" {
+		writeError(w, http.StatusBadRequest, "invalid format %q (expected json or text)", format)
+		return
+	}
+
+	if _, err := s.store.GetBranch(ctx, name); err != nil {
+		writeError(w, http.StatusNotFound, "branch %q not found", name)
+		return
+	}
+
+	merges, err := s.engine.GenerateMerge(ctx, name, tablesParam(r), cow.MergeAll)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, cow.ErrTableNotFound) {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, "generate merge: %v", err)
+		return
+	}
+
+	migrations, err := s.engine.PendingMigrations(ctx, name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "list migrations: %v", err)
+		return
+	}
+
+	resp := mergeSQLResponse{
+		Branch: name,
+		Tables: make([]string, 0, len(merges)),
+	}
+	for _, m := range merges {
+		resp.Tables = append(resp.Tables, m.TableName)
+	}
+	for _, m := range migrations {
+		resp.Migrations = append(resp.Migrations, m.FileName)
+	}
+	if len(merges) > 0 || len(migrations) > 0 {
+		stmts := cow.MigrationStatements(migrations)
+		resp.SQL = cow.FormatMergePlan(merges, stmts...)
+		resp.StatementCount = len(cow.MergePlanStatements(merges, stmts...))
+	}
+
+	var body []byte
+	contentType := "application/json"
+	if format == "text" {
+		contentType = "text/plain; charset=utf-8"
+		if resp.SQL != "" {
+			body = []byte(resp.SQL + "\n")
+		}
+	} else {
+		if body, err = json.Marshal(resp); err != nil {
+			writeError(w, http.StatusInternalServerError, "encode merge SQL: %v", err)
+			return
+		}
+		body = append(body, '\n')
+	}
+
+	// The merge SQL depends on the tables the branch tracks, its pending
+	// migrations, ?tables and ?format, so the ETag is a hash of the body
+	// itself. Clients revalidating with If-None-Match still save the
+	// transfer.
+	sum := sha256.Sum256(body)
+	etag := fmt.Sprintf(`"%x"`, sum[:16])
+	w.Header().Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+type statsResponse struct {
+	Branch string           `json:"branch"`
+	Tables []tableStatsInfo `json:"tables"`
+	Total  tableStatsInfo   `json:"total"`
+}
+
+type tableStatsInfo struct {
+	Schema           string  `json:"schema,omitempty"`
+	Table            string  `json:"table,omitempty"`
+	OverlayRows      int64   `json:"overlay_rows"`
+	Tombstones       int64   `json:"tombstones"`
+	TombstoneRatio   float64 `json:"tombstone_ratio"`
+	SourceSize       int64   `json:"source_size"`
+	OverlaySize      int64   `json:"overlay_size"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	MergeSQLSize     int64   `json:"merge_sql_size"`
+}
+
+func toTableStatsInfo(t *cow.TableStats) tableStatsInfo {
+	return tableStatsInfo{
+		Schema:           t.SourceSchema,
+		Table:            t.TableName,
+		OverlayRows:      t.OverlayRows,
+		Tombstones:       t.Tombstones,
+		TombstoneRatio:   t.TombstoneRatio(),
+		SourceSize:       t.SourceSize,
+		OverlaySize:      t.OverlaySize,
+		CompressionRatio: t.CompressionRatio(),
+		MergeSQLSize:     t.MergeSQLSize,
+	}
+}
+
+func (s *Server) handleBranchStats(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	stats, err := s.engine.Stats(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, storage.ErrBranchNotFound) {
+			writeError(w, http.StatusNotFound, "branch %q not found", name)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "compute stats: %v", err)
+		return
+	}
+
+	resp := statsResponse{
+		Branch: name,
+		Tables: make([]tableStatsInfo, len(stats.Tables)),
+	}
+	for i := range stats.Tables {
+		resp.Tables[i] = toTableStatsInfo(&stats.Tables[i])
+	}
+	total := stats.Total()
+	resp.Total = toTableStatsInfo(&total)
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type mergeRequest struct {
+	// Target is the branch to merge into. Empty means the parent.
+	Target  string `json:"target"`
+	Timeout string `json:"timeout"`
+
+	// Resolutions decide rows in conflict with the parent, as listed by
+	// GET /api/v1/branches/{name}/conflicts. Conflicts left out merge the
+	// branch's version.
+	Resolutions []conflictResolution `json:"resolutions,omitempty"`
+}
+
+// conflictResolution is a cow.ConflictResolution in a merge request.
+type conflictResolution struct {
+	Schema string                     `json:"schema"`
+	Table  string                     `json:"table"`
+	PK     map[string]json.RawMessage `json:"pk"`
+	Choice string                     `json:"choice"` // branch, parent, edit or skip
+	Values map[string]json.RawMessage `json:"values,omitempty"`
+}
+
+type mergeResponse struct {
+	Branch       string `json:"branch"`
+	Target       string `json:"target"`
+	Tables       int    `json:"tables"`
+	Statements   int    `json:"statements"`
+	RowsAffected int64  `json:"rows_affected"`
+	Migrations   int    `json:"migrations,omitempty"`
+}
+
+func (s *Server) handleMerge(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ctx := r.Context()
+
+	var req mergeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+	}
+
+	var timeout time.Duration
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, "invalid timeout %q", req.Timeout)
+			return
+		}
+		timeout = d
+	}
+
+	if len(req.Resolutions) > 0 && req.Target != "" {
+		writeError(w, http.StatusBadRequest, "conflict resolutions only apply to merges into the parent")
+		return
+	}
+	resolutions := make([]cow.ConflictResolution, len(req.Resolutions))
+	for i, res := range req.Resolutions {
+		resolutions[i] = cow.ConflictResolution{
+			SourceSchema: res.Schema,
+			Table:        res.Table,
+			PK:           res.PK,
+			Choice:       res.Choice,
+			Values:       res.Values,
+		}
+	}
+
+	if _, err := s.store.GetBranch(ctx, name); err != nil {
+		writeError(w, http.StatusNotFound, "branch %q not found", name)
+		return
+	}
+
+	var result *cow.MergeResult
+	var err error
+	only := tablesParam(r)
+	target := req.Target
+	if target == "" {
+		target = "parent"
+		result, err = s.engine.ExecuteMergeResolved(ctx, name, only, cow.MergeAll, timeout, resolutions)
+	} else {
+		result, err = s.engine.ExecuteMergeInto(ctx, name, target, only, timeout)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrBranchNotFound):
+			writeError(w, http.StatusNotFound, "%v", err)
+		case errors.Is(err, cow.ErrTableNotFound), errors.Is(err, cow.ErrBadResolution):
+			writeError(w, http.StatusBadRequest, "%v", err)
+		case errors.Is(err, cow.ErrBranchProtected), errors.Is(err, cow.ErrBranchFrozen):
+			writeError(w, http.StatusConflict, "%v", err)
+		default:
+			writeError(w, http.StatusInternalServerError, "merge: %v", err)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, mergeResponse{
+		Branch:       name,
+		Target:       target,
+		Tables:       result.Tables,
+		Statements:   result.Statements,
+		RowsAffected: result.RowsAffected,
+		Migrations:   result.Migrations,
+	})
+}
+
+type conflictResponse struct {
+	Schema          string                     `json:"schema"`
+	Table           string                     `json:"table"`
+	PK              map[string]json.RawMessage `json:"pk"`
+	Branch          map[string]json.RawMessage `json:"branch"` // null if the branch deleted the row
+	Parent          map[string]json.RawMessage `json:"parent"`
+	ParentChangedAt string                     `json:"parent_changed_at"`
+}
+
+type conflictsResponse struct {
+	Branch    string             `json:"branch"`
+	Conflicts []conflictResponse `json:"conflicts"`
+}
+
+// handleMergeConflicts lists the rows changed both on a branch and in its
+// parent since it was created. They can be resolved in the merge request.
+func (s *Server) handleMergeConflicts(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ctx := r.Context()
+
+	if _, err := s.store.GetBranch(ctx, name); err != nil {
+		writeError(w, http.StatusNotFound, "branch %q not found", name)
+		return
+	}
+
+	conflicts, err := s.engine.DetectMergeConflicts(ctx, name, tablesParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, cow.ErrTableNotFound):
+			writeError(w, http.StatusBadRequest, "%v", err)
+		case errors.Is(err, cow.ErrCommitTimestampsOff):
+			writeError(w, http.StatusConflict, "%v", err)
+		default:
+			writeError(w, http.StatusInternalServerError, "detect conflicts: %v", err)
+		}
+		return
+	}
+
+	resp := conflictsResponse{Branch: name, Conflicts: make([]conflictResponse, len(conflicts))}
+	for i, c := range conflicts {
+		resp.Conflicts[i] = conflictResponse{
+			Schema:          c.SourceSchema,
+			Table:           c.Table,
+			PK:              c.PK,
+			Branch:          c.Branch,
+			Parent:          c.Parent,
+			ParentChangedAt: c.ParentChangedAt.Format(time.RFC3339),
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type auditEntryResponse struct {
+	ID         int64          `json:"id"`
+	Branch     string         `json:"branch"`
+	Operation  string         `json:"operation"`
+	User       string         `json:"user"`
+	Details    map[string]any `json:"details"`
+	OccurredAt string         `json:"occurred_at"`
+}
+
+// defaultAuditLimit caps audit responses when no limit is given.
+const defaultAuditLimit = 50
+
+func (s *Server) handleBranchAudit(w http.ResponseWriter, r *http.Request) {
+	// History is kept after a branch is deleted, so unknown names aren't a 404.
+	q := storage.AuditQuery{BranchName: r.PathValue("name"), Limit: defaultAuditLimit}
+
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid since %q (expected RFC 3339)", v)
+			return
+		}
+		q.Since = t
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "invalid limit %q", v)
+			return
+		}
+		q.Limit = n
+	}
+
+	entries, err := s.store.ListAudit(r.Context(), q)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "list audit log: %v", err)
+		return
+	}
+
+	resp := make([]auditEntryResponse, len(entries))
+	for i, e := range entries {
+		resp[i] = toAuditEntryResponse(e)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func toAuditEntryResponse(e *storage.AuditEntry) auditEntryResponse {
+	return auditEntryResponse{
+		ID:         e.ID,
+		Branch:     e.BranchName,
+		Operation:  e.Operation,
+		User:       e.UserName,
+		Details:    e.Details,
+		OccurredAt: e.OccurredAt.Format(time.RFC3339),
+	}
+}
+
 // --- Helpers ---
 
+// tablesParam returns the comma-separated ?tables= filter of a merge
+// request, or nil for all tables.
+func tablesParam(r *http.Request) []string {
+	var tables []string
+	for _, t := range strings.Split(r.URL.Query().Get("tables"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/riftdata/rift/internal/storage/mock"
+)
+
+// maintenanceFlag is a MaintenanceSwitch standing in for the proxy.
+type maintenanceFlag struct{ enabled bool }
+
+func (m *maintenanceFlag) Maintenance() bool           { return m.enabled }
+func (m *maintenanceFlag) SetMaintenance(enabled bool) { m.enabled = enabled }
+
+// serve sends a request to s and decodes the JSON response into v, if set.
+func serve(t *testing.T, s *Server, method, path, body string, v any) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if v != nil {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: decode response: %v", method, path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	flag := &maintenanceFlag{}
+	s := New(&Config{Maintenance: flag}, mock.New(), nil, nil)
+
+	var health map[string]any
+	if code := serve(t, s, http.MethodGet, "/health", "", &health); code != http.StatusOK || health["status"] != "ok" {
+		t.Fatalf("GET /health = %d %v, want 200 ok", code, health)
+	}
+
+	var state maintenanceResponse
+	if code := serve(t, s, http.MethodPost, "/api/v1/maintenance", `{"enabled": true}`, &state); code != http.StatusOK || !state.Enabled {
+		t.Fatalf("enabling maintenance = %d %+v, want 200 enabled", code, state)
+	}
+	if !flag.enabled {
+		t.Error("POST /api/v1/maintenance didn't turn the proxy's maintenance mode on")
+	}
+	if code := serve(t, s, http.MethodGet, "/api/v1/maintenance", "", &state); code != http.StatusOK || !state.Enabled {
+		t.Errorf("GET /api/v1/maintenance = %d %+v, want enabled", code, state)
+	}
+	if code := serve(t, s, http.MethodGet, "/health", "", &health); code != http.StatusServiceUnavailable || health["status"] != "maintenance" {
+		t.Errorf("GET /health in maintenance = %d %v, want 503 maintenance", code, health)
+	}
+
+	if code := serve(t, s, http.MethodPost, "/api/v1/maintenance", `{"enabled": false}`, &state); code != http.StatusOK || state.Enabled {
+		t.Fatalf("disabling maintenance = %d %+v, want 200 disabled", code, state)
+	}
+	if code := serve(t, s, http.MethodGet, "/health", "", &health); code != http.StatusOK || health["status"] != "ok" {
+		t.Errorf("GET /health after maintenance = %d %v, want 200 ok", code, health)
+	}
+}
+
+func TestSetMaintenanceErrors(t *testing.T) {
+	s := New(&Config{Maintenance: &maintenanceFlag{}}, mock.New(), nil, nil)
+	for _, body := range []string{`{}`, `not json`} {
+		if code := serve(t, s, http.MethodPost, "/api/v1/maintenance", body, nil); code != http.StatusBadRequest {
+			t.Errorf("POST /api/v1/maintenance %s = %d, want 400", body, code)
+		}
+	}
+
+	// Without a proxy there's no maintenance mode to switch
+	s = New(&Config{}, mock.New(), nil, nil)
+	if code := serve(t, s, http.MethodPost, "/api/v1/maintenance", `{"enabled": true}`, nil); code != http.StatusNotImplemented {
+		t.Errorf("POST /api/v1/maintenance without a proxy = %d, want 501", code)
+	}
+	var health map[string]any
+	if code := serve(t, s, http.MethodGet, "/health", "", &health); code != http.StatusOK {
+		t.Errorf("GET /health without a proxy = %d, want 200", code)
+	}
+}
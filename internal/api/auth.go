@@ -0,0 +1,67 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/riftdata/rift/internal/cow"
+)
+
+// authMiddleware requires token as a bearer token ("Authorization: Bearer
+// <token>") or, for tools that can't set headers, a "token" query parameter.
+// Health endpoints and the dashboard's static files are always public; the
+// dashboard presents the token on its own API requests. An empty token
+// disables authentication.
+func authMiddleware(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if token == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isHealthPath(r.URL.Path) || !strings.HasPrefix(r.URL.Path, "/api/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			got := requestToken(r)
+			if got == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="rift"`)
+				writeError(w, http.StatusUnauthorized, "missing or invalid token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isHealthPath reports whether path is a health endpoint.
+func isHealthPath(path string) bool {
+	return path == "/health" || path == "/health/deep" || path == "/ready"
+}
+
+// requestToken returns the token presented by r, preferring the
+// Authorization header over the query parameter.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, value, ok := strings.Cut(auth, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return ""
+		}
+		return strings.TrimSpace(value)
+	}
+	return r.URL.Query().Get("token")
+}
+
+// actorMiddleware attributes audited engine operations to the API client.
+// The API has a single shared token rather than per-user identities, so the
+// client is identified by its address.
+func actorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		next.ServeHTTP(w, r.WithContext(cow.WithActor(r.Context(), "api@"+host)))
+	})
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	handler := authMiddleware("secret")(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{"no token", "/api/v1/branches", "", http.StatusUnauthorized},
+		{"wrong token", "/api/v1/branches", "Bearer nope", http.StatusUnauthorized},
+		{"not a bearer token", "/api/v1/branches", "Basic c2VjcmV0", http.StatusUnauthorized},
+		{"valid token", "/api/v1/branches", "Bearer secret", http.StatusOK},
+		{"valid query token", "/api/v1/branches?token=secret", "", http.StatusOK},
+		{"wrong query token", "/api/v1/branches?token=nope", "", http.StatusUnauthorized},
+		{"header over valid query token", "/api/v1/branches?token=secret", "Bearer nope", http.StatusUnauthorized},
+		{"header over wrong query token", "/api/v1/branches?token=nope", "Bearer secret", http.StatusOK},
+		{"health", "/health", "", http.StatusOK},
+		{"deep health", "/health/deep", "", http.StatusOK},
+		{"ready", "/ready", "", http.StatusOK},
+		{"dashboard", "/", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.want)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareNoToken(t *testing.T) {
+	handler := authMiddleware("")(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/branches", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("without a configured token got %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestRequestToken(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/branches?token=query", nil)
+	if got := requestToken(req); got != "query" {
+		t.Errorf("requestToken with only ?token = %q, want %q", got, "query")
+	}
+	req.Header.Set("Authorization", "bearer  header ")
+	if got := requestToken(req); got != "header" {
+		t.Errorf("requestToken with both = %q, want the header's %q", got, "header")
+	}
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+)
+
+const (
+	corsAllowMethods = "GET, POST, PATCH, DELETE, OPTIONS"
+	corsAllowHeaders = "Authorization, Content-Type"
+)
+
+// corsMiddleware lets browsers on origins call the API. "*" allows any
+// origin; otherwise the request's Origin is echoed back only if it is listed.
+// OPTIONS preflight requests are answered with 204 before authentication,
+// since browsers send them without credentials.
+func corsMiddleware(origins []string) func(http.Handler) http.Handler {
+	allowAll := slices.Contains(origins, "*")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+			if origin != "" && (allowAll || slices.Contains(origins, origin)) {
+				if allowAll {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			}
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCORSPreflightAllowsPatch(t *testing.T) {
+	handler := corsMiddleware([]string{"https://app.example.com"})(http.NotFoundHandler())
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/v1/branches/feature", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("preflight status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	methods := strings.Split(rec.Header().Get("Access-Control-Allow-Methods"), ", ")
+	if !slices.Contains(methods, http.MethodPatch) {
+		t.Errorf("Access-Control-Allow-Methods = %q, want PATCH allowed", rec.Header().Get("Access-Control-Allow-Methods"))
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	// Wrapped as in New, so preflights must be answered before auth
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	allowed := corsMiddleware([]string{"https://app.example.com"})(authMiddleware("secret")(next))
+	anyOrigin := corsMiddleware([]string{"*"})(next)
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		method   string
+		origin   string
+		wantCode int
+		wantACAO string
+	}{
+		{"listed origin echoed", allowed, http.MethodGet, "https://app.example.com", http.StatusUnauthorized, "https://app.example.com"},
+		{"unlisted origin", allowed, http.MethodGet, "https://evil.example.com", http.StatusUnauthorized, ""},
+		{"no origin", allowed, http.MethodGet, "", http.StatusUnauthorized, ""},
+		{"preflight skips auth", allowed, http.MethodOptions, "https://app.example.com", http.StatusNoContent, "https://app.example.com"},
+		{"unlisted preflight", allowed, http.MethodOptions, "https://evil.example.com", http.StatusNoContent, ""},
+		{"any origin", anyOrigin, http.MethodGet, "https://other.example.com", http.StatusOK, "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/v1/branches", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("%s with Origin %q = %d, want %d", tt.method, tt.origin, rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantACAO {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantACAO)
+			}
+			if got := rec.Header().Get("Vary"); got != "Origin" {
+				t.Errorf("Vary = %q, want Origin", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// eventPollInterval is how often /api/v1/events checks the audit log for
+// new entries.
+const eventPollInterval = time.Second
+
+// handleEvents streams branch operations (create, delete, merge, ...) as
+// Server-Sent Events, one per audit log entry, each carrying the entry as
+// JSON. The audit log is shared by every rift process,
+// so operations run from the CLI are streamed too. A client reconnecting
+// with Last-Event-ID resumes after that entry; otherwise the stream starts
+// with the next operation.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var lastID int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 0 {
+			writeError(w, http.StatusBadRequest, "invalid Last-Event-ID %q", v)
+			return
+		}
+		lastID = id
+	} else {
+		latest, err := s.store.ListAudit(ctx, storage.AuditQuery{Limit: 1})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "list audit log: %v", err)
+			return
+		}
+		if len(latest) > 0 {
+			lastID = latest[0].ID
+		}
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(eventPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopping:
+			return
+		case <-ticker.C:
+		}
+
+		entries, err := s.store.ListAudit(ctx, storage.AuditQuery{AfterID: lastID})
+		if err != nil {
+			// Best effort: try again at the next tick
+			continue
+		}
+		for _, e := range entries {
+			data, err := json.Marshal(toAuditEntryResponse(e))
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.ID, data); err != nil {
+				return
+			}
+			lastID = e.ID
+		}
+		if len(entries) > 0 {
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+)
+
+// healthCheckTimeout bounds each check run by /ready and /health/deep.
+const healthCheckTimeout = 2 * time.Second
+
+// healthCheck is the outcome of one /health/deep check.
+type healthCheck struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"` // "ok", "failed", or "skipped"
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// errSkipped marks a check that had nothing to verify.
+var errSkipped = errors.New("skipped")
+
+// handleDeepHealth verifies that the engine works, not just that the database
+// is reachable: the _rift schema exists, main is listed, a branch's overlay
+// schema exists, and a query runs through the engine on main.
+func (s *Server) handleDeepHealth(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var overlayBranch string
+
+	checks := []healthCheck{
+		runHealthCheck(ctx, "rift_schema", s.checkRiftSchema),
+		runHealthCheck(ctx, "main_branch", func(ctx context.Context) error {
+			var err error
+			overlayBranch, err = s.checkMainBranch(ctx)
+			return err
+		}),
+		runHealthCheck(ctx, "overlay_schema", func(ctx context.Context) error {
+			return s.checkOverlaySchema(ctx, overlayBranch)
+		}),
+		runHealthCheck(ctx, "engine_query", s.checkEngineQuery),
+	}
+
+	status, code := "ok", http.StatusOK
+	for _, c := range checks {
+		if c.Status == "failed" {
+			status, code = "failed", http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// runHealthCheck runs check with its own timeout and records the outcome.
+func runHealthCheck(ctx context.Context, name string, check func(context.Context) error) healthCheck {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := healthCheck{Name: name, Status: "ok", DurationMS: time.Since(start).Milliseconds()}
+	switch {
+	case errors.Is(err, errSkipped):
+		result.Status = "skipped"
+	case err != nil:
+		result.Status, result.Error = "failed", err.Error()
+	}
+	return result
+}
+
+func (s *Server) checkRiftSchema(ctx context.Context) error {
+	var exists bool
+	if err := s.store.Pool().QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_namespace WHERE nspname = '_rift')`).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("_rift schema does not exist")
+	}
+	return nil
+}
+
+// checkMainBranch verifies that main is listed and returns a random other
+// branch for checkOverlaySchema, or "" if there is none.
+func (s *Server) checkMainBranch(ctx context.Context) (string, error) {
+	branches, err := s.store.ListBranches(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	hasMain := false
+	var others []string
+	for _, b := range branches {
+		if b.Name == "main" {
+			hasMain = true
+			continue
+		}
+		others = append(others, b.Name)
+	}
+	if !hasMain {
+		return "", errors.New("main branch is not listed")
+	}
+	if len(others) == 0 {
+		return "", nil
+	}
+	return others[rand.IntN(len(others))], nil // #nosec G404 -- sampling, not security
+}
+
+func (s *Server) checkOverlaySchema(ctx context.Context, branchName string) error {
+	if branchName == "" {
+		return errSkipped
+	}
+	schema := s.store.BranchSchemaName(branchName)
+	var exists bool
+	if err := s.store.Pool().QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_namespace WHERE nspname = $1)`, schema).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("overlay schema %s of branch %q does not exist", schema, branchName)
+	}
+	return nil
+}
+
+func (s *Server) checkEngineQuery(ctx context.Context) error {
+	pq, err := s.engine.ProcessQuery(ctx, "main", "SELECT 1")
+	if err != nil {
+		return err
+	}
+	var one int
+	return s.store.Pool().QueryRow(ctx, pq.RewrittenSQL).Scan(&one)
+}
+
+// checkProxy verifies that the proxy listener accepts connections.
+func (s *Server) checkProxy(ctx context.Context) error {
+	if s.proxyAddr == "" {
+		return nil
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.proxyAddr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,87 @@
+package branch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrHostNotAllowed is returned when a client host is not in a branch's
+// allowed hosts list.
+var ErrHostNotAllowed = errors.New("host not allowed")
+
+// NormalizeHost validates an allowed-host entry and returns it in canonical
+// form. Entries are IP addresses ("10.0.0.5"), CIDR ranges ("10.0.0.0/24")
+// or hostnames ("app-1.internal"), which are resolved when a client connects.
+func NormalizeHost(host string) (string, error) {
+	host = strings.TrimSpace(host)
+	if host == "" {
+		return "", fmt.Errorf("empty host")
+	}
+	if strings.Contains(host, "/") {
+		_, ipNet, err := net.ParseCIDR(host)
+		if err != nil {
+			return "", fmt.Errorf("invalid CIDR %q: %w", host, err)
+		}
+		return ipNet.String(), nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	if strings.ContainsAny(host, " :") {
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	return strings.ToLower(host), nil
+}
+
+// HostAllowed reports whether ip matches any entry in allowed. An empty list
+// allows every host. Hostname entries are resolved with resolver.
+func HostAllowed(ctx context.Context, resolver *net.Resolver, allowed []string, ip net.IP) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, entry := range allowed {
+		if hostMatches(ctx, resolver, entry, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func hostMatches(ctx context.Context, resolver *net.Resolver, entry string, ip net.IP) bool {
+	if strings.Contains(entry, "/") {
+		_, ipNet, err := net.ParseCIDR(entry)
+		return err == nil && ipNet.Contains(ip)
+	}
+	if entryIP := net.ParseIP(entry); entryIP != nil {
+		return entryIP.Equal(ip)
+	}
+
+	addrs, err := resolver.LookupIPAddr(ctx, entry)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP extracts the IP address from a client's remote address.
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
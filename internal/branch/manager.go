@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"sync"
@@ -30,6 +31,9 @@ type Branch struct {
 	TTL       *Duration `json:"ttl,omitempty"`
 	Pinned    bool      `json:"pinned"`
 
+	// AllowedHosts restricts which client hosts may connect (empty = any)
+	AllowedHosts []string `json:"allowed_hosts,omitempty"`
+
 	// Stats
 	DeltaSize   int64 `json:"delta_size"`
 	RowsChanged int64 `json:"rows_changed"`
@@ -412,14 +416,12 @@ func (m *StorageBackedManager) Delete(ctx context.Context, name string) error {
 	}
 
 	// Check for children
-	branches, err := m.store.ListBranches(ctx)
+	children, err := m.store.ListBranchesByParent(ctx, name)
 	if err != nil {
-		return fmt.Errorf("list branches: %w", err)
+		return fmt.Errorf("list child branches: %w", err)
 	}
-	for _, child := range branches {
-		if child.Parent == name {
-			return fmt.Errorf("branch has children: %s", child.Name)
-		}
+	if len(children) > 0 {
+		return fmt.Errorf("branch has children: %s", children[0].Name)
 	}
 
 	// Drop overlay schema first
@@ -453,10 +455,10 @@ func (m *StorageBackedManager) List(ctx context.Context) ([]*Branch, error) {
 	return branches, nil
 }
 
-// Checks if a branch exists
+// Checks if a branch exists and hasn't been soft-deleted
 func (m *StorageBackedManager) Exists(ctx context.Context, name string) bool {
-	_, err := m.store.GetBranch(ctx, name)
-	return err == nil
+	sb, err := m.store.GetBranch(ctx, name)
+	return err == nil && sb.DeletedAt == nil
 }
 
 // ResolveDatabase returns the upstream database for a branch.
@@ -491,7 +493,77 @@ func (m *StorageBackedManager) Unpin(ctx context.Context, name string) error {
 	return m.store.UpdateBranch(ctx, sb)
 }
 
-// GC removes expired branches and returns their names.
+// AllowHost adds a host, CIDR range or hostname to the branch's allowed hosts.
+// Once the list is non-empty, only matching clients may connect to the branch.
+func (m *StorageBackedManager) AllowHost(ctx context.Context, name, host string) ([]string, error) {
+	host, err := NormalizeHost(host)
+	if err != nil {
+		return nil, err
+	}
+	sb, err := m.store.GetBranch(ctx, name)
+	if err != nil {
+		return nil, ErrBranchNotFound
+	}
+	for _, h := range sb.AllowedHosts {
+		if h == host {
+			return sb.AllowedHosts, nil
+		}
+	}
+	hosts := append(sb.AllowedHosts, host)
+	if err := m.store.SetBranchAllowedHosts(ctx, name, hosts); err != nil {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+// DenyHost removes a host from the branch's allowed hosts. Removing the last
+// entry allows every host again.
+func (m *StorageBackedManager) DenyHost(ctx context.Context, name, host string) ([]string, error) {
+	host, err := NormalizeHost(host)
+	if err != nil {
+		return nil, err
+	}
+	sb, err := m.store.GetBranch(ctx, name)
+	if err != nil {
+		return nil, ErrBranchNotFound
+	}
+	hosts := make([]string, 0, len(sb.AllowedHosts))
+	for _, h := range sb.AllowedHosts {
+		if h != host {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == len(sb.AllowedHosts) {
+		return nil, fmt.Errorf("host %s is not in the allowed hosts of branch %q", host, name)
+	}
+	if err := m.store.SetBranchAllowedHosts(ctx, name, hosts); err != nil {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+// CheckHost returns ErrHostNotAllowed if a client at addr may not connect to
+// the branch.
+func (m *StorageBackedManager) CheckHost(ctx context.Context, name string, addr net.Addr) error {
+	sb, err := m.store.GetBranch(ctx, name)
+	if err != nil {
+		return ErrBranchNotFound
+	}
+	if len(sb.AllowedHosts) == 0 {
+		return nil
+	}
+	ip := remoteIP(addr)
+	if ip == nil {
+		return fmt.Errorf("%w: unknown client address %v", ErrHostNotAllowed, addr)
+	}
+	if !HostAllowed(ctx, net.DefaultResolver, sb.AllowedHosts, ip) {
+		return fmt.Errorf("%w: %s may not connect to branch %q", ErrHostNotAllowed, ip, name)
+	}
+	return nil
+}
+
+// GC removes expired branches and returns their names. An expired branch
+// with unexpired children is kept until they are gone.
 func (m *StorageBackedManager) GC(ctx context.Context) ([]string, error) {
 	branches, err := m.store.ListBranches(ctx)
 	if err != nil {
@@ -501,10 +573,19 @@ func (m *StorageBackedManager) GC(ctx context.Context) ([]string, error) {
 	now := time.Now()
 	var deleted []string
 
-	for _, b := range branches {
+	// Newest first, so expired children go before their expired parents
+	for i := len(branches) - 1; i >= 0; i-- {
+		b := branches[i]
 		if b.TTLSeconds != nil && !b.Pinned {
 			expiresAt := b.CreatedAt.Add(time.Duration(*b.TTLSeconds) * time.Second)
 			if now.After(expiresAt) {
+				children, err := m.store.ListBranchesByParent(ctx, b.Name)
+				if err != nil {
+					return deleted, fmt.Errorf("list children of %s: %w", b.Name, err)
+				}
+				if len(children) > 0 {
+					continue
+				}
 				if err := m.store.DropBranchSchema(ctx, b.Name); err != nil {
 					return deleted, fmt.Errorf("drop schema for %s: %w", b.Name, err)
 				}
@@ -535,6 +616,8 @@ func storageBranchToBranch(sb *storage.Branch) *Branch {
 		Pinned:      sb.Pinned,
 		DeltaSize:   sb.DeltaSize,
 		RowsChanged: sb.RowsChanged,
+
+		AllowedHosts: sb.AllowedHosts,
 	}
 
 	if sb.TTLSeconds != nil {
@@ -1,11 +1,17 @@
 package branch
 
 import (
+	"context"
 	"encoding/json"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/riftdata/rift/internal/storage"
+	"github.com/riftdata/rift/internal/testutil"
 )
 
 func TestNewManager(t *testing.T) {
@@ -394,3 +400,124 @@ func TestDurationJSONInvalid(t *testing.T) {
 		t.Error("Unmarshal should fail for non-string value")
 	}
 }
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"10.0.0.5", "10.0.0.5", false},
+		{" 10.0.4.7/24 ", "10.0.4.0/24", false},
+		{"::1", "::1", false},
+		{"App-1.Internal", "app-1.internal", false},
+		{"10.0.0.0/33", "", true},
+		{"", "", true},
+		{"bad host", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeHost(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeHost(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeHost(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	allowed := []string{"10.0.4.0/24", "192.168.1.10", "::1"}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.0.4.99", true},
+		{"10.0.5.1", false},
+		{"192.168.1.10", true},
+		{"192.168.1.11", false},
+		{"::1", true},
+	}
+	for _, tt := range tests {
+		got := HostAllowed(context.Background(), net.DefaultResolver, allowed, net.ParseIP(tt.ip))
+		if got != tt.want {
+			t.Errorf("HostAllowed(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	if !HostAllowed(context.Background(), net.DefaultResolver, nil, net.ParseIP("203.0.113.1")) {
+		t.Error("empty allowed list should allow every host")
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	if ip := remoteIP(&net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 5432}); !ip.Equal(net.ParseIP("10.0.0.5")) {
+		t.Errorf("remoteIP(TCPAddr) = %v", ip)
+	}
+	if ip := remoteIP(nil); ip != nil {
+		t.Errorf("remoteIP(nil) = %v, want nil", ip)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	branches := []*storage.Branch{
+		{Name: "main", CreatedAt: now.Add(-24 * time.Hour), Pinned: true},
+		{Name: `feature-"auth"`, CreatedAt: now.Add(-90 * time.Second), RowsChanged: 42, DeltaSize: 8192},
+	}
+
+	var buf strings.Builder
+	if err := WritePrometheus(&buf, branches, now); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	got := buf.String()
+
+	for _, want := range []string{
+		"# HELP rift_branch_rows_changed Rows changed on the branch.\n# TYPE rift_branch_rows_changed gauge\n",
+		`rift_branch_rows_changed{branch="feature-\"auth\""} 42` + "\n",
+		`rift_branch_delta_bytes{branch="feature-\"auth\""} 8192` + "\n",
+		`rift_branch_age_seconds{branch="main"} 86400` + "\n",
+		`rift_branch_age_seconds{branch="feature-\"auth\""} 90` + "\n",
+		`rift_branch_pinned{branch="main"} 1` + "\n",
+		`rift_branch_pinned{branch="feature-\"auth\""} 0` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+	if n := strings.Count(got, "# TYPE "); n != 4 {
+		t.Errorf("got %d TYPE lines, want 4", n)
+	}
+}
+
+func TestStorageBackedManagerGCKeepsParents(t *testing.T) {
+	ctx := context.Background()
+	store := testutil.NewMockStore()
+	m := NewStorageBackedManager(store)
+
+	expired := 1
+	created := time.Now().Add(-time.Hour)
+	for _, b := range []*storage.Branch{
+		{Name: "parent", Parent: "main", TTLSeconds: &expired, CreatedAt: created},
+		{Name: "child", Parent: "parent", CreatedAt: created.Add(time.Minute)},
+		{Name: "old", Parent: "main", TTLSeconds: &expired, CreatedAt: created.Add(2 * time.Minute)},
+		{Name: "old-child", Parent: "old", TTLSeconds: &expired, CreatedAt: created.Add(3 * time.Minute)},
+	} {
+		b.Status = "active"
+		if err := store.CreateBranch(ctx, b); err != nil {
+			t.Fatalf("CreateBranch(%s): %v", b.Name, err)
+		}
+	}
+
+	deleted, err := m.GC(ctx)
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if strings.Join(deleted, ",") != "old-child,old" {
+		t.Errorf("GC deleted %v, want [old-child old]", deleted)
+	}
+	if _, err := store.GetBranch(ctx, "parent"); err != nil {
+		t.Errorf("parent of a live branch was collected: %v", err)
+	}
+}
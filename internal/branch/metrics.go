@@ -0,0 +1,56 @@
+package branch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// PrometheusContentType is the Content-Type of WritePrometheus's output.
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// branchMetrics are the gauges WritePrometheus reports for each branch.
+var branchMetrics = []struct {
+	name  string
+	help  string
+	value func(b *storage.Branch, now time.Time) float64
+}{
+	{"rift_branch_rows_changed", "Rows changed on the branch.", func(b *storage.Branch, _ time.Time) float64 {
+		return float64(b.RowsChanged)
+	}},
+	{"rift_branch_delta_bytes", "Size of the branch's overlay tables in bytes.", func(b *storage.Branch, _ time.Time) float64 {
+		return float64(b.DeltaSize)
+	}},
+	{"rift_branch_age_seconds", "Seconds since the branch was created.", func(b *storage.Branch, now time.Time) float64 {
+		return now.Sub(b.CreatedAt).Seconds()
+	}},
+	{"rift_branch_pinned", "Whether the branch is pinned (1) or not (0).", func(b *storage.Branch, _ time.Time) float64 {
+		if b.Pinned {
+			return 1
+		}
+		return 0
+	}},
+}
+
+// WritePrometheus writes gauges for each branch in the Prometheus text
+// exposition format, labelled by branch name, with ages measured at now.
+func WritePrometheus(w io.Writer, branches []*storage.Branch, now time.Time) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range branchMetrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(bw, "# TYPE %s gauge\n", m.name)
+		for _, b := range branches {
+			fmt.Fprintf(bw, "%s{branch=\"%s\"} %g\n", m.name, escapeLabelValue(b.Name), m.value(b, now))
+		}
+	}
+	return bw.Flush()
+}
+
+// escapeLabelValue escapes a label value for the text exposition format.
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
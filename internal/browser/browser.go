@@ -0,0 +1,28 @@
+// Package browser opens URLs in the user's default web browser.
+package browser
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// OpenURL opens url in the default browser, returning once the opener
+// command has been started.
+func OpenURL(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url) // #nosec G204 -- fixed opener, URL built by rift
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url) // #nosec G204 -- fixed opener, URL built by rift
+	default:
+		cmd = exec.Command("xdg-open", url) // #nosec G204 -- fixed opener, URL built by rift
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("open %s: %w", url, err)
+	}
+	// Reap the opener without waiting for the browser
+	go func() { _ = cmd.Wait() }()
+	return nil
+}
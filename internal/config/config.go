@@ -25,11 +25,26 @@ type Config struct {
 	// Storage settings
 	Storage StorageConfig `mapstructure:"storage"`
 
+	// Copy-on-write engine settings
+	Cow CowConfig `mapstructure:"cow"`
+
 	// Logging
 	Log LogConfig `mapstructure:"log"`
 
 	// Telemetry (opt-in)
 	Telemetry TelemetryConfig `mapstructure:"telemetry"`
+
+	// Stale branch reports
+	Notifications NotificationsConfig `mapstructure:"notifications"`
+
+	// Profiling (rift serve --profile)
+	Profile ProfileConfig `mapstructure:"profile"`
+
+	// rawUpstreamURL is upstream.url as written in the config, before
+	// environment substitution; resolvedUpstreamURL is what it became.
+	// Save writes the raw form back so secrets stay out of the file.
+	rawUpstreamURL      string
+	resolvedUpstreamURL string
 }
 
 type UpstreamConfig struct {
@@ -38,6 +53,38 @@ type UpstreamConfig struct {
 	ConnectTimeout time.Duration `mapstructure:"connect_timeout"`
 	IdleTimeout    time.Duration `mapstructure:"idle_timeout"`
 	SSLMode        string        `mapstructure:"ssl_mode"`
+
+	// PasswordFromEnv names an environment variable holding the upstream
+	// password. When set, it replaces any password in URL.
+	PasswordFromEnv string `mapstructure:"password_from_env"`
+
+	// Pool sizing for 'rift serve'. Zero values keep pgx's defaults.
+	PoolMinConns        int32         `mapstructure:"pool_min_conns"`
+	PoolMaxConns        int32         `mapstructure:"pool_max_conns"`
+	PoolMaxConnIdleTime time.Duration `mapstructure:"pool_max_conn_idle_time"`
+
+	// BranchPoolSize gives each branch its own pool of at most this many
+	// connections, so one busy branch can't starve the others. 0 shares
+	// the pool between branches.
+	BranchPoolSize int32 `mapstructure:"branch_pool_size"`
+
+	// ReadReplicaURL, if set, is a read replica of the upstream database
+	// that 'rift serve' sends branch SELECTs made outside a transaction to.
+	// ${VAR} references are expanded as in URL.
+	ReadReplicaURL string `mapstructure:"read_replica_url"`
+
+	// Replicas are further read replicas that 'rift serve' spreads those
+	// SELECTs over, round-robin with ReadReplicaURL. A replica failing its
+	// health check is skipped until it recovers. ${VAR} references are
+	// expanded as in URL.
+	Replicas []string `mapstructure:"replicas"`
+
+	// FailoverURLs are further upstreams, such as standbys a promotion
+	// tool may make the primary, that 'rift serve --upstream-failover'
+	// switches to, in order, when the one in use can't be reached. They
+	// need the same credentials as URL for main's passthrough connections.
+	// ${VAR} references are expanded as in URL.
+	FailoverURLs []string `mapstructure:"failover_urls"`
 }
 
 type ProxyConfig struct {
@@ -45,6 +92,21 @@ type ProxyConfig struct {
 	MaxConnections int           `mapstructure:"max_connections"`
 	ReadTimeout    time.Duration `mapstructure:"read_timeout"`
 	WriteTimeout   time.Duration `mapstructure:"write_timeout"`
+
+	// ReadOnly rejects writes and DDL on every branch (rift serve --read-only).
+	ReadOnly bool `mapstructure:"read_only"`
+
+	// DrainTimeout is how long shutdown waits for in-flight queries.
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+
+	// TLSCertFile and TLSKeyFile let clients connect with TLS.
+	TLSCertFile string `mapstructure:"tls_cert_file"`
+	TLSKeyFile  string `mapstructure:"tls_key_file"`
+
+	// MutualTLS requires clients to present a certificate signed by a CA in
+	// ClientCAFile; its subject CN and fingerprint are logged.
+	MutualTLS    bool   `mapstructure:"mutual_tls"`
+	ClientCAFile string `mapstructure:"client_ca_file"`
 }
 
 type APIConfig struct {
@@ -52,6 +114,10 @@ type APIConfig struct {
 	ListenAddr string `mapstructure:"listen_addr"`
 	EnableCORS bool   `mapstructure:"enable_cors"`
 	AuthToken  string `mapstructure:"auth_token"`
+
+	// AllowedOrigins are the origins allowed by CORS when EnableCORS is
+	// set; "*" allows any origin.
+	AllowedOrigins []string `mapstructure:"allowed_origins"`
 }
 
 type StorageConfig struct {
@@ -59,20 +125,101 @@ type StorageConfig struct {
 	MaxBranchSize int64         `mapstructure:"max_branch_size"`
 	CompactAfter  time.Duration `mapstructure:"compact_after"`
 	RetentionDays int           `mapstructure:"retention_days"`
+
+	// GCInterval is how often 'rift serve' purges branches soft-deleted
+	// more than RetentionDays ago. 0 disables it.
+	GCInterval time.Duration `mapstructure:"gc_interval"`
+
+	// CompactAnalyzeThreshold is how many rows a branch's overlay tables
+	// take before 'rift serve' runs VACUUM ANALYZE on them. 0 disables it.
+	CompactAnalyzeThreshold int64 `mapstructure:"compact_analyze_threshold"`
 }
 
+type CowConfig struct {
+	// MaxOverlayRows caps the branch rows read per table by rewritten SELECTs;
+	// the rows past it, by primary key, are left out of the results. 0 means
+	// unlimited.
+	MaxOverlayRows int `mapstructure:"max_overlay_rows"`
+
+	// CTEMode selects how rewritten SELECTs leave out source rows the branch
+	// changed: "union_all" (a NOT EXISTS per source row) or "hash_antijoin"
+	// (an anti join against the overlay's keys, faster for small overlays
+	// over large tables).
+	CTEMode string `mapstructure:"cte_mode"`
+
+	// TrackDeltaSizeRealtime adds triggers to overlay tables that keep each
+	// branch's delta_size current. Off by default for write performance.
+	TrackDeltaSizeRealtime bool `mapstructure:"track_delta_size_realtime"`
+
+	// TrackAllOnCreate creates overlay tables for every table in the public
+	// schema when a branch is created, instead of on the first write to
+	// each table.
+	TrackAllOnCreate bool `mapstructure:"track_all_on_create"`
+
+	// ResultCacheMaxSize is how many SELECT results on frozen branches are
+	// cached. 0 disables the cache.
+	ResultCacheMaxSize int `mapstructure:"result_cache_max_size"`
+
+	// ResultCacheTTL bounds how long a cached result is served, and so how
+	// stale it can be relative to the source tables.
+	ResultCacheTTL time.Duration `mapstructure:"result_cache_ttl"`
+
+	// ColumnCacheTTL is how long rift serve caches each table's column
+	// definitions. ALTER TABLE on a branch drops the table's entry early. 0
+	// disables the cache.
+	ColumnCacheTTL time.Duration `mapstructure:"column_cache_ttl"`
+
+	// MergeRowsPerSecond is the merge throughput 'rift merge --preview'
+	// bases its time estimate on.
+	MergeRowsPerSecond int `mapstructure:"merge_rows_per_second"`
+}
+
+// LogConfig controls the logs of 'rift serve'.
 type LogConfig struct {
-	Level  string `mapstructure:"level"`
-	Format string `mapstructure:"format"`
-	File   string `mapstructure:"file"`
+	Level  string `mapstructure:"level"`  // debug, info, warn or error
+	Format string `mapstructure:"format"` // text or json
+	File   string `mapstructure:"file"`   // empty means stderr
+
+	// QueryLogFile receives query logs from 'rift serve --log-queries'.
+	// Empty means the main log output (stderr).
+	QueryLogFile string `mapstructure:"query_log_file"`
 }
 
+// TelemetryConfig controls anonymous usage metrics, which are off unless the
+// user turns them on.
 type TelemetryConfig struct {
 	Enabled   bool   `mapstructure:"enabled"`
 	Endpoint  string `mapstructure:"endpoint"`
 	Anonymous bool   `mapstructure:"anonymous"`
 }
 
+// NotificationsConfig controls the stale branch reports of 'rift notify'.
+type NotificationsConfig struct {
+	Channel    string `mapstructure:"channel"`     // slack or email
+	WebhookURL string `mapstructure:"webhook_url"` // Slack incoming webhook
+
+	// OlderThan is the age past which a branch is reported.
+	OlderThan time.Duration `mapstructure:"older_than"`
+
+	SMTPHost     string   `mapstructure:"smtp_host"`
+	SMTPPort     int      `mapstructure:"smtp_port"`
+	SMTPUsername string   `mapstructure:"smtp_username"`
+	SMTPPassword string   `mapstructure:"smtp_password"`
+	SMTPFrom     string   `mapstructure:"smtp_from"`
+	SMTPTo       []string `mapstructure:"smtp_to"`
+
+	// CronNotifications is how often 'rift serve' sends the report; 0
+	// leaves it to 'rift notify'.
+	CronNotifications time.Duration `mapstructure:"cron_notifications"`
+}
+
+// ProfileConfig controls the pprof server of 'rift serve --profile'.
+type ProfileConfig struct {
+	// ListenAddr is where the pprof handlers are served. It must be a
+	// loopback address, so profiles can't be fetched from other machines.
+	ListenAddr string `mapstructure:"listen_addr"`
+}
+
 // DefaultConfig returns sensible defaults
 func DefaultConfig() *Config {
 	return &Config{
@@ -87,17 +234,28 @@ func DefaultConfig() *Config {
 			MaxConnections: 100,
 			ReadTimeout:    30 * time.Second,
 			WriteTimeout:   30 * time.Second,
+			DrainTimeout:   30 * time.Second,
 		},
 		API: APIConfig{
-			Enabled:    true,
-			ListenAddr: ":8080",
-			EnableCORS: true,
+			Enabled:        true,
+			ListenAddr:     ":8080",
+			EnableCORS:     true,
+			AllowedOrigins: []string{"*"},
 		},
 		Storage: StorageConfig{
 			DataDir:       defaultDataDir(),
 			MaxBranchSize: 10 * 1024 * 1024 * 1024, // 10GB
 			CompactAfter:  24 * time.Hour,
 			RetentionDays: 30,
+			GCInterval:    time.Hour,
+
+			CompactAnalyzeThreshold: 10000,
+		},
+		Cow: CowConfig{
+			CTEMode:            "union_all",
+			ResultCacheTTL:     time.Minute,
+			ColumnCacheTTL:     5 * time.Minute,
+			MergeRowsPerSecond: 10000,
 		},
 		Log: LogConfig{
 			Level:  "info",
@@ -105,8 +263,16 @@ func DefaultConfig() *Config {
 		},
 		Telemetry: TelemetryConfig{
 			Enabled:   false,
+			Endpoint:  "https://telemetry.riftdata.io/v1/events",
 			Anonymous: true,
 		},
+		Notifications: NotificationsConfig{
+			OlderThan: 7 * 24 * time.Hour,
+			SMTPPort:  587,
+		},
+		Profile: ProfileConfig{
+			ListenAddr: "127.0.0.1:6060",
+		},
 	}
 }
 
@@ -118,6 +284,30 @@ func defaultDataDir() string {
 	return filepath.Join(home, ".rift")
 }
 
+// SearchPaths returns the directories searched for config.yaml when no
+// explicit config file is given, in priority order.
+func SearchPaths() []string {
+	return []string{".", defaultDataDir(), "/etc/rift"}
+}
+
+// FindFile returns the config file that Load would read for configPath,
+// or "" if none exists.
+func FindFile(configPath string) string {
+	if configPath != "" {
+		if _, err := os.Stat(configPath); err != nil {
+			return ""
+		}
+		return configPath
+	}
+	for _, dir := range SearchPaths() {
+		path := filepath.Join(dir, "config.yaml")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
 // Load loads configuration from file, env vars, and flags
 func Load(configPath string) (*Config, error) {
 	v := viper.New()
@@ -128,21 +318,58 @@ func Load(configPath string) (*Config, error) {
 	v.SetDefault("upstream.connect_timeout", defaults.Upstream.ConnectTimeout)
 	v.SetDefault("upstream.idle_timeout", defaults.Upstream.IdleTimeout)
 	v.SetDefault("upstream.ssl_mode", defaults.Upstream.SSLMode)
+	v.SetDefault("upstream.pool_min_conns", defaults.Upstream.PoolMinConns)
+	v.SetDefault("upstream.pool_max_conns", defaults.Upstream.PoolMaxConns)
+	v.SetDefault("upstream.pool_max_conn_idle_time", defaults.Upstream.PoolMaxConnIdleTime)
+	v.SetDefault("upstream.branch_pool_size", defaults.Upstream.BranchPoolSize)
+	v.SetDefault("upstream.read_replica_url", defaults.Upstream.ReadReplicaURL)
+	v.SetDefault("upstream.replicas", defaults.Upstream.Replicas)
+	v.SetDefault("upstream.failover_urls", defaults.Upstream.FailoverURLs)
 	v.SetDefault("proxy.listen_addr", defaults.Proxy.ListenAddr)
 	v.SetDefault("proxy.max_connections", defaults.Proxy.MaxConnections)
 	v.SetDefault("proxy.read_timeout", defaults.Proxy.ReadTimeout)
 	v.SetDefault("proxy.write_timeout", defaults.Proxy.WriteTimeout)
+	v.SetDefault("proxy.read_only", defaults.Proxy.ReadOnly)
+	v.SetDefault("proxy.drain_timeout", defaults.Proxy.DrainTimeout)
+	v.SetDefault("proxy.tls_cert_file", defaults.Proxy.TLSCertFile)
+	v.SetDefault("proxy.tls_key_file", defaults.Proxy.TLSKeyFile)
+	v.SetDefault("proxy.mutual_tls", defaults.Proxy.MutualTLS)
+	v.SetDefault("proxy.client_ca_file", defaults.Proxy.ClientCAFile)
 	v.SetDefault("api.enabled", defaults.API.Enabled)
 	v.SetDefault("api.listen_addr", defaults.API.ListenAddr)
 	v.SetDefault("api.enable_cors", defaults.API.EnableCORS)
+	v.SetDefault("api.auth_token", defaults.API.AuthToken)
+	v.SetDefault("api.allowed_origins", defaults.API.AllowedOrigins)
 	v.SetDefault("storage.data_dir", defaults.Storage.DataDir)
 	v.SetDefault("storage.max_branch_size", defaults.Storage.MaxBranchSize)
 	v.SetDefault("storage.compact_after", defaults.Storage.CompactAfter)
 	v.SetDefault("storage.retention_days", defaults.Storage.RetentionDays)
+	v.SetDefault("storage.gc_interval", defaults.Storage.GCInterval)
+	v.SetDefault("storage.compact_analyze_threshold", defaults.Storage.CompactAnalyzeThreshold)
+	v.SetDefault("cow.max_overlay_rows", defaults.Cow.MaxOverlayRows)
+	v.SetDefault("cow.cte_mode", defaults.Cow.CTEMode)
+	v.SetDefault("cow.track_delta_size_realtime", defaults.Cow.TrackDeltaSizeRealtime)
+	v.SetDefault("cow.track_all_on_create", defaults.Cow.TrackAllOnCreate)
+	v.SetDefault("cow.result_cache_max_size", defaults.Cow.ResultCacheMaxSize)
+	v.SetDefault("cow.result_cache_ttl", defaults.Cow.ResultCacheTTL)
+	v.SetDefault("cow.column_cache_ttl", defaults.Cow.ColumnCacheTTL)
+	v.SetDefault("cow.merge_rows_per_second", defaults.Cow.MergeRowsPerSecond)
 	v.SetDefault("log.level", defaults.Log.Level)
 	v.SetDefault("log.format", defaults.Log.Format)
 	v.SetDefault("telemetry.enabled", defaults.Telemetry.Enabled)
+	v.SetDefault("telemetry.endpoint", defaults.Telemetry.Endpoint)
 	v.SetDefault("telemetry.anonymous", defaults.Telemetry.Anonymous)
+	v.SetDefault("notifications.channel", defaults.Notifications.Channel)
+	v.SetDefault("notifications.webhook_url", defaults.Notifications.WebhookURL)
+	v.SetDefault("notifications.older_than", defaults.Notifications.OlderThan)
+	v.SetDefault("notifications.smtp_host", defaults.Notifications.SMTPHost)
+	v.SetDefault("notifications.smtp_port", defaults.Notifications.SMTPPort)
+	v.SetDefault("notifications.smtp_username", defaults.Notifications.SMTPUsername)
+	v.SetDefault("notifications.smtp_password", defaults.Notifications.SMTPPassword)
+	v.SetDefault("notifications.smtp_from", defaults.Notifications.SMTPFrom)
+	v.SetDefault("notifications.smtp_to", defaults.Notifications.SMTPTo)
+	v.SetDefault("notifications.cron_notifications", defaults.Notifications.CronNotifications)
+	v.SetDefault("profile.listen_addr", defaults.Profile.ListenAddr)
 
 	// Config file
 	if configPath != "" {
@@ -150,9 +377,9 @@ func Load(configPath string) (*Config, error) {
 	} else {
 		v.SetConfigName("config")
 		v.SetConfigType("yaml")
-		v.AddConfigPath(".")
-		v.AddConfigPath(defaultDataDir())
-		v.AddConfigPath("/etc/rift")
+		for _, dir := range SearchPaths() {
+			v.AddConfigPath(dir)
+		}
 	}
 
 	// Environment variables
@@ -167,24 +394,39 @@ func Load(configPath string) (*Config, error) {
 			return nil, fmt.Errorf("reading config: %w", err)
 		}
 	}
+	if err := checkVersion(v); err != nil {
+		return nil, err
+	}
 
 	var cfg Config
 	if err := v.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("parsing config: %w", err)
 	}
+	if err := cfg.resolveUpstreamURL(); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
 
 // Save writes the config to a file
 func (c *Config) Save(path string) error {
+	upstream := c.Upstream
+	if c.rawUpstreamURL != "" && upstream.URL == c.resolvedUpstreamURL {
+		upstream.URL = c.rawUpstreamURL
+	}
+
 	v := viper.New()
-	v.Set("upstream", c.Upstream)
+	v.Set("version", CurrentVersion)
+	v.Set("upstream", upstream)
 	v.Set("proxy", c.Proxy)
 	v.Set("api", c.API)
 	v.Set("storage", c.Storage)
+	v.Set("cow", c.Cow)
 	v.Set("log", c.Log)
 	v.Set("telemetry", c.Telemetry)
+	v.Set("notifications", c.Notifications)
+	v.Set("profile", c.Profile)
 
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0o750); err != nil {
@@ -202,5 +444,73 @@ func (c *Config) Validate() error {
 	if c.Proxy.ListenAddr == "" {
 		return fmt.Errorf("proxy.listen_addr is required")
 	}
+	if (c.Proxy.TLSCertFile == "") != (c.Proxy.TLSKeyFile == "") {
+		return fmt.Errorf("proxy.tls_cert_file and proxy.tls_key_file must be set together")
+	}
+	if c.Proxy.MutualTLS && (c.Proxy.TLSCertFile == "" || c.Proxy.ClientCAFile == "") {
+		return fmt.Errorf("proxy.mutual_tls requires proxy.tls_cert_file, proxy.tls_key_file and proxy.client_ca_file")
+	}
+	if c.Upstream.PoolMinConns < 0 || c.Upstream.PoolMaxConns < 0 || c.Upstream.BranchPoolSize < 0 {
+		return fmt.Errorf("upstream pool sizes must not be negative")
+	}
+	if c.Upstream.PoolMaxConns > 0 && c.Upstream.PoolMinConns > c.Upstream.PoolMaxConns {
+		return fmt.Errorf("upstream.pool_min_conns must not exceed upstream.pool_max_conns")
+	}
+	if c.Upstream.PoolMaxConnIdleTime < 0 {
+		return fmt.Errorf("upstream.pool_max_conn_idle_time must not be negative")
+	}
+	if c.Storage.RetentionDays < 0 {
+		return fmt.Errorf("storage.retention_days must not be negative")
+	}
+	if c.Storage.GCInterval < 0 {
+		return fmt.Errorf("storage.gc_interval must not be negative")
+	}
+	if c.Storage.CompactAnalyzeThreshold < 0 {
+		return fmt.Errorf("storage.compact_analyze_threshold must not be negative")
+	}
+	if c.Cow.MaxOverlayRows < 0 {
+		return fmt.Errorf("cow.max_overlay_rows must not be negative")
+	}
+	switch c.Cow.CTEMode {
+	case "", "union_all", "hash_antijoin":
+	default:
+		return fmt.Errorf("cow.cte_mode must be union_all or hash_antijoin")
+	}
+	if c.Cow.ResultCacheMaxSize < 0 {
+		return fmt.Errorf("cow.result_cache_max_size must not be negative")
+	}
+	if c.Cow.ResultCacheTTL < 0 {
+		return fmt.Errorf("cow.result_cache_ttl must not be negative")
+	}
+	if c.Cow.ColumnCacheTTL < 0 {
+		return fmt.Errorf("cow.column_cache_ttl must not be negative")
+	}
+	if c.Cow.MergeRowsPerSecond < 0 {
+		return fmt.Errorf("cow.merge_rows_per_second must not be negative")
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("log.level must be debug, info, warn or error")
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("log.format must be text or json")
+	}
+	switch c.Notifications.Channel {
+	case "", "slack", "email":
+	default:
+		return fmt.Errorf("notifications.channel must be slack or email")
+	}
+	if c.Notifications.OlderThan < 0 {
+		return fmt.Errorf("notifications.older_than must not be negative")
+	}
+	if c.Notifications.CronNotifications < 0 {
+		return fmt.Errorf("notifications.cron_notifications must not be negative")
+	}
+	if c.Notifications.CronNotifications > 0 && c.Notifications.Channel == "" {
+		return fmt.Errorf("notifications.cron_notifications needs notifications.channel")
+	}
 	return nil
 }
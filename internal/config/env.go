@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// envPrefix is the prefix of environment variables read by Load.
+const envPrefix = "RIFT_"
+
+// Settings returns the configuration as a flat map of dotted keys
+// (e.g. "proxy.listen_addr") to their string values.
+func (c *Config) Settings() map[string]string {
+	settings := make(map[string]string)
+	flatten(reflect.ValueOf(*c), "", settings)
+	return settings
+}
+
+// flatten walks a config struct and records each leaf field under its
+// mapstructure key, joined to its parents with dots.
+func flatten(v reflect.Value, prefix string, settings map[string]string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("mapstructure")
+		if key == "" {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		value := v.Field(i)
+		if value.Kind() == reflect.Struct {
+			flatten(value, key, settings)
+			continue
+		}
+		settings[key] = fmt.Sprint(value.Interface())
+	}
+}
+
+// EnvName returns the environment variable that overrides a config key,
+// e.g. "proxy.listen_addr" becomes "RIFT_PROXY_LISTEN_ADDR".
+func EnvName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// ExpandEnvInURL substitutes ${VAR} and $VAR references in a connection URL
+// with the values of environment variables, so that passwords can be kept
+// out of config files. Unset variables expand to "".
+func ExpandEnvInURL(rawURL string) string {
+	return os.ExpandEnv(rawURL)
+}
+
+// URLWithPasswordFromEnv returns rawURL with its password replaced by the
+// value of the environment variable envVar.
+func URLWithPasswordFromEnv(rawURL, envVar string) (string, error) {
+	password, ok := os.LookupEnv(envVar)
+	if !ok {
+		return "", fmt.Errorf("upstream.password_from_env: environment variable %s is not set", envVar)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("upstream.url: %w", err)
+	}
+	user := ""
+	if u.User != nil {
+		user = u.User.Username()
+	}
+	u.User = url.UserPassword(user, password)
+	return u.String(), nil
+}
+
+// resolveUpstreamURL applies environment substitution and
+// upstream.password_from_env to the upstream URL.
+func (c *Config) resolveUpstreamURL() error {
+	if c.Upstream.URL == "" {
+		return nil
+	}
+	c.rawUpstreamURL = c.Upstream.URL
+
+	resolved := ExpandEnvInURL(c.Upstream.URL)
+	if c.Upstream.PasswordFromEnv != "" {
+		var err error
+		if resolved, err = URLWithPasswordFromEnv(resolved, c.Upstream.PasswordFromEnv); err != nil {
+			return err
+		}
+	}
+
+	c.Upstream.URL = resolved
+	c.resolvedUpstreamURL = resolved
+	return nil
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// CurrentVersion is the config file format written by Save. Files without a
+// version field are version 0 if they use the flat keys of early releases, and
+// version 1 otherwise.
+const CurrentVersion = 2
+
+// migration converts a config file's settings from one version to the next.
+type migration func(old map[string]interface{}) (map[string]interface{}, error)
+
+// migrations[v] migrates version v to version v+1.
+var migrations = []migration{
+	migrateV0toV1,
+	migrateV1toV2,
+}
+
+// legacyKeys maps the flat keys of version 0 config files to their
+// sectioned replacements.
+var legacyKeys = map[string]string{
+	"upstream_url":    "upstream.url",
+	"proxy_addr":      "proxy.listen_addr",
+	"max_connections": "proxy.max_connections",
+	"api_addr":        "api.listen_addr",
+	"auth_token":      "api.auth_token",
+	"data_dir":        "storage.data_dir",
+	"log_level":       "log.level",
+	"log_format":      "log.format",
+}
+
+// Change is one setting changed by Migrate. Old is nil for added settings
+// and New is nil for removed ones.
+type Change struct {
+	Key string
+	Old interface{}
+	New interface{}
+}
+
+// FileVersion returns the format version of a config file's settings.
+func FileVersion(settings map[string]interface{}) (int, error) {
+	raw, ok := settings["version"]
+	if !ok {
+		for key := range legacyKeys {
+			if _, ok := settings[key]; ok {
+				return 0, nil
+			}
+		}
+		return 1, nil
+	}
+	version, ok := raw.(int)
+	if !ok || version < 0 {
+		return 0, fmt.Errorf("invalid config version %v", raw)
+	}
+	if version > CurrentVersion {
+		return 0, errNewerVersion(version)
+	}
+	return version, nil
+}
+
+func errNewerVersion(version int) error {
+	return fmt.Errorf("config version %d is newer than this rift supports (%d); upgrade rift", version, CurrentVersion)
+}
+
+// checkVersion rejects config files that need 'rift config migrate' and
+// files written by a newer rift, instead of letting old keys be silently
+// ignored.
+func checkVersion(v *viper.Viper) error {
+	if v.InConfig("version") {
+		version := v.GetInt("version")
+		if version > CurrentVersion {
+			return errNewerVersion(version)
+		}
+		if version == CurrentVersion {
+			return nil
+		}
+	}
+	for key, replacement := range legacyKeys {
+		if v.InConfig(key) {
+			return fmt.Errorf("config file %s uses the old key %s (now %s); run 'rift config migrate' to update it",
+				v.ConfigFileUsed(), key, replacement)
+		}
+	}
+	return nil
+}
+
+// Migrate converts a config file's settings to CurrentVersion, returning
+// the migrated settings and the version they started at. settings is not
+// modified.
+func Migrate(settings map[string]interface{}) (map[string]interface{}, int, error) {
+	from, err := FileVersion(settings)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	migrated := copySettings(settings)
+	for v := from; v < CurrentVersion; v++ {
+		migrated, err = migrations[v](migrated)
+		if err != nil {
+			return nil, from, fmt.Errorf("migrating config from version %d to %d: %w", v, v+1, err)
+		}
+		migrated["version"] = v + 1
+	}
+	return migrated, from, nil
+}
+
+// migrateV0toV1 moves the flat keys of version 0 into their sections, e.g.
+// proxy_addr becomes proxy.listen_addr.
+func migrateV0toV1(old map[string]interface{}) (map[string]interface{}, error) {
+	keys := make([]string, 0, len(legacyKeys))
+	for key := range legacyKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, ok := old[key]
+		if !ok {
+			continue
+		}
+		section, name, _ := strings.Cut(legacyKeys[key], ".")
+		sub, ok := old[section].(map[string]interface{})
+		if !ok {
+			if _, exists := old[section]; exists {
+				return nil, fmt.Errorf("%s is not a section", section)
+			}
+			sub = make(map[string]interface{})
+			old[section] = sub
+		}
+		if _, exists := sub[name]; exists {
+			return nil, fmt.Errorf("both %s and %s are set; remove one", key, legacyKeys[key])
+		}
+		sub[name] = value
+		delete(old, key)
+	}
+	return old, nil
+}
+
+// migrateV1toV2 introduces the version field; the settings themselves are
+// unchanged.
+func migrateV1toV2(old map[string]interface{}) (map[string]interface{}, error) {
+	return old, nil
+}
+
+// DiffSettings returns the settings that differ between old and new, by
+// dotted key.
+func DiffSettings(old, new map[string]interface{}) []Change {
+	before := make(map[string]interface{})
+	after := make(map[string]interface{})
+	flattenSettings(old, "", before)
+	flattenSettings(new, "", after)
+
+	var changes []Change
+	for key, o := range before {
+		n, ok := after[key]
+		if !ok {
+			changes = append(changes, Change{Key: key, Old: o})
+		} else if !reflect.DeepEqual(o, n) {
+			changes = append(changes, Change{Key: key, Old: o, New: n})
+		}
+	}
+	for key, n := range after {
+		if _, ok := before[key]; !ok {
+			changes = append(changes, Change{Key: key, New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// flattenSettings records each leaf of a settings map under its dotted key.
+func flattenSettings(settings map[string]interface{}, prefix string, out map[string]interface{}) {
+	for key, value := range settings {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if sub, ok := value.(map[string]interface{}); ok {
+			flattenSettings(sub, key, out)
+			continue
+		}
+		out[key] = value
+	}
+}
+
+// copySettings deep-copies the nested maps of a settings map.
+func copySettings(settings map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		if sub, ok := value.(map[string]interface{}); ok {
+			value = copySettings(sub)
+		}
+		c[key] = value
+	}
+	return c
+}
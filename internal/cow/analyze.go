@@ -0,0 +1,101 @@
+package cow
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// AnalyzeOverlays runs VACUUM ANALYZE on each of a branch's overlay tables,
+// so the planner has fresh statistics for the merged CTEs of rewritten
+// queries after bulk writes such as a COPY.
+func (e *Engine) AnalyzeOverlays(ctx context.Context, branchName string) error {
+	if branchName == "main" {
+		return fmt.Errorf("main has no overlay to analyze")
+	}
+	if _, err := e.store.GetBranch(ctx, branchName); err != nil {
+		return fmt.Errorf("get branch: %w", err)
+	}
+
+	tables, err := e.store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return fmt.Errorf("list tracked tables: %w", err)
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+	for _, t := range tables {
+		// VACUUM can't run in a transaction, so each table is its own statement
+		stmt := fmt.Sprintf("VACUUM (ANALYZE) %s.%s", pgQuoteIdent(branchSchema), pgQuoteIdent(t.TableName))
+		if _, err := pool.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("analyze %s.%s: %w", t.SourceSchema, t.TableName, err)
+		}
+	}
+	return nil
+}
+
+// BranchesNeedingAnalyze returns the branches whose overlay tables have had
+// at least threshold rows inserted, updated or deleted since they were last
+// analyzed, according to Postgres's table statistics.
+func (e *Engine) BranchesNeedingAnalyze(ctx context.Context, threshold int64) ([]string, error) {
+	rows, err := e.store.Pool().Query(ctx,
+		`SELECT schemaname FROM pg_catalog.pg_stat_user_tables
+		 WHERE starts_with(schemaname, '_rift_branch_')
+		 GROUP BY schemaname
+		 HAVING sum(n_mod_since_analyze) >= $1`, threshold)
+	if err != nil {
+		return nil, fmt.Errorf("read overlay statistics: %w", err)
+	}
+	defer rows.Close()
+
+	stale := make(map[string]bool)
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			return nil, fmt.Errorf("scan overlay statistics: %w", err)
+		}
+		stale[schema] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(stale) == 0 {
+		return nil, nil
+	}
+
+	branches, err := e.store.ListBranches(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list branches: %w", err)
+	}
+	var names []string
+	for _, b := range branches {
+		if b.Name != "main" && stale[e.store.BranchSchemaName(b.Name)] {
+			names = append(names, b.Name)
+		}
+	}
+	return names, nil
+}
+
+// AutoAnalyze checks every interval for branches with at least threshold
+// rows changed since their last analyze and runs AnalyzeOverlays on them,
+// until ctx is done. It is best effort: a failed check or analyze is
+// retried at the next interval.
+func (e *Engine) AutoAnalyze(ctx context.Context, threshold int64, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		branches, err := e.BranchesNeedingAnalyze(ctx, threshold)
+		if err != nil {
+			continue
+		}
+		for _, name := range branches {
+			_ = e.AnalyzeOverlays(ctx, name)
+		}
+	}
+}
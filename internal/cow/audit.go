@@ -0,0 +1,41 @@
+package cow
+
+import (
+	"context"
+
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// Audited operations.
+const (
+	AuditCreate = "create"
+	AuditClone  = "clone"
+	AuditDelete = "delete"
+	AuditMerge  = "merge"
+	AuditRepair = "repair"
+)
+
+type actorKey struct{}
+
+// WithActor returns a context that attributes audited engine operations to
+// user (e.g. the OS user of the CLI or the API client).
+func WithActor(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, actorKey{}, user)
+}
+
+// ActorFromContext returns the user set with WithActor, or "".
+func ActorFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(actorKey{}).(string)
+	return user
+}
+
+// audit records a branch operation. Auditing is best effort: the operation
+// has already happened, so a failure to record it is not reported.
+func (e *Engine) audit(ctx context.Context, branchName, operation string, details map[string]any) {
+	_ = e.store.RecordAudit(context.WithoutCancel(ctx), &storage.AuditEntry{
+		BranchName: branchName,
+		Operation:  operation,
+		UserName:   ActorFromContext(ctx),
+		Details:    details,
+	})
+}
@@ -0,0 +1,132 @@
+package cow
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/riftdata/rift/internal/parser"
+)
+
+// DefaultColumnCacheTTL is how long a ColumnCache keeps a table's columns
+// unless the engine is configured otherwise.
+const DefaultColumnCacheTTL = 5 * time.Minute
+
+// ColumnCache caches IntrospectTable results by schema.table, so repeated
+// lookups of the same table don't query information_schema each time.
+// Entries expire after the TTL, and are dropped early when the engine sees
+// an ALTER TABLE for their table. It is safe for concurrent use.
+type ColumnCache struct {
+	ttl     time.Duration
+	entries sync.Map // "schema.table" -> *columnCacheEntry
+
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	now func() time.Time // for tests
+}
+
+type columnCacheEntry struct {
+	cols    []ColumnDef
+	expires time.Time
+}
+
+// ColumnCacheStats reports how well a ColumnCache is doing.
+type ColumnCacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+}
+
+// HitRate returns the fraction of lookups served from the cache, or 0
+// before the first lookup.
+func (s ColumnCacheStats) HitRate() float64 {
+	if total := s.Hits + s.Misses; total > 0 {
+		return float64(s.Hits) / float64(total)
+	}
+	return 0
+}
+
+// NewColumnCache creates a cache that keeps each table's columns for ttl.
+func NewColumnCache(ttl time.Duration) *ColumnCache {
+	return &ColumnCache{ttl: ttl, now: time.Now}
+}
+
+func columnCacheKey(schema, table string) string {
+	return schema + "." + table
+}
+
+// Get returns the cached columns of schema.table, if present and not
+// expired. Callers must not modify the returned slice.
+func (c *ColumnCache) Get(schema, table string) ([]ColumnDef, bool) {
+	key := columnCacheKey(schema, table)
+	v, ok := c.entries.Load(key)
+	if ok {
+		entry := v.(*columnCacheEntry)
+		if c.now().Before(entry.expires) {
+			c.hits.Add(1)
+			return entry.cols, true
+		}
+		c.entries.CompareAndDelete(key, v)
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+// Put caches the columns of schema.table.
+func (c *ColumnCache) Put(schema, table string, cols []ColumnDef) {
+	c.entries.Store(columnCacheKey(schema, table), &columnCacheEntry{
+		cols:    cols,
+		expires: c.now().Add(c.ttl),
+	})
+}
+
+// Invalidate drops the cached columns of schema.table.
+func (c *ColumnCache) Invalidate(schema, table string) {
+	c.entries.Delete(columnCacheKey(schema, table))
+}
+
+// CacheStats returns the cache's hit and miss counts and its number of
+// entries, expired ones included until they are next looked up.
+func (c *ColumnCache) CacheStats() ColumnCacheStats {
+	stats := ColumnCacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+	c.entries.Range(func(_, _ any) bool {
+		stats.Entries++
+		return true
+	})
+	return stats
+}
+
+// introspectTable is IntrospectTable served from the engine's column cache
+// when it is enabled.
+func (e *Engine) introspectTable(ctx context.Context, pool *pgxpool.Pool, schema, table string) ([]ColumnDef, error) {
+	if e.columnCache == nil {
+		return IntrospectTable(ctx, pool, schema, table)
+	}
+	if cols, ok := e.columnCache.Get(schema, table); ok {
+		return cols, nil
+	}
+	cols, err := IntrospectTable(ctx, pool, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	e.columnCache.Put(schema, table, cols)
+	return cols, nil
+}
+
+// invalidateColumns drops the cached columns of the tables an ALTER TABLE
+// on a branch changes: the source table and its overlay.
+func (e *Engine) invalidateColumns(ctx context.Context, branchName string, tables []parser.TableRef, searchPath []string) {
+	if e.columnCache == nil {
+		return
+	}
+	branchSchema := e.store.BranchSchemaName(branchName)
+	for _, tbl := range tables {
+		if schema, err := e.resolveSchema(ctx, tbl, searchPath); err == nil {
+			e.columnCache.Invalidate(schema, tbl.Name)
+		}
+		e.columnCache.Invalidate(branchSchema, tbl.Name)
+	}
+}
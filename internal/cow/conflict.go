@@ -0,0 +1,301 @@
+package cow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrCommitTimestampsOff is returned by DetectMergeConflicts when the
+// upstream database doesn't record commit timestamps, which conflict
+// detection needs to tell which parent rows changed since the branch was
+// created.
+var ErrCommitTimestampsOff = errors.New("conflict detection needs track_commit_timestamp = on in the upstream database")
+
+// ErrBadResolution is returned by ResolveConflicts for a resolution it
+// can't apply.
+var ErrBadResolution = errors.New("invalid conflict resolution")
+
+// MergeConflict is a row a branch changed that was also changed in the
+// parent after the branch was created, so merging the branch would
+// overwrite the parent's change.
+type MergeConflict struct {
+	SourceSchema string
+	Table        string
+
+	// PK holds the row's primary key columns. Values are Postgres's JSON
+	// encoding of the column, as in OverlayChange.
+	PK map[string]json.RawMessage
+
+	// Branch is the row on the branch, or nil if the branch deleted it.
+	Branch map[string]json.RawMessage
+
+	// Parent is the row in the parent as it is now, and ParentChangedAt
+	// when it was last changed.
+	Parent          map[string]json.RawMessage
+	ParentChangedAt time.Time
+}
+
+// Ways to resolve a MergeConflict.
+const (
+	ResolveBranch = "branch" // merge the branch's row, as without a resolution
+	ResolveParent = "parent" // keep the parent's row
+	ResolveEdit   = "edit"   // write the resolution's Values to the parent's row
+	ResolveSkip   = "skip"   // leave the row out of this merge
+)
+
+// ConflictResolution is the decision for one MergeConflict, identified by
+// its table and primary key.
+type ConflictResolution struct {
+	SourceSchema string
+	Table        string
+	PK           map[string]json.RawMessage
+	Choice       string // ResolveBranch, ResolveParent, ResolveEdit or ResolveSkip
+
+	// Values are the columns to write with ResolveEdit; columns left out
+	// keep the parent's value.
+	Values map[string]json.RawMessage
+}
+
+// Resolution returns a resolution of c with the given choice.
+func (c *MergeConflict) Resolution(choice string) ConflictResolution {
+	return ConflictResolution{SourceSchema: c.SourceSchema, Table: c.Table, PK: c.PK, Choice: choice}
+}
+
+// DetectMergeConflicts returns the rows a branch changed or deleted whose
+// parent row was also changed since the branch was created, table by table
+// in primary key order. A non-empty only limits the check to those tables,
+// as in GenerateMerge. Rows the branch left identical to the parent's are
+// not conflicts. Parent changes are dated with their commit timestamps, so
+// the upstream database must run with track_commit_timestamp on; changes
+// committed before it was turned on aren't seen.
+func (e *Engine) DetectMergeConflicts(ctx context.Context, branchName string, only []string) ([]MergeConflict, error) {
+	b, err := e.store.GetBranch(ctx, branchName)
+	if err != nil {
+		return nil, fmt.Errorf("get branch: %w", err)
+	}
+	tables, err := e.store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return nil, fmt.Errorf("list tracked tables: %w", err)
+	}
+	tables, err = filterTrackedTables(tables, only, branchName)
+	if err != nil || len(tables) == 0 {
+		return nil, err
+	}
+
+	pool := e.store.Pool()
+	var tracking string
+	if err := pool.QueryRow(ctx, "SELECT current_setting('track_commit_timestamp')").Scan(&tracking); err != nil {
+		return nil, fmt.Errorf("check track_commit_timestamp: %w", err)
+	}
+	if tracking != "on" {
+		return nil, ErrCommitTimestampsOff
+	}
+
+	branchSchema := e.store.BranchSchemaName(branchName)
+	var conflicts []MergeConflict
+	for _, t := range tables {
+		pkCols, err := e.getPKColumns(ctx, t.SourceSchema, t.TableName, branchSchema)
+		if err != nil {
+			return nil, fmt.Errorf("get PKs for %s: %w", t.TableName, err)
+		}
+		if len(pkCols) == 0 {
+			return nil, fmt.Errorf("table %s.%s has no primary key", t.SourceSchema, t.TableName)
+		}
+		tc, err := detectTableConflicts(ctx, pool, branchSchema, t.SourceSchema, t.TableName, pkCols, b.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.SourceSchema, t.TableName, err)
+		}
+		conflicts = append(conflicts, tc...)
+	}
+	return conflicts, nil
+}
+
+func detectTableConflicts(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string, pkCols []string, since time.Time) ([]MergeConflict, error) {
+	ovrTable := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(tableName)
+	srcTable := pgQuoteIdent(sourceSchema) + "." + pgQuoteIdent(tableName)
+
+	// Only the source's columns are compared, so columns the branch added
+	// don't make every row a conflict.
+	rows, err := pool.Query(ctx, fmt.Sprintf(
+		`SELECT ovr._rift_tombstone,
+		        to_jsonb(ovr) - '{_rift_tombstone,_rift_updated_at}'::text[],
+		        to_jsonb(src),
+		        pg_xact_commit_timestamp(src.xmin)
+		 FROM %s ovr
+		 JOIN %s src ON %s
+		 WHERE pg_xact_commit_timestamp(src.xmin) > $1
+		   AND (ovr._rift_tombstone OR EXISTS (
+		        SELECT 1 FROM jsonb_each(to_jsonb(src)) c
+		        WHERE to_jsonb(ovr) -> c.key IS DISTINCT FROM c.value))
+		 ORDER BY %s`,
+		ovrTable, srcTable, buildPKJoin("src", "ovr", pkCols),
+		strings.Join(prefixIdents("ovr", pkCols), ", ")), since)
+	if err != nil {
+		return nil, fmt.Errorf("find conflicts: %w", err)
+	}
+	defer rows.Close()
+
+	var conflicts []MergeConflict
+	for rows.Next() {
+		var tombstone bool
+		var branchRow, parentRow map[string]json.RawMessage
+		var changedAt time.Time
+		if err := rows.Scan(&tombstone, &branchRow, &parentRow, &changedAt); err != nil {
+			return nil, fmt.Errorf("scan conflict: %w", err)
+		}
+		c := MergeConflict{
+			SourceSchema:    sourceSchema,
+			Table:           tableName,
+			PK:              pickColumns(parentRow, pkCols),
+			Parent:          parentRow,
+			ParentChangedAt: changedAt,
+		}
+		if !tombstone {
+			c.Branch = branchRow
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, rows.Err()
+}
+
+func prefixIdents(alias string, idents []string) []string {
+	prefixed := quoteIdents(idents)
+	for i, id := range prefixed {
+		prefixed[i] = alias + "." + id
+	}
+	return prefixed
+}
+
+// ResolveConflicts applies conflict resolutions to merges generated by
+// GenerateMerge. Rows resolved to the parent's version, skipped or edited
+// are left out of their table's update and delete steps, and each edited
+// row gets a statement in EditSQL that writes its Values over the parent's
+// row. Rows resolved to the branch's version merge as usual. A resolution
+// for a table none of merges covers is an error.
+func ResolveConflicts(merges []MergeSQL, resolutions []ConflictResolution) ([]MergeSQL, error) {
+	if len(resolutions) == 0 {
+		return merges, nil
+	}
+
+	byTable := make(map[string][]ConflictResolution)
+	for _, r := range resolutions {
+		switch r.Choice {
+		case ResolveBranch, ResolveParent, ResolveSkip:
+		case ResolveEdit:
+			if len(r.Values) == 0 {
+				return nil, fmt.Errorf("%w: edit of %s.%s row %s has no values", ErrBadResolution, r.SourceSchema, r.Table, formatPK(r.PK))
+			}
+		default:
+			return nil, fmt.Errorf("%w %q: must be branch, parent, edit or skip", ErrBadResolution, r.Choice)
+		}
+		if len(r.PK) == 0 {
+			return nil, fmt.Errorf("%w: resolution for %s.%s has no primary key", ErrBadResolution, r.SourceSchema, r.Table)
+		}
+		key := r.SourceSchema + "." + r.Table
+		byTable[key] = append(byTable[key], r)
+	}
+
+	resolved := slices.Clone(merges)
+	for i := range resolved {
+		m := &resolved[i]
+		key := m.SourceSchema + "." + m.TableName
+		rs, ok := byTable[key]
+		if !ok {
+			continue
+		}
+		delete(byTable, key)
+		if err := m.resolve(rs); err != nil {
+			return nil, err
+		}
+	}
+	for key := range byTable {
+		return nil, fmt.Errorf("%w: resolution for %s, which the merge doesn't change", ErrTableNotFound, key)
+	}
+	return resolved, nil
+}
+
+// resolve applies the resolutions of m's table to it.
+func (m *MergeSQL) resolve(resolutions []ConflictResolution) error {
+	srcTable := pgQuoteIdent(m.SourceSchema) + "." + pgQuoteIdent(m.TableName)
+	pkCols := slices.Sorted(maps.Keys(resolutions[0].PK))
+
+	var kept []map[string]json.RawMessage
+	for _, r := range resolutions {
+		if !slices.Equal(slices.Sorted(maps.Keys(r.PK)), pkCols) {
+			return fmt.Errorf("%w: resolutions for %s.%s name different primary key columns", ErrBadResolution, m.SourceSchema, m.TableName)
+		}
+		if r.Choice == ResolveBranch {
+			continue
+		}
+		kept = append(kept, r.PK)
+		if r.Choice != ResolveEdit {
+			continue
+		}
+
+		var cols []string
+		for _, col := range slices.Sorted(maps.Keys(r.Values)) {
+			if !slices.Contains(pkCols, col) {
+				cols = append(cols, col)
+			}
+		}
+		if len(cols) == 0 {
+			continue
+		}
+		values, err := json.Marshal(r.Values)
+		if err != nil {
+			return fmt.Errorf("encode values of %s.%s row %s: %w", m.SourceSchema, m.TableName, formatPK(r.PK), err)
+		}
+		pk, err := json.Marshal(r.PK)
+		if err != nil {
+			return fmt.Errorf("encode primary key of %s.%s: %w", m.SourceSchema, m.TableName, err)
+		}
+		// Populating from the parent's row keeps the columns Values leaves out.
+		m.EditSQL = append(m.EditSQL, fmt.Sprintf(
+			"UPDATE %s src SET (%s) = (SELECT %s FROM jsonb_populate_record(src, %s::jsonb) r) WHERE EXISTS (SELECT 1 FROM jsonb_populate_record(NULL::%s, %s::jsonb) k WHERE %s)",
+			srcTable, strings.Join(quoteIdents(cols), ", "), strings.Join(prefixIdents("r", cols), ", "),
+			pgQuoteLiteral(string(values)), srcTable, pgQuoteLiteral(string(pk)), buildPKJoin("k", "src", pkCols)))
+	}
+
+	if len(kept) > 0 {
+		pks, err := json.Marshal(kept)
+		if err != nil {
+			return fmt.Errorf("encode primary keys of %s.%s: %w", m.SourceSchema, m.TableName, err)
+		}
+		exclude := fmt.Sprintf(" AND NOT EXISTS (SELECT 1 FROM jsonb_populate_recordset(NULL::%s, %s::jsonb) k WHERE %s)",
+			srcTable, pgQuoteLiteral(string(pks)), buildPKJoin("k", "src", pkCols))
+		if m.UpdateSQL != "" {
+			m.UpdateSQL += exclude
+		}
+		if m.DeleteSQL != "" {
+			m.DeleteSQL += exclude
+		}
+	}
+
+	if len(m.Statements) > 0 {
+		stmts := []string{"BEGIN"}
+		for _, sql := range slices.Concat([]string{m.DeleteSQL, m.UpdateSQL}, m.EditSQL, []string{m.InsertSQL}) {
+			if sql != "" {
+				stmts = append(stmts, sql)
+			}
+		}
+		m.Statements = append(stmts, "COMMIT")
+	}
+	return nil
+}
+
+// formatPK renders a primary key for messages, e.g. {"id":1}.
+func formatPK(pk map[string]json.RawMessage) string {
+	b, err := json.Marshal(pk)
+	if err != nil {
+		return fmt.Sprint(pk)
+	}
+	return string(b)
+}
@@ -0,0 +1,345 @@
+package cow
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/riftdata/rift/internal/parser"
+)
+
+var (
+	// ErrUnsupportedCopy is returned for COPY FROM STDIN variants rift can't
+	// load into an overlay.
+	ErrUnsupportedCopy = errors.New("unsupported COPY on a branch")
+
+	// ErrBadCopyData is returned when COPY data doesn't match its format or
+	// the target columns.
+	ErrBadCopyData = errors.New("invalid COPY data")
+)
+
+// copyStagingTable is the temp table COPY data is loaded into before it is
+// merged into the overlay.
+const copyStagingTable = "_rift_copy_staging"
+
+// CopyIn is a COPY ... FROM STDIN on a branch, prepared by the engine. The
+// router streams the client's data into Load.
+type CopyIn struct {
+	// Overlay is the qualified overlay table the rows are loaded into.
+	Overlay string
+
+	// Columns are the loaded columns in data order, and Types their types
+	// as format_type prints them.
+	Columns []string
+	Types   []string
+
+	PKColumns []string
+	Options   parser.CopyInfo
+}
+
+// processCopyIn prepares a COPY ... FROM STDIN on a branch: the overlay is
+// created if needed and the target columns resolved, but no data is read.
+func (e *Engine) processCopyIn(ctx context.Context, branchName, sql string, info *parser.CopyInfo, searchPath []string) (*ProcessedQuery, error) {
+	if info.Format != "csv" {
+		return nil, fmt.Errorf("%w: FORMAT %s (only csv is supported)", ErrUnsupportedCopy, info.Format)
+	}
+	for name, val := range map[string]string{"delimiter": info.Delimiter, "quote": info.Quote, "escape": info.Escape} {
+		if len(val) != 1 {
+			return nil, fmt.Errorf("%w: COPY %s must be a single one-byte character", ErrUnsupportedCopy, name)
+		}
+	}
+	if err := e.checkWritable(ctx, branchName); err != nil {
+		return nil, err
+	}
+
+	schema, err := e.resolveSchema(ctx, info.Table, searchPath)
+	if err != nil {
+		return nil, err
+	}
+	target := &parser.ParsedQuery{Type: parser.QueryInsert, Tables: []parser.TableRef{info.Table}}
+	if err := e.ensureOverlays(ctx, branchName, target, searchPath); err != nil {
+		return nil, fmt.Errorf("ensure overlays: %w", err)
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+	types, err := columnTypes(ctx, pool, branchSchema, info.Table.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	copyIn := &CopyIn{
+		Overlay: pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(info.Table.Name),
+		Options: *info,
+	}
+	if len(info.Columns) > 0 {
+		for _, col := range info.Columns {
+			typ, ok := types.get(col)
+			if !ok {
+				return nil, fmt.Errorf("column %q of relation %q does not exist", col, info.Table.Name)
+			}
+			copyIn.Columns = append(copyIn.Columns, col)
+			copyIn.Types = append(copyIn.Types, typ)
+		}
+	} else {
+		for _, col := range types.order {
+			if strings.HasPrefix(col, "_rift_") {
+				continue
+			}
+			copyIn.Columns = append(copyIn.Columns, col)
+			copyIn.Types = append(copyIn.Types, types.byName[col])
+		}
+	}
+
+	copyIn.PKColumns, err = GetTablePrimaryKeys(ctx, pool, schema, info.Table.Name)
+	if err != nil {
+		return nil, fmt.Errorf("get PKs for %s: %w", info.Table.Name, err)
+	}
+
+	return &ProcessedQuery{
+		OriginalSQL:  sql,
+		RewrittenSQL: sql,
+		Type:         parser.QueryUtility,
+		NeedsOverlay: true,
+		TableName:    info.Table.Name,
+		CopyIn:       copyIn,
+	}, nil
+}
+
+// Load reads COPY data from r and upserts it into the overlay within tx,
+// returning the number of rows loaded. The rows are parsed here, copied into
+// a staging table, and merged from there, since COPY can't upsert: a row
+// whose primary key is already in the overlay replaces it.
+func (c *CopyIn) Load(ctx context.Context, tx pgx.Tx, r io.Reader) (int64, error) {
+	staging := make([]string, len(c.Columns))
+	for i, col := range c.Columns {
+		staging[i] = pgQuoteIdent(col) + " text"
+	}
+	if _, err := tx.Exec(ctx, "DROP TABLE IF EXISTS pg_temp."+copyStagingTable); err != nil {
+		return 0, fmt.Errorf("drop staging table: %w", err)
+	}
+	if _, err := tx.Exec(ctx, fmt.Sprintf("CREATE TEMP TABLE %s (%s) ON COMMIT DROP",
+		copyStagingTable, strings.Join(staging, ", "))); err != nil {
+		return 0, fmt.Errorf("create staging table: %w", err)
+	}
+
+	reader := newCSVReader(r, &c.Options)
+	if c.Options.Header {
+		if _, err := reader.Read(); err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+	}
+	rows := pgx.CopyFromFunc(func() ([]any, error) {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) != len(c.Columns) {
+			return nil, fmt.Errorf("%w: line %d has %d columns, want %d",
+				ErrBadCopyData, reader.line, len(record), len(c.Columns))
+		}
+		row := make([]any, len(record))
+		for i, v := range record {
+			if v != nil {
+				row[i] = *v
+			}
+		}
+		return row, nil
+	})
+	n, err := tx.CopyFrom(ctx, pgx.Identifier{copyStagingTable}, c.Columns, rows)
+	if err != nil {
+		return 0, fmt.Errorf("copy rows: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, c.upsertSQL()); err != nil {
+		return 0, fmt.Errorf("merge rows: %w", err)
+	}
+	if _, err := tx.Exec(ctx, "DROP TABLE pg_temp."+copyStagingTable); err != nil {
+		return 0, fmt.Errorf("drop staging table: %w", err)
+	}
+	return n, nil
+}
+
+// upsertSQL returns the statement that merges the staging table into the
+// overlay, casting each text column to its type.
+func (c *CopyIn) upsertSQL() string {
+	cols := quoteIdents(c.Columns)
+	values := make([]string, len(cols))
+	for i, col := range cols {
+		values[i] = fmt.Sprintf("CAST(%s AS %s)", col, c.Types[i])
+	}
+
+	sql := fmt.Sprintf("INSERT INTO %s (%s, _rift_tombstone)\nSELECT %s, false FROM pg_temp.%s",
+		c.Overlay, strings.Join(cols, ", "), strings.Join(values, ", "), copyStagingTable)
+	if len(c.PKColumns) > 0 {
+		setClauses := make([]string, 0, len(cols)+1)
+		for _, col := range cols {
+			setClauses = append(setClauses, fmt.Sprintf("%s = EXCLUDED.%s", col, col))
+		}
+		setClauses = append(setClauses, "_rift_tombstone = false")
+		sql += fmt.Sprintf("\nON CONFLICT (%s) DO UPDATE SET %s",
+			strings.Join(quoteIdents(c.PKColumns), ", "), strings.Join(setClauses, ", "))
+	}
+	return sql
+}
+
+// tableColumnTypes maps a table's columns to their types, and keeps their
+// order.
+type tableColumnTypes struct {
+	order  []string
+	byName map[string]string
+}
+
+func (t tableColumnTypes) get(col string) (string, bool) {
+	typ, ok := t.byName[col]
+	return typ, ok
+}
+
+// columnTypes returns the types of a table's columns, skipping generated
+// columns, which COPY can't load.
+func columnTypes(ctx context.Context, pool *pgxpool.Pool, schema, table string) (tableColumnTypes, error) {
+	types := tableColumnTypes{byName: make(map[string]string)}
+	rows, err := pool.Query(ctx,
+		`SELECT a.attname, format_type(a.atttypid, a.atttypmod)
+		 FROM pg_attribute a
+		 JOIN pg_class c ON c.oid = a.attrelid
+		 JOIN pg_namespace n ON n.oid = c.relnamespace
+		 WHERE n.nspname = $1 AND c.relname = $2
+		   AND a.attnum > 0 AND NOT a.attisdropped AND a.attgenerated = ''
+		 ORDER BY a.attnum`, schema, table)
+	if err != nil {
+		return types, fmt.Errorf("column types: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return types, fmt.Errorf("scan column type: %w", err)
+		}
+		types.order = append(types.order, name)
+		types.byName[name] = typ
+	}
+	if err := rows.Err(); err != nil {
+		return types, err
+	}
+	if len(types.order) == 0 {
+		return types, fmt.Errorf("%w: %s.%s", ErrTableNotFound, schema, table)
+	}
+	return types, nil
+}
+
+// csvReader reads records in Postgres's CSV format. Unlike encoding/csv it
+// tells quoted empty strings from unquoted ones, which COPY's NULL option
+// depends on, and supports COPY's QUOTE and ESCAPE characters.
+type csvReader struct {
+	r                    *bufio.Reader
+	delim, quote, escape byte
+	null                 string
+	line                 int
+}
+
+func newCSVReader(r io.Reader, opts *parser.CopyInfo) *csvReader {
+	return &csvReader{
+		r:      bufio.NewReader(r),
+		delim:  opts.Delimiter[0],
+		quote:  opts.Quote[0],
+		escape: opts.Escape[0],
+		null:   opts.Null,
+	}
+}
+
+// Read returns the next record; a nil field is SQL NULL. It returns io.EOF
+// at the end of the data or at a `\.` end-of-data line.
+func (c *csvReader) Read() ([]*string, error) {
+	var (
+		record   []*string
+		field    []byte
+		quoted   bool // the field had quotes, so it is never NULL
+		inQuotes bool
+		started  bool
+	)
+	c.line++
+	endField := func() {
+		v := string(field)
+		if quoted || v != c.null {
+			record = append(record, &v)
+		} else {
+			record = append(record, nil)
+		}
+		field, quoted = field[:0], false
+	}
+	endRecord := func() ([]*string, error) {
+		wasQuoted := quoted
+		endField()
+		if len(record) == 1 && !wasQuoted && record[0] != nil && *record[0] == `\.` {
+			return nil, io.EOF
+		}
+		return record, nil
+	}
+
+	for {
+		b, err := c.r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			if inQuotes {
+				return nil, fmt.Errorf("%w: unterminated CSV quoted field on line %d", ErrBadCopyData, c.line)
+			}
+			if !started {
+				return nil, io.EOF
+			}
+			return endRecord()
+		}
+		if err != nil {
+			return nil, err
+		}
+		started = true
+
+		if inQuotes {
+			switch b {
+			case c.escape:
+				if next, err := c.r.Peek(1); err == nil && (next[0] == c.quote || next[0] == c.escape) {
+					field = append(field, next[0])
+					_, _ = c.r.ReadByte()
+					continue
+				}
+				if c.escape != c.quote {
+					field = append(field, b)
+					continue
+				}
+				inQuotes = false
+			case c.quote:
+				inQuotes = false
+			default:
+				if b == '\n' {
+					c.line++
+				}
+				field = append(field, b)
+			}
+			continue
+		}
+
+		switch b {
+		case c.quote:
+			inQuotes, quoted = true, true
+		case c.delim:
+			endField()
+		case '\r':
+			if next, err := c.r.Peek(1); err == nil && next[0] == '\n' {
+				_, _ = c.r.ReadByte()
+			}
+			return endRecord()
+		case '\n':
+			return endRecord()
+		default:
+			field = append(field, b)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package cow
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AuditCopyOverlay is the audit log operation recorded by CopyOverlayTable.
+const AuditCopyOverlay = "copy_overlay"
+
+// CopyOverlayTable gives targetBranch the same changes to one table as
+// sourceBranch, e.g. seed data two feature branches both need. The target's
+// overlay of the table is created and tracked if needed, emptied, and filled
+// with the source overlay's rows, tombstones included, so any changes the
+// target had made to the table are replaced. tableName is "table" or
+// "schema.table" and must be tracked by sourceBranch. The target's other
+// tables are left as they are.
+func (e *Engine) CopyOverlayTable(ctx context.Context, sourceBranch, targetBranch, tableName string) error {
+	if sourceBranch == targetBranch {
+		return fmt.Errorf("cannot copy an overlay of branch %q onto itself", sourceBranch)
+	}
+	if targetBranch == "main" {
+		return fmt.Errorf("main has no overlay to copy into")
+	}
+
+	if _, err := e.store.GetBranch(ctx, sourceBranch); err != nil {
+		return fmt.Errorf("source branch: %w", err)
+	}
+	target, err := e.store.GetBranch(ctx, targetBranch)
+	if err != nil {
+		return fmt.Errorf("target branch: %w", err)
+	}
+	if target.Protected {
+		return fmt.Errorf("target branch %q: %w", targetBranch, ErrBranchProtected)
+	}
+	if target.Frozen {
+		return fmt.Errorf("target branch %q: %w", targetBranch, ErrBranchFrozen)
+	}
+
+	tables, err := e.store.ListTrackedTables(ctx, sourceBranch, "")
+	if err != nil {
+		return fmt.Errorf("list tracked tables: %w", err)
+	}
+	tables, err = filterTrackedTables(tables, []string{tableName}, sourceBranch)
+	if err != nil {
+		return err
+	}
+	if len(tables) > 1 {
+		return fmt.Errorf("%s is tracked in more than one schema by branch %s; use schema.table", tableName, sourceBranch)
+	}
+	t := tables[0]
+
+	pool := e.store.Pool()
+	fromSchema := e.store.BranchSchemaName(sourceBranch)
+	toSchema := e.store.BranchSchemaName(targetBranch)
+	if err := e.store.CreateBranchSchema(ctx, targetBranch); err != nil {
+		return fmt.Errorf("create overlay schema: %w", err)
+	}
+	if err := e.ensureTargetOverlay(ctx, pool, toSchema, targetBranch, t); err != nil {
+		return fmt.Errorf("prepare %s in %s: %w", t.TableName, targetBranch, err)
+	}
+
+	// Copy by name: the overlays' columns needn't be in the same order, and
+	// columns added on the source branch must exist on the target too.
+	fromCols, err := IntrospectTable(ctx, pool, fromSchema, t.TableName)
+	if err != nil {
+		return fmt.Errorf("introspect %s overlay: %w", sourceBranch, err)
+	}
+	toCols, err := IntrospectTable(ctx, pool, toSchema, t.TableName)
+	if err != nil {
+		return fmt.Errorf("introspect %s overlay: %w", targetBranch, err)
+	}
+	cols := make([]string, len(fromCols))
+	for i, c := range fromCols {
+		if !slices.ContainsFunc(toCols, func(tc ColumnDef) bool { return tc.Name == c.Name }) {
+			return fmt.Errorf("%s.%s has column %q on %s but not on %s", t.SourceSchema, t.TableName, c.Name, sourceBranch, targetBranch)
+		}
+		cols[i] = pgQuoteIdent(c.Name)
+	}
+	colList := strings.Join(cols, ", ")
+	fromTable := pgQuoteIdent(fromSchema) + "." + pgQuoteIdent(t.TableName)
+	toTable := pgQuoteIdent(toSchema) + "." + pgQuoteIdent(t.TableName)
+
+	// TRUNCATE doesn't fire row triggers, so delete the rows instead when
+	// they have to come off the target's delta_size.
+	clearSQL := "TRUNCATE " + toTable
+	if e.trackDeltaSize {
+		clearSQL = "DELETE FROM " + toTable
+	}
+	var rows int64
+	err = pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, clearSQL); err != nil {
+			return fmt.Errorf("clear %s overlay: %w", targetBranch, err)
+		}
+		tag, err := tx.Exec(ctx, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", toTable, colList, colList, fromTable))
+		if err != nil {
+			return fmt.Errorf("copy overlay rows: %w", err)
+		}
+		rows = tag.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	e.audit(ctx, targetBranch, AuditCopyOverlay, map[string]any{
+		"source": sourceBranch,
+		"schema": t.SourceSchema,
+		"table":  t.TableName,
+		"rows":   rows,
+	})
+	return nil
+}
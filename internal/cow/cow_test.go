@@ -1,7 +1,21 @@
 package cow
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/riftdata/rift/internal/parser"
+	"github.com/riftdata/rift/internal/storage"
+	"github.com/riftdata/rift/internal/testutil"
 )
 
 func TestPgQuoteIdent(t *testing.T) {
@@ -101,6 +115,75 @@ func TestBranchDiffTotalChangesEmpty(t *testing.T) {
 	}
 }
 
+func TestCommonAncestor(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want string
+	}{
+		{[]string{"feat-a", "staging", "main"}, []string{"feat-b", "staging", "main"}, "staging"},
+		{[]string{"feat-a", "main"}, []string{"feat-b", "staging", "main"}, "main"},
+		{[]string{"child", "parent", "main"}, []string{"parent", "main"}, "parent"},
+		{[]string{"main"}, []string{"feat", "main"}, "main"},
+		{[]string{"orphan"}, []string{"feat", "main"}, ""},
+	}
+	for _, tt := range tests {
+		if got := commonAncestor(tt.a, tt.b); got != tt.want {
+			t.Errorf("commonAncestor(%v, %v) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMergedViewSQL(t *testing.T) {
+	src := mergedViewSQL("", "public", "users", []string{"id", "name"}, []string{"id"})
+	if src != `SELECT src."id", src."name" FROM "public"."users" src` {
+		t.Errorf("source view = %q", src)
+	}
+
+	merged := mergedViewSQL("_rift_branch_dev", "public", "users", []string{"id", "name"}, []string{"id"})
+	for _, want := range []string{
+		`FROM "_rift_branch_dev"."users" ovr WHERE NOT ovr._rift_tombstone`,
+		"UNION ALL",
+		`ovr."id" = src."id"`,
+	} {
+		if !strings.Contains(merged, want) {
+			t.Errorf("merged view missing %q:\n%s", want, merged)
+		}
+	}
+}
+
+func TestRowChangeKeyAndChangedColumns(t *testing.T) {
+	str := func(s string) *string { return &s }
+	d := &TableDiffDetailed{
+		Columns:   []string{"name", "id", "email"},
+		PKColumns: []string{"id"},
+	}
+
+	update := RowChange{
+		Change: ChangeUpdate,
+		Before: []*string{str("alice"), str("1"), nil},
+		After:  []*string{str("alicia"), str("1"), nil},
+	}
+	if key := update.Key(d); len(key) != 1 || *key[0] != "1" {
+		t.Errorf("Key() = %v, want [1]", key)
+	}
+	if got := update.ChangedColumns(); len(got) != 1 || got[0] != 0 {
+		t.Errorf("ChangedColumns() = %v, want [0]", got)
+	}
+
+	update.After[2] = str("a@example.com")
+	if got := update.ChangedColumns(); len(got) != 2 || got[1] != 2 {
+		t.Errorf("ChangedColumns() = %v, want [0 2]", got)
+	}
+
+	del := RowChange{Change: ChangeDelete, Before: []*string{str("bob"), str("2"), nil}}
+	if key := del.Key(d); len(key) != 1 || *key[0] != "2" {
+		t.Errorf("Key() = %v, want [2]", key)
+	}
+	if got := del.ChangedColumns(); got != nil {
+		t.Errorf("ChangedColumns() = %v, want nil for delete", got)
+	}
+}
+
 func TestFormatMergeSQL(t *testing.T) {
 	m := &MergeSQL{
 		Statements: []string{"BEGIN", "DELETE FROM public.users WHERE id=1", "COMMIT"},
@@ -114,6 +197,147 @@ func TestFormatMergeSQL(t *testing.T) {
 	}
 }
 
+func TestMergePlanStatementsOrder(t *testing.T) {
+	merges := []MergeSQL{
+		{TableName: "users", DeleteSQL: "DEL users", UpdateSQL: "UPD users", InsertSQL: "INS users"},
+		{TableName: "orders", DeleteSQL: "DEL orders", UpdateSQL: "UPD orders", InsertSQL: "INS orders"},
+	}
+
+	got := MergePlanStatements(merges)
+	want := []string{
+		"BEGIN",
+		"UPD users", "INS users",
+		"UPD orders", "INS orders",
+		"DEL orders", "DEL users",
+		"COMMIT",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("MergePlanStatements() = %v, want %v", got, want)
+	}
+}
+
+func TestMergePlanStatementsMigrationsFirst(t *testing.T) {
+	merges := []MergeSQL{
+		{TableName: "users", DeleteSQL: "DEL users", UpdateSQL: "UPD users", InsertSQL: "INS users"},
+	}
+
+	got := MergePlanStatements(merges, "ALTER TABLE users ADD COLUMN age int;\n", "CREATE INDEX ON users (age)")
+	want := []string{
+		"BEGIN",
+		"ALTER TABLE users ADD COLUMN age int",
+		"CREATE INDEX ON users (age)",
+		"UPD users", "INS users", "DEL users",
+		"COMMIT",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("MergePlanStatements() = %v, want %v", got, want)
+	}
+}
+
+func TestMergePlanStatementsSkipsEmptySteps(t *testing.T) {
+	// Overlay-to-overlay merges have no separate insert step
+	merges := []MergeSQL{
+		{TableName: "users", DeleteSQL: "TOMB users", UpdateSQL: "UPSERT users"},
+	}
+
+	got := MergePlanStatements(merges)
+	want := []string{"BEGIN", "UPSERT users", "TOMB users", "COMMIT"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("MergePlanStatements() = %v, want %v", got, want)
+	}
+}
+
+func TestResolveConflicts(t *testing.T) {
+	merges := []MergeSQL{
+		{TableName: "users", SourceSchema: "public", DeleteSQL: "DEL users", UpdateSQL: "UPD users", InsertSQL: "INS users",
+			Statements: []string{"BEGIN", "DEL users", "UPD users", "INS users", "COMMIT"}},
+		{TableName: "orders", SourceSchema: "public", UpdateSQL: "UPD orders"},
+	}
+	pk := func(id string) map[string]json.RawMessage {
+		return map[string]json.RawMessage{"id": json.RawMessage(id)}
+	}
+	resolutions := []ConflictResolution{
+		{SourceSchema: "public", Table: "users", PK: pk("1"), Choice: ResolveParent},
+		{SourceSchema: "public", Table: "users", PK: pk("2"), Choice: ResolveBranch},
+		{SourceSchema: "public", Table: "users", PK: pk("3"), Choice: ResolveEdit,
+			Values: map[string]json.RawMessage{"id": json.RawMessage("3"), "name": json.RawMessage(`"o'neil"`)}},
+	}
+
+	got, err := ResolveConflicts(merges, resolutions)
+	if err != nil {
+		t.Fatalf("ResolveConflicts: %v", err)
+	}
+	exclude := ` AND NOT EXISTS (SELECT 1 FROM jsonb_populate_recordset(NULL::"public"."users", '[{"id":1},{"id":3}]'::jsonb) k WHERE k."id" = src."id")`
+	if got[0].UpdateSQL != "UPD users"+exclude || got[0].DeleteSQL != "DEL users"+exclude || got[0].InsertSQL != "INS users" {
+		t.Errorf("resolved users steps = %q, %q, %q", got[0].DeleteSQL, got[0].UpdateSQL, got[0].InsertSQL)
+	}
+	wantEdit := `UPDATE "public"."users" src SET ("name") = (SELECT r."name" FROM jsonb_populate_record(src, '{"id":3,"name":"o''neil"}'::jsonb) r)` +
+		` WHERE EXISTS (SELECT 1 FROM jsonb_populate_record(NULL::"public"."users", '{"id":3}'::jsonb) k WHERE k."id" = src."id")`
+	if len(got[0].EditSQL) != 1 || got[0].EditSQL[0] != wantEdit {
+		t.Errorf("EditSQL = %q, want [%q]", got[0].EditSQL, wantEdit)
+	}
+	if n := len(got[0].Statements); n != 6 || got[0].Statements[3] != wantEdit {
+		t.Errorf("Statements = %q, want the edit after the update", got[0].Statements)
+	}
+	if !reflect.DeepEqual(got[1], merges[1]) || merges[0].UpdateSQL != "UPD users" {
+		t.Error("ResolveConflicts changed a table without resolutions or its input")
+	}
+	if steps := MergePlanStatements(got); steps[2] != wantEdit {
+		t.Errorf("MergePlanStatements() = %q, want the edit after the users update", steps)
+	}
+
+	for _, bad := range []ConflictResolution{
+		{SourceSchema: "public", Table: "users", PK: pk("1"), Choice: "theirs"},
+		{SourceSchema: "public", Table: "users", PK: pk("1"), Choice: ResolveEdit},
+		{SourceSchema: "public", Table: "users", Choice: ResolveParent},
+	} {
+		if _, err := ResolveConflicts(merges, []ConflictResolution{bad}); !errors.Is(err, ErrBadResolution) {
+			t.Errorf("ResolveConflicts(%+v) = %v, want ErrBadResolution", bad, err)
+		}
+	}
+	if _, err := ResolveConflicts(merges, []ConflictResolution{{SourceSchema: "public", Table: "items", PK: pk("1"), Choice: ResolveSkip}}); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("ResolveConflicts for a table not merged = %v, want ErrTableNotFound", err)
+	}
+}
+
+func TestSortByDependencies(t *testing.T) {
+	tables := []string{"public.order_items", "public.orders", "public.users", "public.audit"}
+	deps := map[string][]string{
+		"public.order_items": {"public.orders", "public.products"}, // products not in the branch
+		"public.orders":      {"public.users", "public.orders"},    // self-reference ignored
+	}
+
+	got, cyclic := sortByDependencies(tables, deps)
+	if len(cyclic) != 0 {
+		t.Errorf("cyclic = %v, want none", cyclic)
+	}
+	pos := make(map[string]int, len(got))
+	for i, tbl := range got {
+		pos[tbl] = i
+	}
+	if len(got) != len(tables) {
+		t.Fatalf("expected %d tables, got %v", len(tables), got)
+	}
+	if pos["public.users"] > pos["public.orders"] || pos["public.orders"] > pos["public.order_items"] {
+		t.Errorf("referenced tables must come first, got %v", got)
+	}
+}
+
+func TestSortByDependenciesCycle(t *testing.T) {
+	tables := []string{"b", "a", "c", "d"}
+	deps := map[string][]string{"a": {"b"}, "b": {"a"}, "d": {"a"}}
+
+	// d isn't in the cycle but can't be placed before it either
+	got, cyclic := sortByDependencies(tables, deps)
+	want := []string{"c", "a", "b", "d"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("sortByDependencies() = %v, want %v", got, want)
+	}
+	if strings.Join(cyclic, ",") != "a,b,d" {
+		t.Errorf("cyclic = %v, want a, b and d", cyclic)
+	}
+}
+
 func TestProcessedQueryTypes(t *testing.T) {
 	// Verify the ProcessedQuery struct fields work correctly
 	pq := &ProcessedQuery{
@@ -134,3 +358,823 @@ func TestProcessedQueryTypes(t *testing.T) {
 		t.Errorf("TableName = %q, want %q", pq.TableName, "users")
 	}
 }
+
+func TestUsableSchemas(t *testing.T) {
+	got := usableSchemas([]string{"$user", "app", "pg_catalog", "_rift_branch_dev", "public"})
+	want := []string{"app", "public"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("element %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+func TestTableStatsRatios(t *testing.T) {
+	s := &TableStats{OverlayRows: 200, Tombstones: 50, SourceSize: 8192, OverlaySize: 2048}
+	if got := s.TombstoneRatio(); got != 25 {
+		t.Errorf("TombstoneRatio() = %v, want 25", got)
+	}
+	if got := s.CompressionRatio(); got != 4 {
+		t.Errorf("CompressionRatio() = %v, want 4", got)
+	}
+
+	empty := &TableStats{}
+	if empty.TombstoneRatio() != 0 || empty.CompressionRatio() != 0 {
+		t.Error("expected zero ratios for empty stats")
+	}
+}
+
+func TestBranchStatsTotal(t *testing.T) {
+	b := &BranchStats{Tables: []TableStats{
+		{OverlayRows: 10, Tombstones: 1, SourceSize: 100, OverlaySize: 10, MergeSQLSize: 5},
+		{OverlayRows: 20, Tombstones: 2, SourceSize: 200, OverlaySize: 20, MergeSQLSize: 7},
+	}}
+	total := b.Total()
+	if total.OverlayRows != 30 || total.Tombstones != 3 || total.SourceSize != 300 ||
+		total.OverlaySize != 30 || total.MergeSQLSize != 12 {
+		t.Errorf("Total() = %+v", total)
+	}
+}
+
+func TestTimeoutSettings(t *testing.T) {
+	if got := timeoutSettings(0); got != nil {
+		t.Errorf("timeoutSettings(0) = %v, want nil", got)
+	}
+
+	got := timeoutSettings(30 * time.Second)
+	want := []string{
+		"SET LOCAL statement_timeout = '30000ms'",
+		"SET LOCAL lock_timeout = '15000ms'",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("timeoutSettings(30s) = %v, want %v", got, want)
+	}
+}
+
+func TestIsTimeout(t *testing.T) {
+	if !isTimeout(&pgconn.PgError{Code: "57014"}) {
+		t.Error("statement timeout should be a timeout")
+	}
+	if !isTimeout(&pgconn.PgError{Code: "55P03"}) {
+		t.Error("lock timeout should be a timeout")
+	}
+	if !isTimeout(fmt.Errorf("exec: %w", context.DeadlineExceeded)) {
+		t.Error("deadline exceeded should be a timeout")
+	}
+	if isTimeout(&pgconn.PgError{Code: "23505"}) {
+		t.Error("unique violation is not a timeout")
+	}
+}
+
+func TestIsStructuralError(t *testing.T) {
+	if !isStructuralError(fmt.Errorf("exec: %w", &pgconn.PgError{Code: "42703"})) {
+		t.Error("undefined column should be structural")
+	}
+	if !isStructuralError(&pgconn.PgError{Code: "42P10"}) {
+		t.Error("ON CONFLICT without a matching constraint should be structural")
+	}
+	if isStructuralError(&pgconn.PgError{Code: "23505"}) || isStructuralError(nil) {
+		t.Error("unique violation and nil are not structural")
+	}
+}
+
+func TestVerificationError(t *testing.T) {
+	v := VerificationError{Table: "public.users", Before: 10, After: 13, Inserts: 3, Deletes: 1}
+	if got := v.Expected(); got != 12 {
+		t.Errorf("Expected() = %d, want 12", got)
+	}
+	want := "public.users has 13 rows after the merge, expected 12 (10 before, 3 inserted, 1 deleted)"
+	if v.Error() != want {
+		t.Errorf("Error() = %q, want %q", v.Error(), want)
+	}
+	if (&VerificationResult{}).OK() != true || (&VerificationResult{Errors: []VerificationError{v}}).OK() {
+		t.Error("OK() should report whether there are no errors")
+	}
+}
+
+func TestCompareColumns(t *testing.T) {
+	source := []ColumnDef{
+		{Name: "id", DataType: "integer"},
+		{Name: "email", DataType: "text"},
+		{Name: "age", DataType: "integer"},
+	}
+	overlay := []ColumnDef{
+		{Name: "id", DataType: "integer"},
+		{Name: "age", DataType: "bigint"},
+		{Name: "nickname", DataType: "text"},
+		{Name: "_rift_tombstone", DataType: "boolean"},
+	}
+
+	errs := compareColumns("public", "users", source, overlay)
+	if len(errs) != 3 {
+		t.Fatalf("expected 3 validation errors, got %d: %v", len(errs), errs)
+	}
+
+	want := []struct {
+		column     string
+		kind       DriftKind
+		repairable bool
+	}{
+		{"email", DriftMissingColumn, true},
+		{"age", DriftTypeMismatch, false},
+		{"nickname", DriftExtraColumn, false},
+	}
+	for i, w := range want {
+		if errs[i].Column != w.column || errs[i].Kind != w.kind || errs[i].Repairable != w.repairable {
+			t.Errorf("errs[%d] = %+v, want column %q kind %s repairable %v", i, errs[i], w.column, w.kind, w.repairable)
+		}
+	}
+
+	if msg := errs[1].Error(); !strings.Contains(msg, "integer") || !strings.Contains(msg, "bigint") {
+		t.Errorf("type mismatch message should mention both types, got %q", msg)
+	}
+}
+
+func TestCompareColumnsNoDrift(t *testing.T) {
+	cols := []ColumnDef{{Name: "id", DataType: "integer"}}
+	overlay := append(cols, ColumnDef{Name: "_rift_tombstone", DataType: "boolean"})
+	if errs := compareColumns("public", "t", cols, overlay); len(errs) != 0 {
+		t.Errorf("expected no drift, got %v", errs)
+	}
+}
+
+func TestPartitionOverlaySQL(t *testing.T) {
+	p := Partition{
+		Schema: "public",
+		Name:   "orders_2024",
+		Bound:  "FOR VALUES FROM ('2024-01-01') TO ('2025-01-01')",
+	}
+
+	got := partitionOverlaySQL("_rift_branch_dev", "orders", p, false)
+	want := `CREATE TABLE "_rift_branch_dev"."orders_2024" PARTITION OF "_rift_branch_dev"."orders" FOR VALUES FROM ('2024-01-01') TO ('2025-01-01')`
+	if got != want {
+		t.Errorf("create:\n got %s\nwant %s", got, want)
+	}
+
+	got = partitionOverlaySQL("_rift_branch_dev", "orders", p, true)
+	want = `ALTER TABLE "_rift_branch_dev"."orders" ATTACH PARTITION "_rift_branch_dev"."orders_2024" FOR VALUES FROM ('2024-01-01') TO ('2025-01-01')`
+	if got != want {
+		t.Errorf("attach:\n got %s\nwant %s", got, want)
+	}
+
+	sub := Partition{Schema: "public", Name: "orders_eu", Bound: "FOR VALUES IN ('eu')", Key: "RANGE (created_at)"}
+	got = partitionOverlaySQL("_rift_branch_dev", "orders", sub, false)
+	if !strings.HasSuffix(got, "FOR VALUES IN ('eu') PARTITION BY RANGE (created_at)") {
+		t.Errorf("sub-partitioned overlay should keep its key, got %s", got)
+	}
+
+	def := Partition{Schema: "public", Name: "orders_other", Bound: "DEFAULT"}
+	got = partitionOverlaySQL("_rift_branch_dev", "orders", def, false)
+	if !strings.HasSuffix(got, `PARTITION OF "_rift_branch_dev"."orders" DEFAULT`) {
+		t.Errorf("default partition, got %s", got)
+	}
+}
+
+func TestRebaseBackupSchema(t *testing.T) {
+	got := rebaseBackupSchema("_rift_branch_feature_auth")
+	if got != "_rift_rebase_feature_auth" {
+		t.Errorf("rebaseBackupSchema = %q, want %q", got, "_rift_rebase_feature_auth")
+	}
+}
+
+func TestOverlayPrivilegeStatements(t *testing.T) {
+	revoke := overlayPrivilegeStatements("_rift_branch_dev", "users", true)
+	want := []string{
+		`REVOKE INSERT, UPDATE, DELETE ON "_rift_branch_dev"."users" FROM PUBLIC`,
+		`REVOKE INSERT, UPDATE, DELETE ON "_rift_branch_dev"."users" FROM CURRENT_USER`,
+	}
+	if strings.Join(revoke, ";") != strings.Join(want, ";") {
+		t.Errorf("revoke statements = %q, want %q", revoke, want)
+	}
+
+	grant := overlayPrivilegeStatements("_rift_branch_dev", "users", false)
+	if len(grant) != 1 || grant[0] != `GRANT INSERT, UPDATE, DELETE ON "_rift_branch_dev"."users" TO CURRENT_USER` {
+		t.Errorf("grant statements = %q", grant)
+	}
+}
+
+func TestMatchesTable(t *testing.T) {
+	users := QualifiedTable{Schema: "public", Name: "users"}
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"", true},
+		{"users", true},
+		{"public.users", true},
+		{"billing.users", false},
+		{"orders", false},
+	}
+	for _, tt := range tests {
+		if got := matchesTable(tt.filter, users); got != tt.want {
+			t.Errorf("matchesTable(%q) = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestResultCacheKey(t *testing.T) {
+	a := ResultCacheKey("dev", "SELECT *\n  FROM users WHERE id = $1", []any{"1"})
+	if b := ResultCacheKey("dev", "SELECT * FROM users WHERE id = $1", []any{"1"}); a != b {
+		t.Error("whitespace changed the key")
+	}
+	if b := ResultCacheKey("dev", "select * from users where (id = $1)", []any{"1"}); a != b {
+		t.Error("keyword case or parentheses changed the key")
+	}
+	if ResultCacheKey("dev", "SELECT * FROM users WHERE org = 1", nil) ==
+		ResultCacheKey("dev", "SELECT * FROM users WHERE org = 2", nil) {
+		t.Error("different literals produced the same key")
+	}
+	for _, other := range []string{
+		ResultCacheKey("staging", "SELECT * FROM users WHERE id = $1", []any{"1"}),
+		ResultCacheKey("dev", "SELECT * FROM users WHERE id = $1", []any{"2"}),
+		ResultCacheKey("dev", "SELECT * FROM users WHERE id = $1", []any{nil}),
+	} {
+		if other == a {
+			t.Error("different branch or args produced the same key")
+		}
+	}
+}
+
+func TestResultCacheLRU(t *testing.T) {
+	c := NewResultCache(2, 0)
+	r := &CachedResult{}
+	c.Put("a", "dev", r)
+	c.Put("b", "dev", r)
+	c.Get("a") // a is now more recently used than b
+	c.Put("c", "staging", r)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("recently used entry was evicted")
+	}
+
+	c.InvalidateBranch("dev")
+	if _, ok := c.Get("a"); ok || c.Len() != 1 {
+		t.Errorf("after InvalidateBranch: Len() = %d, want 1", c.Len())
+	}
+}
+
+func TestResultCacheTTL(t *testing.T) {
+	now := time.Now()
+	c := NewResultCache(10, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Put("a", "dev", &CachedResult{})
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("fresh entry missing")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Error("expired entry returned")
+	}
+}
+
+func TestResultCacheSkipsLargeResults(t *testing.T) {
+	c := NewResultCache(10, 0)
+	c.Put("a", "dev", &CachedResult{Rows: make([][]*string, MaxCachedRows+1)})
+	if c.Len() != 0 {
+		t.Error("result over MaxCachedRows was cached")
+	}
+}
+
+func TestColumnCache(t *testing.T) {
+	now := time.Now()
+	c := NewColumnCache(time.Minute)
+	c.now = func() time.Time { return now }
+
+	if _, ok := c.Get("public", "users"); ok {
+		t.Fatal("empty cache returned an entry")
+	}
+	cols := []ColumnDef{{Name: "id", DataType: "integer", IsPK: true, Ordinal: 1}}
+	c.Put("public", "users", cols)
+	c.Put("public", "orders", cols)
+	if got, ok := c.Get("public", "users"); !ok || len(got) != 1 || got[0].Name != "id" {
+		t.Fatalf("Get = %v, %v; want the cached columns", got, ok)
+	}
+
+	c.Invalidate("public", "users")
+	if _, ok := c.Get("public", "users"); ok {
+		t.Error("invalidated entry returned")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("public", "orders"); ok {
+		t.Error("expired entry returned")
+	}
+
+	stats := c.CacheStats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Entries != 0 {
+		t.Errorf("CacheStats = %+v, want 1 hit, 3 misses, 0 entries", stats)
+	}
+	if rate := stats.HitRate(); rate != 0.25 {
+		t.Errorf("HitRate = %v, want 0.25", rate)
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	str := func(s string) *string { return &s }
+	opts := &parser.CopyInfo{Format: "csv", Delimiter: ",", Quote: `"`, Escape: `"`}
+	data := "1,alice,\"\"\n" +
+		"2,,\"says \"\"hi\"\"\"\r\n" +
+		"3,\"multi\nline\",\"a,b\"\n" +
+		"\\.\n" +
+		"4,ignored,\n"
+
+	r := newCSVReader(strings.NewReader(data), opts)
+	want := [][]*string{
+		{str("1"), str("alice"), str("")},
+		{str("2"), nil, str(`says "hi"`)},
+		{str("3"), str("multi\nline"), str("a,b")},
+	}
+	for i, w := range want {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if len(got) != len(w) {
+			t.Fatalf("record %d has %d fields, want %d", i, len(got), len(w))
+		}
+		for j := range w {
+			if (got[j] == nil) != (w[j] == nil) || (got[j] != nil && *got[j] != *w[j]) {
+				t.Errorf("record %d field %d = %v, want %v", i, j, got[j], w[j])
+			}
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("expected io.EOF at end-of-data marker, got %v", err)
+	}
+}
+
+func TestCSVReaderOptions(t *testing.T) {
+	opts := &parser.CopyInfo{Format: "csv", Delimiter: ";", Quote: `'`, Escape: `\`, Null: "NA"}
+	r := newCSVReader(strings.NewReader(`NA;'NA';'it\'s';a\b`), opts)
+
+	got, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 || got[0] != nil || *got[1] != "NA" || *got[2] != "it's" || *got[3] != `a\b` {
+		t.Errorf("Read() = %v", got)
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestCSVReaderUnterminatedQuote(t *testing.T) {
+	opts := &parser.CopyInfo{Format: "csv", Delimiter: ",", Quote: `"`, Escape: `"`}
+	r := newCSVReader(strings.NewReader("1,\"open\n"), opts)
+	if _, err := r.Read(); !errors.Is(err, ErrBadCopyData) {
+		t.Errorf("expected ErrBadCopyData, got %v", err)
+	}
+}
+
+func TestCopyInUpsertSQL(t *testing.T) {
+	c := &CopyIn{
+		Overlay:   `"_rift_branch_dev"."users"`,
+		Columns:   []string{"id", "name"},
+		Types:     []string{"integer", "text"},
+		PKColumns: []string{"id"},
+	}
+	want := `INSERT INTO "_rift_branch_dev"."users" ("id", "name", _rift_tombstone)
+SELECT CAST("id" AS integer), CAST("name" AS text), false FROM pg_temp._rift_copy_staging
+ON CONFLICT ("id") DO UPDATE SET "id" = EXCLUDED."id", "name" = EXCLUDED."name", _rift_tombstone = false`
+	if got := c.upsertSQL(); got != want {
+		t.Errorf("upsertSQL() =\n%s\nwant\n%s", got, want)
+	}
+
+	c.PKColumns = nil
+	if got := c.upsertSQL(); strings.Contains(got, "ON CONFLICT") {
+		t.Errorf("upsertSQL() without PKs = %s", got)
+	}
+}
+
+func TestEstimateMergeDuration(t *testing.T) {
+	if got := estimateMergeDuration(10000, 1, 10000); got != time.Second {
+		t.Errorf("10000 rows into an empty table = %v, want 1s", got)
+	}
+	small := estimateMergeDuration(1000, 1000, 10000)
+	large := estimateMergeDuration(1000, 1_000_000_000, 10000)
+	if large <= small {
+		t.Errorf("large table estimate %v should exceed small table estimate %v", large, small)
+	}
+	if got := estimateMergeDuration(0, 1_000_000, 10000); got != 0 {
+		t.Errorf("no rows = %v, want 0", got)
+	}
+}
+
+func TestFKViolationSQL(t *testing.T) {
+	fk := ForeignKeyDef{
+		Name:       "orders_user_fkey",
+		Columns:    []string{"tenant_id", "user_id"},
+		RefTable:   "users",
+		RefColumns: []string{"tenant_id", "id"},
+	}
+	sql := fkViolationSQL("SELECT 1", "SELECT 2", fk)
+	for _, want := range []string{
+		`c."tenant_id" IS NOT NULL AND c."user_id" IS NOT NULL`,
+		`p."tenant_id" = c."tenant_id" AND p."id" = c."user_id"`,
+		"FROM (SELECT 1) c",
+		"FROM (SELECT 2) p",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("fkViolationSQL() missing %q:\n%s", want, sql)
+		}
+	}
+}
+
+func TestSnapshotTableStatements(t *testing.T) {
+	stmts := snapshotTableStatements("_rift_snapshot_ab", "_rift_branch_dev", "public", "users",
+		[]string{"id", "name"}, []string{"id"})
+	if len(stmts) != 2 {
+		t.Fatalf("got %d statements, want 2", len(stmts))
+	}
+	if !strings.HasPrefix(stmts[0], `CREATE TABLE "_rift_snapshot_ab"."users" (LIKE "public"."users"`) {
+		t.Errorf("create statement = %s", stmts[0])
+	}
+	for _, want := range []string{
+		`INSERT INTO "_rift_snapshot_ab"."users" ("id", "name") SELECT`,
+		`FROM "_rift_branch_dev"."users" ovr WHERE NOT ovr._rift_tombstone`,
+		`FROM "public"."users" src`,
+	} {
+		if !strings.Contains(stmts[1], want) {
+			t.Errorf("insert statement missing %q:\n%s", want, stmts[1])
+		}
+	}
+}
+
+func TestFilterTrackedTables(t *testing.T) {
+	users := &storage.TrackedTable{SourceSchema: "public", TableName: "users"}
+	orders := &storage.TrackedTable{SourceSchema: "public", TableName: "orders"}
+	invoices := &storage.TrackedTable{SourceSchema: "billing", TableName: "invoices"}
+	tables := []*storage.TrackedTable{users, orders, invoices}
+
+	got, err := filterTrackedTables(tables, nil, "feature")
+	if err != nil || len(got) != 3 {
+		t.Fatalf("no filter = %d tables, %v; want all 3", len(got), err)
+	}
+
+	got, err = filterTrackedTables(tables, []string{"billing.invoices", "users", "public.users"}, "feature")
+	if err != nil {
+		t.Fatalf("filterTrackedTables: %v", err)
+	}
+	if len(got) != 2 || got[0] != invoices || got[1] != users {
+		t.Errorf("filter = %v, want invoices and users once each", got)
+	}
+
+	if _, err := filterTrackedTables(tables, []string{"userz"}, "feature"); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("unknown table: got %v, want ErrTableNotFound", err)
+	}
+}
+
+func TestEngineCreateDeleteBranch(t *testing.T) {
+	ctx := context.Background()
+	store := testutil.NewMockStore()
+	engine := NewEngine(store)
+
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+
+	b, err := store.GetBranch(ctx, "feature")
+	if err != nil {
+		t.Fatalf("GetBranch: %v", err)
+	}
+	if b.Parent != "main" {
+		t.Errorf("branch parent = %q, want %q", b.Parent, "main")
+	}
+	if !store.HasSchema("feature") {
+		t.Error("branch schema should exist after create")
+	}
+
+	if err := engine.DeleteBranch(ctx, "feature"); err != nil {
+		t.Fatalf("DeleteBranch: %v", err)
+	}
+	b, err = store.GetBranch(ctx, "feature")
+	if err != nil {
+		t.Fatalf("GetBranch after delete: %v", err)
+	}
+	if b.DeletedAt == nil || b.Status != "deleted" {
+		t.Errorf("after delete: deleted_at = %v, status = %q; want a soft-deleted branch", b.DeletedAt, b.Status)
+	}
+	if !store.HasSchema("feature") {
+		t.Error("branch schema should be kept after a soft delete")
+	}
+	if live, _ := store.ListBranches(ctx); len(live) != 1 {
+		t.Errorf("ListBranches after delete = %d branches, want only main", len(live))
+	}
+	if err := engine.DeleteBranch(ctx, "feature"); err == nil {
+		t.Error("deleting a deleted branch should fail")
+	}
+
+	if err := engine.RestoreBranch(ctx, "feature"); err != nil {
+		t.Fatalf("RestoreBranch: %v", err)
+	}
+	if b, _ := store.GetBranch(ctx, "feature"); b.DeletedAt != nil || b.Status != "active" {
+		t.Errorf("after restore: deleted_at = %v, status = %q; want an active branch", b.DeletedAt, b.Status)
+	}
+
+	if err := engine.PurgeBranch(ctx, "feature"); err != nil {
+		t.Fatalf("PurgeBranch: %v", err)
+	}
+	if _, err := store.GetBranch(ctx, "feature"); !errors.Is(err, storage.ErrBranchNotFound) {
+		t.Errorf("GetBranch after purge: got %v, want ErrBranchNotFound", err)
+	}
+	if store.HasSchema("feature") {
+		t.Error("branch schema should be dropped after purge")
+	}
+
+	entries, err := store.ListAudit(ctx, storage.AuditQuery{BranchName: "feature"})
+	if err != nil {
+		t.Fatalf("ListAudit: %v", err)
+	}
+	var ops []string
+	for _, e := range entries {
+		ops = append(ops, e.Operation)
+	}
+	if want := []string{AuditCreate, AuditDelete, AuditRestore, AuditPurge}; !slices.Equal(ops, want) {
+		t.Errorf("audit log = %v, want %v", ops, want)
+	}
+}
+
+func TestEnginePurgeDeletedBranches(t *testing.T) {
+	ctx := context.Background()
+	store := testutil.NewMockStore()
+	engine := NewEngine(store)
+
+	for _, b := range []struct{ name, parent string }{{"parent", "main"}, {"child", "parent"}, {"other", "main"}} {
+		if err := engine.CreateBranch(ctx, b.name, b.parent, nil); err != nil {
+			t.Fatalf("CreateBranch %s: %v", b.name, err)
+		}
+	}
+	for _, name := range []string{"child", "parent"} {
+		if err := engine.DeleteBranch(ctx, name); err != nil {
+			t.Fatalf("DeleteBranch %s: %v", name, err)
+		}
+	}
+
+	if err := engine.CreateBranch(ctx, "child", "main", nil); err == nil {
+		t.Error("creating a branch with a deleted branch's name should fail")
+	}
+	if err := engine.RestoreBranch(ctx, "child"); err == nil || !strings.Contains(err.Error(), "parent") {
+		t.Errorf("restore under a deleted parent: got %v, want parent error", err)
+	}
+
+	purged, err := engine.PurgeDeletedBranches(ctx, time.Hour)
+	if err != nil || len(purged) != 0 {
+		t.Errorf("PurgeDeletedBranches within retention = %v, %v; want nothing purged", purged, err)
+	}
+
+	purged, err = engine.PurgeDeletedBranches(ctx, 0)
+	if err != nil {
+		t.Fatalf("PurgeDeletedBranches: %v", err)
+	}
+	if want := []string{"child", "parent"}; !slices.Equal(purged, want) {
+		t.Errorf("purged = %v, want %v", purged, want)
+	}
+	if _, err := store.GetBranch(ctx, "other"); err != nil {
+		t.Errorf("live branch purged: %v", err)
+	}
+}
+
+func TestEngineCreateBranchErrors(t *testing.T) {
+	ctx := context.Background()
+	store := testutil.NewMockStore()
+	engine := NewEngine(store)
+
+	if err := engine.CreateBranch(ctx, "feature", "nope", nil); !errors.Is(err, storage.ErrBranchNotFound) {
+		t.Errorf("missing parent: got %v, want ErrBranchNotFound", err)
+	}
+	if err := engine.CreateBranch(ctx, "bad name", "main", nil); err == nil {
+		t.Error("invalid branch name should be rejected")
+	}
+
+	// A failed schema creation rolls back the branch metadata
+	store.SetError("CreateBranchSchema", errors.New("simulated failure"))
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err == nil {
+		t.Fatal("CreateBranch should fail when the schema can't be created")
+	}
+	if _, err := store.GetBranch(ctx, "feature"); !errors.Is(err, storage.ErrBranchNotFound) {
+		t.Errorf("branch metadata left behind: %v", err)
+	}
+
+	store.SetError("CreateBranchSchema", nil)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Errorf("CreateBranch after clearing the error: %v", err)
+	}
+}
+
+func TestEngineDeleteBranchRefusals(t *testing.T) {
+	ctx := context.Background()
+	store := testutil.NewMockStore()
+	engine := NewEngine(store)
+
+	if err := engine.DeleteBranch(ctx, "main"); err == nil || !strings.Contains(err.Error(), "pinned") {
+		t.Errorf("delete main: got %v, want pinned error", err)
+	}
+
+	if err := engine.CreateBranch(ctx, "parent", "main", nil); err != nil {
+		t.Fatalf("CreateBranch parent: %v", err)
+	}
+	if err := engine.CreateBranch(ctx, "child", "parent", nil); err != nil {
+		t.Fatalf("CreateBranch child: %v", err)
+	}
+	if err := engine.DeleteBranch(ctx, "parent"); err == nil || !strings.Contains(err.Error(), "child") {
+		t.Errorf("delete parent: got %v, want child branch error", err)
+	}
+
+	failure := errors.New("simulated failure")
+	store.SetError("ListBranchesByParent", failure)
+	if err := engine.DeleteBranch(ctx, "child"); !errors.Is(err, failure) {
+		t.Errorf("delete with failing ListBranchesByParent: got %v, want the simulated failure", err)
+	}
+	if !store.HasSchema("child") {
+		t.Error("schema dropped although the delete failed")
+	}
+}
+
+func TestEngineProcessQueryMainBranch(t *testing.T) {
+	engine := NewEngine(testutil.NewMockStore())
+
+	// Main branch should always passthrough
+	pq, err := engine.ProcessQuery(context.Background(), "main", "SELECT * FROM users")
+	if err != nil {
+		t.Fatalf("ProcessQuery: %v", err)
+	}
+	if !pq.IsPassthrough {
+		t.Error("main branch queries should be passthrough")
+	}
+	if pq.RewrittenSQL != "SELECT * FROM users" {
+		t.Errorf("main branch query should be unchanged, got %q", pq.RewrittenSQL)
+	}
+}
+
+func TestEngineProcessQueryTransactionControl(t *testing.T) {
+	engine := NewEngine(testutil.NewMockStore())
+
+	// Transaction control should passthrough even on non-main
+	for _, sql := range []string{"BEGIN", "COMMIT", "ROLLBACK", "START TRANSACTION"} {
+		pq, err := engine.ProcessQuery(context.Background(), "feature", sql)
+		if err != nil {
+			t.Fatalf("ProcessQuery(%q): %v", sql, err)
+		}
+		if !pq.IsPassthrough {
+			t.Errorf("%q should be passthrough on branch", sql)
+		}
+	}
+}
+
+func TestEngineProcessQueryReadOnlyBranches(t *testing.T) {
+	ctx := context.Background()
+	store := testutil.NewMockStore()
+	engine := NewEngine(store)
+
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	insert := "INSERT INTO users (id) VALUES (1)"
+
+	_ = store.SetBranchProtected(ctx, "feature", true)
+	if _, err := engine.ProcessQuery(ctx, "feature", insert); !errors.Is(err, ErrBranchProtected) {
+		t.Errorf("write to protected branch: got %v, want ErrBranchProtected", err)
+	}
+	_ = store.SetBranchProtected(ctx, "feature", false)
+
+	_ = store.SetBranchFrozen(ctx, "feature", true)
+	if _, err := engine.ProcessQuery(ctx, "feature", insert); !errors.Is(err, ErrBranchFrozen) {
+		t.Errorf("write to frozen branch: got %v, want ErrBranchFrozen", err)
+	}
+	_ = store.SetBranchFrozen(ctx, "feature", false)
+
+	limit := int64(100)
+	_ = store.SetBranchMaxDeltaBytes(ctx, "feature", &limit)
+	b, _ := store.GetBranch(ctx, "feature")
+	b.DeltaSize = 100
+	_ = store.UpdateBranch(ctx, b)
+	if _, err := engine.ProcessQuery(ctx, "feature", insert); !errors.Is(err, ErrDeltaSizeLimit) {
+		t.Errorf("write to full branch: got %v, want ErrDeltaSizeLimit", err)
+	}
+}
+
+func TestEngineRevertTableRefusals(t *testing.T) {
+	ctx := context.Background()
+	store := testutil.NewMockStore()
+	engine := NewEngine(store)
+
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+
+	if err := engine.RevertTable(ctx, "main", "public", "users"); err == nil {
+		t.Error("reverting a table on main: got nil error")
+	}
+	if err := engine.RevertTable(ctx, "missing", "public", "users"); !errors.Is(err, storage.ErrBranchNotFound) {
+		t.Errorf("reverting on a missing branch: got %v, want ErrBranchNotFound", err)
+	}
+	if err := engine.RevertTable(ctx, "feature", "public", "users"); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("reverting an untracked table: got %v, want ErrTableNotFound", err)
+	}
+
+	_ = store.SetBranchProtected(ctx, "feature", true)
+	if err := engine.RevertTable(ctx, "feature", "public", "users"); !errors.Is(err, ErrBranchProtected) {
+		t.Errorf("reverting on a protected branch: got %v, want ErrBranchProtected", err)
+	}
+	_ = store.SetBranchProtected(ctx, "feature", false)
+
+	_ = store.SetBranchFrozen(ctx, "feature", true)
+	if err := engine.RevertTable(ctx, "feature", "public", "users"); !errors.Is(err, ErrBranchFrozen) {
+		t.Errorf("reverting on a frozen branch: got %v, want ErrBranchFrozen", err)
+	}
+}
+
+func TestTableJSONSchema(t *testing.T) {
+	s := TableJSONSchema("public.users", []ColumnDef{
+		{Name: "id", DataType: "integer"},
+		{Name: "email", DataType: "text"},
+		{Name: "score", DataType: "numeric", IsNullable: true},
+		{Name: "created_at", DataType: "timestamp with time zone"},
+		{Name: "prefs", DataType: "jsonb", IsNullable: true},
+	})
+
+	if s.Schema != JSONSchemaDialect || s.Type != "object" || s.Title != "public.users" {
+		t.Errorf("schema = %+v", s)
+	}
+	if id := s.Properties["id"]; id.Type != "integer" {
+		t.Errorf("id = %+v, want integer", id)
+	}
+	if email := s.Properties["email"]; email.Type != "string" || email.Format != "" {
+		t.Errorf("email = %+v, want string", email)
+	}
+	if score := s.Properties["score"]; !reflect.DeepEqual(score.Type, []string{"number", "null"}) {
+		t.Errorf("score type = %v, want nullable number", score.Type)
+	}
+	if ts := s.Properties["created_at"]; ts.Type != "string" || ts.Format != "date-time" {
+		t.Errorf("created_at = %+v, want date-time string", ts)
+	}
+	if prefs := s.Properties["prefs"]; prefs.Type != nil {
+		t.Errorf("prefs type = %v, want any JSON value", prefs.Type)
+	}
+	if want := []string{"id", "email", "created_at"}; !slices.Equal(s.Required, want) {
+		t.Errorf("required = %v, want %v", s.Required, want)
+	}
+}
+
+func TestEngineSummarize(t *testing.T) {
+	ctx := context.Background()
+	store := testutil.NewMockStore()
+	engine := NewEngine(store)
+	now := time.Now()
+
+	ttl := func(d time.Duration) *int {
+		s := int(d.Seconds())
+		return &s
+	}
+	for _, b := range []*storage.Branch{
+		{Name: "small", Parent: "main", CreatedAt: now, Status: "active", DeltaSize: 10, TTLSeconds: ttl(2 * time.Hour)},
+		{Name: "big", Parent: "main", CreatedAt: now, Status: "active", DeltaSize: 300, TTLSeconds: ttl(48 * time.Hour)},
+		{Name: "soon", Parent: "main", CreatedAt: now.Add(-23 * time.Hour), Status: "active", DeltaSize: 20, TTLSeconds: ttl(24 * time.Hour)},
+		{Name: "cold", Parent: "main", CreatedAt: now, Status: "active", DeltaSize: 200},
+		{Name: "gone", Parent: "main", CreatedAt: now, Status: "active", DeltaSize: 1000},
+	} {
+		if err := store.CreateBranch(ctx, b); err != nil {
+			t.Fatalf("CreateBranch(%s): %v", b.Name, err)
+		}
+	}
+	_ = store.SetBranchFrozen(ctx, "cold", true)
+	_ = store.SetBranchDeleted(ctx, "gone", true)
+
+	s, err := engine.Summarize(ctx, now, 24*time.Hour, 2)
+	if err != nil {
+		t.Fatalf("Summarize: %v", err)
+	}
+
+	if s.Branches != 6 {
+		t.Errorf("Branches = %d, want 6 (main included)", s.Branches)
+	}
+	if s.DeltaSize != 530 {
+		t.Errorf("DeltaSize = %d, want 530 (deleted branch left out)", s.DeltaSize)
+	}
+	if want := map[string]int{"active": 4, "frozen": 1, "deleted": 1}; !reflect.DeepEqual(s.ByStatus, want) {
+		t.Errorf("ByStatus = %v, want %v", s.ByStatus, want)
+	}
+
+	var expiring []string
+	for _, b := range s.Expiring {
+		expiring = append(expiring, b.Name)
+	}
+	if got := strings.Join(expiring, ","); got != "soon,small" {
+		t.Errorf("Expiring = %s, want soon,small", got)
+	}
+
+	var largest []string
+	for _, b := range s.Largest {
+		largest = append(largest, b.Name)
+	}
+	if got := strings.Join(largest, ","); got != "big,cold,soon,small" {
+		t.Errorf("Largest = %s, want big,cold,soon,small", got)
+	}
+}
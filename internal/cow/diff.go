@@ -3,6 +3,7 @@ package cow
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -21,6 +22,10 @@ type BranchDiff struct {
 	BranchName string
 	Parent     string
 	Tables     []TableDiff
+
+	// Target is the branch compared against for a cross-branch diff; Parent
+	// is then the two branches' nearest common ancestor.
+	Target string
 }
 
 // TotalChanges returns the sum of all changes across all tables.
@@ -83,6 +88,91 @@ func DiffTable(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSche
 	return diff, nil
 }
 
+// DiffMergedTables compares a table as two branches see it. An empty schema
+// stands for a branch without an overlay for the table, which sees the source
+// table as-is. Rows are matched on primary key with an outer join:
+// - rows only branch A sees → inserts
+// - rows only branch B sees → deletes
+// - rows both see whose columns differ → updates
+func DiffMergedTables(ctx context.Context, pool *pgxpool.Pool, schemaA, schemaB, sourceSchema, tableName string, columns, pkCols []string) (*TableDiff, error) {
+	if len(pkCols) == 0 {
+		return nil, fmt.Errorf("diff table %q: empty primary key columns", tableName)
+	}
+
+	pkSet := make(map[string]bool, len(pkCols))
+	for _, pk := range pkCols {
+		pkSet[pk] = true
+	}
+	var distinct []string
+	for _, col := range columns {
+		if !pkSet[col] {
+			q := pgQuoteIdent(col)
+			distinct = append(distinct, fmt.Sprintf("a.%s::text IS DISTINCT FROM b.%s::text", q, q))
+		}
+	}
+	updated := "false"
+	if len(distinct) > 0 {
+		updated = strings.Join(distinct, " OR ")
+	}
+
+	pk := pgQuoteIdent(pkCols[0])
+	sql := fmt.Sprintf(
+		`WITH a AS (%s), b AS (%s)
+		 SELECT
+		   COUNT(*) FILTER (WHERE b.%s IS NULL),
+		   COUNT(*) FILTER (WHERE a.%s IS NOT NULL AND b.%s IS NOT NULL AND (%s)),
+		   COUNT(*) FILTER (WHERE a.%s IS NULL)
+		 FROM a FULL OUTER JOIN b ON %s`,
+		mergedViewSQL(schemaA, sourceSchema, tableName, columns, pkCols),
+		mergedViewSQL(schemaB, sourceSchema, tableName, columns, pkCols),
+		pk, pk, pk, updated, pk, buildPKJoin("a", "b", pkCols))
+
+	diff := &TableDiff{
+		TableName:    tableName,
+		SourceSchema: sourceSchema,
+	}
+	if err := pool.QueryRow(ctx, sql).Scan(&diff.Inserts, &diff.Updates, &diff.Deletes); err != nil {
+		return nil, fmt.Errorf("compare rows: %w", err)
+	}
+	return diff, nil
+}
+
+// mergedViewSQL returns a query for columns of a table as a branch sees it:
+// its live overlay rows plus the source rows it hasn't overridden. An empty
+// branchSchema reads the source table alone.
+func mergedViewSQL(branchSchema, sourceSchema, tableName string, columns, pkCols []string) string {
+	srcTable := pgQuoteIdent(sourceSchema) + "." + pgQuoteIdent(tableName)
+	if branchSchema == "" {
+		return fmt.Sprintf("SELECT %s FROM %s src", qualifiedColumns("src", columns, ""), srcTable)
+	}
+
+	ovrTable := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(tableName)
+	return fmt.Sprintf(
+		`SELECT %s FROM %s ovr WHERE NOT ovr._rift_tombstone
+		 UNION ALL
+		 SELECT %s FROM %s src
+		 WHERE NOT EXISTS (SELECT 1 FROM %s ovr WHERE %s)`,
+		qualifiedColumns("ovr", columns, ""), ovrTable,
+		qualifiedColumns("src", columns, ""), srcTable,
+		ovrTable, buildPKJoin("ovr", "src", pkCols))
+}
+
+// commonAncestor returns the first branch in lineageA (a branch followed by
+// its ancestors, nearest first) that also appears in lineageB, or "" if the
+// lineages don't meet.
+func commonAncestor(lineageA, lineageB []string) string {
+	inB := make(map[string]bool, len(lineageB))
+	for _, name := range lineageB {
+		inB[name] = true
+	}
+	for _, name := range lineageA {
+		if inB[name] {
+			return name
+		}
+	}
+	return ""
+}
+
 func buildPKJoin(leftAlias, rightAlias string, pkCols []string) string {
 	result := ""
 	for i, col := range pkCols {
@@ -94,3 +184,209 @@ func buildPKJoin(leftAlias, rightAlias string, pkCols []string) string {
 	}
 	return result
 }
+
+// Change kinds reported by a detailed table diff.
+const (
+	ChangeInsert = "INSERT"
+	ChangeUpdate = "UPDATE"
+	ChangeDelete = "DELETE"
+)
+
+// RowChange is one changed row in a detailed table diff. Values are text, in
+// the order of TableDiffDetailed.Columns; a nil value is SQL NULL.
+type RowChange struct {
+	Change string
+	Before []*string // source row; nil for inserts
+	After  []*string // overlay row; nil for deletes
+}
+
+// Key returns the row's primary key values.
+func (r RowChange) Key(d *TableDiffDetailed) []*string {
+	row := r.After
+	if row == nil {
+		row = r.Before
+	}
+	key := make([]*string, 0, len(d.PKColumns))
+	for _, i := range d.pkIndexes() {
+		key = append(key, row[i])
+	}
+	return key
+}
+
+// ChangedColumns returns the indexes of the columns an update changed.
+func (r RowChange) ChangedColumns() []int {
+	if r.Before == nil || r.After == nil {
+		return nil
+	}
+	var changed []int
+	for i := range r.After {
+		if !equalValue(r.Before[i], r.After[i]) {
+			changed = append(changed, i)
+		}
+	}
+	return changed
+}
+
+func equalValue(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// TableDiffDetailed lists the rows a branch changed in one table.
+type TableDiffDetailed struct {
+	TableName    string
+	SourceSchema string
+	Columns      []string
+	PKColumns    []string
+	Changes      []RowChange
+
+	// Truncated is set when a change kind had more rows than the limit.
+	Truncated bool
+}
+
+func (d *TableDiffDetailed) pkIndexes() []int {
+	idx := make([]int, 0, len(d.PKColumns))
+	for _, pk := range d.PKColumns {
+		for i, col := range d.Columns {
+			if col == pk {
+				idx = append(idx, i)
+				break
+			}
+		}
+	}
+	return idx
+}
+
+// DiffTableRows returns the rows a branch overlay changed relative to its
+// source table:
+// - inserts are live overlay rows with no source row
+// - updates are live overlay rows whose non-PK columns differ from the source row
+// - deletes are tombstones with a matching source row
+//
+// Each change kind returns at most maxRows rows (0 means no limit), ordered
+// by primary key.
+func DiffTableRows(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string, columns, pkCols []string, maxRows int) (*TableDiffDetailed, error) {
+	if len(pkCols) == 0 {
+		return nil, fmt.Errorf("diff table %q: empty primary key columns", tableName)
+	}
+
+	ovrTable := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(tableName)
+	srcTable := pgQuoteIdent(sourceSchema) + "." + pgQuoteIdent(tableName)
+	pkJoin := buildPKJoin("ovr", "src", pkCols)
+	orderBy := qualifiedColumns("ovr", pkCols, "")
+
+	pkSet := make(map[string]bool, len(pkCols))
+	for _, pk := range pkCols {
+		pkSet[pk] = true
+	}
+	var distinct []string
+	for _, col := range columns {
+		if !pkSet[col] {
+			q := pgQuoteIdent(col)
+			distinct = append(distinct, fmt.Sprintf("ovr.%s::text IS DISTINCT FROM src.%s::text", q, q))
+		}
+	}
+
+	d := &TableDiffDetailed{
+		TableName:    tableName,
+		SourceSchema: sourceSchema,
+		Columns:      columns,
+		PKColumns:    pkCols,
+	}
+
+	queries := []struct {
+		change string
+		sql    string
+	}{
+		{ChangeInsert, fmt.Sprintf(
+			`SELECT %s FROM %s ovr
+			 WHERE NOT ovr._rift_tombstone
+			 AND NOT EXISTS (SELECT 1 FROM %s src WHERE %s)
+			 ORDER BY %s`,
+			qualifiedColumns("ovr", columns, "::text"), ovrTable, srcTable, pkJoin, orderBy)},
+		{ChangeDelete, fmt.Sprintf(
+			`SELECT %s FROM %s ovr JOIN %s src ON %s
+			 WHERE ovr._rift_tombstone
+			 ORDER BY %s`,
+			qualifiedColumns("src", columns, "::text"), ovrTable, srcTable, pkJoin, orderBy)},
+	}
+	if len(distinct) > 0 {
+		queries = append(queries, struct {
+			change string
+			sql    string
+		}{ChangeUpdate, fmt.Sprintf(
+			`SELECT %s, %s FROM %s ovr JOIN %s src ON %s
+			 WHERE NOT ovr._rift_tombstone AND (%s)
+			 ORDER BY %s`,
+			qualifiedColumns("src", columns, "::text"), qualifiedColumns("ovr", columns, "::text"),
+			ovrTable, srcTable, pkJoin, strings.Join(distinct, " OR "), orderBy)})
+	}
+
+	for _, q := range queries {
+		sql := q.sql
+		if maxRows > 0 {
+			sql += fmt.Sprintf(" LIMIT %d", maxRows+1)
+		}
+		changes, err := queryRowChanges(ctx, pool, sql, q.change, len(columns))
+		if err != nil {
+			return nil, fmt.Errorf("diff %s rows: %w", strings.ToLower(q.change), err)
+		}
+		if maxRows > 0 && len(changes) > maxRows {
+			changes = changes[:maxRows]
+			d.Truncated = true
+		}
+		d.Changes = append(d.Changes, changes...)
+	}
+
+	return d, nil
+}
+
+// queryRowChanges runs a diff query whose rows hold ncols text values, or
+// 2*ncols (before, then after) for updates.
+func queryRowChanges(ctx context.Context, pool *pgxpool.Pool, sql, change string, ncols int) ([]RowChange, error) {
+	rows, err := pool.Query(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	width := ncols
+	if change == ChangeUpdate {
+		width = 2 * ncols
+	}
+
+	var changes []RowChange
+	for rows.Next() {
+		values := make([]*string, width)
+		dest := make([]any, width)
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		rc := RowChange{Change: change}
+		switch change {
+		case ChangeInsert:
+			rc.After = values
+		case ChangeDelete:
+			rc.Before = values
+		default:
+			rc.Before, rc.After = values[:ncols], values[ncols:]
+		}
+		changes = append(changes, rc)
+	}
+	return changes, rows.Err()
+}
+
+// qualifiedColumns returns "alias.col<suffix>, ..." for cols.
+func qualifiedColumns(alias string, cols []string, suffix string) string {
+	parts := make([]string, len(cols))
+	for i, col := range cols {
+		parts[i] = alias + "." + pgQuoteIdent(col) + suffix
+	}
+	return strings.Join(parts, ", ")
+}
@@ -2,22 +2,95 @@ package cow
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/riftdata/rift/internal/parser"
 	"github.com/riftdata/rift/internal/storage"
 )
 
+// ErrBranchProtected is returned for writes and DDL on a protected branch.
+var ErrBranchProtected = errors.New("branch is protected; connect to a child branch to make changes")
+
+// ErrDeltaSizeLimit is returned for writes and DDL on a branch whose delta
+// size has reached its limit (see storage.Branch.MaxDeltaBytes).
+var ErrDeltaSizeLimit = errors.New("branch delta size limit exceeded")
+
 // Engine is the copy-on-write query processing engine. It coordinates SQL parsing,
 // overlay table management, and query rewriting for branch isolation.
 type Engine struct {
-	store storage.Store
+	store          storage.Store
+	maxOverlayRows int
+	cteMode        parser.CTEMode
+	trackDeltaSize bool
+	resultCache    *ResultCache
+	columnCache    *ColumnCache
+
+	mergeRowsPerSecond int
+	trackAllOnCreate   bool
 }
 
 // NewEngine creates a new CoW engine.
 func NewEngine(store storage.Store) *Engine {
-	return &Engine{store: store}
+	return &Engine{store: store, columnCache: NewColumnCache(DefaultColumnCacheTTL)}
+}
+
+// SetMaxOverlayRows caps the number of overlay rows each rewritten SELECT reads
+// per table. 0 (the default) means unlimited. The cap only applies to
+// overlays with more live rows than n; their rows past it are missing from
+// the results, and the client is sent a notice.
+func (e *Engine) SetMaxOverlayRows(n int) {
+	e.maxOverlayRows = n
+}
+
+// SetCTEMode selects how rewritten SELECTs merge overlay and source rows
+// (see parser.CTEMode). The default is parser.CTEUnionAll.
+func (e *Engine) SetCTEMode(mode parser.CTEMode) {
+	e.cteMode = mode
+}
+
+// SetTrackDeltaSize enables triggers on new overlay tables that keep each
+// branch's delta_size up to date as rows change. Off by default, since every
+// overlay write then also updates the branch's metadata row.
+func (e *Engine) SetTrackDeltaSize(enabled bool) {
+	e.trackDeltaSize = enabled
+}
+
+// SetResultCache enables caching of SELECT results on frozen branches, keeping
+// up to maxSize results for ttl each. A maxSize of 0 (the default) disables
+// the cache.
+func (e *Engine) SetResultCache(maxSize int, ttl time.Duration) {
+	if maxSize <= 0 {
+		e.resultCache = nil
+		return
+	}
+	e.resultCache = NewResultCache(maxSize, ttl)
+}
+
+// ResultCache returns the engine's result cache, or nil if it is disabled.
+func (e *Engine) ResultCache() *ResultCache {
+	return e.resultCache
+}
+
+// SetColumnCacheTTL sets how long table columns are cached (see ColumnCache),
+// replacing the cache. The default is DefaultColumnCacheTTL; 0 disables the
+// cache.
+func (e *Engine) SetColumnCacheTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		e.columnCache = nil
+		return
+	}
+	e.columnCache = NewColumnCache(ttl)
+}
+
+// ColumnCache returns the engine's column cache, or nil if it is disabled.
+func (e *Engine) ColumnCache() *ColumnCache {
+	return e.columnCache
 }
 
 // ProcessedQuery holds the result of processing a SQL query through the engine.
@@ -28,11 +101,39 @@ type ProcessedQuery struct {
 	NeedsOverlay  bool
 	IsPassthrough bool
 	TableName     string
+	Notices       []string
+	Returning     bool // the write has a RETURNING clause, so it returns rows
+
+	// Cacheable is set for SELECTs on frozen branches while the result
+	// cache is enabled.
+	Cacheable bool
+
+	// ReplicaSafe is set for SELECTs a read replica can run (see
+	// parser.ParsedQuery.RunsOnStandby).
+	ReplicaSafe bool
+
+	// CopyIn is set for COPY ... FROM STDIN, whose data the router loads
+	// into the overlay instead of running the statement.
+	CopyIn *CopyIn
 }
 
 // ProcessQuery parses and rewrites a SQL query for the given branch.
 // For the "main" branch, queries pass through unmodified.
+// Unqualified table names resolve to the "public" schema.
 func (e *Engine) ProcessQuery(ctx context.Context, branchName, sql string) (*ProcessedQuery, error) {
+	return e.ProcessSessionQuery(ctx, branchName, sql, nil)
+}
+
+// ReturnsRows reports whether the query produces a result set: a SELECT, or a
+// write with a RETURNING clause.
+func (pq *ProcessedQuery) ReturnsRows() bool {
+	return pq.Type == parser.QuerySelect || pq.Returning
+}
+
+// ProcessSessionQuery is like ProcessQuery, but resolves unqualified table names
+// against the session's search_path (as set by the client with SET search_path).
+// An empty searchPath behaves like ProcessQuery.
+func (e *Engine) ProcessSessionQuery(ctx context.Context, branchName, sql string, searchPath []string) (*ProcessedQuery, error) {
 	// Main branch is always passthrough
 	if branchName == "main" {
 		return &ProcessedQuery{
@@ -58,7 +159,15 @@ func (e *Engine) ProcessQuery(ctx context.Context, branchName, sql string) (*Pro
 		return nil, fmt.Errorf("parse query: %w", err)
 	}
 
-	// Utility statements pass through
+	// COPY FROM STDIN loads into the overlay; other utility statements pass
+	// through
+	copyInfo, err := parser.ExtractCopyInfo(pq)
+	if err != nil {
+		return nil, err
+	}
+	if copyInfo != nil {
+		return e.processCopyIn(ctx, branchName, sql, copyInfo, searchPath)
+	}
 	if pq.IsUtility() {
 		return &ProcessedQuery{
 			OriginalSQL:   sql,
@@ -68,19 +177,37 @@ func (e *Engine) ProcessQuery(ctx context.Context, branchName, sql string) (*Pro
 		}, nil
 	}
 
+	// Protected and frozen branches are read-only, and full ones reject
+	// further writes
+	if pq.IsWrite() || pq.IsDDL() {
+		if err := e.checkWritable(ctx, branchName); err != nil {
+			return nil, err
+		}
+	}
+
+	// ALTER TABLE changes the columns of the table and its overlay
+	if pq.DDLType == parser.DDLAlterTable {
+		e.invalidateColumns(ctx, branchName, pq.Tables, searchPath)
+	}
+
+	// DROP INDEX names indexes, not tables, so it has no overlays to set up
+	if pq.DDLType == parser.DDLDropIndex {
+		return e.processDropIndex(ctx, branchName, pq, searchPath)
+	}
+
 	// Build rewrite configs for referenced tables
-	configs, err := e.buildRewriteConfigs(ctx, branchName, pq)
+	configs, err := e.buildRewriteConfigs(ctx, branchName, pq, searchPath)
 	if err != nil {
 		return nil, fmt.Errorf("build rewrite configs: %w", err)
 	}
 
 	// For write operations, ensure overlay tables exist
 	if pq.IsWrite() || pq.IsDDL() {
-		if err := e.ensureOverlays(ctx, branchName, pq); err != nil {
+		if err := e.ensureOverlays(ctx, branchName, pq, searchPath); err != nil {
 			return nil, fmt.Errorf("ensure overlays: %w", err)
 		}
 		// Rebuild configs after overlay creation (PKs may have been cached)
-		configs, err = e.buildRewriteConfigs(ctx, branchName, pq)
+		configs, err = e.buildRewriteConfigs(ctx, branchName, pq, searchPath)
 		if err != nil {
 			return nil, fmt.Errorf("rebuild rewrite configs: %w", err)
 		}
@@ -92,6 +219,15 @@ func (e *Engine) ProcessQuery(ctx context.Context, branchName, sql string) (*Pro
 		return nil, fmt.Errorf("rewrite query: %w", err)
 	}
 
+	cacheable := false
+	if e.resultCache != nil && pq.Type == parser.QuerySelect {
+		branch, err := e.store.GetBranch(ctx, branchName)
+		if err != nil {
+			return nil, fmt.Errorf("get branch: %w", err)
+		}
+		cacheable = branch.Frozen
+	}
+
 	return &ProcessedQuery{
 		OriginalSQL:   sql,
 		RewrittenSQL:  result.SQL,
@@ -99,19 +235,68 @@ func (e *Engine) ProcessQuery(ctx context.Context, branchName, sql string) (*Pro
 		NeedsOverlay:  result.NeedsOverlay,
 		IsPassthrough: result.IsPassthrough,
 		TableName:     result.TableName,
+		Notices:       result.Notices,
+		Returning:     pq.Returning != "",
+		Cacheable:     cacheable,
+		ReplicaSafe:   pq.RunsOnStandby(),
 	}, nil
 }
 
+// checkWritable returns an error if a branch can't take writes: it is
+// protected, frozen, or over its delta size limit.
+func (e *Engine) checkWritable(ctx context.Context, branchName string) error {
+	branch, err := e.store.GetBranch(ctx, branchName)
+	if err != nil {
+		return fmt.Errorf("get branch: %w", err)
+	}
+	if branch.Protected {
+		return ErrBranchProtected
+	}
+	if branch.Frozen {
+		return ErrBranchFrozen
+	}
+	if branch.MaxDeltaBytes != nil && branch.DeltaSize >= *branch.MaxDeltaBytes {
+		return ErrDeltaSizeLimit
+	}
+	return nil
+}
+
 // CreateBranch creates a new branch with overlay schema.
 func (e *Engine) CreateBranch(ctx context.Context, name, parent string, ttl *time.Duration) error {
+	if err := e.createBranch(ctx, name, parent, ttl); err != nil {
+		return err
+	}
+
+	details := map[string]any{"parent": parent}
+	if ttl != nil {
+		details["ttl"] = ttl.String()
+	}
+	e.audit(ctx, name, AuditCreate, details)
+
+	if e.trackAllOnCreate {
+		if err := e.TrackAllTables(ctx, name, "public"); err != nil {
+			return fmt.Errorf("branch %q created, but tracking its tables failed: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (e *Engine) createBranch(ctx context.Context, name, parent string, ttl *time.Duration) error {
 	if err := storage.ValidateBranchName(name); err != nil {
 		return err
 	}
 
+	if existing, err := e.store.GetBranch(ctx, name); err == nil && existing.DeletedAt != nil {
+		return fmt.Errorf("branch %q was deleted; restore or purge it first", name)
+	}
+
 	// Get parent info
 	parentBranch, err := e.store.GetBranch(ctx, parent)
 	if err != nil {
-		return fmt.Errorf("parent branch %q not found: %w", parent, err)
+		return fmt.Errorf("parent branch: %w", err)
+	}
+	if parentBranch.DeletedAt != nil {
+		return fmt.Errorf("parent branch %q is deleted", parent)
 	}
 
 	now := time.Now()
@@ -141,43 +326,126 @@ func (e *Engine) CreateBranch(ctx context.Context, name, parent string, ttl *tim
 	return nil
 }
 
-// DeleteBranch deletes a branch and its overlay schema.
-// It verifies the branch exists, is not pinned, and has no children before proceeding.
+// CloneBranch creates newName as a child of sourceBranch and copies the
+// source's overlay tables, so the new branch starts with all of the source
+// branch's changes. CreateBranch, by contrast, starts with an empty overlay.
+func (e *Engine) CloneBranch(ctx context.Context, sourceBranch, newName string) error {
+	if sourceBranch == "main" {
+		return fmt.Errorf("main has no overlay to clone; create a branch from main instead")
+	}
+
+	tables, err := e.store.ListTrackedTables(ctx, sourceBranch, "")
+	if err != nil {
+		return fmt.Errorf("list tracked tables: %w", err)
+	}
+
+	if err := e.createBranch(ctx, newName, sourceBranch, nil); err != nil {
+		return err
+	}
+
+	pool := e.store.Pool()
+	fromSchema := e.store.BranchSchemaName(sourceBranch)
+	toSchema := e.store.BranchSchemaName(newName)
+
+	for _, t := range tables {
+		if err := e.cloneTrackedTable(ctx, pool, fromSchema, toSchema, newName, t); err != nil {
+			_ = e.store.DropBranchSchema(ctx, newName)
+			_ = e.store.DeleteBranch(ctx, newName)
+			return fmt.Errorf("clone %s.%s: %w", t.SourceSchema, t.TableName, err)
+		}
+	}
+
+	e.audit(ctx, newName, AuditClone, map[string]any{"source": sourceBranch, "tables": len(tables)})
+	return nil
+}
+
+// cloneTrackedTable copies one overlay table into the new branch and tracks it.
+func (e *Engine) cloneTrackedTable(ctx context.Context, pool *pgxpool.Pool, fromSchema, toSchema, newName string, t *storage.TrackedTable) error {
+	rows, err := CloneOverlayTable(ctx, pool, fromSchema, toSchema, t.TableName)
+	if err != nil {
+		return err
+	}
+
+	// LIKE copies the _rift_updated_at column but not its trigger
+	if err := AddUpdatedAtTracking(ctx, pool, toSchema, t.TableName); err != nil {
+		return err
+	}
+
+	if e.trackDeltaSize {
+		if err := AddDeltaSizeTrigger(ctx, pool, toSchema, t.TableName, newName); err != nil {
+			return err
+		}
+	}
+
+	if err := e.store.TrackTable(ctx, &storage.TrackedTable{
+		BranchName:    newName,
+		SourceSchema:  t.SourceSchema,
+		TableName:     t.TableName,
+		OverlayTable:  t.OverlayTable,
+		HasTombstones: t.HasTombstones,
+	}); err != nil {
+		return err
+	}
+
+	return e.store.UpdateTrackedTableRowCount(ctx, newName, t.SourceSchema, t.TableName, rows)
+}
+
+// DeleteBranch soft-deletes a branch: it is hidden from listings and refuses
+// connections, but its overlay schema is kept so RestoreBranch can bring it
+// back until it is purged. It verifies the branch exists, is not pinned, and
+// has no children before proceeding.
 func (e *Engine) DeleteBranch(ctx context.Context, name string) error {
+	branch, err := e.checkDeletable(ctx, name, false)
+	if err != nil {
+		return err
+	}
+	if branch.DeletedAt != nil {
+		return fmt.Errorf("branch %q is already deleted", name)
+	}
+
+	if err := e.store.SetBranchDeleted(ctx, name, true); err != nil {
+		return err
+	}
+
+	e.audit(ctx, name, AuditDelete, map[string]any{"parent": branch.Parent})
+	return nil
+}
+
+// checkDeletable returns the branch if it may be deleted: it is not pinned
+// and has no child branches. Purging also counts soft-deleted children, whose
+// overlays would be left without a parent to restore onto.
+func (e *Engine) checkDeletable(ctx context.Context, name string, purge bool) (*storage.Branch, error) {
 	branch, err := e.store.GetBranch(ctx, name)
 	if err != nil {
-		return fmt.Errorf("get branch: %w", err)
+		return nil, fmt.Errorf("get branch: %w", err)
 	}
 
 	if branch.Pinned {
-		return fmt.Errorf("cannot delete pinned branch %q", name)
+		return nil, fmt.Errorf("cannot delete pinned branch %q", name)
 	}
 
 	// Check for child branches that depend on this one.
-	branches, err := e.store.ListBranches(ctx)
+	children, err := e.store.ListBranchesByParent(ctx, name)
 	if err != nil {
-		return fmt.Errorf("list branches: %w", err)
+		return nil, fmt.Errorf("list child branches: %w", err)
 	}
-	for _, b := range branches {
-		if b.Parent == name {
-			return fmt.Errorf("cannot delete branch %q: has child branch %q", name, b.Name)
+	for _, child := range children {
+		if purge || child.DeletedAt == nil {
+			return nil, fmt.Errorf("cannot delete branch %q: has child branch %q", name, child.Name)
 		}
 	}
-
-	if err := e.store.DropBranchSchema(ctx, name); err != nil {
-		return fmt.Errorf("drop branch schema: %w", err)
-	}
-	return e.store.DeleteBranch(ctx, name)
+	return branch, nil
 }
 
-// Diff computes changes between a branch and its parent.
-func (e *Engine) Diff(ctx context.Context, branchName string) (*BranchDiff, error) {
+// Diff computes changes between a branch and its parent, for the tables of
+// sourceSchema only unless it is "".
+func (e *Engine) Diff(ctx context.Context, branchName, sourceSchema string) (*BranchDiff, error) {
 	branch, err := e.store.GetBranch(ctx, branchName)
 	if err != nil {
 		return nil, fmt.Errorf("get branch: %w", err)
 	}
 
-	tables, err := e.store.ListTrackedTables(ctx, branchName)
+	tables, err := e.store.ListTrackedTables(ctx, branchName, sourceSchema)
 	if err != nil {
 		return nil, fmt.Errorf("list tracked tables: %w", err)
 	}
@@ -191,16 +459,11 @@ func (e *Engine) Diff(ctx context.Context, branchName string) (*BranchDiff, erro
 	}
 
 	for _, t := range tables {
-		pks, err := e.store.GetPrimaryKeys(ctx, t.SourceSchema, t.TableName)
+		pkCols, err := e.getPKColumns(ctx, t.SourceSchema, t.TableName, branchSchema)
 		if err != nil {
 			return nil, fmt.Errorf("get PKs for %s: %w", t.TableName, err)
 		}
 
-		pkCols := make([]string, len(pks))
-		for i, pk := range pks {
-			pkCols[i] = pk.ColumnName
-		}
-
 		td, err := DiffTable(ctx, pool, branchSchema, t.SourceSchema, t.TableName, pkCols)
 		if err != nil {
 			return nil, fmt.Errorf("diff table %s: %w", t.TableName, err)
@@ -212,29 +475,257 @@ func (e *Engine) Diff(ctx context.Context, branchName string) (*BranchDiff, erro
 	return diff, nil
 }
 
-// GenerateMerge produces SQL to apply branch changes to the parent.
-func (e *Engine) GenerateMerge(ctx context.Context, branchName string) ([]MergeSQL, error) {
-	tables, err := e.store.ListTrackedTables(ctx, branchName)
+// DiffBranches compares the data two branches see, for every table either
+// branch tracks, or only those of sourceSchema unless it is "". Counts are
+// from branchA's point of view: inserts are rows only branchA sees, deletes
+// are rows only branchB sees. The result's Parent is the branches' nearest
+// common ancestor.
+func (e *Engine) DiffBranches(ctx context.Context, branchA, branchB, sourceSchema string) (*BranchDiff, error) {
+	if branchA == branchB {
+		return nil, fmt.Errorf("cannot diff branch %q against itself", branchA)
+	}
+
+	lineageA, err := e.Lineage(ctx, branchA)
+	if err != nil {
+		return nil, err
+	}
+	lineageB, err := e.Lineage(ctx, branchB)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &BranchDiff{
+		BranchName: branchA,
+		Parent:     commonAncestor(lineageA, lineageB),
+		Target:     branchB,
+	}
+
+	// Tables tracked by either branch, keyed to the overlay schema of each
+	// branch that tracks them.
+	type trackedBy struct {
+		table            *storage.TrackedTable
+		schemaA, schemaB string
+	}
+	var order []string
+	tables := make(map[string]*trackedBy)
+	for _, side := range []string{branchA, branchB} {
+		if side == "main" {
+			continue
+		}
+		tracked, err := e.store.ListTrackedTables(ctx, side, sourceSchema)
+		if err != nil {
+			return nil, fmt.Errorf("list tracked tables: %w", err)
+		}
+		for _, t := range tracked {
+			key := t.SourceSchema + "." + t.TableName
+			tb, ok := tables[key]
+			if !ok {
+				tb = &trackedBy{table: t}
+				tables[key] = tb
+				order = append(order, key)
+			}
+			if side == branchA {
+				tb.schemaA = e.store.BranchSchemaName(side)
+			} else {
+				tb.schemaB = e.store.BranchSchemaName(side)
+			}
+		}
+	}
+
+	pool := e.store.Pool()
+	for _, key := range order {
+		tb := tables[key]
+		t := tb.table
+
+		cols, err := e.introspectTable(ctx, pool, t.SourceSchema, t.TableName)
+		if err != nil {
+			return nil, fmt.Errorf("introspect %s: %w", t.TableName, err)
+		}
+		colNames := make([]string, len(cols))
+		for i, c := range cols {
+			colNames[i] = c.Name
+		}
+
+		overlay := tb.schemaA
+		if overlay == "" {
+			overlay = tb.schemaB
+		}
+		pkCols, err := e.getPKColumns(ctx, t.SourceSchema, t.TableName, overlay)
+		if err != nil {
+			return nil, fmt.Errorf("get PKs for %s: %w", t.TableName, err)
+		}
+
+		td, err := DiffMergedTables(ctx, pool, tb.schemaA, tb.schemaB, t.SourceSchema, t.TableName, colNames, pkCols)
+		if err != nil {
+			return nil, fmt.Errorf("diff table %s: %w", t.TableName, err)
+		}
+		diff.Tables = append(diff.Tables, *td)
+	}
+
+	return diff, nil
+}
+
+// Lineage returns name followed by its ancestors, nearest first, ending at
+// the root branch (normally main).
+func (e *Engine) Lineage(ctx context.Context, name string) ([]string, error) {
+	var lineage []string
+	seen := make(map[string]bool)
+	for name != "" && !seen[name] {
+		b, err := e.store.GetBranch(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("get branch %s: %w", name, err)
+		}
+		seen[name] = true
+		lineage = append(lineage, name)
+		name = b.Parent
+	}
+	return lineage, nil
+}
+
+// DiffTable lists the rows a branch changed in one table. tableName may be
+// schema-qualified; maxRows caps each change kind (0 means no limit).
+func (e *Engine) DiffTable(ctx context.Context, branchName, tableName string, maxRows int) (*TableDiffDetailed, error) {
+	if _, err := e.store.GetBranch(ctx, branchName); err != nil {
+		return nil, fmt.Errorf("get branch: %w", err)
+	}
+
+	tables, err := e.store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return nil, fmt.Errorf("list tracked tables: %w", err)
+	}
+
+	schema, name := "", tableName
+	if i := strings.LastIndex(tableName, "."); i >= 0 {
+		schema, name = tableName[:i], tableName[i+1:]
+	}
+
+	for _, t := range tables {
+		if t.TableName != name || (schema != "" && t.SourceSchema != schema) {
+			continue
+		}
+
+		pool := e.store.Pool()
+		branchSchema := e.store.BranchSchemaName(branchName)
+		cols, err := e.introspectTable(ctx, pool, t.SourceSchema, t.TableName)
+		if err != nil {
+			return nil, fmt.Errorf("introspect %s: %w", t.TableName, err)
+		}
+		colNames := make([]string, len(cols))
+		for i, c := range cols {
+			colNames[i] = c.Name
+		}
+
+		pkCols, err := e.getPKColumns(ctx, t.SourceSchema, t.TableName, branchSchema)
+		if err != nil {
+			return nil, fmt.Errorf("get PKs for %s: %w", t.TableName, err)
+		}
+
+		d, err := DiffTableRows(ctx, pool, branchSchema, t.SourceSchema, t.TableName, colNames, pkCols, maxRows)
+		if err != nil {
+			return nil, fmt.Errorf("diff table %s: %w", t.TableName, err)
+		}
+		return d, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s has no changes in branch %s", ErrTableNotFound, tableName, branchName)
+}
+
+// GenerateMerge produces SQL to apply branch changes to the parent. A
+// non-empty only limits the merge to those tables ("table" or
+// "schema.table"); the branch's other tables are left as they are. opts
+// selects the kinds of change to merge; the rest stay on the branch.
+func (e *Engine) GenerateMerge(ctx context.Context, branchName string, only []string, opts MergeOptions) ([]MergeSQL, error) {
+	return e.generateMerge(ctx, branchName, only, func(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string, pkCols []string) (*MergeSQL, error) {
+		return GenerateMergeSQL(ctx, pool, branchSchema, sourceSchema, tableName, pkCols, opts)
+	})
+}
+
+// generateMerge is GenerateMerge with the SQL for each table built by gen.
+func (e *Engine) generateMerge(ctx context.Context, branchName string, only []string,
+	gen func(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string, pkCols []string) (*MergeSQL, error),
+) ([]MergeSQL, error) {
+	tables, err := e.store.ListTrackedTables(ctx, branchName, "")
 	if err != nil {
 		return nil, fmt.Errorf("list tracked tables: %w", err)
 	}
+	tables, err = filterTrackedTables(tables, only, branchName)
+	if err != nil {
+		return nil, err
+	}
 
 	pool := e.store.Pool()
 	branchSchema := e.store.BranchSchemaName(branchName)
 
 	var merges []MergeSQL
 	for _, t := range tables {
-		pks, err := e.store.GetPrimaryKeys(ctx, t.SourceSchema, t.TableName)
+		pkCols, err := e.getPKColumns(ctx, t.SourceSchema, t.TableName, branchSchema)
 		if err != nil {
 			return nil, fmt.Errorf("get PKs for %s: %w", t.TableName, err)
 		}
 
-		pkCols := make([]string, len(pks))
-		for i, pk := range pks {
-			pkCols[i] = pk.ColumnName
+		m, err := gen(ctx, pool, branchSchema, t.SourceSchema, t.TableName, pkCols)
+		if err != nil {
+			return nil, fmt.Errorf("generate merge for %s: %w", t.TableName, err)
+		}
+
+		merges = append(merges, *m)
+	}
+
+	return e.orderMerges(ctx, merges)
+}
+
+// GenerateMergeInto produces SQL to apply sourceBranch's changes to
+// targetBranch's overlay rather than to the source tables, for merges between
+// branches that aren't parent and child. Target overlay tables that don't
+// exist yet are created and tracked so the generated SQL can run. A target of
+// "main" is the same as GenerateMerge. only filters tables as in GenerateMerge.
+func (e *Engine) GenerateMergeInto(ctx context.Context, sourceBranch, targetBranch string, only []string) ([]MergeSQL, error) {
+	if targetBranch == "main" {
+		return e.GenerateMerge(ctx, sourceBranch, only, MergeAll)
+	}
+	if sourceBranch == targetBranch {
+		return nil, fmt.Errorf("cannot merge branch %q into itself", sourceBranch)
+	}
+
+	if _, err := e.store.GetBranch(ctx, sourceBranch); err != nil {
+		return nil, fmt.Errorf("source branch: %w", err)
+	}
+	target, err := e.store.GetBranch(ctx, targetBranch)
+	if err != nil {
+		return nil, fmt.Errorf("target branch: %w", err)
+	}
+	if target.Protected {
+		return nil, fmt.Errorf("target branch %q: %w", targetBranch, ErrBranchProtected)
+	}
+	if target.Frozen {
+		return nil, fmt.Errorf("target branch %q: %w", targetBranch, ErrBranchFrozen)
+	}
+
+	tables, err := e.store.ListTrackedTables(ctx, sourceBranch, "")
+	if err != nil {
+		return nil, fmt.Errorf("list tracked tables: %w", err)
+	}
+	tables, err = filterTrackedTables(tables, only, sourceBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := e.store.Pool()
+	fromSchema := e.store.BranchSchemaName(sourceBranch)
+	toSchema := e.store.BranchSchemaName(targetBranch)
+
+	var merges []MergeSQL
+	for _, t := range tables {
+		if err := e.ensureTargetOverlay(ctx, pool, toSchema, targetBranch, t); err != nil {
+			return nil, fmt.Errorf("prepare %s in %s: %w", t.TableName, targetBranch, err)
+		}
+
+		pkCols, err := e.getPKColumns(ctx, t.SourceSchema, t.TableName, fromSchema)
+		if err != nil {
+			return nil, fmt.Errorf("get PKs for %s: %w", t.TableName, err)
 		}
 
-		m, err := GenerateMergeSQL(ctx, pool, branchSchema, t.SourceSchema, t.TableName, pkCols)
+		m, err := GenerateOverlayMergeSQL(ctx, pool, fromSchema, toSchema, t.SourceSchema, t.TableName, pkCols)
 		if err != nil {
 			return nil, fmt.Errorf("generate merge for %s: %w", t.TableName, err)
 		}
@@ -242,19 +733,358 @@ func (e *Engine) GenerateMerge(ctx context.Context, branchName string) ([]MergeS
 		merges = append(merges, *m)
 	}
 
-	return merges, nil
+	return e.orderMerges(ctx, merges)
+}
+
+// filterTrackedTables keeps the tables named in only, as "table" or
+// "schema.table". An empty only keeps every table; a name the branch doesn't
+// track is an error, so that a typo doesn't silently merge nothing.
+func filterTrackedTables(tables []*storage.TrackedTable, only []string, branchName string) ([]*storage.TrackedTable, error) {
+	if len(only) == 0 {
+		return tables, nil
+	}
+
+	var kept []*storage.TrackedTable
+	seen := make(map[*storage.TrackedTable]bool)
+	for _, name := range only {
+		found := false
+		for _, t := range tables {
+			if name != t.TableName && name != t.SourceSchema+"."+t.TableName {
+				continue
+			}
+			found = true
+			if !seen[t] {
+				kept = append(kept, t)
+				seen[t] = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s is not tracked by branch %s", ErrTableNotFound, name, branchName)
+		}
+	}
+	return kept, nil
+}
+
+// ensureTargetOverlay creates and tracks the target branch's overlay for a
+// table tracked by the branch being merged into it.
+func (e *Engine) ensureTargetOverlay(ctx context.Context, pool *pgxpool.Pool, toSchema, targetBranch string, t *storage.TrackedTable) error {
+	opts := OverlayOptions{TrackDeltaSize: e.trackDeltaSize, BranchName: targetBranch}
+	if err := EnsureOverlayTable(ctx, pool, toSchema, t.SourceSchema, t.TableName, opts); err != nil {
+		return err
+	}
+	return e.store.TrackTable(ctx, &storage.TrackedTable{
+		BranchName:   targetBranch,
+		SourceSchema: t.SourceSchema,
+		TableName:    t.TableName,
+		OverlayTable: t.OverlayTable,
+	})
+}
+
+// ExecuteMerge applies a branch's changes to the parent in a single
+// transaction. A positive timeout bounds the whole transaction and sets
+// statement_timeout and lock_timeout; if it is exceeded the transaction is
+// rolled back and the error reports how far the merge got. only filters
+// tables and opts selects changes as in GenerateMerge.
+func (e *Engine) ExecuteMerge(ctx context.Context, branchName string, only []string, opts MergeOptions, timeout time.Duration) (*MergeResult, error) {
+	return e.ExecuteMergeResolved(ctx, branchName, only, opts, timeout, nil)
+}
+
+// ExecuteMergeResolved is ExecuteMerge with conflict resolutions applied to
+// the merge, as by ResolveConflicts.
+func (e *Engine) ExecuteMergeResolved(ctx context.Context, branchName string, only []string, opts MergeOptions, timeout time.Duration, resolutions []ConflictResolution) (*MergeResult, error) {
+	merges, err := e.GenerateMerge(ctx, branchName, only, opts)
+	if err != nil {
+		return nil, err
+	}
+	if merges, err = ResolveConflicts(merges, resolutions); err != nil {
+		return nil, err
+	}
+	migrations, err := e.PendingMigrations(ctx, branchName)
+	if err != nil {
+		return nil, err
+	}
+	result, err := e.executeMerges(ctx, merges, migrations, timeout, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	e.auditMerge(ctx, branchName, "parent", only, opts, result, len(resolutions))
+	return result, nil
+}
+
+// ExecuteMergeInto applies sourceBranch's changes to targetBranch's overlay,
+// like ExecuteMerge does for the parent.
+func (e *Engine) ExecuteMergeInto(ctx context.Context, sourceBranch, targetBranch string, only []string, timeout time.Duration) (*MergeResult, error) {
+	if targetBranch == "main" {
+		return e.ExecuteMerge(ctx, sourceBranch, only, MergeAll, timeout)
+	}
+	merges, err := e.GenerateMergeInto(ctx, sourceBranch, targetBranch, only)
+	if err != nil {
+		return nil, err
+	}
+	result, err := e.executeMerges(ctx, merges, nil, timeout, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	e.auditMerge(ctx, sourceBranch, targetBranch, only, MergeAll, result, 0)
+	return result, nil
+}
+
+// auditMerge records a merge that changed something, with the number of
+// conflicts resolved for it.
+func (e *Engine) auditMerge(ctx context.Context, branchName, target string, only []string, opts MergeOptions, result *MergeResult, resolved int) {
+	if result.Tables == 0 && result.Migrations == 0 {
+		return
+	}
+	details := map[string]any{
+		"target":        target,
+		"tables":        result.Tables,
+		"statements":    result.Statements,
+		"rows_affected": result.RowsAffected,
+		"migrations":    result.Migrations,
+	}
+	if len(only) > 0 {
+		details["only_tables"] = only
+	}
+	if opts != MergeAll {
+		details["only_changes"] = opts.kinds()
+	}
+	if resolved > 0 {
+		details["resolved_conflicts"] = resolved
+	}
+	e.audit(ctx, branchName, AuditMerge, details)
+}
+
+// PendingMigrations returns the migrations applied to a branch with
+// 'rift migrate' that haven't been merged into its parent yet, oldest first.
+func (e *Engine) PendingMigrations(ctx context.Context, branchName string) ([]*storage.AppliedMigration, error) {
+	all, err := e.store.ListMigrations(ctx, branchName)
+	if err != nil {
+		return nil, err
+	}
+	var pending []*storage.AppliedMigration
+	for _, m := range all {
+		if m.MergedAt == nil {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// mergeCheck runs inside a merge's transaction: before once the migrations
+// have run, after once every merge step has. An error from either rolls the
+// merge back and is returned as is.
+type mergeCheck struct {
+	before func(ctx context.Context, tx pgx.Tx) error
+	after  func(ctx context.Context, tx pgx.Tx) error
+}
+
+// executeMerges runs migrations and then merge steps in a single transaction
+// (see ExecuteMerge). Migrations are marked as merged in the same transaction.
+// A non-nil check runs around the merge steps.
+func (e *Engine) executeMerges(ctx context.Context, merges []MergeSQL, migrations []*storage.AppliedMigration, timeout time.Duration, check *mergeCheck) (*MergeResult, error) {
+	if len(merges) == 0 && len(migrations) == 0 {
+		return &MergeResult{}, nil
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	tx, err := e.store.Pool().Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin merge: %w", err)
+	}
+	defer func() { _ = tx.Rollback(context.Background()) }() // no-op after commit
+
+	for _, stmt := range timeoutSettings(timeout) {
+		if _, err := tx.Exec(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("set merge timeout: %w", err)
+		}
+	}
+
+	result := &MergeResult{}
+	for _, m := range migrations {
+		if _, err := tx.Exec(ctx, m.SQL); err != nil {
+			if isTimeout(err) {
+				return nil, fmt.Errorf("merge timed out after %s in migration %s; transaction rolled back: %w",
+					timeout, m.FileName, err)
+			}
+			return nil, fmt.Errorf("migration %s: %w", m.FileName, err)
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE _rift.applied_migrations SET merged_at = now()
+			 WHERE branch_name = $1 AND file_hash = $2`,
+			m.BranchName, m.FileHash); err != nil {
+			return nil, fmt.Errorf("mark migration %s merged: %w", m.FileName, err)
+		}
+		result.Migrations++
+	}
+
+	if check != nil {
+		if err := check.before(ctx, tx); err != nil {
+			return nil, err
+		}
+	}
+
+	steps := mergeSteps(merges)
+	remaining := make([]int, len(merges))
+	for _, st := range steps {
+		remaining[st.table]++
+	}
+
+	for _, st := range steps {
+		tag, err := tx.Exec(ctx, st.sql)
+		if err != nil {
+			if isTimeout(err) {
+				return nil, fmt.Errorf("merge timed out after %s (%d of %d tables processed); transaction rolled back: %w",
+					timeout, result.Tables, len(merges), err)
+			}
+			return nil, fmt.Errorf("merge %s: %w", merges[st.table].TableName, err)
+		}
+		result.Statements++
+		result.RowsAffected += tag.RowsAffected()
+		remaining[st.table]--
+		if remaining[st.table] == 0 {
+			result.Tables++
+		}
+	}
+
+	if check != nil {
+		if err := check.after(ctx, tx); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit merge: %w", err)
+	}
+	return result, nil
+}
+
+// orderMerges sorts merges so that tables come after the tables they
+// reference through foreign keys.
+func (e *Engine) orderMerges(ctx context.Context, merges []MergeSQL) ([]MergeSQL, error) {
+	if len(merges) < 2 {
+		return merges, nil
+	}
+
+	tables := make([]QualifiedTable, len(merges))
+	byKey := make(map[string]MergeSQL, len(merges))
+	for i, m := range merges {
+		tables[i] = QualifiedTable{Schema: m.SourceSchema, Name: m.TableName}
+		byKey[m.SourceSchema+"."+m.TableName] = m
+	}
+	order, err := e.sortTables(ctx, tables)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := make([]MergeSQL, 0, len(merges))
+	for _, key := range order {
+		sorted = append(sorted, byKey[key])
+	}
+	return sorted, nil
+}
+
+// MergeTableOrder returns the tables a branch tracks, as "schema.table", in
+// the order a merge inserts and updates their rows: tables referenced
+// through foreign keys come before the tables that reference them. Deletes
+// run in the reverse order.
+func (e *Engine) MergeTableOrder(ctx context.Context, branchName string) ([]string, error) {
+	tracked, err := e.store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return nil, fmt.Errorf("list tracked tables: %w", err)
+	}
+	tables := make([]QualifiedTable, len(tracked))
+	for i, t := range tracked {
+		tables[i] = QualifiedTable{Schema: t.SourceSchema, Name: t.TableName}
+	}
+	return e.sortTables(ctx, tables)
+}
+
+// sortTables orders tables, as "schema.table", by their foreign keys with
+// sortByDependencies, and logs a warning about tables caught in a reference
+// cycle, which go last in alphabetical order.
+func (e *Engine) sortTables(ctx context.Context, tables []QualifiedTable) ([]string, error) {
+	pool := e.store.Pool()
+	keys := make([]string, len(tables))
+	deps := make(map[string][]string, len(tables))
+	for i, t := range tables {
+		key := t.Schema + "." + t.Name
+		keys[i] = key
+
+		fks, err := IntrospectForeignKeys(ctx, pool, t.Schema, t.Name)
+		if err != nil {
+			return nil, fmt.Errorf("get foreign keys for %s: %w", t.Name, err)
+		}
+		for _, fk := range fks {
+			deps[key] = append(deps[key], fk.RefSchema+"."+fk.RefTable)
+		}
+	}
+
+	sorted, cyclic := sortByDependencies(keys, deps)
+	if len(cyclic) > 0 {
+		slog.Warn("circular foreign keys between merged tables; merging them in alphabetical order", "tables", cyclic)
+	}
+	return sorted, nil
+}
+
+// resolveSchema returns the source schema for a table reference. Qualified
+// references keep their schema. Unqualified ones resolve to the first schema in
+// searchPath that contains the table, mirroring PostgreSQL name resolution;
+// tables not found anywhere (e.g. CREATE TABLE targets) get the first usable
+// schema in the path. With no search path, the schema defaults to "public".
+func (e *Engine) resolveSchema(ctx context.Context, tbl parser.TableRef, searchPath []string) (string, error) {
+	if tbl.Schema != "" {
+		return tbl.Schema, nil
+	}
+
+	schemas := usableSchemas(searchPath)
+	if len(schemas) == 0 {
+		return "public", nil
+	}
+
+	pool := e.store.Pool()
+	for _, schema := range schemas {
+		exists, err := TableExists(ctx, pool, schema, tbl.Name)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return schema, nil
+		}
+	}
+	return schemas[0], nil
+}
+
+// usableSchemas filters a search path down to schemas that may hold user tables.
+// "$user", system schemas, and rift's own schemas are skipped.
+func usableSchemas(searchPath []string) []string {
+	var schemas []string
+	for _, s := range searchPath {
+		if s == "$user" || s == "pg_catalog" || s == "pg_temp" || s == "information_schema" ||
+			strings.HasPrefix(s, "_rift") {
+			continue
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas
 }
 
 // buildRewriteConfigs creates parser.RewriteConfig for each table referenced in the query.
-func (e *Engine) buildRewriteConfigs(ctx context.Context, branchName string, pq *parser.ParsedQuery) (map[string]parser.RewriteConfig, error) {
+func (e *Engine) buildRewriteConfigs(ctx context.Context, branchName string, pq *parser.ParsedQuery, searchPath []string) (map[string]parser.RewriteConfig, error) {
 	configs := make(map[string]parser.RewriteConfig)
 	branchSchema := e.store.BranchSchemaName(branchName)
 	pool := e.store.Pool()
 
 	for _, tbl := range pq.Tables {
-		schema := tbl.Schema
-		if schema == "" {
-			schema = "public"
+		schema, err := e.resolveSchema(ctx, tbl, searchPath)
+		if err != nil {
+			return nil, err
 		}
 
 		// Check if overlay exists for this table
@@ -263,11 +1093,12 @@ func (e *Engine) buildRewriteConfigs(ctx context.Context, branchName string, pq
 			return nil, err
 		}
 
-		if !exists && pq.IsReadOnly() {
-			// For reads, if no overlay exists, the table hasn't been modified in this branch.
+		if !exists && (pq.IsReadOnly() || tbl.IsJoinSource) {
+			// For reads (including UPDATE ... FROM join sources), if no overlay
+			// exists, the table hasn't been modified in this branch.
 			// Still create a config so reads see the source data correctly,
 			// but only if we know the table has tracked changes.
-			trackedTables, err := e.store.ListTrackedTables(ctx, branchName)
+			trackedTables, err := e.store.ListTrackedTables(ctx, branchName, "")
 			if err != nil {
 				return nil, err
 			}
@@ -284,15 +1115,29 @@ func (e *Engine) buildRewriteConfigs(ctx context.Context, branchName string, pq
 		}
 
 		// Get primary keys
-		pkCols, err := e.getPKColumns(ctx, schema, tbl.Name)
+		pkCols, err := e.getPKColumns(ctx, schema, tbl.Name, branchSchema)
 		if err != nil {
 			return nil, fmt.Errorf("get PKs for %s: %w", tbl.Name, err)
 		}
 
+		// Only reads go through the capped merged view
+		maxRows := 0
+		if e.maxOverlayRows > 0 && exists && (pq.IsReadOnly() || tbl.IsJoinSource) {
+			over, err := overlayExceeds(ctx, pool, branchSchema, tbl.Name, e.maxOverlayRows)
+			if err != nil {
+				return nil, err
+			}
+			if over {
+				maxRows = e.maxOverlayRows
+			}
+		}
+
 		configs[tbl.Name] = parser.RewriteConfig{
 			BranchSchema: branchSchema,
 			SourceSchema: schema,
 			PKColumns:    pkCols,
+			MaxRows:      maxRows,
+			CTEMode:      e.cteMode,
 		}
 	}
 
@@ -300,14 +1145,19 @@ func (e *Engine) buildRewriteConfigs(ctx context.Context, branchName string, pq
 }
 
 // ensureOverlays creates overlay tables for any tables that don't have them yet.
-func (e *Engine) ensureOverlays(ctx context.Context, branchName string, pq *parser.ParsedQuery) error {
+func (e *Engine) ensureOverlays(ctx context.Context, branchName string, pq *parser.ParsedQuery, searchPath []string) error {
 	pool := e.store.Pool()
-	branchSchema := e.store.BranchSchemaName(branchName)
+	var pkEntries []storage.PrimaryKeyColumn
 
 	for _, tbl := range pq.Tables {
-		schema := tbl.Schema
-		if schema == "" {
-			schema = "public"
+		// Join sources are only read
+		if tbl.IsJoinSource {
+			continue
+		}
+
+		schema, err := e.resolveSchema(ctx, tbl, searchPath)
+		if err != nil {
+			return err
 		}
 
 		// Skip if it's a rift internal table
@@ -328,48 +1178,69 @@ func (e *Engine) ensureOverlays(ctx context.Context, branchName string, pq *pars
 			return fmt.Errorf("source table %s.%s does not exist", schema, tbl.Name)
 		}
 
-		// Create overlay table
-		if err := EnsureOverlayTable(ctx, pool, branchSchema, schema, tbl.Name); err != nil {
-			return fmt.Errorf("ensure overlay for %s: %w", tbl.Name, err)
-		}
-
-		// Cache PKs
-		pkCols, err := GetTablePrimaryKeys(ctx, pool, schema, tbl.Name)
+		pks, err := e.trackSourceTable(ctx, branchName, schema, tbl.Name)
 		if err != nil {
-			return fmt.Errorf("get PKs for %s: %w", tbl.Name, err)
+			return err
 		}
+		pkEntries = append(pkEntries, pks...)
+	}
 
-		var pkEntries []storage.PrimaryKeyColumn
-		for i, col := range pkCols {
-			pkEntries = append(pkEntries, storage.PrimaryKeyColumn{
-				SourceSchema: schema,
-				TableName:    tbl.Name,
-				ColumnName:   col,
-				Ordinal:      i + 1,
-			})
-		}
-		if err := e.store.CachePrimaryKeys(ctx, pkEntries); err != nil {
-			return fmt.Errorf("cache PKs for %s: %w", tbl.Name, err)
-		}
+	if err := e.store.BulkCachePrimaryKeys(ctx, pkEntries); err != nil {
+		return fmt.Errorf("cache PKs: %w", err)
+	}
+	return nil
+}
 
-		// Track the table
-		tracked := &storage.TrackedTable{
-			BranchName:    branchName,
-			SourceSchema:  schema,
-			TableName:     tbl.Name,
-			OverlayTable:  tbl.Name,
-			HasTombstones: false,
+// trackSourceTable creates a branch's overlay table for a source table and
+// tracks it, returning the table's primary key columns for the caller to
+// cache in one batch.
+func (e *Engine) trackSourceTable(ctx context.Context, branchName, schema, table string) ([]storage.PrimaryKeyColumn, error) {
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+	opts := OverlayOptions{TrackDeltaSize: e.trackDeltaSize, BranchName: branchName}
+	err := EnsureOverlayTable(ctx, pool, branchSchema, schema, table, opts)
+	if isStructuralError(err) {
+		// A half-created overlay, e.g. one whose creation was interrupted
+		slog.Warn("repairing overlay table", "branch", branchName, "table", schema+"."+table, "error", err)
+		if repairErr := RepairOverlay(ctx, pool, branchSchema, schema, table); repairErr != nil {
+			return nil, fmt.Errorf("ensure overlay for %s: %w (repair failed: %v)", table, err, repairErr)
 		}
-		if err := e.store.TrackTable(ctx, tracked); err != nil {
-			return fmt.Errorf("track table %s: %w", tbl.Name, err)
+		err = EnsureOverlayTable(ctx, pool, branchSchema, schema, table, opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ensure overlay for %s: %w", table, err)
+	}
+
+	pkCols, err := GetTablePrimaryKeys(ctx, pool, schema, table)
+	if err != nil {
+		return nil, fmt.Errorf("get PKs for %s: %w", table, err)
+	}
+	pkEntries := make([]storage.PrimaryKeyColumn, len(pkCols))
+	for i, col := range pkCols {
+		pkEntries[i] = storage.PrimaryKeyColumn{
+			SourceSchema: schema,
+			TableName:    table,
+			ColumnName:   col,
+			Ordinal:      i + 1,
 		}
 	}
 
-	return nil
+	tracked := &storage.TrackedTable{
+		BranchName:    branchName,
+		SourceSchema:  schema,
+		TableName:     table,
+		OverlayTable:  table,
+		HasTombstones: false,
+	}
+	if err := e.store.TrackTable(ctx, tracked); err != nil {
+		return nil, fmt.Errorf("track table %s: %w", table, err)
+	}
+	return pkEntries, nil
 }
 
-// getPKColumns returns PK column names, using cache first.
-func (e *Engine) getPKColumns(ctx context.Context, schema, table string) ([]string, error) {
+// getPKColumns returns PK column names, using cache first. branchSchemas are
+// searched after schema for tables that only exist on a branch.
+func (e *Engine) getPKColumns(ctx context.Context, schema, table string, branchSchemas ...string) ([]string, error) {
 	// Try cache first
 	cached, err := e.store.GetPrimaryKeys(ctx, schema, table)
 	if err == nil && len(cached) > 0 {
@@ -381,5 +1252,5 @@ func (e *Engine) getPKColumns(ctx context.Context, schema, table string) ([]stri
 	}
 
 	// Fall back to information_schema
-	return GetTablePrimaryKeys(ctx, e.store.Pool(), schema, table)
+	return GetTablePrimaryKeys(ctx, e.store.Pool(), schema, table, branchSchemas...)
 }
@@ -0,0 +1,69 @@
+package cow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// MergeExplain is the query plan of one step of a table's merge.
+type MergeExplain struct {
+	TableName    string
+	SourceSchema string
+	Step         string // "delete", "update" or "insert"
+	SQL          string
+	Plan         string
+}
+
+// ExplainMerge returns the query plan of each step of merges, table by table
+// in the order given, without running them. Plans come from EXPLAIN without
+// ANALYZE, inside a read-only transaction that is rolled back, so nothing is
+// modified. The plans are for the parent's current schema; a step that needs
+// a pending migration fails to plan.
+func (e *Engine) ExplainMerge(ctx context.Context, merges []MergeSQL) ([]MergeExplain, error) {
+	tx, err := e.store.Pool().BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var plans []MergeExplain
+	for _, m := range merges {
+		for _, step := range []struct{ name, sql string }{
+			{"delete", m.DeleteSQL},
+			{"update", m.UpdateSQL},
+			{"insert", m.InsertSQL},
+		} {
+			if step.sql == "" {
+				continue
+			}
+			plan, err := explain(ctx, tx, step.sql)
+			if err != nil {
+				return nil, fmt.Errorf("explain %s of %s: %w", step.name, m.TableName, err)
+			}
+			plans = append(plans, MergeExplain{
+				TableName:    m.TableName,
+				SourceSchema: m.SourceSchema,
+				Step:         step.name,
+				SQL:          step.sql,
+				Plan:         plan,
+			})
+		}
+	}
+	return plans, nil
+}
+
+// explain returns the text plan of sql, one plan line per line.
+func explain(ctx context.Context, tx pgx.Tx, sql string) (string, error) {
+	rows, err := tx.Query(ctx, "EXPLAIN (ANALYZE false, COSTS true, FORMAT TEXT) "+sql)
+	if err != nil {
+		return "", err
+	}
+	lines, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,124 @@
+package cow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrBranchFrozen is returned for writes and DDL on a frozen branch.
+var ErrBranchFrozen = errors.New("branch is frozen; unfreeze it to make changes")
+
+// Audited freeze operations.
+const (
+	AuditFreeze   = "freeze"
+	AuditUnfreeze = "unfreeze"
+)
+
+// overlayWritePrivileges are the privileges FreezeBranch revokes.
+const overlayWritePrivileges = "INSERT, UPDATE, DELETE"
+
+// FreezeBranch makes a branch read-only: write privileges on every table in
+// its overlay schema are revoked from PUBLIC and from the upstream user, so
+// writes fail in Postgres even if they bypass rift, and the branch is marked
+// frozen so the engine rejects them up front.
+func (e *Engine) FreezeBranch(ctx context.Context, branchName string) error {
+	if err := e.setOverlayPrivileges(ctx, branchName, true); err != nil {
+		return err
+	}
+	if err := e.store.SetBranchFrozen(ctx, branchName, true); err != nil {
+		return err
+	}
+	e.audit(ctx, branchName, AuditFreeze, nil)
+	return nil
+}
+
+// UnfreezeBranch restores write privileges on a frozen branch's overlay
+// tables for the upstream user and clears its frozen flag.
+func (e *Engine) UnfreezeBranch(ctx context.Context, branchName string) error {
+	if err := e.setOverlayPrivileges(ctx, branchName, false); err != nil {
+		return err
+	}
+	if err := e.store.SetBranchFrozen(ctx, branchName, false); err != nil {
+		return err
+	}
+	if e.resultCache != nil {
+		e.resultCache.InvalidateBranch(branchName)
+	}
+	e.audit(ctx, branchName, AuditUnfreeze, nil)
+	return nil
+}
+
+// setOverlayPrivileges revokes (or grants back) write privileges on all of a
+// branch's overlay tables in one transaction.
+func (e *Engine) setOverlayPrivileges(ctx context.Context, branchName string, revoke bool) error {
+	if branchName == "main" {
+		return fmt.Errorf("main has no overlay to freeze")
+	}
+	if _, err := e.store.GetBranch(ctx, branchName); err != nil {
+		return fmt.Errorf("get branch: %w", err)
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+	tables, err := listSchemaTables(ctx, pool, branchSchema)
+	if err != nil {
+		return fmt.Errorf("list overlay tables: %w", err)
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	for _, table := range tables {
+		for _, stmt := range overlayPrivilegeStatements(branchSchema, table, revoke) {
+			if _, err := tx.Exec(ctx, stmt); err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// overlayPrivilegeStatements returns the statements that revoke or restore
+// write privileges on one overlay table.
+func overlayPrivilegeStatements(branchSchema, table string, revoke bool) []string {
+	qualified := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(table)
+	if !revoke {
+		return []string{
+			fmt.Sprintf("GRANT %s ON %s TO CURRENT_USER", overlayWritePrivileges, qualified),
+		}
+	}
+	return []string{
+		fmt.Sprintf("REVOKE %s ON %s FROM PUBLIC", overlayWritePrivileges, qualified),
+		fmt.Sprintf("REVOKE %s ON %s FROM CURRENT_USER", overlayWritePrivileges, qualified),
+	}
+}
+
+// listSchemaTables returns the names of the tables (including partitioned
+// tables) in schema.
+func listSchemaTables(ctx context.Context, pool *pgxpool.Pool, schema string) ([]string, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT c.relname FROM pg_class c
+		 JOIN pg_namespace n ON n.oid = c.relnamespace
+		 WHERE n.nspname = $1 AND c.relkind IN ('r', 'p')
+		 ORDER BY c.relname`, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
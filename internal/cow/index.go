@@ -0,0 +1,61 @@
+package cow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/riftdata/rift/internal/parser"
+)
+
+// ErrSourceIndex is returned for DROP INDEX on a branch when the index
+// belongs to a source table. Overlays don't copy the source's indexes, so
+// the only way to drop it would be to drop it for every branch.
+var ErrSourceIndex = errors.New("index belongs to a source table; only indexes created on the branch can be dropped")
+
+// processDropIndex redirects DROP INDEX to the branch schema. Each index is
+// looked up in pg_class: one the branch created is dropped from the branch
+// schema, and one found only on a source table in the statement's schema or
+// search path is refused. An index that exists nowhere is still qualified
+// with the branch schema, so Postgres reports it missing (or skips it under
+// IF EXISTS) without the source being touched.
+func (e *Engine) processDropIndex(ctx context.Context, branchName string, pq *parser.ParsedQuery, searchPath []string) (*ProcessedQuery, error) {
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+
+	configs := make(map[string]parser.RewriteConfig, len(pq.Tables))
+	for _, idx := range pq.Tables {
+		schemas, err := IndexSchemas(ctx, pool, idx.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(schemas, branchSchema) {
+			candidates := []string{idx.Schema}
+			if idx.Schema == "" {
+				if candidates = usableSchemas(searchPath); len(candidates) == 0 {
+					candidates = []string{"public"}
+				}
+			}
+			for _, s := range candidates {
+				if slices.Contains(schemas, s) {
+					return nil, fmt.Errorf("drop index %s.%s: %w", s, idx.Name, ErrSourceIndex)
+				}
+			}
+		}
+		configs[idx.Name] = parser.RewriteConfig{BranchSchema: branchSchema, SourceSchema: idx.Schema}
+	}
+
+	result, err := parser.RewriteForBranch(pq, configs)
+	if err != nil {
+		return nil, fmt.Errorf("rewrite query: %w", err)
+	}
+	return &ProcessedQuery{
+		OriginalSQL:   pq.Original,
+		RewrittenSQL:  result.SQL,
+		Type:          pq.Type,
+		NeedsOverlay:  result.NeedsOverlay,
+		IsPassthrough: result.IsPassthrough,
+		TableName:     result.TableName,
+	}, nil
+}
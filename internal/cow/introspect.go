@@ -2,11 +2,16 @@ package cow
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
+	pgx "github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// ErrTableNotFound is returned when a table does not exist or has no columns.
+var ErrTableNotFound = errors.New("table not found")
+
 // ColumnDef describes a column in a table.
 type ColumnDef struct {
 	Name       string
@@ -44,7 +49,7 @@ func IntrospectTable(ctx context.Context, pool *pgxpool.Pool, schema, table stri
 	}
 
 	if len(cols) == 0 {
-		return nil, fmt.Errorf("table %s.%s not found or has no columns", schema, table)
+		return nil, fmt.Errorf("%w: %s.%s", ErrTableNotFound, schema, table)
 	}
 
 	// Mark PK columns
@@ -63,8 +68,150 @@ func IntrospectTable(ctx context.Context, pool *pgxpool.Pool, schema, table stri
 	return cols, nil
 }
 
-// GetTablePrimaryKeys returns the primary key column names for a table.
-func GetTablePrimaryKeys(ctx context.Context, pool *pgxpool.Pool, schema, table string) ([]string, error) {
+// ForeignKeyDef describes a foreign key constraint on a table.
+type ForeignKeyDef struct {
+	Name       string
+	Columns    []string
+	RefSchema  string
+	RefTable   string
+	RefColumns []string
+}
+
+// IntrospectForeignKeys returns the foreign keys defined on a table.
+func IntrospectForeignKeys(ctx context.Context, pool *pgxpool.Pool, schema, table string) ([]ForeignKeyDef, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT con.conname,
+		        ARRAY(SELECT a.attname FROM unnest(con.conkey) WITH ORDINALITY k(attnum, ord)
+		              JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum
+		              ORDER BY k.ord)::text[],
+		        rn.nspname, rc.relname,
+		        ARRAY(SELECT a.attname FROM unnest(con.confkey) WITH ORDINALITY k(attnum, ord)
+		              JOIN pg_attribute a ON a.attrelid = con.confrelid AND a.attnum = k.attnum
+		              ORDER BY k.ord)::text[]
+		 FROM pg_constraint con
+		 JOIN pg_class c ON c.oid = con.conrelid
+		 JOIN pg_namespace n ON n.oid = c.relnamespace
+		 JOIN pg_class rc ON rc.oid = con.confrelid
+		 JOIN pg_namespace rn ON rn.oid = rc.relnamespace
+		 WHERE con.contype = 'f' AND n.nspname = $1 AND c.relname = $2
+		 ORDER BY con.conname`,
+		schema, table)
+	if err != nil {
+		return nil, fmt.Errorf("introspect foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	var fks []ForeignKeyDef
+	for rows.Next() {
+		var fk ForeignKeyDef
+		if err := rows.Scan(&fk.Name, &fk.Columns, &fk.RefSchema, &fk.RefTable, &fk.RefColumns); err != nil {
+			return nil, fmt.Errorf("scan foreign key: %w", err)
+		}
+		fks = append(fks, fk)
+	}
+	return fks, rows.Err()
+}
+
+// Partition is a child partition of a partitioned table.
+type Partition struct {
+	Schema string
+	Name   string
+
+	// Bound is the partition bound clause, e.g. "FOR VALUES FROM ('2024-01-01') TO ('2025-01-01')".
+	Bound string
+
+	// Key is the partition key if the partition is itself partitioned, e.g. "LIST (region)".
+	Key string
+}
+
+// PartitionInfo describes how a partitioned table is split.
+type PartitionInfo struct {
+	// Key is the partition key definition, e.g. "RANGE (created_at)".
+	Key        string
+	Partitions []Partition
+}
+
+// IntrospectPartitions returns the partition key and direct child partitions of
+// a table, or nil if the table is not partitioned (pg_class.relkind <> 'p').
+func IntrospectPartitions(ctx context.Context, pool *pgxpool.Pool, schema, table string) (*PartitionInfo, error) {
+	var key *string
+	err := pool.QueryRow(ctx,
+		`SELECT CASE WHEN c.relkind = 'p' THEN pg_catalog.pg_get_partkeydef(c.oid) END
+		 FROM pg_catalog.pg_class c
+		 JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		 WHERE n.nspname = $1 AND c.relname = $2`,
+		schema, table).Scan(&key)
+	if err != nil {
+		return nil, fmt.Errorf("introspect partition key: %w", err)
+	}
+	if key == nil {
+		return nil, nil
+	}
+
+	rows, err := pool.Query(ctx,
+		`SELECT cn.nspname, child.relname,
+		        pg_catalog.pg_get_expr(child.relpartbound, child.oid),
+		        CASE WHEN child.relkind = 'p' THEN pg_catalog.pg_get_partkeydef(child.oid) ELSE '' END
+		 FROM pg_catalog.pg_inherits i
+		 JOIN pg_catalog.pg_class parent ON parent.oid = i.inhparent
+		 JOIN pg_catalog.pg_namespace pn ON pn.oid = parent.relnamespace
+		 JOIN pg_catalog.pg_class child ON child.oid = i.inhrelid
+		 JOIN pg_catalog.pg_namespace cn ON cn.oid = child.relnamespace
+		 WHERE pn.nspname = $1 AND parent.relname = $2 AND child.relispartition
+		 ORDER BY child.relname`,
+		schema, table)
+	if err != nil {
+		return nil, fmt.Errorf("introspect partitions: %w", err)
+	}
+	defer rows.Close()
+
+	info := &PartitionInfo{Key: *key}
+	for rows.Next() {
+		var p Partition
+		if err := rows.Scan(&p.Schema, &p.Name, &p.Bound, &p.Key); err != nil {
+			return nil, fmt.Errorf("scan partition: %w", err)
+		}
+		info.Partitions = append(info.Partitions, p)
+	}
+	return info, rows.Err()
+}
+
+// PartitionParent returns the schema and name of the table that a partition
+// belongs to, or ok=false if the table is not a partition.
+func PartitionParent(ctx context.Context, pool *pgxpool.Pool, schema, table string) (parentSchema, parentTable string, ok bool, err error) {
+	err = pool.QueryRow(ctx,
+		`SELECT pn.nspname, parent.relname
+		 FROM pg_catalog.pg_class c
+		 JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		 JOIN pg_catalog.pg_inherits i ON i.inhrelid = c.oid
+		 JOIN pg_catalog.pg_class parent ON parent.oid = i.inhparent
+		 JOIN pg_catalog.pg_namespace pn ON pn.oid = parent.relnamespace
+		 WHERE n.nspname = $1 AND c.relname = $2 AND c.relispartition`,
+		schema, table).Scan(&parentSchema, &parentTable)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, fmt.Errorf("introspect partition parent: %w", err)
+	}
+	return parentSchema, parentTable, true, nil
+}
+
+// GetTablePrimaryKeys returns the primary key column names for a table. If
+// the table has no primary key in schema, each of fallbackSchemas is tried in
+// turn; pass a branch schema to resolve tables created on the branch that
+// don't exist in the source.
+func GetTablePrimaryKeys(ctx context.Context, pool *pgxpool.Pool, schema, table string, fallbackSchemas ...string) ([]string, error) {
+	for _, sch := range append([]string{schema}, fallbackSchemas...) {
+		pkCols, err := queryTablePrimaryKeys(ctx, pool, sch, table)
+		if err != nil || len(pkCols) > 0 {
+			return pkCols, err
+		}
+	}
+	return nil, nil
+}
+
+func queryTablePrimaryKeys(ctx context.Context, pool *pgxpool.Pool, schema, table string) ([]string, error) {
 	rows, err := pool.Query(ctx,
 		`SELECT kcu.column_name
 		 FROM information_schema.table_constraints tc
@@ -102,3 +249,86 @@ func TableExists(ctx context.Context, pool *pgxpool.Pool, schema, table string)
 		)`, schema, table).Scan(&exists)
 	return exists, err
 }
+
+// IndexSchemas returns the schemas that hold an index with the given name,
+// in name order. Index names are unique per schema, not per table, so an
+// index is found through pg_class rather than by its table.
+func IndexSchemas(ctx context.Context, pool *pgxpool.Pool, index string) ([]string, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT n.nspname
+		 FROM pg_catalog.pg_class c
+		 JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		 WHERE c.relkind IN ('i', 'I') AND c.relname = $1
+		 ORDER BY n.nspname`,
+		index)
+	if err != nil {
+		return nil, fmt.Errorf("look up index %s: %w", index, err)
+	}
+	defer rows.Close()
+
+	var schemas []string
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			return nil, fmt.Errorf("scan index schema: %w", err)
+		}
+		schemas = append(schemas, schema)
+	}
+	return schemas, rows.Err()
+}
+
+// QualifiedTable is a schema-qualified table name.
+type QualifiedTable struct {
+	Schema string
+	Name   string
+}
+
+// ListSearchPathTables returns the base tables in the schemas on the
+// connection's search_path, ordered by schema and name.
+func ListSearchPathTables(ctx context.Context, pool *pgxpool.Pool) ([]QualifiedTable, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT table_schema, table_name
+		 FROM information_schema.tables
+		 WHERE table_schema = ANY(current_schemas(false))
+		   AND table_type = 'BASE TABLE'
+		 ORDER BY table_schema, table_name`)
+	if err != nil {
+		return nil, fmt.Errorf("list tables: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []QualifiedTable
+	for rows.Next() {
+		var t QualifiedTable
+		if err := rows.Scan(&t.Schema, &t.Name); err != nil {
+			return nil, fmt.Errorf("scan table: %w", err)
+		}
+		tables = append(tables, t)
+	}
+	return tables, rows.Err()
+}
+
+// ListSchemaTables returns the names of the base tables in schema, ordered
+// by name.
+func ListSchemaTables(ctx context.Context, pool *pgxpool.Pool, schema string) ([]string, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT table_name
+		 FROM information_schema.tables
+		 WHERE table_schema = $1
+		   AND table_type = 'BASE TABLE'
+		 ORDER BY table_name`, schema)
+	if err != nil {
+		return nil, fmt.Errorf("list tables: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scan table: %w", err)
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
@@ -2,24 +2,88 @@ package cow
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/riftdata/rift/internal/storage"
 )
 
 // MergeSQL holds the generated SQL statements to merge a branch into its parent.
 type MergeSQL struct {
 	Statements []string
 	TableName  string
+
+	// SourceSchema is the schema of the table in the parent.
+	SourceSchema string
+
+	// Individual steps, for callers that order statements across tables
+	// (see MergePlanStatements).
+	DeleteSQL string
+	UpdateSQL string
+	InsertSQL string
+
+	// EditSQL writes hand-edited conflict rows over the parent's, after
+	// UpdateSQL (see ResolveConflicts).
+	EditSQL []string
+}
+
+// MergeOptions selects which kinds of change a merge applies, for merging
+// part of a branch: new rows only, say, leaving its updates and deletions on
+// the branch.
+type MergeOptions struct {
+	Inserts bool // rows added on the branch
+	Updates bool // rows changed on the branch
+	Deletes bool // rows deleted on the branch
+}
+
+// MergeAll applies every change on the branch.
+var MergeAll = MergeOptions{Inserts: true, Updates: true, Deletes: true}
+
+// kinds names the kinds of change o selects, for the audit log.
+func (o MergeOptions) kinds() []string {
+	var kinds []string
+	if o.Inserts {
+		kinds = append(kinds, "inserts")
+	}
+	if o.Updates {
+		kinds = append(kinds, "updates")
+	}
+	if o.Deletes {
+		kinds = append(kinds, "deletes")
+	}
+	return kinds
 }
 
 // GenerateMergeSQL produces SQL to apply a branch's changes to the parent.
 // The generated SQL handles inserts, updates, and deletes in the correct order.
-func GenerateMergeSQL(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string, pkCols []string) (*MergeSQL, error) {
+// Steps opts leaves out are empty and missing from Statements.
+func GenerateMergeSQL(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string, pkCols []string, opts MergeOptions) (*MergeSQL, error) {
+	return generateMergeSQL(ctx, pool, branchSchema, sourceSchema, tableName, pkCols, opts, false)
+}
+
+// GenerateBatchedMergeSQL is like GenerateMergeSQL, but each step only
+// applies one page of the overlay's rows, in primary key order: the steps
+// take the page size as $1 (LIMIT) and the rows to skip as $2 (OFFSET).
+// DeleteSQL pages through the overlay's tombstones, and UpdateSQL and
+// InsertSQL through its live rows. Statements is left empty, since the pages
+// are meant to run in separate transactions.
+func GenerateBatchedMergeSQL(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string, pkCols []string) (*MergeSQL, error) {
+	return generateMergeSQL(ctx, pool, branchSchema, sourceSchema, tableName, pkCols, MergeAll, true)
+}
+
+func generateMergeSQL(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string, pkCols []string, opts MergeOptions, paged bool) (*MergeSQL, error) {
 	if len(pkCols) == 0 {
 		return nil, fmt.Errorf("merge table %q: empty primary key columns", tableName)
 	}
+	if opts == (MergeOptions{}) {
+		return nil, fmt.Errorf("merge table %q: no kind of change selected", tableName)
+	}
 
 	ovrTable := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(tableName)
 	srcTable := pgQuoteIdent(sourceSchema) + "." + pgQuoteIdent(tableName)
@@ -39,48 +103,118 @@ func GenerateMergeSQL(ctx context.Context, pool *pgxpool.Pool, branchSchema, sou
 	quotedPKs := quoteIdents(pkCols)
 	quotedCols := quoteIdents(colNames)
 
+	// ovrRows is what each step reads overlay rows from: the whole overlay,
+	// or one page of the rows matching filter.
+	ovrRows := func(filter string) string {
+		if !paged {
+			return ovrTable
+		}
+		return fmt.Sprintf("(SELECT * FROM %s WHERE %s ORDER BY %s LIMIT $1 OFFSET $2)",
+			ovrTable, filter, strings.Join(quotedPKs, ", "))
+	}
+
 	var stmts []string
+	var deleteSQL, updateSQL, insertSQL string
 
 	// Step 1: Delete rows marked as tombstones from source
-	deleteSQL := fmt.Sprintf(
-		"DELETE FROM %s src WHERE EXISTS (SELECT 1 FROM %s ovr WHERE %s AND ovr._rift_tombstone)",
-		srcTable, ovrTable, pkJoin)
-	stmts = append(stmts, deleteSQL)
+	if opts.Deletes {
+		deleteSQL = fmt.Sprintf(
+			"DELETE FROM %s src WHERE EXISTS (SELECT 1 FROM %s ovr WHERE %s AND ovr._rift_tombstone)",
+			srcTable, ovrRows("_rift_tombstone"), pkJoin)
+		stmts = append(stmts, deleteSQL)
+	}
 
 	// Step 2: Update existing rows (non-tombstone overlay rows that exist in source)
-	var setClauses []string
-	for _, col := range quotedCols {
-		setClauses = append(setClauses, fmt.Sprintf("%s = ovr.%s", col, col))
+	if opts.Updates {
+		var setClauses []string
+		for _, col := range quotedCols {
+			setClauses = append(setClauses, fmt.Sprintf("%s = ovr.%s", col, col))
+		}
+		updateSQL = fmt.Sprintf(
+			"UPDATE %s src SET %s FROM %s ovr WHERE %s AND NOT ovr._rift_tombstone",
+			srcTable, strings.Join(setClauses, ", "), ovrRows("NOT _rift_tombstone"), pkJoin)
+		stmts = append(stmts, updateSQL)
 	}
-	updateSQL := fmt.Sprintf(
-		"UPDATE %s src SET %s FROM %s ovr WHERE %s AND NOT ovr._rift_tombstone",
-		srcTable, strings.Join(setClauses, ", "), ovrTable, pkJoin)
-	stmts = append(stmts, updateSQL)
 
 	// Step 3: Insert new rows (non-tombstone overlay rows that don't exist in source)
-	colList := strings.Join(quotedCols, ", ")
-	ovrColList := make([]string, len(quotedCols))
-	for i, col := range quotedCols {
-		ovrColList[i] = "ovr." + col
+	if opts.Inserts {
+		colList := strings.Join(quotedCols, ", ")
+		ovrColList := make([]string, len(quotedCols))
+		for i, col := range quotedCols {
+			ovrColList[i] = "ovr." + col
+		}
+
+		pkJoinForInsert := buildPKJoin("src", "ovr", pkCols)
+		insertSQL = fmt.Sprintf(
+			"INSERT INTO %s (%s) SELECT %s FROM %s ovr WHERE NOT ovr._rift_tombstone AND NOT EXISTS (SELECT 1 FROM %s src WHERE %s)",
+			srcTable, colList, strings.Join(ovrColList, ", "),
+			ovrRows("NOT _rift_tombstone"), srcTable, pkJoinForInsert)
+		stmts = append(stmts, insertSQL)
+	}
+
+	m := &MergeSQL{
+		TableName:    tableName,
+		SourceSchema: sourceSchema,
+		DeleteSQL:    deleteSQL,
+		UpdateSQL:    updateSQL,
+		InsertSQL:    insertSQL,
+	}
+	if !paged {
+		// Wrap in a transaction
+		m.Statements = append([]string{"BEGIN"}, stmts...)
+		m.Statements = append(m.Statements, "COMMIT")
+	}
+	return m, nil
+}
+
+// GenerateOverlayMergeSQL produces SQL to apply one branch's changes to another
+// branch's overlay table (toSchema) instead of the source table. Live rows are
+// upserted into the target overlay and tombstones are copied as tombstones,
+// so the target branch sees the same changes the source branch does.
+func GenerateOverlayMergeSQL(ctx context.Context, pool *pgxpool.Pool, fromSchema, toSchema, sourceSchema, tableName string, pkCols []string) (*MergeSQL, error) {
+	if len(pkCols) == 0 {
+		return nil, fmt.Errorf("merge table %q: empty primary key columns", tableName)
+	}
+
+	ovrTable := pgQuoteIdent(fromSchema) + "." + pgQuoteIdent(tableName)
+	tgtTable := pgQuoteIdent(toSchema) + "." + pgQuoteIdent(tableName)
+
+	cols, err := IntrospectTable(ctx, pool, sourceSchema, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("introspect table for merge: %w", err)
+	}
+
+	quotedCols := make([]string, len(cols))
+	ovrCols := make([]string, len(cols))
+	var setClauses []string
+	for i, c := range cols {
+		quotedCols[i] = pgQuoteIdent(c.Name)
+		ovrCols[i] = "ovr." + quotedCols[i]
+		if !c.IsPK {
+			setClauses = append(setClauses, fmt.Sprintf("%s = EXCLUDED.%s", quotedCols[i], quotedCols[i]))
+		}
 	}
+	setClauses = append(setClauses, "_rift_tombstone = false")
 
-	pkJoinForInsert := buildPKJoin("src", "ovr", pkCols)
-	insertSQL := fmt.Sprintf(
-		"INSERT INTO %s (%s) SELECT %s FROM %s ovr WHERE NOT ovr._rift_tombstone AND NOT EXISTS (SELECT 1 FROM %s src WHERE %s)",
-		srcTable, colList, strings.Join(ovrColList, ", "),
-		ovrTable, srcTable, pkJoinForInsert)
-	stmts = append(stmts, insertSQL)
+	colList := strings.Join(quotedCols, ", ")
+	pkList := strings.Join(quoteIdents(pkCols), ", ")
 
-	// Wrap in a transaction
-	txStmts := []string{"BEGIN"}
-	txStmts = append(txStmts, stmts...)
-	txStmts = append(txStmts, "COMMIT")
+	// Tombstones: mark the rows deleted in the target branch too
+	deleteSQL := fmt.Sprintf(
+		"INSERT INTO %s (%s, _rift_tombstone) SELECT %s, true FROM %s ovr WHERE ovr._rift_tombstone ON CONFLICT (%s) DO UPDATE SET _rift_tombstone = true",
+		tgtTable, colList, strings.Join(ovrCols, ", "), ovrTable, pkList)
 
-	_ = quotedPKs // used in pkJoin via buildPKJoin
+	// Live rows: insert or overwrite the target's version
+	upsertSQL := fmt.Sprintf(
+		"INSERT INTO %s (%s, _rift_tombstone) SELECT %s, false FROM %s ovr WHERE NOT ovr._rift_tombstone ON CONFLICT (%s) DO UPDATE SET %s",
+		tgtTable, colList, strings.Join(ovrCols, ", "), ovrTable, pkList, strings.Join(setClauses, ", "))
 
 	return &MergeSQL{
-		Statements: txStmts,
-		TableName:  tableName,
+		Statements:   []string{"BEGIN", deleteSQL, upsertSQL, "COMMIT"},
+		TableName:    tableName,
+		SourceSchema: sourceSchema,
+		DeleteSQL:    deleteSQL,
+		UpdateSQL:    upsertSQL,
 	}, nil
 }
 
@@ -88,3 +222,162 @@ func GenerateMergeSQL(ctx context.Context, pool *pgxpool.Pool, branchSchema, sou
 func FormatMergeSQL(m *MergeSQL) string {
 	return strings.Join(m.Statements, ";\n") + ";"
 }
+
+// MergePlanStatements combines per-table merges into a single transaction.
+// merges must be in foreign key dependency order (referenced tables first), as
+// returned by Engine.GenerateMerge. Updates and inserts run in that order so
+// parent rows exist before children reference them; deletes run in reverse so
+// children are removed before their parents. Migrations, if any, run first so
+// the data statements see the migrated schema.
+func MergePlanStatements(merges []MergeSQL, migrations ...string) []string {
+	stmts := []string{"BEGIN"}
+	for _, m := range migrations {
+		stmts = append(stmts, strings.TrimRight(strings.TrimSpace(m), ";"))
+	}
+	for _, st := range mergeSteps(merges) {
+		stmts = append(stmts, st.sql)
+	}
+	return append(stmts, "COMMIT")
+}
+
+// FormatMergePlan returns MergePlanStatements as a single string.
+func FormatMergePlan(merges []MergeSQL, migrations ...string) string {
+	return strings.Join(MergePlanStatements(merges, migrations...), ";\n") + ";"
+}
+
+// MigrationStatements returns the SQL of migrations, for MergePlanStatements.
+func MigrationStatements(migrations []*storage.AppliedMigration) []string {
+	stmts := make([]string, len(migrations))
+	for i, m := range migrations {
+		stmts[i] = m.SQL
+	}
+	return stmts
+}
+
+// sortByDependencies orders tables so that every table comes after the tables
+// it references (Kahn's algorithm). deps maps a table to the tables it
+// references; references to tables outside the list and self-references are
+// ignored. Tables caught in a reference cycle, or depending on one, can't be
+// ordered: they are also returned as cyclic, and go at the end in
+// alphabetical order.
+func sortByDependencies(tables []string, deps map[string][]string) (sorted, cyclic []string) {
+	index := make(map[string]int, len(tables))
+	for i, t := range tables {
+		index[t] = i
+	}
+
+	inDegree := make(map[string]int, len(tables))
+	dependents := make(map[string][]string, len(tables))
+	for _, t := range tables {
+		seen := make(map[string]bool)
+		for _, ref := range deps[t] {
+			if _, ok := index[ref]; !ok || ref == t || seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			inDegree[t]++
+			dependents[ref] = append(dependents[ref], t)
+		}
+	}
+
+	var queue []string
+	for _, t := range tables {
+		if inDegree[t] == 0 {
+			queue = append(queue, t)
+		}
+	}
+
+	sorted = make([]string, 0, len(tables))
+	done := make(map[string]bool, len(tables))
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		sorted = append(sorted, t)
+		done[t] = true
+		for _, d := range dependents[t] {
+			inDegree[d]--
+			if inDegree[d] == 0 {
+				queue = append(queue, d)
+			}
+		}
+	}
+
+	for _, t := range tables {
+		if !done[t] {
+			cyclic = append(cyclic, t)
+		}
+	}
+	slices.Sort(cyclic)
+	return append(sorted, cyclic...), cyclic
+}
+
+// MergeResult summarizes an executed merge.
+type MergeResult struct {
+	Tables       int
+	Statements   int
+	RowsAffected int64
+
+	// Migrations is the number of branch migrations applied before the data.
+	Migrations int
+}
+
+// mergeStep is a single merge statement and the index of the table it belongs to.
+type mergeStep struct {
+	table int
+	sql   string
+}
+
+// mergeSteps returns the statements of MergePlanStatements (without BEGIN/COMMIT),
+// tagged with the table each one belongs to. Empty steps are skipped.
+func mergeSteps(merges []MergeSQL) []mergeStep {
+	steps := make([]mergeStep, 0, 3*len(merges))
+	add := func(i int, sql string) {
+		if sql != "" {
+			steps = append(steps, mergeStep{i, sql})
+		}
+	}
+	for i := range merges {
+		add(i, merges[i].UpdateSQL)
+		for _, sql := range merges[i].EditSQL {
+			add(i, sql)
+		}
+		add(i, merges[i].InsertSQL)
+	}
+	for i := len(merges) - 1; i >= 0; i-- {
+		add(i, merges[i].DeleteSQL)
+	}
+	return steps
+}
+
+// timeoutSettings returns the SET LOCAL statements that bound a merge
+// transaction. Locks get half the budget so a blocked merge fails fast.
+func timeoutSettings(timeout time.Duration) []string {
+	if timeout <= 0 {
+		return nil
+	}
+	stmtMs := timeout.Milliseconds()
+	if stmtMs < 1 {
+		stmtMs = 1
+	}
+	lockMs := stmtMs / 2
+	if lockMs < 1 {
+		lockMs = 1
+	}
+	return []string{
+		fmt.Sprintf("SET LOCAL statement_timeout = '%dms'", stmtMs),
+		fmt.Sprintf("SET LOCAL lock_timeout = '%dms'", lockMs),
+	}
+}
+
+// isTimeout reports whether err was caused by statement_timeout, lock_timeout,
+// or the context deadline.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "57014" || pgErr.Code == "55P03" // query_canceled, lock_not_available
+	}
+	return false
+}
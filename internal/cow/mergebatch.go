@@ -0,0 +1,119 @@
+package cow
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// batchedTable is one table of a batched merge and how many overlay rows
+// each of its steps pages through.
+type batchedTable struct {
+	merge      MergeSQL
+	live       int64
+	tombstones int64
+}
+
+// ExecuteMergeUnbatched applies a branch's changes to the parent like
+// ExecuteMerge, but without a single enclosing transaction, for merges too
+// large to hold their locks for the whole run. Each table's overlay rows are
+// applied batchSize at a time, each batch in its own transaction: live rows
+// table by table in foreign key order, then deletions in the reverse order.
+// Pending migrations are applied first, in one transaction.
+//
+// If a batch fails, the batches before it stay committed and the parent is
+// left partly merged; running the merge again applies the rest, since every
+// step is idempotent. progress, if not nil, is called after each batch with
+// the overlay rows merged so far and in total.
+func (e *Engine) ExecuteMergeUnbatched(ctx context.Context, branchName string, batchSize int, progress func(merged, total int64)) error {
+	if batchSize <= 0 {
+		return fmt.Errorf("batch size must be positive, got %d", batchSize)
+	}
+
+	merges, err := e.generateMerge(ctx, branchName, nil, GenerateBatchedMergeSQL)
+	if err != nil {
+		return err
+	}
+	migrations, err := e.PendingMigrations(ctx, branchName)
+	if err != nil {
+		return err
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+	tables := make([]batchedTable, len(merges))
+	var total int64
+	for i, m := range merges {
+		tables[i].merge = m
+		if err := pool.QueryRow(ctx, fmt.Sprintf(
+			`SELECT count(*) FILTER (WHERE NOT _rift_tombstone), count(*) FILTER (WHERE _rift_tombstone) FROM %s.%s`,
+			pgQuoteIdent(branchSchema), pgQuoteIdent(m.TableName))).Scan(&tables[i].live, &tables[i].tombstones); err != nil {
+			return fmt.Errorf("count overlay rows of %s: %w", m.TableName, err)
+		}
+		total += tables[i].live + tables[i].tombstones
+	}
+
+	result, err := e.executeMerges(ctx, nil, migrations, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	var merged int64
+	report := func(n int64) {
+		merged += n
+		if progress != nil {
+			progress(merged, total)
+		}
+	}
+	report(0)
+
+	for _, t := range tables {
+		n, err := e.mergeBatches(ctx, t.merge.TableName, t.live, batchSize, report, t.merge.UpdateSQL, t.merge.InsertSQL)
+		result.Statements += n.Statements
+		result.RowsAffected += n.RowsAffected
+		if err != nil {
+			return err
+		}
+	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		t := tables[i]
+		n, err := e.mergeBatches(ctx, t.merge.TableName, t.tombstones, batchSize, report, t.merge.DeleteSQL)
+		result.Statements += n.Statements
+		result.RowsAffected += n.RowsAffected
+		if err != nil {
+			return err
+		}
+		result.Tables++
+	}
+
+	e.auditMerge(ctx, branchName, "parent", nil, MergeAll, result, 0)
+	return nil
+}
+
+// mergeBatches runs the paged statements stmts over rows overlay rows,
+// batchSize rows per transaction, and reports each committed batch's size.
+func (e *Engine) mergeBatches(ctx context.Context, tableName string, rows int64, batchSize int, report func(int64), stmts ...string) (MergeResult, error) {
+	var result MergeResult
+	for offset := int64(0); offset < rows; offset += int64(batchSize) {
+		var affected int64
+		err := pgx.BeginFunc(ctx, e.store.Pool(), func(tx pgx.Tx) error {
+			for _, stmt := range stmts {
+				tag, err := tx.Exec(ctx, stmt, batchSize, offset)
+				if err != nil {
+					return err
+				}
+				affected += tag.RowsAffected()
+			}
+			return nil
+		})
+		if err != nil {
+			return result, fmt.Errorf("merge %s: batch at row %d of %d failed; earlier batches are committed: %w",
+				tableName, offset, rows, err)
+		}
+		result.Statements += len(stmts)
+		result.RowsAffected += affected
+		report(min(int64(batchSize), rows-offset))
+	}
+	return result, nil
+}
@@ -0,0 +1,99 @@
+package cow
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MergeSizeEstimate is a rough guess at how much merging a branch grows or
+// shrinks its parent's tables on disk. Updated rows are left out: they
+// replace rows of about the same size.
+type MergeSizeEstimate struct {
+	// InsertBytes is what the rows the branch added take up.
+	InsertBytes int64
+
+	// DeletedBytes is what the rows the branch deleted take up. PostgreSQL
+	// reuses that space for new rows after VACUUM rather than returning it
+	// to the filesystem.
+	DeletedBytes int64
+
+	// NetChange is InsertBytes minus DeletedBytes.
+	NetChange int64
+}
+
+// Tablespace is where a database's tables are stored.
+type Tablespace struct {
+	Name string
+
+	// Location is the tablespace's directory on the database server, or ""
+	// if the database user isn't allowed to see it.
+	Location string
+}
+
+// EstimateMergeSize estimates the disk space merging a branch into its
+// parent takes. Each table's rows are assumed to be its average row size,
+// its total size (indexes and TOAST included) over its live row count.
+func (e *Engine) EstimateMergeSize(ctx context.Context, branchName string) (MergeSizeEstimate, error) {
+	var estimate MergeSizeEstimate
+	diff, err := e.Diff(ctx, branchName, "")
+	if err != nil {
+		return estimate, err
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+	for _, td := range diff.Tables {
+		if td.Inserts == 0 && td.Deletes == 0 {
+			continue
+		}
+		rowSize, err := averageRowSize(ctx, pool, td.SourceSchema, td.TableName)
+		if err != nil {
+			return estimate, fmt.Errorf("row size of %s.%s: %w", td.SourceSchema, td.TableName, err)
+		}
+		// An empty or never analyzed source table says nothing about its
+		// rows, but the overlay holds rows of the same table
+		if rowSize == 0 {
+			if rowSize, err = averageRowSize(ctx, pool, branchSchema, td.TableName); err != nil {
+				return estimate, fmt.Errorf("row size of %s overlay: %w", td.TableName, err)
+			}
+		}
+		estimate.InsertBytes += td.Inserts * rowSize
+		estimate.DeletedBytes += td.Deletes * rowSize
+	}
+	estimate.NetChange = estimate.InsertBytes - estimate.DeletedBytes
+	return estimate, nil
+}
+
+// averageRowSize returns a table's total size over its live row count from
+// pg_stat_user_tables, or 0 if it has no live rows.
+func averageRowSize(ctx context.Context, pool *pgxpool.Pool, schema, table string) (int64, error) {
+	var size int64
+	err := pool.QueryRow(ctx,
+		`SELECT COALESCE(pg_catalog.pg_total_relation_size(relid) / NULLIF(n_live_tup, 0), 0)
+		 FROM pg_catalog.pg_stat_user_tables
+		 WHERE schemaname = $1 AND relname = $2`, schema, table).Scan(&size)
+	return size, err
+}
+
+// UpstreamTablespace returns the default tablespace of the upstream
+// database, which merged rows are written to.
+func (e *Engine) UpstreamTablespace(ctx context.Context) (Tablespace, error) {
+	pool := e.store.Pool()
+	var ts Tablespace
+	err := pool.QueryRow(ctx,
+		`SELECT t.spcname, pg_catalog.pg_tablespace_location(t.oid)
+		 FROM pg_catalog.pg_database d
+		 JOIN pg_catalog.pg_tablespace t ON t.oid = d.dattablespace
+		 WHERE d.datname = current_database()`).Scan(&ts.Name, &ts.Location)
+	if err != nil {
+		return ts, fmt.Errorf("look up tablespace: %w", err)
+	}
+	// pg_default and pg_global live in the data directory, which only
+	// privileged users can see
+	if ts.Location == "" {
+		_ = pool.QueryRow(ctx, `SELECT current_setting('data_directory')`).Scan(&ts.Location)
+	}
+	return ts, nil
+}
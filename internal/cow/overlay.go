@@ -9,11 +9,14 @@ import (
 )
 
 // EnsureOverlayTable creates an overlay table in the branch schema that mirrors the source table,
-// with an additional _rift_tombstone column.
-func EnsureOverlayTable(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string) error {
-	overlayTable := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(tableName)
-	sourceTable := pgQuoteIdent(sourceSchema) + "." + pgQuoteIdent(tableName)
-
+// with additional _rift_tombstone and _rift_updated_at columns.
+//
+// Partitioned source tables get a partitioned overlay with the same key and one
+// overlay partition per source partition, so rows written through the parent
+// land in the overlay of the partition they belong to and reads through either
+// the parent or a partition see the same rows. Asking for the overlay of a
+// partition creates the overlay of its whole partitioned table.
+func EnsureOverlayTable(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string, opts OverlayOptions) error {
 	// Check if overlay already exists
 	exists, err := TableExists(ctx, pool, branchSchema, tableName)
 	if err != nil {
@@ -23,6 +26,113 @@ func EnsureOverlayTable(ctx context.Context, pool *pgxpool.Pool, branchSchema, s
 		return nil
 	}
 
+	parentSchema, parentTable, isPartition, err := PartitionParent(ctx, pool, sourceSchema, tableName)
+	if err != nil {
+		return err
+	}
+	if isPartition {
+		if err := EnsureOverlayTable(ctx, pool, branchSchema, parentSchema, parentTable, opts); err != nil {
+			return fmt.Errorf("ensure overlay for partitioned table %s: %w", parentTable, err)
+		}
+		// The parent's overlay normally creates this partition's overlay. It
+		// won't if the parent overlay predates partition support.
+		if exists, err = TableExists(ctx, pool, branchSchema, tableName); err != nil || exists {
+			return err
+		}
+	}
+
+	partitions, err := IntrospectPartitions(ctx, pool, sourceSchema, tableName)
+	if err != nil {
+		return err
+	}
+
+	partitionBy := ""
+	if partitions != nil {
+		partitionBy = partitions.Key
+	}
+	if err := createOverlayTable(ctx, pool, branchSchema, sourceSchema, tableName, partitionBy); err != nil {
+		return err
+	}
+
+	if partitions != nil {
+		if err := createPartitionOverlays(ctx, pool, branchSchema, tableName, partitions.Partitions); err != nil {
+			return err
+		}
+	}
+
+	// Row triggers on a partitioned overlay are cloned to its partitions
+	if opts.TrackDeltaSize {
+		return AddDeltaSizeTrigger(ctx, pool, branchSchema, tableName, opts.BranchName)
+	}
+	return nil
+}
+
+// OverlayOptions controls optional behavior of EnsureOverlayTable.
+type OverlayOptions struct {
+	// TrackDeltaSize attaches a trigger that keeps the delta_size of
+	// BranchName up to date as overlay rows change (see AddDeltaSizeTrigger).
+	TrackDeltaSize bool
+	BranchName     string
+}
+
+// AddDeltaSizeTrigger attaches the _rift._rift_track_delta_size() trigger to an
+// overlay table. The trigger adjusts _rift.branches.delta_size for branchName
+// by pg_column_size of each inserted, updated, or deleted row.
+func AddDeltaSizeTrigger(ctx context.Context, pool *pgxpool.Pool, branchSchema, tableName, branchName string) error {
+	createSQL := fmt.Sprintf(
+		`CREATE TRIGGER _rift_delta_size AFTER INSERT OR UPDATE OR DELETE ON %s.%s
+		 FOR EACH ROW EXECUTE FUNCTION _rift._rift_track_delta_size(%s)`,
+		pgQuoteIdent(branchSchema), pgQuoteIdent(tableName), pgQuoteLiteral(branchName))
+	if _, err := pool.Exec(ctx, createSQL); err != nil {
+		return fmt.Errorf("add delta size trigger: %w", err)
+	}
+	return nil
+}
+
+// AddUpdatedAtTracking adds the _rift_updated_at column to an overlay table,
+// kept current by the _rift._rift_touch_updated_at() trigger, if the table
+// doesn't have it yet. Overlays created before 'rift watch' lack it.
+func AddUpdatedAtTracking(ctx context.Context, pool *pgxpool.Pool, branchSchema, tableName string) error {
+	overlayTable := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(tableName)
+
+	addColumn := fmt.Sprintf(
+		`ALTER TABLE %s ADD COLUMN IF NOT EXISTS _rift_updated_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+		overlayTable)
+	if _, err := pool.Exec(ctx, addColumn); err != nil {
+		return fmt.Errorf("add updated_at column: %w", err)
+	}
+
+	var hasTrigger bool
+	err := pool.QueryRow(ctx,
+		`SELECT EXISTS (
+			SELECT 1 FROM pg_catalog.pg_trigger
+			WHERE tgrelid = format('%I.%I', $1::text, $2::text)::regclass
+			  AND tgname = '_rift_updated_at'
+		)`, branchSchema, tableName).Scan(&hasTrigger)
+	if err != nil {
+		return fmt.Errorf("check updated_at trigger: %w", err)
+	}
+	if hasTrigger {
+		return nil
+	}
+
+	createSQL := fmt.Sprintf(
+		`CREATE TRIGGER _rift_updated_at BEFORE INSERT OR UPDATE ON %s
+		 FOR EACH ROW EXECUTE FUNCTION _rift._rift_touch_updated_at()`,
+		overlayTable)
+	if _, err := pool.Exec(ctx, createSQL); err != nil {
+		return fmt.Errorf("add updated_at trigger: %w", err)
+	}
+	return nil
+}
+
+// createOverlayTable creates a single overlay table with a tombstone column
+// and the source's primary key. A non-empty partitionBy creates a partitioned
+// overlay with that key.
+func createOverlayTable(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName, partitionBy string) error {
+	overlayTable := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(tableName)
+	sourceTable := pgQuoteIdent(sourceSchema) + "." + pgQuoteIdent(tableName)
+
 	// Get PK columns for the source table
 	pkCols, err := GetTablePrimaryKeys(ctx, pool, sourceSchema, tableName)
 	if err != nil {
@@ -36,6 +146,9 @@ func EnsureOverlayTable(ctx context.Context, pool *pgxpool.Pool, branchSchema, s
 	createSQL := fmt.Sprintf(
 		`CREATE TABLE IF NOT EXISTS %s (LIKE %s INCLUDING DEFAULTS INCLUDING CONSTRAINTS)`,
 		overlayTable, sourceTable)
+	if partitionBy != "" {
+		createSQL += " PARTITION BY " + partitionBy
+	}
 
 	if _, err := pool.Exec(ctx, createSQL); err != nil {
 		return fmt.Errorf("create overlay table: %w", err)
@@ -50,10 +163,20 @@ func EnsureOverlayTable(ctx context.Context, pool *pgxpool.Pool, branchSchema, s
 		return fmt.Errorf("add tombstone column: %w", err)
 	}
 
-	// Add a primary key only if one doesn't already exist.
+	if err := AddUpdatedAtTracking(ctx, pool, branchSchema, tableName); err != nil {
+		return err
+	}
+
 	// LIKE - may or may not copy PK constraints depending on a PG version.
+	_, err = addOverlayPK(ctx, pool, branchSchema, tableName, pkCols)
+	return err
+}
+
+// addOverlayPK gives an overlay table a primary key on pkCols if it doesn't
+// have one, and reports whether it added it.
+func addOverlayPK(ctx context.Context, pool *pgxpool.Pool, branchSchema, tableName string, pkCols []string) (bool, error) {
 	var hasPK bool
-	err = pool.QueryRow(ctx,
+	err := pool.QueryRow(ctx,
 		`SELECT EXISTS (
 			SELECT 1 FROM pg_catalog.pg_constraint c
 			JOIN pg_catalog.pg_class r ON r.oid = c.conrelid
@@ -61,20 +184,104 @@ func EnsureOverlayTable(ctx context.Context, pool *pgxpool.Pool, branchSchema, s
 			WHERE n.nspname = $1 AND r.relname = $2 AND c.contype = 'p'
 		)`, branchSchema, tableName).Scan(&hasPK)
 	if err != nil {
-		return fmt.Errorf("check overlay PK: %w", err)
+		return false, fmt.Errorf("check overlay PK: %w", err)
+	}
+	if hasPK {
+		return false, nil
 	}
 
-	if !hasPK {
-		pkList := strings.Join(quoteIdents(pkCols), ", ")
-		addPK := fmt.Sprintf(`ALTER TABLE %s ADD PRIMARY KEY (%s)`, overlayTable, pkList)
-		if _, err := pool.Exec(ctx, addPK); err != nil {
-			return fmt.Errorf("add overlay PK: %w", err)
-		}
+	overlayTable := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(tableName)
+	pkList := strings.Join(quoteIdents(pkCols), ", ")
+	addPK := fmt.Sprintf(`ALTER TABLE %s ADD PRIMARY KEY (%s)`, overlayTable, pkList)
+	if _, err := pool.Exec(ctx, addPK); err != nil {
+		return false, fmt.Errorf("add overlay PK: %w", err)
 	}
+	return true, nil
+}
 
+// createPartitionOverlays creates an overlay partition of a partitioned overlay
+// table for each source partition, recursing into sub-partitioned tables.
+// Overlays created for a partition before its parent are attached instead.
+func createPartitionOverlays(ctx context.Context, pool *pgxpool.Pool, branchSchema, parentTable string, partitions []Partition) error {
+	for _, p := range partitions {
+		exists, err := TableExists(ctx, pool, branchSchema, p.Name)
+		if err != nil {
+			return fmt.Errorf("check overlay partition exists: %w", err)
+		}
+
+		if _, err := pool.Exec(ctx, partitionOverlaySQL(branchSchema, parentTable, p, exists)); err != nil {
+			return fmt.Errorf("create overlay partition %s: %w", p.Name, err)
+		}
+
+		if p.Key == "" || exists {
+			continue
+		}
+		sub, err := IntrospectPartitions(ctx, pool, p.Schema, p.Name)
+		if err != nil {
+			return err
+		}
+		if sub != nil {
+			if err := createPartitionOverlays(ctx, pool, branchSchema, p.Name, sub.Partitions); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
+// partitionOverlaySQL returns the statement that makes the overlay of p a
+// partition of the parent overlay: CREATE TABLE ... PARTITION OF for a new
+// overlay, or ALTER TABLE ... ATTACH PARTITION for an existing one.
+func partitionOverlaySQL(branchSchema, parentTable string, p Partition, exists bool) string {
+	parent := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(parentTable)
+	overlay := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(p.Name)
+
+	if exists {
+		return fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s %s", parent, overlay, p.Bound)
+	}
+
+	sql := fmt.Sprintf("CREATE TABLE %s PARTITION OF %s %s", overlay, parent, p.Bound)
+	if p.Key != "" {
+		sql += " PARTITION BY " + p.Key
+	}
+	return sql
+}
+
+// CloneOverlayTable creates an overlay table in toSchema with the same structure
+// as the one in fromSchema (including any columns added by branch DDL) and copies
+// its rows, tombstones included. It returns the number of rows copied.
+func CloneOverlayTable(ctx context.Context, pool *pgxpool.Pool, fromSchema, toSchema, tableName string) (int64, error) {
+	fromTable := pgQuoteIdent(fromSchema) + "." + pgQuoteIdent(tableName)
+	toTable := pgQuoteIdent(toSchema) + "." + pgQuoteIdent(tableName)
+
+	pkCols, err := GetTablePrimaryKeys(ctx, pool, fromSchema, tableName)
+	if err != nil {
+		return 0, fmt.Errorf("get overlay PKs: %w", err)
+	}
+	if len(pkCols) == 0 {
+		return 0, fmt.Errorf("overlay table %s.%s has no primary key", fromSchema, tableName)
+	}
+
+	createSQL := fmt.Sprintf(
+		`CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS INCLUDING CONSTRAINTS)`,
+		toTable, fromTable)
+	if _, err := pool.Exec(ctx, createSQL); err != nil {
+		return 0, fmt.Errorf("create overlay table: %w", err)
+	}
+
+	addPK := fmt.Sprintf(`ALTER TABLE %s ADD PRIMARY KEY (%s)`,
+		toTable, strings.Join(quoteIdents(pkCols), ", "))
+	if _, err := pool.Exec(ctx, addPK); err != nil {
+		return 0, fmt.Errorf("add overlay PK: %w", err)
+	}
+
+	tag, err := pool.Exec(ctx, fmt.Sprintf(`INSERT INTO %s SELECT * FROM %s`, toTable, fromTable))
+	if err != nil {
+		return 0, fmt.Errorf("copy overlay rows: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
 // DropOverlayTable drops an overlay table if it exists.
 func DropOverlayTable(ctx context.Context, pool *pgxpool.Pool, branchSchema, tableName string) error {
 	sql := fmt.Sprintf("DROP TABLE IF EXISTS %s.%s",
@@ -97,6 +304,19 @@ func OverlayRowCount(ctx context.Context, pool *pgxpool.Pool, branchSchema, tabl
 	return count, nil
 }
 
+// overlayExceeds reports whether an overlay table has more than n live rows,
+// reading at most n+1 of them.
+func overlayExceeds(ctx context.Context, pool *pgxpool.Pool, branchSchema, tableName string, n int) (bool, error) {
+	var over bool
+	err := pool.QueryRow(ctx,
+		fmt.Sprintf("SELECT COUNT(*) > $1 FROM (SELECT 1 FROM %s.%s WHERE NOT _rift_tombstone LIMIT $1 + 1) r",
+			pgQuoteIdent(branchSchema), pgQuoteIdent(tableName)), n).Scan(&over)
+	if err != nil {
+		return false, fmt.Errorf("count overlay rows: %w", err)
+	}
+	return over, nil
+}
+
 // TombstoneCount returns the count of tombstone rows in an overlay table.
 func TombstoneCount(ctx context.Context, pool *pgxpool.Pool, branchSchema, tableName string) (int64, error) {
 	var count int64
@@ -113,6 +333,10 @@ func pgQuoteIdent(ident string) string {
 	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
 }
 
+func pgQuoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
 func quoteIdents(idents []string) []string {
 	quoted := make([]string, len(idents))
 	for i, id := range idents {
@@ -0,0 +1,86 @@
+package cow
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// PrefetchPrimaryKeys looks up the primary keys of tables with up to
+// concurrency queries in flight and returns them ready for
+// Store.BulkCachePrimaryKeys. Tables without a primary key are skipped.
+// progress, if set, is called with the number of tables done so far; calls
+// are serialized.
+func PrefetchPrimaryKeys(ctx context.Context, pool *pgxpool.Pool, tables []QualifiedTable, concurrency int, progress func(done int)) ([]storage.PrimaryKeyColumn, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	work := make(chan QualifiedTable)
+	var (
+		mu       sync.Mutex
+		keys     []storage.PrimaryKeyColumn
+		done     int
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range work {
+				pkCols, err := GetTablePrimaryKeys(ctx, pool, t.Schema, t.Name)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("get PKs for %s.%s: %w", t.Schema, t.Name, err)
+						cancel()
+					}
+					mu.Unlock()
+					continue
+				}
+				for j, col := range pkCols {
+					keys = append(keys, storage.PrimaryKeyColumn{
+						SourceSchema: t.Schema,
+						TableName:    t.Name,
+						ColumnName:   col,
+						Ordinal:      j + 1,
+					})
+				}
+				done++
+				if progress != nil {
+					progress(done)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, t := range tables {
+		select {
+		case work <- t:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,210 @@
+package cow
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// DefaultMergeRowsPerSecond is the merge throughput PreviewMerge assumes
+// unless SetMergeRowsPerSecond changes it.
+const DefaultMergeRowsPerSecond = 10000
+
+// MergePreview summarizes what merging a branch into its parent would do.
+type MergePreview struct {
+	BranchName string
+	Parent     string
+
+	// Tables holds the change counts of each table the merge writes to.
+	Tables  []TableDiff
+	Inserts int64
+	Updates int64
+	Deletes int64
+
+	// Migrations is the number of DDL migrations replayed before the data.
+	Migrations int
+
+	// EstimatedDuration is a rough guess at how long the merge runs.
+	EstimatedDuration time.Duration
+
+	// FKRisks lists foreign keys the merged data would violate.
+	FKRisks []FKRisk
+}
+
+// FKRisk is a foreign key that rows would violate after a merge: child rows
+// whose referenced row the branch doesn't have.
+type FKRisk struct {
+	SourceSchema string
+	TableName    string
+	Constraint   string
+	RefTable     string
+	Rows         int64
+}
+
+// TotalRows returns the number of rows the merge writes.
+func (p *MergePreview) TotalRows() int64 {
+	return p.Inserts + p.Updates + p.Deletes
+}
+
+// SetMergeRowsPerSecond sets the merge throughput PreviewMerge bases its
+// estimate on. 0 restores DefaultMergeRowsPerSecond.
+func (e *Engine) SetMergeRowsPerSecond(n int) {
+	e.mergeRowsPerSecond = n
+}
+
+// PreviewMerge summarizes merging a branch into its parent without changing
+// anything: the tables and rows affected, an estimate of how long it takes,
+// and the foreign keys the merged rows would violate.
+func (e *Engine) PreviewMerge(ctx context.Context, branchName string) (*MergePreview, error) {
+	diff, err := e.Diff(ctx, branchName, "")
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := e.PendingMigrations(ctx, branchName)
+	if err != nil {
+		return nil, fmt.Errorf("list migrations: %w", err)
+	}
+
+	rowsPerSecond := e.mergeRowsPerSecond
+	if rowsPerSecond <= 0 {
+		rowsPerSecond = DefaultMergeRowsPerSecond
+	}
+
+	preview := &MergePreview{
+		BranchName: branchName,
+		Parent:     diff.Parent,
+		Migrations: len(migrations),
+	}
+	pool := e.store.Pool()
+	changed := make(map[string]bool)
+	for _, td := range diff.Tables {
+		rows := td.Inserts + td.Updates + td.Deletes
+		if rows == 0 {
+			continue
+		}
+		preview.Tables = append(preview.Tables, td)
+		preview.Inserts += td.Inserts
+		preview.Updates += td.Updates
+		preview.Deletes += td.Deletes
+		changed[td.SourceSchema+"."+td.TableName] = true
+
+		sourceRows, err := estimatedRowCount(ctx, pool, td.SourceSchema, td.TableName)
+		if err != nil {
+			return nil, fmt.Errorf("size of %s: %w", td.TableName, err)
+		}
+		preview.EstimatedDuration += estimateMergeDuration(rows, sourceRows, rowsPerSecond)
+	}
+
+	tables, err := e.store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return nil, fmt.Errorf("list tracked tables: %w", err)
+	}
+	preview.FKRisks, err = e.foreignKeyRisks(ctx, branchName, tables, changed)
+	if err != nil {
+		return nil, err
+	}
+	return preview, nil
+}
+
+// estimateMergeDuration guesses how long merging rows into a table of
+// sourceRows rows takes. Each row costs a primary key index lookup, and index
+// depth grows with the log of the table size, so big tables are scaled up.
+func estimateMergeDuration(rows, sourceRows int64, rowsPerSecond int) time.Duration {
+	sizeFactor := 1 + math.Log10(float64(max(sourceRows, 1)))/10
+	seconds := float64(rows) / float64(rowsPerSecond) * sizeFactor
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// estimatedRowCount returns the planner's row estimate for a table, which
+// is cheap to read and close enough for a time estimate.
+func estimatedRowCount(ctx context.Context, pool *pgxpool.Pool, schema, table string) (int64, error) {
+	var rows float64
+	err := pool.QueryRow(ctx,
+		`SELECT GREATEST(c.reltuples, 0) FROM pg_class c
+		 JOIN pg_namespace n ON n.oid = c.relnamespace
+		 WHERE n.nspname = $1 AND c.relname = $2`, schema, table).Scan(&rows)
+	return int64(rows), err
+}
+
+// foreignKeyRisks checks the foreign keys of changed tables, and of tables
+// referencing changed tables, against the branch's view of the data, which is
+// what the parent holds after the merge.
+func (e *Engine) foreignKeyRisks(ctx context.Context, branchName string, tables []*storage.TrackedTable, changed map[string]bool) ([]FKRisk, error) {
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+	tracked := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		tracked[t.SourceSchema+"."+t.TableName] = true
+	}
+
+	var risks []FKRisk
+	for _, t := range tables {
+		fks, err := IntrospectForeignKeys(ctx, pool, t.SourceSchema, t.TableName)
+		if err != nil {
+			return nil, fmt.Errorf("get foreign keys for %s: %w", t.TableName, err)
+		}
+		for _, fk := range fks {
+			refKey := fk.RefSchema + "." + fk.RefTable
+			if !changed[t.SourceSchema+"."+t.TableName] && !changed[refKey] {
+				continue
+			}
+
+			child, err := e.branchView(ctx, branchSchema, t.SourceSchema, t.TableName, fk.Columns, true)
+			if err != nil {
+				return nil, err
+			}
+			parent, err := e.branchView(ctx, branchSchema, fk.RefSchema, fk.RefTable, fk.RefColumns, tracked[refKey])
+			if err != nil {
+				return nil, err
+			}
+
+			var rows int64
+			if err := pool.QueryRow(ctx, fkViolationSQL(child, parent, fk)).Scan(&rows); err != nil {
+				return nil, fmt.Errorf("check %s: %w", fk.Name, err)
+			}
+			if rows > 0 {
+				risks = append(risks, FKRisk{
+					SourceSchema: t.SourceSchema,
+					TableName:    t.TableName,
+					Constraint:   fk.Name,
+					RefTable:     fk.RefTable,
+					Rows:         rows,
+				})
+			}
+		}
+	}
+	return risks, nil
+}
+
+// branchView returns a query for columns of a table as the branch sees it;
+// tables the branch doesn't track are read from the source.
+func (e *Engine) branchView(ctx context.Context, branchSchema, schema, table string, columns []string, tracked bool) (string, error) {
+	if !tracked {
+		return mergedViewSQL("", schema, table, columns, nil), nil
+	}
+	pkCols, err := e.getPKColumns(ctx, schema, table, branchSchema)
+	if err != nil {
+		return "", fmt.Errorf("get PKs for %s: %w", table, err)
+	}
+	return mergedViewSQL(branchSchema, schema, table, columns, pkCols), nil
+}
+
+// fkViolationSQL counts the child rows with a non-NULL foreign key that
+// matches no parent row.
+func fkViolationSQL(childView, parentView string, fk ForeignKeyDef) string {
+	notNull := make([]string, len(fk.Columns))
+	match := make([]string, len(fk.Columns))
+	for i, col := range fk.Columns {
+		notNull[i] = "c." + pgQuoteIdent(col) + " IS NOT NULL"
+		match[i] = fmt.Sprintf("p.%s = c.%s", pgQuoteIdent(fk.RefColumns[i]), pgQuoteIdent(col))
+	}
+	return fmt.Sprintf(
+		`SELECT count(*) FROM (%s) c
+		 WHERE %s AND NOT EXISTS (SELECT 1 FROM (%s) p WHERE %s)`,
+		childView, strings.Join(notNull, " AND "), parentView, strings.Join(match, " AND "))
+}
@@ -0,0 +1,329 @@
+package cow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/riftdata/rift/internal/parser"
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// AuditRebase is the audited operation for RebaseBranch.
+const AuditRebase = "rebase"
+
+// OverlayChange is one row of a branch's delta, captured by RebaseBranch so
+// it can be replayed on top of the current source data.
+type OverlayChange struct {
+	Operation    string // ChangeInsert, ChangeUpdate or ChangeDelete
+	SourceSchema string
+	Table        string
+	// Values are kept as Postgres's JSON encoding of the column, so numbers
+	// and timestamps replay exactly.
+	PKValues map[string]json.RawMessage
+	// ColumnValues holds the full row for inserts and the columns whose
+	// values differ from the source row for updates. It is nil for deletes.
+	ColumnValues map[string]json.RawMessage
+}
+
+// rebaseResult counts what a rebase replayed; it is recorded in the audit log.
+type rebaseResult struct {
+	Tables   int
+	Replayed int
+	// Skipped counts updates and deletes whose source row no longer exists.
+	Skipped int
+}
+
+// RebaseBranch rebuilds a branch's overlay on top of the current source data.
+// It captures the branch's delta, recreates the overlay tables from the
+// current source tables, and replays each change: an updated row is the
+// current source row with the branch's differing columns applied, so columns
+// added to the source since are picked up. Changes to rows that no longer
+// exist in the source are dropped. The old overlay is kept until the replay
+// succeeds and restored if it fails.
+func (e *Engine) RebaseBranch(ctx context.Context, branchName string) error {
+	if branchName == "main" {
+		return fmt.Errorf("main has no overlay to rebase")
+	}
+	b, err := e.store.GetBranch(ctx, branchName)
+	if err != nil {
+		return fmt.Errorf("get branch: %w", err)
+	}
+	if b.Protected {
+		return fmt.Errorf("rebase %q: %w", branchName, ErrBranchProtected)
+	}
+	if b.Frozen {
+		return fmt.Errorf("rebase %q: %w", branchName, ErrBranchFrozen)
+	}
+
+	tables, err := e.store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return fmt.Errorf("list tracked tables: %w", err)
+	}
+
+	changes, err := e.captureDelta(ctx, branchName, tables)
+	if err != nil {
+		return fmt.Errorf("capture delta: %w", err)
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+	backupSchema := rebaseBackupSchema(branchSchema)
+
+	if _, err := pool.Exec(ctx, fmt.Sprintf("ALTER SCHEMA %s RENAME TO %s",
+		pgQuoteIdent(branchSchema), pgQuoteIdent(backupSchema))); err != nil {
+		return fmt.Errorf("move old overlay aside: %w", err)
+	}
+	for _, t := range tables {
+		if err := e.store.UntrackTable(ctx, branchName, t.SourceSchema, t.TableName); err != nil {
+			e.restoreOverlay(ctx, branchName, backupSchema, tables)
+			return fmt.Errorf("untrack %s.%s: %w", t.SourceSchema, t.TableName, err)
+		}
+	}
+
+	result, err := e.replayDelta(ctx, branchName, tables, changes)
+	if err != nil {
+		e.restoreOverlay(ctx, branchName, backupSchema, tables)
+		return fmt.Errorf("replay delta (branch left unchanged): %w", err)
+	}
+
+	if _, err := pool.Exec(ctx, fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE",
+		pgQuoteIdent(backupSchema))); err != nil {
+		return fmt.Errorf("drop old overlay: %w", err)
+	}
+
+	e.audit(ctx, branchName, AuditRebase, map[string]any{
+		"tables":   result.Tables,
+		"replayed": result.Replayed,
+		"skipped":  result.Skipped,
+	})
+	return nil
+}
+
+// rebaseBackupSchema names the schema an overlay is moved to during a rebase.
+// Swapping the prefix keeps the name distinct from the branch schema even when
+// Postgres truncates long identifiers.
+func rebaseBackupSchema(branchSchema string) string {
+	return "_rift_rebase_" + strings.TrimPrefix(branchSchema, "_rift_branch_")
+}
+
+// restoreOverlay undoes a failed rebase: it drops the partially rebuilt
+// overlay, moves the old one back and tracks its tables again.
+func (e *Engine) restoreOverlay(ctx context.Context, branchName, backupSchema string, tables []*storage.TrackedTable) {
+	ctx = context.WithoutCancel(ctx)
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+
+	_ = e.store.DropBranchSchema(ctx, branchName)
+	_, _ = pool.Exec(ctx, fmt.Sprintf("ALTER SCHEMA %s RENAME TO %s",
+		pgQuoteIdent(backupSchema), pgQuoteIdent(branchSchema)))
+
+	if current, err := e.store.ListTrackedTables(ctx, branchName, ""); err == nil {
+		for _, t := range current {
+			_ = e.store.UntrackTable(ctx, branchName, t.SourceSchema, t.TableName)
+		}
+	}
+	for _, t := range tables {
+		_ = e.store.TrackTable(ctx, t)
+		_ = e.store.UpdateTrackedTableRowCount(ctx, branchName, t.SourceSchema, t.TableName, t.RowCount)
+	}
+}
+
+// captureDelta reads every overlay row of a branch as an OverlayChange.
+// Tombstones for rows that are already gone from the source and overlay rows
+// identical to their source row carry no change and are left out.
+func (e *Engine) captureDelta(ctx context.Context, branchName string, tables []*storage.TrackedTable) ([]OverlayChange, error) {
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+
+	var changes []OverlayChange
+	for _, t := range tables {
+		pkCols, err := e.getPKColumns(ctx, t.SourceSchema, t.TableName, branchSchema)
+		if err != nil {
+			return nil, fmt.Errorf("get PKs for %s: %w", t.TableName, err)
+		}
+		if len(pkCols) == 0 {
+			return nil, fmt.Errorf("table %s.%s has no primary key", t.SourceSchema, t.TableName)
+		}
+
+		tc, err := captureTableDelta(ctx, pool, branchSchema, t.SourceSchema, t.TableName, pkCols)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.SourceSchema, t.TableName, err)
+		}
+		changes = append(changes, tc...)
+	}
+	return changes, nil
+}
+
+func captureTableDelta(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string, pkCols []string) ([]OverlayChange, error) {
+	ovrTable := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(tableName)
+	srcTable := pgQuoteIdent(sourceSchema) + "." + pgQuoteIdent(tableName)
+
+	// The lateral join yields the matching source row, if any; the subquery
+	// keeps only the overlay columns whose values differ from it.
+	rows, err := pool.Query(ctx, fmt.Sprintf(
+		`SELECT ovr._rift_tombstone,
+		        s.row IS NOT NULL,
+		        to_jsonb(ovr) - '{_rift_tombstone,_rift_updated_at}'::text[],
+		        (SELECT jsonb_object_agg(c.key, c.value)
+		         FROM jsonb_each(to_jsonb(ovr) - '{_rift_tombstone,_rift_updated_at}'::text[]) c
+		         WHERE s.row -> c.key IS DISTINCT FROM c.value)
+		 FROM %s ovr
+		 LEFT JOIN LATERAL (SELECT to_jsonb(src) AS row FROM %s src WHERE %s) s ON true`,
+		ovrTable, srcTable, buildPKJoin("src", "ovr", pkCols)))
+	if err != nil {
+		return nil, fmt.Errorf("read overlay: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []OverlayChange
+	for rows.Next() {
+		var tombstone, inSource bool
+		var row, changed map[string]json.RawMessage
+		if err := rows.Scan(&tombstone, &inSource, &row, &changed); err != nil {
+			return nil, fmt.Errorf("scan overlay row: %w", err)
+		}
+
+		c := OverlayChange{SourceSchema: sourceSchema, Table: tableName, PKValues: pickColumns(row, pkCols)}
+		switch {
+		case tombstone && !inSource:
+			continue
+		case tombstone:
+			c.Operation = ChangeDelete
+		case !inSource:
+			c.Operation = ChangeInsert
+			c.ColumnValues = row
+		case len(changed) == 0:
+			continue
+		default:
+			c.Operation = ChangeUpdate
+			c.ColumnValues = changed
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+func pickColumns(row map[string]json.RawMessage, cols []string) map[string]json.RawMessage {
+	picked := make(map[string]json.RawMessage, len(cols))
+	for _, col := range cols {
+		picked[col] = row[col]
+	}
+	return picked
+}
+
+// replayDelta creates fresh overlays for tables and applies changes to them.
+func (e *Engine) replayDelta(ctx context.Context, branchName string, tables []*storage.TrackedTable, changes []OverlayChange) (*rebaseResult, error) {
+	if err := e.store.CreateBranchSchema(ctx, branchName); err != nil {
+		return nil, err
+	}
+
+	pq := &parser.ParsedQuery{Type: parser.QueryInsert}
+	for _, t := range tables {
+		pq.Tables = append(pq.Tables, parser.TableRef{Schema: t.SourceSchema, Name: t.TableName})
+	}
+	if err := e.ensureOverlays(ctx, branchName, pq, nil); err != nil {
+		return nil, err
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+	result := &rebaseResult{Tables: len(tables)}
+	rowCounts := make(map[QualifiedTable]int64)
+
+	for _, c := range changes {
+		pkCols, err := e.getPKColumns(ctx, c.SourceSchema, c.Table, branchSchema)
+		if err != nil {
+			return nil, fmt.Errorf("get PKs for %s: %w", c.Table, err)
+		}
+		applied, err := replayChange(ctx, pool, branchSchema, pkCols, c)
+		if err != nil {
+			return nil, fmt.Errorf("replay %s on %s.%s: %w", strings.ToLower(c.Operation), c.SourceSchema, c.Table, err)
+		}
+		if !applied {
+			result.Skipped++
+			continue
+		}
+		result.Replayed++
+		rowCounts[QualifiedTable{Schema: c.SourceSchema, Name: c.Table}]++
+	}
+
+	for _, t := range tables {
+		n := rowCounts[QualifiedTable{Schema: t.SourceSchema, Name: t.TableName}]
+		if err := e.store.UpdateTrackedTableRowCount(ctx, branchName, t.SourceSchema, t.TableName, n); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// replayChange writes one change into the new overlay. Updates and deletes
+// start from the current source row; they report false, and write nothing,
+// when that row no longer exists.
+func replayChange(ctx context.Context, pool *pgxpool.Pool, branchSchema string, pkCols []string, c OverlayChange) (bool, error) {
+	ovrTable := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(c.Table)
+	srcTable := pgQuoteIdent(c.SourceSchema) + "." + pgQuoteIdent(c.Table)
+
+	if c.Operation == ChangeInsert {
+		// Name the columns so ones added to the source since get their defaults.
+		cols, err := overlayColumns(ctx, pool, branchSchema, c.Table, c.ColumnValues)
+		if err != nil {
+			return false, err
+		}
+		_, err = pool.Exec(ctx, fmt.Sprintf(
+			`INSERT INTO %s (%s) SELECT %s FROM jsonb_populate_record(NULL::%s, $1::jsonb)`,
+			ovrTable, cols, cols, ovrTable), c.ColumnValues)
+		return err == nil, err
+	}
+
+	values := c.ColumnValues
+	if values == nil {
+		values = map[string]json.RawMessage{}
+	}
+	tombstone := `{"_rift_tombstone": false}`
+	if c.Operation == ChangeDelete {
+		tombstone = `{"_rift_tombstone": true}`
+	}
+
+	pkList := quotedColumns(pkCols)
+	tag, err := pool.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %s
+		 SELECT (jsonb_populate_record(NULL::%s, to_jsonb(src) || $1::jsonb || '%s'::jsonb)).*
+		 FROM %s src
+		 WHERE (%s) = (SELECT %s FROM jsonb_populate_record(NULL::%s, $2::jsonb))`,
+		ovrTable, ovrTable, tombstone, srcTable,
+		qualifiedColumns("src", pkCols, ""), pkList, srcTable), values, c.PKValues)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// overlayColumns returns the quoted, comma-separated names of the overlay
+// columns present in values, in table order.
+func overlayColumns(ctx context.Context, pool *pgxpool.Pool, branchSchema, table string, values map[string]json.RawMessage) (string, error) {
+	defs, err := IntrospectTable(ctx, pool, branchSchema, table)
+	if err != nil {
+		return "", fmt.Errorf("introspect overlay %s: %w", table, err)
+	}
+	var cols []string
+	for _, d := range defs {
+		if _, ok := values[d.Name]; ok {
+			cols = append(cols, d.Name)
+		}
+	}
+	if len(cols) == 0 {
+		return "", fmt.Errorf("no columns of %s left in the overlay", table)
+	}
+	return quotedColumns(cols), nil
+}
+
+func quotedColumns(cols []string) string {
+	quoted := make([]string, len(cols))
+	for i, col := range cols {
+		quoted[i] = pgQuoteIdent(col)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,173 @@
+package cow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// OverlayRepair lists what RepairOverlays fixed in one overlay table.
+type OverlayRepair struct {
+	SourceSchema string
+	TableName    string
+	Fixes        []string
+}
+
+// RepairOverlay fixes an overlay table left partially broken, e.g. by an
+// interrupted migration, so writes to it work again: a missing
+// _rift_tombstone or _rift_updated_at column is added, a missing primary key
+// is added on the source's primary key columns, and columns whose type
+// differs from the source's are altered back to it. A type change is a
+// single ALTER, so one whose values don't cast leaves the column as it was
+// and returns an error. Note this also undoes type changes a branch
+// migration made on purpose.
+func RepairOverlay(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string) error {
+	_, err := repairOverlay(ctx, pool, branchSchema, sourceSchema, tableName)
+	return err
+}
+
+// repairOverlay does the work of RepairOverlay and describes each fix.
+func repairOverlay(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string) ([]string, error) {
+	ovrCols, err := IntrospectTable(ctx, pool, branchSchema, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("introspect overlay: %w", err)
+	}
+	hasColumn := func(name string) bool {
+		return slices.ContainsFunc(ovrCols, func(c ColumnDef) bool { return c.Name == name })
+	}
+	overlayTable := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(tableName)
+
+	var fixes []string
+	if !hasColumn("_rift_tombstone") {
+		addTombstone := fmt.Sprintf(
+			`ALTER TABLE %s ADD COLUMN IF NOT EXISTS _rift_tombstone BOOLEAN NOT NULL DEFAULT false`,
+			overlayTable)
+		if _, err := pool.Exec(ctx, addTombstone); err != nil {
+			return fixes, fmt.Errorf("add tombstone column: %w", err)
+		}
+		fixes = append(fixes, "added the _rift_tombstone column")
+	}
+	if !hasColumn("_rift_updated_at") {
+		if err := AddUpdatedAtTracking(ctx, pool, branchSchema, tableName); err != nil {
+			return fixes, err
+		}
+		fixes = append(fixes, "added the _rift_updated_at column")
+	}
+
+	pkCols, err := GetTablePrimaryKeys(ctx, pool, sourceSchema, tableName)
+	if err != nil {
+		return fixes, fmt.Errorf("get source PKs: %w", err)
+	}
+	if len(pkCols) == 0 {
+		return fixes, fmt.Errorf("table %s.%s has no primary key; overlay requires a PK", sourceSchema, tableName)
+	}
+	added, err := addOverlayPK(ctx, pool, branchSchema, tableName, pkCols)
+	if err != nil {
+		return fixes, err
+	}
+	if added {
+		fixes = append(fixes, "added the primary key")
+	}
+
+	// Compare full types, with length and precision, of the columns both
+	// tables have; columns only one of them has are rift validate's concern.
+	rows, err := pool.Query(ctx,
+		`SELECT s.attname,
+		        pg_catalog.format_type(s.atttypid, s.atttypmod),
+		        pg_catalog.format_type(o.atttypid, o.atttypmod)
+		 FROM pg_catalog.pg_attribute s
+		 JOIN pg_catalog.pg_attribute o ON o.attname = s.attname
+		 WHERE s.attrelid = format('%I.%I', $1::text, $3::text)::regclass
+		   AND o.attrelid = format('%I.%I', $2::text, $3::text)::regclass
+		   AND s.attnum > 0 AND NOT s.attisdropped
+		   AND o.attnum > 0 AND NOT o.attisdropped
+		   AND (s.atttypid, s.atttypmod) <> (o.atttypid, o.atttypmod)
+		 ORDER BY s.attnum`,
+		sourceSchema, branchSchema, tableName)
+	if err != nil {
+		return fixes, fmt.Errorf("compare column types: %w", err)
+	}
+	type mismatch struct{ column, sourceType, overlayType string }
+	var mismatches []mismatch
+	for rows.Next() {
+		var m mismatch
+		if err := rows.Scan(&m.column, &m.sourceType, &m.overlayType); err != nil {
+			rows.Close()
+			return fixes, fmt.Errorf("scan column type: %w", err)
+		}
+		mismatches = append(mismatches, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fixes, fmt.Errorf("compare column types: %w", err)
+	}
+
+	for _, m := range mismatches {
+		col := pgQuoteIdent(m.column)
+		alterSQL := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s",
+			overlayTable, col, m.sourceType, col, m.sourceType)
+		if _, err := pool.Exec(ctx, alterSQL); err != nil {
+			return fixes, fmt.Errorf("change column %q from %s to %s: %w", m.column, m.overlayType, m.sourceType, err)
+		}
+		fixes = append(fixes, fmt.Sprintf("changed column %q from %s to %s", m.column, m.overlayType, m.sourceType))
+	}
+	return fixes, nil
+}
+
+// RepairOverlays runs RepairOverlay on each overlay table a branch tracks,
+// or only on the tables named in only ("table" or "schema.table"), and
+// returns the tables it fixed.
+func (e *Engine) RepairOverlays(ctx context.Context, branchName string, only []string) ([]OverlayRepair, error) {
+	if branchName == "main" {
+		return nil, fmt.Errorf("main has no overlays to repair")
+	}
+	if _, err := e.store.GetBranch(ctx, branchName); err != nil {
+		return nil, fmt.Errorf("get branch: %w", err)
+	}
+	tables, err := e.store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return nil, fmt.Errorf("list tracked tables: %w", err)
+	}
+	if tables, err = filterTrackedTables(tables, only, branchName); err != nil {
+		return nil, err
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+	var repairs []OverlayRepair
+	var repaired []string
+	for _, t := range tables {
+		fixes, err := repairOverlay(ctx, pool, branchSchema, t.SourceSchema, t.TableName)
+		if len(fixes) > 0 {
+			repairs = append(repairs, OverlayRepair{SourceSchema: t.SourceSchema, TableName: t.TableName, Fixes: fixes})
+			repaired = append(repaired, t.SourceSchema+"."+t.TableName)
+		}
+		if err != nil {
+			e.auditOverlayRepair(ctx, branchName, repaired)
+			return repairs, fmt.Errorf("repair %s.%s: %w", t.SourceSchema, t.TableName, err)
+		}
+	}
+	e.auditOverlayRepair(ctx, branchName, repaired)
+	return repairs, nil
+}
+
+func (e *Engine) auditOverlayRepair(ctx context.Context, branchName string, tables []string) {
+	if len(tables) > 0 {
+		e.audit(ctx, branchName, AuditRepair, map[string]any{"tables": tables})
+	}
+}
+
+// isStructuralError reports whether err is one a broken overlay table
+// causes: a column rift expects doesn't exist (undefined_column), or there's
+// no primary key for ON CONFLICT to use (invalid_column_reference).
+func isStructuralError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "42703" || pgErr.Code == "42P10"
+	}
+	return false
+}
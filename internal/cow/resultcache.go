@@ -0,0 +1,155 @@
+package cow
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/riftdata/rift/internal/parser"
+)
+
+// MaxCachedRows is the largest result a ResultCache stores; bigger results
+// are sent to the client but not cached.
+const MaxCachedRows = 1000
+
+// CachedResult is a SELECT result held by a ResultCache. Rows hold text
+// values in Fields order; a nil value is SQL NULL.
+type CachedResult struct {
+	Fields []pgconn.FieldDescription
+	Rows   [][]*string
+}
+
+// ResultCache is an LRU cache of SELECT results on frozen branches. A frozen
+// branch's overlay can't change, so a repeated query returns the same rows
+// until the source tables change; the TTL bounds how stale a result can get.
+// It is safe for concurrent use.
+type ResultCache struct {
+	mu      sync.Mutex
+	maxSize int
+	ttl     time.Duration
+	lru     *list.List // of *resultCacheEntry, most recently used first
+	entries map[string]*list.Element
+
+	now func() time.Time // for tests
+}
+
+type resultCacheEntry struct {
+	key     string
+	branch  string
+	result  *CachedResult
+	expires time.Time
+}
+
+// NewResultCache creates a cache holding up to maxSize results for ttl each.
+// A zero ttl keeps results until they are evicted.
+func NewResultCache(maxSize int, ttl time.Duration) *ResultCache {
+	return &ResultCache{
+		maxSize: maxSize,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// ResultCacheKey returns the cache key for a query on a branch: the SHA-256
+// of the branch name, the query as normalized by parser.NormalizeSQL, the
+// literals it replaced, and the query's arguments. Queries that don't parse
+// are keyed with only their whitespace normalized.
+func ResultCacheKey(branchName, sql string, args []any) string {
+	normalized, literals, err := parser.NormalizeSQL(sql)
+	if err != nil {
+		normalized, literals = strings.Join(strings.Fields(sql), " "), nil
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", branchName, normalized)
+	for _, lit := range literals {
+		fmt.Fprintf(h, "%s\x00", lit)
+	}
+	for _, arg := range args {
+		fmt.Fprintf(h, "%T:%v\x00", arg, arg)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns the cached result for key, if present and not expired.
+func (c *ResultCache) Get(key string) (*CachedResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*resultCacheEntry)
+	if !entry.expires.IsZero() && !c.now().Before(entry.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return entry.result, true
+}
+
+// Put caches a result of a query on branchName, evicting the least recently
+// used result if the cache is full.
+func (c *ResultCache) Put(key, branchName string, r *CachedResult) {
+	if c.maxSize <= 0 || len(r.Rows) > MaxCachedRows {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = c.now().Add(c.ttl)
+	}
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*resultCacheEntry)
+		entry.result, entry.expires = r, expires
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&resultCacheEntry{
+		key:     key,
+		branch:  branchName,
+		result:  r,
+		expires: expires,
+	})
+	for c.lru.Len() > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+// InvalidateBranch drops every cached result for a branch.
+func (c *ResultCache) InvalidateBranch(branchName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*resultCacheEntry).branch == branchName {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// Len returns the number of cached results.
+func (c *ResultCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *ResultCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*resultCacheEntry).key)
+}
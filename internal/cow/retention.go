@@ -0,0 +1,110 @@
+package cow
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// Audited soft-delete operations.
+const (
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+// RestoreBranch brings back a branch soft-deleted by DeleteBranch, with its
+// overlay as it was when it was deleted. Its parent must not be deleted.
+func (e *Engine) RestoreBranch(ctx context.Context, branchName string) error {
+	branch, err := e.store.GetBranch(ctx, branchName)
+	if err != nil {
+		return fmt.Errorf("get branch: %w", err)
+	}
+	if branch.DeletedAt == nil {
+		return fmt.Errorf("branch %q is not deleted", branchName)
+	}
+	if branch.Parent != "" {
+		parent, err := e.store.GetBranch(ctx, branch.Parent)
+		if err != nil {
+			return fmt.Errorf("parent branch: %w", err)
+		}
+		if parent.DeletedAt != nil {
+			return fmt.Errorf("parent branch %q is deleted; restore it first", branch.Parent)
+		}
+	}
+
+	if err := e.store.SetBranchDeleted(ctx, branchName, false); err != nil {
+		return err
+	}
+
+	e.audit(ctx, branchName, AuditRestore, map[string]any{"deleted_at": branch.DeletedAt})
+	return nil
+}
+
+// PurgeBranch deletes a branch and its overlay schema for good, whether it is
+// live or soft-deleted. It can't be restored afterwards.
+func (e *Engine) PurgeBranch(ctx context.Context, branchName string) error {
+	branch, err := e.checkDeletable(ctx, branchName, true)
+	if err != nil {
+		return err
+	}
+	if err := e.purge(ctx, branchName); err != nil {
+		return err
+	}
+
+	e.audit(ctx, branchName, AuditPurge, map[string]any{"parent": branch.Parent})
+	return nil
+}
+
+func (e *Engine) purge(ctx context.Context, branchName string) error {
+	if err := e.store.DropBranchSchema(ctx, branchName); err != nil {
+		return fmt.Errorf("drop branch schema: %w", err)
+	}
+	return e.store.DeleteBranch(ctx, branchName)
+}
+
+// PurgeDeletedBranches purges the branches soft-deleted more than retention
+// ago and returns their names. A branch that can't be purged yet, such as one
+// with a deleted child still within retention, is skipped.
+func (e *Engine) PurgeDeletedBranches(ctx context.Context, retention time.Duration) ([]string, error) {
+	// Newest first, so children are purged before their parents
+	branches, err := e.store.ListBranchesSorted(ctx, storage.BranchFilter{Status: "deleted"},
+		[]storage.SortKey{{Field: "created_at", Desc: true}})
+	if err != nil {
+		return nil, fmt.Errorf("list deleted branches: %w", err)
+	}
+
+	cutoff := time.Now().Add(-retention)
+	var purged []string
+	for _, b := range branches {
+		if b.DeletedAt == nil || b.DeletedAt.After(cutoff) {
+			continue
+		}
+		if _, err := e.checkDeletable(ctx, b.Name, true); err != nil {
+			continue
+		}
+		if err := e.purge(ctx, b.Name); err != nil {
+			return purged, fmt.Errorf("purge %s: %w", b.Name, err)
+		}
+		e.audit(ctx, b.Name, AuditPurge, map[string]any{"parent": b.Parent, "deleted_at": b.DeletedAt})
+		purged = append(purged, b.Name)
+	}
+	return purged, nil
+}
+
+// AutoPurge runs PurgeDeletedBranches every interval until ctx is done. It
+// is best effort: a failed purge is retried at the next interval.
+func (e *Engine) AutoPurge(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		_, _ = e.PurgeDeletedBranches(ctx, retention)
+	}
+}
@@ -0,0 +1,69 @@
+package cow
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// AuditRevertTable is the audit log operation recorded by RevertTable.
+const AuditRevertTable = "revert_table"
+
+// RevertTable discards every change a branch has made to one table: the
+// table's overlay is dropped, it is no longer tracked by the branch, and its
+// cached primary key is cleared. Reads on the branch see the source table
+// again, and the next write to it creates a fresh, empty overlay. Changes to
+// the branch's other tables are kept.
+func (e *Engine) RevertTable(ctx context.Context, branchName, sourceSchema, tableName string) error {
+	if branchName == "main" {
+		return fmt.Errorf("main has no overlay to revert")
+	}
+	b, err := e.store.GetBranch(ctx, branchName)
+	if err != nil {
+		return fmt.Errorf("get branch: %w", err)
+	}
+	if b.Protected {
+		return fmt.Errorf("revert %q: %w", branchName, ErrBranchProtected)
+	}
+	if b.Frozen {
+		return fmt.Errorf("revert %q: %w", branchName, ErrBranchFrozen)
+	}
+
+	tables, err := e.store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return fmt.Errorf("list tracked tables: %w", err)
+	}
+	if !slices.ContainsFunc(tables, func(t *storage.TrackedTable) bool {
+		return t.SourceSchema == sourceSchema && t.TableName == tableName
+	}) {
+		return fmt.Errorf("%w: %s.%s is not tracked by branch %s", ErrTableNotFound, sourceSchema, tableName, branchName)
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+	if e.trackDeltaSize {
+		// Dropping the table doesn't fire its row triggers, so delete the
+		// rows first to take their bytes off the branch's delta_size.
+		if _, err := pool.Exec(ctx, fmt.Sprintf("DELETE FROM %s.%s",
+			pgQuoteIdent(branchSchema), pgQuoteIdent(tableName))); err != nil {
+			return fmt.Errorf("clear overlay: %w", err)
+		}
+	}
+	if err := DropOverlayTable(ctx, pool, branchSchema, tableName); err != nil {
+		return err
+	}
+	if err := e.store.UntrackTable(ctx, branchName, sourceSchema, tableName); err != nil {
+		return fmt.Errorf("untrack %s.%s: %w", sourceSchema, tableName, err)
+	}
+	if err := e.store.DeletePrimaryKeys(ctx, sourceSchema, tableName); err != nil {
+		return fmt.Errorf("clear cached PKs: %w", err)
+	}
+
+	e.audit(ctx, branchName, AuditRevertTable, map[string]any{
+		"schema": sourceSchema,
+		"table":  tableName,
+	})
+	return nil
+}
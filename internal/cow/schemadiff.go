@@ -0,0 +1,135 @@
+package cow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// TableSchemaDiff is a tracked table whose columns a branch changed: Before
+// holds the source table's columns and After the columns the branch sees
+// through its overlay.
+type TableSchemaDiff struct {
+	SourceSchema string
+	TableName    string
+	Before       []ColumnDef
+	After        []ColumnDef
+
+	// Changes lists the differences, as ValidateBranch reports them.
+	Changes []ValidationError
+}
+
+// DiffSchema returns the tracked tables of a branch whose structure differs
+// from their source table, e.g. after an ALTER TABLE on the branch.
+func (e *Engine) DiffSchema(ctx context.Context, branchName string) ([]TableSchemaDiff, error) {
+	if _, err := e.store.GetBranch(ctx, branchName); err != nil {
+		return nil, fmt.Errorf("get branch: %w", err)
+	}
+
+	tables, err := e.store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return nil, fmt.Errorf("list tracked tables: %w", err)
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+
+	var diffs []TableSchemaDiff
+	for _, t := range tables {
+		srcCols, err := IntrospectTable(ctx, pool, t.SourceSchema, t.TableName)
+		if err != nil {
+			return nil, fmt.Errorf("introspect source %s.%s: %w", t.SourceSchema, t.TableName, err)
+		}
+		ovrCols, err := IntrospectTable(ctx, pool, branchSchema, t.TableName)
+		if err != nil {
+			return nil, fmt.Errorf("introspect overlay %s: %w", t.TableName, err)
+		}
+
+		changes := compareColumns(t.SourceSchema, t.TableName, srcCols, ovrCols)
+		if len(changes) == 0 {
+			continue
+		}
+		diffs = append(diffs, TableSchemaDiff{
+			SourceSchema: t.SourceSchema,
+			TableName:    t.TableName,
+			Before:       srcCols,
+			After:        withoutRiftColumns(ovrCols),
+			Changes:      changes,
+		})
+	}
+	return diffs, nil
+}
+
+// withoutRiftColumns drops the overlay bookkeeping columns from cols.
+func withoutRiftColumns(cols []ColumnDef) []ColumnDef {
+	out := make([]ColumnDef, 0, len(cols))
+	for _, c := range cols {
+		if c.Name != "_rift_tombstone" && c.Name != "_rift_updated_at" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// JSONSchemaDialect is the $schema of the documents TableJSONSchema returns.
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema is the subset of a JSON Schema document used to describe a
+// table's rows.
+type JSONSchema struct {
+	Schema     string                 `json:"$schema,omitempty"`
+	Title      string                 `json:"title,omitempty"`
+	Type       any                    `json:"type,omitempty"`
+	Format     string                 `json:"format,omitempty"`
+	Properties map[string]*JSONSchema `json:"properties,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+}
+
+// TableJSONSchema describes the rows of a table with columns cols as a JSON
+// Schema object. NOT NULL columns are required; nullable ones also allow
+// null.
+func TableJSONSchema(title string, cols []ColumnDef) *JSONSchema {
+	s := &JSONSchema{
+		Schema:     JSONSchemaDialect,
+		Title:      title,
+		Type:       "object",
+		Properties: make(map[string]*JSONSchema, len(cols)),
+	}
+	for _, c := range cols {
+		prop := columnJSONSchema(c.DataType)
+		if c.IsNullable && prop.Type != nil {
+			prop.Type = []string{prop.Type.(string), "null"}
+		}
+		s.Properties[c.Name] = prop
+		if !c.IsNullable {
+			s.Required = append(s.Required, c.Name)
+		}
+	}
+	return s
+}
+
+// columnJSONSchema maps a column's information_schema data_type to a JSON
+// Schema. json and jsonb columns may hold any JSON value, so they get no type.
+func columnJSONSchema(dataType string) *JSONSchema {
+	switch {
+	case dataType == "smallint" || dataType == "integer" || dataType == "bigint":
+		return &JSONSchema{Type: "integer"}
+	case dataType == "numeric" || dataType == "real" || dataType == "double precision":
+		return &JSONSchema{Type: "number"}
+	case dataType == "boolean":
+		return &JSONSchema{Type: "boolean"}
+	case strings.HasPrefix(dataType, "timestamp"):
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case dataType == "date":
+		return &JSONSchema{Type: "string", Format: "date"}
+	case strings.HasPrefix(dataType, "time"):
+		return &JSONSchema{Type: "string", Format: "time"}
+	case dataType == "uuid":
+		return &JSONSchema{Type: "string", Format: "uuid"}
+	case dataType == "json" || dataType == "jsonb":
+		return &JSONSchema{}
+	case dataType == "ARRAY":
+		return &JSONSchema{Type: "array"}
+	}
+	return &JSONSchema{Type: "string"}
+}
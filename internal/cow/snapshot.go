@@ -0,0 +1,103 @@
+package cow
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// SnapshotSchemaPrefix prefixes the temporary schemas MaterializeBranch
+// creates.
+const SnapshotSchemaPrefix = "_rift_snapshot_"
+
+// MaterializeBranch copies the complete merged view of every table a branch
+// tracks (source rows plus overlay changes, minus tombstones) into plain
+// tables in a new schema, so tools like pg_dump can read the branch's state.
+// It returns the schema's name; callers drop it with DropSnapshotSchema.
+func (e *Engine) MaterializeBranch(ctx context.Context, branchName string) (string, error) {
+	if _, err := e.store.GetBranch(ctx, branchName); err != nil {
+		return "", fmt.Errorf("get branch: %w", err)
+	}
+	tables, err := e.store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return "", fmt.Errorf("list tracked tables: %w", err)
+	}
+
+	seen := make(map[string]string, len(tables))
+	for _, t := range tables {
+		if schema, ok := seen[t.TableName]; ok {
+			return "", fmt.Errorf("tables %s.%s and %s.%s would share a name in the snapshot",
+				schema, t.TableName, t.SourceSchema, t.TableName)
+		}
+		seen[t.TableName] = t.SourceSchema
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("generate snapshot name: %w", err)
+	}
+	snapshotSchema := SnapshotSchemaPrefix + hex.EncodeToString(suffix)
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, "CREATE SCHEMA "+pgQuoteIdent(snapshotSchema)); err != nil {
+		return "", fmt.Errorf("create snapshot schema: %w", err)
+	}
+
+	for _, t := range tables {
+		types, err := columnTypes(ctx, pool, t.SourceSchema, t.TableName)
+		if err != nil {
+			return "", err
+		}
+		pkCols, err := e.getPKColumns(ctx, t.SourceSchema, t.TableName, branchSchema)
+		if err != nil {
+			return "", fmt.Errorf("get PKs for %s: %w", t.TableName, err)
+		}
+
+		for _, stmt := range snapshotTableStatements(snapshotSchema, branchSchema, t.SourceSchema, t.TableName, types.order, pkCols) {
+			if _, err := tx.Exec(ctx, stmt); err != nil {
+				return "", fmt.Errorf("snapshot %s: %w", t.TableName, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("commit: %w", err)
+	}
+	return snapshotSchema, nil
+}
+
+// snapshotTableStatements returns the statements that create a table in the
+// snapshot schema with the source table's definition and fill it from the
+// branch's merged view.
+func snapshotTableStatements(snapshotSchema, branchSchema, sourceSchema, tableName string, columns, pkCols []string) []string {
+	snapTable := pgQuoteIdent(snapshotSchema) + "." + pgQuoteIdent(tableName)
+	srcTable := pgQuoteIdent(sourceSchema) + "." + pgQuoteIdent(tableName)
+	return []string{
+		fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS INCLUDING GENERATED INCLUDING CONSTRAINTS INCLUDING INDEXES)",
+			snapTable, srcTable),
+		fmt.Sprintf("INSERT INTO %s (%s) %s",
+			snapTable, strings.Join(quoteIdents(columns), ", "),
+			mergedViewSQL(branchSchema, sourceSchema, tableName, columns, pkCols)),
+	}
+}
+
+// DropSnapshotSchema drops a schema created by MaterializeBranch.
+func (e *Engine) DropSnapshotSchema(ctx context.Context, schema string) error {
+	if !strings.HasPrefix(schema, SnapshotSchemaPrefix) {
+		return fmt.Errorf("%q is not a snapshot schema", schema)
+	}
+	if _, err := e.store.Pool().Exec(ctx, fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", pgQuoteIdent(schema))); err != nil {
+		return fmt.Errorf("drop snapshot schema: %w", err)
+	}
+	return nil
+}
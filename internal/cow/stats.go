@@ -0,0 +1,126 @@
+package cow
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TableStats holds storage metrics for a single overlay table.
+type TableStats struct {
+	TableName    string
+	SourceSchema string
+	OverlayRows  int64
+	Tombstones   int64
+	SourceSize   int64 // bytes, from pg_relation_size
+	OverlaySize  int64 // bytes, from pg_relation_size
+	MergeSQLSize int64 // bytes of generated merge SQL
+}
+
+// TombstoneRatio returns the percentage of overlay rows that are tombstones.
+func (s *TableStats) TombstoneRatio() float64 {
+	if s.OverlayRows == 0 {
+		return 0
+	}
+	return float64(s.Tombstones) / float64(s.OverlayRows) * 100
+}
+
+// CompressionRatio returns source size divided by overlay size, or 0 if the
+// overlay is empty.
+func (s *TableStats) CompressionRatio() float64 {
+	if s.OverlaySize == 0 {
+		return 0
+	}
+	return float64(s.SourceSize) / float64(s.OverlaySize)
+}
+
+// BranchStats holds storage metrics for all tracked tables of a branch.
+type BranchStats struct {
+	BranchName string
+	Tables     []TableStats
+}
+
+// Total returns the metrics summed across all tables.
+func (b *BranchStats) Total() TableStats {
+	var total TableStats
+	for _, t := range b.Tables {
+		total.OverlayRows += t.OverlayRows
+		total.Tombstones += t.Tombstones
+		total.SourceSize += t.SourceSize
+		total.OverlaySize += t.OverlaySize
+		total.MergeSQLSize += t.MergeSQLSize
+	}
+	return total
+}
+
+// TableStorageStats computes row counts and on-disk sizes for an overlay table
+// and its source. Merge SQL size is left for the caller to fill in.
+func TableStorageStats(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string) (*TableStats, error) {
+	ovrTable := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(tableName)
+
+	stats := &TableStats{
+		TableName:    tableName,
+		SourceSchema: sourceSchema,
+	}
+
+	err := pool.QueryRow(ctx,
+		fmt.Sprintf("SELECT COUNT(*), COUNT(*) FILTER (WHERE _rift_tombstone) FROM %s", ovrTable),
+	).Scan(&stats.OverlayRows, &stats.Tombstones)
+	if err != nil {
+		return nil, fmt.Errorf("count overlay rows: %w", err)
+	}
+
+	err = pool.QueryRow(ctx,
+		`SELECT pg_catalog.pg_relation_size(format('%I.%I', $1::text, $3::text)::regclass),
+		        pg_catalog.pg_relation_size(format('%I.%I', $2::text, $3::text)::regclass)`,
+		sourceSchema, branchSchema, tableName,
+	).Scan(&stats.SourceSize, &stats.OverlaySize)
+	if err != nil {
+		return nil, fmt.Errorf("relation sizes: %w", err)
+	}
+
+	return stats, nil
+}
+
+// Stats computes storage efficiency metrics for each tracked table in a branch.
+func (e *Engine) Stats(ctx context.Context, branchName string) (*BranchStats, error) {
+	if _, err := e.store.GetBranch(ctx, branchName); err != nil {
+		return nil, fmt.Errorf("get branch: %w", err)
+	}
+
+	tables, err := e.store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return nil, fmt.Errorf("list tracked tables: %w", err)
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+
+	stats := &BranchStats{BranchName: branchName}
+	for _, t := range tables {
+		ts, err := TableStorageStats(ctx, pool, branchSchema, t.SourceSchema, t.TableName)
+		if err != nil {
+			return nil, fmt.Errorf("stats for %s: %w", t.TableName, err)
+		}
+
+		pks, err := e.store.GetPrimaryKeys(ctx, t.SourceSchema, t.TableName)
+		if err != nil {
+			return nil, fmt.Errorf("get PKs for %s: %w", t.TableName, err)
+		}
+		pkCols := make([]string, len(pks))
+		for i, pk := range pks {
+			pkCols[i] = pk.ColumnName
+		}
+
+		m, err := GenerateMergeSQL(ctx, pool, branchSchema, t.SourceSchema, t.TableName, pkCols, MergeAll)
+		if err != nil {
+			return nil, fmt.Errorf("generate merge for %s: %w", t.TableName, err)
+		}
+		ts.MergeSQLSize = int64(len(FormatMergeSQL(m)))
+
+		stats.Tables = append(stats.Tables, *ts)
+	}
+
+	return stats, nil
+}
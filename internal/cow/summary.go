@@ -0,0 +1,180 @@
+package cow
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// summaryLargest is how many of the largest branches Summarize reports.
+const summaryLargest = 5
+
+// Summary aggregates statistics over every branch.
+type Summary struct {
+	Branches    int   `json:"branches"`
+	OverlayRows int64 `json:"overlay_rows"`
+	Tombstones  int64 `json:"tombstones"`
+	DeltaSize   int64 `json:"delta_size"`
+
+	// ByStatus counts branches by status: "deleted" for soft-deleted
+	// branches, "frozen" for frozen ones, and otherwise the branch's status.
+	ByStatus map[string]int `json:"by_status"`
+
+	// Expiring are the live branches whose TTL runs out within the window
+	// passed to Summarize, soonest first.
+	Expiring []ExpiringBranch `json:"expiring"`
+
+	// Largest are the live branches other than main with the largest
+	// delta size, largest first.
+	Largest []BranchSize `json:"largest"`
+}
+
+// ExpiringBranch is a branch whose TTL is about to run out.
+type ExpiringBranch struct {
+	Name      string    `json:"name"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// BranchSize is a branch's overlay size.
+type BranchSize struct {
+	Name        string `json:"name"`
+	DeltaSize   int64  `json:"delta_size"`
+	OverlayRows int64  `json:"overlay_rows"`
+	Tombstones  int64  `json:"tombstones"`
+}
+
+// Summarize aggregates statistics over every branch, soft-deleted ones
+// included in ByStatus only. The overlay rows and tombstones of live
+// branches are counted with up to concurrency branches in flight. Branches
+// expiring between now and now+window are listed in Expiring.
+func (e *Engine) Summarize(ctx context.Context, now time.Time, window time.Duration, concurrency int) (*Summary, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	branches, err := e.store.ListBranchesFilter(ctx, storage.BranchFilter{IncludeDeleted: true})
+	if err != nil {
+		return nil, fmt.Errorf("list branches: %w", err)
+	}
+
+	summary := &Summary{ByStatus: make(map[string]int)}
+	var live []*storage.Branch
+	for _, b := range branches {
+		summary.Branches++
+		switch {
+		case b.DeletedAt != nil:
+			summary.ByStatus["deleted"]++
+			continue
+		case b.Frozen:
+			summary.ByStatus["frozen"]++
+		default:
+			summary.ByStatus[b.Status]++
+		}
+		summary.DeltaSize += b.DeltaSize
+		if b.Name != "main" {
+			live = append(live, b)
+		}
+
+		if b.TTLSeconds != nil {
+			expiresAt := b.CreatedAt.Add(time.Duration(*b.TTLSeconds) * time.Second)
+			if !expiresAt.Before(now) && expiresAt.Before(now.Add(window)) {
+				summary.Expiring = append(summary.Expiring, ExpiringBranch{Name: b.Name, ExpiresAt: expiresAt})
+			}
+		}
+	}
+	slices.SortFunc(summary.Expiring, func(a, b ExpiringBranch) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+
+	sizes, err := e.countOverlays(ctx, live, concurrency)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sizes {
+		summary.OverlayRows += s.OverlayRows
+		summary.Tombstones += s.Tombstones
+	}
+
+	slices.SortStableFunc(sizes, func(a, b BranchSize) int {
+		return cmp.Compare(b.DeltaSize, a.DeltaSize)
+	})
+	summary.Largest = sizes[:min(len(sizes), summaryLargest)]
+	return summary, nil
+}
+
+// countOverlays counts the live rows and tombstones in each branch's
+// overlay tables, with up to concurrency branches counted at once. The
+// sizes are returned in the order of branches.
+func (e *Engine) countOverlays(ctx context.Context, branches []*storage.Branch, concurrency int) ([]BranchSize, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sizes := make([]BranchSize, len(branches))
+	work := make(chan int)
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for range min(concurrency, len(branches)) {
+		wg.Go(func() {
+			for i := range work {
+				if err := e.countOverlay(ctx, branches[i], &sizes[i]); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					mu.Unlock()
+				}
+			}
+		})
+	}
+
+feed:
+	for i := range branches {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return sizes, ctx.Err()
+}
+
+// countOverlay fills in size for one branch.
+func (e *Engine) countOverlay(ctx context.Context, b *storage.Branch, size *BranchSize) error {
+	size.Name = b.Name
+	size.DeltaSize = b.DeltaSize
+
+	tables, err := e.store.ListTrackedTables(ctx, b.Name, "")
+	if err != nil {
+		return fmt.Errorf("list tracked tables of %s: %w", b.Name, err)
+	}
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(b.Name)
+	for _, t := range tables {
+		rows, err := OverlayRowCount(ctx, pool, branchSchema, t.TableName)
+		if err != nil {
+			return fmt.Errorf("%s.%s on %s: %w", t.SourceSchema, t.TableName, b.Name, err)
+		}
+		tombstones, err := TombstoneCount(ctx, pool, branchSchema, t.TableName)
+		if err != nil {
+			return fmt.Errorf("%s.%s on %s: %w", t.SourceSchema, t.TableName, b.Name, err)
+		}
+		size.OverlayRows += rows
+		size.Tombstones += tombstones
+	}
+	return nil
+}
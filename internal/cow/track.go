@@ -0,0 +1,65 @@
+package cow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// AuditTrackAll is the audit log operation recorded by TrackAllTables.
+const AuditTrackAll = "track_all"
+
+// SetTrackAllOnCreate makes CreateBranch track every table in the public
+// schema (see TrackAllTables), so no table pays the cost of creating its
+// overlay on the branch's first write to it. Branch creation is slower in
+// proportion to the number of tables. Off by default.
+func (e *Engine) SetTrackAllOnCreate(enabled bool) {
+	e.trackAllOnCreate = enabled
+}
+
+// TrackAllTables creates overlay tables on a branch for every base table in
+// schema that doesn't have one yet, instead of waiting for the first write
+// to each table. rift's own _rift* tables are skipped.
+func (e *Engine) TrackAllTables(ctx context.Context, branchName, schema string) error {
+	if err := e.trackAllTables(ctx, branchName, schema); err != nil {
+		return err
+	}
+	e.audit(ctx, branchName, AuditTrackAll, map[string]any{"schema": schema})
+	return nil
+}
+
+func (e *Engine) trackAllTables(ctx context.Context, branchName, schema string) error {
+	if branchName == "main" {
+		return fmt.Errorf("main has no overlay to track tables in")
+	}
+	if schema == "_rift" || strings.HasPrefix(schema, "_rift_") {
+		return fmt.Errorf("schema %q is internal to rift", schema)
+	}
+	if err := e.checkWritable(ctx, branchName); err != nil {
+		return err
+	}
+
+	tables, err := ListSchemaTables(ctx, e.store.Pool(), schema)
+	if err != nil {
+		return err
+	}
+
+	var pkEntries []storage.PrimaryKeyColumn
+	for _, table := range tables {
+		if strings.HasPrefix(table, "_rift") {
+			continue
+		}
+		pks, err := e.trackSourceTable(ctx, branchName, schema, table)
+		if err != nil {
+			return err
+		}
+		pkEntries = append(pkEntries, pks...)
+	}
+
+	if err := e.store.BulkCachePrimaryKeys(ctx, pkEntries); err != nil {
+		return fmt.Errorf("cache PKs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package cow
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DriftKind identifies how an overlay table's schema differs from its source.
+type DriftKind string
+
+const (
+	DriftMissingColumn DriftKind = "missing_column" // column in source but not in overlay
+	DriftExtraColumn   DriftKind = "extra_column"   // column in overlay but not in source
+	DriftTypeMismatch  DriftKind = "type_mismatch"  // column types differ
+)
+
+// ValidationError describes a schema difference between an overlay table and
+// its source table.
+type ValidationError struct {
+	SourceSchema string
+	TableName    string
+	Column       string
+	Kind         DriftKind
+	SourceType   string
+	OverlayType  string
+
+	// Repairable is true when the drift can be fixed automatically by adding
+	// the missing column to the overlay (see Engine.RepairBranch).
+	Repairable bool
+}
+
+func (v ValidationError) Error() string {
+	table := v.SourceSchema + "." + v.TableName
+	switch v.Kind {
+	case DriftMissingColumn:
+		return fmt.Sprintf("%s: column %q (%s) is missing from the overlay", table, v.Column, v.SourceType)
+	case DriftExtraColumn:
+		return fmt.Sprintf("%s: overlay column %q (%s) does not exist in the source", table, v.Column, v.OverlayType)
+	case DriftTypeMismatch:
+		return fmt.Sprintf("%s: column %q is %s in the source but %s in the overlay", table, v.Column, v.SourceType, v.OverlayType)
+	}
+	return fmt.Sprintf("%s: column %q: %s", table, v.Column, v.Kind)
+}
+
+// compareColumns returns the drift between a source table and its overlay.
+// The overlay's _rift_tombstone and _rift_updated_at columns are ignored.
+func compareColumns(sourceSchema, tableName string, source, overlay []ColumnDef) []ValidationError {
+	ovrCols := make(map[string]ColumnDef, len(overlay))
+	for _, c := range overlay {
+		ovrCols[c.Name] = c
+	}
+	srcCols := make(map[string]bool, len(source))
+
+	var errs []ValidationError
+	for _, src := range source {
+		srcCols[src.Name] = true
+		ovr, ok := ovrCols[src.Name]
+		switch {
+		case !ok:
+			errs = append(errs, ValidationError{
+				SourceSchema: sourceSchema,
+				TableName:    tableName,
+				Column:       src.Name,
+				Kind:         DriftMissingColumn,
+				SourceType:   src.DataType,
+				Repairable:   true,
+			})
+		case ovr.DataType != src.DataType:
+			errs = append(errs, ValidationError{
+				SourceSchema: sourceSchema,
+				TableName:    tableName,
+				Column:       src.Name,
+				Kind:         DriftTypeMismatch,
+				SourceType:   src.DataType,
+				OverlayType:  ovr.DataType,
+			})
+		}
+	}
+
+	for _, ovr := range overlay {
+		if ovr.Name == "_rift_tombstone" || ovr.Name == "_rift_updated_at" || srcCols[ovr.Name] {
+			continue
+		}
+		errs = append(errs, ValidationError{
+			SourceSchema: sourceSchema,
+			TableName:    tableName,
+			Column:       ovr.Name,
+			Kind:         DriftExtraColumn,
+			OverlayType:  ovr.DataType,
+		})
+	}
+
+	return errs
+}
+
+// ValidateBranch compares the columns of each overlay table in a branch with
+// its source table and returns any drift found.
+func (e *Engine) ValidateBranch(ctx context.Context, branchName string) ([]ValidationError, error) {
+	if _, err := e.store.GetBranch(ctx, branchName); err != nil {
+		return nil, fmt.Errorf("get branch: %w", err)
+	}
+
+	tables, err := e.store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return nil, fmt.Errorf("list tracked tables: %w", err)
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+
+	var errs []ValidationError
+	for _, t := range tables {
+		srcCols, err := IntrospectTable(ctx, pool, t.SourceSchema, t.TableName)
+		if err != nil {
+			return nil, fmt.Errorf("introspect source %s.%s: %w", t.SourceSchema, t.TableName, err)
+		}
+		ovrCols, err := IntrospectTable(ctx, pool, branchSchema, t.TableName)
+		if err != nil {
+			return nil, fmt.Errorf("introspect overlay %s: %w", t.TableName, err)
+		}
+		errs = append(errs, compareColumns(t.SourceSchema, t.TableName, srcCols, ovrCols)...)
+	}
+
+	return errs, nil
+}
+
+// RepairBranch fixes the repairable drift in errs by adding missing columns to
+// the branch's overlay tables. It returns the number of columns added.
+func (e *Engine) RepairBranch(ctx context.Context, branchName string, errs []ValidationError) (int, error) {
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+
+	repaired := 0
+	for _, v := range errs {
+		if !v.Repairable {
+			continue
+		}
+		if err := addOverlayColumn(ctx, pool, branchSchema, v.SourceSchema, v.TableName, v.Column); err != nil {
+			return repaired, fmt.Errorf("repair %s.%s: %w", v.TableName, v.Column, err)
+		}
+		repaired++
+	}
+
+	if repaired > 0 {
+		e.audit(ctx, branchName, AuditRepair, map[string]any{"columns": repaired})
+	}
+	return repaired, nil
+}
+
+// addOverlayColumn adds a source column to an overlay table with the source's
+// full type (including length and precision) and a NULL default.
+func addOverlayColumn(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName, column string) error {
+	var colType string
+	err := pool.QueryRow(ctx,
+		`SELECT pg_catalog.format_type(a.atttypid, a.atttypmod)
+		 FROM pg_catalog.pg_attribute a
+		 WHERE a.attrelid = format('%I.%I', $1::text, $2::text)::regclass
+		   AND a.attname = $3 AND NOT a.attisdropped`,
+		sourceSchema, tableName, column).Scan(&colType)
+	if err != nil {
+		return fmt.Errorf("get column type: %w", err)
+	}
+
+	alterSQL := fmt.Sprintf("ALTER TABLE %s.%s ADD COLUMN IF NOT EXISTS %s %s DEFAULT NULL",
+		pgQuoteIdent(branchSchema), pgQuoteIdent(tableName), pgQuoteIdent(column), colType)
+	if _, err := pool.Exec(ctx, alterSQL); err != nil {
+		return fmt.Errorf("add column: %w", err)
+	}
+	return nil
+}
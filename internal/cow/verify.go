@@ -0,0 +1,180 @@
+package cow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrMergeVerification is returned by ExecuteMergeVerified when a table's
+// row count after the merge isn't what the branch's changes add up to.
+var ErrMergeVerification = errors.New("merge verification failed")
+
+// RowCountSnapshot maps tables, as "schema.table", to their row counts.
+type RowCountSnapshot map[string]int64
+
+// VerificationError is a table whose row count the merge changed by more or
+// less than the branch's inserts and deletes account for.
+type VerificationError struct {
+	Table  string // "schema.table"
+	Before int64
+	After  int64
+
+	// Inserts are the branch's new rows and Deletes its tombstones of rows
+	// the parent had; updates leave the count as it is.
+	Inserts int64
+	Deletes int64
+}
+
+// Expected returns the row count the merge should have left.
+func (v VerificationError) Expected() int64 {
+	return v.Before + v.Inserts - v.Deletes
+}
+
+func (v VerificationError) Error() string {
+	return fmt.Sprintf("%s has %d rows after the merge, expected %d (%d before, %d inserted, %d deleted)",
+		v.Table, v.After, v.Expected(), v.Before, v.Inserts, v.Deletes)
+}
+
+// VerificationResult is the outcome of VerifyMerge.
+type VerificationResult struct {
+	BranchName string
+
+	// Tables is the number of tables checked.
+	Tables int
+	Errors []VerificationError
+}
+
+// OK reports whether every table's row count matched.
+func (r *VerificationResult) OK() bool {
+	return len(r.Errors) == 0
+}
+
+// VerifyMerge checks the row counts of the tables merged from a branch,
+// taken before and after the merge statements ran, against the branch's
+// changes: each new row adds one, each deleted row removes one. Tables
+// missing from either snapshot aren't checked. The changes are read from the
+// parent's committed rows, so VerifyMerge must run before the merge commits,
+// as ExecuteMergeVerified does; rows others commit to the tables meanwhile
+// show up as discrepancies too.
+func (e *Engine) VerifyMerge(ctx context.Context, branchName string, premerge, postmerge RowCountSnapshot) (*VerificationResult, error) {
+	tables, err := e.store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return nil, fmt.Errorf("list tracked tables: %w", err)
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+	result := &VerificationResult{BranchName: branchName}
+	for _, t := range tables {
+		key := t.SourceSchema + "." + t.TableName
+		before, ok := premerge[key]
+		if !ok {
+			continue
+		}
+		after, ok := postmerge[key]
+		if !ok {
+			continue
+		}
+
+		pkCols, err := e.getPKColumns(ctx, t.SourceSchema, t.TableName, branchSchema)
+		if err != nil {
+			return nil, fmt.Errorf("get PKs for %s: %w", t.TableName, err)
+		}
+		inserts, deletes, err := countRowChanges(ctx, pool, branchSchema, t.SourceSchema, t.TableName, pkCols)
+		if err != nil {
+			return nil, fmt.Errorf("count changes to %s: %w", key, err)
+		}
+
+		result.Tables++
+		if after != before+inserts-deletes {
+			result.Errors = append(result.Errors, VerificationError{
+				Table: key, Before: before, After: after, Inserts: inserts, Deletes: deletes,
+			})
+		}
+	}
+	return result, nil
+}
+
+// ExecuteMergeVerified is ExecuteMerge with a row count check. In the
+// merge's transaction, it counts the rows of each merged table before and
+// after the merge statements and runs VerifyMerge. If a count is off, the
+// merge is rolled back and the error wraps ErrMergeVerification. The
+// verification result is returned in either case, and is nil when there
+// was nothing to merge or the merge failed before it ran.
+func (e *Engine) ExecuteMergeVerified(ctx context.Context, branchName string, only []string, timeout time.Duration) (*MergeResult, *VerificationResult, error) {
+	merges, err := e.GenerateMerge(ctx, branchName, only, MergeAll)
+	if err != nil {
+		return nil, nil, err
+	}
+	migrations, err := e.PendingMigrations(ctx, branchName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var premerge RowCountSnapshot
+	var verification *VerificationResult
+	check := &mergeCheck{
+		before: func(ctx context.Context, tx pgx.Tx) error {
+			var err error
+			premerge, err = countMergedRows(ctx, tx, merges)
+			return err
+		},
+		after: func(ctx context.Context, tx pgx.Tx) error {
+			postmerge, err := countMergedRows(ctx, tx, merges)
+			if err != nil {
+				return err
+			}
+			verification, err = e.VerifyMerge(ctx, branchName, premerge, postmerge)
+			if err != nil {
+				return fmt.Errorf("verify merge: %w", err)
+			}
+			if !verification.OK() {
+				return fmt.Errorf("%w: row counts of %d table(s) are off; transaction rolled back: %w",
+					ErrMergeVerification, len(verification.Errors), verification.Errors[0])
+			}
+			return nil
+		},
+	}
+	result, err := e.executeMerges(ctx, merges, migrations, timeout, check)
+	if err != nil {
+		return nil, verification, err
+	}
+
+	e.auditMerge(ctx, branchName, "parent", only, MergeAll, result, 0)
+	return result, verification, nil
+}
+
+// countMergedRows counts the rows of each table merges write to, in tx.
+func countMergedRows(ctx context.Context, tx pgx.Tx, merges []MergeSQL) (RowCountSnapshot, error) {
+	counts := make(RowCountSnapshot, len(merges))
+	for _, m := range merges {
+		var n int64
+		srcTable := pgQuoteIdent(m.SourceSchema) + "." + pgQuoteIdent(m.TableName)
+		if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM "+srcTable).Scan(&n); err != nil {
+			return nil, fmt.Errorf("count rows of %s.%s: %w", m.SourceSchema, m.TableName, err)
+		}
+		counts[m.SourceSchema+"."+m.TableName] = n
+	}
+	return counts, nil
+}
+
+// countRowChanges counts the overlay rows that change a source table's row
+// count when merged: rows the source doesn't have, and tombstones of rows it
+// does. Tombstones of rows only the branch ever had delete nothing.
+func countRowChanges(ctx context.Context, pool *pgxpool.Pool, branchSchema, sourceSchema, tableName string, pkCols []string) (inserts, deletes int64, err error) {
+	ovrTable := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(tableName)
+	srcTable := pgQuoteIdent(sourceSchema) + "." + pgQuoteIdent(tableName)
+	err = pool.QueryRow(ctx, fmt.Sprintf(
+		`SELECT COUNT(*) FILTER (WHERE NOT tombstone AND NOT in_source),
+		        COUNT(*) FILTER (WHERE tombstone AND in_source)
+		 FROM (SELECT ovr._rift_tombstone AS tombstone,
+		              EXISTS (SELECT 1 FROM %s src WHERE %s) AS in_source
+		       FROM %s ovr) c`,
+		srcTable, buildPKJoin("ovr", "src", pkCols), ovrTable)).Scan(&inserts, &deletes)
+	return inserts, deletes, err
+}
@@ -0,0 +1,187 @@
+package cow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// OverlayEvent is a write to a branch row, reported by WatchBranch.
+type OverlayEvent struct {
+	Time         time.Time
+	Operation    string // ChangeInsert, ChangeUpdate or ChangeDelete
+	SourceSchema string
+	Table        string
+	Key          []string // "column=value" for each primary key column
+}
+
+// WatchBranch polls the branch's overlay tables every interval and calls fn
+// for each row written since the watch started, until ctx is done. A
+// non-empty table ("users" or "billing.invoices") limits the watch to that
+// table; otherwise tables the branch first writes to during the watch are
+// picked up as well.
+//
+// Rows are reported by their state when polled: a row the branch inserted
+// is reported as an insert every time it is written, and a row written
+// several times between polls is reported once.
+func (e *Engine) WatchBranch(ctx context.Context, branchName, table string, interval time.Duration, fn func(OverlayEvent)) error {
+	if branchName == "main" {
+		return fmt.Errorf("main has no overlay to watch")
+	}
+	if _, err := e.store.GetBranch(ctx, branchName); err != nil {
+		return fmt.Errorf("get branch: %w", err)
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+	watched := make(map[QualifiedTable]*watchedTable)
+
+	// Tables present at the start are set up before the start time is taken,
+	// so adding the updated_at column to old overlays doesn't report every row.
+	if err := e.watchNewTables(ctx, branchName, table, watched); err != nil {
+		return err
+	}
+	var since time.Time
+	if err := pool.QueryRow(ctx, "SELECT clock_timestamp()").Scan(&since); err != nil {
+		return fmt.Errorf("read server time: %w", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if err := e.watchNewTables(ctx, branchName, table, watched); err != nil {
+			return watchErr(ctx, err)
+		}
+		for qt, w := range watched {
+			if w.lastSeen.IsZero() {
+				w.lastSeen = since
+			}
+			events, err := pollOverlayEvents(ctx, pool, branchSchema, qt, w)
+			if err != nil {
+				return watchErr(ctx, fmt.Errorf("poll %s.%s: %w", qt.Schema, qt.Name, err))
+			}
+			for _, ev := range events {
+				fn(ev)
+			}
+		}
+	}
+}
+
+// watchErr drops errors caused by the watch being stopped mid-poll.
+func watchErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+type watchedTable struct {
+	pkCols   []string
+	lastSeen time.Time
+}
+
+// watchNewTables adds the branch's tracked tables that match filter to
+// watched, making sure each has an updated_at column.
+func (e *Engine) watchNewTables(ctx context.Context, branchName, filter string, watched map[QualifiedTable]*watchedTable) error {
+	tables, err := e.store.ListTrackedTables(ctx, branchName, "")
+	if err != nil {
+		return fmt.Errorf("list tracked tables: %w", err)
+	}
+
+	pool := e.store.Pool()
+	branchSchema := e.store.BranchSchemaName(branchName)
+	for _, t := range tables {
+		qt := QualifiedTable{Schema: t.SourceSchema, Name: t.TableName}
+		if _, ok := watched[qt]; ok || !matchesTable(filter, qt) {
+			continue
+		}
+
+		pkCols, err := e.getPKColumns(ctx, t.SourceSchema, t.TableName, branchSchema)
+		if err != nil {
+			return fmt.Errorf("get PKs for %s: %w", t.TableName, err)
+		}
+		if err := AddUpdatedAtTracking(ctx, pool, branchSchema, t.TableName); err != nil {
+			return fmt.Errorf("%s.%s: %w", t.SourceSchema, t.TableName, err)
+		}
+		watched[qt] = &watchedTable{pkCols: pkCols}
+	}
+	return nil
+}
+
+// matchesTable reports whether qt is named by filter, which may be empty
+// (every table), a bare table name or schema.table.
+func matchesTable(filter string, qt QualifiedTable) bool {
+	if filter == "" {
+		return true
+	}
+	if schema, name, ok := strings.Cut(filter, "."); ok {
+		return schema == qt.Schema && name == qt.Name
+	}
+	return filter == qt.Name
+}
+
+// pollOverlayEvents returns the rows of one overlay table written after
+// w.lastSeen, oldest first, and advances w.lastSeen past them.
+func pollOverlayEvents(ctx context.Context, pool *pgxpool.Pool, branchSchema string, qt QualifiedTable, w *watchedTable) ([]OverlayEvent, error) {
+	ovrTable := pgQuoteIdent(branchSchema) + "." + pgQuoteIdent(qt.Name)
+	srcTable := pgQuoteIdent(qt.Schema) + "." + pgQuoteIdent(qt.Name)
+
+	rows, err := pool.Query(ctx, fmt.Sprintf(
+		`SELECT ovr._rift_updated_at, ovr._rift_tombstone,
+		        EXISTS (SELECT 1 FROM %s src WHERE %s), %s
+		 FROM %s ovr
+		 WHERE ovr._rift_updated_at > $1
+		 ORDER BY ovr._rift_updated_at`,
+		srcTable, buildPKJoin("src", "ovr", w.pkCols), qualifiedColumns("ovr", w.pkCols, "::text"), ovrTable),
+		w.lastSeen)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []OverlayEvent
+	for rows.Next() {
+		var ev OverlayEvent
+		var tombstone, inSource bool
+		keys := make([]*string, len(w.pkCols))
+		dest := []any{&ev.Time, &tombstone, &inSource}
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("scan overlay row: %w", err)
+		}
+
+		ev.SourceSchema, ev.Table = qt.Schema, qt.Name
+		switch {
+		case tombstone:
+			ev.Operation = ChangeDelete
+		case inSource:
+			ev.Operation = ChangeUpdate
+		default:
+			ev.Operation = ChangeInsert
+		}
+		for i, col := range w.pkCols {
+			ev.Key = append(ev.Key, col+"="+sqlText(keys[i]))
+		}
+		events = append(events, ev)
+		w.lastSeen = ev.Time
+	}
+	return events, rows.Err()
+}
+
+func sqlText(v *string) string {
+	if v == nil {
+		return "NULL"
+	}
+	return *v
+}
@@ -0,0 +1,40 @@
+// Package logging sets up the structured logger rift's server components
+// write to.
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Setup creates a logger writing to output and makes it slog's default.
+// level is "debug", "info", "warn" or "error"; anything else logs at info.
+// format "json" writes one JSON object per line, anything else key=value
+// text.
+func Setup(level, format string, output io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: ParseLevel(level)}
+	var handler slog.Handler
+	if strings.EqualFold(format, "json") {
+		handler = slog.NewJSONHandler(output, opts)
+	} else {
+		handler = slog.NewTextHandler(output, opts)
+	}
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	return logger
+}
+
+// ParseLevel returns the slog level named by level, or slog.LevelInfo if it
+// names none.
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
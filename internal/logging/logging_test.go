@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	var buf bytes.Buffer
+	logger := Setup("warn", "json", &buf)
+	logger.Info("hidden")
+	logger.Warn("shown", "branch", "dev")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("want a single JSON entry, got %q: %v", buf.String(), err)
+	}
+	if entry["msg"] != "shown" || entry["level"] != "WARN" || entry["branch"] != "dev" {
+		t.Errorf("entry = %v", entry)
+	}
+	if slog.Default() != logger {
+		t.Error("Setup did not make the logger slog's default")
+	}
+
+	buf.Reset()
+	Setup("debug", "text", &buf).Debug("query", "branch", "dev")
+	if got := buf.String(); !strings.Contains(got, "level=DEBUG msg=query branch=dev") {
+		t.Errorf("text entry = %q", got)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"debug":   slog.LevelDebug,
+		"INFO":    slog.LevelInfo,
+		"warn":    slog.LevelWarn,
+		"warning": slog.LevelWarn,
+		"error":   slog.LevelError,
+		"":        slog.LevelInfo,
+		"verbose": slog.LevelInfo,
+	}
+	for level, want := range tests {
+		if got := ParseLevel(level); got != want {
+			t.Errorf("ParseLevel(%q) = %v, want %v", level, got, want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// Package notify reports stale branches, those older than a threshold, to a
+// Slack channel or by email, so that forgotten branches get cleaned up.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/riftdata/rift/internal/cow"
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// Channels a report can be sent to.
+const (
+	ChannelSlack = "slack"
+	ChannelEmail = "email"
+)
+
+// DefaultOlderThan is the age past which a branch is reported unless
+// configured otherwise.
+const DefaultOlderThan = 7 * 24 * time.Hour
+
+// StaleBranch is a branch in a report.
+type StaleBranch struct {
+	Name      string        `json:"name"`
+	Parent    string        `json:"parent"`
+	CreatedAt time.Time     `json:"created_at"`
+	Age       time.Duration `json:"age"`
+
+	// Owner is the user the audit log records creating the branch, if any.
+	Owner string `json:"owner,omitempty"`
+
+	// URL is the branch's connection string through the proxy.
+	URL string `json:"url"`
+}
+
+// SMTPConfig is the mail server email reports are sent through.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string // empty sends without authentication
+	Password string
+	From     string
+	To       []string
+}
+
+// Notifier finds stale branches and sends a report of them.
+type Notifier struct {
+	Channel    string // ChannelSlack or ChannelEmail
+	WebhookURL string // Slack incoming webhook, for ChannelSlack
+	SMTP       SMTPConfig
+
+	// OlderThan is the age past which a branch is stale.
+	OlderThan time.Duration
+
+	// Interval is how often Run sends a report.
+	Interval time.Duration
+
+	// ConnURL, if set, returns a branch's connection string for the report.
+	ConnURL func(branchName string) string
+
+	client   *http.Client
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewNotifier creates a Notifier that sends reports to channel, reporting
+// branches older than olderThan (0 for DefaultOlderThan).
+func NewNotifier(channel string, olderThan time.Duration) *Notifier {
+	if olderThan <= 0 {
+		olderThan = DefaultOlderThan
+	}
+	return &Notifier{
+		Channel:   channel,
+		OlderThan: olderThan,
+		Interval:  24 * time.Hour,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		sendMail:  smtp.SendMail,
+	}
+}
+
+// Check reports whether the Notifier has what its channel needs to send.
+func (n *Notifier) Check() error {
+	switch n.Channel {
+	case ChannelSlack:
+		if n.WebhookURL == "" {
+			return fmt.Errorf("slack notifications need a webhook URL")
+		}
+	case ChannelEmail:
+		if n.SMTP.Host == "" || n.SMTP.From == "" || len(n.SMTP.To) == 0 {
+			return fmt.Errorf("email notifications need an SMTP host, a sender and at least one recipient")
+		}
+	default:
+		return fmt.Errorf("unknown notification channel %q: must be slack or email", n.Channel)
+	}
+	return nil
+}
+
+// Run sends a report every Interval until ctx is done. Failures are logged
+// and the next report is tried as usual.
+func (n *Notifier) Run(ctx context.Context, store storage.Store) {
+	ticker := time.NewTicker(n.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			stale, err := n.Notify(ctx, store, now)
+			if err != nil {
+				slog.Error("stale branch report failed", "channel", n.Channel, "error", err)
+				continue
+			}
+			if len(stale) > 0 {
+				slog.Info("sent stale branch report", "channel", n.Channel, "branches", len(stale))
+			}
+		}
+	}
+}
+
+// Notify sends a report of the branches stale at now, if there are any,
+// and returns them.
+func (n *Notifier) Notify(ctx context.Context, store storage.Store, now time.Time) ([]StaleBranch, error) {
+	stale, err := n.FindStale(ctx, store, now)
+	if err != nil || len(stale) == 0 {
+		return nil, err
+	}
+	if err := n.send(ctx, stale); err != nil {
+		return nil, err
+	}
+	return stale, nil
+}
+
+// FindStale returns the live branches other than main created more than
+// OlderThan before now, oldest first.
+func (n *Notifier) FindStale(ctx context.Context, store storage.Store, now time.Time) ([]StaleBranch, error) {
+	branches, err := store.ListBranches(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list branches: %w", err)
+	}
+
+	var stale []StaleBranch
+	for _, b := range branches {
+		age := now.Sub(b.CreatedAt)
+		if b.Name == "main" || b.DeletedAt != nil || age <= n.OlderThan {
+			continue
+		}
+		owner, err := branchOwner(ctx, store, b.Name)
+		if err != nil {
+			return nil, err
+		}
+		s := StaleBranch{Name: b.Name, Parent: b.Parent, CreatedAt: b.CreatedAt, Age: age, Owner: owner}
+		if n.ConnURL != nil {
+			s.URL = n.ConnURL(b.Name)
+		}
+		stale = append(stale, s)
+	}
+	slices.SortFunc(stale, func(a, b StaleBranch) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return stale, nil
+}
+
+// branchOwner returns who created a branch according to the audit log, or
+// "" if it doesn't say.
+func branchOwner(ctx context.Context, store storage.Store, branchName string) (string, error) {
+	entries, err := store.ListAudit(ctx, storage.AuditQuery{BranchName: branchName})
+	if err != nil {
+		return "", fmt.Errorf("list audit log of %s: %w", branchName, err)
+	}
+	// The latest creation, in case the name was used by a deleted branch
+	for _, e := range slices.Backward(entries) {
+		if e.Operation == cow.AuditCreate || e.Operation == cow.AuditClone {
+			return e.UserName, nil
+		}
+	}
+	return "", nil
+}
+
+func (n *Notifier) send(ctx context.Context, stale []StaleBranch) error {
+	switch n.Channel {
+	case ChannelSlack:
+		return n.sendSlack(ctx, stale)
+	case ChannelEmail:
+		return n.sendEmail(stale)
+	}
+	return fmt.Errorf("unknown notification channel %q: must be slack or email", n.Channel)
+}
+
+// slackMessage is the payload of a Slack incoming webhook.
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+func (n *Notifier) sendSlack(ctx context.Context, stale []StaleBranch) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s*\n", n.summary(stale))
+	for _, s := range stale {
+		fmt.Fprintf(&b, "• `%s` (from `%s`): %s old", s.Name, s.Parent, FormatAge(s.Age))
+		if s.Owner != "" {
+			fmt.Fprintf(&b, ", created by %s", s.Owner)
+		}
+		if s.URL != "" {
+			fmt.Fprintf(&b, "\n    `%s`", s.URL)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("Delete the ones no longer needed with `rift delete <branch>`.")
+
+	body, err := json.Marshal(slackMessage{Text: b.String()})
+	if err != nil {
+		return fmt.Errorf("encode slack message: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post to slack: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("post to slack: %s", resp.Status)
+	}
+	return nil
+}
+
+func (n *Notifier) sendEmail(stale []StaleBranch) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.SMTP.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.SMTP.To, ", "))
+	fmt.Fprintf(&b, "Subject: [rift] %s\r\n", n.summary(stale))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(&b, "%s:\r\n\r\n", n.summary(stale))
+	for _, s := range stale {
+		fmt.Fprintf(&b, "  %s (from %s): %s old", s.Name, s.Parent, FormatAge(s.Age))
+		if s.Owner != "" {
+			fmt.Fprintf(&b, ", created by %s", s.Owner)
+		}
+		b.WriteString("\r\n")
+		if s.URL != "" {
+			fmt.Fprintf(&b, "    %s\r\n", s.URL)
+		}
+	}
+	b.WriteString("\r\nDelete the ones no longer needed with 'rift delete <branch>'.\r\n")
+
+	port := n.SMTP.Port
+	if port == 0 {
+		port = 587
+	}
+	var auth smtp.Auth
+	if n.SMTP.Username != "" {
+		auth = smtp.PlainAuth("", n.SMTP.Username, n.SMTP.Password, n.SMTP.Host)
+	}
+	addr := net.JoinHostPort(n.SMTP.Host, strconv.Itoa(port))
+	if err := n.sendMail(addr, auth, n.SMTP.From, n.SMTP.To, []byte(b.String())); err != nil {
+		return fmt.Errorf("send email: %w", err)
+	}
+	return nil
+}
+
+// summary is the report's headline.
+func (n *Notifier) summary(stale []StaleBranch) string {
+	if len(stale) == 1 {
+		return fmt.Sprintf("1 rift branch is older than %s", FormatAge(n.OlderThan))
+	}
+	return fmt.Sprintf("%d rift branches are older than %s", len(stale), FormatAge(n.OlderThan))
+}
+
+// FormatAge renders an age in whole days once it is at least a day, and in
+// hours or minutes below that.
+func FormatAge(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	}
+}
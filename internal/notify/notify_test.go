@@ -0,0 +1,148 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riftdata/rift/internal/cow"
+	"github.com/riftdata/rift/internal/storage"
+	"github.com/riftdata/rift/internal/storage/mock"
+)
+
+var now = time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC)
+
+func newStore(t *testing.T) *mock.Store {
+	t.Helper()
+	ctx := context.Background()
+	store := mock.New()
+	for _, b := range []*storage.Branch{
+		{Name: "old", Parent: "main", CreatedAt: now.Add(-10 * 24 * time.Hour)},
+		{Name: "older", Parent: "old", CreatedAt: now.Add(-30 * 24 * time.Hour)},
+		{Name: "fresh", Parent: "main", CreatedAt: now.Add(-time.Hour)},
+	} {
+		if err := store.CreateBranch(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.RecordAudit(ctx, &storage.AuditEntry{BranchName: "old", Operation: cow.AuditCreate, UserName: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestFindStale(t *testing.T) {
+	n := NewNotifier(ChannelSlack, 0)
+	n.ConnURL = func(name string) string { return "postgres://localhost:6432/" + name }
+
+	stale, err := n.FindStale(context.Background(), newStore(t), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 2 || stale[0].Name != "older" || stale[1].Name != "old" {
+		t.Fatalf("stale = %+v, want older then old", stale)
+	}
+	if stale[1].Owner != "alice" || stale[0].Owner != "" {
+		t.Errorf("owners = %q, %q; want \"\", alice", stale[0].Owner, stale[1].Owner)
+	}
+	if stale[1].Age != 10*24*time.Hour || stale[1].URL != "postgres://localhost:6432/old" {
+		t.Errorf("old = %+v", stale[1])
+	}
+}
+
+func TestNotifySlack(t *testing.T) {
+	var got slackMessage
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	n := NewNotifier(ChannelSlack, 0)
+	n.WebhookURL = srv.URL
+	stale, err := n.Notify(context.Background(), newStore(t), now)
+	if err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if len(stale) != 2 || requests != 1 {
+		t.Fatalf("reported %d branches in %d requests, want 2 in 1", len(stale), requests)
+	}
+	for _, want := range []string{"2 rift branches are older than 7d", "`old` (from `main`): 10d old, created by alice", "`older`"} {
+		if !strings.Contains(got.Text, want) {
+			t.Errorf("message %q does not contain %q", got.Text, want)
+		}
+	}
+
+	// Nothing is sent when no branch is stale
+	n.OlderThan = 365 * 24 * time.Hour
+	if stale, err := n.Notify(context.Background(), newStore(t), now); err != nil || len(stale) != 0 || requests != 1 {
+		t.Errorf("Notify with no stale branches = %v, %v after %d requests; want nothing sent", stale, err, requests)
+	}
+}
+
+func TestNotifyEmail(t *testing.T) {
+	var gotAddr, gotFrom string
+	var gotTo []string
+	var gotMsg string
+	n := NewNotifier(ChannelEmail, 0)
+	n.SMTP = SMTPConfig{Host: "mail.example.com", From: "rift@example.com", To: []string{"dev@example.com"}}
+	n.sendMail = func(addr string, _ smtp.Auth, from string, to []string, msg []byte) error {
+		gotAddr, gotFrom, gotTo, gotMsg = addr, from, to, string(msg)
+		return nil
+	}
+
+	if _, err := n.Notify(context.Background(), newStore(t), now); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if gotAddr != "mail.example.com:587" || gotFrom != "rift@example.com" || len(gotTo) != 1 {
+		t.Errorf("sent to %s from %s for %v", gotAddr, gotFrom, gotTo)
+	}
+	for _, want := range []string{"Subject: [rift] 2 rift branches are older than 7d\r\n", "old (from main): 10d old, created by alice"} {
+		if !strings.Contains(gotMsg, want) {
+			t.Errorf("message %q does not contain %q", gotMsg, want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		n  Notifier
+		ok bool
+	}{
+		{Notifier{Channel: ChannelSlack, WebhookURL: "https://hooks.slack.com/x"}, true},
+		{Notifier{Channel: ChannelSlack}, false},
+		{Notifier{Channel: ChannelEmail, SMTP: SMTPConfig{Host: "h", From: "f", To: []string{"t"}}}, true},
+		{Notifier{Channel: ChannelEmail, SMTP: SMTPConfig{Host: "h", From: "f"}}, false},
+		{Notifier{Channel: "pager"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.n.Check(); (err == nil) != tt.ok {
+			t.Errorf("Check(%+v) = %v, want ok %v", tt.n, err, tt.ok)
+		}
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	tests := map[time.Duration]string{
+		30 * time.Minute:        "30m",
+		5 * time.Hour:           "5h",
+		7 * 24 * time.Hour:      "7d",
+		(7*24 + 23) * time.Hour: "7d",
+	}
+	for d, want := range tests {
+		if got := FormatAge(d); got != want {
+			t.Errorf("FormatAge(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+// CopyInfo describes a COPY ... FROM STDIN statement.
+type CopyInfo struct {
+	Table TableRef
+
+	// Columns is the column list, if any; empty means every column in
+	// table order.
+	Columns []string
+
+	// Format is "text", "csv", or "binary".
+	Format string
+
+	// Header is set when the first line of the data is a header to skip.
+	Header bool
+
+	// Delimiter, Null, Quote, and Escape are the COPY options, with
+	// Postgres's defaults for the format filled in.
+	Delimiter string
+	Null      string
+	Quote     string
+	Escape    string
+}
+
+// ExtractCopyInfo returns the details of a COPY ... FROM STDIN statement, or
+// nil for any other query (including COPY TO and COPY from a file or
+// program).
+func ExtractCopyInfo(pq *ParsedQuery) (*CopyInfo, error) {
+	if pq == nil || pq.Type != QueryUtility || pq.tree == nil || len(pq.tree.Stmts) == 0 {
+		return nil, nil
+	}
+
+	n, ok := pq.tree.Stmts[0].Stmt.GetNode().(*pg_query.Node_CopyStmt)
+	if !ok {
+		return nil, nil
+	}
+	stmt := n.CopyStmt
+	if !stmt.IsFrom || stmt.IsProgram || stmt.Filename != "" || stmt.Relation == nil {
+		return nil, nil
+	}
+
+	info := &CopyInfo{
+		Table:  TableRef{Schema: stmt.Relation.Schemaname, Name: stmt.Relation.Relname},
+		Format: "text",
+	}
+	for _, col := range stmt.Attlist {
+		info.Columns = append(info.Columns, col.GetString_().GetSval())
+	}
+
+	for _, opt := range stmt.Options {
+		def := opt.GetDefElem()
+		if def == nil {
+			continue
+		}
+		val := defElemString(def.Arg)
+		switch def.Defname {
+		case "format":
+			info.Format = strings.ToLower(val)
+		case "header":
+			info.Header = def.Arg == nil || !isFalse(val)
+		case "delimiter":
+			info.Delimiter = val
+		case "null":
+			info.Null = val
+		case "quote":
+			info.Quote = val
+		case "escape":
+			info.Escape = val
+		}
+	}
+
+	switch info.Format {
+	case "csv":
+		if info.Delimiter == "" {
+			info.Delimiter = ","
+		}
+		if info.Quote == "" {
+			info.Quote = `"`
+		}
+		if info.Escape == "" {
+			info.Escape = info.Quote
+		}
+	case "text":
+		if info.Delimiter == "" {
+			info.Delimiter = "\t"
+		}
+		if !hasOption(stmt.Options, "null") {
+			info.Null = `\N`
+		}
+	case "binary":
+	default:
+		return nil, fmt.Errorf("COPY format %q not recognized", info.Format)
+	}
+	return info, nil
+}
+
+// defElemString returns an option argument as a string. Booleans and
+// integers are returned in their SQL spelling.
+func defElemString(arg *pg_query.Node) string {
+	switch v := arg.GetNode().(type) {
+	case *pg_query.Node_String_:
+		return v.String_.Sval
+	case *pg_query.Node_Boolean:
+		if v.Boolean.Boolval {
+			return "true"
+		}
+		return "false"
+	case *pg_query.Node_Integer:
+		return fmt.Sprint(v.Integer.Ival)
+	}
+	return ""
+}
+
+func isFalse(val string) bool {
+	switch strings.ToLower(val) {
+	case "false", "off", "0", "no":
+		return true
+	}
+	return false
+}
+
+func hasOption(opts []*pg_query.Node, name string) bool {
+	for _, opt := range opts {
+		if def := opt.GetDefElem(); def != nil && def.Defname == name {
+			return true
+		}
+	}
+	return false
+}
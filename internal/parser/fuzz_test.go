@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzParse(f *testing.F) {
+	for _, sql := range []string{
+		"SELECT * FROM users WHERE id = 1",
+		"SELECT u.name, o.total FROM users u JOIN orders o ON o.user_id = u.id",
+		"INSERT INTO users (id, name) VALUES (1, 'Alice') RETURNING id",
+		"UPDATE users SET name = 'Bob' FROM orders WHERE orders.user_id = users.id",
+		"DELETE FROM users WHERE id = 1",
+		"CREATE TABLE t (id BIGINT PRIMARY KEY)",
+		"ALTER TABLE users ADD COLUMN email TEXT",
+		"COPY users (id, name) FROM STDIN",
+		"BEGIN; INSERT INTO t VALUES (1); COMMIT",
+		"SET search_path = app, public",
+		"LISTEN events",
+		"",
+		";",
+		"SELECT '\x00'",
+	} {
+		f.Add(sql)
+	}
+
+	f.Fuzz(func(t *testing.T, sql string) {
+		pq, err := Parse(sql)
+		if err != nil {
+			if !strings.HasPrefix(err.Error(), "parse sql: ") {
+				t.Fatalf("Parse(%q) returned an unwrapped error: %v", sql, err)
+			}
+			return
+		}
+		if pq.Original != sql {
+			t.Fatalf("Parse(%q).Original = %q", sql, pq.Original)
+		}
+
+		// Every statement that parses as a batch must parse on its own
+		stmts, err := ParseMulti(sql)
+		if err != nil {
+			t.Fatalf("ParseMulti(%q) failed after Parse succeeded: %v", sql, err)
+		}
+		for _, stmt := range stmts {
+			if stmt == nil {
+				t.Fatalf("ParseMulti(%q) returned a nil statement", sql)
+			}
+		}
+	})
+}
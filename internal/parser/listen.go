@@ -0,0 +1,47 @@
+package parser
+
+import (
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+// ListenKind identifies a LISTEN, UNLISTEN, or NOTIFY statement.
+type ListenKind int
+
+const (
+	ListenStart  ListenKind = iota // LISTEN channel
+	ListenStop                     // UNLISTEN channel / UNLISTEN *
+	ListenNotify                   // NOTIFY channel [, payload]
+)
+
+// ListenInfo describes an asynchronous notification statement.
+type ListenInfo struct {
+	Kind ListenKind
+
+	// Channel is the channel name. Empty for UNLISTEN *.
+	Channel string
+
+	// Payload is the NOTIFY payload, if any.
+	Payload string
+}
+
+// ExtractListenInfo returns the notification details for a LISTEN, UNLISTEN,
+// or NOTIFY statement, or nil for any other query.
+func ExtractListenInfo(pq *ParsedQuery) *ListenInfo {
+	if pq == nil || pq.Type != QueryUtility || pq.tree == nil || len(pq.tree.Stmts) == 0 {
+		return nil
+	}
+
+	switch n := pq.tree.Stmts[0].Stmt.GetNode().(type) {
+	case *pg_query.Node_ListenStmt:
+		return &ListenInfo{Kind: ListenStart, Channel: n.ListenStmt.Conditionname}
+	case *pg_query.Node_UnlistenStmt:
+		return &ListenInfo{Kind: ListenStop, Channel: n.UnlistenStmt.Conditionname}
+	case *pg_query.Node_NotifyStmt:
+		return &ListenInfo{
+			Kind:    ListenNotify,
+			Channel: n.NotifyStmt.Conditionname,
+			Payload: n.NotifyStmt.Payload,
+		}
+	}
+	return nil
+}
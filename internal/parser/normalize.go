@@ -0,0 +1,108 @@
+package parser
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// NormalizeSQL returns a canonical form of sql for cache keys and query
+// statistics: the statement is parsed and deparsed, which normalizes
+// whitespace, keyword case and redundant parentheses, with every string,
+// numeric and bit-string constant replaced by a $n placeholder. Placeholders
+// are numbered in source order after any the query already has, and the
+// constants they replace are returned in the same order. Constants in type
+// modifiers, such as varchar(10), and NULL and boolean constants are kept.
+//
+// Queries that differ only in their constants normalize to the same string,
+// so callers that need to tell them apart must use literals too.
+func NormalizeSQL(sql string) (normalized string, literals []string, err error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return "", nil, fmt.Errorf("parse: %w", err)
+	}
+
+	var consts []*pg_query.Node
+	var maxParam int32
+	walkNodes(tree.ProtoReflect(), func(n *pg_query.Node) {
+		switch {
+		case n.GetParamRef() != nil:
+			maxParam = max(maxParam, n.GetParamRef().Number)
+		case n.GetAConst() != nil:
+			if _, ok := constLiteral(n.GetAConst()); ok {
+				consts = append(consts, n)
+			}
+		}
+	})
+	slices.SortFunc(consts, func(a, b *pg_query.Node) int {
+		return cmp.Compare(a.GetAConst().Location, b.GetAConst().Location)
+	})
+
+	literals = make([]string, len(consts))
+	for i, n := range consts {
+		c := n.GetAConst()
+		literals[i], _ = constLiteral(c)
+		n.Node = &pg_query.Node_ParamRef{ParamRef: &pg_query.ParamRef{
+			Number:   maxParam + int32(i) + 1,
+			Location: c.Location,
+		}}
+	}
+
+	normalized, err = pg_query.Deparse(tree)
+	if err != nil {
+		return "", nil, fmt.Errorf("deparse: %w", err)
+	}
+	return normalized, literals, nil
+}
+
+// constLiteral returns the value of a string, numeric or bit-string
+// constant, and false for NULL and boolean constants.
+func constLiteral(c *pg_query.A_Const) (string, bool) {
+	if c.Isnull {
+		return "", false
+	}
+	switch v := c.Val.(type) {
+	case *pg_query.A_Const_Sval:
+		return v.Sval.Sval, true
+	case *pg_query.A_Const_Ival:
+		return strconv.FormatInt(int64(v.Ival.Ival), 10), true
+	case *pg_query.A_Const_Fval:
+		return v.Fval.Fval, true
+	case *pg_query.A_Const_Bsval:
+		return v.Bsval.Bsval, true
+	}
+	return "", false
+}
+
+// walkNodes calls visit for every constant and parameter reference in the
+// parse tree message m. Type names are not descended into, so the constants
+// of type modifiers are left alone.
+func walkNodes(m protoreflect.Message, visit func(*pg_query.Node)) {
+	switch msg := m.Interface().(type) {
+	case *pg_query.Node:
+		if msg.GetAConst() != nil || msg.GetParamRef() != nil {
+			visit(msg)
+			return
+		}
+	case *pg_query.TypeName:
+		return
+	}
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Message() == nil || fd.IsMap():
+		case fd.IsList():
+			list := v.List()
+			for i := range list.Len() {
+				walkNodes(list.Get(i).Message(), visit)
+			}
+		default:
+			walkNodes(v.Message(), visit)
+		}
+		return true
+	})
+}
@@ -1,10 +1,12 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
 	pg_query "github.com/pganalyze/pg_query_go/v6"
+	pgparser "github.com/pganalyze/pg_query_go/v6/parser"
 )
 
 // QueryType classifies the kind of SQL statement.
@@ -44,6 +46,10 @@ type TableRef struct {
 	Schema string
 	Name   string
 	Alias  string
+
+	// IsJoinSource marks a table from an UPDATE ... FROM clause. It is only
+	// read, so it never needs an overlay of its own.
+	IsJoinSource bool
 }
 
 // QualifiedName returns schema.table or just table if no schema.
@@ -77,6 +83,20 @@ type ParsedQuery struct {
 	// For INSERT: target table columns
 	TargetColumns []string
 
+	// Returning is the RETURNING list of an INSERT/UPDATE/DELETE, without
+	// the keyword (e.g. "id, created_at"). Empty if there is none.
+	Returning string
+
+	// returningStart is the offset of the RETURNING keyword in Original.
+	returningStart int
+
+	// selectWrites is set for a SELECT that locks rows (FOR UPDATE/SHARE)
+	// or creates a table (SELECT INTO).
+	selectWrites bool
+
+	// fromClause is the text of an UPDATE ... FROM clause, without the keyword.
+	fromClause string
+
 	// Raw parse tree for rewriting
 	tree *pg_query.ParseResult
 }
@@ -86,6 +106,104 @@ func (p *ParsedQuery) IsReadOnly() bool {
 	return p.Type == QuerySelect
 }
 
+// RunsOnStandby reports whether the query is a SELECT that a read-only hot
+// standby can run: one that neither locks rows nor creates a table. Calls to
+// functions that write are not detected.
+func (p *ParsedQuery) RunsOnStandby() bool {
+	return p.Type == QuerySelect && !p.selectWrites
+}
+
+// AllowedReadOnly reports whether the statement may run on a read-only
+// session: a SELECT that neither locks rows, creates a table nor has a
+// data-modifying WITH query; EXPLAIN, PREPARE or COPY ... TO STDOUT of such
+// a SELECT; EXPLAIN without ANALYZE; SHOW; SET and RESET of anything but
+// the read-only settings; EXECUTE, DEALLOCATE, DISCARD, LISTEN and UNLISTEN;
+// and transaction control that doesn't ask for READ WRITE. Everything else,
+// COPY FROM, TRUNCATE, GRANT, DO and CALL included, is refused. Calls to
+// functions that write are not detected.
+func (p *ParsedQuery) AllowedReadOnly() bool {
+	if p.tree == nil || len(p.tree.Stmts) == 0 || p.tree.Stmts[0].Stmt == nil {
+		return true
+	}
+	return allowedReadOnly(p.tree.Stmts[0].Stmt)
+}
+
+// readOnlySettings are the settings that would let a session write.
+var readOnlySettings = map[string]bool{
+	"transaction_read_only":         true,
+	"default_transaction_read_only": true,
+}
+
+func allowedReadOnly(stmt *pg_query.Node) bool {
+	switch n := stmt.Node.(type) {
+	case *pg_query.Node_SelectStmt:
+		sel := n.SelectStmt
+		return len(sel.LockingClause) == 0 && sel.IntoClause == nil && !modifiesData(sel.WithClause)
+	case *pg_query.Node_ExplainStmt:
+		return allowedReadOnly(n.ExplainStmt.Query) || !hasDefElem(n.ExplainStmt.Options, "analyze")
+	case *pg_query.Node_PrepareStmt:
+		return allowedReadOnly(n.PrepareStmt.Query)
+	case *pg_query.Node_CopyStmt:
+		c := n.CopyStmt
+		return !c.IsFrom && !c.IsProgram && c.Filename == "" && (c.Query == nil || allowedReadOnly(c.Query))
+	case *pg_query.Node_VariableShowStmt, *pg_query.Node_ExecuteStmt, *pg_query.Node_DeallocateStmt,
+		*pg_query.Node_DiscardStmt, *pg_query.Node_ListenStmt, *pg_query.Node_UnlistenStmt:
+		return true
+	case *pg_query.Node_VariableSetStmt:
+		// SET TRANSACTION and SET SESSION CHARACTERISTICS take options
+		return !readOnlySettings[strings.ToLower(n.VariableSetStmt.Name)] && !asksReadWrite(n.VariableSetStmt.Args)
+	case *pg_query.Node_TransactionStmt:
+		switch n.TransactionStmt.Kind {
+		case pg_query.TransactionStmtKind_TRANS_STMT_PREPARE,
+			pg_query.TransactionStmtKind_TRANS_STMT_COMMIT_PREPARED,
+			pg_query.TransactionStmtKind_TRANS_STMT_ROLLBACK_PREPARED:
+			return false
+		}
+		return !asksReadWrite(n.TransactionStmt.Options)
+	}
+	return false
+}
+
+// modifiesData reports whether a WITH clause has an INSERT, UPDATE, DELETE
+// or MERGE query. Postgres only allows those at the top level.
+func modifiesData(with *pg_query.WithClause) bool {
+	if with == nil {
+		return false
+	}
+	for _, node := range with.Ctes {
+		query := node.GetCommonTableExpr().GetCtequery()
+		if query == nil {
+			continue
+		}
+		if _, ok := query.Node.(*pg_query.Node_SelectStmt); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDefElem reports whether options has an option named name.
+func hasDefElem(options []*pg_query.Node, name string) bool {
+	for _, opt := range options {
+		if opt.GetDefElem().GetDefname() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// asksReadWrite reports whether transaction options include READ WRITE,
+// which the parser encodes as transaction_read_only set to 0.
+func asksReadWrite(options []*pg_query.Node) bool {
+	for _, opt := range options {
+		def := opt.GetDefElem()
+		if def.GetDefname() == "transaction_read_only" && def.GetArg().GetAConst().GetIval().GetIval() == 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // IsWrite returns true for INSERT/UPDATE/DELETE.
 func (p *ParsedQuery) IsWrite() bool {
 	return p.Type == QueryInsert || p.Type == QueryUpdate || p.Type == QueryDelete
@@ -101,6 +219,12 @@ func (p *ParsedQuery) IsUtility() bool {
 	return p.Type == QueryUtility
 }
 
+// IsSyntaxError reports whether err was caused by SQL that failed to parse.
+func IsSyntaxError(err error) bool {
+	var parseErr *pgparser.Error
+	return errors.As(err, &parseErr)
+}
+
 // Parse parses a SQL string and returns a ParsedQuery.
 func Parse(sql string) (*ParsedQuery, error) {
 	tree, err := pg_query.Parse(sql)
@@ -124,14 +248,50 @@ func Parse(sql string) (*ParsedQuery, error) {
 	}
 
 	classifyStatement(pq, stmt)
+	extractReturning(pq, tree.Stmts[0])
+	extractUpdateFrom(pq, tree.Stmts[0])
 
 	return pq, nil
 }
 
+// ParseMulti parses SQL that may hold several semicolon-separated statements,
+// as sent in a simple Query message, and returns one ParsedQuery per
+// statement. Each statement's Original is its own text, without the
+// separating semicolon.
+func ParseMulti(sql string) ([]*ParsedQuery, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse sql: %w", err)
+	}
+
+	queries := make([]*ParsedQuery, 0, len(tree.Stmts))
+	for _, raw := range tree.Stmts {
+		start := int(raw.StmtLocation)
+		end := len(sql)
+		if raw.StmtLen > 0 { // 0 means the rest of the string
+			end = start + int(raw.StmtLen)
+		}
+		text := strings.TrimSpace(sql[start:end])
+		if text == "" {
+			continue
+		}
+
+		// Parse each statement on its own, so that offsets into Original
+		// (e.g. of RETURNING) are relative to the statement's text.
+		pq, err := Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, pq)
+	}
+	return queries, nil
+}
+
 func classifyStatement(pq *ParsedQuery, stmt *pg_query.Node) {
 	switch n := stmt.Node.(type) {
 	case *pg_query.Node_SelectStmt:
 		pq.Type = QuerySelect
+		pq.selectWrites = len(n.SelectStmt.LockingClause) > 0 || n.SelectStmt.IntoClause != nil
 		extractSelectTables(pq, n.SelectStmt)
 
 	case *pg_query.Node_InsertStmt:
@@ -180,6 +340,52 @@ func classifyStatement(pq *ParsedQuery, stmt *pg_query.Node) {
 	}
 }
 
+// extractReturning records the RETURNING clause of a write statement. The
+// parse tree only gives the position of the first returned expression, so
+// the keyword is found by scanning back from there.
+func extractReturning(pq *ParsedQuery, raw *pg_query.RawStmt) {
+	var list []*pg_query.Node
+	switch n := raw.Stmt.Node.(type) {
+	case *pg_query.Node_InsertStmt:
+		list = n.InsertStmt.ReturningList
+	case *pg_query.Node_UpdateStmt:
+		list = n.UpdateStmt.ReturningList
+	case *pg_query.Node_DeleteStmt:
+		list = n.DeleteStmt.ReturningList
+	}
+	if len(list) == 0 {
+		return
+	}
+	rt, ok := list[0].Node.(*pg_query.Node_ResTarget)
+	if !ok || rt.ResTarget.Location < 0 {
+		return
+	}
+
+	end := len(pq.Original)
+	if raw.StmtLen > 0 {
+		end = int(raw.StmtLocation + raw.StmtLen)
+	}
+	loc := int(rt.ResTarget.Location)
+	if loc > end {
+		return
+	}
+	kw := strings.LastIndex(strings.ToUpper(pq.Original[:loc]), "RETURNING")
+	if kw == -1 {
+		return
+	}
+
+	pq.returningStart = kw
+	pq.Returning = strings.TrimRight(strings.TrimSpace(pq.Original[kw+len("RETURNING"):end]), ";")
+}
+
+// withoutReturning returns the original SQL with any RETURNING clause removed.
+func (p *ParsedQuery) withoutReturning() string {
+	if p.Returning == "" {
+		return p.Original
+	}
+	return strings.TrimSpace(p.Original[:p.returningStart])
+}
+
 func extractSelectTables(pq *ParsedQuery, sel *pg_query.SelectStmt) {
 	if sel == nil {
 		return
@@ -206,9 +412,68 @@ func extractUpdateTables(pq *ParsedQuery, upd *pg_query.UpdateStmt) {
 		return
 	}
 	extractRangeVarTable(pq, upd.Relation)
+	target := len(pq.Tables)
 	for _, from := range upd.FromClause {
 		extractTableFromNode(pq, from)
 	}
+	for i := target; i < len(pq.Tables); i++ {
+		pq.Tables[i].IsJoinSource = true
+	}
+}
+
+// extractUpdateFrom records the FROM clause of an UPDATE. It runs from the
+// first FROM item to the WHERE clause (or RETURNING, or the end).
+func extractUpdateFrom(pq *ParsedQuery, raw *pg_query.RawStmt) {
+	n, ok := raw.Stmt.Node.(*pg_query.Node_UpdateStmt)
+	if !ok || len(n.UpdateStmt.FromClause) == 0 {
+		return
+	}
+	loc := nodeLocation(n.UpdateStmt.FromClause[0])
+	if loc < 0 {
+		return
+	}
+
+	sql := pq.withoutReturning()
+	if raw.StmtLen > 0 && int(raw.StmtLocation+raw.StmtLen) < len(sql) {
+		sql = sql[:raw.StmtLocation+raw.StmtLen]
+	}
+	if loc >= len(sql) {
+		return
+	}
+	clause := sql[loc:]
+	if pos := indexKeyword(clause, "WHERE"); pos != -1 {
+		clause = clause[:pos]
+	}
+	pq.fromClause = strings.TrimRight(strings.TrimSpace(clause), ";")
+}
+
+// nodeLocation returns the source offset of a FROM item, or -1 if unknown.
+func nodeLocation(node *pg_query.Node) int {
+	switch n := node.Node.(type) {
+	case *pg_query.Node_RangeVar:
+		return int(n.RangeVar.Location)
+	case *pg_query.Node_JoinExpr:
+		return nodeLocation(n.JoinExpr.Larg)
+	}
+	return -1
+}
+
+// indexKeyword returns the offset of the first occurrence of an upper-case
+// keyword in sql as a whole word, ignoring case, or -1.
+func indexKeyword(sql, kw string) int {
+	upper := strings.ToUpper(sql)
+	for idx := 0; ; {
+		pos := strings.Index(upper[idx:], kw)
+		if pos == -1 {
+			return -1
+		}
+		start := idx + pos
+		end := start + len(kw)
+		if (start == 0 || !isIdentChar(upper[start-1])) && (end == len(upper) || !isIdentChar(upper[end])) {
+			return start
+		}
+		idx = end
+	}
 }
 
 func extractDeleteTables(pq *ParsedQuery, del *pg_query.DeleteStmt) {
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -45,6 +46,29 @@ func TestParseSelectJoin(t *testing.T) {
 	}
 }
 
+func TestRunsOnStandby(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{"SELECT * FROM users", true},
+		{"SELECT id FROM users UNION SELECT id FROM admins", true},
+		{"SELECT * FROM users WHERE id = 1 FOR UPDATE", false},
+		{"SELECT * FROM users FOR SHARE SKIP LOCKED", false},
+		{"SELECT * INTO users_copy FROM users", false},
+		{"INSERT INTO users (id) VALUES (1)", false},
+	}
+	for _, tt := range tests {
+		pq, err := Parse(tt.sql)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.sql, err)
+		}
+		if got := pq.RunsOnStandby(); got != tt.want {
+			t.Errorf("RunsOnStandby(%q) = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
 func TestParseInsert(t *testing.T) {
 	pq, err := Parse("INSERT INTO users (name, email) VALUES ('Alice', 'alice@example.com')")
 	if err != nil {
@@ -237,6 +261,109 @@ func TestRewriteSelect(t *testing.T) {
 	}
 }
 
+func TestRewriteSelectMaxRows(t *testing.T) {
+	pq, err := Parse("SELECT * FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configs := map[string]RewriteConfig{
+		"users": {
+			BranchSchema: "_rift_branch_dev",
+			SourceSchema: "public",
+			PKColumns:    []string{"id"},
+			MaxRows:      100,
+		},
+	}
+
+	result, err := RewriteForBranch(pq, configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(result.SQL, `(SELECT * FROM "_rift_branch_dev"."users" WHERE NOT _rift_tombstone ORDER BY "id" LIMIT 100)`) {
+		t.Errorf("expected limited overlay subquery, got:\n%s", result.SQL)
+	}
+	if len(result.Notices) != 1 {
+		t.Errorf("expected 1 notice, got %d", len(result.Notices))
+	}
+
+	// The overlay rows kept don't depend on the plan
+	cfg := configs["users"]
+	cfg.PKColumns = []string{"org_id", "id"}
+	configs["users"] = cfg
+	result, err = RewriteForBranch(pq, configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.SQL, `ORDER BY "org_id", "id" LIMIT 100)`) {
+		t.Errorf("expected the overlay ordered by its composite key, got:\n%s", result.SQL)
+	}
+
+	cfg.MaxRows = 0
+	configs["users"] = cfg
+	result, err = RewriteForBranch(pq, configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(result.SQL, "LIMIT") || len(result.Notices) != 0 {
+		t.Errorf("without a cap expected no LIMIT or notice, got %v:\n%s", result.Notices, result.SQL)
+	}
+}
+
+func TestRewriteSelectHashAntiJoin(t *testing.T) {
+	pq, err := Parse("SELECT * FROM orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configs := map[string]RewriteConfig{
+		"orders": {
+			BranchSchema: "_rift_branch_dev",
+			SourceSchema: "public",
+			PKColumns:    []string{"tenant_id", "id"},
+			CTEMode:      CTEHashAntiJoin,
+		},
+	}
+
+	result, err := RewriteForBranch(pq, configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`SELECT * FROM "_rift_branch_dev"."orders" WHERE NOT _rift_tombstone`,
+		`LEFT JOIN (SELECT "tenant_id", "id" FROM "_rift_branch_dev"."orders") ovr ON ovr."tenant_id" = src."tenant_id" AND ovr."id" = src."id"`,
+		`WHERE ovr."tenant_id" IS NULL`,
+	} {
+		if !strings.Contains(result.SQL, want) {
+			t.Errorf("expected %q in:\n%s", want, result.SQL)
+		}
+	}
+	if strings.Contains(result.SQL, "NOT EXISTS") {
+		t.Errorf("hash anti join rewrite should not use NOT EXISTS:\n%s", result.SQL)
+	}
+}
+
+func TestParseCTEMode(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    CTEMode
+		wantErr bool
+	}{
+		{"", CTEUnionAll, false},
+		{"union_all", CTEUnionAll, false},
+		{"hash_antijoin", CTEHashAntiJoin, false},
+		{"merge_join", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCTEMode(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseCTEMode(%q) = %q, %v; want %q, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
 func TestRewriteInsert(t *testing.T) {
 	pq, err := Parse("INSERT INTO users (name) VALUES ('Charlie')")
 	if err != nil {
@@ -267,6 +394,156 @@ func TestRewriteInsert(t *testing.T) {
 	}
 }
 
+func TestParseReturning(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"INSERT INTO users (name) VALUES ('Alice') RETURNING id, created_at", "id, created_at"},
+		{"UPDATE users SET name = 'x' WHERE id = 1\nreturning *;", "*"},
+		{"DELETE FROM users WHERE id = 1 RETURNING users.id AS deleted_id", "users.id AS deleted_id"},
+		{"INSERT INTO users (name) VALUES ('returning')", ""},
+		{"SELECT 1", ""},
+	}
+	for _, tt := range tests {
+		pq, err := Parse(tt.sql)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.sql, err)
+		}
+		if pq.Returning != tt.want {
+			t.Errorf("Parse(%q).Returning = %q, want %q", tt.sql, pq.Returning, tt.want)
+		}
+	}
+}
+
+func TestRewriteReturning(t *testing.T) {
+	configs := map[string]RewriteConfig{
+		"users": {
+			BranchSchema: "_rift_branch_dev",
+			SourceSchema: "public",
+			PKColumns:    []string{"id"},
+		},
+	}
+
+	tests := []struct {
+		sql        string
+		wantSuffix string
+	}{
+		{
+			"INSERT INTO users (name) VALUES ('Alice') RETURNING id, created_at;",
+			"ON CONFLICT (\"id\") DO UPDATE SET \"name\" = EXCLUDED.\"name\", _rift_tombstone = false\nRETURNING id, created_at",
+		},
+		{
+			"UPDATE users SET name = 'Bob' WHERE id = 1 RETURNING id, name",
+			"SET name = 'Bob' WHERE (id = 1) AND NOT _rift_branch_dev.users._rift_tombstone RETURNING id, name",
+		},
+		{
+			"DELETE FROM users WHERE id = 1 RETURNING users.id",
+			"SET _rift_tombstone = true WHERE (id = 1) AND NOT _rift_tombstone RETURNING id",
+		},
+	}
+	for _, tt := range tests {
+		pq, err := Parse(tt.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := RewriteForBranch(pq, configs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(result.SQL, tt.wantSuffix) {
+			t.Errorf("rewrite of %q:\n%s\nwant suffix:\n%s", tt.sql, result.SQL, tt.wantSuffix)
+		}
+		if strings.Count(strings.ToUpper(result.SQL), "RETURNING") != 1 {
+			t.Errorf("rewrite of %q should have exactly one RETURNING clause:\n%s", tt.sql, result.SQL)
+		}
+		if _, err := Parse(result.SQL); err != nil {
+			t.Errorf("rewrite of %q is not valid SQL: %v", tt.sql, err)
+		}
+	}
+}
+
+func TestParseUpdateFrom(t *testing.T) {
+	pq, err := Parse("UPDATE users u SET name = a.name FROM accounts a JOIN plans p ON p.id = a.plan_id\nWHERE u.id = a.user_id RETURNING u.id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pq.Tables) != 3 {
+		t.Fatalf("expected 3 tables, got %+v", pq.Tables)
+	}
+	if pq.Tables[0].Name != "users" || pq.Tables[0].IsJoinSource {
+		t.Errorf("target should not be a join source: %+v", pq.Tables[0])
+	}
+	for _, tbl := range pq.Tables[1:] {
+		if !tbl.IsJoinSource {
+			t.Errorf("expected %s to be a join source", tbl.Name)
+		}
+	}
+	if want := "accounts a JOIN plans p ON p.id = a.plan_id"; pq.fromClause != want {
+		t.Errorf("fromClause = %q, want %q", pq.fromClause, want)
+	}
+}
+
+func TestRewriteUpdateFrom(t *testing.T) {
+	configs := map[string]RewriteConfig{
+		"users": {
+			BranchSchema: "_rift_branch_dev",
+			SourceSchema: "public",
+			PKColumns:    []string{"id"},
+		},
+	}
+	pq, err := Parse("UPDATE users SET name = a.name FROM accounts a WHERE users.id = a.user_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := RewriteForBranch(pq, configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.SQL, "AND EXISTS (SELECT 1 FROM accounts a WHERE src.id = a.user_id)") {
+		t.Errorf("copy step should join to the FROM clause:\n%s", result.SQL)
+	}
+	if !strings.HasSuffix(result.SQL, "UPDATE _rift_branch_dev.users SET name = a.name FROM accounts a WHERE (_rift_branch_dev.users.id = a.user_id) AND NOT _rift_branch_dev.users._rift_tombstone") {
+		t.Errorf("unexpected update step:\n%s", result.SQL)
+	}
+
+	// A join source with branch changes is read through its merged view.
+	configs["accounts"] = RewriteConfig{
+		BranchSchema: "_rift_branch_dev",
+		SourceSchema: "public",
+		PKColumns:    []string{"id"},
+	}
+	result, err = RewriteForBranch(pq, configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range strings.Split(result.SQL, ";\n") {
+		if !strings.HasPrefix(stmt, `WITH "_rift_merged_accounts" AS (`) {
+			t.Errorf("statement should read accounts through the merged view:\n%s", stmt)
+		}
+		if !strings.Contains(stmt, "FROM _rift_merged_accounts a") {
+			t.Errorf("statement should reference the merged view:\n%s", stmt)
+		}
+	}
+}
+
+func TestRequalifyWhereForAlias(t *testing.T) {
+	tests := []struct {
+		where string
+		want  string
+	}{
+		{"users.id = 1", "src.id = 1"},
+		{"u.id = old_users.id", "src.id = old_users.id"},
+		{"public.users.id = a.user_id", "src.id = a.user_id"},
+	}
+	for _, tt := range tests {
+		if got := requalifyWhereForAlias(tt.where, "src", "users", "u", "public.users"); got != tt.want {
+			t.Errorf("requalifyWhereForAlias(%q) = %q, want %q", tt.where, got, tt.want)
+		}
+	}
+}
+
 func TestRewriteDelete(t *testing.T) {
 	pq, err := Parse("DELETE FROM users WHERE id = 1")
 	if err != nil {
@@ -297,6 +574,105 @@ func TestRewriteDelete(t *testing.T) {
 	}
 }
 
+// Rows the branch deleted are tombstones in the overlay; a later UPDATE or
+// DELETE must neither change nor return them.
+func TestRewriteSkipsTombstones(t *testing.T) {
+	configs := map[string]RewriteConfig{
+		"users": {BranchSchema: "_rift_branch_dev", SourceSchema: "public", PKColumns: []string{"id"}},
+	}
+	tests := []struct {
+		sql        string
+		wantSuffix string
+	}{
+		{"DELETE FROM users", `UPDATE "_rift_branch_dev"."users" SET _rift_tombstone = true WHERE NOT _rift_tombstone`},
+		{"DELETE FROM users WHERE id = 1 OR id = 2 RETURNING *", "WHERE (id = 1 OR id = 2) AND NOT _rift_tombstone RETURNING *"},
+		{"UPDATE users SET name = 'x'", "UPDATE _rift_branch_dev.users SET name = 'x' WHERE NOT _rift_branch_dev.users._rift_tombstone"},
+		{"UPDATE users u SET name = 'x' WHERE u.id = 1 OR u.id = 2 RETURNING u.id;", "WHERE (u.id = 1 OR u.id = 2) AND NOT u._rift_tombstone RETURNING u.id"},
+	}
+	for _, tt := range tests {
+		pq, err := Parse(tt.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := RewriteForBranch(pq, configs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(result.SQL, tt.wantSuffix) {
+			t.Errorf("rewrite of %q:\n%s\nwant suffix:\n%s", tt.sql, result.SQL, tt.wantSuffix)
+		}
+		if _, err := ParseMulti(result.SQL); err != nil {
+			t.Errorf("rewrite of %q is not valid SQL: %v", tt.sql, err)
+		}
+	}
+}
+
+func TestRewriteCreateIndex(t *testing.T) {
+	configs := map[string]RewriteConfig{
+		"users": {BranchSchema: "_rift_branch_dev", SourceSchema: "public", PKColumns: []string{"id"}},
+	}
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"CREATE INDEX users_email ON users (email)",
+			`CREATE INDEX users_email ON "_rift_branch_dev"."users" (email)`},
+		{"CREATE UNIQUE INDEX IF NOT EXISTS users ON public.users USING btree (users)",
+			`CREATE UNIQUE INDEX IF NOT EXISTS users ON "_rift_branch_dev"."users" USING btree (users)`},
+		{`CREATE INDEX CONCURRENTLY ON ONLY "public"."users"(lower(email))`,
+			`CREATE INDEX CONCURRENTLY ON ONLY "_rift_branch_dev"."users"(lower(email))`},
+	}
+	for _, tt := range tests {
+		pq, err := Parse(tt.sql)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.sql, err)
+		}
+		result, err := RewriteForBranch(pq, configs)
+		if err != nil {
+			t.Fatalf("RewriteForBranch(%q): %v", tt.sql, err)
+		}
+		if result.SQL != tt.want {
+			t.Errorf("RewriteForBranch(%q) = %q, want %q", tt.sql, result.SQL, tt.want)
+		}
+		if !result.NeedsOverlay || result.TableName != "users" {
+			t.Errorf("RewriteForBranch(%q): NeedsOverlay=%v TableName=%q, want true, users",
+				tt.sql, result.NeedsOverlay, result.TableName)
+		}
+	}
+}
+
+func TestRewriteDropIndex(t *testing.T) {
+	pq, err := Parse("DROP INDEX IF EXISTS users_email, public.orders_total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pq.DDLType != DDLDropIndex {
+		t.Fatalf("DDLType = %v, want DDLDropIndex", pq.DDLType)
+	}
+
+	configs := map[string]RewriteConfig{
+		"users_email":  {BranchSchema: "_rift_branch_dev"},
+		"orders_total": {BranchSchema: "_rift_branch_dev", SourceSchema: "public"},
+	}
+	result, err := RewriteForBranch(pq, configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `DROP INDEX IF EXISTS "_rift_branch_dev"."users_email", "_rift_branch_dev"."orders_total"`
+	if result.SQL != want {
+		t.Errorf("SQL = %q, want %q", result.SQL, want)
+	}
+
+	// Without a resolved schema the statement passes through unchanged.
+	result, err = RewriteForBranch(pq, map[string]RewriteConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.IsPassthrough || result.SQL != pq.Original {
+		t.Errorf("expected passthrough without configs, got %q", result.SQL)
+	}
+}
+
 func TestRewritePassthroughUtility(t *testing.T) {
 	pq, err := Parse("SET search_path TO public")
 	if err != nil {
@@ -408,3 +784,222 @@ func TestQueryTypeString(t *testing.T) {
 		}
 	}
 }
+
+func TestExtractSetInfo(t *testing.T) {
+	tests := []struct {
+		sql   string
+		name  string
+		value string
+		local bool
+		reset bool
+	}{
+		{"SET search_path TO app, public", "search_path", "app, public", false, false},
+		{"SET application_name = 'worker'", "application_name", "worker", false, false},
+		{"SET TIME ZONE 'UTC'", "timezone", "UTC", false, false},
+		{"SET LOCAL search_path = app", "search_path", "app", true, false},
+		{"RESET application_name", "application_name", "", false, true},
+		{"SET search_path TO DEFAULT", "search_path", "", false, true},
+		{"RESET ALL", "", "", false, true},
+	}
+
+	for _, tt := range tests {
+		pq, err := Parse(tt.sql)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.sql, err)
+		}
+		info := ExtractSetInfo(pq)
+		if info == nil {
+			t.Fatalf("ExtractSetInfo(%q) returned nil", tt.sql)
+		}
+		if info.Name != tt.name || info.Value != tt.value || info.Local != tt.local || info.Reset != tt.reset {
+			t.Errorf("ExtractSetInfo(%q) = %+v", tt.sql, *info)
+		}
+	}
+
+	pq, err := Parse("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ExtractSetInfo(pq) != nil {
+		t.Error("expected nil for non-SET statement")
+	}
+}
+
+func TestSplitSearchPath(t *testing.T) {
+	got := SplitSearchPath(`"$user", public , "My""Schema"`)
+	want := []string{"$user", "public", `My"Schema`}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("element %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+
+	if SplitSearchPath("") != nil {
+		t.Error("expected nil for empty search_path")
+	}
+}
+
+func TestExtractListenInfo(t *testing.T) {
+	tests := []struct {
+		sql     string
+		kind    ListenKind
+		channel string
+		payload string
+	}{
+		{"LISTEN orders", ListenStart, "orders", ""},
+		{"UNLISTEN orders", ListenStop, "orders", ""},
+		{"UNLISTEN *", ListenStop, "", ""},
+		{"NOTIFY orders, 'created'", ListenNotify, "orders", "created"},
+	}
+
+	for _, tt := range tests {
+		pq, err := Parse(tt.sql)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.sql, err)
+		}
+		info := ExtractListenInfo(pq)
+		if info == nil {
+			t.Fatalf("ExtractListenInfo(%q) returned nil", tt.sql)
+		}
+		if info.Kind != tt.kind || info.Channel != tt.channel || info.Payload != tt.payload {
+			t.Errorf("ExtractListenInfo(%q) = %+v", tt.sql, *info)
+		}
+	}
+}
+
+func TestExtractCopyInfo(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want *CopyInfo
+	}{
+		{
+			"COPY users FROM STDIN WITH (FORMAT CSV, HEADER)",
+			&CopyInfo{Table: TableRef{Name: "users"}, Format: "csv", Header: true, Delimiter: ",", Quote: `"`, Escape: `"`},
+		},
+		{
+			"COPY app.users (id, name) FROM STDIN WITH (FORMAT csv, NULL 'NA', DELIMITER ';', ESCAPE '\\')",
+			&CopyInfo{Table: TableRef{Schema: "app", Name: "users"}, Columns: []string{"id", "name"},
+				Format: "csv", Null: "NA", Delimiter: ";", Quote: `"`, Escape: `\`},
+		},
+		{
+			"COPY users FROM STDIN CSV HEADER",
+			&CopyInfo{Table: TableRef{Name: "users"}, Format: "csv", Header: true, Delimiter: ",", Quote: `"`, Escape: `"`},
+		},
+		{
+			"COPY users FROM STDIN WITH (FORMAT csv, HEADER false)",
+			&CopyInfo{Table: TableRef{Name: "users"}, Format: "csv", Delimiter: ",", Quote: `"`, Escape: `"`},
+		},
+		{
+			"COPY users FROM STDIN",
+			&CopyInfo{Table: TableRef{Name: "users"}, Format: "text", Delimiter: "\t", Null: `\N`},
+		},
+		{"COPY users TO STDOUT", nil},
+		{"COPY users FROM '/tmp/users.csv'", nil},
+		{"SET search_path = app", nil},
+	}
+
+	for _, tt := range tests {
+		pq, err := Parse(tt.sql)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.sql, err)
+		}
+		info, err := ExtractCopyInfo(pq)
+		if err != nil {
+			t.Fatalf("ExtractCopyInfo(%q): %v", tt.sql, err)
+		}
+		if !reflect.DeepEqual(info, tt.want) {
+			t.Errorf("ExtractCopyInfo(%q) = %+v, want %+v", tt.sql, info, tt.want)
+		}
+	}
+}
+
+func TestExtractCopyInfoUnknownFormat(t *testing.T) {
+	pq, err := Parse("COPY users FROM STDIN WITH (FORMAT xml)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExtractCopyInfo(pq); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestParseMulti(t *testing.T) {
+	stmts, err := ParseMulti("BEGIN; INSERT INTO t VALUES (1) RETURNING id;\n SELECT ';' FROM t; COMMIT;")
+	if err != nil {
+		t.Fatalf("ParseMulti: %v", err)
+	}
+
+	want := []struct {
+		sql string
+		typ QueryType
+	}{
+		{"BEGIN", QueryUtility},
+		{"INSERT INTO t VALUES (1) RETURNING id", QueryInsert},
+		{"SELECT ';' FROM t", QuerySelect},
+		{"COMMIT", QueryUtility},
+	}
+	if len(stmts) != len(want) {
+		t.Fatalf("got %d statements, want %d", len(stmts), len(want))
+	}
+	for i, w := range want {
+		if stmts[i].Original != w.sql || stmts[i].Type != w.typ {
+			t.Errorf("statement %d = %q (%v), want %q (%v)", i, stmts[i].Original, stmts[i].Type, w.sql, w.typ)
+		}
+	}
+	if stmts[1].Returning != "id" {
+		t.Errorf("Returning = %q, want %q", stmts[1].Returning, "id")
+	}
+
+	if stmts, err := ParseMulti("SELECT 1"); err != nil || len(stmts) != 1 {
+		t.Errorf("single statement: got %d statements, %v", len(stmts), err)
+	}
+	if _, err := ParseMulti("SELECT 1; SELEC 2"); !IsSyntaxError(err) {
+		t.Errorf("syntax error: got %v", err)
+	}
+}
+
+func TestNormalizeSQL(t *testing.T) {
+	a, litA, err := NormalizeSQL("select  *\n  FROM users\tWHERE id = 42 and name='bob'")
+	if err != nil {
+		t.Fatalf("NormalizeSQL: %v", err)
+	}
+	b, litB, err := NormalizeSQL("SELECT * FROM users WHERE (id = 42) AND name = 'bob'")
+	if err != nil {
+		t.Fatalf("NormalizeSQL: %v", err)
+	}
+	if want := "SELECT * FROM users WHERE id = $1 AND name = $2"; a != want || b != want {
+		t.Errorf("normalized = %q and %q, want %q", a, b, want)
+	}
+	if want := []string{"42", "bob"}; !reflect.DeepEqual(litA, want) || !reflect.DeepEqual(litB, want) {
+		t.Errorf("literals = %q and %q, want %q", litA, litB, want)
+	}
+
+	tests := []struct {
+		sql      string
+		want     string
+		literals []string
+	}{
+		{"SELECT * FROM t WHERE a = -5 AND b = $1", "SELECT * FROM t WHERE a = $2 AND b = $1", []string{"-5"}},
+		{"SELECT a::varchar(10) FROM t WHERE b IS NULL AND c = true LIMIT 10",
+			"SELECT a::varchar(10) FROM t WHERE b IS NULL AND c = true LIMIT $1", []string{"10"}},
+		{"INSERT INTO t (a, b) VALUES (1.5, 'x'), (2, 'y')",
+			"INSERT INTO t (a, b) VALUES ($1, $2), ($3, $4)", []string{"1.5", "x", "2", "y"}},
+	}
+	for _, tt := range tests {
+		got, literals, err := NormalizeSQL(tt.sql)
+		if err != nil {
+			t.Errorf("NormalizeSQL(%q): %v", tt.sql, err)
+			continue
+		}
+		if got != tt.want || !reflect.DeepEqual(literals, tt.literals) {
+			t.Errorf("NormalizeSQL(%q) = %q, %q; want %q, %q", tt.sql, got, literals, tt.want, tt.literals)
+		}
+	}
+
+	if _, _, err := NormalizeSQL("SELEC 1"); err == nil {
+		t.Error("expected error for invalid SQL")
+	}
+}
@@ -10,6 +10,34 @@ type RewriteConfig struct {
 	BranchSchema string   // e.g. "_rift_branch_dev"
 	SourceSchema string   // e.g. "public"
 	PKColumns    []string // primary key columns of the target table
+	MaxRows      int      // cap on overlay rows read by merged views; 0 means unlimited
+	CTEMode      CTEMode  // how SELECT rewrites exclude changed source rows; "" means CTEUnionAll
+}
+
+// CTEMode selects how the merged-table CTE of a SELECT rewrite leaves out the
+// source rows a branch has changed.
+type CTEMode string
+
+const (
+	// CTEUnionAll filters source rows with a correlated NOT EXISTS against
+	// the overlay.
+	CTEUnionAll CTEMode = "union_all"
+
+	// CTEHashAntiJoin left-joins the source to the overlay's primary keys and
+	// keeps the rows without a match, which the planner runs as a hash anti
+	// join: one pass over a small overlay instead of a probe per source row.
+	CTEHashAntiJoin CTEMode = "hash_antijoin"
+)
+
+// ParseCTEMode parses a cow.cte_mode setting.
+func ParseCTEMode(s string) (CTEMode, error) {
+	switch mode := CTEMode(s); mode {
+	case CTEUnionAll, CTEHashAntiJoin:
+		return mode, nil
+	case "":
+		return CTEUnionAll, nil
+	}
+	return "", fmt.Errorf("invalid CTE mode %q: must be %s or %s", s, CTEUnionAll, CTEHashAntiJoin)
 }
 
 // RewriteResult holds the rewritten SQL and metadata.
@@ -18,6 +46,7 @@ type RewriteResult struct {
 	IsPassthrough bool
 	NeedsOverlay  bool
 	TableName     string
+	Notices       []string // messages to report to the client as NOTICEs
 }
 
 // RewriteForBranch rewrites a parsed query for execution against a branch overlay.
@@ -58,6 +87,16 @@ func RewriteForBranch(pq *ParsedQuery, configs map[string]RewriteConfig) (*Rewri
 //	  )
 //	)
 //	SELECT * FROM _rift_merged_users WHERE id = 1
+//
+// When cfg.MaxRows is set, the overlay branch of the UNION becomes
+// (SELECT * FROM _rift_branch_dev.users WHERE NOT _rift_tombstone ORDER BY id
+// LIMIT n). The source branch still leaves out every row the overlay has, so
+// the rows past the cap are missing from the results altogether.
+// With CTEHashAntiJoin, the source branch becomes
+//
+//	SELECT src.* FROM public.users src
+//	LEFT JOIN (SELECT id FROM _rift_branch_dev.users) ovr ON ovr.id = src.id
+//	WHERE ovr.id IS NULL
 func rewriteSelect(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteResult, error) {
 	if len(pq.Tables) == 0 {
 		return &RewriteResult{SQL: pq.Original, IsPassthrough: true}, nil
@@ -65,6 +104,7 @@ func rewriteSelect(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteR
 
 	sql := pq.Original
 	var ctes []string
+	var notices []string
 	hasOverlay := false
 
 	for _, tbl := range pq.Tables {
@@ -78,26 +118,10 @@ func rewriteSelect(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteR
 		hasOverlay = true
 
 		mergedName := "_rift_merged_" + tbl.Name
-		srcTable := qualifiedTable(cfg.SourceSchema, tbl.Name)
-		ovrTable := qualifiedTable(cfg.BranchSchema, tbl.Name)
-
-		pkJoin := buildPKJoin("ovr", "src", cfg.PKColumns)
-
-		cte := fmt.Sprintf(
-			`%s AS (
-  SELECT * FROM %s WHERE NOT _rift_tombstone
-  UNION ALL
-  SELECT src.* FROM %s src
-  WHERE NOT EXISTS (
-    SELECT 1 FROM %s ovr WHERE %s
-  )
-)`,
-			pgQuoteIdent(mergedName),
-			ovrTable,
-			srcTable,
-			ovrTable,
-			pkJoin,
-		)
+		cte, notice := mergedTableCTE(tbl.Name, cfg)
+		if notice != "" {
+			notices = append(notices, notice)
+		}
 		ctes = append(ctes, cte)
 
 		// Replace table references in the original query
@@ -113,15 +137,59 @@ func rewriteSelect(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteR
 		SQL:          result,
 		NeedsOverlay: true,
 		TableName:    pq.Tables[0].Name,
+		Notices:      notices,
 	}, nil
 }
 
+// mergedTableCTE returns the "_rift_merged_<table> AS (...)" CTE that reads a
+// table as the branch sees it, and a notice if cfg.MaxRows caps the overlay.
+// The overlay rows kept are those with the lowest primary keys.
+func mergedTableCTE(table string, cfg RewriteConfig) (cte, notice string) {
+	srcTable := qualifiedTable(cfg.SourceSchema, table)
+	ovrTable := qualifiedTable(cfg.BranchSchema, table)
+	pkJoin := buildPKJoin("ovr", "src", cfg.PKColumns)
+
+	ovrSelect := fmt.Sprintf("SELECT * FROM %s WHERE NOT _rift_tombstone", ovrTable)
+	if cfg.MaxRows > 0 {
+		ovrSelect = fmt.Sprintf("(%s ORDER BY %s LIMIT %d)",
+			ovrSelect, strings.Join(quoteIdents(cfg.PKColumns), ", "), cfg.MaxRows)
+		notice = fmt.Sprintf(
+			"rift: branch rows of %q limited to %d (cow.max_overlay_rows); rows past the limit are missing from the results",
+			table, cfg.MaxRows)
+	}
+
+	srcSelect := fmt.Sprintf(`SELECT src.* FROM %s src
+  WHERE NOT EXISTS (
+    SELECT 1 FROM %s ovr WHERE %s
+  )`, srcTable, ovrTable, pkJoin)
+	if cfg.CTEMode == CTEHashAntiJoin {
+		pks := quoteIdents(cfg.PKColumns)
+		srcSelect = fmt.Sprintf(`SELECT src.* FROM %s src
+  LEFT JOIN (SELECT %s FROM %s) ovr ON %s
+  WHERE ovr.%s IS NULL`, srcTable, strings.Join(pks, ", "), ovrTable, pkJoin, pks[0])
+	}
+
+	cte = fmt.Sprintf(
+		`%s AS (
+  %s
+  UNION ALL
+  %s
+)`,
+		pgQuoteIdent("_rift_merged_"+table),
+		ovrSelect,
+		srcSelect,
+	)
+	return cte, notice
+}
+
 // rewriteInsert redirects the INSERT to the overlay table using ON CONFLICT upsert.
 //
 // For: INSERT INTO users (name) VALUES ('Charlie')
 // Produces: INSERT INTO _rift_branch_dev.users (name, _rift_tombstone) VALUES ('Charlie', false)
 //
 //	ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, _rift_tombstone = false
+//
+// A RETURNING clause is moved after the ON CONFLICT clause.
 func rewriteInsert(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteResult, error) {
 	if len(pq.Tables) == 0 {
 		return &RewriteResult{SQL: pq.Original, IsPassthrough: true}, nil
@@ -140,7 +208,7 @@ func rewriteInsert(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteR
 	}
 
 	// Replace the target table with overlay table
-	sql := strings.Replace(pq.Original, srcRef, ovrTable, 1)
+	sql := strings.Replace(pq.withoutReturning(), srcRef, ovrTable, 1)
 	if tbl.Schema == "" {
 		sql = replaceTableRef(sql, tbl, cfg.BranchSchema+"."+tbl.Name)
 	}
@@ -163,6 +231,9 @@ func rewriteInsert(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteR
 		sql += fmt.Sprintf("\nON CONFLICT (%s) DO UPDATE SET %s",
 			pkList, strings.Join(setClauses, ", "))
 	}
+	if pq.Returning != "" {
+		sql = strings.TrimRight(strings.TrimSpace(sql), ";") + "\nRETURNING " + pq.Returning
+	}
 
 	return &RewriteResult{
 		SQL:          sql,
@@ -175,6 +246,17 @@ func rewriteInsert(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteR
 // This is a two-step operation:
 //  1. Copy rows that match the WHERE clause from source to overlay (if not already there)
 //  2. Execute the UPDATE against the overlay table
+//
+// Step 2 skips the rows the branch deleted, and keeps the UPDATE's
+// RETURNING clause, so the result is that of step 2.
+//
+// For UPDATE ... FROM, step 1 copies the rows that join to the FROM clause:
+//
+//	INSERT INTO _rift_branch_dev.users SELECT src.*, false AS _rift_tombstone FROM public.users src
+//	WHERE NOT EXISTS (...) AND EXISTS (SELECT 1 FROM accounts a WHERE src.id = a.user_id)
+//
+// Join sources with branch changes are read through their merged view, as in
+// a SELECT; they never get overlays of their own.
 func rewriteUpdate(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteResult, error) {
 	if len(pq.Tables) == 0 {
 		return &RewriteResult{SQL: pq.Original, IsPassthrough: true}, nil
@@ -193,6 +275,36 @@ func rewriteUpdate(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteR
 	srcTable := qualifiedTable(cfg.SourceSchema, tbl.Name)
 	pkJoin := buildPKJoin("ovr", "src", cfg.PKColumns)
 
+	// Step 2 is built first so join sources can be swapped for merged views
+	// in both steps.
+	ovrRef := cfg.BranchSchema + "." + tbl.Name
+	updateSQL := replaceTableRef(strings.TrimRight(pq.withoutReturning(), ";"), tbl, ovrRef)
+	if tbl.Alias != "" {
+		ovrRef = tbl.Alias
+	}
+	updateSQL = skipTombstones(updateSQL, ovrRef+"._rift_tombstone")
+	if pq.Returning != "" {
+		updateSQL += " RETURNING " + pq.Returning
+	}
+	fromClause := pq.fromClause
+
+	var ctes []string
+	for _, src := range pq.Tables[1:] {
+		srcCfg, ok := configs[src.Name]
+		if !src.IsJoinSource || !ok || src.Name == tbl.Name {
+			continue
+		}
+		if len(srcCfg.PKColumns) == 0 {
+			return nil, fmt.Errorf("table %q requires a primary key for overlay semantics", src.Name)
+		}
+		cte, _ := mergedTableCTE(src.Name, srcCfg)
+		ctes = append(ctes, cte)
+
+		mergedName := "_rift_merged_" + src.Name
+		updateSQL = replaceTableRef(updateSQL, src, mergedName)
+		fromClause = replaceTableRef(fromClause, src, mergedName)
+	}
+
 	// Step 1: Copy-on-write — insert matching rows from source that aren't already in overlay
 	copySQL := fmt.Sprintf(
 		`INSERT INTO %s SELECT src.*, false AS _rift_tombstone FROM %s src WHERE NOT EXISTS (SELECT 1 FROM %s ovr WHERE %s)`,
@@ -201,14 +313,25 @@ func rewriteUpdate(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteR
 	// Extract WHERE clause from original for the copy step.
 	// Strip any table name, schema.table, or alias qualifiers so columns
 	// resolve against the "src" alias used in the copy subquery.
-	whereClause := extractWhereClause(pq.Original)
+	whereClause := extractWhereClause(pq.withoutReturning())
 	qualifiers := []string{tbl.Name, tbl.Alias, tbl.QualifiedName()}
 	if whereClause != "" {
-		copySQL += " AND (" + requalifyWhereForAlias(whereClause, "src", qualifiers...) + ")"
+		whereClause = requalifyWhereForAlias(whereClause, "src", qualifiers...)
+	}
+	switch {
+	case fromClause != "" && whereClause != "":
+		copySQL += " AND EXISTS (SELECT 1 FROM " + fromClause + " WHERE " + whereClause + ")"
+	case fromClause != "":
+		copySQL += " AND EXISTS (SELECT 1 FROM " + fromClause + ")"
+	case whereClause != "":
+		copySQL += " AND (" + whereClause + ")"
 	}
 
-	// Step 2: Execute UPDATE on overlay (no alias, so strip qualifiers)
-	updateSQL := replaceTableRef(pq.Original, tbl, cfg.BranchSchema+"."+tbl.Name)
+	if len(ctes) > 0 {
+		with := "WITH " + strings.Join(ctes, ", ") + "\n"
+		copySQL = with + copySQL
+		updateSQL = with + updateSQL
+	}
 
 	// Combine into a single DO block
 	sql := copySQL + ";\n" + updateSQL
@@ -223,7 +346,8 @@ func rewriteUpdate(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteR
 // rewriteDelete inserts a tombstone row in the overlay instead of actually deleting.
 // Steps:
 //  1. Copy-on-write matching rows into overlay (if not there)
-//  2. Mark them as tombstones
+//  2. Mark those not already deleted as tombstones, returning them if the
+//     DELETE had a RETURNING clause
 func rewriteDelete(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteResult, error) {
 	if len(pq.Tables) == 0 {
 		return &RewriteResult{SQL: pq.Original, IsPassthrough: true}, nil
@@ -247,7 +371,7 @@ func rewriteDelete(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteR
 		`INSERT INTO %s SELECT src.*, false AS _rift_tombstone FROM %s src WHERE NOT EXISTS (SELECT 1 FROM %s ovr WHERE %s)`,
 		ovrTable, srcTable, ovrTable, pkJoin)
 
-	whereClause := extractWhereClause(pq.Original)
+	whereClause := extractWhereClause(pq.withoutReturning())
 	qualifiers := []string{tbl.Name, tbl.Alias, tbl.QualifiedName()}
 	if whereClause != "" {
 		copySQL += " AND (" + requalifyWhereForAlias(whereClause, "src", qualifiers...) + ")"
@@ -259,6 +383,10 @@ func rewriteDelete(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteR
 	if whereClause != "" {
 		tombstoneSQL += " WHERE " + stripTableQualifiers(whereClause, qualifiers...)
 	}
+	tombstoneSQL = skipTombstones(tombstoneSQL, "_rift_tombstone")
+	if pq.Returning != "" {
+		tombstoneSQL += " RETURNING " + stripTableQualifiers(pq.Returning, qualifiers...)
+	}
 
 	sql := copySQL + ";\n" + tombstoneSQL
 
@@ -274,6 +402,12 @@ func rewriteDDL(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteResu
 	if len(pq.Tables) == 0 {
 		return &RewriteResult{SQL: pq.Original, IsPassthrough: true}, nil
 	}
+	switch pq.DDLType {
+	case DDLCreateIndex:
+		return rewriteCreateIndex(pq, configs)
+	case DDLDropIndex:
+		return rewriteDropIndex(pq, configs)
+	}
 
 	tbl := pq.Tables[0]
 	cfg, ok := configs[tbl.Name]
@@ -297,8 +431,91 @@ func rewriteDDL(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteResu
 	}, nil
 }
 
+// rewriteCreateIndex builds the index on the table's overlay. Only the
+// relation after ON is replaced, found by its position in the parse tree, so
+// an index or column that shares the table's name is left alone.
+func rewriteCreateIndex(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteResult, error) {
+	tbl := pq.Tables[0]
+	cfg, ok := configs[tbl.Name]
+	if !ok || cfg.BranchSchema == "" {
+		return &RewriteResult{SQL: pq.Original, IsPassthrough: true}, nil
+	}
+
+	rel := pq.tree.Stmts[0].Stmt.GetIndexStmt().GetRelation()
+	start := int(rel.GetLocation())
+	if start < 0 || start >= len(pq.Original) {
+		return nil, fmt.Errorf("locate table %s in CREATE INDEX", tbl.Name)
+	}
+	end := identChainEnd(pq.Original, start)
+	sql := pq.Original[:start] + qualifiedTable(cfg.BranchSchema, tbl.Name) + pq.Original[end:]
+
+	return &RewriteResult{
+		SQL:          sql,
+		NeedsOverlay: true,
+		TableName:    tbl.Name,
+	}, nil
+}
+
+// rewriteDropIndex qualifies each dropped index with the schema its config
+// names. pq.Tables holds index names here, not tables, so the caller is
+// expected to have looked up where each index lives; an index without a
+// config is left as written.
+func rewriteDropIndex(pq *ParsedQuery, configs map[string]RewriteConfig) (*RewriteResult, error) {
+	sql := pq.Original
+	rewritten := false
+	for _, idx := range pq.Tables {
+		cfg, ok := configs[idx.Name]
+		if !ok || cfg.BranchSchema == "" {
+			continue
+		}
+		sql = replaceTableRef(sql, idx, qualifiedTable(cfg.BranchSchema, idx.Name))
+		rewritten = true
+	}
+	if !rewritten {
+		return &RewriteResult{SQL: pq.Original, IsPassthrough: true}, nil
+	}
+	return &RewriteResult{
+		SQL:          sql,
+		NeedsOverlay: true,
+		TableName:    pq.Tables[0].Name,
+	}, nil
+}
+
 // --- Helpers ---
 
+// identChainEnd returns the offset just past the possibly quoted, dotted
+// identifier that starts at sql[start], such as users, public.users or
+// "My Schema"."Users".
+func identChainEnd(sql string, start int) int {
+	i := start
+	for i < len(sql) {
+		if sql[i] == '"' {
+			i++
+			for i < len(sql) {
+				if sql[i] == '"' {
+					if i+1 < len(sql) && sql[i+1] == '"' {
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				i++
+			}
+		} else {
+			for i < len(sql) && (isIdentChar(sql[i]) || sql[i] == '$' || sql[i] >= 0x80) {
+				i++
+			}
+		}
+		if i < len(sql) && sql[i] == '.' {
+			i++
+			continue
+		}
+		break
+	}
+	return i
+}
+
 func qualifiedTable(schema, table string) string {
 	return pgQuoteIdent(schema) + "." + pgQuoteIdent(table)
 }
@@ -395,6 +612,18 @@ func extractWhereClause(sql string) string {
 	return strings.TrimRight(strings.TrimSpace(clause), ";")
 }
 
+// skipTombstones adds "NOT <tombstone>" to the WHERE clause of an UPDATE or
+// DELETE without RETURNING, so that it leaves the rows the branch deleted
+// alone. Like extractWhereClause, it takes the first WHERE for the
+// statement's.
+func skipTombstones(sql, tombstone string) string {
+	idx := strings.Index(strings.ToUpper(sql), " WHERE ")
+	if idx == -1 {
+		return sql + " WHERE NOT " + tombstone
+	}
+	return sql[:idx+7] + "(" + strings.TrimSpace(sql[idx+7:]) + ") AND NOT " + tombstone
+}
+
 // requalifyWhereForAlias strips known table qualifiers from column references
 // in a WHERE clause and re-prefixes them with the given alias. For example,
 // given table "users" (alias "u"), "u.id = 1 AND users.name = 'x'" becomes
@@ -412,7 +641,7 @@ func requalifyWhereForAlias(where, alias string, qualifiers ...string) string {
 		// Replace "qualifier." with "alias." — use case-insensitive matching
 		// by trying the original case and lowercase variant.
 		for _, variant := range []string{q, strings.ToLower(q)} {
-			result = strings.ReplaceAll(result, variant+".", alias+".")
+			result = replaceQualifier(result, variant, alias+".")
 		}
 	}
 	return result
@@ -428,8 +657,29 @@ func stripTableQualifiers(where string, qualifiers ...string) string {
 			continue
 		}
 		for _, variant := range []string{q, strings.ToLower(q)} {
-			result = strings.ReplaceAll(result, variant+".", "")
+			result = replaceQualifier(result, variant, "")
 		}
 	}
 	return result
 }
+
+// replaceQualifier replaces "q." with repl wherever q starts a reference, so
+// that qualifier "users" leaves "old_users.id" and "public.users.id" alone.
+func replaceQualifier(sql, q, repl string) string {
+	old := q + "."
+	var b strings.Builder
+	for {
+		pos := strings.Index(sql, old)
+		if pos == -1 {
+			b.WriteString(sql)
+			return b.String()
+		}
+		if pos > 0 && (isIdentChar(sql[pos-1]) || sql[pos-1] == '.') {
+			b.WriteString(sql[:pos+len(old)])
+		} else {
+			b.WriteString(sql[:pos])
+			b.WriteString(repl)
+		}
+		sql = sql[pos+len(old):]
+	}
+}
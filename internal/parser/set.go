@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+// SetInfo describes a SET or RESET statement.
+type SetInfo struct {
+	// Name is the lowercased variable name (e.g. "search_path", "timezone").
+	// Empty for RESET ALL.
+	Name string
+
+	// Value is the new value with list elements joined by ", ".
+	// Empty when Reset is true.
+	Value string
+
+	// Local is true for SET LOCAL, which only lasts until the end of the transaction.
+	Local bool
+
+	// Reset is true for RESET, SET ... TO DEFAULT, and RESET ALL.
+	Reset bool
+}
+
+// ExtractSetInfo returns the variable assignment for a SET/RESET statement.
+// Returns nil if the query is not a SET/RESET or uses a form that is not
+// tracked (e.g. SET TRANSACTION, SET ... FROM CURRENT).
+func ExtractSetInfo(pq *ParsedQuery) *SetInfo {
+	if pq == nil || pq.Type != QueryUtility || pq.tree == nil || len(pq.tree.Stmts) == 0 {
+		return nil
+	}
+
+	n, ok := pq.tree.Stmts[0].Stmt.GetNode().(*pg_query.Node_VariableSetStmt)
+	if !ok {
+		return nil
+	}
+	vs := n.VariableSetStmt
+
+	info := &SetInfo{
+		Name:  strings.ToLower(vs.Name),
+		Local: vs.IsLocal,
+	}
+
+	switch vs.Kind {
+	case pg_query.VariableSetKind_VAR_SET_VALUE:
+		values := make([]string, 0, len(vs.Args))
+		for _, arg := range vs.Args {
+			v, ok := constValue(arg)
+			if !ok {
+				return nil
+			}
+			values = append(values, v)
+		}
+		info.Value = strings.Join(values, ", ")
+	case pg_query.VariableSetKind_VAR_SET_DEFAULT, pg_query.VariableSetKind_VAR_RESET:
+		info.Reset = true
+	case pg_query.VariableSetKind_VAR_RESET_ALL:
+		info.Name = ""
+		info.Reset = true
+	default:
+		return nil
+	}
+
+	return info
+}
+
+// constValue returns the text of a constant SET argument.
+func constValue(node *pg_query.Node) (string, bool) {
+	switch n := node.GetNode().(type) {
+	case *pg_query.Node_AConst:
+		c := n.AConst
+		switch {
+		case c.GetSval() != nil:
+			return c.GetSval().Sval, true
+		case c.GetIval() != nil:
+			return strconv.FormatInt(int64(c.GetIval().Ival), 10), true
+		case c.GetFval() != nil:
+			return c.GetFval().Fval, true
+		case c.GetBoolval() != nil:
+			return strconv.FormatBool(c.GetBoolval().Boolval), true
+		}
+	case *pg_query.Node_TypeCast:
+		// SET TIME ZONE INTERVAL '+02:00' HOUR TO MINUTE
+		return constValue(n.TypeCast.Arg)
+	}
+	return "", false
+}
+
+// SplitSearchPath splits a search_path value into schema names, stripping
+// whitespace and identifier quotes. "$user" is kept as-is for the caller to resolve.
+func SplitSearchPath(value string) []string {
+	var schemas []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) >= 2 && part[0] == '"' && part[len(part)-1] == '"' {
+			part = strings.ReplaceAll(part[1:len(part)-1], `""`, `"`)
+		}
+		if part != "" {
+			schemas = append(schemas, part)
+		}
+	}
+	return schemas
+}
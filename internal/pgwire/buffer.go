@@ -1,10 +1,12 @@
 package pgwire
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"io"
 	"math"
+	"sync"
 )
 
 var (
@@ -23,11 +25,45 @@ type Buffer struct {
 	pos int
 }
 
-// NewBuffer creates a new buffer with the given initial capacity
-func NewBuffer(capacity int) *Buffer {
-	return &Buffer{
-		buf: make([]byte, 0, capacity),
+// Pooled buffers start at pooledBufferSize bytes; buffers that grew past
+// maxPooledBufferSize are dropped on release so one large message doesn't
+// pin its memory in the pool.
+const (
+	pooledBufferSize    = 256
+	maxPooledBufferSize = 64 << 10
+)
+
+var bufPool = sync.Pool{
+	New: func() any {
+		return &Buffer{buf: make([]byte, 0, pooledBufferSize)}
+	},
+}
+
+// NewBuffer creates a new buffer with the given initial capacity. With
+// usePool the buffer comes from a shared pool; return it with ReleaseBuffer
+// once nothing references its bytes.
+func NewBuffer(capacity int, usePool bool) *Buffer {
+	if !usePool {
+		return &Buffer{
+			buf: make([]byte, 0, capacity),
+		}
+	}
+
+	b := bufPool.Get().(*Buffer)
+	if cap(b.buf) < capacity {
+		b.buf = make([]byte, 0, capacity)
+	}
+	return b
+}
+
+// ReleaseBuffer resets b and returns it to the pool. b must have come from
+// NewBuffer(_, true) and must not be used afterwards.
+func ReleaseBuffer(b *Buffer) {
+	if b == nil || cap(b.buf) > maxPooledBufferSize {
+		return
 	}
+	b.Reset()
+	bufPool.Put(b)
 }
 
 // Reset clears the buffer for reuse
@@ -228,14 +264,10 @@ func ReadMessage(r io.Reader) (msgType byte, payload []byte, err error) {
 		return 0, nil, ErrMessageTooLarge
 	}
 
-	// Read payload
-	payload = make([]byte, length)
-	if length > 0 {
-		if _, err := io.ReadFull(r, payload); err != nil {
-			return 0, nil, err
-		}
+	payload, err = readPayload(r, length)
+	if err != nil {
+		return 0, nil, err
 	}
-
 	return msgType, payload, nil
 }
 
@@ -253,15 +285,34 @@ func ReadStartupMessage(r io.Reader) ([]byte, error) {
 		return nil, ErrMessageTooLarge
 	}
 
-	// Read payload
-	payload := make([]byte, length)
-	if length > 0 {
+	return readPayload(r, length)
+}
+
+// payloadChunk bounds the up-front allocation for a message payload.
+const payloadChunk = 64 * 1024
+
+// readPayload reads a length-byte payload. Payloads are read in chunks so
+// that a header claiming a huge length costs memory only as the data
+// actually arrives.
+func readPayload(r io.Reader, length int) ([]byte, error) {
+	if length <= payloadChunk {
+		payload := make([]byte, length)
 		if _, err := io.ReadFull(r, payload); err != nil {
 			return nil, err
 		}
+		return payload, nil
 	}
 
-	return payload, nil
+	var buf bytes.Buffer
+	buf.Grow(payloadChunk)
+	n, err := io.Copy(&buf, io.LimitReader(r, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	if n < int64(length) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), nil
 }
 
 // WriteMessage writes a complete Postgres message
@@ -293,7 +344,13 @@ func WriteRaw(w io.Writer, data []byte) error {
 
 // BuildErrorResponse creates an ErrorResponse message payload
 func BuildErrorResponse(severity, code, message string) []byte {
-	buf := NewBuffer(256)
+	return BuildErrorResponseWithDetail(severity, code, message, "", "")
+}
+
+// BuildErrorResponseWithDetail creates an ErrorResponse message payload with
+// optional detail and hint fields. Empty detail or hint is omitted.
+func BuildErrorResponseWithDetail(severity, code, message, detail, hint string) []byte {
+	buf := NewBuffer(256, false)
 
 	_ = buf.WriteByte(FieldSeverity)
 	buf.WriteString(severity)
@@ -304,6 +361,16 @@ func BuildErrorResponse(severity, code, message string) []byte {
 	_ = buf.WriteByte(FieldMessage)
 	buf.WriteString(message)
 
+	if detail != "" {
+		_ = buf.WriteByte(FieldDetail)
+		buf.WriteString(detail)
+	}
+
+	if hint != "" {
+		_ = buf.WriteByte(FieldHint)
+		buf.WriteString(hint)
+	}
+
 	_ = buf.WriteByte(0) // terminator
 
 	return buf.Bytes()
@@ -315,6 +382,15 @@ func BuildNoticeResponse(severity, code, message string) []byte {
 	return BuildErrorResponse(severity, code, message)
 }
 
+// BuildNotificationResponse creates a NotificationResponse message payload
+func BuildNotificationResponse(pid int32, channel, payload string) []byte {
+	buf := NewBuffer(64, false)
+	buf.WriteInt32(pid)
+	buf.WriteString(channel)
+	buf.WriteString(payload)
+	return buf.Bytes()
+}
+
 // BuildReadyForQuery creates a ReadyForQuery message payload
 func BuildReadyForQuery(txStatus byte) []byte {
 	return []byte{txStatus}
@@ -322,7 +398,7 @@ func BuildReadyForQuery(txStatus byte) []byte {
 
 // BuildParameterStatus creates a ParameterStatus message payload
 func BuildParameterStatus(name, value string) []byte {
-	buf := NewBuffer(64)
+	buf := NewBuffer(64, false)
 	buf.WriteString(name)
 	buf.WriteString(value)
 	return buf.Bytes()
@@ -330,14 +406,14 @@ func BuildParameterStatus(name, value string) []byte {
 
 // BuildAuthenticationOk creates an AuthenticationOk message payload
 func BuildAuthenticationOk() []byte {
-	buf := NewBuffer(4)
+	buf := NewBuffer(4, false)
 	buf.WriteInt32(AuthOK)
 	return buf.Bytes()
 }
 
 // BuildAuthenticationMD5 creates an AuthenticationMD5Password message payload
 func BuildAuthenticationMD5(salt [4]byte) []byte {
-	buf := NewBuffer(8)
+	buf := NewBuffer(8, false)
 	buf.WriteInt32(AuthMD5Password)
 	buf.WriteBytes(salt[:])
 	return buf.Bytes()
@@ -345,14 +421,14 @@ func BuildAuthenticationMD5(salt [4]byte) []byte {
 
 // BuildAuthenticationCleartext creates an AuthenticationCleartextPassword payload
 func BuildAuthenticationCleartext() []byte {
-	buf := NewBuffer(4)
+	buf := NewBuffer(4, false)
 	buf.WriteInt32(AuthCleartextPassword)
 	return buf.Bytes()
 }
 
 // BuildBackendKeyData creates a BackendKeyData message payload
 func BuildBackendKeyData(pid, secretKey int32) []byte {
-	buf := NewBuffer(8)
+	buf := NewBuffer(8, false)
 	buf.WriteInt32(pid)
 	buf.WriteInt32(secretKey)
 	return buf.Bytes()
@@ -360,11 +436,36 @@ func BuildBackendKeyData(pid, secretKey int32) []byte {
 
 // BuildCommandComplete creates a CommandComplete message payload
 func BuildCommandComplete(tag string) []byte {
-	buf := NewBuffer(len(tag) + 1)
+	buf := NewBuffer(len(tag)+1, false)
 	buf.WriteString(tag)
 	return buf.Bytes()
 }
 
+// BuildCopyInResponse creates a CopyInResponse message payload for a
+// text-format COPY of the given number of columns.
+func BuildCopyInResponse(columns int) []byte {
+	buf := NewBuffer(3+2*columns, false)
+	_ = buf.WriteByte(0)           // overall format: text
+	buf.WriteInt16(int16(columns)) // #nosec G115 -- tables have at most 1600 columns
+	for range columns {
+		buf.WriteInt16(0)
+	}
+	return buf.Bytes()
+}
+
+// BuildFunctionCallResponse creates a FunctionCallResponse message payload.
+// A nil result is sent as NULL.
+func BuildFunctionCallResponse(result []byte) []byte {
+	buf := NewBuffer(4+len(result), false)
+	if result == nil {
+		buf.WriteInt32(-1)
+		return buf.Bytes()
+	}
+	buf.WriteInt32(int32(len(result))) // #nosec G115 -- a single value is far below 2 GiB
+	buf.WriteBytes(result)
+	return buf.Bytes()
+}
+
 // ParseStartupMessage parses startup message parameters
 func ParseStartupMessage(payload []byte) (version int32, params map[string]string, err error) {
 	if len(payload) < 4 {
@@ -375,7 +476,7 @@ func ParseStartupMessage(payload []byte) (version int32, params map[string]strin
 	params = make(map[string]string)
 
 	// Parse key-value pairs (null-terminated strings)
-	buf := NewBuffer(0)
+	buf := NewBuffer(0, false)
 	buf.buf = payload[4:]
 
 	for buf.Remaining() > 1 {
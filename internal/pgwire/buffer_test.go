@@ -6,7 +6,7 @@ import (
 )
 
 func TestBufferWriteRead(t *testing.T) {
-	buf := NewBuffer(64)
+	buf := NewBuffer(64, false)
 
 	// Write values
 	_ = buf.WriteByte(42)
@@ -45,7 +45,7 @@ func TestBufferWriteRead(t *testing.T) {
 }
 
 func TestParseStartupMessage(t *testing.T) {
-	buf := NewBuffer(256)
+	buf := NewBuffer(256, false)
 	buf.WriteInt32(ProtocolVersionNumber)
 	buf.WriteString("user")
 	buf.WriteString("testuser")
@@ -74,7 +74,7 @@ func TestParseStartupMessage(t *testing.T) {
 func TestBuildErrorResponse(t *testing.T) {
 	payload := BuildErrorResponse("ERROR", "42P01", "table not found")
 
-	buf := NewBuffer(0)
+	buf := NewBuffer(0, false)
 	buf.WriteBytes(payload)
 	buf.SetPosition(0)
 
@@ -107,6 +107,61 @@ func TestBuildErrorResponse(t *testing.T) {
 	}
 }
 
+func TestBuildErrorResponseWithDetail(t *testing.T) {
+	payload := BuildErrorResponseWithDetail("ERROR", "3D000", "branch not found: dev", "detail text", "hint text")
+
+	buf := NewBuffer(0, false)
+	buf.WriteBytes(payload)
+	buf.SetPosition(0)
+
+	fields := make(map[byte]string)
+	for {
+		fieldType, err := buf.ReadByte()
+		if err != nil || fieldType == 0 {
+			break
+		}
+		value, _ := buf.ReadString()
+		fields[fieldType] = value
+	}
+
+	if fields[FieldCode] != "3D000" {
+		t.Errorf("code: got %q, want '3D000'", fields[FieldCode])
+	}
+	if fields[FieldDetail] != "detail text" {
+		t.Errorf("detail: got %q, want 'detail text'", fields[FieldDetail])
+	}
+	if fields[FieldHint] != "hint text" {
+		t.Errorf("hint: got %q, want 'hint text'", fields[FieldHint])
+	}
+
+	// Empty detail and hint are omitted
+	if !bytes.Equal(BuildErrorResponseWithDetail("ERROR", "XX000", "boom", "", ""), BuildErrorResponse("ERROR", "XX000", "boom")) {
+		t.Error("empty detail and hint should produce a plain error response")
+	}
+}
+
+func TestBuildNotificationResponse(t *testing.T) {
+	payload := BuildNotificationResponse(42, "orders", "created")
+
+	buf := NewBuffer(0, false)
+	buf.WriteBytes(payload)
+	buf.SetPosition(0)
+
+	pid, _ := buf.ReadInt32()
+	channel, _ := buf.ReadString()
+	msg, _ := buf.ReadString()
+
+	if pid != 42 {
+		t.Errorf("pid: got %d, want 42", pid)
+	}
+	if channel != "orders" {
+		t.Errorf("channel: got %q, want 'orders'", channel)
+	}
+	if msg != "created" {
+		t.Errorf("payload: got %q, want 'created'", msg)
+	}
+}
+
 func TestMD5Password(t *testing.T) {
 	// Known test case
 	user := "postgres"
@@ -125,3 +180,62 @@ func TestMD5Password(t *testing.T) {
 		t.Errorf("MD5Password length: got %d, want 35", len(result))
 	}
 }
+
+func TestBuildFunctionCallResponse(t *testing.T) {
+	if got := BuildFunctionCallResponse([]byte("42")); !bytes.Equal(got, []byte{0, 0, 0, 2, '4', '2'}) {
+		t.Errorf("BuildFunctionCallResponse(42) = %v", got)
+	}
+	if got := BuildFunctionCallResponse(nil); !bytes.Equal(got, []byte{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("BuildFunctionCallResponse(nil) = %v, want -1 length", got)
+	}
+}
+
+func TestPooledBuffer(t *testing.T) {
+	buf := NewBuffer(16, true)
+	if buf.Len() != 0 {
+		t.Fatalf("pooled buffer has %d bytes, want 0", buf.Len())
+	}
+	buf.WriteString("hello")
+	ReleaseBuffer(buf)
+
+	// A buffer from the pool always starts empty
+	buf = NewBuffer(1024, true)
+	defer ReleaseBuffer(buf)
+	if buf.Len() != 0 || buf.Position() != 0 {
+		t.Errorf("reused buffer len=%d pos=%d, want 0 and 0", buf.Len(), buf.Position())
+	}
+	if cap(buf.Bytes()) < 1024 {
+		t.Errorf("pooled buffer capacity = %d, want at least 1024", cap(buf.Bytes()))
+	}
+}
+
+func TestReleaseBufferDropsLargeBuffers(t *testing.T) {
+	ReleaseBuffer(nil) // must not panic
+
+	buf := NewBuffer(maxPooledBufferSize+1, true)
+	buf.WriteString("x")
+	ReleaseBuffer(buf)
+	if buf.Len() == 0 {
+		t.Error("oversized buffer was reset and returned to the pool")
+	}
+}
+
+// benchmarkDataRow builds a DataRow-sized message the way the router does.
+func benchmarkDataRow(b *testing.B, usePool bool) {
+	values := [][]byte{[]byte("42"), []byte("alice@example.com"), []byte("2024-01-01 00:00:00")}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := NewBuffer(256, usePool)
+		buf.WriteInt16(int16(len(values)))
+		for _, v := range values {
+			buf.WriteInt32(int32(len(v)))
+			buf.WriteBytes(v)
+		}
+		if usePool {
+			ReleaseBuffer(buf)
+		}
+	}
+}
+
+func BenchmarkBufferNew(b *testing.B)    { benchmarkDataRow(b, false) }
+func BenchmarkBufferPooled(b *testing.B) { benchmarkDataRow(b, true) }
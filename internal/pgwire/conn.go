@@ -1,8 +1,11 @@
 package pgwire
 
 import (
+	"bufio"
 	"crypto/md5" // #nosec G501 -- required by Postgres wire protocol
 	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -19,6 +22,7 @@ var (
 	ErrAuthenticationFailed = errors.New("authentication failed")
 	ErrUnsupportedAuth      = errors.New("unsupported authentication method")
 	ErrConnectionClosed     = errors.New("connection closed")
+	ErrTLSHandshake         = errors.New("TLS handshake failed")
 )
 
 // ConnID is a unique connection identifier
@@ -40,12 +44,26 @@ type ClientConn struct {
 	pid       int32
 	secretKey int32
 
+	// tlsConfig, if set, accepts SSLRequest and direct TLS and upgrades
+	// conn to TLS; tlsConn is the TLS connection, which conn may already be
+	// when the connection is created.
+	tlsConfig *tls.Config
+	tlsConn   *tls.Conn
+
 	mu     sync.Mutex
 	closed bool
 
+	// writeMu serializes messages written from different goroutines
+	// (e.g. asynchronous notifications alongside query results).
+	writeMu sync.Mutex
+
 	// Read/write buffers
 	readBuf  *Buffer
 	writeBuf *Buffer
+
+	// rowWriter streams DataRow messages to conn (see WriteDataRow); it is
+	// guarded by writeMu.
+	rowWriter *bufio.Writer
 }
 
 // NewClientConn creates a new client connection wrapper
@@ -55,15 +73,20 @@ func NewClientConn(conn net.Conn) *ClientConn {
 	_, _ = rand.Read(pidBytes[:])
 	_, _ = rand.Read(keyBytes[:])
 
-	return &ClientConn{
+	c := &ClientConn{
 		id:        nextConnID(),
 		conn:      conn,
 		params:    make(map[string]string),
 		pid:       int32(pidBytes[0])<<24 | int32(pidBytes[1])<<16 | int32(pidBytes[2])<<8 | int32(pidBytes[3]),
 		secretKey: int32(keyBytes[0])<<24 | int32(keyBytes[1])<<16 | int32(keyBytes[2])<<8 | int32(keyBytes[3]),
-		readBuf:   NewBuffer(4096),
-		writeBuf:  NewBuffer(4096),
+		readBuf:   NewBuffer(4096, false),
+		writeBuf:  NewBuffer(4096, false),
+		rowWriter: bufio.NewWriterSize(conn, 4096),
 	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		c.tlsConn = tlsConn
+	}
+	return c
 }
 
 // ID returns the connection ID
@@ -86,6 +109,23 @@ func (c *ClientConn) Params() map[string]string {
 	return c.params
 }
 
+// SetTLSConfig makes the connection accept a client's SSLRequest, or a TLS
+// hello sent in place of the startup message (direct TLS), and switch to TLS
+// with cfg (see UpgradeToTLS). Without it SSL is declined. It must be called
+// before Startup.
+func (c *ClientConn) SetTLSConfig(cfg *tls.Config) {
+	c.tlsConfig = cfg
+}
+
+// TLSState returns the state of the client's TLS session, and false if the
+// client didn't use TLS.
+func (c *ClientConn) TLSState() (tls.ConnectionState, bool) {
+	if c.tlsConn == nil {
+		return tls.ConnectionState{}, false
+	}
+	return c.tlsConn.ConnectionState(), true
+}
+
 // RemoteAddr returns the client's remote address
 func (c *ClientConn) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
@@ -111,6 +151,25 @@ func (c *ClientConn) SetDeadline(t time.Time) error {
 // Handshake performs the initial Postgres handshake
 // Returns nil on successful authentication
 func (c *ClientConn) Handshake(authenticate func(user, database, password string) error) error {
+	if err := c.Startup(); err != nil {
+		return err
+	}
+
+	// Perform authentication
+	if authenticate != nil {
+		if err := c.Authenticate(authenticate); err != nil {
+			return err
+		}
+	}
+
+	return c.CompleteHandshake()
+}
+
+// Startup reads the client's startup message and records its parameters.
+// It is the first step of Handshake, for callers that need to exchange
+// further authentication messages with the client (such as relayed GSSAPI
+// tokens) before calling CompleteHandshake.
+func (c *ClientConn) Startup() error {
 	version, params, err := c.readStartup()
 	if err != nil {
 		return err
@@ -129,19 +188,29 @@ func (c *ClientConn) Handshake(authenticate func(user, database, password string
 	if c.database == "" {
 		c.database = c.user // Default database is username
 	}
+	return nil
+}
 
-	// Perform authentication
-	if authenticate != nil {
-		if err := c.authenticateClient(authenticate); err != nil {
-			return err
-		}
-	}
+// Authenticate asks the client for a cleartext password and checks it with
+// authenticate.
+func (c *ClientConn) Authenticate(authenticate func(user, database, password string) error) error {
+	return c.authenticateClient(authenticate)
+}
 
+// CompleteHandshake tells the client authentication succeeded and sends the
+// post-authentication messages, ending with ReadyForQuery.
+func (c *ClientConn) CompleteHandshake() error {
 	return c.sendPostAuthMessages()
 }
 
 // readStartup reads the startup message, handling SSL and GSSENC negotiation.
 func (c *ClientConn) readStartup() (version int32, params map[string]string, err error) {
+	if c.tlsConfig != nil && c.tlsConn == nil {
+		if err = c.acceptDirectTLS(); err != nil {
+			return 0, nil, fmt.Errorf("reading startup: %w", err)
+		}
+	}
+
 	var payload []byte
 	payload, err = ReadStartupMessage(c.conn)
 	if err != nil {
@@ -153,9 +222,15 @@ func (c *ClientConn) readStartup() (version int32, params map[string]string, err
 		return 0, nil, fmt.Errorf("parsing startup: %w", err)
 	}
 
-	// Handle SSL and GSSENC requests by declining and re-reading
+	// Handle SSL and GSSENC requests by accepting SSL if configured, or
+	// declining, then re-reading. A connection already on TLS, whether
+	// direct or set up before the ClientConn, declines further requests.
 	for version == SSLRequestCode || version == GSSENCRequestCode {
-		if _, err = c.conn.Write([]byte{'N'}); err != nil {
+		if version == SSLRequestCode && c.tlsConfig != nil && c.tlsConn == nil {
+			if err = c.startTLS(); err != nil {
+				return 0, nil, err
+			}
+		} else if _, err = c.conn.Write([]byte{'N'}); err != nil {
 			return 0, nil, err
 		}
 		payload, err = ReadStartupMessage(c.conn)
@@ -235,18 +310,74 @@ func (c *ClientConn) ReadMessage() (msgType byte, payload []byte, err error) {
 
 // WriteMessage writes a message to the client
 func (c *ClientConn) WriteMessage(msgType byte, payload []byte) error {
-	return WriteMessage(c.conn, msgType, payload)
+	// Frame the message in one pooled buffer so it goes out in a single write
+	buf := NewBuffer(5+len(payload), true)
+	defer ReleaseBuffer(buf)
+	_ = buf.WriteByte(msgType)
+	buf.WriteInt32(int32(len(payload) + 4)) // #nosec G115 -- payloads are far below 2 GiB
+	buf.WriteBytes(payload)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+// WriteDataRow writes a DataRow message, streaming each column's length and
+// value to the connection instead of assembling the whole row in memory
+// first, so wide rows cost no more than the values themselves. Values are
+// sent as is, so a column may hold text or binary format bytes. A nil value
+// is sent as NULL.
+func (c *ClientConn) WriteDataRow(values []*string) error {
+	length := 4 + 2 // length field and column count
+	for _, v := range values {
+		length += 4
+		if v != nil {
+			length += len(*v)
+		}
+	}
+	if length > MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	w := c.rowWriter
+	var header [7]byte
+	header[0] = MsgDataRow
+	binary.BigEndian.PutUint32(header[1:5], uint32(length))      // #nosec G115 -- bounded by MaxMessageSize
+	binary.BigEndian.PutUint16(header[5:7], uint16(len(values))) // #nosec G115 -- column count fits in uint16
+	_, _ = w.Write(header[:])
+
+	var colLen [4]byte
+	for _, v := range values {
+		n := int32(-1)
+		if v != nil {
+			n = int32(len(*v)) // #nosec G115 -- bounded by MaxMessageSize
+		}
+		binary.BigEndian.PutUint32(colLen[:], uint32(n)) // #nosec G115 -- -1 encodes NULL
+		_, _ = w.Write(colLen[:])
+		if v != nil {
+			_, _ = w.WriteString(*v)
+		}
+	}
+
+	// bufio.Writer errors are sticky, so Flush reports any earlier failure
+	return w.Flush()
 }
 
 // WriteRaw writes raw bytes to the client
 func (c *ClientConn) WriteRaw(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
 	_, err := c.conn.Write(data)
 	return err
 }
 
 // sendError sends an error response to the client
 func (c *ClientConn) sendError(severity, code, message string) error {
-	return WriteMessage(c.conn, MsgErrorResponse, BuildErrorResponse(severity, code, message))
+	return c.WriteMessage(MsgErrorResponse, BuildErrorResponse(severity, code, message))
 }
 
 // SendError sends an error response
@@ -254,19 +385,29 @@ func (c *ClientConn) SendError(severity, code, message string) error {
 	return c.sendError(severity, code, message)
 }
 
+// SendErrorWithDetail sends an error response with detail and hint fields
+func (c *ClientConn) SendErrorWithDetail(severity, code, message, detail, hint string) error {
+	return c.WriteMessage(MsgErrorResponse, BuildErrorResponseWithDetail(severity, code, message, detail, hint))
+}
+
 // SendNotice sends a notice response
 func (c *ClientConn) SendNotice(severity, code, message string) error {
-	return WriteMessage(c.conn, MsgNoticeResponse, BuildNoticeResponse(severity, code, message))
+	return c.WriteMessage(MsgNoticeResponse, BuildNoticeResponse(severity, code, message))
+}
+
+// SendNotification sends an asynchronous NotificationResponse
+func (c *ClientConn) SendNotification(pid int32, channel, payload string) error {
+	return c.WriteMessage(MsgNotificationResponse, BuildNotificationResponse(pid, channel, payload))
 }
 
 // SendReadyForQuery sends a ReadyForQuery message
 func (c *ClientConn) SendReadyForQuery(txStatus byte) error {
-	return WriteMessage(c.conn, MsgReadyForQuery, BuildReadyForQuery(txStatus))
+	return c.WriteMessage(MsgReadyForQuery, BuildReadyForQuery(txStatus))
 }
 
 // SendCommandComplete sends a CommandComplete message
 func (c *ClientConn) SendCommandComplete(tag string) error {
-	return WriteMessage(c.conn, MsgCommandComplete, BuildCommandComplete(tag))
+	return c.WriteMessage(MsgCommandComplete, BuildCommandComplete(tag))
 }
 
 // MD5Password computes the MD5 password hash per Postgres wire protocol.
@@ -0,0 +1,40 @@
+package pgwire
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestWriteDataRow(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = server.Close() }()
+	defer func() { _ = client.Close() }()
+
+	conn := NewClientConn(server)
+	id, name := "42", "Alice"
+	errc := make(chan error, 1)
+	go func() { errc <- conn.WriteDataRow([]*string{&id, nil, &name}) }()
+
+	msgType, payload, err := ReadMessage(client)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("WriteDataRow: %v", err)
+	}
+	if msgType != MsgDataRow {
+		t.Errorf("message type = %c, want %c", msgType, MsgDataRow)
+	}
+
+	want := NewBuffer(32, false)
+	want.WriteInt16(3)
+	want.WriteInt32(2)
+	want.WriteBytes([]byte("42"))
+	want.WriteInt32(-1)
+	want.WriteInt32(5)
+	want.WriteBytes([]byte("Alice"))
+	if !bytes.Equal(payload, want.Bytes()) {
+		t.Errorf("payload = %v, want %v", payload, want.Bytes())
+	}
+}
@@ -45,6 +45,7 @@ const (
 	MsgDataRow              byte = 'D'
 	MsgEmptyQueryResponse   byte = 'I'
 	MsgErrorResponse        byte = 'E'
+	MsgFunctionCallResponse byte = 'V'
 	MsgNoData               byte = 'n'
 	MsgNoticeResponse       byte = 'N'
 	MsgNotificationResponse byte = 'A'
@@ -78,6 +79,12 @@ const (
 	TxStatusFailed byte = 'E' // In a failed transaction
 )
 
+// Format codes (Bind, RowDescription)
+const (
+	FormatText   int16 = 0
+	FormatBinary int16 = 1
+)
+
 // Protocol version
 const (
 	ProtocolVersionNumber = 196608 // 3.0 = (3 << 16) | 0
@@ -113,11 +120,22 @@ const (
 	ErrCodeSuccessfulCompletion  = "00000"
 	ErrCodeWarning               = "01000"
 	ErrCodeNoData                = "02000"
+	ErrCodeReadOnlyTransaction   = "25006"
 	ErrCodeConnectionException   = "08000"
 	ErrCodeConnectionFailure     = "08006"
 	ErrCodeSyntaxError           = "42601"
 	ErrCodeInvalidCatalogName    = "3D000"
+	ErrCodeInvalidAuthorization  = "28000"
 	ErrCodeUndefinedTable        = "42P01"
 	ErrCodeInsufficientPrivilege = "42501"
+	ErrCodeUniqueViolation       = "23505"
 	ErrCodeInternalError         = "XX000"
+	ErrCodeFeatureNotSupported   = "0A000"
+	ErrCodeUndefinedFunction     = "42883"
+	ErrCodeTooManyConnections    = "53300"
+	ErrCodeConfigLimitExceeded   = "53400"
+	ErrCodeBadCopyFileFormat     = "22P04"
+	ErrCodeQueryCanceled         = "57014"
+	ErrCodeCannotConnectNow      = "57P03"
+	ErrCodeProtocolViolation     = "08P01"
 )
@@ -0,0 +1,87 @@
+package pgwire
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+)
+
+// ALPNProtocol is the ALPN protocol ID of PostgreSQL over TLS. Clients
+// connecting with direct TLS (libpq's sslnegotiation=direct) and TLS-aware
+// load balancers and poolers send it in their hello.
+const ALPNProtocol = "postgresql"
+
+// tlsHandshakeRecord is the first byte of a TLS ClientHello. A startup
+// message starts with the high byte of its length, which is always 0, so
+// the two can't be confused.
+const tlsHandshakeRecord = 0x16
+
+// UpgradeToTLS performs a server-side TLS handshake on conn with cfg,
+// advertising ALPNProtocol, and returns the TLS connection. A client offering
+// only other ALPN protocols fails the handshake. cfg is not modified.
+func UpgradeToTLS(conn net.Conn, cfg *tls.Config) (net.Conn, error) {
+	cfg = cfg.Clone()
+	if !slices.Contains(cfg.NextProtos, ALPNProtocol) {
+		cfg.NextProtos = append(cfg.NextProtos, ALPNProtocol)
+	}
+	tlsConn := tls.Server(conn, cfg)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTLSHandshake, err)
+	}
+	return tlsConn, nil
+}
+
+// peekedConn is a net.Conn whose first bytes were already read; Read
+// returns them before the rest of the stream.
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// acceptDirectTLS upgrades the connection to TLS if the client opened it
+// with a TLS hello rather than a startup message or SSLRequest.
+func (c *ClientConn) acceptDirectTLS() error {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(c.conn, first); err != nil {
+		return err
+	}
+	conn := &peekedConn{Conn: c.conn, r: io.MultiReader(bytes.NewReader(first), c.conn)}
+	if first[0] != tlsHandshakeRecord {
+		c.conn = conn
+		return nil
+	}
+	tlsConn, err := UpgradeToTLS(conn, c.tlsConfig)
+	if err != nil {
+		return err
+	}
+	c.setTLSConn(tlsConn.(*tls.Conn))
+	return nil
+}
+
+// startTLS accepts an SSLRequest and performs the TLS handshake, after which
+// the connection talks TLS.
+func (c *ClientConn) startTLS() error {
+	if _, err := c.conn.Write([]byte{'S'}); err != nil {
+		return err
+	}
+	tlsConn, err := UpgradeToTLS(c.conn, c.tlsConfig)
+	if err != nil {
+		return err
+	}
+	c.setTLSConn(tlsConn.(*tls.Conn))
+	return nil
+}
+
+// setTLSConn makes the connection talk over tlsConn.
+func (c *ClientConn) setTLSConn(tlsConn *tls.Conn) {
+	c.tlsConn = tlsConn
+	c.conn = tlsConn
+	c.rowWriter.Reset(tlsConn)
+}
@@ -0,0 +1,200 @@
+package pgwire
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testTLSConfig returns a server config with a self-signed certificate for
+// localhost, and a client config trusting it.
+func testTLSConfig(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}}}
+	client = &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	return server, client
+}
+
+// startupClient runs a client on one end of a pipe, returning the server's
+// end. connect gets the client's end and returns the connection to send the
+// startup message on.
+func startupClient(t *testing.T, connect func(net.Conn) (net.Conn, error)) (net.Conn, chan error) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() { _ = server.Close(); _ = client.Close() })
+	_ = server.SetDeadline(time.Now().Add(5 * time.Second))
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	errc := make(chan error, 1)
+	go func() {
+		conn, err := connect(client)
+		if err == nil {
+			_, err = conn.Write(startupMessage(startupPayload(ProtocolVersionNumber, "user", "alice", "database", "dev")))
+		}
+		errc <- err
+	}()
+	return server, errc
+}
+
+func TestUpgradeToTLS(t *testing.T) {
+	serverCfg, clientCfg := testTLSConfig(t)
+	server, client := net.Pipe()
+	defer func() { _ = server.Close(); _ = client.Close() }()
+
+	clientCfg.NextProtos = []string{ALPNProtocol}
+	tlsClient := tls.Client(client, clientCfg)
+	errc := make(chan error, 1)
+	go func() { errc <- tlsClient.Handshake() }()
+
+	conn, err := UpgradeToTLS(server, serverCfg)
+	if err != nil {
+		t.Fatalf("UpgradeToTLS: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	if got := conn.(*tls.Conn).ConnectionState().NegotiatedProtocol; got != ALPNProtocol {
+		t.Errorf("server negotiated %q, want %q", got, ALPNProtocol)
+	}
+	if got := tlsClient.ConnectionState().NegotiatedProtocol; got != ALPNProtocol {
+		t.Errorf("client negotiated %q, want %q", got, ALPNProtocol)
+	}
+	if len(serverCfg.NextProtos) != 0 {
+		t.Errorf("UpgradeToTLS modified the config's NextProtos: %v", serverCfg.NextProtos)
+	}
+}
+
+func TestUpgradeToTLSOtherProtocol(t *testing.T) {
+	serverCfg, clientCfg := testTLSConfig(t)
+	server, client := net.Pipe()
+	defer func() { _ = server.Close(); _ = client.Close() }()
+
+	clientCfg.NextProtos = []string{"h2"}
+	go func() { _ = tls.Client(client, clientCfg).Handshake() }()
+
+	if _, err := UpgradeToTLS(server, serverCfg); !errors.Is(err, ErrTLSHandshake) {
+		t.Errorf("UpgradeToTLS with a client speaking h2 = %v, want ErrTLSHandshake", err)
+	}
+}
+
+func TestStartupTLS(t *testing.T) {
+	serverCfg, clientCfg := testTLSConfig(t)
+	clientCfg.NextProtos = []string{ALPNProtocol}
+
+	tests := []struct {
+		name    string
+		connect func(net.Conn) (net.Conn, error)
+	}{
+		{"direct", func(conn net.Conn) (net.Conn, error) {
+			tlsConn := tls.Client(conn, clientCfg)
+			return tlsConn, tlsConn.Handshake()
+		}},
+		{"SSLRequest", func(conn net.Conn) (net.Conn, error) {
+			if _, err := conn.Write(startupMessage(startupPayload(SSLRequestCode))); err != nil {
+				return nil, err
+			}
+			answer := make([]byte, 1)
+			if _, err := conn.Read(answer); err != nil {
+				return nil, err
+			}
+			if answer[0] != 'S' {
+				return nil, errors.New("SSLRequest declined")
+			}
+			tlsConn := tls.Client(conn, clientCfg)
+			return tlsConn, tlsConn.Handshake()
+		}},
+		{"plain", func(conn net.Conn) (net.Conn, error) {
+			return conn, nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, errc := startupClient(t, tt.connect)
+			conn := NewClientConn(server)
+			conn.SetTLSConfig(serverCfg)
+			if err := conn.Startup(); err != nil {
+				t.Fatalf("Startup: %v", err)
+			}
+			if err := <-errc; err != nil {
+				t.Fatalf("client: %v", err)
+			}
+			if conn.User() != "alice" || conn.Database() != "dev" {
+				t.Errorf("user %q, database %q; want alice, dev", conn.User(), conn.Database())
+			}
+			state, ok := conn.TLSState()
+			if ok != (tt.name != "plain") {
+				t.Fatalf("TLSState ok = %v", ok)
+			}
+			if ok && state.NegotiatedProtocol != ALPNProtocol {
+				t.Errorf("negotiated %q, want %q", state.NegotiatedProtocol, ALPNProtocol)
+			}
+		})
+	}
+}
+
+func TestStartupAlreadyTLS(t *testing.T) {
+	serverCfg, clientCfg := testTLSConfig(t)
+	server, errc := startupClient(t, func(conn net.Conn) (net.Conn, error) {
+		tlsConn := tls.Client(conn, clientCfg)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		// An SSLRequest on a TLS connection is declined
+		if _, err := tlsConn.Write(startupMessage(startupPayload(SSLRequestCode))); err != nil {
+			return nil, err
+		}
+		answer := make([]byte, 1)
+		if _, err := tlsConn.Read(answer); err != nil {
+			return nil, err
+		}
+		if answer[0] != 'N' {
+			return nil, errors.New("SSLRequest on a TLS connection accepted")
+		}
+		return tlsConn, nil
+	})
+
+	tlsServer, err := UpgradeToTLS(server, serverCfg)
+	if err != nil {
+		t.Fatalf("UpgradeToTLS: %v", err)
+	}
+	conn := NewClientConn(tlsServer)
+	conn.SetTLSConfig(serverCfg)
+	if err := conn.Startup(); err != nil {
+		t.Fatalf("Startup: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	if _, ok := conn.TLSState(); !ok {
+		t.Error("TLSState ok = false on a TLS connection")
+	}
+}
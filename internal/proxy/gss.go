@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/riftdata/rift/internal/pgwire"
+)
+
+// gssRelay forwards a client's GSSAPI tokens to the upstream while
+// handleUpstreamAuth relays the upstream's AuthGSS and AuthGSSContinue
+// requests the other way. The two directions run independently because
+// the exchange's last AuthGSSContinue may need no reply: the upstream
+// then sends AuthOK while the client waits.
+type gssRelay struct {
+	client   *pgwire.ClientConn
+	upstream net.Conn
+	done     chan struct{}
+}
+
+func startGSSRelay(client *pgwire.ClientConn, upstream net.Conn) *gssRelay {
+	r := &gssRelay{client: client, upstream: upstream, done: make(chan struct{})}
+	go r.run()
+	return r
+}
+
+// run copies the client's GSSResponse messages to the upstream until the
+// client's connection fails or stop interrupts it. On any other message
+// or error it closes the upstream, ending the authentication.
+func (r *gssRelay) run() {
+	defer close(r.done)
+	for {
+		msgType, payload, err := r.client.ReadMessage()
+		if err == nil && msgType != pgwire.MsgPassword {
+			err = fmt.Errorf("%w: expected GSSAPI response, got %c", pgwire.ErrInvalidStartup, msgType)
+		}
+		if err == nil {
+			err = pgwire.WriteMessage(r.upstream, pgwire.MsgPassword, payload)
+		}
+		if err != nil {
+			if !isTimeout(err) {
+				_ = r.upstream.Close()
+			}
+			return
+		}
+	}
+}
+
+// stop interrupts the relay's pending read from the client and waits for
+// it to return. The client sends nothing more until it has seen
+// ReadyForQuery, so no message is cut short.
+func (r *gssRelay) stop() {
+	_ = r.client.SetDeadline(time.Now())
+	<-r.done
+	_ = r.client.SetDeadline(time.Time{})
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/riftdata/rift/internal/pgwire"
+)
+
+func authMessage(authType int32, data []byte) []byte {
+	buf := pgwire.NewBuffer(8, false)
+	buf.WriteInt32(authType)
+	buf.WriteBytes(data)
+	return buf.Bytes()
+}
+
+func TestHandleUpstreamAuthRelaysGSS(t *testing.T) {
+	proxyUp, upstream := net.Pipe()
+	proxyClient, client := net.Pipe()
+	defer func() { _ = upstream.Close() }()
+	defer func() { _ = client.Close() }()
+
+	// The upstream asks for GSSAPI, reads the client's token and finishes
+	// with a last AuthGSSContinue the client doesn't answer.
+	upstreamErr := make(chan error, 1)
+	go func() {
+		upstreamErr <- func() error {
+			if err := pgwire.WriteMessage(upstream, pgwire.MsgAuthentication, authMessage(pgwire.AuthGSS, nil)); err != nil {
+				return err
+			}
+			msgType, payload, err := pgwire.ReadMessage(upstream)
+			if err != nil {
+				return err
+			}
+			if msgType != pgwire.MsgPassword || string(payload) != "client-token" {
+				t.Errorf("upstream got %c %q, want the client's token", msgType, payload)
+			}
+			if err := pgwire.WriteMessage(upstream, pgwire.MsgAuthentication, authMessage(pgwire.AuthGSSContinue, []byte("server-token"))); err != nil {
+				return err
+			}
+			if err := pgwire.WriteMessage(upstream, pgwire.MsgAuthentication, authMessage(pgwire.AuthOK, nil)); err != nil {
+				return err
+			}
+			return pgwire.WriteMessage(upstream, pgwire.MsgReadyForQuery, pgwire.BuildReadyForQuery(pgwire.TxStatusIdle))
+		}()
+	}()
+
+	clientErr := make(chan error, 1)
+	go func() {
+		clientErr <- func() error {
+			_, payload, err := pgwire.ReadMessage(client)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(payload, authMessage(pgwire.AuthGSS, nil)) {
+				t.Errorf("client got %v, want AuthGSS", payload)
+			}
+			if err := pgwire.WriteMessage(client, pgwire.MsgPassword, []byte("client-token")); err != nil {
+				return err
+			}
+			_, payload, err = pgwire.ReadMessage(client)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(payload, authMessage(pgwire.AuthGSSContinue, []byte("server-token"))) {
+				t.Errorf("client got %v, want AuthGSSContinue with the server's token", payload)
+			}
+			return nil
+		}()
+	}()
+
+	p := New(&Config{})
+	if err := p.handleUpstreamAuth(proxyUp, pgwire.NewClientConn(proxyClient)); err != nil {
+		t.Fatalf("handleUpstreamAuth: %v", err)
+	}
+	if err := <-upstreamErr; err != nil {
+		t.Errorf("upstream: %v", err)
+	}
+	if err := <-clientErr; err != nil {
+		t.Errorf("client: %v", err)
+	}
+}
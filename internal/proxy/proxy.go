@@ -2,14 +2,17 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/riftdata/rift/internal/branch"
 	"github.com/riftdata/rift/internal/pgwire"
 	"github.com/riftdata/rift/internal/router"
 )
@@ -22,13 +25,41 @@ var (
 
 // Config holds proxy configuration
 type Config struct {
-	ListenAddr     string
-	UpstreamAddr   string
-	UpstreamUser   string
-	UpstreamPass   string
+	ListenAddr   string
+	UpstreamAddr string
+	UpstreamUser string
+	UpstreamPass string
+
+	// MaxConnections caps concurrent client connections (0 = unlimited).
+	// Clients beyond it wait up to ConnectTimeout for a connection to close
+	// before they are rejected.
 	MaxConnections int
 	ConnectTimeout time.Duration
 	IdleTimeout    time.Duration
+
+	// DrainTimeout is how long Stop waits for in-flight queries before
+	// closing connections. 0 closes them right away.
+	DrainTimeout time.Duration
+
+	// MaintenanceMode starts the proxy in maintenance mode (see
+	// Proxy.SetMaintenance).
+	MaintenanceMode bool
+
+	// TLSCertFile and TLSKeyFile, if set, let clients connect with TLS
+	// (sslmode=require and the like), negotiated with an SSLRequest or
+	// direct (sslnegotiation=direct, ALPN "postgresql"). Clients may still
+	// connect without it unless MutualTLS is set.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// MutualTLS requires clients to connect with TLS and present a
+	// certificate signed by a CA in ClientCAFile. The certificate's subject
+	// CN and SHA-256 fingerprint are logged for each connection.
+	MutualTLS    bool
+	ClientCAFile string
+
+	// Logger receives the proxy's logs; nil uses slog.Default().
+	Logger *slog.Logger
 }
 
 // DefaultConfig returns default proxy configuration
@@ -38,17 +69,40 @@ func DefaultConfig() *Config {
 		MaxConnections: 100,
 		ConnectTimeout: 10 * time.Second,
 		IdleTimeout:    5 * time.Minute,
+		DrainTimeout:   30 * time.Second,
 	}
 }
 
+// shutdownNotice is sent to routed sessions when the proxy starts draining.
+const shutdownNotice = "Server is shutting down, please reconnect shortly"
+
+// maintenanceMessage is sent to clients that connect in maintenance mode.
+const maintenanceMessage = "server in maintenance mode, try again later"
+
+// tooManyConnectionsMessage is sent to clients that waited ConnectTimeout
+// without a connection slot freeing up.
+const tooManyConnectionsMessage = "too many connections"
+
+// drainPollInterval is how often Stop checks for in-flight queries.
+const drainPollInterval = 50 * time.Millisecond
+
 // Proxy is the main Postgres proxy server
 type Proxy struct {
 	config   *Config
 	listener net.Listener
+	logger   *slog.Logger
+
+	// tlsConfig is built from the TLS settings by Start; nil without TLS.
+	tlsConfig *tls.Config
 
 	// Connection tracking
 	connections sync.Map // ConnID -> *clientSession
 	connCount   atomic.Int64
+	maintenance atomic.Bool
+
+	// slots holds a token per connection past startup, so at most
+	// MaxConnections are served at once; nil when unlimited.
+	slots chan struct{}
 
 	// Lifecycle
 	ctx    context.Context
@@ -57,12 +111,21 @@ type Proxy struct {
 	mu     sync.Mutex
 	closed bool
 
-	// Hooks for branch routing (to be set by branch manager)
-	OnConnect    func(database string) (upstreamDB string, err error)
+	// Hooks for branch routing (to be set by branch manager). OnConnect may
+	// reject a client by returning an error wrapping branch.ErrHostNotAllowed.
+	OnConnect    func(database string, remoteAddr net.Addr) (upstreamDB string, err error)
 	Authenticate func(user, database, password string) error
 
 	// Router for non-main branch connections (nil = passthrough only)
 	Router *router.Router
+
+	// OnDrain, if set, is called while Stop waits for in-flight queries,
+	// with the number of connections still open whenever it changes.
+	OnDrain func(connections int64)
+
+	// UpstreamAddr, if set, returns the address passthrough connections
+	// dial in place of Config.UpstreamAddr, e.g. the upstream failed over to.
+	UpstreamAddr func() string
 }
 
 // clientSession holds state for a single client connection
@@ -75,15 +138,30 @@ type clientSession struct {
 // New creates a new proxy server
 func New(config *Config) *Proxy {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &Proxy{
+	p := &Proxy{
 		config: config,
+		logger: config.Logger,
 		ctx:    ctx,
 		cancel: cancel,
 	}
+	if p.logger == nil {
+		p.logger = slog.Default()
+	}
+	if config.MaxConnections > 0 {
+		p.slots = make(chan struct{}, config.MaxConnections)
+	}
+	p.maintenance.Store(config.MaintenanceMode)
+	return p
 }
 
 // Start starts the proxy server
 func (p *Proxy) Start() error {
+	tlsConfig, err := loadTLSConfig(p.config)
+	if err != nil {
+		return err
+	}
+	p.tlsConfig = tlsConfig
+
 	listener, err := net.Listen("tcp", p.config.ListenAddr)
 	if err != nil {
 		return fmt.Errorf("listen %s: %w", p.config.ListenAddr, err)
@@ -96,7 +174,11 @@ func (p *Proxy) Start() error {
 	return nil
 }
 
-// Stop gracefully stops the proxy server
+// Stop gracefully stops the proxy server: it stops accepting connections,
+// tells routed sessions that the server is shutting down, waits up to
+// DrainTimeout for their in-flight queries, then closes every connection.
+// Passthrough (main) connections are raw TCP, so their queries can't be
+// waited for or notified.
 func (p *Proxy) Stop() error {
 	p.mu.Lock()
 	if p.closed {
@@ -106,12 +188,13 @@ func (p *Proxy) Stop() error {
 	p.closed = true
 	p.mu.Unlock()
 
-	p.cancel()
-
 	if p.listener != nil {
 		_ = p.listener.Close()
 	}
 
+	p.drain()
+	p.cancel()
+
 	// Close all client connections
 	p.connections.Range(func(key, value interface{}) bool {
 		if session, ok := value.(*clientSession); ok {
@@ -127,6 +210,46 @@ func (p *Proxy) Stop() error {
 	return nil
 }
 
+// drain notifies routed sessions of the shutdown and waits for their
+// in-flight queries, for at most DrainTimeout.
+func (p *Proxy) drain() {
+	if p.Router == nil || p.config.DrainTimeout <= 0 {
+		return
+	}
+
+	p.connections.Range(func(_, value interface{}) bool {
+		if session, ok := value.(*clientSession); ok && session.upstream == nil {
+			_ = session.client.SendNotice("NOTICE", pgwire.ErrCodeWarning, shutdownNotice)
+		}
+		return true
+	})
+
+	deadline := time.NewTimer(p.config.DrainTimeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	reported := int64(-1)
+	for p.Router.InFlight() > 0 {
+		if n := p.connCount.Load(); n != reported && p.OnDrain != nil {
+			p.OnDrain(n)
+			reported = n
+		}
+		select {
+		case <-deadline.C:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// isClosed reports whether Stop has been called.
+func (p *Proxy) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
 // Addr returns the listener address
 func (p *Proxy) Addr() net.Addr {
 	if p.listener == nil {
@@ -140,25 +263,29 @@ func (p *Proxy) ConnectionCount() int64 {
 	return p.connCount.Load()
 }
 
+// SetMaintenance turns maintenance mode on or off. In maintenance mode new
+// connections are rejected with SQLSTATE 57P03 right after their startup
+// message, while open sessions carry on until they disconnect or Stop
+// drains them.
+func (p *Proxy) SetMaintenance(enabled bool) {
+	p.maintenance.Store(enabled)
+}
+
+// Maintenance reports whether the proxy is in maintenance mode.
+func (p *Proxy) Maintenance() bool {
+	return p.maintenance.Load()
+}
+
 func (p *Proxy) acceptLoop() {
 	defer p.wg.Done()
 
 	for {
 		conn, err := p.listener.Accept()
 		if err != nil {
-			select {
-			case <-p.ctx.Done():
+			if p.isClosed() {
 				return
-			default:
-				// Log error and continue
-				fmt.Printf("accept error: %v\n", err)
-				continue
 			}
-		}
-
-		// Check max connections
-		if p.config.MaxConnections > 0 && p.connCount.Load() >= int64(p.config.MaxConnections) {
-			_ = conn.Close()
+			p.logger.Error("accept failed", "error", err)
 			continue
 		}
 
@@ -171,6 +298,9 @@ func (p *Proxy) handleConnection(conn net.Conn) {
 	defer p.wg.Done()
 
 	client := pgwire.NewClientConn(conn)
+	if p.tlsConfig != nil {
+		client.SetTLSConfig(p.tlsConfig)
+	}
 	p.connCount.Add(1)
 	defer func() {
 		p.connCount.Add(-1)
@@ -178,46 +308,98 @@ func (p *Proxy) handleConnection(conn net.Conn) {
 		_ = client.Close()
 	}()
 
-	// Perform handshake
-	if err := client.Handshake(p.Authenticate); err != nil {
-		fmt.Printf("handshake error: %v\n", err)
+	// Perform handshake. Its last step, CompleteHandshake, waits until the
+	// connection is routed: a passthrough connection first authenticates
+	// upstream, relaying any GSSAPI exchange to the client.
+	if err := client.Startup(); err != nil {
+		if errors.Is(err, pgwire.ErrTLSHandshake) {
+			p.logger.Warn("TLS handshake failed", "remote_ip", remoteIP(conn.RemoteAddr()), "reason", err)
+			return
+		}
+		// Also how TCP health checks look, so not worth more than debug
+		p.logger.Debug("handshake failed", "remote_addr", conn.RemoteAddr(), "error", err)
+		return
+	}
+	state, usesTLS := client.TLSState()
+	if p.config.MutualTLS && !usesTLS {
+		p.logger.Warn("TLS handshake failed", "remote_ip", remoteIP(conn.RemoteAddr()), "reason", "client did not request TLS")
+		_ = client.SendError("FATAL", pgwire.ErrCodeInvalidAuthorization, "client certificate required")
 		return
 	}
+	p.logger.Info("client connected", append([]any{
+		"remote_addr", conn.RemoteAddr(), "user", client.User(), "database", client.Database(),
+	}, tlsLogAttrs(state, usesTLS)...)...)
+	if p.maintenance.Load() {
+		_ = client.SendError("FATAL", pgwire.ErrCodeCannotConnectNow, maintenanceMessage)
+		return
+	}
+	notice, ok := p.acquireSlot(client)
+	if !ok {
+		return
+	}
+	defer p.releaseSlot()
+	if p.Authenticate != nil {
+		if err := client.Authenticate(p.Authenticate); err != nil {
+			p.logger.Warn("authentication failed",
+				"remote_addr", conn.RemoteAddr(), "user", client.User(), "database", client.Database(), "error", err)
+			return
+		}
+	}
 
 	// Resolve database to upstream (branch routing)
 	database := client.Database()
 	upstreamDB := database
 	if p.OnConnect != nil {
 		var err error
-		upstreamDB, err = p.OnConnect(database)
+		upstreamDB, err = p.OnConnect(database, client.RemoteAddr())
 		if err != nil {
-			_ = client.SendError("FATAL", pgwire.ErrCodeInvalidCatalogName, err.Error())
+			code := pgwire.ErrCodeInvalidCatalogName
+			if errors.Is(err, branch.ErrHostNotAllowed) {
+				code = pgwire.ErrCodeInvalidAuthorization
+			}
+			p.logger.Warn("connection rejected", "remote_addr", conn.RemoteAddr(), "database", database, "error", err)
+			_ = client.SendError("FATAL", code, err.Error())
 			return
 		}
 	}
 
-	// If Router is set and this is a non-main branch, use the CoW router
-	if p.Router != nil && router.IsBranchRouted(database) {
+	// If Router is set and this is a non-main branch, use the CoW router. A
+	// read-only router takes main as well, to check its queries.
+	if p.Router != nil && (router.IsBranchRouted(database) || p.Router.ReadOnly) {
+		branchName := database
+		if router.IsPassthroughBranch(branchName) {
+			branchName = "main"
+		}
 		session := &clientSession{
 			client: client,
 			branch: database,
 		}
 		p.connections.Store(client.ID(), session)
 
-		if err := p.Router.HandleSession(p.ctx, client, database); err != nil {
-			// Connection closed or error — normal termination
-			_ = err
+		if err := client.CompleteHandshake(); err != nil {
+			return
+		}
+		sendSlotNotice(client, notice)
+		p.logger.Debug("session started", "remote_addr", conn.RemoteAddr(), "branch", branchName)
+		if err := p.Router.HandleSession(p.ctx, client, branchName); err != nil {
+			// Usually just the client going away
+			p.logger.Debug("session ended", "remote_addr", conn.RemoteAddr(), "branch", branchName, "error", err)
 		}
 		return
 	}
 
 	// Main branch or no router: raw TCP passthrough
-	upstream, err := p.connectUpstream(upstreamDB, client.User())
+	upstream, err := p.connectUpstream(upstreamDB, client)
 	if err != nil {
+		p.logger.Error("upstream connection failed", "database", upstreamDB, "error", err)
 		_ = client.SendError("FATAL", pgwire.ErrCodeConnectionFailure, fmt.Sprintf("upstream connection failed: %v", err))
 		return
 	}
 	defer func() { _ = upstream.Close() }()
+	if err := client.CompleteHandshake(); err != nil {
+		return
+	}
+	sendSlotNotice(client, notice)
 
 	// Track session
 	session := &clientSession{
@@ -231,22 +413,81 @@ func (p *Proxy) handleConnection(conn net.Conn) {
 	p.proxyTraffic(client, upstream)
 }
 
-func (p *Proxy) connectUpstream(database, user string) (net.Conn, error) {
+// acquireSlot takes one of the MaxConnections slots for client, waiting up
+// to ConnectTimeout for one to free up. Clients still without a slot when
+// the timeout elapses are sent a FATAL "too many connections" error
+// (SQLSTATE 53300). It reports whether a slot was taken, which the caller
+// must give back with releaseSlot. For clients arriving with 90% or more of
+// the slots taken, it also returns a warning for sendSlotNotice: clients
+// only accept a NOTICE once they're authenticated.
+func (p *Proxy) acquireSlot(client *pgwire.ClientConn) (notice string, ok bool) {
+	if p.slots == nil {
+		return "", true
+	}
+
+	limit := cap(p.slots)
+	if inUse := len(p.slots); inUse >= limit-limit/10 {
+		p.logger.Info("near connection limit", "remote_addr", client.RemoteAddr(), "in_use", inUse, "max_connections", limit)
+		notice = fmt.Sprintf("server is near its connection limit (%d of %d connections in use)", inUse, limit)
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+		return notice, true
+	default:
+	}
+
+	timer := time.NewTimer(p.config.ConnectTimeout)
+	defer timer.Stop()
+	select {
+	case p.slots <- struct{}{}:
+		return notice, true
+	case <-timer.C:
+		p.logger.Warn("connection limit reached", "remote_addr", client.RemoteAddr(), "max_connections", limit)
+		_ = client.SendError("FATAL", pgwire.ErrCodeTooManyConnections, tooManyConnectionsMessage)
+		return "", false
+	case <-p.ctx.Done():
+		return "", false
+	}
+}
+
+// sendSlotNotice sends the warning acquireSlot returned, if any, to a client
+// that has completed its handshake.
+func sendSlotNotice(client *pgwire.ClientConn, notice string) {
+	if notice != "" {
+		_ = client.SendNotice("NOTICE", pgwire.ErrCodeWarning, notice)
+	}
+}
+
+// releaseSlot gives back a slot taken by acquireSlot.
+func (p *Proxy) releaseSlot() {
+	if p.slots != nil {
+		<-p.slots
+	}
+}
+
+// connectUpstream opens and authenticates a passthrough connection for
+// client, which must not have completed its handshake yet.
+func (p *Proxy) connectUpstream(database string, client *pgwire.ClientConn) (net.Conn, error) {
 	// Connect to upstream Postgres
-	conn, err := net.DialTimeout("tcp", p.config.UpstreamAddr, p.config.ConnectTimeout)
+	addr := p.config.UpstreamAddr
+	if p.UpstreamAddr != nil {
+		addr = p.UpstreamAddr()
+	}
+	conn, err := net.DialTimeout("tcp", addr, p.config.ConnectTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("dial upstream: %w", err)
 	}
 
 	// Send startup message
-	startup := buildStartupMessage(database, user, p.config.UpstreamUser)
+	startup := buildStartupMessage(database, client.User(), p.config.UpstreamUser)
 	if _, err := conn.Write(startup); err != nil {
 		_ = conn.Close()
 		return nil, fmt.Errorf("send startup: %w", err)
 	}
 
 	// Handle authentication
-	if err := p.handleUpstreamAuth(conn); err != nil {
+	if err := p.handleUpstreamAuth(conn, client); err != nil {
 		_ = conn.Close()
 		return nil, fmt.Errorf("upstream auth: %w", err)
 	}
@@ -255,7 +496,7 @@ func (p *Proxy) connectUpstream(database, user string) (net.Conn, error) {
 }
 
 func buildStartupMessage(database, clientUser, upstreamUser string) []byte {
-	buf := pgwire.NewBuffer(256)
+	buf := pgwire.NewBuffer(256, false)
 
 	// Placeholder for length (will be filled in)
 	buf.WriteInt32(0)
@@ -292,7 +533,18 @@ func buildStartupMessage(database, clientUser, upstreamUser string) []byte {
 	return data
 }
 
-func (p *Proxy) handleUpstreamAuth(conn net.Conn) error {
+// handleUpstreamAuth answers the upstream's authentication requests.
+// Password methods use the configured upstream credentials; GSSAPI requests
+// are relayed to client, whose tokens are passed back to the upstream, so
+// rift needn't be a Kerberos principal itself.
+func (p *Proxy) handleUpstreamAuth(conn net.Conn, client *pgwire.ClientConn) error {
+	var relay *gssRelay
+	defer func() {
+		if relay != nil {
+			relay.stop()
+		}
+	}()
+
 	for {
 		msgType, payload, err := pgwire.ReadMessage(conn)
 		if err != nil {
@@ -313,7 +565,7 @@ func (p *Proxy) handleUpstreamAuth(conn net.Conn) error {
 
 			case pgwire.AuthCleartextPassword:
 				// Send password
-				passBuf := pgwire.NewBuffer(64)
+				passBuf := pgwire.NewBuffer(64, false)
 				passBuf.WriteString(p.config.UpstreamPass)
 				if err := pgwire.WriteMessage(conn, pgwire.MsgPassword, passBuf.Bytes()); err != nil {
 					return err
@@ -327,12 +579,20 @@ func (p *Proxy) handleUpstreamAuth(conn net.Conn) error {
 				copy(salt[:], payload[4:8])
 				hash := pgwire.MD5Password(p.config.UpstreamUser, p.config.UpstreamPass, salt)
 
-				passBuf := pgwire.NewBuffer(64)
+				passBuf := pgwire.NewBuffer(64, false)
 				passBuf.WriteString(hash)
 				if err := pgwire.WriteMessage(conn, pgwire.MsgPassword, passBuf.Bytes()); err != nil {
 					return err
 				}
 
+			case pgwire.AuthGSS, pgwire.AuthGSSContinue:
+				if err := client.WriteMessage(pgwire.MsgAuthentication, payload); err != nil {
+					return err
+				}
+				if relay == nil {
+					relay = startGSSRelay(client, conn)
+				}
+
 			default:
 				return fmt.Errorf("%w: type %d", pgwire.ErrUnsupportedAuth, authType)
 			}
@@ -360,7 +620,7 @@ func (p *Proxy) handleUpstreamAuth(conn net.Conn) error {
 }
 
 func parseError(payload []byte) error {
-	buf := pgwire.NewBuffer(0)
+	buf := pgwire.NewBuffer(0, false)
 	buf.WriteBytes(payload) // Hacky way to set buf content
 	buf.SetPosition(0)
 
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riftdata/rift/internal/pgwire"
+)
+
+// startup connects to p and sends a startup message.
+func startup(t *testing.T, p *Proxy) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", p.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write(buildStartupMessage("dev", "alice", "")); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// readResponse reads one error or notice from conn and returns its code and
+// message.
+func readResponse(t *testing.T, conn net.Conn, want byte) (code, message string) {
+	t.Helper()
+	msgType, payload, err := pgwire.ReadMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != want {
+		t.Fatalf("got message %c, want %c", msgType, want)
+	}
+	for _, field := range strings.Split(string(payload), "\x00") {
+		switch {
+		case strings.HasPrefix(field, string(pgwire.FieldCode)):
+			code = field[1:]
+		case strings.HasPrefix(field, string(pgwire.FieldMessage)):
+			message = field[1:]
+		}
+	}
+	return code, message
+}
+
+// connectError connects to p and returns the error the proxy answers the
+// startup message with.
+func connectError(t *testing.T, p *Proxy) (code, message string) {
+	t.Helper()
+	return readResponse(t, startup(t, p), pgwire.MsgErrorResponse)
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ListenAddr = "127.0.0.1:0"
+	cfg.UpstreamAddr = "127.0.0.1:1"
+	cfg.ConnectTimeout = time.Second
+	cfg.MaintenanceMode = true
+	p := New(cfg)
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Stop() })
+
+	if !p.Maintenance() {
+		t.Fatal("Maintenance() = false with Config.MaintenanceMode set")
+	}
+	if code, msg := connectError(t, p); code != pgwire.ErrCodeCannotConnectNow || msg != maintenanceMessage {
+		t.Errorf("in maintenance mode got %s %q, want %s %q", code, msg, pgwire.ErrCodeCannotConnectNow, maintenanceMessage)
+	}
+
+	// Out of maintenance mode the connection gets as far as the upstream,
+	// which isn't listening
+	p.SetMaintenance(false)
+	if code, _ := connectError(t, p); code != pgwire.ErrCodeConnectionFailure {
+		t.Errorf("out of maintenance mode got %s, want %s", code, pgwire.ErrCodeConnectionFailure)
+	}
+}
+
+func TestConnectionLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ListenAddr = "127.0.0.1:0"
+	cfg.MaxConnections = 1
+	cfg.ConnectTimeout = 200 * time.Millisecond
+	p := New(cfg)
+	// Clients are asked for a password they never send, so they hold
+	// their slot until they disconnect
+	p.Authenticate = func(user, database, password string) error { return nil }
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Stop() })
+
+	first := startup(t, p)
+	if msgType, _, err := pgwire.ReadMessage(first); err != nil || msgType != pgwire.MsgAuthentication {
+		t.Fatalf("first client got %c, %v; want a password request", msgType, err)
+	}
+
+	// Nothing but the error comes before authentication, which clients
+	// would reject
+	second := startup(t, p)
+	if code, msg := readResponse(t, second, pgwire.MsgErrorResponse); code != pgwire.ErrCodeTooManyConnections || msg != tooManyConnectionsMessage {
+		t.Errorf("queued client got %s %q, want %s %q", code, msg, pgwire.ErrCodeTooManyConnections, tooManyConnectionsMessage)
+	}
+
+	// A queued client gets the slot once the first one disconnects
+	third := startup(t, p)
+	_ = first.Close()
+	if msgType, _, err := pgwire.ReadMessage(third); err != nil || msgType != pgwire.MsgAuthentication {
+		t.Fatalf("third client got %c, %v; want a password request", msgType, err)
+	}
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+)
+
+// loadTLSConfig builds the TLS configuration clients are served with, or
+// returns nil if TLS isn't configured.
+func loadTLSConfig(config *Config) (*tls.Config, error) {
+	if config.TLSCertFile == "" && config.TLSKeyFile == "" {
+		if config.MutualTLS {
+			return nil, fmt.Errorf("mutual TLS needs a TLS certificate and key")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if !config.MutualTLS {
+		return tlsConfig, nil
+	}
+
+	if config.ClientCAFile == "" {
+		return nil, fmt.Errorf("mutual TLS needs a client CA file")
+	}
+	pem, err := os.ReadFile(config.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("client CA file %s has no PEM certificates", config.ClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig, nil
+}
+
+// tlsLogAttrs returns the log attributes describing a client's TLS session:
+// its version and cipher suite, and the subject CN and SHA-256 fingerprint
+// of the client certificate if one was presented.
+func tlsLogAttrs(state tls.ConnectionState, usesTLS bool) []any {
+	if !usesTLS {
+		return []any{"tls", false}
+	}
+	attrs := []any{
+		"tls", true,
+		"tls_version", tls.VersionName(state.Version),
+		"cipher_suite", tls.CipherSuiteName(state.CipherSuite),
+	}
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		attrs = append(attrs,
+			"client_cn", cert.Subject.CommonName,
+			"client_cert_sha256", certFingerprint(cert))
+	}
+	return attrs
+}
+
+// certFingerprint returns the hex SHA-256 digest of a certificate's DER
+// encoding, as openssl x509 -fingerprint -sha256 shows it without colons.
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// remoteIP returns the IP part of a client's address.
+func remoteIP(addr net.Addr) string {
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
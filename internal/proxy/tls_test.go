@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/riftdata/rift/internal/pgwire"
+)
+
+// syncBuffer is a bytes.Buffer safe for the proxy's goroutines to log to.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newCert creates a certificate for cn signed by parent (self-signed when
+// parent is nil).
+func newCert(t *testing.T, cn string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, any(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writePEM writes cert and its key to PEM files in dir.
+func writePEM(t *testing.T, dir, name string, cert tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// startMutualTLS starts a proxy in maintenance mode requiring client
+// certificates signed by ca, and returns it with its log.
+func startMutualTLS(t *testing.T, ca tls.Certificate) (*Proxy, *syncBuffer) {
+	t.Helper()
+	dir := t.TempDir()
+	caFile, _ := writePEM(t, dir, "ca", ca)
+	certFile, keyFile := writePEM(t, dir, "server", newCert(t, "localhost", &ca))
+
+	logs := &syncBuffer{}
+	cfg := DefaultConfig()
+	cfg.ListenAddr = "127.0.0.1:0"
+	cfg.MaintenanceMode = true
+	cfg.TLSCertFile = certFile
+	cfg.TLSKeyFile = keyFile
+	cfg.MutualTLS = true
+	cfg.ClientCAFile = caFile
+	cfg.Logger = slog.New(slog.NewTextHandler(logs, nil))
+	p := New(cfg)
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Stop() })
+	return p, logs
+}
+
+// dialTLS connects to p, negotiates SSL and performs a TLS handshake,
+// presenting clientCerts.
+func dialTLS(t *testing.T, p *Proxy, ca tls.Certificate, clientCerts ...tls.Certificate) (*tls.Conn, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", p.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	sslRequest := make([]byte, 8)
+	binary.BigEndian.PutUint32(sslRequest[0:4], 8)
+	binary.BigEndian.PutUint32(sslRequest[4:8], pgwire.SSLRequestCode)
+	if _, err := conn.Write(sslRequest); err != nil {
+		t.Fatal(err)
+	}
+	answer := make([]byte, 1)
+	if _, err := conn.Read(answer); err != nil || answer[0] != 'S' {
+		t.Fatalf("SSLRequest answered %q, %v; want S", answer, err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	tlsConn := tls.Client(conn, &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: clientCerts})
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	// With TLS 1.3 the server checks the client certificate after the
+	// client's side of the handshake is done, so send the startup message
+	// to find out
+	if _, err := tlsConn.Write(buildStartupMessage("dev", "alice", "")); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newCert(t, "rift test CA", nil)
+	client := newCert(t, "ci-runner", &ca)
+	p, logs := startMutualTLS(t, ca)
+
+	conn, err := dialTLS(t, p, ca, client)
+	if err != nil {
+		t.Fatalf("TLS connection with a client certificate: %v", err)
+	}
+	// Maintenance mode turns the client away once it is logged
+	if code, _ := readResponse(t, conn, pgwire.MsgErrorResponse); code != pgwire.ErrCodeCannotConnectNow {
+		t.Errorf("got %s, want %s", code, pgwire.ErrCodeCannotConnectNow)
+	}
+	log := logs.String()
+	for _, want := range []string{
+		"msg=\"client connected\"",
+		"tls=true",
+		"tls_version=\"TLS 1.",
+		"cipher_suite=TLS_",
+		"client_cn=ci-runner",
+		"client_cert_sha256=" + certFingerprint(client.Leaf),
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("log %q does not contain %q", log, want)
+		}
+	}
+}
+
+func TestMutualTLSRejected(t *testing.T) {
+	ca := newCert(t, "rift test CA", nil)
+	p, logs := startMutualTLS(t, ca)
+
+	// No client certificate
+	if conn, err := dialTLS(t, p, ca); err == nil {
+		if _, _, err := pgwire.ReadMessage(conn); err == nil {
+			t.Fatal("client without a certificate was served")
+		}
+	}
+	// A certificate from another CA
+	other := newCert(t, "other CA", nil)
+	if conn, err := dialTLS(t, p, ca, newCert(t, "intruder", &other)); err == nil {
+		if _, _, err := pgwire.ReadMessage(conn); err == nil {
+			t.Fatal("client with an unknown certificate was served")
+		}
+	}
+	// No TLS at all
+	if code, _ := connectError(t, p); code != pgwire.ErrCodeInvalidAuthorization {
+		t.Errorf("client without TLS got %s, want %s", code, pgwire.ErrCodeInvalidAuthorization)
+	}
+
+	log := logs.String()
+	if n := strings.Count(log, "msg=\"TLS handshake failed\" remote_ip=127.0.0.1 reason="); n != 3 {
+		t.Errorf("log has %d failed handshakes, want 3:\n%s", n, log)
+	}
+	if strings.Contains(log, "client connected") {
+		t.Errorf("rejected clients were logged as connected:\n%s", log)
+	}
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	if cfg, err := loadTLSConfig(DefaultConfig()); cfg != nil || err != nil {
+		t.Errorf("without TLS settings got %v, %v; want nil, nil", cfg, err)
+	}
+	if _, err := loadTLSConfig(&Config{MutualTLS: true}); err == nil {
+		t.Error("mutual TLS without a certificate was accepted")
+	}
+	if _, err := loadTLSConfig(&Config{TLSCertFile: "missing.crt", TLSKeyFile: "missing.key"}); err == nil {
+		t.Error("missing certificate files were accepted")
+	}
+}
@@ -0,0 +1,62 @@
+package router
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// activityInterval is how often at most a branch's last_query_at is
+// written, so a busy branch doesn't cost a metadata write per query.
+const activityInterval = time.Minute
+
+// activityTracker records when branches are queried in their last_query_at.
+// It is safe for concurrent use.
+type activityTracker struct {
+	store  storage.Store
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	written map[string]time.Time // branch -> time last written
+
+	wg  sync.WaitGroup
+	now func() time.Time // for tests
+}
+
+func newActivityTracker(store storage.Store, logger *slog.Logger) *activityTracker {
+	return &activityTracker{
+		store:   store,
+		logger:  logger,
+		written: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// touch records that branchName was queried now, unless it was recorded less
+// than activityInterval ago. The write happens in the background.
+func (a *activityTracker) touch(branchName string) {
+	now := a.now()
+	a.mu.Lock()
+	if last, ok := a.written[branchName]; ok && now.Sub(last) < activityInterval {
+		a.mu.Unlock()
+		return
+	}
+	a.written[branchName] = now
+	a.mu.Unlock()
+
+	a.wg.Go(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := a.store.SetBranchLastQueryAt(ctx, branchName, now); err != nil {
+			a.logger.Debug("recording branch activity failed", "branch", branchName, "error", err)
+		}
+	})
+}
+
+// wait waits for the writes in progress.
+func (a *activityTracker) wait() {
+	a.wg.Wait()
+}
@@ -0,0 +1,183 @@
+package router
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/riftdata/rift/internal/pgwire"
+)
+
+// errResultFormats is returned when a Bind's result format codes don't match
+// the query's columns.
+var errResultFormats = errors.New("invalid result format codes")
+
+// postgresEpoch is the zero point of Postgres's binary timestamp and date
+// formats.
+var postgresEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// binaryEncoders maps the OIDs rift can send in binary format to an encoder
+// for the Go value pgx decodes the column to. Columns of other types are sent
+// as text even when the client asks for binary; RowDescription tells it so.
+var binaryEncoders = map[uint32]func(v interface{}) ([]byte, bool){
+	pgtype.BoolOID:        encodeBinaryBool,
+	pgtype.Int2OID:        encodeBinaryInt,
+	pgtype.Int4OID:        encodeBinaryInt,
+	pgtype.Int8OID:        encodeBinaryInt,
+	pgtype.Float4OID:      encodeBinaryFloat,
+	pgtype.Float8OID:      encodeBinaryFloat,
+	pgtype.TextOID:        encodeBinaryText,
+	pgtype.VarcharOID:     encodeBinaryText,
+	pgtype.ByteaOID:       encodeBinaryBytea,
+	pgtype.UUIDOID:        encodeBinaryUUID,
+	pgtype.TimestampOID:   encodeBinaryTimestamp,
+	pgtype.TimestamptzOID: encodeBinaryTimestamp,
+	pgtype.DateOID:        encodeBinaryDate,
+}
+
+// columnFormats returns the format code of each column, from the result
+// format codes of a Bind: none means all text, one applies to every column,
+// otherwise there is one per column. Binary is downgraded to text for types
+// without a binary encoder.
+func columnFormats(requested []int16, fields []pgconn.FieldDescription) ([]int16, error) {
+	if len(requested) > 1 && len(requested) != len(fields) {
+		return nil, fmt.Errorf("%w: bind message has %d result formats but query has %d columns",
+			errResultFormats, len(requested), len(fields))
+	}
+
+	formats := make([]int16, len(fields))
+	for i, f := range fields {
+		var fc int16
+		switch len(requested) {
+		case 0:
+			fc = pgwire.FormatText
+		case 1:
+			fc = requested[0]
+		default:
+			fc = requested[i]
+		}
+		if _, ok := binaryEncoders[f.DataTypeOID]; fc == pgwire.FormatBinary && !ok {
+			fc = pgwire.FormatText
+		}
+		formats[i] = fc
+	}
+	return formats, nil
+}
+
+// hasBinaryFormat reports whether any of formats is binary.
+func hasBinaryFormat(formats []int16) bool {
+	for _, fc := range formats {
+		if fc == pgwire.FormatBinary {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeBinary converts a value to its Postgres binary wire representation.
+func encodeBinary(v interface{}, oid uint32) ([]byte, error) {
+	enc, ok := binaryEncoders[oid]
+	if !ok {
+		return nil, fmt.Errorf("no binary encoding for type OID %d", oid)
+	}
+	b, ok := enc(v)
+	if !ok {
+		return nil, fmt.Errorf("cannot encode %T as binary type OID %d", v, oid)
+	}
+	return b, nil
+}
+
+func encodeBinaryBool(v interface{}) ([]byte, bool) {
+	b, ok := v.(bool)
+	if !ok {
+		return nil, false
+	}
+	if b {
+		return []byte{1}, true
+	}
+	return []byte{0}, true
+}
+
+func encodeBinaryInt(v interface{}) ([]byte, bool) {
+	switch val := v.(type) {
+	case int16:
+		return binary.BigEndian.AppendUint16(nil, uint16(val)), true // #nosec G115 -- two's complement bit pattern
+	case int32:
+		return binary.BigEndian.AppendUint32(nil, uint32(val)), true // #nosec G115 -- two's complement bit pattern
+	case int64:
+		return binary.BigEndian.AppendUint64(nil, uint64(val)), true // #nosec G115 -- two's complement bit pattern
+	}
+	return nil, false
+}
+
+func encodeBinaryFloat(v interface{}) ([]byte, bool) {
+	switch val := v.(type) {
+	case float32:
+		return binary.BigEndian.AppendUint32(nil, math.Float32bits(val)), true
+	case float64:
+		return binary.BigEndian.AppendUint64(nil, math.Float64bits(val)), true
+	}
+	return nil, false
+}
+
+func encodeBinaryText(v interface{}) ([]byte, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, false
+	}
+	return []byte(s), true
+}
+
+func encodeBinaryBytea(v interface{}) ([]byte, bool) {
+	b, ok := v.([]byte)
+	return b, ok
+}
+
+func encodeBinaryUUID(v interface{}) ([]byte, bool) {
+	u, ok := v.([16]byte)
+	if !ok {
+		return nil, false
+	}
+	return u[:], true
+}
+
+// encodeBinaryTimestamp encodes microseconds since 2000-01-01. pgx decodes
+// timestamp (without time zone) columns as UTC, so both types share it.
+func encodeBinaryTimestamp(v interface{}) ([]byte, bool) {
+	switch v {
+	case pgtype.Infinity:
+		return binary.BigEndian.AppendUint64(nil, math.MaxInt64), true
+	case pgtype.NegativeInfinity:
+		return binary.BigEndian.AppendUint64(nil, 1<<63), true // math.MinInt64
+	}
+	t, ok := v.(time.Time)
+	if !ok {
+		return nil, false
+	}
+	micros := t.UnixMicro() - postgresEpoch.UnixMicro()
+	return binary.BigEndian.AppendUint64(nil, uint64(micros)), true // #nosec G115 -- two's complement bit pattern
+}
+
+// encodeBinaryDate encodes days since 2000-01-01.
+func encodeBinaryDate(v interface{}) ([]byte, bool) {
+	switch v {
+	case pgtype.Infinity:
+		return binary.BigEndian.AppendUint32(nil, math.MaxInt32), true
+	case pgtype.NegativeInfinity:
+		return binary.BigEndian.AppendUint32(nil, 1<<31), true // math.MinInt32
+	}
+	t, ok := v.(time.Time)
+	if !ok {
+		return nil, false
+	}
+	secs := t.Unix() - postgresEpoch.Unix()
+	days := secs / 86400
+	if secs%86400 < 0 {
+		days-- // round toward the earlier day
+	}
+	return binary.BigEndian.AppendUint32(nil, uint32(int32(days))), true // #nosec G115 -- Postgres dates fit in int32
+}
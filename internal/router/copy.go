@@ -0,0 +1,136 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/riftdata/rift/internal/cow"
+	"github.com/riftdata/rift/internal/pgwire"
+)
+
+var (
+	// errCopyFailed is returned when the client aborts a COPY with CopyFail.
+	errCopyFailed = errors.New("COPY from stdin failed")
+
+	// errCopyProtocol is returned for a message a client may not send during
+	// COPY.
+	errCopyProtocol = errors.New("unexpected message during COPY from stdin")
+
+	// errExtendedCopy is returned for COPY FROM STDIN sent with the extended
+	// query protocol.
+	errExtendedCopy = errors.New("COPY FROM STDIN is only supported with the simple query protocol")
+)
+
+// handleCopyIn runs a COPY ... FROM STDIN on the branch: it asks the client
+// for the data and loads it into the overlay, in the session's transaction or
+// in one of its own. Like runSimpleStatement, it returns false if the COPY
+// failed and the error was sent to the client.
+func (s *Session) handleCopyIn(ctx context.Context, pq *cow.ProcessedQuery) (bool, error) {
+	start := time.Now()
+	defer func() {
+		s.queryLog.Log(s.branchName, pq.OriginalSQL, pq.RewrittenSQL, time.Since(start))
+	}()
+
+	if err := s.client.WriteMessage(pgwire.MsgCopyInResponse,
+		pgwire.BuildCopyInResponse(len(pq.CopyIn.Columns))); err != nil {
+		return false, err
+	}
+
+	in := &copyInReader{client: s.client}
+	n, err := s.loadCopy(ctx, pq.CopyIn, in)
+
+	// The data may end before CopyDone (at a \. line) or the load may fail
+	// part way; either way the rest of the client's data is discarded.
+	if derr := in.drain(); derr != nil {
+		return false, derr
+	}
+	if err != nil {
+		if s.txStatus == pgwire.TxStatusInTx {
+			s.txStatus = pgwire.TxStatusFailed
+		}
+		s.sendError(err)
+		return false, nil
+	}
+
+	return true, s.client.SendCommandComplete(fmt.Sprintf("COPY %d", n))
+}
+
+// loadCopy loads COPY data in the session's transaction, or in a transaction
+// of its own so that a failed COPY leaves no rows behind.
+func (s *Session) loadCopy(ctx context.Context, copyIn *cow.CopyIn, r io.Reader) (int64, error) {
+	if s.tx != nil {
+		return copyIn.Load(ctx, s.tx, r)
+	}
+
+	tx, err := s.lb.Primary().Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	n, err := copyIn.Load(ctx, tx, r)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit(ctx)
+}
+
+// copyInReader reads the payloads of the client's CopyData messages until
+// CopyDone.
+type copyInReader struct {
+	client *pgwire.ClientConn
+	buf    []byte
+	done   bool
+
+	// connErr is a read error from the client connection, after which the
+	// session can't continue.
+	connErr error
+}
+
+func (r *copyInReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		msgType, payload, err := r.client.ReadMessage()
+		if err != nil {
+			r.done, r.connErr = true, err
+			return 0, err
+		}
+		switch msgType {
+		case pgwire.MsgCopyData:
+			r.buf = payload
+		case pgwire.MsgCopyDone:
+			r.done = true
+		case pgwire.MsgCopyFail:
+			r.done = true
+			return 0, fmt.Errorf("%w: %s", errCopyFailed, strings.TrimSuffix(string(payload), "\x00"))
+		case pgwire.MsgFlush, pgwire.MsgSync:
+			// Ignored during COPY, as Postgres does
+		default:
+			r.done = true
+			return 0, fmt.Errorf("%w: type %q", errCopyProtocol, msgType)
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// drain discards the client's remaining COPY messages. It returns an error
+// only if the connection failed.
+func (r *copyInReader) drain() error {
+	for !r.done {
+		r.buf = nil
+		if _, err := r.Read(nil); err != nil {
+			break
+		}
+	}
+	return r.connErr
+}
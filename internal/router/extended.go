@@ -1,15 +1,22 @@
 package router
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/riftdata/rift/internal/cow"
 	"github.com/riftdata/rift/internal/parser"
 	"github.com/riftdata/rift/internal/pgwire"
 )
 
+// errMalformedMessage is returned for a Bind or FunctionCall message whose
+// counts don't fit its payload.
+var errMalformedMessage = errors.New("malformed message")
+
 // preparedStmt holds a parsed statement waiting for binding.
 type preparedStmt struct {
 	name      string
@@ -22,6 +29,10 @@ type portal struct {
 	name      string
 	stmt      *preparedStmt
 	paramVals [][]byte
+
+	// resultFormats holds the result format codes from Bind: none for all
+	// text, one for every column, or one per column.
+	resultFormats []int16
 }
 
 // extendedState tracks Parse/Bind/Execute state per session.
@@ -40,7 +51,8 @@ func newExtendedState() *extendedState {
 // handleParse processes a Parse ('P') message.
 // Format: name(string) query(string) numParamTypes(int16) paramTypes(int32[]...)
 func (s *Session) handleParse(ctx context.Context, payload []byte) error {
-	buf := pgwire.NewBuffer(0)
+	buf := pgwire.NewBuffer(len(payload), true)
+	defer pgwire.ReleaseBuffer(buf)
 	buf.WriteBytes(payload)
 	buf.SetPosition(0)
 
@@ -76,12 +88,20 @@ func (s *Session) handleParse(ctx context.Context, payload []byte) error {
 			IsPassthrough: true,
 		}
 	default:
-		processed, err = s.engine.ProcessQuery(ctx, s.branchName, sql)
+		if err := s.checkReadOnly(sql); err != nil {
+			s.extErr = err
+			return nil
+		}
+		processed, err = s.engine.ProcessSessionQuery(ctx, s.branchName, sql, s.searchPath())
 		if err != nil {
 			s.extErr = fmt.Errorf("parse query: %w", err)
 			// Don't send error yet — wait for Sync
 			return nil
 		}
+		if processed.CopyIn != nil {
+			s.extErr = errExtendedCopy
+			return nil
+		}
 	}
 
 	stmt := &preparedStmt{
@@ -101,7 +121,8 @@ func (s *Session) handleParse(ctx context.Context, payload []byte) error {
 //
 //	numParams(int16) paramValues(int32 len + bytes[]) numResultFormats(int16) resultFormats(int16[])
 func (s *Session) handleBind(_ context.Context, payload []byte) error {
-	buf := pgwire.NewBuffer(0)
+	buf := pgwire.NewBuffer(len(payload), true)
+	defer pgwire.ReleaseBuffer(buf)
 	buf.WriteBytes(payload)
 	buf.SetPosition(0)
 
@@ -121,23 +142,29 @@ func (s *Session) handleBind(_ context.Context, payload []byte) error {
 		return nil
 	}
 
-	if err := validateFormatCodes(buf, "parameter"); err != nil {
+	paramFormats, err := readFormatCodes(buf, "parameter")
+	if err != nil {
 		return err
 	}
+	if hasBinaryFormat(paramFormats) {
+		return fmt.Errorf("unsupported binary parameter format")
+	}
 
 	paramVals, err := readParamValues(buf)
 	if err != nil {
 		return err
 	}
 
-	if err := validateFormatCodes(buf, "result"); err != nil {
+	resultFormats, err := readFormatCodes(buf, "result")
+	if err != nil {
 		return err
 	}
 
 	p := &portal{
-		name:      portalName,
-		stmt:      stmt,
-		paramVals: paramVals,
+		name:          portalName,
+		stmt:          stmt,
+		paramVals:     paramVals,
+		resultFormats: resultFormats,
 	}
 	s.ext.portals[portalName] = p
 
@@ -145,44 +172,59 @@ func (s *Session) handleBind(_ context.Context, payload []byte) error {
 	return s.client.WriteMessage(pgwire.MsgBindComplete, nil)
 }
 
-// validateFormatCodes reads format codes from buf and rejects binary (non-zero) formats.
-func validateFormatCodes(buf *pgwire.Buffer, kind string) error {
+// readFormatCodes reads format codes from buf, rejecting codes other than
+// text (0) and binary (1), and counts the payload can't hold.
+func readFormatCodes(buf *pgwire.Buffer, kind string) ([]int16, error) {
 	count, err := buf.ReadInt16()
 	if err != nil {
-		return fmt.Errorf("read num %s formats: %w", kind, err)
+		return nil, fmt.Errorf("read num %s formats: %w", kind, err)
+	}
+	if count < 0 || int(count)*2 > buf.Remaining() {
+		return nil, fmt.Errorf("%w: %d %s formats in %d bytes", errMalformedMessage, count, kind, buf.Remaining())
 	}
-	for i := int16(0); i < count; i++ {
+	formats := make([]int16, count)
+	for i := range formats {
 		fc, err := buf.ReadInt16()
 		if err != nil {
-			return fmt.Errorf("read %s format code: %w", kind, err)
+			return nil, fmt.Errorf("read %s format code: %w", kind, err)
 		}
-		if fc != 0 {
-			return fmt.Errorf("unsupported binary %s format (code %d) at index %d", kind, fc, i)
+		if fc != pgwire.FormatText && fc != pgwire.FormatBinary {
+			return nil, fmt.Errorf("unsupported %s format (code %d) at index %d", kind, fc, i)
 		}
+		formats[i] = fc
 	}
-	return nil
+	return formats, nil
 }
 
-// readParamValues reads bind parameter values from buf.
+// readParamValues reads bind parameter values from buf, rejecting counts and
+// lengths the payload can't hold.
 func readParamValues(buf *pgwire.Buffer) ([][]byte, error) {
 	numParams, err := buf.ReadInt16()
 	if err != nil {
 		return nil, fmt.Errorf("read num params: %w", err)
 	}
+	// Every value has at least its 4-byte length
+	if numParams < 0 || int(numParams)*4 > buf.Remaining() {
+		return nil, fmt.Errorf("%w: %d params in %d bytes", errMalformedMessage, numParams, buf.Remaining())
+	}
 	vals := make([][]byte, numParams)
 	for i := int16(0); i < numParams; i++ {
 		length, err := buf.ReadInt32()
 		if err != nil {
 			return nil, fmt.Errorf("read param length: %w", err)
 		}
-		if length == -1 {
+		switch {
+		case length == -1:
 			vals[i] = nil // NULL
-		} else {
+		case length < 0 || int(length) > buf.Remaining():
+			return nil, fmt.Errorf("%w: param of %d bytes in %d", errMalformedMessage, length, buf.Remaining())
+		default:
 			val, err := buf.ReadBytes(int(length))
 			if err != nil {
 				return nil, fmt.Errorf("read param value: %w", err)
 			}
-			vals[i] = val
+			// The portal outlives buf, which goes back to the pool
+			vals[i] = bytes.Clone(val)
 		}
 	}
 	return vals, nil
@@ -197,7 +239,8 @@ func (s *Session) handleDescribe(_ context.Context, payload []byte) error {
 	}
 
 	descType := payload[0]
-	buf := pgwire.NewBuffer(0)
+	buf := pgwire.NewBuffer(len(payload), true)
+	defer pgwire.ReleaseBuffer(buf)
 	buf.WriteBytes(payload[1:])
 	buf.SetPosition(0)
 	name, _ := buf.ReadString()
@@ -211,7 +254,8 @@ func (s *Session) handleDescribe(_ context.Context, payload []byte) error {
 			return nil
 		}
 		// Send empty ParameterDescription (no params described)
-		paramBuf := pgwire.NewBuffer(4)
+		paramBuf := pgwire.NewBuffer(4, true)
+		defer pgwire.ReleaseBuffer(paramBuf)
 		paramBuf.WriteInt16(0) // zero parameters
 		if err := s.client.WriteMessage(pgwire.MsgParameterDescription, paramBuf.Bytes()); err != nil {
 			return err
@@ -238,7 +282,8 @@ func (s *Session) handleDescribe(_ context.Context, payload []byte) error {
 // handleExecute processes an Execute ('E') message.
 // Format: portal(string) maxRows(int32)
 func (s *Session) handleExecute(ctx context.Context, payload []byte) error {
-	buf := pgwire.NewBuffer(0)
+	buf := pgwire.NewBuffer(len(payload), true)
+	defer pgwire.ReleaseBuffer(buf)
 	buf.WriteBytes(payload)
 	buf.SetPosition(0)
 
@@ -268,7 +313,7 @@ func (s *Session) handleExecute(ctx context.Context, payload []byte) error {
 	sql := processed.RewrittenSQL
 	if sql == "" {
 		// Empty query
-		return pgwire.WriteMessage(s.client.NetConn(), pgwire.MsgEmptyQueryResponse, nil)
+		return s.client.WriteMessage(pgwire.MsgEmptyQueryResponse, nil)
 	}
 
 	// Handle transaction control
@@ -292,13 +337,23 @@ func (s *Session) handleExecute(ctx context.Context, payload []byte) error {
 		}
 	}
 
-	return s.executeExtStatements(ctx, processed, sql, args)
+	return s.executeExtStatements(ctx, processed, sql, args, p.resultFormats)
 }
 
 // executeExtStatements runs the statements for an extended protocol Execute.
 // Each statement is individually parsed/processed so that executeExtOne sees
 // the correct query type rather than the type of the full (possibly multi-statement) SQL.
-func (s *Session) executeExtStatements(ctx context.Context, processed *cow.ProcessedQuery, sql string, args []interface{}) error {
+func (s *Session) executeExtStatements(ctx context.Context, processed *cow.ProcessedQuery, sql string, args []interface{}, resultFormats []int16) error {
+	s.telemetry.RecordQuery(processed.Type.String())
+	if err := s.sendNotices(processed); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	defer func() {
+		s.queryLog.Log(s.branchName, processed.OriginalSQL, sql, time.Since(start))
+	}()
+
 	statements := splitStatements(sql)
 
 	// Fast path: single statement uses the already-computed ProcessedQuery.
@@ -307,7 +362,7 @@ func (s *Session) executeExtStatements(ctx context.Context, processed *cow.Proce
 		if stmt == "" {
 			return nil
 		}
-		return s.executeExtOne(ctx, processed, stmt, true, args)
+		return s.executeExtOne(ctx, processed, stmt, true, args, resultFormats)
 	}
 
 	for i, stmt := range statements {
@@ -317,14 +372,14 @@ func (s *Session) executeExtStatements(ctx context.Context, processed *cow.Proce
 		}
 
 		// Re-process each individual statement to get the correct query type.
-		stmtProcessed, err := s.engine.ProcessQuery(ctx, s.branchName, stmt)
+		stmtProcessed, err := s.engine.ProcessSessionQuery(ctx, s.branchName, stmt, s.searchPath())
 		if err != nil {
 			s.extErr = fmt.Errorf("process split statement: %w", err)
 			return nil
 		}
 
 		isLast := i == len(statements)-1
-		if err := s.executeExtOne(ctx, stmtProcessed, stmt, isLast, args); err != nil {
+		if err := s.executeExtOne(ctx, stmtProcessed, stmt, isLast, args, resultFormats); err != nil {
 			return err
 		}
 		args = nil // only the first statement gets params
@@ -332,10 +387,17 @@ func (s *Session) executeExtStatements(ctx context.Context, processed *cow.Proce
 	return nil
 }
 
-// executeExtOne runs a single statement within the extended protocol.
-func (s *Session) executeExtOne(ctx context.Context, processed *cow.ProcessedQuery, stmt string, isLast bool, args []interface{}) error {
-	if processed.Type == parser.QuerySelect && isLast {
-		rows, err := s.query(ctx, stmt, args...)
+// executeExtOne runs a single statement within the extended protocol. The
+// last statement's rows are sent in the formats the portal was bound with.
+func (s *Session) executeExtOne(ctx context.Context, processed *cow.ProcessedQuery, stmt string, isLast bool, args []interface{}, resultFormats []int16) error {
+	if processed.ReturnsRows() && isLast {
+		key, cacheable := s.resultCacheKey(processed, stmt, args)
+		// The result cache holds text rows only
+		cacheable = cacheable && !hasBinaryFormat(resultFormats)
+		if cached, ok := s.cachedResult(key, cacheable); ok {
+			return sendCachedResult(s.client, cached, processed.Type)
+		}
+		rows, err := s.query(ctx, processed, stmt, args...)
 		if err != nil {
 			if s.txStatus == pgwire.TxStatusInTx {
 				s.txStatus = pgwire.TxStatusFailed
@@ -343,7 +405,17 @@ func (s *Session) executeExtOne(ctx context.Context, processed *cow.ProcessedQue
 			s.extErr = err
 			return nil
 		}
-		return sendQueryResult(s.client, rows, "")
+		formats, err := columnFormats(resultFormats, rows.FieldDescriptions())
+		if err != nil {
+			// A failed query has no columns; report why it failed instead
+			rows.Close()
+			if rerr := rows.Err(); rerr != nil {
+				err = rerr
+			}
+			s.extErr = err
+			return nil
+		}
+		return s.sendResult(rows, processed.Type, formats, key, cacheable)
 	}
 
 	tag, err := s.runExec(ctx, stmt, args...)
@@ -354,6 +426,11 @@ func (s *Session) executeExtOne(ctx context.Context, processed *cow.ProcessedQue
 		s.extErr = err
 		return nil
 	}
+	if processed.Type == parser.QueryUtility {
+		if err := s.trackSet(stmt); err != nil {
+			return err
+		}
+	}
 	if isLast {
 		return s.client.SendCommandComplete(tag)
 	}
@@ -368,7 +445,8 @@ func (s *Session) handleClose(_ context.Context, payload []byte) error {
 	}
 
 	closeType := payload[0]
-	buf := pgwire.NewBuffer(0)
+	buf := pgwire.NewBuffer(len(payload), true)
+	defer pgwire.ReleaseBuffer(buf)
 	buf.WriteBytes(payload[1:])
 	buf.SetPosition(0)
 	name, _ := buf.ReadString()
@@ -386,7 +464,7 @@ func (s *Session) handleClose(_ context.Context, payload []byte) error {
 // handleSync processes a Sync ('S') message — ends the extended query cycle.
 func (s *Session) handleSync() error {
 	if s.extErr != nil {
-		_ = s.client.SendError("ERROR", pgwire.ErrCodeInternalError, s.extErr.Error())
+		s.sendError(s.extErr)
 		s.extErr = nil
 	}
 	return s.client.SendReadyForQuery(s.txStatus)
@@ -398,7 +476,7 @@ func (s *Session) handleExtBegin(ctx context.Context) error {
 	if s.tx != nil {
 		return s.client.SendCommandComplete("BEGIN")
 	}
-	tx, err := s.pool.Begin(ctx)
+	tx, err := s.lb.Primary().Begin(ctx)
 	if err != nil {
 		s.extErr = err
 		return nil
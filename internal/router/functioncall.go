@@ -0,0 +1,153 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/riftdata/rift/internal/pgwire"
+)
+
+var (
+	errUnknownFunction = errors.New("function does not exist")
+	// Volatile functions may write to tables directly, bypassing the
+	// branch's overlay, so only immutable and stable ones are called.
+	errVolatileFunction = errors.New("volatile functions can't be called with the function call protocol on a branch; call the function from a query instead")
+)
+
+// functionCall is a decoded FunctionCall ('F') message.
+type functionCall struct {
+	oid          uint32
+	argFormats   []int16 // one per argument
+	args         [][]byte
+	resultFormat int16
+}
+
+// parseFunctionCall decodes a FunctionCall message.
+// Format: oid(int32) numFormats(int16) formats(int16[]) numArgs(int16)
+//
+//	args(int32 len + bytes[]) resultFormat(int16)
+func parseFunctionCall(payload []byte) (*functionCall, error) {
+	buf := pgwire.NewBuffer(0, false)
+	buf.WriteBytes(payload)
+	buf.SetPosition(0)
+
+	oid, err := buf.ReadUint32()
+	if err != nil {
+		return nil, fmt.Errorf("read function oid: %w", err)
+	}
+
+	formats, err := readFormatCodes(buf, "argument")
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := readParamValues(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	resultFormat, err := buf.ReadInt16()
+	if err != nil {
+		return nil, fmt.Errorf("read result format: %w", err)
+	}
+
+	// No format codes means all text; a single one applies to every argument
+	argFormats := make([]int16, len(args))
+	switch len(formats) {
+	case 0:
+	case 1:
+		for i := range argFormats {
+			argFormats[i] = formats[0]
+		}
+	case len(args):
+		copy(argFormats, formats)
+	default:
+		return nil, fmt.Errorf("%w: %d argument formats for %d arguments", errMalformedMessage, len(formats), len(args))
+	}
+
+	return &functionCall{oid: oid, argFormats: argFormats, args: args, resultFormat: resultFormat}, nil
+}
+
+// handleFunctionCall processes a FunctionCall ('F') message, still sent by
+// some legacy drivers and tools, by running the function as a SELECT. The
+// arguments and result are passed through in the client's formats. A
+// malformed message is answered with a protocol violation error.
+func (s *Session) handleFunctionCall(ctx context.Context, payload []byte) error {
+	fc, err := parseFunctionCall(payload)
+	if errors.Is(err, errMalformedMessage) {
+		return s.sendQueryError(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := s.callFunction(ctx, fc)
+	if err != nil {
+		if s.txStatus == pgwire.TxStatusInTx {
+			s.txStatus = pgwire.TxStatusFailed
+		}
+		return s.sendQueryError(err)
+	}
+
+	if err := s.client.WriteMessage(pgwire.MsgFunctionCallResponse, pgwire.BuildFunctionCallResponse(result)); err != nil {
+		return err
+	}
+	return s.client.SendReadyForQuery(s.txStatus)
+}
+
+// callFunction looks up fc's function and calls it, returning its result
+// (nil for NULL).
+func (s *Session) callFunction(ctx context.Context, fc *functionCall) ([]byte, error) {
+	var name string
+	var argTypes []uint32
+	var volatility string
+	err := s.lb.Primary().QueryRow(ctx,
+		`SELECT p.oid::regproc::text, p.proargtypes::oid[], p.provolatile::text
+		 FROM pg_catalog.pg_proc p WHERE p.oid = $1 AND p.prokind = 'f' AND NOT p.proretset`,
+		fc.oid).Scan(&name, &argTypes, &volatility)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: OID %d", errUnknownFunction, fc.oid)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("look up function: %w", err)
+	}
+	if volatility == "v" {
+		return nil, fmt.Errorf("%s: %w", name, errVolatileFunction)
+	}
+	if len(fc.args) != len(argTypes) {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", name, len(argTypes), len(fc.args))
+	}
+
+	sql := "SELECT " + name + "("
+	for i := range fc.args {
+		if i > 0 {
+			sql += ", "
+		}
+		sql += fmt.Sprintf("$%d", i+1)
+	}
+	sql += ")"
+
+	var pgc *pgconn.PgConn
+	if s.tx != nil {
+		pgc = s.tx.Conn().PgConn()
+	} else {
+		conn, err := s.lb.Primary().Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Release()
+		pgc = conn.Conn().PgConn()
+	}
+
+	res := pgc.ExecParams(ctx, sql, fc.args, argTypes, fc.argFormats, []int16{fc.resultFormat}).Read()
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	if len(res.Rows) != 1 || len(res.Rows[0]) != 1 {
+		return nil, fmt.Errorf("%s returned %d rows", name, len(res.Rows))
+	}
+	return res.Rows[0][0], nil
+}
@@ -0,0 +1,33 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/riftdata/rift/internal/pgwire"
+)
+
+func FuzzParseFunctionCall(f *testing.F) {
+	valid := pgwire.NewBuffer(32, false)
+	valid.WriteUint32(1598)
+	valid.WriteInt16(1)
+	valid.WriteInt16(1)
+	valid.WriteInt16(2)
+	valid.WriteInt32(4)
+	valid.WriteInt32(42)
+	valid.WriteInt32(-1)
+	valid.WriteInt16(0)
+	f.Add(valid.Bytes())
+	f.Add([]byte{0, 0, 6, 62, 0xff, 0xff})
+	f.Add([]byte{0, 0, 6, 62, 0, 0, 0x80, 0})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		fc, err := parseFunctionCall(payload)
+		if err != nil {
+			return
+		}
+		if len(fc.argFormats) != len(fc.args) {
+			t.Fatalf("parseFunctionCall(%x): %d formats for %d arguments", payload, len(fc.argFormats), len(fc.args))
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package router
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// healthCheckTimeout bounds each replica's SELECT 1 in CheckHealth.
+const healthCheckTimeout = 5 * time.Second
+
+// LoadBalancer spreads a session's queries over the upstream nodes: writes,
+// and everything inside a transaction, go to the primary pool, and
+// replica-safe reads are sent round-robin to the healthy replicas. With no
+// healthy replica, reads fall back to the primary.
+type LoadBalancer struct {
+	primary  *pgxpool.Pool
+	replicas []*replica
+	next     *atomic.Uint64
+
+	// failover, if set, provides the primary pool in place of primary
+	failover *storage.UpstreamFailoverManager
+}
+
+// replica is one read replica's pool and its last health check result.
+type replica struct {
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+}
+
+// NewLoadBalancer creates a load balancer over a primary pool and any number
+// of replica pools. Replicas start out healthy; CheckHealth takes
+// unreachable ones out of rotation. The pools stay owned by the caller.
+func NewLoadBalancer(primary *pgxpool.Pool, replicas ...*pgxpool.Pool) *LoadBalancer {
+	lb := &LoadBalancer{primary: primary, next: new(atomic.Uint64)}
+	for _, pool := range replicas {
+		if pool == nil || pool == primary {
+			continue
+		}
+		r := &replica{pool: pool}
+		r.healthy.Store(true)
+		lb.replicas = append(lb.replicas, r)
+	}
+	return lb
+}
+
+// SetFailover makes the load balancer write to the pool m has in use
+// instead of the primary it was created with, and fail over through m when
+// that pool's upstream can't be reached. It must be called before the
+// load balancer is used.
+func (lb *LoadBalancer) SetFailover(m *storage.UpstreamFailoverManager) {
+	lb.failover = m
+}
+
+// withPrimary returns a load balancer that writes to primary and shares lb's
+// replicas, their health and the round-robin position. It doesn't fail over.
+func (lb *LoadBalancer) withPrimary(primary *pgxpool.Pool) *LoadBalancer {
+	return &LoadBalancer{primary: primary, replicas: lb.replicas, next: lb.next}
+}
+
+// Primary returns the pool for writes and transactions.
+func (lb *LoadBalancer) Primary() *pgxpool.Pool {
+	if lb.failover != nil {
+		return lb.failover.Pool()
+	}
+	return lb.primary
+}
+
+// failOver is called when a query on pool failed with err. If err means
+// the primary's upstream is unreachable, it fails over to the next upstream
+// and returns its pool.
+func (lb *LoadBalancer) failOver(ctx context.Context, pool *pgxpool.Pool, err error) (*pgxpool.Pool, bool) {
+	if lb.failover == nil || !storage.IsConnectionError(err) {
+		return nil, false
+	}
+	return lb.failover.Failover(ctx, pool)
+}
+
+// Read returns the pool for the next replica-safe read: the next healthy
+// replica in turn, or the primary if none is healthy.
+func (lb *LoadBalancer) Read() *pgxpool.Pool {
+	n := len(lb.replicas)
+	if n == 0 {
+		return lb.Primary()
+	}
+	start := lb.next.Add(1) - 1
+	for i := range n {
+		r := lb.replicas[(start+uint64(i))%uint64(n)]
+		if r.healthy.Load() {
+			return r.pool
+		}
+	}
+	return lb.Primary()
+}
+
+// Replicas returns the replica pools, in the order they were given.
+func (lb *LoadBalancer) Replicas() []*pgxpool.Pool {
+	pools := make([]*pgxpool.Pool, len(lb.replicas))
+	for i, r := range lb.replicas {
+		pools[i] = r.pool
+	}
+	return pools
+}
+
+// Healthy returns how many replicas are in rotation.
+func (lb *LoadBalancer) Healthy() int {
+	n := 0
+	for _, r := range lb.replicas {
+		if r.healthy.Load() {
+			n++
+		}
+	}
+	return n
+}
+
+// CheckHealth runs SELECT 1 on every replica, concurrently and with a
+// timeout of five seconds each, taking replicas that fail out of rotation
+// and putting ones that succeed back in.
+func (lb *LoadBalancer) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range lb.replicas {
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+			var one int
+			err := r.pool.QueryRow(ctx, "SELECT 1").Scan(&one)
+			r.healthy.Store(err == nil)
+		})
+	}
+	wg.Wait()
+}
+
+// RunHealthChecks runs CheckHealth every interval until ctx is done.
+func (lb *LoadBalancer) RunHealthChecks(ctx context.Context, interval time.Duration) {
+	if len(lb.replicas) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		lb.CheckHealth(ctx)
+	}
+}
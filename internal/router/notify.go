@@ -0,0 +1,120 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/riftdata/rift/internal/parser"
+)
+
+// listener holds the dedicated upstream connection used for a session's
+// LISTEN channels. Pooled connections can't be used because notifications are
+// delivered to the connection that issued LISTEN.
+type listener struct {
+	conn   *pgxpool.Conn
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// start waits for notifications in the background and passes each one to deliver.
+func (l *listener) start(deliver func(*pgconn.Notification)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	l.done = make(chan struct{})
+
+	go func() {
+		defer close(l.done)
+		for {
+			n, err := l.conn.Conn().WaitForNotification(ctx)
+			if err != nil {
+				return
+			}
+			deliver(n)
+		}
+	}()
+}
+
+// stop ends the background wait so the connection can run statements again.
+func (l *listener) stop() {
+	if l.cancel == nil {
+		return
+	}
+	l.cancel()
+	<-l.done
+	l.cancel = nil
+}
+
+// isListenStatement reports whether sql is a LISTEN or UNLISTEN statement.
+// NOTIFY is not included; it runs upstream like any other statement.
+func isListenStatement(sql string) bool {
+	upper := strings.ToUpper(strings.TrimSpace(sql))
+	if !strings.HasPrefix(upper, "LISTEN") && !strings.HasPrefix(upper, "UNLISTEN") {
+		return false
+	}
+	pq, err := parser.Parse(sql)
+	if err != nil {
+		return false
+	}
+	info := parser.ExtractListenInfo(pq)
+	return info != nil && info.Kind != parser.ListenNotify
+}
+
+// handleListen runs LISTEN/UNLISTEN on the session's dedicated listener
+// connection, acquiring it on first use, and returns the command tag.
+func (s *Session) handleListen(ctx context.Context, sql string) (string, error) {
+	if s.listen == nil {
+		conn, err := s.lb.Primary().Acquire(ctx)
+		if err != nil {
+			return "", err
+		}
+		s.listen = &listener{conn: conn}
+	} else {
+		s.listen.stop()
+	}
+
+	tag, err := s.listen.conn.Exec(ctx, sql)
+	s.listen.start(s.deliverNotification)
+	return tag.String(), err
+}
+
+// closeListener unsubscribes from all channels and returns the listener
+// connection to the pool.
+func (s *Session) closeListener(ctx context.Context) {
+	if s.listen == nil {
+		return
+	}
+	s.listen.stop()
+	if _, err := s.listen.conn.Exec(ctx, "UNLISTEN *"); err != nil {
+		// Don't hand a connection with unknown subscriptions back to the pool
+		_ = s.listen.conn.Conn().Close(ctx)
+	}
+	s.listen.conn.Release()
+	s.listen = nil
+}
+
+// deliverNotification forwards an upstream notification to the client,
+// tagged with the session's branch.
+func (s *Session) deliverNotification(n *pgconn.Notification) {
+	_ = s.client.SendNotification(int32(n.PID), n.Channel, branchPayload(n.Payload, s.branchName)) // #nosec G115 -- PID comes from a backend process ID
+}
+
+// branchPayload injects the branch name into a notification payload. JSON
+// objects get a "_rift_branch" field; other payloads are prefixed with
+// "riftbranch=<name>|".
+func branchPayload(payload, branchName string) string {
+	trimmed := bytes.TrimSpace([]byte(payload))
+	if len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(trimmed) {
+		field := `"_rift_branch":` + strconv.Quote(branchName)
+		rest := bytes.TrimSpace(trimmed[1:])
+		if len(rest) > 0 && rest[0] == '}' {
+			return "{" + field + "}"
+		}
+		return "{" + field + "," + string(trimmed[1:])
+	}
+	return "riftbranch=" + branchName + "|" + payload
+}
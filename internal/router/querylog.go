@@ -0,0 +1,40 @@
+package router
+
+import (
+	"io"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// QueryLogger logs every query the router executes on a branch, with the
+// original and rewritten SQL. It is safe for concurrent use.
+type QueryLogger struct {
+	logger *log.Logger
+}
+
+// NewQueryLogger creates a QueryLogger writing to w. format is "json" for one
+// JSON object per line, anything else for human-readable text.
+func NewQueryLogger(w io.Writer, format string) *QueryLogger {
+	opts := log.Options{
+		ReportTimestamp: true,
+		TimeFormat:      time.RFC3339Nano,
+	}
+	if format == "json" {
+		opts.Formatter = log.JSONFormatter
+	}
+	return &QueryLogger{logger: log.NewWithOptions(w, opts)}
+}
+
+// Log records a single executed query. A nil QueryLogger discards the entry.
+func (l *QueryLogger) Log(branchName, original, rewritten string, duration time.Duration) {
+	if l == nil {
+		return
+	}
+	l.logger.Info("query",
+		"branch", branchName,
+		"original", original,
+		"rewritten", rewritten,
+		"duration", duration,
+	)
+}
@@ -0,0 +1,32 @@
+package router
+
+import (
+	"errors"
+
+	"github.com/riftdata/rift/internal/parser"
+)
+
+// errReadOnly is returned for a write while the router is read-only.
+var errReadOnly = errors.New("cannot execute write in read-only mode")
+
+// checkReadOnly rejects sql on a read-only session unless each of its
+// statements is one parser.AllowedReadOnly allows. SQL that doesn't parse is
+// left for the engine or upstream to report. This gives clients a
+// clear error up front; the session's upstream connections also default to
+// read-only transactions (see Router.ReadOnly), so Postgres refuses the
+// writes no parser can see, such as functions that write.
+func (s *Session) checkReadOnly(sql string) error {
+	if !s.readOnly {
+		return nil
+	}
+	queries, err := parser.ParseMulti(sql)
+	if err != nil {
+		return nil
+	}
+	for _, pq := range queries {
+		if !pq.AllowedReadOnly() {
+			return errReadOnly
+		}
+	}
+	return nil
+}
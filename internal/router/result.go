@@ -9,19 +9,36 @@ import (
 	pgx "github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/riftdata/rift/internal/cow"
+	"github.com/riftdata/rift/internal/parser"
 	"github.com/riftdata/rift/internal/pgwire"
 )
 
 // sendQueryResult serializes pgx rows back to Postgres wire protocol and writes
 // them to the client connection. This converts the pgx result set into
-// RowDescription + DataRow* + CommandComplete messages.
-func sendQueryResult(client *pgwire.ClientConn, rows pgx.Rows, tag string) error {
+// RowDescription + DataRow* + CommandComplete messages. The command tag is
+// built for qt, so a rewritten DELETE ... RETURNING still reports "DELETE n".
+func sendQueryResult(client *pgwire.ClientConn, rows pgx.Rows, qt parser.QueryType) error {
+	_, err := streamQueryResult(client, rows, qt, nil, false)
+	return err
+}
+
+// streamQueryResult is sendQueryResult that sends each column in the format
+// of formats (see columnFormats; nil means all text) and, with collect, also
+// returns the result for the result cache. The returned result is nil if it
+// had more than cow.MaxCachedRows rows. Only text results may be collected.
+func streamQueryResult(client *pgwire.ClientConn, rows pgx.Rows, qt parser.QueryType, formats []int16, collect bool) (*cow.CachedResult, error) {
 	defer rows.Close()
 
 	// Send RowDescription
 	fieldDescs := rows.FieldDescriptions()
-	if err := sendRowDescription(client, fieldDescs); err != nil {
-		return fmt.Errorf("send row description: %w", err)
+	if err := sendRowDescription(client, fieldDescs, formats); err != nil {
+		return nil, fmt.Errorf("send row description: %w", err)
+	}
+
+	var result *cow.CachedResult
+	if collect {
+		result = &cow.CachedResult{Fields: fieldDescs}
 	}
 
 	// Send DataRows
@@ -29,35 +46,77 @@ func sendQueryResult(client *pgwire.ClientConn, rows pgx.Rows, tag string) error
 	for rows.Next() {
 		values, err := rows.Values()
 		if err != nil {
-			return fmt.Errorf("read row values: %w", err)
+			return nil, fmt.Errorf("read row values: %w", err)
 		}
 
-		if err := sendDataRow(client, values, fieldDescs); err != nil {
-			return fmt.Errorf("send data row: %w", err)
+		var texts []*string
+		if hasBinaryFormat(formats) {
+			texts, err = rowValues(values, fieldDescs, formats)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			texts = rowTexts(values, fieldDescs)
+		}
+		if err := client.WriteDataRow(texts); err != nil {
+			return nil, fmt.Errorf("send data row: %w", err)
 		}
 		rowCount++
+
+		if result != nil {
+			if rowCount > cow.MaxCachedRows {
+				result = nil
+			} else {
+				result.Rows = append(result.Rows, texts)
+			}
+		}
 	}
 
 	if err := rows.Err(); err != nil {
-		return fmt.Errorf("rows iteration: %w", err)
+		return nil, fmt.Errorf("rows iteration: %w", err)
 	}
 
 	// Send CommandComplete
-	cmdTag := tag
-	if tag == "" {
-		cmdTag = fmt.Sprintf("SELECT %d", rowCount)
+	return result, client.SendCommandComplete(commandTag(qt, rowCount))
+}
+
+// sendCachedResult sends a result from the result cache the way
+// sendQueryResult sends one read from upstream.
+func sendCachedResult(client *pgwire.ClientConn, r *cow.CachedResult, qt parser.QueryType) error {
+	if err := sendRowDescription(client, r.Fields, nil); err != nil {
+		return fmt.Errorf("send row description: %w", err)
+	}
+	for _, row := range r.Rows {
+		if err := client.WriteDataRow(row); err != nil {
+			return fmt.Errorf("send data row: %w", err)
+		}
+	}
+	return client.SendCommandComplete(commandTag(qt, len(r.Rows)))
+}
+
+// commandTag returns the CommandComplete tag for a statement of type qt that
+// returned n rows.
+func commandTag(qt parser.QueryType, n int) string {
+	switch qt {
+	case parser.QueryInsert:
+		return fmt.Sprintf("INSERT 0 %d", n)
+	case parser.QueryUpdate, parser.QueryDelete:
+		return fmt.Sprintf("%s %d", qt, n)
+	default:
+		return fmt.Sprintf("SELECT %d", n)
 	}
-	return client.SendCommandComplete(cmdTag)
 }
 
-// sendRowDescription builds and sends a RowDescription ('T') message.
-func sendRowDescription(client *pgwire.ClientConn, fields []pgconn.FieldDescription) error {
-	buf := pgwire.NewBuffer(256)
+// sendRowDescription builds and sends a RowDescription ('T') message. formats
+// holds each column's format code; nil means all text.
+func sendRowDescription(client *pgwire.ClientConn, fields []pgconn.FieldDescription, formats []int16) error {
+	buf := pgwire.NewBuffer(256, true)
+	defer pgwire.ReleaseBuffer(buf)
 
 	// Number of fields
 	buf.WriteInt16(int16(len(fields))) // #nosec G115 -- field count fits in int16
 
-	for _, f := range fields {
+	for i, f := range fields {
 		// Field name (null-terminated)
 		buf.WriteString(f.Name)
 
@@ -76,26 +135,24 @@ func sendRowDescription(client *pgwire.ClientConn, fields []pgconn.FieldDescript
 		// Type modifier
 		buf.WriteInt32(f.TypeModifier)
 
-		// Format code — always text (0) since sendDataRow emits text values
-		buf.WriteInt16(0)
+		// Format code
+		fc := pgwire.FormatText
+		if i < len(formats) {
+			fc = formats[i]
+		}
+		buf.WriteInt16(fc)
 	}
 
 	return client.WriteMessage(pgwire.MsgRowDescription, buf.Bytes())
 }
 
-// sendDataRow builds and sends a DataRow ('D') message.
-// Values are sent in text format using OID-aware encoding.
-func sendDataRow(client *pgwire.ClientConn, values []interface{}, fields []pgconn.FieldDescription) error {
-	buf := pgwire.NewBuffer(256)
-
-	// Number of columns
-	buf.WriteInt16(int16(len(values))) // #nosec G115 -- column count fits in int16
-
+// rowTexts converts a row's values to their text wire format using
+// OID-aware encoding. A nil value (NULL) stays nil.
+func rowTexts(values []interface{}, fields []pgconn.FieldDescription) []*string {
+	texts := make([]*string, len(values))
 	for i, v := range values {
 		if v == nil {
-			// NULL value: -1 length
-			buf.WriteInt32(-1)
-			continue
+			continue // NULL
 		}
 
 		var oid uint32
@@ -105,12 +162,38 @@ func sendDataRow(client *pgwire.ClientConn, values []interface{}, fields []pgcon
 
 		// Convert to text representation using OID
 		text := formatValue(v, oid)
-		textBytes := []byte(text)
-		buf.WriteInt32(int32(len(textBytes))) // #nosec G115 -- text length fits in int32
-		buf.WriteBytes(textBytes)
+		texts[i] = &text
 	}
+	return texts
+}
 
-	return client.WriteMessage(pgwire.MsgDataRow, buf.Bytes())
+// rowValues converts a row's values to the wire format of each column's
+// format code: binary columns use encodeBinary, the rest formatValue.
+func rowValues(values []interface{}, fields []pgconn.FieldDescription, formats []int16) ([]*string, error) {
+	texts := make([]*string, len(values))
+	for i, v := range values {
+		if v == nil {
+			continue // NULL
+		}
+
+		var oid uint32
+		if i < len(fields) {
+			oid = fields[i].DataTypeOID
+		}
+
+		var text string
+		if i < len(formats) && formats[i] == pgwire.FormatBinary {
+			b, err := encodeBinary(v, oid)
+			if err != nil {
+				return nil, fmt.Errorf("column %d: %w", i+1, err)
+			}
+			text = string(b)
+		} else {
+			text = formatValue(v, oid)
+		}
+		texts[i] = &text
+	}
+	return texts, nil
 }
 
 // formatValue converts a Go value to its Postgres text wire representation,
@@ -2,39 +2,271 @@ package router
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
 	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/riftdata/rift/internal/cow"
 	"github.com/riftdata/rift/internal/pgwire"
+	"github.com/riftdata/rift/internal/storage"
+	"github.com/riftdata/rift/internal/telemetry"
 )
 
 // Router handles query routing for branch connections.
 // Main branch connections bypass the router entirely (raw TCP passthrough).
 // Non-main branch connections are handled via the CoW engine.
 type Router struct {
-	pool   *pgxpool.Pool
+	lb     *LoadBalancer
 	engine *cow.Engine
+
+	// QueryLogger, if set, logs every query executed on a branch.
+	QueryLogger *QueryLogger
+
+	// Logger receives the sessions' logs; nil uses slog.Default().
+	Logger *slog.Logger
+
+	// ReadOnly rejects writes and DDL on every session. The proxy then routes
+	// main through the router too, so that its queries can be checked.
+	// Sessions then write through pools of their own whose connections
+	// default to read-only transactions, so Postgres refuses the writes the
+	// check can't see; replicas are expected to be read-only already.
+	ReadOnly bool
+
+	// Telemetry, if set, counts queries and errors for usage metrics.
+	Telemetry *telemetry.Collector
+
+	// BranchPoolSize, if set, gives each branch its own upstream pool of at
+	// most this many connections, so a busy branch can't take every
+	// connection of the shared pool. 0 shares the pool between branches.
+	// A branch's pool is closed once it has had no sessions for a while, so
+	// deleted and unused branches don't hold on to connections.
+	BranchPoolSize int32
+
+	poolsMu     sync.Mutex
+	branchPools map[string]*branchPool
+
+	// branchPoolIdle is how long a branch's pool is kept without sessions.
+	branchPoolIdle time.Duration
+
+	// readOnlyPools are a read-only router's pools without BranchPoolSize,
+	// one per upstream primary it has used (see UpstreamFailoverManager).
+	readOnlyPools []readOnlyPool
+
+	// inFlight counts messages sessions are processing, so shutdown can
+	// wait for running queries.
+	inFlight atomic.Int64
+
+	// activity records when branches are queried; nil until TrackActivity.
+	activity *activityTracker
 }
 
-// New creates a new Router.
-func New(pool *pgxpool.Pool, engine *cow.Engine) *Router {
+// New creates a new Router. Sessions write to lb's primary pool and send
+// SELECTs made outside a transaction to its replicas, where they may not see
+// the session's latest writes.
+func New(lb *LoadBalancer, engine *cow.Engine) *Router {
 	return &Router{
-		pool:   pool,
-		engine: engine,
+		lb:             lb,
+		engine:         engine,
+		branchPoolIdle: defaultBranchPoolIdle,
+	}
+}
+
+// TrackActivity makes sessions record when their branch was queried in the
+// branch's last_query_at, at most once a minute per branch. It must be called
+// before the first session.
+func (r *Router) TrackActivity(store storage.Store) {
+	logger := r.Logger
+	if logger == nil {
+		logger = slog.Default()
 	}
+	r.activity = newActivityTracker(store, logger)
 }
 
 // HandleSession handles a client connection for a non-main branch.
 // This takes over from the proxy after handshake and branch resolution.
 // The upstream TCP connection is not used — queries go through pgx pool instead.
 func (r *Router) HandleSession(ctx context.Context, client *pgwire.ClientConn, branchName string) error {
-	session := NewSession(client, r.pool, r.engine, branchName)
+	lb, release, err := r.balancerFor(ctx, branchName)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	session := NewSession(client, lb, r.engine, branchName, r.Logger)
+	session.queryLog = r.QueryLogger
+	session.readOnly = r.ReadOnly
+	session.telemetry = r.Telemetry
+	session.inFlight = &r.inFlight
+	session.activity = r.activity
 	defer session.Cleanup(ctx)
 
 	return session.HandleMessages(ctx)
 }
 
+// readOnlyPool is a pool opened with readOnlyParam on, and the pool whose
+// upstream it connects to.
+type readOnlyPool struct {
+	source *pgxpool.Pool
+	pool   *pgxpool.Pool
+}
+
+// branchPool is a branch's own pool and the number of sessions using it.
+type branchPool struct {
+	pool     *pgxpool.Pool
+	sessions int
+
+	// idle closes the pool once it has had no sessions for branchPoolIdle.
+	idle *time.Timer
+}
+
+// defaultBranchPoolIdle is how long a branch's pool is kept without sessions.
+const defaultBranchPoolIdle = 5 * time.Minute
+
+// readOnlyParam makes a read-only router's upstream connections default to
+// read-only transactions.
+const readOnlyParam = "default_transaction_read_only"
+
+// balancerFor returns the load balancer a branch's sessions use: the shared
+// one, or when BranchPoolSize is set one that writes to the branch's own
+// pool, created on first use. A read-only router without BranchPoolSize
+// writes to a read-only pool of the current primary's upstream instead.
+// Reads share the replicas either way. The session must call release when
+// it ends.
+func (r *Router) balancerFor(ctx context.Context, branchName string) (lb *LoadBalancer, release func(), err error) {
+	release = func() {}
+	if r.BranchPoolSize <= 0 && !r.ReadOnly {
+		return r.lb, release, nil
+	}
+
+	r.poolsMu.Lock()
+	defer r.poolsMu.Unlock()
+	if r.BranchPoolSize <= 0 {
+		primary := r.lb.Primary()
+		for _, p := range r.readOnlyPools {
+			if p.source == primary {
+				return r.lb.withPrimary(p.pool), release, nil
+			}
+		}
+		pool, err := pgxpool.NewWithConfig(context.WithoutCancel(ctx), r.poolConfig())
+		if err != nil {
+			return nil, nil, fmt.Errorf("create read-only pool: %w", err)
+		}
+		r.readOnlyPools = append(r.readOnlyPools, readOnlyPool{source: primary, pool: pool})
+		return r.lb.withPrimary(pool), release, nil
+	}
+
+	bp, ok := r.branchPools[branchName]
+	if !ok {
+		cfg := r.poolConfig()
+		cfg.MaxConns = r.BranchPoolSize
+		cfg.MinConns = 0
+		pool, err := pgxpool.NewWithConfig(context.WithoutCancel(ctx), cfg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("create pool for branch %s: %w", branchName, err)
+		}
+		if r.branchPools == nil {
+			r.branchPools = make(map[string]*branchPool)
+		}
+		bp = &branchPool{pool: pool}
+		r.branchPools[branchName] = bp
+	}
+	bp.sessions++
+	if bp.idle != nil {
+		bp.idle.Stop()
+		bp.idle = nil
+	}
+	return r.lb.withPrimary(bp.pool), func() { r.releaseBranchPool(branchName, bp) }, nil
+}
+
+// releaseBranchPool ends a session's use of a branch's pool, and has the
+// pool closed after branchPoolIdle if no other session uses it by then.
+func (r *Router) releaseBranchPool(branchName string, bp *branchPool) {
+	r.poolsMu.Lock()
+	defer r.poolsMu.Unlock()
+	bp.sessions--
+	if bp.sessions > 0 || r.branchPools[branchName] != bp {
+		return
+	}
+	bp.idle = time.AfterFunc(r.branchPoolIdle, func() {
+		r.poolsMu.Lock()
+		if r.branchPools[branchName] != bp || bp.sessions > 0 {
+			r.poolsMu.Unlock()
+			return
+		}
+		delete(r.branchPools, branchName)
+		r.poolsMu.Unlock()
+		bp.pool.Close()
+	})
+}
+
+// poolConfig returns the configuration of the pools the router opens, from
+// the primary's, with readOnlyParam on if the router is read-only.
+func (r *Router) poolConfig() *pgxpool.Config {
+	cfg := r.lb.Primary().Config()
+	if r.ReadOnly {
+		cfg.ConnConfig.RuntimeParams[readOnlyParam] = "on"
+	}
+	return cfg
+}
+
+// PoolStats returns the utilization of the shared pool, named "shared", of
+// each read replica's pool, named "replica-1", "replica-2" and so on, of
+// each read-only pool, named "read-only-1" and so on, and of each branch's
+// own pool.
+func (r *Router) PoolStats() []storage.PoolStats {
+	stats := []storage.PoolStats{storage.StatsOf("shared", r.lb.Primary())}
+	for i, pool := range r.lb.Replicas() {
+		stats = append(stats, storage.StatsOf(fmt.Sprintf("replica-%d", i+1), pool))
+	}
+
+	r.poolsMu.Lock()
+	defer r.poolsMu.Unlock()
+	for i, p := range r.readOnlyPools {
+		stats = append(stats, storage.StatsOf(fmt.Sprintf("read-only-%d", i+1), p.pool))
+	}
+	names := make([]string, 0, len(r.branchPools))
+	for name := range r.branchPools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stats = append(stats, storage.StatsOf(name, r.branchPools[name].pool))
+	}
+	return stats
+}
+
+// Close closes the branches' own pools and the read-only pools, after
+// waiting for branch activity being recorded. The shared and replica pools
+// belong to the caller and are left open.
+func (r *Router) Close() {
+	if r.activity != nil {
+		r.activity.wait()
+	}
+	r.poolsMu.Lock()
+	defer r.poolsMu.Unlock()
+	for name, bp := range r.branchPools {
+		if bp.idle != nil {
+			bp.idle.Stop()
+		}
+		bp.pool.Close()
+		delete(r.branchPools, name)
+	}
+	for _, p := range r.readOnlyPools {
+		p.pool.Close()
+	}
+	r.readOnlyPools = nil
+}
+
+// InFlight returns the number of queries sessions are running.
+func (r *Router) InFlight() int64 {
+	return r.inFlight.Load()
+}
+
 // IsBranchRouted returns true if a branch should go through the CoW router
 // rather than raw TCP passthrough.
 func IsBranchRouted(branchName string) bool {
@@ -1,7 +1,25 @@
 package router
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/riftdata/rift/internal/cow"
+	"github.com/riftdata/rift/internal/parser"
+	"github.com/riftdata/rift/internal/pgwire"
+	"github.com/riftdata/rift/internal/storage"
+	"github.com/riftdata/rift/internal/storage/mock"
 )
 
 func TestIsBranchRouted(t *testing.T) {
@@ -185,6 +203,18 @@ func TestIsRollback(t *testing.T) {
 	}
 }
 
+func TestHasTransactionControl(t *testing.T) {
+	if hasTransactionControl([]string{"INSERT INTO t VALUES (1)", "SELECT 1"}) {
+		t.Error("plain statements should run in an implicit transaction")
+	}
+	if !hasTransactionControl([]string{"BEGIN", "INSERT INTO t VALUES (1)", "COMMIT"}) {
+		t.Error("BEGIN ... COMMIT has transaction control")
+	}
+	if !hasTransactionControl([]string{"INSERT INTO t VALUES (1)", "SAVEPOINT a"}) {
+		t.Error("SAVEPOINT has transaction control")
+	}
+}
+
 func TestExtendedState(t *testing.T) {
 	ext := newExtendedState()
 
@@ -247,3 +277,610 @@ func TestFormatValue(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeBinary(t *testing.T) {
+	uuid := [16]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}
+	tests := []struct {
+		name   string
+		input  interface{}
+		oid    uint32
+		expect []byte
+	}{
+		{"bool", true, pgtype.BoolOID, []byte{1}},
+		{"int2", int16(-2), pgtype.Int2OID, []byte{0xff, 0xfe}},
+		{"int4", int32(258), pgtype.Int4OID, []byte{0, 0, 1, 2}},
+		{"int8", int64(1), pgtype.Int8OID, []byte{0, 0, 0, 0, 0, 0, 0, 1}},
+		{"float4", float32(1), pgtype.Float4OID, []byte{0x3f, 0x80, 0, 0}},
+		{"float8", float64(-2), pgtype.Float8OID, []byte{0xc0, 0, 0, 0, 0, 0, 0, 0}},
+		{"text", "héllo", pgtype.TextOID, []byte("héllo")},
+		{"bytea", []byte{0, 1}, pgtype.ByteaOID, []byte{0, 1}},
+		{"uuid", uuid, pgtype.UUIDOID, uuid[:]},
+		{"timestamptz", time.Date(2000, 1, 1, 0, 0, 1, 0, time.UTC), pgtype.TimestamptzOID, []byte{0, 0, 0, 0, 0, 0x0f, 0x42, 0x40}},
+		{"timestamp before epoch", time.Date(1999, 12, 31, 23, 59, 59, 999999000, time.UTC), pgtype.TimestampOID, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"date", time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC), pgtype.DateOID, []byte{0, 0, 0, 2}},
+		{"date before epoch", time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC), pgtype.DateOID, []byte{0xff, 0xff, 0xff, 0xff}},
+		{"infinite date", pgtype.Infinity, pgtype.DateOID, []byte{0x7f, 0xff, 0xff, 0xff}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encodeBinary(tt.input, tt.oid)
+			if err != nil {
+				t.Fatalf("encodeBinary: %v", err)
+			}
+			if !bytes.Equal(got, tt.expect) {
+				t.Errorf("encodeBinary(%v, %d) = %x, want %x", tt.input, tt.oid, got, tt.expect)
+			}
+		})
+	}
+
+	if _, err := encodeBinary("42", pgtype.Int4OID); err == nil {
+		t.Error("encodeBinary of a string as int4: expected error")
+	}
+	if _, err := encodeBinary("1.5", pgtype.NumericOID); err == nil {
+		t.Error("encodeBinary of numeric: expected error")
+	}
+}
+
+func TestColumnFormats(t *testing.T) {
+	fields := []pgconn.FieldDescription{
+		{Name: "id", DataTypeOID: pgtype.Int4OID},
+		{Name: "price", DataTypeOID: pgtype.NumericOID},
+		{Name: "name", DataTypeOID: pgtype.TextOID},
+	}
+	text, binary := pgwire.FormatText, pgwire.FormatBinary
+	tests := []struct {
+		name      string
+		requested []int16
+		expect    []int16
+	}{
+		{"none is text", nil, []int16{text, text, text}},
+		{"one applies to all", []int16{binary}, []int16{binary, text, binary}},
+		{"per column", []int16{text, binary, binary}, []int16{text, text, binary}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := columnFormats(tt.requested, fields)
+			if err != nil {
+				t.Fatalf("columnFormats: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Errorf("columnFormats(%v) = %v, want %v", tt.requested, got, tt.expect)
+			}
+		})
+	}
+
+	if _, err := columnFormats([]int16{binary, binary}, fields); !errors.Is(err, errResultFormats) {
+		t.Errorf("columnFormats with 2 formats for 3 columns: got %v, want errResultFormats", err)
+	}
+}
+
+func TestRowValuesBinary(t *testing.T) {
+	fields := []pgconn.FieldDescription{
+		{Name: "id", DataTypeOID: pgtype.Int4OID},
+		{Name: "price", DataTypeOID: pgtype.NumericOID},
+		{Name: "note", DataTypeOID: pgtype.TextOID},
+	}
+	formats := []int16{pgwire.FormatBinary, pgwire.FormatText, pgwire.FormatBinary}
+
+	got, err := rowValues([]interface{}{int32(7), "9.99", nil}, fields, formats)
+	if err != nil {
+		t.Fatalf("rowValues: %v", err)
+	}
+	if *got[0] != "\x00\x00\x00\x07" {
+		t.Errorf("id = %q, want 4 big-endian bytes", *got[0])
+	}
+	if *got[1] != "9.99" {
+		t.Errorf("price = %q, want text 9.99", *got[1])
+	}
+	if got[2] != nil {
+		t.Errorf("note = %q, want NULL", *got[2])
+	}
+}
+
+func TestCommandTag(t *testing.T) {
+	tests := []struct {
+		qt   parser.QueryType
+		want string
+	}{
+		{parser.QuerySelect, "SELECT 3"},
+		{parser.QueryInsert, "INSERT 0 3"},
+		{parser.QueryUpdate, "UPDATE 3"},
+		{parser.QueryDelete, "DELETE 3"},
+	}
+	for _, tt := range tests {
+		if got := commandTag(tt.qt, 3); got != tt.want {
+			t.Errorf("commandTag(%s, 3) = %q, want %q", tt.qt, got, tt.want)
+		}
+	}
+}
+
+func TestQueryLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewQueryLogger(&buf, "json")
+	l.Log("dev", "SELECT 1", "SELECT 2", 5*time.Millisecond)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	if entry["branch"] != "dev" || entry["original"] != "SELECT 1" || entry["rewritten"] != "SELECT 2" {
+		t.Errorf("unexpected entry: %v", entry)
+	}
+}
+
+func TestQueryLoggerText(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewQueryLogger(&buf, "text")
+	l.Log("dev", "SELECT 1", "SELECT 2", time.Millisecond)
+
+	if !strings.Contains(buf.String(), "branch=dev") {
+		t.Errorf("expected branch in text log, got %q", buf.String())
+	}
+}
+
+func TestQueryLoggerNil(t *testing.T) {
+	var l *QueryLogger
+	l.Log("dev", "SELECT 1", "SELECT 1", 0) // must not panic
+}
+
+func TestErrorFields(t *testing.T) {
+	_, syntaxErr := parser.Parse("SELEC 1")
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"protected branch", fmt.Errorf("parse query: %w", cow.ErrBranchProtected), pgwire.ErrCodeReadOnlyTransaction},
+		{"read-only mode", errReadOnly, pgwire.ErrCodeReadOnlyTransaction},
+		{"frozen branch", fmt.Errorf("process query: %w", cow.ErrBranchFrozen), pgwire.ErrCodeReadOnlyTransaction},
+		{"delta size limit", fmt.Errorf("process query: %w", cow.ErrDeltaSizeLimit), pgwire.ErrCodeConfigLimitExceeded},
+		{"branch not found", fmt.Errorf("get branch: %w", storage.ErrBranchNotFound), pgwire.ErrCodeInvalidCatalogName},
+		{"table not found", fmt.Errorf("ensure overlay: %w", cow.ErrTableNotFound), pgwire.ErrCodeUndefinedTable},
+		{"syntax error", fmt.Errorf("parse query: %w", syntaxErr), pgwire.ErrCodeSyntaxError},
+		{"unknown function", fmt.Errorf("%w: OID 1", errUnknownFunction), pgwire.ErrCodeUndefinedFunction},
+		{"volatile function", fmt.Errorf("lo_open: %w", errVolatileFunction), pgwire.ErrCodeFeatureNotSupported},
+		{"unsupported copy", fmt.Errorf("process query: %w", cow.ErrUnsupportedCopy), pgwire.ErrCodeFeatureNotSupported},
+		{"extended copy", errExtendedCopy, pgwire.ErrCodeFeatureNotSupported},
+		{"bad copy data", fmt.Errorf("copy rows: %w", cow.ErrBadCopyData), pgwire.ErrCodeBadCopyFileFormat},
+		{"copy failed", fmt.Errorf("copy rows: %w: aborted", errCopyFailed), pgwire.ErrCodeQueryCanceled},
+		{"result formats", fmt.Errorf("%w: bind message has 2 result formats but query has 3 columns", errResultFormats), pgwire.ErrCodeProtocolViolation},
+		{"upstream error", fmt.Errorf("exec: %w", &pgconn.PgError{Code: "23505"}), pgwire.ErrCodeUniqueViolation},
+		{"generic error", errors.New("boom"), pgwire.ErrCodeInternalError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _, _, _ := errorFields(tt.err); got != tt.want {
+				t.Errorf("errorFields(%v) code = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorFieldsUpstream(t *testing.T) {
+	err := fmt.Errorf("exec: %w", &pgconn.PgError{
+		Code:    "42501",
+		Message: "permission denied for table users",
+		Detail:  "some detail",
+		Hint:    "some hint",
+	})
+	code, message, detail, hint := errorFields(err)
+	if code != "42501" || message != "permission denied for table users" || detail != "some detail" || hint != "some hint" {
+		t.Errorf("errorFields() = %q, %q, %q, %q", code, message, detail, hint)
+	}
+}
+
+func TestBranchPayload(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    string
+	}{
+		{`{"id":1}`, `{"_rift_branch":"dev","id":1}`},
+		{`{}`, `{"_rift_branch":"dev"}`},
+		{` { } `, `{"_rift_branch":"dev"}`},
+		{"order 42", "riftbranch=dev|order 42"},
+		{"", "riftbranch=dev|"},
+		{`{not json`, "riftbranch=dev|{not json"},
+		{`[1,2]`, "riftbranch=dev|[1,2]"},
+	}
+
+	for _, tt := range tests {
+		if got := branchPayload(tt.payload, "dev"); got != tt.want {
+			t.Errorf("branchPayload(%q) = %q, want %q", tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestIsListenStatement(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{"LISTEN orders", true},
+		{"unlisten *", true},
+		{"UNLISTEN orders", true},
+		{"NOTIFY orders, 'x'", false},
+		{"SELECT 1", false},
+		{"LISTENX", false},
+	}
+
+	for _, tt := range tests {
+		if got := isListenStatement(tt.sql); got != tt.want {
+			t.Errorf("isListenStatement(%q) = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestParseFunctionCall(t *testing.T) {
+	buf := pgwire.NewBuffer(64, false)
+	buf.WriteUint32(1598) // function OID
+	buf.WriteInt16(1)     // one format code for all arguments
+	buf.WriteInt16(1)
+	buf.WriteInt16(2) // two arguments
+	buf.WriteInt32(4)
+	buf.WriteInt32(42)
+	buf.WriteInt32(-1) // NULL
+	buf.WriteInt16(0)  // text result
+
+	fc, err := parseFunctionCall(buf.Bytes())
+	if err != nil {
+		t.Fatalf("parseFunctionCall: %v", err)
+	}
+	if fc.oid != 1598 || fc.resultFormat != 0 {
+		t.Errorf("oid = %d, result format = %d; want 1598, 0", fc.oid, fc.resultFormat)
+	}
+	if len(fc.args) != 2 || len(fc.args[0]) != 4 || fc.args[1] != nil {
+		t.Errorf("args = %v, want a 4-byte value and NULL", fc.args)
+	}
+	if len(fc.argFormats) != 2 || fc.argFormats[0] != 1 || fc.argFormats[1] != 1 {
+		t.Errorf("argFormats = %v, want [1 1]", fc.argFormats)
+	}
+}
+
+func TestParseFunctionCallFormatMismatch(t *testing.T) {
+	buf := pgwire.NewBuffer(32, false)
+	buf.WriteUint32(1598)
+	buf.WriteInt16(2) // two format codes...
+	buf.WriteInt16(0)
+	buf.WriteInt16(0)
+	buf.WriteInt16(3) // ...for three arguments
+	for i := 0; i < 3; i++ {
+		buf.WriteInt32(-1)
+	}
+	buf.WriteInt16(0)
+
+	if _, err := parseFunctionCall(buf.Bytes()); err == nil {
+		t.Error("expected an error for mismatched format codes")
+	}
+}
+
+func TestParseFunctionCallMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(buf *pgwire.Buffer)
+	}{
+		{"negative format count", func(buf *pgwire.Buffer) {
+			buf.WriteInt16(-1)
+		}},
+		{"format count past the payload", func(buf *pgwire.Buffer) {
+			buf.WriteInt16(32767)
+			buf.WriteInt16(0)
+		}},
+		{"negative argument count", func(buf *pgwire.Buffer) {
+			buf.WriteInt16(0)
+			buf.WriteInt16(-2)
+		}},
+		{"argument count past the payload", func(buf *pgwire.Buffer) {
+			buf.WriteInt16(0)
+			buf.WriteInt16(32767)
+			buf.WriteInt32(-1)
+		}},
+		{"negative argument length", func(buf *pgwire.Buffer) {
+			buf.WriteInt16(0)
+			buf.WriteInt16(1)
+			buf.WriteInt32(-5)
+			buf.WriteInt16(0)
+		}},
+		{"argument length past the payload", func(buf *pgwire.Buffer) {
+			buf.WriteInt16(0)
+			buf.WriteInt16(1)
+			buf.WriteInt32(1 << 30)
+			buf.WriteInt16(0)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := pgwire.NewBuffer(32, false)
+			buf.WriteUint32(1598)
+			tt.build(buf)
+			if _, err := parseFunctionCall(buf.Bytes()); !errors.Is(err, errMalformedMessage) {
+				t.Errorf("parseFunctionCall = %v, want errMalformedMessage", err)
+			}
+		})
+	}
+}
+
+func TestCheckReadOnly(t *testing.T) {
+	tests := []struct {
+		sql     string
+		wantErr bool
+	}{
+		{"SELECT * FROM users", false},
+		{"BEGIN", false},
+		{"COMMIT", false},
+		{"SET search_path TO app", false},
+		{"SHOW search_path", false},
+		{"INSERT INTO users (id) VALUES (1)", true},
+		{"UPDATE users SET name = 'x'", true},
+		{"DELETE FROM users", true},
+		{"CREATE TABLE t (id int)", true},
+		{"ALTER TABLE users ADD COLUMN age int", true},
+		{"COPY users FROM STDIN", true},
+		{"COPY users TO STDOUT", false},
+		{"SELECT 1; DELETE FROM users", true},
+		{"SELECT 'a; DELETE FROM users'", false},
+		{"EXPLAIN SELECT * FROM users", false},
+		{"BEGIN READ ONLY", false},
+		{"SET TRANSACTION READ ONLY", false},
+		{"RESET ALL", false},
+		// Left to the upstream's read-only transactions
+		{"SELECT setval('users_id_seq', 1)", false},
+		{"TRUNCATE users", true},
+		{"GRANT SELECT ON users TO app", true},
+		{"REVOKE SELECT ON users FROM app", true},
+		{"DO $$ BEGIN DELETE FROM users; END $$", true},
+		{"CALL cleanup()", true},
+		{"VACUUM users", true},
+		{"REFRESH MATERIALIZED VIEW user_stats", true},
+		{"SELECT * INTO users_copy FROM users", true},
+		{"WITH d AS (DELETE FROM users RETURNING *) SELECT * FROM d", true},
+		{"SELECT * FROM users FOR UPDATE", true},
+		{"EXPLAIN ANALYZE DELETE FROM users", true},
+		{"COPY (DELETE FROM users RETURNING *) TO STDOUT", true},
+		{"SET default_transaction_read_only = off", true},
+		{"SET transaction_read_only = off", true},
+		{"SET TRANSACTION READ WRITE", true},
+		{"SET SESSION CHARACTERISTICS AS TRANSACTION READ WRITE", true},
+		{"BEGIN READ WRITE", true},
+		{"START TRANSACTION ISOLATION LEVEL SERIALIZABLE, READ WRITE", true},
+		{"PREPARE TRANSACTION 'tx'", true},
+	}
+
+	s := &Session{readOnly: true}
+	for _, tt := range tests {
+		err := s.checkReadOnly(tt.sql)
+		if tt.wantErr && !errors.Is(err, errReadOnly) {
+			t.Errorf("checkReadOnly(%q) = %v, want errReadOnly", tt.sql, err)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("checkReadOnly(%q) = %v, want nil", tt.sql, err)
+		}
+	}
+
+	if err := (&Session{}).checkReadOnly("DELETE FROM users"); err != nil {
+		t.Errorf("checkReadOnly on a read-write session = %v, want nil", err)
+	}
+}
+
+// lazyPool returns a pool that doesn't connect until it is used; port 1 on
+// localhost refuses connections, so its queries fail fast.
+func lazyPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	pool, err := pgxpool.New(context.Background(), "postgres://rift@127.0.0.1:1/rift?connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestReadOnlyPools(t *testing.T) {
+	primary := lazyPool(t)
+	r := New(NewLoadBalancer(primary), nil)
+	r.ReadOnly = true
+	defer r.Close()
+
+	lb, _, err := r.balancerFor(context.Background(), "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := lb.Primary()
+	if pool == primary {
+		t.Fatal("a read-only router's sessions write to the shared pool")
+	}
+	if got := pool.Config().ConnConfig.RuntimeParams[readOnlyParam]; got != "on" {
+		t.Errorf("read-only pool %s = %q, want on", readOnlyParam, got)
+	}
+	if _, ok := primary.Config().ConnConfig.RuntimeParams[readOnlyParam]; ok {
+		t.Errorf("the shared pool's %s was changed", readOnlyParam)
+	}
+
+	lb, _, err = r.balancerFor(context.Background(), "feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lb.Primary() != pool {
+		t.Error("branches of the same upstream should share its read-only pool")
+	}
+
+	r.BranchPoolSize = 2
+	lb, _, err = r.balancerFor(context.Background(), "feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lb.Primary().Config().ConnConfig.RuntimeParams[readOnlyParam]; got != "on" {
+		t.Errorf("branch pool %s = %q, want on", readOnlyParam, got)
+	}
+}
+
+func TestBranchPoolClosedWhenIdle(t *testing.T) {
+	r := New(NewLoadBalancer(lazyPool(t)), nil)
+	r.BranchPoolSize = 2
+	r.branchPoolIdle = 10 * time.Millisecond
+	defer r.Close()
+
+	hasPool := func() bool {
+		r.poolsMu.Lock()
+		defer r.poolsMu.Unlock()
+		_, ok := r.branchPools["feature"]
+		return ok
+	}
+
+	lb1, release1, err := r.balancerFor(context.Background(), "feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb2, release2, err := r.balancerFor(context.Background(), "feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lb1.Primary() != lb2.Primary() {
+		t.Fatal("sessions of a branch should share its pool")
+	}
+
+	release1()
+	time.Sleep(50 * time.Millisecond)
+	if !hasPool() {
+		t.Fatal("pool closed while a session still uses it")
+	}
+
+	release2()
+	deadline := time.Now().Add(time.Second)
+	for hasPool() {
+		if time.Now().After(deadline) {
+			t.Fatal("idle branch pool wasn't closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	lb3, release3, err := r.balancerFor(context.Background(), "feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release3()
+	if lb3.Primary() == lb1.Primary() {
+		t.Error("a closed pool was handed out again")
+	}
+	if got := len(r.PoolStats()); got != 2 {
+		t.Errorf("PoolStats has %d pools, want the shared and the branch's", got)
+	}
+}
+
+func TestLoadBalancerRead(t *testing.T) {
+	primary, r1, r2 := lazyPool(t), lazyPool(t), lazyPool(t)
+
+	if got := NewLoadBalancer(primary).Read(); got != primary {
+		t.Error("Read without replicas should return the primary")
+	}
+	if got := NewLoadBalancer(primary, nil, primary).Replicas(); len(got) != 0 {
+		t.Errorf("nil and primary replicas should be skipped, got %d", len(got))
+	}
+
+	lb := NewLoadBalancer(primary, r1, r2)
+	var got []*pgxpool.Pool
+	for range 4 {
+		got = append(got, lb.Read())
+	}
+	want := []*pgxpool.Pool{r1, r2, r1, r2}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Read #%d returned the wrong pool; want round-robin over the replicas", i+1)
+		}
+	}
+
+	lb.replicas[0].healthy.Store(false)
+	for range 3 {
+		if lb.Read() != r2 {
+			t.Fatal("Read returned an unhealthy replica")
+		}
+	}
+
+	// A branch's balancer writes elsewhere but shares replica health
+	branch := lb.withPrimary(lazyPool(t))
+	if branch.Primary() == primary || branch.Read() != r2 {
+		t.Error("withPrimary should change the primary and keep the replicas")
+	}
+
+	lb.replicas[1].healthy.Store(false)
+	if lb.Read() != primary {
+		t.Error("Read with no healthy replica should fall back to the primary")
+	}
+}
+
+func TestLoadBalancerCheckHealth(t *testing.T) {
+	lb := NewLoadBalancer(lazyPool(t), lazyPool(t), lazyPool(t))
+	if lb.Healthy() != 2 {
+		t.Fatalf("Healthy() = %d before any check, want 2", lb.Healthy())
+	}
+	lb.CheckHealth(context.Background())
+	if lb.Healthy() != 0 {
+		t.Errorf("Healthy() = %d after checking unreachable replicas, want 0", lb.Healthy())
+	}
+}
+
+func TestLoadBalancerFailover(t *testing.T) {
+	primary, standby := lazyPool(t), lazyPool(t)
+	lb := NewLoadBalancer(primary)
+	if _, ok := lb.failOver(context.Background(), primary, &pgconn.ConnectError{}); ok {
+		t.Error("a load balancer without failover shouldn't fail over")
+	}
+
+	m := storage.NewUpstreamFailoverManager(1, primary, standby)
+	lb.SetFailover(m)
+	if lb.Primary() != standby || lb.Read() != standby {
+		t.Error("Primary and Read should use the failover manager's pool")
+	}
+	if _, ok := lb.failOver(context.Background(), standby, &pgconn.PgError{Code: "23505"}); ok {
+		t.Error("a query error shouldn't fail over")
+	}
+}
+
+func TestActivityTracker(t *testing.T) {
+	ctx := context.Background()
+	store := mock.New()
+	if err := store.CreateBranch(ctx, &storage.Branch{Name: "feature", Parent: "main"}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	a := newActivityTracker(store, slog.Default())
+	a.now = func() time.Time { return now }
+
+	lastQuery := func() time.Time {
+		t.Helper()
+		a.wait()
+		b, err := store.GetBranch(ctx, "feature")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.LastQueryAt == nil {
+			return time.Time{}
+		}
+		return *b.LastQueryAt
+	}
+
+	a.touch("feature")
+	if got := lastQuery(); !got.Equal(start) {
+		t.Fatalf("last_query_at = %v, want %v", got, start)
+	}
+
+	// Queries within a minute of the last write aren't written
+	now = start.Add(30 * time.Second)
+	a.touch("feature")
+	if got := lastQuery(); !got.Equal(start) {
+		t.Errorf("last_query_at after 30s = %v, want it left at %v", got, start)
+	}
+
+	now = start.Add(time.Minute)
+	a.touch("feature")
+	if got := lastQuery(); !got.Equal(now) {
+		t.Errorf("last_query_at after a minute = %v, want %v", got, now)
+	}
+
+	// A missing branch is only logged
+	a.touch("gone")
+	a.wait()
+}
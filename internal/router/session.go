@@ -2,22 +2,31 @@ package router
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/riftdata/rift/internal/cow"
 	"github.com/riftdata/rift/internal/parser"
 	"github.com/riftdata/rift/internal/pgwire"
+	"github.com/riftdata/rift/internal/storage"
+	"github.com/riftdata/rift/internal/telemetry"
 )
 
-// Session handles query processing for a single client connection on a non-main branch.
+// Session handles query processing for a single client connection on a non-main branch,
+// or on main when the router is read-only.
 type Session struct {
 	client     *pgwire.ClientConn
-	pool       *pgxpool.Pool
+	lb         *LoadBalancer
 	engine     *cow.Engine
 	branchName string
+	logger     *slog.Logger
 
 	// Transaction state
 	tx       pgx.Tx
@@ -26,18 +35,111 @@ type Session struct {
 	// Extended query protocol state
 	ext    *extendedState
 	extErr error // deferred error until Sync
+
+	// Session variables set by the client (search_path, application_name, timezone),
+	// keyed by lowercased name.
+	sessionVars map[string]string
+
+	queryLog *QueryLogger
+
+	// readOnly rejects writes and DDL (see checkReadOnly)
+	readOnly bool
+
+	telemetry *telemetry.Collector
+
+	// inFlight is the router's count of messages being processed, or nil
+	inFlight *atomic.Int64
+
+	// activity records that the branch was queried, or nil
+	activity *activityTracker
+
+	// Dedicated upstream connection for LISTEN, acquired on first use
+	listen *listener
+}
+
+// trackedVars lists session variables the router keeps track of. search_path
+// drives table resolution; the others are kept for observability. Values are
+// the names reported back to the client in ParameterStatus, or "" if the
+// variable is not reported.
+var trackedVars = map[string]string{
+	"search_path":      "",
+	"application_name": "application_name",
+	"timezone":         "TimeZone",
+}
+
+// NewSession creates a new session for a branch connection. A nil logger
+// uses slog.Default().
+func NewSession(client *pgwire.ClientConn, lb *LoadBalancer, engine *cow.Engine, branchName string, logger *slog.Logger) *Session {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	s := &Session{
+		client:      client,
+		lb:          lb,
+		engine:      engine,
+		branchName:  branchName,
+		logger:      logger.With("branch", branchName),
+		txStatus:    pgwire.TxStatusIdle,
+		ext:         newExtendedState(),
+		sessionVars: make(map[string]string),
+	}
+
+	// Seed from startup parameters such as application_name
+	for name, value := range client.Params() {
+		if _, ok := trackedVars[strings.ToLower(name)]; ok {
+			s.sessionVars[strings.ToLower(name)] = value
+		}
+	}
+
+	return s
+}
+
+// SessionVar returns the current value of a tracked session variable,
+// or "" if the client has not set it.
+func (s *Session) SessionVar(name string) string {
+	return s.sessionVars[strings.ToLower(name)]
+}
+
+// searchPath returns the session's search_path as a list of schemas.
+func (s *Session) searchPath() []string {
+	return parser.SplitSearchPath(s.sessionVars["search_path"])
 }
 
-// NewSession creates a new session for a branch connection.
-func NewSession(client *pgwire.ClientConn, pool *pgxpool.Pool, engine *cow.Engine, branchName string) *Session {
-	return &Session{
-		client:     client,
-		pool:       pool,
-		engine:     engine,
-		branchName: branchName,
-		txStatus:   pgwire.TxStatusIdle,
-		ext:        newExtendedState(),
+// trackSet records a successfully executed SET/RESET of a tracked variable
+// and reports reportable variables to the client via ParameterStatus.
+// SET LOCAL only lasts until the end of the transaction, so it is not tracked.
+func (s *Session) trackSet(sql string) error {
+	pq, err := parser.Parse(sql)
+	if err != nil {
+		return nil
+	}
+	info := parser.ExtractSetInfo(pq)
+	if info == nil || info.Local {
+		return nil
+	}
+
+	if info.Name == "" { // RESET ALL
+		for name := range s.sessionVars {
+			delete(s.sessionVars, name)
+		}
+		return nil
+	}
+
+	reportAs, ok := trackedVars[info.Name]
+	if !ok {
+		return nil
+	}
+
+	if info.Reset {
+		delete(s.sessionVars, info.Name)
+		return nil
 	}
+	s.sessionVars[info.Name] = info.Value
+
+	if reportAs == "" {
+		return nil
+	}
+	return s.client.WriteMessage(pgwire.MsgParameterStatus, pgwire.BuildParameterStatus(reportAs, info.Value))
 }
 
 // HandleMessages processes messages from the client until the connection closes.
@@ -66,6 +168,14 @@ func (s *Session) HandleMessages(ctx context.Context) error {
 
 // dispatchMessage routes a single wire protocol message to its handler.
 func (s *Session) dispatchMessage(ctx context.Context, msgType byte, payload []byte) error {
+	if s.inFlight != nil {
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+	}
+	if s.activity != nil && (msgType == pgwire.MsgQuery || msgType == pgwire.MsgExecute || msgType == pgwire.MsgFunctionCall) {
+		s.activity.touch(s.branchName)
+	}
+
 	switch msgType {
 	case pgwire.MsgQuery:
 		return wrapErr("handle query", s.handleSimpleQuery(ctx, payload))
@@ -83,6 +193,8 @@ func (s *Session) dispatchMessage(ctx context.Context, msgType byte, payload []b
 		return wrapErr("handle sync", s.handleSync())
 	case pgwire.MsgFlush:
 		return nil // Flush is a no-op — we write immediately
+	case pgwire.MsgFunctionCall:
+		return wrapErr("handle function call", s.handleFunctionCall(ctx, payload))
 	default:
 		return s.client.SendReadyForQuery(s.txStatus)
 	}
@@ -103,39 +215,146 @@ func (s *Session) handleSimpleQuery(ctx context.Context, payload []byte) error {
 
 	if sql == "" {
 		// Empty query
-		if err := pgwire.WriteMessage(s.client.NetConn(), pgwire.MsgEmptyQueryResponse, nil); err != nil {
+		if err := s.client.WriteMessage(pgwire.MsgEmptyQueryResponse, nil); err != nil {
+			return err
+		}
+		return s.client.SendReadyForQuery(s.txStatus)
+	}
+
+	if err := s.checkReadOnly(sql); err != nil {
+		return s.sendQueryError(err)
+	}
+
+	// Each statement goes through the engine on its own. SQL that doesn't
+	// parse is run whole, so the engine reports the syntax error.
+	stmts := []string{sql}
+	if parsed, err := parser.ParseMulti(sql); err == nil && len(parsed) > 1 {
+		stmts = make([]string, len(parsed))
+		for i, pq := range parsed {
+			stmts[i] = pq.Original
+		}
+	}
+
+	if len(stmts) > 1 && s.tx == nil && !hasTransactionControl(stmts) {
+		return s.runImplicitTransaction(ctx, stmts)
+	}
+
+	for _, stmt := range stmts {
+		ok, err := s.runSimpleStatement(ctx, stmt)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break // like Postgres, skip the rest of the query after an error
+		}
+	}
+	return s.client.SendReadyForQuery(s.txStatus)
+}
+
+// runImplicitTransaction runs the statements of a multi-statement query in
+// one transaction, as Postgres does when the query has no transaction control
+// of its own: a failed statement rolls back the ones before it.
+func (s *Session) runImplicitTransaction(ctx context.Context, stmts []string) error {
+	tx, err := s.lb.Primary().Begin(ctx)
+	if err != nil {
+		return s.sendQueryError(err)
+	}
+	s.tx = tx
+
+	ok := true
+	for _, stmt := range stmts {
+		if ok, err = s.runSimpleStatement(ctx, stmt); err != nil || !ok {
+			break
+		}
+	}
+	s.tx = nil
+	s.txStatus = pgwire.TxStatusIdle
+
+	if err != nil || !ok {
+		_ = tx.Rollback(ctx)
+		if err != nil {
 			return err
 		}
 		return s.client.SendReadyForQuery(s.txStatus)
 	}
+	if err := tx.Commit(ctx); err != nil {
+		return s.sendQueryError(err)
+	}
+	return s.client.SendReadyForQuery(s.txStatus)
+}
+
+// hasTransactionControl reports whether any of stmts begins or ends a
+// transaction.
+func hasTransactionControl(stmts []string) bool {
+	for _, stmt := range stmts {
+		if parser.IsTransactionControl(stmt) {
+			return true
+		}
+	}
+	return false
+}
 
+// runSimpleStatement runs one statement of a simple query and sends its
+// results, but not ReadyForQuery. It returns false if the statement failed
+// and the error was sent to the client; err is only set when the client
+// connection failed.
+func (s *Session) runSimpleStatement(ctx context.Context, sql string) (bool, error) {
 	// Handle transaction control
-	if isBegin(sql) {
+	switch {
+	case isBegin(sql):
 		return s.handleBegin(ctx)
-	}
-	if isCommit(sql) {
+	case isCommit(sql):
 		return s.handleCommit(ctx)
-	}
-	if isRollback(sql) {
+	case isRollback(sql):
 		return s.handleRollback(ctx)
 	}
 
 	// Process through the CoW engine
-	processed, err := s.engine.ProcessQuery(ctx, s.branchName, sql)
+	processed, err := s.engine.ProcessSessionQuery(ctx, s.branchName, sql, s.searchPath())
 	if err != nil {
-		return s.sendQueryError(err)
+		s.sendError(err)
+		return false, nil
+	}
+	s.telemetry.RecordQuery(processed.Type.String())
+	if processed.CopyIn != nil {
+		return s.handleCopyIn(ctx, processed)
 	}
 
 	// Execute the query
 	if err := s.executeProcessed(ctx, processed); err != nil {
-		return s.sendQueryError(err)
+		s.sendError(err)
+		return false, nil
 	}
 
-	return s.client.SendReadyForQuery(s.txStatus)
+	if processed.Type == parser.QueryUtility {
+		if err := s.trackSet(sql); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// sendNotices forwards engine notices (e.g. applied row limits) to the client.
+func (s *Session) sendNotices(pq *cow.ProcessedQuery) error {
+	for _, msg := range pq.Notices {
+		if err := s.client.SendNotice("NOTICE", pgwire.ErrCodeWarning, msg); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // executeProcessed runs a processed query and sends results to the client.
 func (s *Session) executeProcessed(ctx context.Context, pq *cow.ProcessedQuery) error {
+	if err := s.sendNotices(pq); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	defer func() {
+		s.queryLog.Log(s.branchName, pq.OriginalSQL, pq.RewrittenSQL, time.Since(start))
+	}()
+
 	sqlToRun := pq.RewrittenSQL
 
 	// For multi-statement rewrites (UPDATE/DELETE with copy-on-write),
@@ -151,15 +370,19 @@ func (s *Session) executeProcessed(ctx context.Context, pq *cow.ProcessedQuery)
 		isLast := i == len(statements)-1
 
 		// Determine if this is a query (returns rows) or statement
-		if pq.Type == parser.QuerySelect && isLast {
-			rows, err := s.query(ctx, stmt)
+		if pq.ReturnsRows() && isLast {
+			key, cacheable := s.resultCacheKey(pq, stmt, nil)
+			if cached, ok := s.cachedResult(key, cacheable); ok {
+				return sendCachedResult(s.client, cached, pq.Type)
+			}
+			rows, err := s.query(ctx, pq, stmt)
 			if err != nil {
 				if s.txStatus == pgwire.TxStatusInTx {
 					s.txStatus = pgwire.TxStatusFailed
 				}
 				return err
 			}
-			if err := sendQueryResult(s.client, rows, ""); err != nil {
+			if err := s.sendResult(rows, pq.Type, nil, key, cacheable); err != nil {
 				return err
 			}
 		} else {
@@ -179,102 +402,185 @@ func (s *Session) executeProcessed(ctx context.Context, pq *cow.ProcessedQuery)
 	return nil
 }
 
-// query runs a SQL query and returns rows.
-func (s *Session) query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+// resultCacheKey returns the result cache key for a query, and whether its
+// result may be cached: it must be a SELECT on a frozen branch, outside a
+// transaction.
+func (s *Session) resultCacheKey(pq *cow.ProcessedQuery, stmt string, args []interface{}) (string, bool) {
+	if !pq.Cacheable || s.tx != nil || s.engine.ResultCache() == nil {
+		return "", false
+	}
+	return cow.ResultCacheKey(s.branchName, stmt, args), true
+}
+
+// cachedResult returns the cached result for key, if cacheable and present.
+func (s *Session) cachedResult(key string, cacheable bool) (*cow.CachedResult, bool) {
+	if !cacheable {
+		return nil, false
+	}
+	return s.engine.ResultCache().Get(key)
+}
+
+// sendResult sends rows to the client with the given column formats (nil
+// means all text), caching the result under key if cacheable.
+func (s *Session) sendResult(rows pgx.Rows, qt parser.QueryType, formats []int16, key string, cacheable bool) error {
+	result, err := streamQueryResult(s.client, rows, qt, formats, cacheable)
+	if err != nil {
+		return err
+	}
+	if result != nil {
+		s.engine.ResultCache().Put(key, s.branchName, result)
+	}
+	return nil
+}
+
+// query runs a SQL query and returns rows. Outside a transaction, SELECTs
+// that pq marks replica-safe run on the load balancer's next read pool.
+func (s *Session) query(ctx context.Context, pq *cow.ProcessedQuery, sql string, args ...interface{}) (pgx.Rows, error) {
 	if s.tx != nil {
 		return s.tx.Query(ctx, sql, args...)
 	}
-	return s.pool.Query(ctx, sql, args...)
+	pool := s.lb.Primary()
+	if pq.ReplicaSafe {
+		pool = s.lb.Read()
+	}
+	return withFailover(ctx, s.lb, pool, func(p *pgxpool.Pool) (pgx.Rows, error) {
+		return p.Query(ctx, sql, args...)
+	})
 }
 
 // runExec runs a SQL statement that doesn't return rows.
 func (s *Session) runExec(ctx context.Context, sql string, args ...interface{}) (string, error) {
+	if len(args) == 0 && isListenStatement(sql) {
+		return s.handleListen(ctx, sql)
+	}
 	if s.tx != nil {
 		tag, err := s.tx.Exec(ctx, sql, args...)
 		return tag.String(), err
 	}
-	tag, err := s.pool.Exec(ctx, sql, args...)
+	tag, err := withFailover(ctx, s.lb, s.lb.Primary(), func(p *pgxpool.Pool) (pgconn.CommandTag, error) {
+		return p.Exec(ctx, sql, args...)
+	})
 	return tag.String(), err
 }
 
-func (s *Session) handleBegin(ctx context.Context) error {
-	if s.tx != nil {
-		// Already in a transaction
-		if err := s.client.SendCommandComplete("BEGIN"); err != nil {
-			return err
-		}
-		return s.client.SendReadyForQuery(s.txStatus)
-	}
-
-	tx, err := s.pool.Begin(ctx)
-	if err != nil {
-		return s.sendQueryError(err)
+// withFailover runs fn on pool. If it fails because the primary's upstream
+// is unreachable, the load balancer fails over, and fn runs once more on the
+// new primary when nothing reached the old one, so it can't run twice.
+func withFailover[T any](ctx context.Context, lb *LoadBalancer, pool *pgxpool.Pool, fn func(*pgxpool.Pool) (T, error)) (T, error) {
+	res, err := fn(pool)
+	if err == nil {
+		return res, nil
 	}
-	s.tx = tx
-	s.txStatus = pgwire.TxStatusInTx
-
-	if err := s.client.SendCommandComplete("BEGIN"); err != nil {
-		return err
+	next, ok := lb.failOver(ctx, pool, err)
+	if !ok || !pgconn.SafeToRetry(err) {
+		return res, err
 	}
-	return s.client.SendReadyForQuery(s.txStatus)
+	return fn(next)
 }
 
-func (s *Session) handleCommit(ctx context.Context) error {
+// handleBegin, handleCommit and handleRollback run transaction control for
+// runSimpleStatement, with the same results.
+func (s *Session) handleBegin(ctx context.Context) (bool, error) {
 	if s.tx == nil {
-		// Not in a transaction — Postgres sends a warning but succeeds
-		if err := s.client.SendCommandComplete("COMMIT"); err != nil {
-			return err
+		tx, err := s.lb.Primary().Begin(ctx)
+		if err != nil {
+			s.sendError(err)
+			return false, nil
 		}
-		return s.client.SendReadyForQuery(s.txStatus)
-	}
-
-	err := s.tx.Commit(ctx)
-	s.tx = nil
-	s.txStatus = pgwire.TxStatusIdle
-
-	if err != nil {
-		return s.sendQueryError(err)
+		s.tx = tx
+		s.txStatus = pgwire.TxStatusInTx
 	}
+	// If already in a transaction, Postgres warns but succeeds
+	return true, s.client.SendCommandComplete("BEGIN")
+}
 
-	if err := s.client.SendCommandComplete("COMMIT"); err != nil {
-		return err
+func (s *Session) handleCommit(ctx context.Context) (bool, error) {
+	if s.tx != nil {
+		err := s.tx.Commit(ctx)
+		s.tx = nil
+		s.txStatus = pgwire.TxStatusIdle
+		if err != nil {
+			s.sendError(err)
+			return false, nil
+		}
 	}
-	return s.client.SendReadyForQuery(s.txStatus)
+	// If not in a transaction, Postgres sends a warning but succeeds
+	return true, s.client.SendCommandComplete("COMMIT")
 }
 
-func (s *Session) handleRollback(ctx context.Context) error {
-	if s.tx == nil {
-		if err := s.client.SendCommandComplete("ROLLBACK"); err != nil {
-			return err
+func (s *Session) handleRollback(ctx context.Context) (bool, error) {
+	if s.tx != nil {
+		err := s.tx.Rollback(ctx)
+		s.tx = nil
+		s.txStatus = pgwire.TxStatusIdle
+		if err != nil {
+			s.sendError(err)
+			return false, nil
 		}
-		return s.client.SendReadyForQuery(s.txStatus)
 	}
+	return true, s.client.SendCommandComplete("ROLLBACK")
+}
 
-	err := s.tx.Rollback(ctx)
-	s.tx = nil
-	s.txStatus = pgwire.TxStatusIdle
+func (s *Session) sendQueryError(err error) error {
+	s.sendError(err)
+	return s.client.SendReadyForQuery(s.txStatus)
+}
 
-	if err != nil {
-		return s.sendQueryError(err)
+// sendError sends err to the client as an ErrorResponse with its SQLSTATE.
+func (s *Session) sendError(err error) {
+	s.telemetry.RecordError()
+	code, message, detail, hint := errorFields(err)
+	if code == pgwire.ErrCodeInternalError {
+		s.logger.Error("query failed", "error", err)
 	}
+	_ = s.client.SendErrorWithDetail("ERROR", code, message, detail, hint)
+}
 
-	if err := s.client.SendCommandComplete("ROLLBACK"); err != nil {
-		return err
+// errorFields returns the SQLSTATE, message, detail, and hint reported to the
+// client for err. Errors from the upstream database keep their own fields.
+func errorFields(err error) (code, message, detail, hint string) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code, pgErr.Message, pgErr.Detail, pgErr.Hint
 	}
-	return s.client.SendReadyForQuery(s.txStatus)
-}
 
-func (s *Session) sendQueryError(err error) error {
-	_ = s.client.SendError("ERROR", pgwire.ErrCodeInternalError, err.Error())
-	return s.client.SendReadyForQuery(s.txStatus)
+	message = err.Error()
+	switch {
+	case errors.Is(err, cow.ErrBranchProtected), errors.Is(err, errReadOnly):
+		return pgwire.ErrCodeReadOnlyTransaction, message, "", ""
+	case errors.Is(err, cow.ErrBranchFrozen):
+		return pgwire.ErrCodeReadOnlyTransaction, message, "", "Unfreeze it with 'rift branches unfreeze'."
+	case errors.Is(err, cow.ErrDeltaSizeLimit):
+		return pgwire.ErrCodeConfigLimitExceeded, message, "", "Raise the limit with 'rift branches limit-size'."
+	case errors.Is(err, storage.ErrBranchNotFound):
+		return pgwire.ErrCodeInvalidCatalogName, message, "", "Run 'rift list' to see available branches."
+	case errors.Is(err, cow.ErrTableNotFound):
+		return pgwire.ErrCodeUndefinedTable, message, "", ""
+	case errors.Is(err, errUnknownFunction):
+		return pgwire.ErrCodeUndefinedFunction, message, "", ""
+	case errors.Is(err, errVolatileFunction), errors.Is(err, errExtendedCopy), errors.Is(err, cow.ErrUnsupportedCopy):
+		return pgwire.ErrCodeFeatureNotSupported, message, "", ""
+	case errors.Is(err, cow.ErrBadCopyData):
+		return pgwire.ErrCodeBadCopyFileFormat, message, "", ""
+	case errors.Is(err, errCopyFailed):
+		return pgwire.ErrCodeQueryCanceled, message, "", ""
+	case errors.Is(err, errCopyProtocol), errors.Is(err, errResultFormats), errors.Is(err, errMalformedMessage):
+		return pgwire.ErrCodeProtocolViolation, message, "", ""
+	case parser.IsSyntaxError(err):
+		return pgwire.ErrCodeSyntaxError, message, "", ""
+	}
+	return pgwire.ErrCodeInternalError, message, "", ""
 }
 
 // Cleanup releases session resources.
 func (s *Session) Cleanup(ctx context.Context) {
 	if s.tx != nil {
-		_ = s.tx.Rollback(ctx)
+		if err := s.tx.Rollback(ctx); err != nil {
+			s.logger.Warn("roll back open transaction", "error", err)
+		}
 		s.tx = nil
 	}
+	s.closeListener(ctx)
 }
 
 func isBegin(sql string) bool {
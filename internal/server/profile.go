@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// startProfiling serves the net/http/pprof handlers under /debug/pprof/ on
+// addr. Profiles expose the process's internals, so addr must be a loopback
+// address.
+func (s *Server) startProfiling(addr string) error {
+	if err := checkLoopback(addr); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	s.profile = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	s.profileAddr = listener.Addr()
+
+	go func() {
+		if err := s.profile.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("profiling server failed", "error", err)
+		}
+	}()
+	return nil
+}
+
+// stopProfiling shuts the profiling server down, if it was started.
+func (s *Server) stopProfiling() error {
+	if s.profile == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.profile.Shutdown(ctx)
+}
+
+// ProfileAddr returns the address the profiling server listens on, or ""
+// if profiling is off.
+func (s *Server) ProfileAddr() string {
+	if s.profileAddr == nil {
+		return ""
+	}
+	return s.profileAddr.String()
+}
+
+// checkLoopback checks that addr listens on a loopback interface only.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid profile address %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("profile address %q must be a loopback address such as 127.0.0.1:6060, so profiles can't be fetched from other machines", addr)
+	}
+	return nil
+}
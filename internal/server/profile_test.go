@@ -0,0 +1,48 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+)
+
+func TestCheckLoopback(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1:6060": true,
+		"localhost:6060": true,
+		"[::1]:6060":     true,
+		":6060":          false,
+		"0.0.0.0:6060":   false,
+		"10.0.0.5:6060":  false,
+		"127.0.0.1":      false,
+	}
+	for addr, ok := range tests {
+		if err := checkLoopback(addr); (err == nil) != ok {
+			t.Errorf("checkLoopback(%q) = %v, want ok %v", addr, err, ok)
+		}
+	}
+}
+
+func TestProfiling(t *testing.T) {
+	s := New(&Config{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := s.startProfiling("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get("http://" + s.ProfileAddr() + "/debug/pprof/cmdline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /debug/pprof/cmdline = %s", resp.Status)
+	}
+
+	if err := s.stopProfiling(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get("http://" + s.ProfileAddr() + "/debug/pprof/"); err == nil {
+		t.Error("profiling server still serving after stopProfiling")
+	}
+}
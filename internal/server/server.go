@@ -3,13 +3,21 @@ package server
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/riftdata/rift/internal/api"
 	"github.com/riftdata/rift/internal/branch"
 	"github.com/riftdata/rift/internal/cow"
+	"github.com/riftdata/rift/internal/notify"
+	"github.com/riftdata/rift/internal/parser"
 	"github.com/riftdata/rift/internal/proxy"
 	"github.com/riftdata/rift/internal/router"
 	"github.com/riftdata/rift/internal/storage"
+	"github.com/riftdata/rift/internal/telemetry"
 )
 
 // Config holds server configuration.
@@ -17,39 +25,147 @@ type Config struct {
 	// Upstream PostgreSQL connection string
 	UpstreamURL string
 
+	// UpstreamPool sizes the shared upstream connection pool, and sets the
+	// read replica that branch SELECTs go to and the upstreams to fail over
+	// to. Failover needs BranchPoolSize 0.
+	UpstreamPool storage.PoolConfig
+
+	// UpstreamReplicas are connection strings of further read replicas that
+	// branch SELECTs are spread over along with UpstreamPool.ReadReplicaURL.
+	UpstreamReplicas []string
+
+	// BranchPoolSize, if set, gives each branch its own pool of at most this
+	// many upstream connections (see router.Router.BranchPoolSize).
+	BranchPoolSize int32
+
 	// Proxy settings
 	ListenAddr   string
 	UpstreamAddr string
 	UpstreamUser string
 	UpstreamPass string
 
+	// Client TLS, passed to the proxy (see proxy.Config)
+	TLSCertFile  string
+	TLSKeyFile   string
+	MutualTLS    bool
+	ClientCAFile string
+
 	// HTTP API settings
-	APIAddr string // e.g. ":8080"
+	APIAddr        string   // e.g. ":8080"
+	APIAuthToken   string   // required bearer token; empty disables auth
+	APICORSOrigins []string // origins allowed by CORS; empty disables CORS
+
+	// DrainTimeout is how long Stop waits for in-flight queries; 0 uses
+	// the proxy's default.
+	DrainTimeout time.Duration
+
+	// OnDrain, if set, is called during Stop with the number of connections
+	// still open while in-flight queries finish.
+	OnDrain func(connections int64)
 
 	// Limits
 	MaxConnections int
+	MaxOverlayRows int // 0 = unlimited
+
+	// CTEMode selects how SELECTs on branches merge overlay and source rows.
+	CTEMode parser.CTEMode
+
+	// TrackDeltaSize keeps branch delta_size current with overlay triggers.
+	TrackDeltaSize bool
+
+	// TrackAllOnCreate tracks every public table when a branch is created.
+	TrackAllOnCreate bool
+
+	// Result cache for SELECTs on frozen branches; a size of 0 disables it.
+	ResultCacheMaxSize int
+	ResultCacheTTL     time.Duration
+
+	// ColumnCacheTTL is how long table column definitions are cached; 0
+	// disables the cache.
+	ColumnCacheTTL time.Duration
+
+	// QueryLogger, if set, logs every query executed on a branch.
+	QueryLogger *router.QueryLogger
+
+	// ReadOnly rejects writes and DDL on every branch, main included.
+	ReadOnly bool
+
+	// MaintenanceMode starts the proxy rejecting new connections; it can be
+	// toggled at runtime through the API.
+	MaintenanceMode bool
+
+	// AnalyzeThreshold is how many rows a branch's overlay tables take
+	// between runs of VACUUM ANALYZE on them; 0 disables automatic analyze.
+	AnalyzeThreshold int64
+
+	// GCInterval is how often branches soft-deleted more than Retention ago
+	// are purged; 0 disables purging.
+	GCInterval time.Duration
+	Retention  time.Duration
+
+	// Telemetry, if set, reports anonymous usage metrics while the server
+	// runs. Only set when the user opted in with telemetry.enabled.
+	Telemetry *telemetry.Reporter
+
+	// Notifier, if set, reports stale branches every Notifier.Interval
+	// (notifications.cron_notifications).
+	Notifier *notify.Notifier
+
+	// ProfileAddr, if set, serves the net/http/pprof handlers on this
+	// address, which must be a loopback address (rift serve --profile).
+	ProfileAddr string
+
+	// Logger receives the logs of the server and its proxy, router and API;
+	// nil uses slog.Default().
+	Logger *slog.Logger
 }
 
 // Server orchestrates all rift components: storage, engine, router, proxy, API.
 type Server struct {
 	config  *Config
+	logger  *slog.Logger
 	store   storage.Store
 	engine  *cow.Engine
 	manager *branch.StorageBackedManager
 	proxy   *proxy.Proxy
 	router  *router.Router
 	api     *api.Server
+
+	// replicaPools are the pools of UpstreamReplicas
+	replicaPools []*pgxpool.Pool
+
+	// profile serves pprof on profileAddr when ProfileAddr is set
+	profile     *http.Server
+	profileAddr net.Addr
+
+	stopBackground context.CancelFunc
 }
 
+// autoAnalyzeInterval is how often the server checks whether overlay tables
+// need their statistics refreshed.
+const autoAnalyzeInterval = time.Minute
+
+// replicaHealthInterval is how often read replicas, and the upstreams of
+// UpstreamPool.FailoverURLs, are health checked.
+const replicaHealthInterval = 10 * time.Second
+
 // New creates a new server with the given config.
 func New(cfg *Config) *Server {
-	return &Server{config: cfg}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Server{config: cfg, logger: logger}
 }
 
 // Start initializes storage, engine, router, proxy and starts serving.
 func (s *Server) Start(ctx context.Context) error {
+	if len(s.config.UpstreamPool.FailoverURLs) > 0 && s.config.BranchPoolSize > 0 {
+		return fmt.Errorf("upstream failover can't be used with per-branch pools (upstream.branch_pool_size)")
+	}
+
 	// Initialize storage
-	store, err := storage.New(ctx, s.config.UpstreamURL)
+	store, err := storage.NewWithPool(ctx, s.config.UpstreamURL, s.config.UpstreamPool)
 	if err != nil {
 		return fmt.Errorf("connect to upstream: %w", err)
 	}
@@ -62,14 +178,48 @@ func (s *Server) Start(ctx context.Context) error {
 
 	// Create engine and manager
 	s.engine = cow.NewEngine(store)
+	s.engine.SetMaxOverlayRows(s.config.MaxOverlayRows)
+	s.engine.SetCTEMode(s.config.CTEMode)
+	s.engine.SetTrackDeltaSize(s.config.TrackDeltaSize)
+	s.engine.SetTrackAllOnCreate(s.config.TrackAllOnCreate)
+	s.engine.SetResultCache(s.config.ResultCacheMaxSize, s.config.ResultCacheTTL)
+	s.engine.SetColumnCacheTTL(s.config.ColumnCacheTTL)
 	s.manager = branch.NewStorageBackedManager(store)
 
-	// Create router
-	s.router = router.New(store.Pool(), s.engine)
+	// Create router, spreading reads over the read replicas
+	for _, url := range s.config.UpstreamReplicas {
+		pool, err := storage.OpenPool(ctx, url, s.config.UpstreamPool)
+		if err != nil {
+			s.closeReplicas()
+			store.Close()
+			return fmt.Errorf("connect to replica: %w", err)
+		}
+		s.replicaPools = append(s.replicaPools, pool)
+	}
+	lb := router.NewLoadBalancer(store.WritePool(), append([]*pgxpool.Pool{store.ReadPool()}, s.replicaPools...)...)
+	lb.CheckHealth(ctx)
+	failover := store.Failover()
+	if failover != nil {
+		failover.Logger = s.logger
+		lb.SetFailover(failover)
+	}
+	s.router = router.New(lb, s.engine)
+	s.router.QueryLogger = s.config.QueryLogger
+	s.router.Logger = s.logger
+	s.router.ReadOnly = s.config.ReadOnly
+	s.router.BranchPoolSize = s.config.BranchPoolSize
+	s.router.TrackActivity(store)
+	if s.config.Telemetry != nil {
+		s.router.Telemetry = s.config.Telemetry.Collector
+	}
 
 	// Create and configure proxy
 	s.proxy = proxy.New(s.buildProxyConfig())
 	s.proxy.Router = s.router
+	s.proxy.OnDrain = s.config.OnDrain
+	if failover != nil {
+		s.proxy.UpstreamAddr = failover.Addr
+	}
 
 	// Set up authentication — accept any credentials that match upstream user,
 	// or accept all if no upstream user is configured.
@@ -84,7 +234,7 @@ func (s *Server) Start(ctx context.Context) error {
 	}
 
 	// Set up branch resolution hook
-	s.proxy.OnConnect = func(database string) (string, error) {
+	s.proxy.OnConnect = func(database string, remoteAddr net.Addr) (string, error) {
 		if database == "main" || database == "" {
 			return database, nil
 		}
@@ -92,6 +242,10 @@ func (s *Server) Start(ctx context.Context) error {
 		if !s.manager.Exists(ctx, database) {
 			return "", fmt.Errorf("branch %q not found", database)
 		}
+		// Enforce the branch's allowed hosts
+		if err := s.manager.CheckHost(ctx, database, remoteAddr); err != nil {
+			return "", err
+		}
 		db, err := s.manager.ResolveDatabase(ctx, database)
 		if err != nil {
 			return "", err
@@ -101,19 +255,66 @@ func (s *Server) Start(ctx context.Context) error {
 
 	// Start proxy
 	if err := s.proxy.Start(); err != nil {
+		s.closeReplicas()
 		store.Close()
 		return fmt.Errorf("start proxy: %w", err)
 	}
+	s.logger.Info("proxy listening", "addr", s.Addr())
 
 	// Start HTTP API if configured
 	if s.config.APIAddr != "" {
-		apiCfg := &api.Config{ListenAddr: s.config.APIAddr}
+		apiCfg := &api.Config{
+			ListenAddr:  s.config.APIAddr,
+			AuthToken:   s.config.APIAuthToken,
+			CORSOrigins: s.config.APICORSOrigins,
+			ProxyAddr:   s.Addr(),
+			ReadOnly:    s.config.ReadOnly,
+			PoolStats:   s.router.PoolStats,
+			Maintenance: s.proxy,
+			Logger:      s.logger,
+		}
 		s.api = api.New(apiCfg, store, s.engine, s.manager)
 		if err := s.api.Start(); err != nil {
 			_ = s.proxy.Stop()
+			s.closeReplicas()
 			store.Close()
 			return fmt.Errorf("start api: %w", err)
 		}
+		s.logger.Info("api listening", "addr", s.api.Addr())
+	}
+
+	if s.config.ProfileAddr != "" {
+		if err := s.startProfiling(s.config.ProfileAddr); err != nil {
+			if s.api != nil {
+				_ = s.api.Stop(context.Background())
+			}
+			_ = s.proxy.Stop()
+			s.closeReplicas()
+			store.Close()
+			return fmt.Errorf("start profiling: %w", err)
+		}
+		s.logger.Info("profiling listening", "addr", s.ProfileAddr())
+	}
+
+	// Keep overlay statistics fresh, purge deleted branches past retention,
+	// report usage metrics and stale branches in the background
+	bgCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	s.stopBackground = cancel
+	if s.config.AnalyzeThreshold > 0 {
+		go s.engine.AutoAnalyze(bgCtx, s.config.AnalyzeThreshold, autoAnalyzeInterval)
+	}
+	if s.config.GCInterval > 0 {
+		go s.engine.AutoPurge(bgCtx, s.config.Retention, s.config.GCInterval)
+	}
+	if s.config.Telemetry != nil {
+		go s.config.Telemetry.Run(bgCtx, store)
+	}
+	if s.config.Notifier != nil {
+		go s.config.Notifier.Run(bgCtx, store)
+	}
+	go lb.RunHealthChecks(bgCtx, replicaHealthInterval)
+	if failover != nil {
+		go failover.RunHealthChecks(bgCtx, replicaHealthInterval)
 	}
 
 	return nil
@@ -123,6 +324,10 @@ func (s *Server) Start(ctx context.Context) error {
 func (s *Server) Stop() error {
 	var firstErr error
 
+	if s.stopBackground != nil {
+		s.stopBackground()
+	}
+
 	if s.api != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*1e9) // 5s
 		if err := s.api.Stop(ctx); err != nil && firstErr == nil {
@@ -131,19 +336,37 @@ func (s *Server) Stop() error {
 		cancel()
 	}
 
+	if err := s.stopProfiling(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
 	if s.proxy != nil {
 		if err := s.proxy.Stop(); err != nil && firstErr == nil {
 			firstErr = err
 		}
 	}
 
+	if s.router != nil {
+		s.router.Close()
+	}
+	s.closeReplicas()
+
 	if s.store != nil {
 		s.store.Close()
 	}
 
+	s.logger.Info("server stopped")
 	return firstErr
 }
 
+// closeReplicas closes the pools of UpstreamReplicas.
+func (s *Server) closeReplicas() {
+	for _, pool := range s.replicaPools {
+		pool.Close()
+	}
+	s.replicaPools = nil
+}
+
 // Store returns the underlying storage for direct access.
 func (s *Server) Store() storage.Store {
 	return s.store
@@ -193,5 +416,14 @@ func (s *Server) buildProxyConfig() *proxy.Config {
 	if s.config.MaxConnections > 0 {
 		cfg.MaxConnections = s.config.MaxConnections
 	}
+	if s.config.DrainTimeout > 0 {
+		cfg.DrainTimeout = s.config.DrainTimeout
+	}
+	cfg.MaintenanceMode = s.config.MaintenanceMode
+	cfg.TLSCertFile = s.config.TLSCertFile
+	cfg.TLSKeyFile = s.config.TLSKeyFile
+	cfg.MutualTLS = s.config.MutualTLS
+	cfg.ClientCAFile = s.config.ClientCAFile
+	cfg.Logger = s.logger
 	return cfg
 }
@@ -0,0 +1,323 @@
+// Package snapshot fills a branch's overlay tables with a copy of their
+// source tables taken with pg_dump, for a point-in-time branch on upstreams
+// where logical replication isn't available.
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/riftdata/rift/internal/cow"
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// Table is a source table to snapshot.
+type Table struct {
+	Schema string
+	Name   string
+}
+
+// String returns the table as schema.name.
+func (t Table) String() string {
+	return t.Schema + "." + t.Name
+}
+
+// ParseTables parses table names given as table or schema.table; tables
+// without a schema are in public.
+func ParseTables(names []string) ([]Table, error) {
+	tables := make([]Table, 0, len(names))
+	for _, name := range names {
+		schema, table, ok := strings.Cut(strings.TrimSpace(name), ".")
+		if !ok {
+			schema, table = "public", schema
+		}
+		if schema == "" || table == "" {
+			return nil, fmt.Errorf("invalid table %q (use table or schema.table)", name)
+		}
+		tables = append(tables, Table{Schema: schema, Name: table})
+	}
+	return tables, nil
+}
+
+// Create copies the current rows of tables from the database at upstreamURL
+// into the overlays of branchName in the database at targetURL, normally the
+// same database, and tracks them on the branch. The branch then keeps
+// seeing each table as it was when the dump started, apart from rows added
+// to the source afterwards, which still show through.
+//
+// The rows are dumped with pg_dump in the compressed custom format and
+// turned back into COPY statements by pg_restore; both must be in PATH. The
+// COPY statements are redirected to the overlay schema and loaded in a single
+// transaction, so a failed restore leaves the overlays empty. Partitioned
+// tables are not supported. opts is passed to cow.EnsureOverlayTable, with
+// BranchName set to branchName.
+func Create(ctx context.Context, store storage.Store, upstreamURL, targetURL, branchName string, names []string, opts cow.OverlayOptions) error {
+	tables, err := ParseTables(names)
+	if err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		return fmt.Errorf("no tables to snapshot")
+	}
+	pgDump, err := exec.LookPath("pg_dump")
+	if err != nil {
+		return fmt.Errorf("snapshot requires pg_dump in PATH")
+	}
+	pgRestore, err := exec.LookPath("pg_restore")
+	if err != nil {
+		return fmt.Errorf("snapshot requires pg_restore in PATH")
+	}
+
+	pool := store.Pool()
+	branchSchema := store.BranchSchemaName(branchName)
+	opts.BranchName = branchName
+	for _, t := range tables {
+		partitions, err := cow.IntrospectPartitions(ctx, pool, t.Schema, t.Name)
+		if err != nil {
+			return err
+		}
+		if partitions != nil {
+			return fmt.Errorf("%s is partitioned; snapshots of partitioned tables are not supported", t)
+		}
+		if err := cow.EnsureOverlayTable(ctx, pool, branchSchema, t.Schema, t.Name, opts); err != nil {
+			return fmt.Errorf("ensure overlay for %s: %w", t, err)
+		}
+	}
+
+	if err := restore(ctx, pgDump, pgRestore, upstreamURL, targetURL, branchSchema, tables); err != nil {
+		return err
+	}
+
+	var pkEntries []storage.PrimaryKeyColumn
+	for _, t := range tables {
+		if err := store.TrackTable(ctx, &storage.TrackedTable{
+			BranchName:   branchName,
+			SourceSchema: t.Schema,
+			TableName:    t.Name,
+			OverlayTable: t.Name,
+		}); err != nil {
+			return fmt.Errorf("track table %s: %w", t, err)
+		}
+		pkCols, err := cow.GetTablePrimaryKeys(ctx, pool, t.Schema, t.Name)
+		if err != nil {
+			return fmt.Errorf("get PKs for %s: %w", t, err)
+		}
+		for i, col := range pkCols {
+			pkEntries = append(pkEntries, storage.PrimaryKeyColumn{
+				SourceSchema: t.Schema,
+				TableName:    t.Name,
+				ColumnName:   col,
+				Ordinal:      i + 1,
+			})
+		}
+	}
+	if err := store.BulkCachePrimaryKeys(ctx, pkEntries); err != nil {
+		return fmt.Errorf("cache PKs: %w", err)
+	}
+	return nil
+}
+
+// dumpArgs returns the pg_dump arguments that dump the rows of tables from
+// the database at dbURL in the custom format, which is compressed.
+func dumpArgs(dbURL string, tables []Table) []string {
+	args := []string{"--dbname", dbURL, "--format", "custom", "--data-only", "--no-owner", "--no-privileges"}
+	for _, t := range tables {
+		args = append(args, "--table", quoteIdent(t.Schema)+"."+quoteIdent(t.Name))
+	}
+	return args
+}
+
+// restoreArgs returns the pg_restore arguments that turn a custom-format
+// archive on stdin into SQL on stdout.
+func restoreArgs() []string {
+	return []string{"--data-only", "--no-owner", "--no-privileges", "--file", "-"}
+}
+
+// restore runs pg_dump | pg_restore and loads the COPY statements in the
+// resulting SQL into the overlays. The load commits only once both commands
+// have succeeded.
+func restore(ctx context.Context, pgDump, pgRestore, upstreamURL, targetURL, branchSchema string, tables []Table) error {
+	conn, err := pgx.Connect(ctx, targetURL)
+	if err != nil {
+		return fmt.Errorf("connect to target database: %w", err)
+	}
+	defer func() { _ = conn.Close(context.Background()) }()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	dump := exec.CommandContext(ctx, pgDump, dumpArgs(upstreamURL, tables)...) // #nosec G204 -- fixed client binary, arguments built by rift
+	restore := exec.CommandContext(ctx, pgRestore, restoreArgs()...)           // #nosec G204 -- fixed client binary, arguments built by rift
+	var dumpErr, restoreErr bytes.Buffer
+	dump.Stderr = &dumpErr
+	restore.Stderr = &restoreErr
+	archive, err := dump.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	restore.Stdin = archive
+	sql, err := restore.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := dump.Start(); err != nil {
+		return fmt.Errorf("pg_dump: %w", err)
+	}
+	if err := restore.Start(); err != nil {
+		cancel()
+		_ = dump.Wait()
+		return fmt.Errorf("pg_restore: %w", err)
+	}
+
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		loadErr := load(ctx, tx, sql, branchSchema, tables)
+		if loadErr != nil {
+			cancel()
+		}
+		restoreWait := restore.Wait()
+		dumpWait := dump.Wait()
+		switch {
+		case loadErr != nil:
+			return fmt.Errorf("restore into %s: %w", branchSchema, loadErr)
+		case dumpWait != nil:
+			return fmt.Errorf("pg_dump: %w: %s", dumpWait, strings.TrimSpace(dumpErr.String()))
+		case restoreWait != nil:
+			return fmt.Errorf("pg_restore: %w: %s", restoreWait, strings.TrimSpace(restoreErr.String()))
+		}
+		return nil
+	})
+}
+
+// load reads the SQL script pg_restore writes and runs each of its COPY
+// statements for one of tables on tx, redirected to the same table in
+// branchSchema. Everything else in the script, such as SET commands or
+// sequence values, is skipped, since it would change the source database.
+func load(ctx context.Context, tx pgx.Tx, script io.Reader, branchSchema string, tables []Table) error {
+	wanted := make(map[Table]bool, len(tables))
+	for _, t := range tables {
+		wanted[t] = true
+	}
+
+	r := bufio.NewReaderSize(script, 64*1024)
+	for {
+		line, err := r.ReadString('\n')
+		if errors.Is(err, io.EOF) && line == "" {
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		stmt, table, ok, err := rewriteCopy(strings.TrimRight(line, "\r\n"), branchSchema)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if !wanted[table] {
+			return fmt.Errorf("dump contains unexpected table %s", table)
+		}
+		if _, err := tx.Conn().PgConn().CopyFrom(ctx, &copyData{r: r}, stmt); err != nil {
+			return fmt.Errorf("copy %s: %w", table, err)
+		}
+	}
+}
+
+// rewriteCopy rewrites a "COPY schema.table (columns) FROM stdin;" line of a
+// pg_restore script to load into the same table in branchSchema, reporting
+// ok=false for any other line.
+func rewriteCopy(line, branchSchema string) (stmt string, table Table, ok bool, err error) {
+	rest, found := strings.CutPrefix(line, "COPY ")
+	if !found || !strings.HasSuffix(rest, " FROM stdin;") {
+		return "", Table{}, false, nil
+	}
+	rest = strings.TrimSuffix(rest, " FROM stdin;")
+
+	schema, rest, err := scanIdent(rest)
+	if err != nil {
+		return "", Table{}, false, fmt.Errorf("parse %q: %w", line, err)
+	}
+	rest, found = strings.CutPrefix(rest, ".")
+	if !found {
+		return "", Table{}, false, fmt.Errorf("parse %q: table is not schema-qualified", line)
+	}
+	name, rest, err := scanIdent(rest)
+	if err != nil {
+		return "", Table{}, false, fmt.Errorf("parse %q: %w", line, err)
+	}
+
+	table = Table{Schema: schema, Name: name}
+	stmt = "COPY " + quoteIdent(branchSchema) + "." + quoteIdent(name) + rest + " FROM STDIN"
+	return stmt, table, true, nil
+}
+
+// scanIdent reads one identifier, quoted or not, from the start of s and
+// returns it unquoted along with the rest of s.
+func scanIdent(s string) (ident, rest string, err error) {
+	if strings.HasPrefix(s, `"`) {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '"' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '"' {
+				b.WriteByte('"')
+				i++
+				continue
+			}
+			return b.String(), s[i+1:], nil
+		}
+		return "", "", fmt.Errorf("unterminated quoted identifier")
+	}
+	end := strings.IndexAny(s, ". (")
+	if end < 0 {
+		end = len(s)
+	}
+	if end == 0 {
+		return "", "", fmt.Errorf("missing identifier")
+	}
+	return s[:end], s[end:], nil
+}
+
+func quoteIdent(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+// copyData reads the data of a COPY statement in a pg_restore script, up to
+// the \. line that ends it.
+type copyData struct {
+	r       *bufio.Reader
+	buf     []byte
+	midLine bool // the last read ended inside a row too long for r's buffer
+	done    bool
+}
+
+func (c *copyData) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		line, err := c.r.ReadSlice('\n')
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return 0, fmt.Errorf("COPY data ended without \\.: %w", err)
+		}
+		if !c.midLine && string(line) == "\\.\n" {
+			c.done = true
+			return 0, io.EOF
+		}
+		c.midLine = errors.Is(err, bufio.ErrBufferFull)
+		c.buf = append(c.buf[:0], line...)
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
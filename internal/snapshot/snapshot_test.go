@@ -0,0 +1,94 @@
+package snapshot
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTables(t *testing.T) {
+	got, err := ParseTables([]string{"users", " sales.orders "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Table{{"public", "users"}, {"sales", "orders"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseTables = %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"", ".users", "sales."} {
+		if _, err := ParseTables([]string{bad}); err == nil {
+			t.Errorf("ParseTables(%q): expected error", bad)
+		}
+	}
+}
+
+func TestDumpArgs(t *testing.T) {
+	got := dumpArgs("postgres://localhost/app", []Table{{"public", "users"}, {"Sales", "orders"}})
+	want := []string{
+		"--dbname", "postgres://localhost/app", "--format", "custom", "--data-only", "--no-owner", "--no-privileges",
+		"--table", `"public"."users"`, "--table", `"Sales"."orders"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dumpArgs = %q, want %q", got, want)
+	}
+}
+
+func TestRewriteCopy(t *testing.T) {
+	tests := []struct {
+		line  string
+		stmt  string
+		table Table
+		ok    bool
+	}{
+		{"COPY public.users (id, name) FROM stdin;",
+			`COPY "_rift_branch_dev"."users" (id, name) FROM STDIN`, Table{"public", "users"}, true},
+		{`COPY "My Schema"."Odd ""Name""" (id) FROM stdin;`,
+			`COPY "_rift_branch_dev"."Odd ""Name""" (id) FROM STDIN`, Table{"My Schema", `Odd "Name"`}, true},
+		{"SET statement_timeout = 0;", "", Table{}, false},
+		{"SELECT pg_catalog.setval('public.users_id_seq', 42, true);", "", Table{}, false},
+		{"", "", Table{}, false},
+	}
+	for _, tt := range tests {
+		stmt, table, ok, err := rewriteCopy(tt.line, "_rift_branch_dev")
+		if err != nil {
+			t.Errorf("rewriteCopy(%q): %v", tt.line, err)
+			continue
+		}
+		if stmt != tt.stmt || table != tt.table || ok != tt.ok {
+			t.Errorf("rewriteCopy(%q) = %q, %v, %v; want %q, %v, %v",
+				tt.line, stmt, table, ok, tt.stmt, tt.table, tt.ok)
+		}
+	}
+
+	for _, bad := range []string{"COPY users (id) FROM stdin;", `COPY "public.users (id) FROM stdin;`} {
+		if _, _, _, err := rewriteCopy(bad, "_rift_branch_dev"); err == nil {
+			t.Errorf("rewriteCopy(%q): expected error", bad)
+		}
+	}
+}
+
+func TestCopyData(t *testing.T) {
+	long := strings.Repeat("x", 40) + "\n"
+	script := "1\talice\n" + long + "\\.\n" + "SET x = 1;\n"
+	// A buffer smaller than the long row splits it across reads
+	r := bufio.NewReaderSize(strings.NewReader(script), 16)
+
+	data, err := io.ReadAll(&copyData{r: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1\talice\n" + long; string(data) != want {
+		t.Errorf("copy data = %q, want %q", data, want)
+	}
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "SET x = 1;\n" {
+		t.Errorf("rest of script = %q, want the line after \\.", rest)
+	}
+
+	if _, err := io.ReadAll(&copyData{r: bufio.NewReader(strings.NewReader("1\talice\n"))}); err == nil {
+		t.Error("expected error for COPY data without \\.")
+	}
+}
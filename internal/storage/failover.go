@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// failoverCheckTimeout bounds each upstream's SELECT 1, in health checks and
+// when a failover tries the next upstream.
+const failoverCheckTimeout = 5 * time.Second
+
+// UpstreamFailoverManager tracks the health of several upstream databases
+// that can each act as the primary, such as a primary and its standbys
+// behind a promotion tool, and provides the pool of the one in use. When
+// that one becomes unreachable, Failover or a health check switches to the
+// next reachable upstream in order, wrapping around, and logs both
+// addresses. It doesn't switch back on its own when the old upstream
+// recovers, so writes don't flap between databases.
+type UpstreamFailoverManager struct {
+	upstreams []*upstream
+	current   atomic.Int64
+
+	// mu serializes switching upstreams
+	mu sync.Mutex
+
+	// Logger receives failover events; nil uses slog.Default().
+	Logger *slog.Logger
+}
+
+// upstream is one upstream's pool and its last health check result.
+type upstream struct {
+	addr    string
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+}
+
+// NewUpstreamFailoverManager creates a manager over pools, in failover
+// order, using pools[current]. Every upstream starts out healthy. The
+// pools stay owned by the caller.
+func NewUpstreamFailoverManager(current int, pools ...*pgxpool.Pool) *UpstreamFailoverManager {
+	m := &UpstreamFailoverManager{}
+	for _, pool := range pools {
+		u := &upstream{addr: poolAddr(pool), pool: pool}
+		u.healthy.Store(true)
+		m.upstreams = append(m.upstreams, u)
+	}
+	m.current.Store(int64(current))
+	return m
+}
+
+// Pool returns the pool of the upstream in use.
+func (m *UpstreamFailoverManager) Pool() *pgxpool.Pool {
+	return m.upstreams[m.current.Load()].pool
+}
+
+// Addr returns the host:port of the upstream in use.
+func (m *UpstreamFailoverManager) Addr() string {
+	return m.upstreams[m.current.Load()].addr
+}
+
+// Pools returns every upstream's pool, in failover order.
+func (m *UpstreamFailoverManager) Pools() []*pgxpool.Pool {
+	pools := make([]*pgxpool.Pool, len(m.upstreams))
+	for i, u := range m.upstreams {
+		pools[i] = u.pool
+	}
+	return pools
+}
+
+// Failover is called when a query on failed couldn't reach its upstream.
+// If failed is the pool in use and its upstream doesn't answer SELECT 1
+// either, it is marked unhealthy and Failover switches to the next upstream
+// in order that answers, trying upstreams last seen healthy first. It
+// returns the pool in use afterwards and whether it differs from failed;
+// when failed still answers or no other upstream does, failed stays in use.
+func (m *UpstreamFailoverManager) Failover(ctx context.Context, failed *pgxpool.Pool) (*pgxpool.Pool, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// The query's own deadline may be what it failed on
+	ctx = context.WithoutCancel(ctx)
+
+	cur := m.upstreams[m.current.Load()]
+	if cur.pool != failed {
+		// Another session failed over already
+		return cur.pool, true
+	}
+	// A query timing out doesn't mean the upstream is down
+	if ping(ctx, cur.pool) == nil {
+		return cur.pool, false
+	}
+	cur.healthy.Store(false)
+
+	candidates := m.after(int(m.current.Load()))
+	for _, wantHealthy := range []bool{true, false} {
+		for _, i := range candidates {
+			u := m.upstreams[i]
+			if u.healthy.Load() != wantHealthy {
+				continue
+			}
+			if err := ping(ctx, u.pool); err != nil {
+				u.healthy.Store(false)
+				continue
+			}
+			u.healthy.Store(true)
+			m.switchTo(i, "query failed")
+			return u.pool, true
+		}
+	}
+	m.logger().Error("upstream unreachable and no failover upstream answers", "upstream", cur.addr)
+	return cur.pool, false
+}
+
+// CheckHealth runs SELECT 1 on every upstream, concurrently and with a
+// timeout of five seconds each, and switches to the next healthy upstream
+// in order if the one in use failed.
+func (m *UpstreamFailoverManager) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, u := range m.upstreams {
+		wg.Go(func() {
+			u.healthy.Store(ping(ctx, u.pool) == nil)
+		})
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.upstreams[m.current.Load()].healthy.Load() {
+		return
+	}
+	for _, i := range m.after(int(m.current.Load())) {
+		if m.upstreams[i].healthy.Load() {
+			m.switchTo(i, "health check failed")
+			return
+		}
+	}
+}
+
+// RunHealthChecks runs CheckHealth every interval until ctx is done.
+func (m *UpstreamFailoverManager) RunHealthChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		m.CheckHealth(ctx)
+	}
+}
+
+// after returns the indexes of the upstreams other than i, in round-robin
+// order starting after it.
+func (m *UpstreamFailoverManager) after(i int) []int {
+	n := len(m.upstreams)
+	idx := make([]int, 0, n-1)
+	for k := 1; k < n; k++ {
+		idx = append(idx, (i+k)%n)
+	}
+	return idx
+}
+
+// switchTo makes upstream i the one in use. m.mu must be held.
+func (m *UpstreamFailoverManager) switchTo(i int, reason string) {
+	from := m.upstreams[m.current.Load()].addr
+	m.current.Store(int64(i))
+	m.logger().Warn("upstream failover", "from", from, "to", m.upstreams[i].addr, "reason", reason)
+}
+
+func (m *UpstreamFailoverManager) logger() *slog.Logger {
+	if m.Logger != nil {
+		return m.Logger
+	}
+	return slog.Default()
+}
+
+func ping(ctx context.Context, pool *pgxpool.Pool) error {
+	ctx, cancel := context.WithTimeout(ctx, failoverCheckTimeout)
+	defer cancel()
+	var one int
+	return pool.QueryRow(ctx, "SELECT 1").Scan(&one)
+}
+
+// poolAddr returns the host:port a pool connects to, for logs; it leaves
+// out the credentials of the connection string.
+func poolAddr(pool *pgxpool.Pool) string {
+	cc := pool.Config().ConnConfig
+	if strings.HasPrefix(cc.Host, "/") {
+		return cc.Host // Unix socket directory
+	}
+	return net.JoinHostPort(cc.Host, strconv.Itoa(int(cc.Port)))
+}
+
+// IsConnectionError reports whether err means the upstream couldn't be
+// reached or dropped the connection, as opposed to an error in the query:
+// a failed connect, a network error or timeout, a connection closed
+// mid-query, or a server shutting down or not accepting connections.
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) || pgconn.Timeout(err) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// connection_exception, admin_shutdown, crash_shutdown, cannot_connect_now
+		return strings.HasPrefix(pgErr.Code, "08") || pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03"
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BranchFilter narrows ListBranchesFilter. Zero-valued fields match every
+// branch that hasn't been soft-deleted.
+type BranchFilter struct {
+	Status        string
+	Pinned        *bool
+	ParentName    string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+
+	// ActiveAfter matches branches queried after it. InactiveBefore matches
+	// branches not queried since it, counting never queried branches as
+	// last used when they were created.
+	ActiveAfter    time.Time
+	InactiveBefore time.Time
+
+	// NamePattern is a SQL LIKE pattern, e.g. "feature-%".
+	NamePattern string
+
+	// Inclusive bounds on delta_size (bytes) and rows_changed; nil leaves
+	// that side unbounded.
+	MinDeltaSize   *int64
+	MaxDeltaSize   *int64
+	MinRowsChanged *int64
+	MaxRowsChanged *int64
+
+	// IncludeDeleted also matches soft-deleted branches, which are otherwise
+	// left out unless Status is "deleted".
+	IncludeDeleted bool
+}
+
+// BranchFilterKeys are the keys accepted by ParseBranchFilter.
+var BranchFilterKeys = []string{"status", "pinned", "parent", "name", "created_after", "created_before", "updated_after", "updated_before"}
+
+// ParseBranchFilter parses filter expressions such as "status=active",
+// "parent=main,pinned=true" or "name=feature-*". Names are shell-style globs
+// ("*" and "?"); dates (created_after, updated_before, ...) are RFC 3339
+// timestamps or YYYY-MM-DD.
+func ParseBranchFilter(exprs []string) (BranchFilter, error) {
+	var f BranchFilter
+	for _, expr := range exprs {
+		for _, term := range strings.Split(expr, ",") {
+			term = strings.TrimSpace(term)
+			if term == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(term, "=")
+			if !ok {
+				return f, fmt.Errorf("invalid filter %q: expected key=value", term)
+			}
+			if err := f.Set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+				return f, err
+			}
+		}
+	}
+	return f, nil
+}
+
+// Set sets the filter field for one of BranchFilterKeys.
+func (f *BranchFilter) Set(key, value string) error {
+	switch key {
+	case "status":
+		f.Status = value
+	case "pinned":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid filter pinned=%q: expected true or false", value)
+		}
+		f.Pinned = &b
+	case "parent":
+		f.ParentName = value
+	case "name":
+		f.NamePattern = globToLike(value)
+	case "created_after", "created_before", "updated_after", "updated_before":
+		t, err := parseFilterTime(value)
+		if err != nil {
+			return fmt.Errorf("invalid filter %s=%q: %w", key, value, err)
+		}
+		switch key {
+		case "created_after":
+			f.CreatedAfter = t
+		case "created_before":
+			f.CreatedBefore = t
+		case "updated_after":
+			f.UpdatedAfter = t
+		case "updated_before":
+			f.UpdatedBefore = t
+		}
+	default:
+		return fmt.Errorf("unknown filter %q (expected one of %s)", key, strings.Join(BranchFilterKeys, ", "))
+	}
+	return nil
+}
+
+func parseFilterTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 timestamp or YYYY-MM-DD")
+	}
+	return t, nil
+}
+
+// globToLike converts a shell-style glob to a LIKE pattern, escaping LIKE's
+// own wildcards.
+func globToLike(glob string) string {
+	var b strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteByte('%')
+		case '?':
+			b.WriteByte('_')
+		case '%', '_', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// where returns the SQL WHERE clause (empty if the filter matches everything)
+// and its arguments.
+func (f BranchFilter) where() (string, []any) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Status != "" {
+		add("status = $%d", f.Status)
+	}
+	if f.Pinned != nil {
+		add("pinned = $%d", *f.Pinned)
+	}
+	if f.ParentName != "" {
+		add("parent = $%d", f.ParentName)
+	}
+	if !f.CreatedAfter.IsZero() {
+		add("created_at > $%d", f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		add("created_at < $%d", f.CreatedBefore)
+	}
+	if !f.UpdatedAfter.IsZero() {
+		add("updated_at > $%d", f.UpdatedAfter)
+	}
+	if !f.UpdatedBefore.IsZero() {
+		add("updated_at < $%d", f.UpdatedBefore)
+	}
+	if !f.ActiveAfter.IsZero() {
+		add("last_query_at > $%d", f.ActiveAfter)
+	}
+	if !f.InactiveBefore.IsZero() {
+		add("COALESCE(last_query_at, created_at) < $%d", f.InactiveBefore)
+	}
+	if f.NamePattern != "" {
+		add("name LIKE $%d", f.NamePattern)
+	}
+	if f.MinDeltaSize != nil {
+		add("delta_size >= $%d", *f.MinDeltaSize)
+	}
+	if f.MaxDeltaSize != nil {
+		add("delta_size <= $%d", *f.MaxDeltaSize)
+	}
+	if f.MinRowsChanged != nil {
+		add("rows_changed >= $%d", *f.MinRowsChanged)
+	}
+	if f.MaxRowsChanged != nil {
+		add("rows_changed <= $%d", *f.MaxRowsChanged)
+	}
+	if !f.IncludeDeleted && f.Status != "deleted" {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
@@ -57,6 +57,29 @@ func runMigrations(ctx context.Context, pool *pgxpool.Pool) error {
 	return nil
 }
 
+// LatestSchemaVersion returns the highest migration version embedded in this binary.
+func LatestSchemaVersion() (int, error) {
+	entries, err := migrationFS.ReadDir("migrations")
+	if err != nil {
+		return 0, fmt.Errorf("reading migrations dir: %w", err)
+	}
+
+	latest := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		version, err := parseMigrationVersion(entry.Name())
+		if err != nil {
+			return 0, fmt.Errorf("parsing migration filename %s: %w", entry.Name(), err)
+		}
+		if version > latest {
+			latest = version
+		}
+	}
+	return latest, nil
+}
+
 // parseMigrationVersion extracts the version number from a filename like "001_init.sql".
 func parseMigrationVersion(filename string) (int, error) {
 	parts := strings.SplitN(filename, "_", 2)
@@ -66,15 +89,35 @@ func parseMigrationVersion(filename string) (int, error) {
 	return strconv.Atoi(parts[0])
 }
 
-// isMigrationApplied checks if a migration version has already been applied.
-// Returns false if the schema_version table doesn't exist yet (first run).
-func isMigrationApplied(ctx context.Context, pool *pgxpool.Pool, version int) (bool, error) {
+// currentSchemaVersion returns the highest applied migration version.
+// Returns 0 if the schema_version table doesn't exist yet (not initialized).
+func currentSchemaVersion(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	exists, err := schemaVersionTableExists(ctx, pool)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+	err = pool.QueryRow(ctx,
+		`SELECT COALESCE(MAX(version), 0) FROM _rift.schema_version`).Scan(&version)
+	return version, err
+}
+
+// schemaVersionTableExists reports whether _rift.schema_version has been created.
+func schemaVersionTableExists(ctx context.Context, pool *pgxpool.Pool) (bool, error) {
 	var exists bool
 	err := pool.QueryRow(ctx,
 		`SELECT EXISTS(
 			SELECT 1 FROM information_schema.tables
 			WHERE table_schema = '_rift' AND table_name = 'schema_version'
 		)`).Scan(&exists)
+	return exists, err
+}
+
+// isMigrationApplied checks if a migration version has already been applied.
+// Returns false if the schema_version table doesn't exist yet (first run).
+func isMigrationApplied(ctx context.Context, pool *pgxpool.Pool, version int) (bool, error) {
+	exists, err := schemaVersionTableExists(ctx, pool)
 	if err != nil {
 		return false, err
 	}
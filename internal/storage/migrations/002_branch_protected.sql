@@ -0,0 +1,3 @@
+-- Protected branches reject writes and DDL through the router.
+ALTER TABLE _rift.branches
+    ADD COLUMN IF NOT EXISTS protected BOOLEAN NOT NULL DEFAULT false;
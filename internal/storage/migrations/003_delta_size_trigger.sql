@@ -0,0 +1,24 @@
+-- Keeps _rift.branches.delta_size up to date as overlay rows change.
+-- Attached to overlay tables when cow.track_delta_size_realtime is enabled;
+-- the branch name is passed as the trigger's first argument.
+CREATE OR REPLACE FUNCTION _rift._rift_track_delta_size() RETURNS trigger
+LANGUAGE plpgsql AS $$
+DECLARE
+    delta BIGINT := 0;
+BEGIN
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        delta := delta + pg_column_size(NEW);
+    END IF;
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        delta := delta - pg_column_size(OLD);
+    END IF;
+
+    IF delta <> 0 THEN
+        UPDATE _rift.branches
+        SET delta_size = GREATEST(delta_size + delta, 0)
+        WHERE name = TG_ARGV[0];
+    END IF;
+
+    RETURN NULL;
+END;
+$$;
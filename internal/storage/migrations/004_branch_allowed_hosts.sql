@@ -0,0 +1,4 @@
+-- Hosts (IP addresses, CIDR ranges or hostnames) allowed to connect to a
+-- branch through the proxy. An empty list allows every host.
+ALTER TABLE _rift.branches
+    ADD COLUMN IF NOT EXISTS allowed_hosts JSONB NOT NULL DEFAULT '[]'::jsonb;
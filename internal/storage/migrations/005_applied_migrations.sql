@@ -0,0 +1,12 @@
+-- Schema migrations applied to a branch with 'rift migrate'. The SQL is kept
+-- so 'rift merge' can replay migrations that haven't reached the parent yet.
+CREATE TABLE IF NOT EXISTS _rift.applied_migrations
+(
+    branch_name TEXT        NOT NULL REFERENCES _rift.branches (name) ON DELETE CASCADE,
+    file_hash   TEXT        NOT NULL,
+    file_name   TEXT        NOT NULL,
+    sql         TEXT        NOT NULL,
+    applied_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    merged_at   TIMESTAMPTZ,
+    PRIMARY KEY (branch_name, file_hash)
+);
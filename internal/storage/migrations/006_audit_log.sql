@@ -0,0 +1,14 @@
+-- History of branch operations (create, delete, merge, ...). branch_name is
+-- not a foreign key so a branch's history outlives the branch.
+CREATE TABLE IF NOT EXISTS _rift.audit_log
+(
+    id          BIGSERIAL PRIMARY KEY,
+    branch_name TEXT        NOT NULL,
+    operation   TEXT        NOT NULL,
+    user_name   TEXT        NOT NULL DEFAULT '',
+    details     JSONB       NOT NULL DEFAULT '{}'::jsonb,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_branch_occurred_at
+    ON _rift.audit_log (branch_name, occurred_at);
@@ -0,0 +1,9 @@
+-- Stamps overlay rows with the time they were last written, so 'rift watch'
+-- can poll a branch for new changes. Attached to every overlay table.
+CREATE OR REPLACE FUNCTION _rift._rift_touch_updated_at() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+    NEW._rift_updated_at := clock_timestamp();
+    RETURN NEW;
+END;
+$$;
@@ -0,0 +1,4 @@
+-- Cap on a branch's delta_size set with 'rift branches limit-size'. Writes
+-- are rejected once the branch reaches it; NULL means no limit.
+ALTER TABLE _rift.branches
+    ADD COLUMN IF NOT EXISTS max_delta_bytes BIGINT;
@@ -0,0 +1,4 @@
+-- Set by 'rift branches freeze'. A frozen branch rejects writes, and write
+-- privileges on its overlay tables are revoked.
+ALTER TABLE _rift.branches
+    ADD COLUMN IF NOT EXISTS frozen BOOLEAN NOT NULL DEFAULT false;
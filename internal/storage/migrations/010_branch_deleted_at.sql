@@ -0,0 +1,4 @@
+-- Set by 'rift delete'. Soft-deleted branches keep their overlay schema until
+-- the retention period passes, so they can be restored; NULL means live.
+ALTER TABLE _rift.branches
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
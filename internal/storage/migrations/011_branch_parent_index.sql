@@ -0,0 +1,3 @@
+-- Child lookups (ListBranchesByParent) run before every delete and purge.
+CREATE INDEX IF NOT EXISTS branches_parent
+    ON _rift.branches (parent);
@@ -0,0 +1,4 @@
+-- Set by the router, at most once a minute per branch, when a client sends a
+-- query on the branch. NULL means no query was seen since the column was added.
+ALTER TABLE _rift.branches
+    ADD COLUMN IF NOT EXISTS last_query_at TIMESTAMPTZ;
@@ -0,0 +1,681 @@
+// Package mock provides an in-memory storage.Store for unit tests that
+// exercise engine logic without a PostgreSQL database.
+package mock
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// Store implements storage.Store with in-memory maps. It keeps branch
+// metadata, tracked tables, the primary key cache, migrations and the audit
+// log, and records which overlay schemas exist, but has no database: Pool
+// returns nil, so code that queries overlay tables still needs PgStore.
+//
+// Errors can be injected per method with SetError.
+type Store struct {
+	mu         sync.Mutex
+	branches   map[string]*storage.Branch
+	schemas    map[string]bool
+	tables     map[string][]*storage.TrackedTable // by branch
+	pks        map[string][]storage.PrimaryKeyColumn
+	migrations map[string][]*storage.AppliedMigration // by branch
+	audit      []*storage.AuditEntry
+	errs       map[string]error
+}
+
+var _ storage.Store = (*Store)(nil)
+
+// New creates a Store holding only the main branch, like a freshly
+// initialized PgStore.
+func New() *Store {
+	s := &Store{
+		branches:   make(map[string]*storage.Branch),
+		schemas:    make(map[string]bool),
+		tables:     make(map[string][]*storage.TrackedTable),
+		pks:        make(map[string][]storage.PrimaryKeyColumn),
+		migrations: make(map[string][]*storage.AppliedMigration),
+		errs:       make(map[string]error),
+	}
+	s.seedMain()
+	return s
+}
+
+// SetError makes every later call to the named Store method (e.g.
+// "GetBranch") fail with err. A nil err removes the injected error.
+func (s *Store) SetError(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.errs, method)
+		return
+	}
+	s.errs[method] = err
+}
+
+// HasSchema reports whether the overlay schema for a branch exists.
+func (s *Store) HasSchema(branchName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.schemas[storage.BranchSchemaName(branchName)]
+}
+
+// injected returns the error set for method. The caller holds mu.
+func (s *Store) injected(method string) error {
+	return s.errs[method]
+}
+
+// lockedInjected is like injected, for methods that delegate to another one
+// and so don't hold mu themselves.
+func (s *Store) lockedInjected(method string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.injected(method)
+}
+
+func (s *Store) seedMain() {
+	if _, ok := s.branches["main"]; ok {
+		return
+	}
+	now := time.Now()
+	s.branches["main"] = &storage.Branch{
+		Name:      "main",
+		CreatedAt: now,
+		UpdatedAt: now,
+		Pinned:    true,
+		Status:    "active",
+	}
+}
+
+func (s *Store) Init(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("Init"); err != nil {
+		return err
+	}
+	s.seedMain()
+	return nil
+}
+
+func (s *Store) Close() {}
+
+func (s *Store) SchemaVersion(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("SchemaVersion"); err != nil {
+		return 0, err
+	}
+	return storage.LatestSchemaVersion()
+}
+
+func (s *Store) Pool() *pgxpool.Pool {
+	return nil
+}
+
+func (s *Store) WritePool() *pgxpool.Pool {
+	return nil
+}
+
+func (s *Store) ReadPool() *pgxpool.Pool {
+	return nil
+}
+
+// --- Branch CRUD ---
+
+func (s *Store) CreateBranch(_ context.Context, b *storage.Branch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("CreateBranch"); err != nil {
+		return err
+	}
+	if _, ok := s.branches[b.Name]; ok {
+		return fmt.Errorf("insert branch: branch %s already exists", b.Name)
+	}
+	s.branches[b.Name] = cloneBranch(b)
+	return nil
+}
+
+func (s *Store) GetBranch(_ context.Context, name string) (*storage.Branch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("GetBranch"); err != nil {
+		return nil, err
+	}
+	b, ok := s.branches[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", storage.ErrBranchNotFound, name)
+	}
+	return cloneBranch(b), nil
+}
+
+func (s *Store) ListBranches(ctx context.Context) ([]*storage.Branch, error) {
+	if err := s.lockedInjected("ListBranches"); err != nil {
+		return nil, err
+	}
+	return s.ListBranchesFilter(ctx, storage.BranchFilter{})
+}
+
+func (s *Store) ListBranchesFilter(ctx context.Context, filter storage.BranchFilter) ([]*storage.Branch, error) {
+	if err := s.lockedInjected("ListBranchesFilter"); err != nil {
+		return nil, err
+	}
+	return s.ListBranchesSorted(ctx, filter, nil)
+}
+
+func (s *Store) ListBranchesByParent(ctx context.Context, parentName string) ([]*storage.Branch, error) {
+	if err := s.lockedInjected("ListBranchesByParent"); err != nil {
+		return nil, err
+	}
+	return s.ListBranchesSorted(ctx, storage.BranchFilter{ParentName: parentName, IncludeDeleted: true}, nil)
+}
+
+func (s *Store) ListBranchesSorted(_ context.Context, filter storage.BranchFilter, sort []storage.SortKey) ([]*storage.Branch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("ListBranchesSorted"); err != nil {
+		return nil, err
+	}
+
+	var branches []*storage.Branch
+	for _, b := range s.branches {
+		if matchesFilter(b, filter) {
+			branches = append(branches, cloneBranch(b))
+		}
+	}
+	slices.SortStableFunc(branches, func(a, b *storage.Branch) int {
+		for _, k := range sort {
+			c := compareField(a, b, k.Field)
+			if k.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return branches, nil
+}
+
+func (s *Store) UpdateBranch(_ context.Context, b *storage.Branch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("UpdateBranch"); err != nil {
+		return err
+	}
+	b.UpdatedAt = time.Now()
+	cur, ok := s.branches[b.Name]
+	if !ok {
+		return nil // like UPDATE ... WHERE name = $1 matching no rows
+	}
+	// The same columns PgStore.UpdateBranch writes; the rest have setters.
+	cur.Parent = b.Parent
+	cur.Database = b.Database
+	cur.UpdatedAt = b.UpdatedAt
+	cur.TTLSeconds = clonePtr(b.TTLSeconds)
+	cur.Pinned = b.Pinned
+	cur.Protected = b.Protected
+	cur.DeltaSize = b.DeltaSize
+	cur.RowsChanged = b.RowsChanged
+	cur.Status = b.Status
+	return nil
+}
+
+func (s *Store) DeleteBranch(_ context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("DeleteBranch"); err != nil {
+		return err
+	}
+	if _, ok := s.branches[name]; !ok {
+		return fmt.Errorf("%w: %s", storage.ErrBranchNotFound, name)
+	}
+	delete(s.branches, name)
+	delete(s.tables, name)
+	delete(s.migrations, name)
+	return nil
+}
+
+func (s *Store) SetBranchProtected(_ context.Context, name string, protected bool) error {
+	return s.updateBranch("SetBranchProtected", name, func(b *storage.Branch) {
+		b.Protected = protected
+	})
+}
+
+func (s *Store) SetBranchAllowedHosts(_ context.Context, name string, hosts []string) error {
+	return s.updateBranch("SetBranchAllowedHosts", name, func(b *storage.Branch) {
+		b.AllowedHosts = slices.Clone(hosts)
+	})
+}
+
+func (s *Store) SetBranchMaxDeltaBytes(_ context.Context, name string, maxBytes *int64) error {
+	return s.updateBranch("SetBranchMaxDeltaBytes", name, func(b *storage.Branch) {
+		b.MaxDeltaBytes = clonePtr(maxBytes)
+	})
+}
+
+func (s *Store) SetBranchFrozen(_ context.Context, name string, frozen bool) error {
+	return s.updateBranch("SetBranchFrozen", name, func(b *storage.Branch) {
+		b.Frozen = frozen
+	})
+}
+
+func (s *Store) SetBranchDeleted(_ context.Context, name string, deleted bool) error {
+	return s.updateBranch("SetBranchDeleted", name, func(b *storage.Branch) {
+		if deleted {
+			now := time.Now()
+			b.DeletedAt = &now
+			b.Status = "deleted"
+		} else {
+			b.DeletedAt = nil
+			b.Status = "active"
+		}
+	})
+}
+
+// updateBranch applies fn to an existing branch and bumps its updated_at.
+func (s *Store) SetBranchLastQueryAt(_ context.Context, name string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("SetBranchLastQueryAt"); err != nil {
+		return err
+	}
+	b, ok := s.branches[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", storage.ErrBranchNotFound, name)
+	}
+	if b.LastQueryAt == nil || t.After(*b.LastQueryAt) {
+		b.LastQueryAt = &t
+	}
+	return nil
+}
+
+func (s *Store) updateBranch(method, name string, fn func(*storage.Branch)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected(method); err != nil {
+		return err
+	}
+	b, ok := s.branches[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", storage.ErrBranchNotFound, name)
+	}
+	fn(b)
+	b.UpdatedAt = time.Now()
+	return nil
+}
+
+// --- Branch overlay schema ---
+
+func (s *Store) CreateBranchSchema(_ context.Context, branchName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("CreateBranchSchema"); err != nil {
+		return err
+	}
+	s.schemas[storage.BranchSchemaName(branchName)] = true
+	return nil
+}
+
+func (s *Store) DropBranchSchema(_ context.Context, branchName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("DropBranchSchema"); err != nil {
+		return err
+	}
+	delete(s.schemas, storage.BranchSchemaName(branchName))
+	return nil
+}
+
+func (s *Store) BranchSchemaName(branchName string) string {
+	return storage.BranchSchemaName(branchName)
+}
+
+func (s *Store) ListBranchSchemas(_ context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("ListBranchSchemas"); err != nil {
+		return nil, err
+	}
+	var schemas []string
+	for name := range s.schemas {
+		schemas = append(schemas, name)
+	}
+	slices.Sort(schemas)
+	return schemas, nil
+}
+
+// --- Table tracking ---
+
+func (s *Store) TrackTable(_ context.Context, t *storage.TrackedTable) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("TrackTable"); err != nil {
+		return err
+	}
+	for _, cur := range s.tables[t.BranchName] {
+		if cur.SourceSchema == t.SourceSchema && cur.TableName == t.TableName {
+			return nil // ON CONFLICT DO NOTHING
+		}
+	}
+	tracked := *t
+	tracked.RowCount = 0
+	s.tables[t.BranchName] = append(s.tables[t.BranchName], &tracked)
+	return nil
+}
+
+func (s *Store) UntrackTable(_ context.Context, branchName, sourceSchema, tableName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("UntrackTable"); err != nil {
+		return err
+	}
+	s.tables[branchName] = slices.DeleteFunc(s.tables[branchName], func(t *storage.TrackedTable) bool {
+		return t.SourceSchema == sourceSchema && t.TableName == tableName
+	})
+	return nil
+}
+
+func (s *Store) ListTrackedTables(_ context.Context, branchName, sourceSchema string) ([]*storage.TrackedTable, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("ListTrackedTables"); err != nil {
+		return nil, err
+	}
+	var tables []*storage.TrackedTable
+	for _, t := range s.tables[branchName] {
+		if sourceSchema != "" && t.SourceSchema != sourceSchema {
+			continue
+		}
+		tracked := *t
+		tables = append(tables, &tracked)
+	}
+	slices.SortStableFunc(tables, func(a, b *storage.TrackedTable) int {
+		return strings.Compare(a.TableName, b.TableName)
+	})
+	return tables, nil
+}
+
+func (s *Store) UpdateTrackedTableRowCount(_ context.Context, branchName, sourceSchema, tableName string, rowCount int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("UpdateTrackedTableRowCount"); err != nil {
+		return err
+	}
+	for _, t := range s.tables[branchName] {
+		if t.SourceSchema == sourceSchema && t.TableName == tableName {
+			t.RowCount = rowCount
+		}
+	}
+	return nil
+}
+
+// --- Primary key cache ---
+
+func (s *Store) CachePrimaryKeys(_ context.Context, keys []storage.PrimaryKeyColumn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("CachePrimaryKeys"); err != nil {
+		return err
+	}
+	s.cachePrimaryKeys(keys)
+	return nil
+}
+
+func (s *Store) BulkCachePrimaryKeys(_ context.Context, keys []storage.PrimaryKeyColumn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("BulkCachePrimaryKeys"); err != nil {
+		return err
+	}
+	s.cachePrimaryKeys(keys)
+	return nil
+}
+
+// cachePrimaryKeys upserts keys by (schema, table, column). The caller holds mu.
+func (s *Store) cachePrimaryKeys(keys []storage.PrimaryKeyColumn) {
+	for _, k := range keys {
+		table := pkCacheKey(k.SourceSchema, k.TableName)
+		cols := s.pks[table]
+		i := slices.IndexFunc(cols, func(c storage.PrimaryKeyColumn) bool {
+			return c.ColumnName == k.ColumnName
+		})
+		if i >= 0 {
+			cols[i].Ordinal = k.Ordinal
+		} else {
+			cols = append(cols, k)
+		}
+		s.pks[table] = cols
+	}
+}
+
+func (s *Store) GetPrimaryKeys(_ context.Context, sourceSchema, tableName string) ([]storage.PrimaryKeyColumn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("GetPrimaryKeys"); err != nil {
+		return nil, err
+	}
+	keys := slices.Clone(s.pks[pkCacheKey(sourceSchema, tableName)])
+	slices.SortStableFunc(keys, func(a, b storage.PrimaryKeyColumn) int {
+		return cmp.Compare(a.Ordinal, b.Ordinal)
+	})
+	return keys, nil
+}
+
+func (s *Store) DeletePrimaryKeys(_ context.Context, sourceSchema, tableName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("DeletePrimaryKeys"); err != nil {
+		return err
+	}
+	delete(s.pks, pkCacheKey(sourceSchema, tableName))
+	return nil
+}
+
+// --- Branch migrations ---
+
+func (s *Store) RecordMigration(_ context.Context, m *storage.AppliedMigration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("RecordMigration"); err != nil {
+		return err
+	}
+	for _, cur := range s.migrations[m.BranchName] {
+		if cur.FileHash == m.FileHash {
+			return fmt.Errorf("%w: %s", storage.ErrMigrationApplied, m.FileName)
+		}
+	}
+	applied := *m
+	applied.AppliedAt = time.Now()
+	applied.MergedAt = nil
+	s.migrations[m.BranchName] = append(s.migrations[m.BranchName], &applied)
+	if b, ok := s.branches[m.BranchName]; ok {
+		b.UpdatedAt = applied.AppliedAt
+	}
+	return nil
+}
+
+func (s *Store) ListMigrations(_ context.Context, branchName string) ([]*storage.AppliedMigration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("ListMigrations"); err != nil {
+		return nil, err
+	}
+	var migrations []*storage.AppliedMigration
+	for _, m := range s.migrations[branchName] {
+		applied := *m
+		migrations = append(migrations, &applied)
+	}
+	return migrations, nil
+}
+
+// --- Audit log ---
+
+func (s *Store) RecordAudit(_ context.Context, e *storage.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("RecordAudit"); err != nil {
+		return err
+	}
+	entry := *e
+	entry.ID = int64(len(s.audit) + 1)
+	entry.OccurredAt = time.Now()
+	if entry.Details == nil {
+		entry.Details = map[string]any{}
+	}
+	s.audit = append(s.audit, &entry)
+	return nil
+}
+
+func (s *Store) ListAudit(_ context.Context, q storage.AuditQuery) ([]*storage.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.injected("ListAudit"); err != nil {
+		return nil, err
+	}
+	var entries []*storage.AuditEntry
+	for _, e := range s.audit {
+		if q.BranchName != "" && e.BranchName != q.BranchName {
+			continue
+		}
+		if !q.Since.IsZero() && e.OccurredAt.Before(q.Since) {
+			continue
+		}
+		if e.ID <= q.AfterID {
+			continue
+		}
+		entry := *e
+		entries = append(entries, &entry)
+	}
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries, nil
+}
+
+// --- Helpers ---
+
+func cloneBranch(b *storage.Branch) *storage.Branch {
+	c := *b
+	c.TTLSeconds = clonePtr(b.TTLSeconds)
+	c.MaxDeltaBytes = clonePtr(b.MaxDeltaBytes)
+	c.AllowedHosts = slices.Clone(b.AllowedHosts)
+	c.DeletedAt = clonePtr(b.DeletedAt)
+	c.LastQueryAt = clonePtr(b.LastQueryAt)
+	return &c
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func pkCacheKey(sourceSchema, tableName string) string {
+	return sourceSchema + "." + tableName
+}
+
+// lastUsed is when a branch was last queried, or created if never.
+func lastUsed(b *storage.Branch) time.Time {
+	if b.LastQueryAt != nil {
+		return *b.LastQueryAt
+	}
+	return b.CreatedAt
+}
+
+// matchesFilter evaluates a BranchFilter the way its SQL WHERE clause does.
+func matchesFilter(b *storage.Branch, f storage.BranchFilter) bool {
+	switch {
+	case f.Status != "" && b.Status != f.Status:
+		return false
+	case f.Pinned != nil && b.Pinned != *f.Pinned:
+		return false
+	case f.ParentName != "" && b.Parent != f.ParentName:
+		return false
+	case !f.CreatedAfter.IsZero() && !b.CreatedAt.After(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !b.CreatedAt.Before(f.CreatedBefore):
+		return false
+	case !f.UpdatedAfter.IsZero() && !b.UpdatedAt.After(f.UpdatedAfter):
+		return false
+	case !f.UpdatedBefore.IsZero() && !b.UpdatedAt.Before(f.UpdatedBefore):
+		return false
+	case !f.ActiveAfter.IsZero() && (b.LastQueryAt == nil || !b.LastQueryAt.After(f.ActiveAfter)):
+		return false
+	case !f.InactiveBefore.IsZero() && !lastUsed(b).Before(f.InactiveBefore):
+		return false
+	case f.NamePattern != "" && !likeMatch(f.NamePattern, b.Name):
+		return false
+	case f.MinDeltaSize != nil && b.DeltaSize < *f.MinDeltaSize:
+		return false
+	case f.MaxDeltaSize != nil && b.DeltaSize > *f.MaxDeltaSize:
+		return false
+	case f.MinRowsChanged != nil && b.RowsChanged < *f.MinRowsChanged:
+		return false
+	case f.MaxRowsChanged != nil && b.RowsChanged > *f.MaxRowsChanged:
+		return false
+	case !f.IncludeDeleted && f.Status != "deleted" && b.DeletedAt != nil:
+		return false
+	}
+	return true
+}
+
+// likeMatch reports whether s matches a SQL LIKE pattern with the default
+// backslash escape.
+func likeMatch(pattern, s string) bool {
+	var re strings.Builder
+	re.WriteString("^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			re.WriteString("(?s:.*)")
+		case r == '_':
+			re.WriteString("(?s:.)")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.MustCompile(re.String()).MatchString(s)
+}
+
+// compareField compares two branches by one of storage.BranchSortFields.
+func compareField(a, b *storage.Branch, field string) int {
+	switch field {
+	case "name":
+		return strings.Compare(a.Name, b.Name)
+	case "parent":
+		return strings.Compare(a.Parent, b.Parent)
+	case "status":
+		return strings.Compare(a.Status, b.Status)
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	case "updated_at":
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	case "delta_size":
+		return cmp.Compare(a.DeltaSize, b.DeltaSize)
+	case "rows_changed":
+		return cmp.Compare(a.RowsChanged, b.RowsChanged)
+	}
+	return 0
+}
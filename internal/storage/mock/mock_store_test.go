@@ -0,0 +1,98 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/riftdata/rift/internal/storage"
+)
+
+func TestLikeMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"feature-%", "feature-auth", true},
+		{"feature-%", "hotfix", false},
+		{"dev_", "dev1", true},
+		{"dev_", "dev12", false},
+		{`100\%`, "100%", true},
+		{`100\%`, "1000", false},
+		{"a.b", "axb", false},
+	}
+	for _, tt := range tests {
+		if got := likeMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("likeMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestListBranchesSorted(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, b := range []*storage.Branch{
+		{Name: "feature-a", Parent: "main", DeltaSize: 10},
+		{Name: "feature-b", Parent: "main", DeltaSize: 30},
+		{Name: "hotfix", Parent: "main", DeltaSize: 20},
+	} {
+		b.CreatedAt = base.Add(time.Duration(i) * time.Hour)
+		b.Status = "active"
+		if err := s.CreateBranch(ctx, b); err != nil {
+			t.Fatalf("CreateBranch: %v", err)
+		}
+	}
+
+	filter, err := storage.ParseBranchFilter([]string{"name=feature-*"})
+	if err != nil {
+		t.Fatalf("ParseBranchFilter: %v", err)
+	}
+	sort, err := storage.ParseBranchSort("delta_size:desc")
+	if err != nil {
+		t.Fatalf("ParseBranchSort: %v", err)
+	}
+	got, err := s.ListBranchesSorted(ctx, filter, sort)
+	if err != nil {
+		t.Fatalf("ListBranchesSorted: %v", err)
+	}
+	if len(got) != 2 || got[0].Name != "feature-b" || got[1].Name != "feature-a" {
+		t.Errorf("got %d branches, want feature-b then feature-a", len(got))
+	}
+
+	minSize, maxSize := int64(15), int64(25)
+	got, err = s.ListBranchesSorted(ctx, storage.BranchFilter{MinDeltaSize: &minSize, MaxDeltaSize: &maxSize}, nil)
+	if err != nil {
+		t.Fatalf("ListBranchesSorted: %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "hotfix" {
+		t.Errorf("delta size 15-25: got %d branches, want hotfix", len(got))
+	}
+
+	// Returned branches are copies
+	got[0].Protected = true
+	if b, _ := s.GetBranch(ctx, "feature-b"); b.Protected {
+		t.Error("modifying a listed branch changed the store")
+	}
+}
+
+func TestSetError(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	failure := errors.New("simulated failure")
+
+	s.SetError("GetBranch", failure)
+	if _, err := s.GetBranch(ctx, "main"); !errors.Is(err, failure) {
+		t.Errorf("GetBranch = %v, want the injected error", err)
+	}
+	if _, err := s.ListBranches(ctx); err != nil {
+		t.Errorf("ListBranches = %v, want no error", err)
+	}
+
+	s.SetError("GetBranch", nil)
+	if _, err := s.GetBranch(ctx, "main"); err != nil {
+		t.Errorf("GetBranch after clearing = %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PoolConfig sizes the upstream connection pool. Zero fields keep pgx's
+// defaults (at most max(4, GOMAXPROCS) connections, idle ones closed after
+// 30 minutes).
+type PoolConfig struct {
+	MinConns        int32
+	MaxConns        int32
+	MaxConnIdleTime time.Duration
+
+	// ReadReplicaURL, if set, connects a second pool, sized the same way, to
+	// a read replica of the upstream database (see PgStore.ReadPool).
+	ReadReplicaURL string
+
+	// FailoverURLs are further upstreams that can take over as the primary.
+	// The store connects to the first reachable of the connection string
+	// and these, in order, and then switches between them as its
+	// PgStore.Failover manager finds them down.
+	FailoverURLs []string
+}
+
+// apply sets the non-zero fields of pc on a parsed pgxpool config.
+func (pc PoolConfig) apply(c *pgxpool.Config) {
+	if pc.MaxConns > 0 {
+		c.MaxConns = pc.MaxConns
+	}
+	if pc.MinConns > 0 {
+		c.MinConns = pc.MinConns
+	}
+	if pc.MaxConnIdleTime > 0 {
+		c.MaxConnIdleTime = pc.MaxConnIdleTime
+	}
+}
+
+// NewWithPool is like New, with the connection pool sized by pc.
+func NewWithPool(ctx context.Context, connString string, pc PoolConfig) (*PgStore, error) {
+	var s *PgStore
+	if len(pc.FailoverURLs) == 0 {
+		pool, err := connectPool(ctx, connString, pc)
+		if err != nil {
+			return nil, err
+		}
+		s = &PgStore{pool: pool}
+	} else {
+		m, err := connectFailover(ctx, append([]string{connString}, pc.FailoverURLs...), pc)
+		if err != nil {
+			return nil, err
+		}
+		s = &PgStore{pool: m.Pool(), failover: m}
+	}
+
+	if pc.ReadReplicaURL != "" {
+		readPool, err := connectPool(ctx, pc.ReadReplicaURL, pc)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("read replica: %w", err)
+		}
+		s.readPool = readPool
+	}
+	return s, nil
+}
+
+// connectFailover opens a pool to each of urls and returns a failover
+// manager over them using the first that can reach its database.
+func connectFailover(ctx context.Context, urls []string, pc PoolConfig) (*UpstreamFailoverManager, error) {
+	var pools []*pgxpool.Pool
+	closeAll := func() {
+		for _, p := range pools {
+			p.Close()
+		}
+	}
+	current := -1
+	var errs []error
+	for i, url := range urls {
+		pool, err := OpenPool(ctx, url, pc)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("upstream %d: %w", i+1, err)
+		}
+		pools = append(pools, pool)
+		if current >= 0 {
+			continue
+		}
+		if err := pool.Ping(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", poolAddr(pool), err))
+			continue
+		}
+		current = i
+	}
+	if current < 0 {
+		closeAll()
+		return nil, fmt.Errorf("ping upstream: no upstream reachable: %w", errors.Join(errs...))
+	}
+	m := NewUpstreamFailoverManager(current, pools...)
+	for _, u := range m.upstreams[:current] {
+		u.healthy.Store(false)
+	}
+	return m, nil
+}
+
+// connectPool creates a pool sized by pc and checks it can reach the database.
+func connectPool(ctx context.Context, connString string, pc PoolConfig) (*pgxpool.Pool, error) {
+	pool, err := OpenPool(ctx, connString, pc)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("ping upstream: %w", err)
+	}
+	return pool, nil
+}
+
+// OpenPool creates a pool sized by pc without connecting to the database,
+// for pools such as extra read replicas that may be down when it's called.
+func OpenPool(ctx context.Context, connString string, pc PoolConfig) (*pgxpool.Pool, error) {
+	poolCfg, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, fmt.Errorf("parse connection string: %w", err)
+	}
+	pc.apply(poolCfg)
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		return nil, fmt.Errorf("create pool: %w", err)
+	}
+	return pool, nil
+}
+
+// PoolStats is a snapshot of a connection pool's utilization.
+type PoolStats struct {
+	Name              string        `json:"name"`
+	AcquiredConns     int32         `json:"acquired_conns"`
+	IdleConns         int32         `json:"idle_conns"`
+	TotalConns        int32         `json:"total_conns"`
+	MaxConns          int32         `json:"max_conns"`
+	AcquireCount      int64         `json:"acquire_count"`
+	EmptyAcquireCount int64         `json:"empty_acquire_count"`
+	AcquireDuration   time.Duration `json:"acquire_duration_ns"`
+}
+
+// StatsOf returns a snapshot of pool's utilization, labelled name.
+func StatsOf(name string, pool *pgxpool.Pool) PoolStats {
+	st := pool.Stat()
+	return PoolStats{
+		Name:              name,
+		AcquiredConns:     st.AcquiredConns(),
+		IdleConns:         st.IdleConns(),
+		TotalConns:        st.TotalConns(),
+		MaxConns:          st.MaxConns(),
+		AcquireCount:      st.AcquireCount(),
+		EmptyAcquireCount: st.EmptyAcquireCount(),
+		AcquireDuration:   st.AcquireDuration(),
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"regexp"
@@ -12,46 +13,88 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// ErrBranchNotFound is returned when a branch does not exist.
+var ErrBranchNotFound = errors.New("branch not found")
+
+// ErrMigrationApplied is returned when a migration was already applied to a branch.
+var ErrMigrationApplied = errors.New("migration already applied")
+
 var branchNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
 
+// branchSchemaPrefix is prepended to the sanitized branch name to form its overlay schema.
+const branchSchemaPrefix = "_rift_branch_"
+
 // PgStore implements Store using a PostgreSQL connection pool.
 type PgStore struct {
 	pool *pgxpool.Pool
+
+	// failover, if set, picks the pool in use among the upstreams of
+	// PoolConfig.FailoverURLs; pool is then the first one reachable.
+	failover *UpstreamFailoverManager
+
+	// readPool is connected to a read replica, or nil without one.
+	readPool *pgxpool.Pool
 }
 
-// New creates a new PgStore from a connection string.
+// New creates a new PgStore from a connection string, with pgx's default
+// pool settings.
 func New(ctx context.Context, connString string) (*PgStore, error) {
-	pool, err := pgxpool.New(ctx, connString)
-	if err != nil {
-		return nil, fmt.Errorf("create pool: %w", err)
-	}
-	if err := pool.Ping(ctx); err != nil {
-		pool.Close()
-		return nil, fmt.Errorf("ping upstream: %w", err)
-	}
-	return &PgStore{pool: pool}, nil
+	return NewWithPool(ctx, connString, PoolConfig{})
 }
 
 func (s *PgStore) Init(ctx context.Context) error {
-	return runMigrations(ctx, s.pool)
+	return runMigrations(ctx, s.Pool())
 }
 
 func (s *PgStore) Close() {
-	s.pool.Close()
+	if s.failover != nil {
+		for _, pool := range s.failover.Pools() {
+			pool.Close()
+		}
+	} else {
+		s.pool.Close()
+	}
+	if s.readPool != nil {
+		s.readPool.Close()
+	}
+}
+
+func (s *PgStore) SchemaVersion(ctx context.Context) (int, error) {
+	return currentSchemaVersion(ctx, s.Pool())
 }
 
 func (s *PgStore) Pool() *pgxpool.Pool {
+	if s.failover != nil {
+		return s.failover.Pool()
+	}
 	return s.pool
 }
 
+func (s *PgStore) WritePool() *pgxpool.Pool {
+	return s.Pool()
+}
+
+// Failover returns the manager that switches between the upstreams of
+// PoolConfig.FailoverURLs, or nil if none were given.
+func (s *PgStore) Failover() *UpstreamFailoverManager {
+	return s.failover
+}
+
+func (s *PgStore) ReadPool() *pgxpool.Pool {
+	if s.readPool != nil {
+		return s.readPool
+	}
+	return s.Pool()
+}
+
 // --- Branch CRUD ---
 
 func (s *PgStore) CreateBranch(ctx context.Context, b *Branch) error {
-	_, err := s.pool.Exec(ctx,
-		`INSERT INTO _rift.branches (name, parent, database, created_at, updated_at, ttl_seconds, pinned, status)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+	_, err := s.Pool().Exec(ctx,
+		`INSERT INTO _rift.branches (name, parent, database, created_at, updated_at, ttl_seconds, pinned, protected, status)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
 		b.Name, nullIfEmpty(b.Parent), b.Database,
-		b.CreatedAt, b.UpdatedAt, b.TTLSeconds, b.Pinned, b.Status)
+		b.CreatedAt, b.UpdatedAt, b.TTLSeconds, b.Pinned, b.Protected, b.Status)
 	if err != nil {
 		return fmt.Errorf("insert branch: %w", err)
 	}
@@ -61,13 +104,13 @@ func (s *PgStore) CreateBranch(ctx context.Context, b *Branch) error {
 func (s *PgStore) GetBranch(ctx context.Context, name string) (*Branch, error) {
 	b := &Branch{}
 	var parent *string
-	err := s.pool.QueryRow(ctx,
-		`SELECT name, parent, database, created_at, updated_at, ttl_seconds, pinned, delta_size, rows_changed, status
+	err := s.Pool().QueryRow(ctx,
+		`SELECT name, parent, database, created_at, updated_at, ttl_seconds, pinned, protected, delta_size, rows_changed, status, allowed_hosts, max_delta_bytes, frozen, deleted_at, last_query_at
 		 FROM _rift.branches WHERE name = $1`, name).Scan(
 		&b.Name, &parent, &b.Database, &b.CreatedAt, &b.UpdatedAt,
-		&b.TTLSeconds, &b.Pinned, &b.DeltaSize, &b.RowsChanged, &b.Status)
+		&b.TTLSeconds, &b.Pinned, &b.Protected, &b.DeltaSize, &b.RowsChanged, &b.Status, &b.AllowedHosts, &b.MaxDeltaBytes, &b.Frozen, &b.DeletedAt, &b.LastQueryAt)
 	if errors.Is(err, pgx.ErrNoRows) {
-		return nil, fmt.Errorf("branch %q not found", name)
+		return nil, fmt.Errorf("%w: %s", ErrBranchNotFound, name)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("get branch: %w", err)
@@ -79,9 +122,18 @@ func (s *PgStore) GetBranch(ctx context.Context, name string) (*Branch, error) {
 }
 
 func (s *PgStore) ListBranches(ctx context.Context) ([]*Branch, error) {
-	rows, err := s.pool.Query(ctx,
-		`SELECT name, parent, database, created_at, updated_at, ttl_seconds, pinned, delta_size, rows_changed, status
-		 FROM _rift.branches ORDER BY created_at`)
+	return s.ListBranchesFilter(ctx, BranchFilter{})
+}
+
+func (s *PgStore) ListBranchesFilter(ctx context.Context, filter BranchFilter) ([]*Branch, error) {
+	return s.ListBranchesSorted(ctx, filter, nil)
+}
+
+func (s *PgStore) ListBranchesSorted(ctx context.Context, filter BranchFilter, sort []SortKey) ([]*Branch, error) {
+	where, args := filter.where()
+	rows, err := s.Pool().Query(ctx,
+		`SELECT name, parent, database, created_at, updated_at, ttl_seconds, pinned, protected, delta_size, rows_changed, status, allowed_hosts, max_delta_bytes, frozen, deleted_at, last_query_at
+		 FROM _rift.branches`+where+orderBy(sort), args...)
 	if err != nil {
 		return nil, fmt.Errorf("list branches: %w", err)
 	}
@@ -92,7 +144,7 @@ func (s *PgStore) ListBranches(ctx context.Context) ([]*Branch, error) {
 		b := &Branch{}
 		var parent *string
 		if err := rows.Scan(&b.Name, &parent, &b.Database, &b.CreatedAt, &b.UpdatedAt,
-			&b.TTLSeconds, &b.Pinned, &b.DeltaSize, &b.RowsChanged, &b.Status); err != nil {
+			&b.TTLSeconds, &b.Pinned, &b.Protected, &b.DeltaSize, &b.RowsChanged, &b.Status, &b.AllowedHosts, &b.MaxDeltaBytes, &b.Frozen, &b.DeletedAt, &b.LastQueryAt); err != nil {
 			return nil, fmt.Errorf("scan branch: %w", err)
 		}
 		if parent != nil {
@@ -103,14 +155,18 @@ func (s *PgStore) ListBranches(ctx context.Context) ([]*Branch, error) {
 	return branches, rows.Err()
 }
 
+func (s *PgStore) ListBranchesByParent(ctx context.Context, parentName string) ([]*Branch, error) {
+	return s.ListBranchesSorted(ctx, BranchFilter{ParentName: parentName, IncludeDeleted: true}, nil)
+}
+
 func (s *PgStore) UpdateBranch(ctx context.Context, b *Branch) error {
 	b.UpdatedAt = time.Now()
-	_, err := s.pool.Exec(ctx,
+	_, err := s.Pool().Exec(ctx,
 		`UPDATE _rift.branches SET parent=$2, database=$3, updated_at=$4, ttl_seconds=$5,
-		 pinned=$6, delta_size=$7, rows_changed=$8, status=$9
+		 pinned=$6, protected=$7, delta_size=$8, rows_changed=$9, status=$10
 		 WHERE name=$1`,
 		b.Name, nullIfEmpty(b.Parent), b.Database, b.UpdatedAt,
-		b.TTLSeconds, b.Pinned, b.DeltaSize, b.RowsChanged, b.Status)
+		b.TTLSeconds, b.Pinned, b.Protected, b.DeltaSize, b.RowsChanged, b.Status)
 	if err != nil {
 		return fmt.Errorf("update branch: %w", err)
 	}
@@ -118,12 +174,102 @@ func (s *PgStore) UpdateBranch(ctx context.Context, b *Branch) error {
 }
 
 func (s *PgStore) DeleteBranch(ctx context.Context, name string) error {
-	tag, err := s.pool.Exec(ctx, `DELETE FROM _rift.branches WHERE name = $1`, name)
+	tag, err := s.Pool().Exec(ctx, `DELETE FROM _rift.branches WHERE name = $1`, name)
 	if err != nil {
 		return fmt.Errorf("delete branch: %w", err)
 	}
 	if tag.RowsAffected() == 0 {
-		return fmt.Errorf("branch %q not found", name)
+		return fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+	}
+	return nil
+}
+
+func (s *PgStore) SetBranchProtected(ctx context.Context, name string, protected bool) error {
+	tag, err := s.Pool().Exec(ctx,
+		`UPDATE _rift.branches SET protected = $2, updated_at = now() WHERE name = $1`,
+		name, protected)
+	if err != nil {
+		return fmt.Errorf("set branch protected: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+	}
+	return nil
+}
+
+func (s *PgStore) SetBranchAllowedHosts(ctx context.Context, name string, hosts []string) error {
+	if hosts == nil {
+		hosts = []string{}
+	}
+	data, err := json.Marshal(hosts)
+	if err != nil {
+		return fmt.Errorf("encode allowed hosts: %w", err)
+	}
+	tag, err := s.Pool().Exec(ctx,
+		`UPDATE _rift.branches SET allowed_hosts = $2::jsonb, updated_at = now() WHERE name = $1`,
+		name, string(data))
+	if err != nil {
+		return fmt.Errorf("set branch allowed hosts: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+	}
+	return nil
+}
+
+func (s *PgStore) SetBranchMaxDeltaBytes(ctx context.Context, name string, maxBytes *int64) error {
+	tag, err := s.Pool().Exec(ctx,
+		`UPDATE _rift.branches SET max_delta_bytes = $2, updated_at = now() WHERE name = $1`,
+		name, maxBytes)
+	if err != nil {
+		return fmt.Errorf("set branch max delta bytes: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+	}
+	return nil
+}
+
+func (s *PgStore) SetBranchFrozen(ctx context.Context, name string, frozen bool) error {
+	tag, err := s.Pool().Exec(ctx,
+		`UPDATE _rift.branches SET frozen = $2, updated_at = now() WHERE name = $1`,
+		name, frozen)
+	if err != nil {
+		return fmt.Errorf("set branch frozen: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+	}
+	return nil
+}
+
+func (s *PgStore) SetBranchDeleted(ctx context.Context, name string, deleted bool) error {
+	tag, err := s.Pool().Exec(ctx,
+		`UPDATE _rift.branches
+		 SET deleted_at = CASE WHEN $2 THEN now() END,
+		     status = CASE WHEN $2 THEN 'deleted' ELSE 'active' END,
+		     updated_at = now()
+		 WHERE name = $1`,
+		name, deleted)
+	if err != nil {
+		return fmt.Errorf("set branch deleted: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+	}
+	return nil
+}
+
+func (s *PgStore) SetBranchLastQueryAt(ctx context.Context, name string, t time.Time) error {
+	// GREATEST keeps concurrent servers from moving it back
+	tag, err := s.Pool().Exec(ctx,
+		`UPDATE _rift.branches SET last_query_at = GREATEST(last_query_at, $2) WHERE name = $1`,
+		name, t)
+	if err != nil {
+		return fmt.Errorf("set branch last query: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrBranchNotFound, name)
 	}
 	return nil
 }
@@ -132,7 +278,7 @@ func (s *PgStore) DeleteBranch(ctx context.Context, name string) error {
 
 func (s *PgStore) CreateBranchSchema(ctx context.Context, branchName string) error {
 	schema := s.BranchSchemaName(branchName)
-	_, err := s.pool.Exec(ctx, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", pgQuoteIdent(schema)))
+	_, err := s.Pool().Exec(ctx, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", pgQuoteIdent(schema)))
 	if err != nil {
 		return fmt.Errorf("create branch schema: %w", err)
 	}
@@ -141,7 +287,7 @@ func (s *PgStore) CreateBranchSchema(ctx context.Context, branchName string) err
 
 func (s *PgStore) DropBranchSchema(ctx context.Context, branchName string) error {
 	schema := s.BranchSchemaName(branchName)
-	_, err := s.pool.Exec(ctx, fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", pgQuoteIdent(schema)))
+	_, err := s.Pool().Exec(ctx, fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", pgQuoteIdent(schema)))
 	if err != nil {
 		return fmt.Errorf("drop branch schema: %w", err)
 	}
@@ -149,14 +295,34 @@ func (s *PgStore) DropBranchSchema(ctx context.Context, branchName string) error
 }
 
 func (s *PgStore) BranchSchemaName(branchName string) string {
-	safe := sanitizeBranchName(branchName)
-	return "_rift_branch_" + safe
+	return BranchSchemaName(branchName)
+}
+
+func (s *PgStore) ListBranchSchemas(ctx context.Context) ([]string, error) {
+	rows, err := s.Pool().Query(ctx,
+		`SELECT nspname FROM pg_catalog.pg_namespace
+		 WHERE starts_with(nspname, $1)
+		 ORDER BY nspname`, branchSchemaPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("list branch schemas: %w", err)
+	}
+	defer rows.Close()
+
+	var schemas []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scan branch schema: %w", err)
+		}
+		schemas = append(schemas, name)
+	}
+	return schemas, rows.Err()
 }
 
 // --- Table tracking ---
 
 func (s *PgStore) TrackTable(ctx context.Context, t *TrackedTable) error {
-	_, err := s.pool.Exec(ctx,
+	_, err := s.Pool().Exec(ctx,
 		`INSERT INTO _rift.branch_tables (branch_name, source_schema, table_name, overlay_table, has_tombstones)
 		 VALUES ($1, $2, $3, $4, $5)
 		 ON CONFLICT (branch_name, source_schema, table_name) DO NOTHING`,
@@ -168,17 +334,18 @@ func (s *PgStore) TrackTable(ctx context.Context, t *TrackedTable) error {
 }
 
 func (s *PgStore) UntrackTable(ctx context.Context, branchName, sourceSchema, tableName string) error {
-	_, err := s.pool.Exec(ctx,
+	_, err := s.Pool().Exec(ctx,
 		`DELETE FROM _rift.branch_tables WHERE branch_name=$1 AND source_schema=$2 AND table_name=$3`,
 		branchName, sourceSchema, tableName)
 	return err
 }
 
-func (s *PgStore) ListTrackedTables(ctx context.Context, branchName string) ([]*TrackedTable, error) {
-	rows, err := s.pool.Query(ctx,
+func (s *PgStore) ListTrackedTables(ctx context.Context, branchName, sourceSchema string) ([]*TrackedTable, error) {
+	rows, err := s.Pool().Query(ctx,
 		`SELECT branch_name, source_schema, table_name, overlay_table, has_tombstones, row_count
-		 FROM _rift.branch_tables WHERE branch_name = $1 ORDER BY table_name`,
-		branchName)
+		 FROM _rift.branch_tables WHERE branch_name = $1 AND ($2 = '' OR source_schema = $2)
+		 ORDER BY table_name`,
+		branchName, sourceSchema)
 	if err != nil {
 		return nil, fmt.Errorf("list tracked tables: %w", err)
 	}
@@ -197,7 +364,7 @@ func (s *PgStore) ListTrackedTables(ctx context.Context, branchName string) ([]*
 }
 
 func (s *PgStore) UpdateTrackedTableRowCount(ctx context.Context, branchName, sourceSchema, tableName string, rowCount int64) error {
-	_, err := s.pool.Exec(ctx,
+	_, err := s.Pool().Exec(ctx,
 		`UPDATE _rift.branch_tables SET row_count=$4
 		 WHERE branch_name=$1 AND source_schema=$2 AND table_name=$3`,
 		branchName, sourceSchema, tableName, rowCount)
@@ -208,7 +375,7 @@ func (s *PgStore) UpdateTrackedTableRowCount(ctx context.Context, branchName, so
 
 func (s *PgStore) CachePrimaryKeys(ctx context.Context, keys []PrimaryKeyColumn) error {
 	for _, k := range keys {
-		_, err := s.pool.Exec(ctx,
+		_, err := s.Pool().Exec(ctx,
 			`INSERT INTO _rift.table_primary_keys (source_schema, table_name, column_name, ordinal)
 			 VALUES ($1, $2, $3, $4)
 			 ON CONFLICT (source_schema, table_name, column_name) DO UPDATE SET ordinal = $4`,
@@ -220,8 +387,48 @@ func (s *PgStore) CachePrimaryKeys(ctx context.Context, keys []PrimaryKeyColumn)
 	return nil
 }
 
+func (s *PgStore) BulkCachePrimaryKeys(ctx context.Context, keys []PrimaryKeyColumn) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	tx, err := s.Pool().Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// COPY can't upsert, so load into a staging table and merge from there.
+	if _, err := tx.Exec(ctx,
+		`CREATE TEMP TABLE _rift_pk_staging (LIKE _rift.table_primary_keys) ON COMMIT DROP`); err != nil {
+		return fmt.Errorf("create staging table: %w", err)
+	}
+
+	columns := []string{"source_schema", "table_name", "column_name", "ordinal"}
+	rows := pgx.CopyFromSlice(len(keys), func(i int) ([]any, error) {
+		k := keys[i]
+		return []any{k.SourceSchema, k.TableName, k.ColumnName, k.Ordinal}, nil
+	})
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"_rift_pk_staging"}, columns, rows); err != nil {
+		return fmt.Errorf("copy primary keys: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO _rift.table_primary_keys (source_schema, table_name, column_name, ordinal)
+		 SELECT DISTINCT ON (source_schema, table_name, column_name) source_schema, table_name, column_name, ordinal
+		 FROM _rift_pk_staging
+		 ON CONFLICT (source_schema, table_name, column_name) DO UPDATE SET ordinal = EXCLUDED.ordinal`); err != nil {
+		return fmt.Errorf("merge primary keys: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
 func (s *PgStore) GetPrimaryKeys(ctx context.Context, sourceSchema, tableName string) ([]PrimaryKeyColumn, error) {
-	rows, err := s.pool.Query(ctx,
+	rows, err := s.Pool().Query(ctx,
 		`SELECT source_schema, table_name, column_name, ordinal
 		 FROM _rift.table_primary_keys
 		 WHERE source_schema=$1 AND table_name=$2
@@ -243,6 +450,13 @@ func (s *PgStore) GetPrimaryKeys(ctx context.Context, sourceSchema, tableName st
 	return keys, rows.Err()
 }
 
+func (s *PgStore) DeletePrimaryKeys(ctx context.Context, sourceSchema, tableName string) error {
+	_, err := s.Pool().Exec(ctx,
+		`DELETE FROM _rift.table_primary_keys WHERE source_schema=$1 AND table_name=$2`,
+		sourceSchema, tableName)
+	return err
+}
+
 // --- Helpers ---
 
 func nullIfEmpty(s string) *string {
@@ -262,6 +476,12 @@ func pgQuoteIdent(ident string) string {
 	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
 }
 
+// BranchSchemaName returns the overlay schema name for a branch, for Store
+// implementations that share PgStore's naming.
+func BranchSchemaName(branchName string) string {
+	return branchSchemaPrefix + sanitizeBranchName(branchName)
+}
+
 // ValidateBranchName checks if a branch name is safe for use as a schema suffix.
 func ValidateBranchName(name string) error {
 	if name == "" {
@@ -275,3 +495,116 @@ func ValidateBranchName(name string) error {
 	}
 	return nil
 }
+
+// --- Branch migrations ---
+
+func (s *PgStore) RecordMigration(ctx context.Context, m *AppliedMigration) error {
+	// Bump the branch's updated_at so cached merge SQL is revalidated.
+	tag, err := s.Pool().Exec(ctx,
+		`WITH ins AS (
+		     INSERT INTO _rift.applied_migrations (branch_name, file_hash, file_name, sql)
+		     VALUES ($1, $2, $3, $4)
+		     ON CONFLICT (branch_name, file_hash) DO NOTHING
+		     RETURNING 1
+		 )
+		 UPDATE _rift.branches SET updated_at = now()
+		 WHERE name = $1 AND EXISTS (SELECT 1 FROM ins)`,
+		m.BranchName, m.FileHash, m.FileName, m.SQL)
+	if err != nil {
+		return fmt.Errorf("record migration: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrMigrationApplied, m.FileName)
+	}
+	return nil
+}
+
+func (s *PgStore) ListMigrations(ctx context.Context, branchName string) ([]*AppliedMigration, error) {
+	rows, err := s.Pool().Query(ctx,
+		`SELECT branch_name, file_hash, file_name, sql, applied_at, merged_at
+		 FROM _rift.applied_migrations
+		 WHERE branch_name = $1
+		 ORDER BY applied_at, file_name`,
+		branchName)
+	if err != nil {
+		return nil, fmt.Errorf("list migrations: %w", err)
+	}
+	defer rows.Close()
+
+	var migrations []*AppliedMigration
+	for rows.Next() {
+		m := &AppliedMigration{}
+		if err := rows.Scan(&m.BranchName, &m.FileHash, &m.FileName, &m.SQL, &m.AppliedAt, &m.MergedAt); err != nil {
+			return nil, fmt.Errorf("scan migration: %w", err)
+		}
+		migrations = append(migrations, m)
+	}
+	return migrations, rows.Err()
+}
+
+// --- Audit log ---
+
+func (s *PgStore) RecordAudit(ctx context.Context, e *AuditEntry) error {
+	details := e.Details
+	if details == nil {
+		details = map[string]any{}
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("encode audit details: %w", err)
+	}
+	_, err = s.Pool().Exec(ctx,
+		`INSERT INTO _rift.audit_log (branch_name, operation, user_name, details)
+		 VALUES ($1, $2, $3, $4::jsonb)`,
+		e.BranchName, e.Operation, e.UserName, string(data))
+	if err != nil {
+		return fmt.Errorf("record audit: %w", err)
+	}
+	return nil
+}
+
+func (s *PgStore) ListAudit(ctx context.Context, q AuditQuery) ([]*AuditEntry, error) {
+	var conds []string
+	var args []any
+	if q.BranchName != "" {
+		args = append(args, q.BranchName)
+		conds = append(conds, fmt.Sprintf("branch_name = $%d", len(args)))
+	}
+	if !q.Since.IsZero() {
+		args = append(args, q.Since)
+		conds = append(conds, fmt.Sprintf("occurred_at >= $%d", len(args)))
+	}
+	if q.AfterID > 0 {
+		args = append(args, q.AfterID)
+		conds = append(conds, fmt.Sprintf("id > $%d", len(args)))
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+	limit := ""
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		limit = fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	// Take the most recent entries, then return them in chronological order.
+	rows, err := s.Pool().Query(ctx,
+		`SELECT id, branch_name, operation, user_name, details, occurred_at FROM (
+		     SELECT * FROM _rift.audit_log`+where+` ORDER BY id DESC`+limit+`
+		 ) recent ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*AuditEntry
+	for rows.Next() {
+		e := &AuditEntry{}
+		if err := rows.Scan(&e.ID, &e.BranchName, &e.Operation, &e.UserName, &e.Details, &e.OccurredAt); err != nil {
+			return nil, fmt.Errorf("scan audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
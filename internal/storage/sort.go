@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// BranchSortFields are the fields accepted by ParseBranchSort. Each is also
+// the name of its _rift.branches column.
+var BranchSortFields = []string{"name", "parent", "status", "created_at", "updated_at", "delta_size", "rows_changed"}
+
+// SortKey orders ListBranchesSorted by one field.
+type SortKey struct {
+	Field string // one of BranchSortFields
+	Desc  bool
+}
+
+// ParseBranchSort parses a sort spec such as "delta_size:desc" or
+// "parent:asc,delta_size:desc". The direction defaults to ascending.
+func ParseBranchSort(spec string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, term := range strings.Split(spec, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		field, dir, _ := strings.Cut(term, ":")
+		field = strings.TrimSpace(field)
+		if !slices.Contains(BranchSortFields, field) {
+			return nil, fmt.Errorf("unknown sort field %q (expected one of %s)", field, strings.Join(BranchSortFields, ", "))
+		}
+
+		key := SortKey{Field: field}
+		switch strings.ToLower(strings.TrimSpace(dir)) {
+		case "", "asc":
+		case "desc":
+			key.Desc = true
+		default:
+			return nil, fmt.Errorf("invalid sort direction %q for %s: expected asc or desc", dir, field)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// orderBy returns the ORDER BY clause for keys. Only whitelisted columns are
+// used, and created_at breaks ties so the order is stable.
+func orderBy(keys []SortKey) string {
+	terms := make([]string, 0, len(keys)+1)
+	hasCreated := false
+	for _, k := range keys {
+		if !slices.Contains(BranchSortFields, k.Field) {
+			continue
+		}
+		col := k.Field
+		hasCreated = hasCreated || col == "created_at"
+		if k.Desc {
+			col += " DESC"
+		}
+		terms = append(terms, col)
+	}
+	if !hasCreated {
+		terms = append(terms, "created_at")
+	}
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
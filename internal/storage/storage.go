@@ -16,9 +16,30 @@ type Branch struct {
 	UpdatedAt   time.Time
 	TTLSeconds  *int
 	Pinned      bool
+	Protected   bool
 	DeltaSize   int64
 	RowsChanged int64
 	Status      string
+
+	// AllowedHosts restricts which client hosts may connect to the branch
+	// (IP addresses, CIDR ranges or hostnames). Empty allows every host.
+	AllowedHosts []string
+
+	// MaxDeltaBytes caps DeltaSize: writes are rejected once the branch
+	// reaches it. Nil means no limit.
+	MaxDeltaBytes *int64
+
+	// Frozen branches reject writes; their overlays are immutable.
+	Frozen bool
+
+	// DeletedAt is when the branch was soft-deleted. Its overlay schema is
+	// kept until the retention period passes, so it can be restored. Nil
+	// for live branches.
+	DeletedAt *time.Time
+
+	// LastQueryAt is when a client last queried the branch through the
+	// proxy, to the minute. Nil if it never has.
+	LastQueryAt *time.Time
 }
 
 // TrackedTable represents an overlay table entry in _rift.branch_tables.
@@ -39,6 +60,41 @@ type PrimaryKeyColumn struct {
 	Ordinal      int
 }
 
+// AppliedMigration is a schema migration applied to a branch with
+// 'rift migrate', stored in _rift.applied_migrations.
+type AppliedMigration struct {
+	BranchName string
+	FileHash   string
+	FileName   string
+	SQL        string
+	AppliedAt  time.Time
+
+	// MergedAt is set once the migration has been merged into the parent.
+	MergedAt *time.Time
+}
+
+// AuditEntry is one branch operation recorded in _rift.audit_log.
+type AuditEntry struct {
+	ID         int64
+	BranchName string
+	Operation  string
+	UserName   string
+	Details    map[string]any
+	OccurredAt time.Time
+}
+
+// AuditQuery selects audit log entries. Zero-valued fields match everything.
+type AuditQuery struct {
+	BranchName string
+	Since      time.Time
+
+	// AfterID keeps only entries recorded after the entry with this ID.
+	AfterID int64
+
+	// Limit keeps only the most recent entries.
+	Limit int
+}
+
 // Store defines the interface for rift's PostgreSQL-backed storage.
 type Store interface {
 	// Init runs migrations and ensures the _rift schema exists.
@@ -47,17 +103,63 @@ type Store interface {
 	// Close releases the connection pool.
 	Close()
 
-	// Pool returns the underlying connection pool for direct queries.
+	// SchemaVersion returns the latest applied migration version,
+	// or 0 if the _rift schema has not been initialized.
+	SchemaVersion(ctx context.Context) (int, error)
+
+	// Pool returns the underlying connection pool for direct queries. It is
+	// the same as WritePool, and is what rift's metadata is read and written
+	// through.
 	Pool() *pgxpool.Pool
 
+	// WritePool returns the pool connected to the primary upstream database.
+	WritePool() *pgxpool.Pool
+
+	// ReadPool returns the pool for branch SELECTs that can tolerate replica
+	// lag: one connected to upstream.read_replica_url if it is set, and
+	// otherwise WritePool.
+	ReadPool() *pgxpool.Pool
+
 	// --- Branch CRUD ---
 
 	CreateBranch(ctx context.Context, b *Branch) error
 	GetBranch(ctx context.Context, name string) (*Branch, error)
 	ListBranches(ctx context.Context) ([]*Branch, error)
+
+	// ListBranchesFilter returns the branches matching filter.
+	ListBranchesFilter(ctx context.Context, filter BranchFilter) ([]*Branch, error)
+
+	// ListBranchesSorted returns the branches matching filter, ordered by
+	// sort (then by creation time).
+	ListBranchesSorted(ctx context.Context, filter BranchFilter, sort []SortKey) ([]*Branch, error)
+
+	// ListBranchesByParent returns the children of parentName, including
+	// soft-deleted ones, in creation order.
+	ListBranchesByParent(ctx context.Context, parentName string) ([]*Branch, error)
+
 	UpdateBranch(ctx context.Context, b *Branch) error
 	DeleteBranch(ctx context.Context, name string) error
 
+	// SetBranchProtected marks a branch as read-only (or writable again).
+	SetBranchProtected(ctx context.Context, name string, protected bool) error
+
+	// SetBranchAllowedHosts replaces the list of hosts allowed to connect to a branch.
+	SetBranchAllowedHosts(ctx context.Context, name string, hosts []string) error
+
+	// SetBranchMaxDeltaBytes sets the branch's delta size limit; nil removes it.
+	SetBranchMaxDeltaBytes(ctx context.Context, name string, maxBytes *int64) error
+
+	// SetBranchFrozen marks a branch as frozen (or thawed).
+	SetBranchFrozen(ctx context.Context, name string, frozen bool) error
+
+	// SetBranchDeleted soft-deletes a branch, setting its deleted_at and
+	// status 'deleted', or restores it to an active branch.
+	SetBranchDeleted(ctx context.Context, name string, deleted bool) error
+
+	// SetBranchLastQueryAt records that the branch was queried at t. It
+	// doesn't count as an update of the branch.
+	SetBranchLastQueryAt(ctx context.Context, name string, t time.Time) error
+
 	// --- Branch overlay schema ---
 
 	// CreateBranchSchema creates the _rift_branch_<name> schema.
@@ -69,15 +171,46 @@ type Store interface {
 	// BranchSchemaName returns the schema name for a branch.
 	BranchSchemaName(branchName string) string
 
+	// ListBranchSchemas returns the names of all _rift_branch_* schemas in the database,
+	// including any left behind without matching branch metadata.
+	ListBranchSchemas(ctx context.Context) ([]string, error)
+
 	// --- Table tracking ---
 
 	TrackTable(ctx context.Context, t *TrackedTable) error
 	UntrackTable(ctx context.Context, branchName, sourceSchema, tableName string) error
-	ListTrackedTables(ctx context.Context, branchName string) ([]*TrackedTable, error)
+	// ListTrackedTables lists the tables a branch tracks, ordered by name,
+	// only those of sourceSchema unless it is "".
+	ListTrackedTables(ctx context.Context, branchName, sourceSchema string) ([]*TrackedTable, error)
 	UpdateTrackedTableRowCount(ctx context.Context, branchName, sourceSchema, tableName string, rowCount int64) error
 
 	// --- Primary key cache ---
 
 	CachePrimaryKeys(ctx context.Context, keys []PrimaryKeyColumn) error
+
+	// BulkCachePrimaryKeys is like CachePrimaryKeys, but loads all keys with a
+	// single COPY instead of one statement per key.
+	BulkCachePrimaryKeys(ctx context.Context, keys []PrimaryKeyColumn) error
 	GetPrimaryKeys(ctx context.Context, sourceSchema, tableName string) ([]PrimaryKeyColumn, error)
+
+	// DeletePrimaryKeys drops a table's cached primary key, so the next
+	// lookup reads it from the catalog again.
+	DeletePrimaryKeys(ctx context.Context, sourceSchema, tableName string) error
+
+	// --- Branch migrations ---
+
+	// RecordMigration records a migration applied to a branch. It returns
+	// ErrMigrationApplied if the branch already has a migration with the same hash.
+	RecordMigration(ctx context.Context, m *AppliedMigration) error
+
+	// ListMigrations returns a branch's migrations in the order they were applied.
+	ListMigrations(ctx context.Context, branchName string) ([]*AppliedMigration, error)
+
+	// --- Audit log ---
+
+	// RecordAudit appends an entry to the audit log.
+	RecordAudit(ctx context.Context, e *AuditEntry) error
+
+	// ListAudit returns the entries matching q, oldest first.
+	ListAudit(ctx context.Context, q AuditQuery) ([]*AuditEntry, error)
 }
@@ -2,6 +2,9 @@ package storage
 
 import (
 	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 func TestValidateBranchName(t *testing.T) {
@@ -143,3 +146,147 @@ func TestParseMigrationVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestLatestSchemaVersion(t *testing.T) {
+	v, err := LatestSchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v < 9 {
+		t.Errorf("LatestSchemaVersion() = %d, want at least 9", v)
+	}
+}
+
+func TestParseBranchFilter(t *testing.T) {
+	f, err := ParseBranchFilter([]string{"status=active,pinned=true", "parent=main", "name=feature-*_v?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Status != "active" || f.Pinned == nil || !*f.Pinned || f.ParentName != "main" {
+		t.Errorf("unexpected filter: %+v", f)
+	}
+	if f.NamePattern != `feature-%\_v_` {
+		t.Errorf("NamePattern = %q, want %q", f.NamePattern, `feature-%\_v_`)
+	}
+
+	f, err = ParseBranchFilter([]string{"created_after=2026-01-02", "created_before=2026-03-01T12:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.CreatedAfter.Format("2006-01-02") != "2026-01-02" || f.CreatedBefore.Hour() != 12 {
+		t.Errorf("unexpected dates: %+v", f)
+	}
+
+	f, err = ParseBranchFilter([]string{"updated_after=2026-02-01,updated_before=2026-02-08"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.UpdatedAfter.Day() != 1 || f.UpdatedBefore.Day() != 8 || !f.CreatedAfter.IsZero() {
+		t.Errorf("unexpected updated dates: %+v", f)
+	}
+
+	for _, bad := range []string{"status", "pinned=maybe", "owner=me", "created_after=yesterday"} {
+		if _, err := ParseBranchFilter([]string{bad}); err == nil {
+			t.Errorf("ParseBranchFilter(%q) should fail", bad)
+		}
+	}
+}
+
+func TestBranchFilterWhere(t *testing.T) {
+	if where, args := (BranchFilter{IncludeDeleted: true}).where(); where != "" || args != nil {
+		t.Errorf("empty filter: where = %q, args = %v", where, args)
+	}
+	if where, _ := (BranchFilter{}).where(); where != " WHERE deleted_at IS NULL" {
+		t.Errorf("default filter: where = %q, want deleted branches left out", where)
+	}
+	if where, _ := (BranchFilter{Status: "deleted"}).where(); where != " WHERE status = $1" {
+		t.Errorf("status=deleted: where = %q", where)
+	}
+
+	pinned := false
+	where, args := BranchFilter{Status: "active", Pinned: &pinned, NamePattern: "feat%", IncludeDeleted: true}.where()
+	want := " WHERE status = $1 AND pinned = $2 AND name LIKE $3"
+	if where != want {
+		t.Errorf("where = %q, want %q", where, want)
+	}
+	if len(args) != 3 || args[0] != "active" || args[1] != false || args[2] != "feat%" {
+		t.Errorf("args = %v", args)
+	}
+
+	since := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	where, args = BranchFilter{CreatedBefore: since, UpdatedAfter: since}.where()
+	want = " WHERE created_at < $1 AND updated_at > $2 AND deleted_at IS NULL"
+	if where != want || len(args) != 2 {
+		t.Errorf("where = %q (%d args), want %q", where, len(args), want)
+	}
+
+	where, _ = BranchFilter{ActiveAfter: since, IncludeDeleted: true}.where()
+	if want = " WHERE last_query_at > $1"; where != want {
+		t.Errorf("where = %q, want %q", where, want)
+	}
+	where, _ = BranchFilter{InactiveBefore: since, IncludeDeleted: true}.where()
+	if want = " WHERE COALESCE(last_query_at, created_at) < $1"; where != want {
+		t.Errorf("where = %q, want %q", where, want)
+	}
+
+	minSize, maxRows := int64(1<<20), int64(0)
+	where, args = BranchFilter{MinDeltaSize: &minSize, MaxRowsChanged: &maxRows, IncludeDeleted: true}.where()
+	want = " WHERE delta_size >= $1 AND rows_changed <= $2"
+	if where != want || len(args) != 2 || args[0] != minSize || args[1] != maxRows {
+		t.Errorf("where = %q, args = %v; want %q, [%d %d]", where, args, want, minSize, maxRows)
+	}
+}
+
+func TestParseBranchSort(t *testing.T) {
+	keys, err := ParseBranchSort("parent:asc, delta_size:DESC,name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SortKey{{Field: "parent"}, {Field: "delta_size", Desc: true}, {Field: "name"}}
+	if len(keys) != len(want) {
+		t.Fatalf("keys = %+v, want %+v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("keys[%d] = %+v, want %+v", i, keys[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"size:desc", "name:down", "name; DROP TABLE x"} {
+		if _, err := ParseBranchSort(bad); err == nil {
+			t.Errorf("ParseBranchSort(%q) should fail", bad)
+		}
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	if got := orderBy(nil); got != " ORDER BY created_at" {
+		t.Errorf("orderBy(nil) = %q", got)
+	}
+	got := orderBy([]SortKey{{Field: "delta_size", Desc: true}, {Field: "name"}})
+	if got != " ORDER BY delta_size DESC, name, created_at" {
+		t.Errorf("orderBy = %q", got)
+	}
+	got = orderBy([]SortKey{{Field: "created_at", Desc: true}})
+	if got != " ORDER BY created_at DESC" {
+		t.Errorf("orderBy(created_at desc) = %q", got)
+	}
+}
+
+func TestPoolConfigApply(t *testing.T) {
+	c, err := pgxpool.ParseConfig("postgres://localhost/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultMax := c.MaxConns
+
+	PoolConfig{}.apply(c)
+	if c.MaxConns != defaultMax || c.MinConns != 0 {
+		t.Errorf("zero PoolConfig changed the pool: max %d, min %d", c.MaxConns, c.MinConns)
+	}
+
+	PoolConfig{MinConns: 2, MaxConns: 20, MaxConnIdleTime: time.Minute}.apply(c)
+	if c.MaxConns != 20 || c.MinConns != 2 || c.MaxConnIdleTime != time.Minute {
+		t.Errorf("pool config = max %d, min %d, idle %v", c.MaxConns, c.MinConns, c.MaxConnIdleTime)
+	}
+}
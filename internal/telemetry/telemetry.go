@@ -0,0 +1,232 @@
+// Package telemetry sends opt-in, anonymous usage metrics: how many branches
+// were created and deleted, how many queries of each type ran, and how many
+// failed. Events carry a random installation ID, the rift version and the
+// OS/architecture; never SQL, branch or table names, or connection details.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/riftdata/rift/internal/cow"
+	"github.com/riftdata/rift/internal/storage"
+)
+
+// DefaultEndpoint receives events unless telemetry.endpoint overrides it.
+const DefaultEndpoint = "https://telemetry.riftdata.io/v1/events"
+
+// DefaultInterval is how often a Reporter sends an event.
+const DefaultInterval = 24 * time.Hour
+
+// idFileName is the file in the data directory holding the installation ID.
+const idFileName = "telemetry_id"
+
+// Event is the payload POSTed to the endpoint, covering one reporting period.
+type Event struct {
+	InstallationID string    `json:"installation_id"`
+	Version        string    `json:"version"`
+	OS             string    `json:"os"`
+	Arch           string    `json:"arch"`
+	PeriodStart    time.Time `json:"period_start"`
+	PeriodEnd      time.Time `json:"period_end"`
+
+	BranchesCreated int `json:"branches_created"`
+	BranchesDeleted int `json:"branches_deleted"`
+
+	// Queries counts queries by type (SELECT, INSERT, DDL, ...).
+	Queries map[string]int64 `json:"queries"`
+	Errors  int64            `json:"errors"`
+}
+
+// Collector counts queries and errors between reports. A nil Collector
+// discards everything, so callers don't need to check whether telemetry is
+// enabled.
+type Collector struct {
+	mu      sync.Mutex
+	queries map[string]int64
+	errors  int64
+}
+
+// NewCollector creates an empty Collector.
+func NewCollector() *Collector {
+	return &Collector{queries: make(map[string]int64)}
+}
+
+// RecordQuery counts a query of the given type.
+func (c *Collector) RecordQuery(queryType string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queries[queryType]++
+}
+
+// RecordError counts a query that failed.
+func (c *Collector) RecordError() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errors++
+}
+
+// take returns the counts so far and starts counting from zero.
+func (c *Collector) take() (map[string]int64, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	queries, errs := c.queries, c.errors
+	c.queries, c.errors = make(map[string]int64), 0
+	return queries, errs
+}
+
+// Reporter periodically sends an Event with the Collector's counts and the
+// branch operations recorded in the audit log.
+type Reporter struct {
+	Endpoint       string
+	InstallationID string
+	Version        string
+	Interval       time.Duration
+
+	Collector *Collector
+
+	client *http.Client
+}
+
+// NewReporter creates a Reporter that sends events for installationID to
+// endpoint ("" for DefaultEndpoint) once per DefaultInterval.
+func NewReporter(endpoint, installationID, version string) *Reporter {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	return &Reporter{
+		Endpoint:       endpoint,
+		InstallationID: installationID,
+		Version:        version,
+		Interval:       DefaultInterval,
+		Collector:      NewCollector(),
+		client:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run sends an event every Interval until ctx is done. Telemetry is best
+// effort: a failed report is dropped and never affects the server.
+func (r *Reporter) Run(ctx context.Context, store storage.Store) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case end := <-ticker.C:
+			_ = r.Report(ctx, store, start, end)
+			start = end
+		}
+	}
+}
+
+// Report sends the event for the period from start to end.
+func (r *Reporter) Report(ctx context.Context, store storage.Store, start, end time.Time) error {
+	event, err := r.buildEvent(ctx, store, start, end)
+	if err != nil {
+		return err
+	}
+	return r.send(ctx, event)
+}
+
+func (r *Reporter) buildEvent(ctx context.Context, store storage.Store, start, end time.Time) (*Event, error) {
+	entries, err := store.ListAudit(ctx, storage.AuditQuery{Since: start})
+	if err != nil {
+		return nil, fmt.Errorf("list audit log: %w", err)
+	}
+
+	queries, errs := r.Collector.take()
+	event := &Event{
+		InstallationID: r.InstallationID,
+		Version:        r.Version,
+		OS:             runtime.GOOS,
+		Arch:           runtime.GOARCH,
+		PeriodStart:    start.UTC(),
+		PeriodEnd:      end.UTC(),
+		Queries:        queries,
+		Errors:         errs,
+	}
+	for _, e := range entries {
+		if !e.OccurredAt.Before(end) {
+			continue
+		}
+		switch e.Operation {
+		case cow.AuditCreate, cow.AuditClone:
+			event.BranchesCreated++
+		case cow.AuditDelete:
+			event.BranchesDeleted++
+		}
+	}
+	return event, nil
+}
+
+func (r *Reporter) send(ctx context.Context, event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encode event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "rift/"+r.Version)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send event: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("send event: %s", resp.Status)
+	}
+	return nil
+}
+
+// InstallationID returns the random ID stored in dir, creating it on first
+// use. The ID identifies an installation across reports and nothing else.
+func InstallationID(dir string) (string, error) {
+	path := filepath.Join(dir, idFileName)
+	data, err := os.ReadFile(path) // #nosec G304 -- path in rift's own data directory
+	if err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("read installation ID: %w", err)
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate installation ID: %w", err)
+	}
+	id := hex.EncodeToString(b)
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", fmt.Errorf("create data directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(id+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("write installation ID: %w", err)
+	}
+	return id, nil
+}
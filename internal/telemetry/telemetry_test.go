@@ -0,0 +1,114 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstallationID(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "rift")
+
+	id, err := InstallationID(dir)
+	if err != nil {
+		t.Fatalf("InstallationID: %v", err)
+	}
+	if len(id) != 32 {
+		t.Errorf("id = %q, want 32 hex characters", id)
+	}
+
+	again, err := InstallationID(dir)
+	if err != nil {
+		t.Fatalf("InstallationID: %v", err)
+	}
+	if again != id {
+		t.Errorf("second call = %q, want the stored %q", again, id)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, idFileName))
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("mode = %o, want 600", perm)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	var nilCollector *Collector
+	nilCollector.RecordQuery("SELECT") // must not panic
+	nilCollector.RecordError()
+
+	c := NewCollector()
+	c.RecordQuery("SELECT")
+	c.RecordQuery("SELECT")
+	c.RecordQuery("INSERT")
+	c.RecordError()
+
+	queries, errs := c.take()
+	if queries["SELECT"] != 2 || queries["INSERT"] != 1 || errs != 1 {
+		t.Errorf("take() = %v, %d; want SELECT 2, INSERT 1, 1 error", queries, errs)
+	}
+
+	queries, errs = c.take()
+	if len(queries) != 0 || errs != 0 {
+		t.Errorf("take() after take() = %v, %d; want nothing", queries, errs)
+	}
+}
+
+func TestSend(t *testing.T) {
+	var got Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	r := NewReporter(srv.URL, "abc123", "1.2.3")
+	event := &Event{
+		InstallationID:  "abc123",
+		Version:         "1.2.3",
+		PeriodEnd:       time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+		BranchesCreated: 3,
+		Queries:         map[string]int64{"SELECT": 10},
+	}
+	if err := r.send(context.Background(), event); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if got.InstallationID != "abc123" || got.BranchesCreated != 3 || got.Queries["SELECT"] != 10 {
+		t.Errorf("server got %+v", got)
+	}
+}
+
+func TestSendRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	r := NewReporter(srv.URL, "abc123", "dev")
+	err := r.send(context.Background(), &Event{})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("send = %v, want a 503 error", err)
+	}
+}
+
+func TestNewReporterDefaultEndpoint(t *testing.T) {
+	if r := NewReporter("", "id", "dev"); r.Endpoint != DefaultEndpoint {
+		t.Errorf("Endpoint = %q, want %q", r.Endpoint, DefaultEndpoint)
+	}
+}
@@ -0,0 +1,10 @@
+// Package testutil holds helpers shared by unit tests across packages.
+package testutil
+
+import "github.com/riftdata/rift/internal/storage/mock"
+
+// NewMockStore returns an in-memory storage.Store holding only the main
+// branch, for tests of engine logic that don't need a database.
+func NewMockStore() *mock.Store {
+	return mock.New()
+}
@@ -103,6 +103,33 @@ func SelectWithKeys(title string, options map[string]string) (string, error) {
 	return result, err
 }
 
+// Option is one of the choices of SelectOption.
+type Option struct {
+	Key   string // returned when the option is chosen
+	Label string
+}
+
+// SelectOption prompts for one of options, shown in the order given with
+// description under the title, and returns the key of the chosen option.
+func SelectOption(title, description string, options []Option) (string, error) {
+	var result string
+
+	opts := make([]huh.Option[string], len(options))
+	for i, opt := range options {
+		opts[i] = huh.NewOption(opt.Label, opt.Key)
+	}
+
+	err := huh.NewSelect[string]().
+		Title(title).
+		Description(description).
+		Options(opts...).
+		Value(&result).
+		WithTheme(PromptTheme()).
+		Run()
+
+	return result, err
+}
+
 // MultiSelect prompts for multiple selections
 func MultiSelect(title string, options []string) ([]string, error) {
 	var result []string
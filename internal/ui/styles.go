@@ -118,6 +118,7 @@ const (
 	IconClock    = "⏱"
 	IconLock     = "🔒"
 	IconUnlock   = "🔓"
+	IconFrozen   = "❄"
 )
 
 // Emoji alternatives (more compatible)
@@ -0,0 +1,202 @@
+// rift dashboard: a branch tree with per-branch diff summaries, create,
+// delete and merge actions, and a live feed of branch operations, all on
+// top of the HTTP API.
+"use strict";
+
+// 'rift web --open' passes the API token in the URL fragment, which is never
+// sent to the server. Keep it for this tab and drop it from the address bar.
+(function takeToken() {
+  const match = location.hash.match(/token=([^&]+)/);
+  if (match) {
+    sessionStorage.setItem("rift-token", decodeURIComponent(match[1]));
+    history.replaceState(null, "", location.pathname);
+  }
+})();
+
+const token = sessionStorage.getItem("rift-token") || "";
+let selected = null;
+
+async function api(method, path, body) {
+  const headers = {};
+  if (token) {
+    headers.Authorization = "Bearer " + token;
+  }
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  const resp = await fetch(path, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  const data = await resp.json().catch(() => ({}));
+  if (!resp.ok) {
+    throw new Error(data.error || resp.statusText);
+  }
+  return data;
+}
+
+function el(tag, props, ...children) {
+  const node = document.createElement(tag);
+  Object.assign(node, props);
+  node.append(...children);
+  return node;
+}
+
+// --- Branch tree ---
+
+async function loadBranches() {
+  const branches = await api("GET", "/api/v1/branches");
+  const children = new Map();
+  for (const b of branches) {
+    const parent = b.parent || "";
+    if (!children.has(parent)) {
+      children.set(parent, []);
+    }
+    children.get(parent).push(b);
+  }
+
+  const tree = document.getElementById("tree");
+  tree.replaceChildren(...renderLevel(children, ""));
+
+  const parents = document.getElementById("create-parent");
+  const current = parents.value || "main";
+  parents.replaceChildren(...branches.map((b) => el("option", { value: b.name, textContent: b.name })));
+  parents.value = current;
+
+  if (selected && !branches.some((b) => b.name === selected)) {
+    selectBranch(null);
+  }
+}
+
+function renderLevel(children, parent) {
+  return (children.get(parent) || []).map((b) => {
+    const label = el("span", {
+      textContent: b.name + (b.protected ? " 🔒" : "") + (b.frozen ? " ❄" : ""),
+      className: b.name === selected ? "selected" : "",
+      onclick: () => selectBranch(b.name),
+    });
+    const item = el("li", {}, label);
+    const sub = renderLevel(children, b.name);
+    if (sub.length > 0) {
+      item.append(el("ul", {}, ...sub));
+    }
+    return item;
+  });
+}
+
+// --- Branch detail ---
+
+async function selectBranch(name) {
+  selected = name;
+  for (const span of document.querySelectorAll("#tree span")) {
+    span.classList.toggle("selected", span.textContent.split(" ")[0] === name);
+  }
+
+  const title = document.getElementById("detail-title");
+  const actions = document.getElementById("detail-actions");
+  const table = document.getElementById("diff");
+  const message = document.getElementById("detail-message");
+  table.hidden = true;
+  message.textContent = "";
+
+  if (!name) {
+    title.textContent = "Select a branch";
+    actions.hidden = true;
+    return;
+  }
+  title.textContent = name;
+  actions.hidden = name === "main";
+  if (name === "main") {
+    message.textContent = "main is the upstream database.";
+    return;
+  }
+
+  try {
+    const diff = await api("GET", `/api/v1/branches/${encodeURIComponent(name)}/diff`);
+    const rows = diff.tables.map((t) =>
+      el("tr", {},
+        el("td", { textContent: `${t.schema}.${t.table}` }),
+        el("td", { textContent: t.inserts }),
+        el("td", { textContent: t.updates }),
+        el("td", { textContent: t.deletes })));
+    table.tBodies[0].replaceChildren(...rows);
+    table.hidden = rows.length === 0;
+    message.textContent = `${diff.total_changes} change(s) against ${diff.parent}`;
+  } catch (err) {
+    message.textContent = err.message;
+  }
+}
+
+async function act(fn) {
+  const message = document.getElementById("detail-message");
+  try {
+    await fn();
+  } catch (err) {
+    message.textContent = err.message;
+    return;
+  }
+  await loadBranches();
+}
+
+document.getElementById("create-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  const name = document.getElementById("create-name").value.trim();
+  const parent = document.getElementById("create-parent").value;
+  act(async () => {
+    await api("POST", "/api/v1/branches", { name, parent });
+    document.getElementById("create-name").value = "";
+    selected = name;
+  }).then(() => selectBranch(name));
+});
+
+document.getElementById("delete-button").addEventListener("click", () => {
+  const name = selected;
+  if (name && confirm(`Delete branch ${name}? It can be restored with 'rift branches restore'.`)) {
+    act(() => api("DELETE", `/api/v1/branches/${encodeURIComponent(name)}`));
+  }
+});
+
+document.getElementById("merge-button").addEventListener("click", () => {
+  const name = selected;
+  if (name && confirm(`Merge ${name} into its parent?`)) {
+    act(async () => {
+      const result = await api("POST", `/api/v1/branches/${encodeURIComponent(name)}/merge`);
+      await selectBranch(name);
+      document.getElementById("detail-message").textContent =
+        `Merged ${result.tables} table(s), ${result.rows_affected} row(s) affected.`;
+    });
+  }
+});
+
+// --- Activity feed ---
+
+function watchEvents() {
+  const url = "/api/v1/events" + (token ? "?token=" + encodeURIComponent(token) : "");
+  const source = new EventSource(url);
+  const status = document.getElementById("connection");
+  source.onopen = () => {
+    status.textContent = "live";
+  };
+  source.onerror = () => {
+    status.textContent = "reconnecting…";
+  };
+
+  const log = document.getElementById("event-log");
+  source.onmessage = (event) => {
+    const entry = JSON.parse(event.data);
+    const when = new Date(entry.occurred_at).toLocaleTimeString();
+    log.prepend(el("li", { textContent: `${when} ${entry.operation} ${entry.branch} (${entry.user || "unknown"})` }));
+    loadBranches().catch(() => {});
+    if (entry.branch === selected) {
+      selectBranch(selected);
+    }
+  };
+}
+
+loadBranches()
+  .then(() => selectBranch("main"))
+  .catch((err) => {
+    document.getElementById("detail-message").textContent = err.message;
+  });
+watchEvents();
@@ -0,0 +1,22 @@
+// Package web holds rift's browser dashboard, a single page served
+// alongside the HTTP API by 'rift web'.
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed assets
+var assets embed.FS
+
+// Handler serves the dashboard's static files.
+func Handler() http.Handler {
+	sub, err := fs.Sub(assets, "assets")
+	if err != nil {
+		// The directory is embedded at build time, so this can't happen
+		panic(err)
+	}
+	return http.FileServerFS(sub)
+}
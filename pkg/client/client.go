@@ -0,0 +1,383 @@
+// Package client is a Go client for the rift HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxRetries is how many times a request is retried after a 429 or 503.
+	maxRetries = 3
+
+	// defaultRetryBackoff is the wait before the first retry; it doubles on
+	// each subsequent retry.
+	defaultRetryBackoff = 200 * time.Millisecond
+)
+
+// Client talks to a rift API server.
+type Client struct {
+	// BaseURL is the API server address, e.g. "http://localhost:8080".
+	BaseURL string
+
+	// HTTPClient is used for requests. http.DefaultClient if nil.
+	HTTPClient *http.Client
+
+	// Token, if set, is sent as a bearer token (api.auth_token).
+	Token string
+
+	// retryBackoff overrides defaultRetryBackoff (for tests).
+	retryBackoff time.Duration
+}
+
+// TokenEnv is the environment variable New reads the token from when none is
+// given; it is the same variable that sets api.auth_token for the server.
+const TokenEnv = "RIFT_API_AUTH_TOKEN"
+
+// New creates a client for the API server at baseURL. An empty token falls
+// back to $RIFT_API_AUTH_TOKEN.
+func New(baseURL, token string) *Client {
+	if token == "" {
+		token = os.Getenv(TokenEnv)
+	}
+	return &Client{BaseURL: baseURL, Token: token}
+}
+
+// Error is returned when the API responds with an error status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rift api: %s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// IsNotFound reports whether err is an API error with status 404.
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Branch describes a branch.
+type Branch struct {
+	Name        string `json:"name"`
+	Parent      string `json:"parent,omitempty"`
+	Database    string `json:"database"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+	Pinned      bool   `json:"pinned"`
+	Protected   bool   `json:"protected"`
+	Frozen      bool   `json:"frozen"`
+	DeltaSize   int64  `json:"delta_size"`
+	RowsChanged int64  `json:"rows_changed"`
+	TTLSeconds  *int   `json:"ttl_seconds,omitempty"`
+	Status      string `json:"status"`
+
+	// AllowedHosts lists the hosts allowed to connect; empty allows any.
+	AllowedHosts []string `json:"allowed_hosts,omitempty"`
+
+	// MaxDeltaBytes is the delta size at which writes are rejected; nil
+	// means no limit.
+	MaxDeltaBytes *int64 `json:"max_delta_bytes,omitempty"`
+}
+
+// CreateBranchRequest holds the parameters for CreateBranch.
+type CreateBranchRequest struct {
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty"` // defaults to "main"
+	TTL    string `json:"ttl,omitempty"`    // e.g. "1h", "24h"
+}
+
+// Diff summarizes a branch's changes relative to its parent.
+type Diff struct {
+	Branch       string      `json:"branch"`
+	Parent       string      `json:"parent"`
+	TotalChanges int64       `json:"total_changes"`
+	Tables       []TableDiff `json:"tables"`
+}
+
+// TableDiff holds the change counts for one table.
+type TableDiff struct {
+	Table   string `json:"table"`
+	Schema  string `json:"schema"`
+	Inserts int64  `json:"inserts"`
+	Updates int64  `json:"updates"`
+	Deletes int64  `json:"deletes"`
+}
+
+// MergeSQL is the SQL that applies a branch's changes to its parent.
+type MergeSQL struct {
+	Branch         string   `json:"branch"`
+	Tables         []string `json:"tables"`
+	Migrations     []string `json:"migrations,omitempty"`
+	SQL            string   `json:"sql"`
+	StatementCount int      `json:"statement_count"`
+}
+
+// Stats holds storage efficiency metrics for a branch.
+type Stats struct {
+	Branch string       `json:"branch"`
+	Tables []TableStats `json:"tables"`
+	Total  TableStats   `json:"total"`
+}
+
+// TableStats holds storage metrics for one overlay table. Sizes are in bytes;
+// TombstoneRatio is a percentage.
+type TableStats struct {
+	Schema           string  `json:"schema,omitempty"`
+	Table            string  `json:"table,omitempty"`
+	OverlayRows      int64   `json:"overlay_rows"`
+	Tombstones       int64   `json:"tombstones"`
+	TombstoneRatio   float64 `json:"tombstone_ratio"`
+	SourceSize       int64   `json:"source_size"`
+	OverlaySize      int64   `json:"overlay_size"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	MergeSQLSize     int64   `json:"merge_sql_size"`
+}
+
+// AuditEntry is one branch operation from the audit log.
+type AuditEntry struct {
+	ID         int64                  `json:"id"`
+	Branch     string                 `json:"branch"`
+	Operation  string                 `json:"operation"`
+	User       string                 `json:"user"`
+	Details    map[string]interface{} `json:"details"`
+	OccurredAt string                 `json:"occurred_at"`
+}
+
+// ListBranches returns all branches.
+func (c *Client) ListBranches(ctx context.Context) ([]Branch, error) {
+	var branches []Branch
+	if err := c.do(ctx, http.MethodGet, "/api/v1/branches", nil, &branches); err != nil {
+		return nil, err
+	}
+	return branches, nil
+}
+
+// ListBranchesFilter returns the branches matching filter, whose keys are
+// those of 'rift list --filter' (status, pinned, parent, name, created_after,
+// created_before).
+func (c *Client) ListBranchesFilter(ctx context.Context, filter url.Values) ([]Branch, error) {
+	path := "/api/v1/branches"
+	if len(filter) > 0 {
+		path += "?" + filter.Encode()
+	}
+	var branches []Branch
+	if err := c.do(ctx, http.MethodGet, path, nil, &branches); err != nil {
+		return nil, err
+	}
+	return branches, nil
+}
+
+// GetBranch returns a single branch.
+func (c *Client) GetBranch(ctx context.Context, name string) (*Branch, error) {
+	var b Branch
+	if err := c.do(ctx, http.MethodGet, branchPath(name, ""), nil, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// CreateBranch creates a branch and returns it.
+func (c *Client) CreateBranch(ctx context.Context, req CreateBranchRequest) (*Branch, error) {
+	var b Branch
+	if err := c.do(ctx, http.MethodPost, "/api/v1/branches", req, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// SetMaxDeltaBytes sets the delta size at which writes to a branch are
+// rejected and returns the updated branch. nil removes the limit.
+func (c *Client) SetMaxDeltaBytes(ctx context.Context, name string, maxBytes *int64) (*Branch, error) {
+	req := struct {
+		MaxDeltaBytes *int64 `json:"max_delta_bytes"`
+	}{maxBytes}
+	var b Branch
+	if err := c.do(ctx, http.MethodPatch, branchPath(name, ""), req, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// DeleteBranch deletes a branch.
+func (c *Client) DeleteBranch(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, branchPath(name, ""), nil, nil)
+}
+
+// RevertTable discards a branch's changes to one table.
+func (c *Client) RevertTable(ctx context.Context, name, schema, table string) error {
+	suffix := "/tables/" + url.PathEscape(schema) + "/" + url.PathEscape(table)
+	return c.do(ctx, http.MethodDelete, branchPath(name, suffix), nil, nil)
+}
+
+// GetDiff returns a branch's changes relative to its parent.
+func (c *Client) GetDiff(ctx context.Context, name string) (*Diff, error) {
+	var d Diff
+	if err := c.do(ctx, http.MethodGet, branchPath(name, "/diff"), nil, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// GetMergeSQL returns the SQL that merges a branch into its parent.
+func (c *Client) GetMergeSQL(ctx context.Context, name string) (*MergeSQL, error) {
+	var m MergeSQL
+	if err := c.do(ctx, http.MethodGet, branchPath(name, "/merge-sql"), nil, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetStats returns storage efficiency metrics for a branch.
+func (c *Client) GetStats(ctx context.Context, name string) (*Stats, error) {
+	var s Stats
+	if err := c.do(ctx, http.MethodGet, branchPath(name, "/stats"), nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetAudit returns a branch's audit log, oldest first. A zero since or limit
+// uses the server's defaults (all history, most recent 50 entries).
+func (c *Client) GetAudit(ctx context.Context, name string, since time.Time, limit int) ([]AuditEntry, error) {
+	q := url.Values{}
+	if !since.IsZero() {
+		q.Set("since", since.Format(time.RFC3339))
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	path := branchPath(name, "/audit")
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	var entries []AuditEntry
+	if err := c.do(ctx, http.MethodGet, path, nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func branchPath(name, suffix string) string {
+	return "/api/v1/branches/" + url.PathEscape(name) + suffix
+}
+
+// do sends a request, retrying with exponential backoff on 429 and 503, and
+// decodes a JSON response into out (if non-nil).
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+	}
+
+	backoff := c.retryBackoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, payload)
+		if err != nil {
+			return err
+		}
+
+		if attempt < maxRetries && retryable(resp.StatusCode) {
+			wait := retryAfter(resp, backoff)
+			_ = resp.Body.Close()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			backoff *= 2
+			continue
+		}
+
+		return decodeResponse(resp, out)
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, payload []byte) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.BaseURL, "/")+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	return resp, nil
+}
+
+// retryable reports whether a response status is a transient error.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// retryAfter returns the wait requested by a Retry-After header in seconds,
+// or fallback if there is none.
+func retryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return fallback
+}
+
+func decodeResponse(resp *http.Response, out interface{}) error {
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		var body struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err == nil && body.Error != "" {
+			apiErr.Message = body.Error
+		} else {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
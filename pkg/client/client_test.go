@@ -0,0 +1,407 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	c := New(srv.URL, "")
+	c.retryBackoff = time.Millisecond
+	return c
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestListBranches(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/v1/branches" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		writeJSON(w, http.StatusOK, []map[string]interface{}{
+			{"name": "main", "status": "active"},
+			{"name": "dev", "parent": "main", "protected": true, "delta_size": 42},
+		})
+	})
+
+	branches, err := c.ListBranches(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 2 {
+		t.Fatalf("expected 2 branches, got %d", len(branches))
+	}
+	if b := branches[1]; b.Name != "dev" || b.Parent != "main" || !b.Protected || b.DeltaSize != 42 {
+		t.Errorf("unexpected branch: %+v", b)
+	}
+}
+
+func TestListBranchesFilter(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/branches" || r.URL.Query().Get("status") != "active" || r.URL.Query().Get("name") != "feature-*" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		writeJSON(w, http.StatusOK, []map[string]interface{}{{"name": "feature-a", "status": "active"}})
+	})
+
+	branches, err := c.ListBranchesFilter(context.Background(), url.Values{"status": {"active"}, "name": {"feature-*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 1 || branches[0].Name != "feature-a" {
+		t.Errorf("unexpected branches: %+v", branches)
+	}
+}
+
+func TestGetBranchNotFound(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/branches/missing" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": `branch "missing" not found`})
+	})
+
+	_, err := c.GetBranch(context.Background(), "missing")
+	if !IsNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Message != `branch "missing" not found` {
+		t.Errorf("expected API error message, got %v", err)
+	}
+}
+
+func TestCreateBranch(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/branches" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+
+		var req CreateBranchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+		if req.Name != "feature" || req.Parent != "dev" || req.TTL != "24h" {
+			t.Errorf("unexpected request body: %+v", req)
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"name": req.Name, "parent": req.Parent})
+	})
+
+	b, err := c.CreateBranch(context.Background(), CreateBranchRequest{Name: "feature", Parent: "dev", TTL: "24h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Name != "feature" || b.Parent != "dev" {
+		t.Errorf("unexpected branch: %+v", b)
+	}
+}
+
+func TestSetMaxDeltaBytes(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/api/v1/branches/dev" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": "dev", "max_delta_bytes": req["max_delta_bytes"]})
+	})
+
+	limit := int64(1 << 30)
+	b, err := c.SetMaxDeltaBytes(context.Background(), "dev", &limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.MaxDeltaBytes == nil || *b.MaxDeltaBytes != limit {
+		t.Errorf("MaxDeltaBytes = %v, want %d", b.MaxDeltaBytes, limit)
+	}
+
+	b, err = c.SetMaxDeltaBytes(context.Background(), "dev", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.MaxDeltaBytes != nil {
+		t.Errorf("MaxDeltaBytes = %d, want nil", *b.MaxDeltaBytes)
+	}
+}
+
+func TestDeleteBranch(t *testing.T) {
+	var called bool
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if r.Method != http.MethodDelete || r.URL.Path != "/api/v1/branches/dev" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "deleted", "branch": "dev"})
+	})
+
+	if err := c.DeleteBranch(context.Background(), "dev"); err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Error("server was not called")
+	}
+}
+
+func TestRevertTable(t *testing.T) {
+	var called bool
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if r.Method != http.MethodDelete || r.URL.Path != "/api/v1/branches/dev/tables/public/orders" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "reverted", "branch": "dev"})
+	})
+
+	if err := c.RevertTable(context.Background(), "dev", "public", "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Error("server was not called")
+	}
+}
+
+func TestGetDiff(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/branches/dev/diff" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"branch":        "dev",
+			"parent":        "main",
+			"total_changes": 3,
+			"tables": []map[string]interface{}{
+				{"table": "users", "schema": "public", "inserts": 1, "updates": 1, "deletes": 1},
+			},
+		})
+	})
+
+	d, err := c.GetDiff(context.Background(), "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.TotalChanges != 3 || len(d.Tables) != 1 || d.Tables[0].Table != "users" || d.Tables[0].Deletes != 1 {
+		t.Errorf("unexpected diff: %+v", d)
+	}
+}
+
+func TestGetMergeSQL(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/branches/dev/merge-sql" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"branch":          "dev",
+			"tables":          []string{"users"},
+			"sql":             "BEGIN;\nCOMMIT;",
+			"statement_count": 2,
+		})
+	})
+
+	m, err := c.GetMergeSQL(context.Background(), "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.StatementCount != 2 || m.SQL != "BEGIN;\nCOMMIT;" || len(m.Tables) != 1 {
+		t.Errorf("unexpected merge SQL: %+v", m)
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/branches/dev/stats" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"branch": "dev",
+			"tables": []map[string]interface{}{{"schema": "public", "table": "users", "overlay_rows": 10}},
+			"total":  map[string]interface{}{"overlay_rows": 10, "compression_ratio": 2.5},
+		})
+	})
+
+	s, err := c.GetStats(context.Background(), "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Tables) != 1 || s.Total.OverlayRows != 10 || s.Total.CompressionRatio != 2.5 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestGetAudit(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/branches/dev/audit" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("since"); got != "2026-01-02T03:04:05Z" {
+			t.Errorf("since = %q", got)
+		}
+		if got := r.URL.Query().Get("limit"); got != "10" {
+			t.Errorf("limit = %q", got)
+		}
+		writeJSON(w, http.StatusOK, []map[string]interface{}{
+			{"id": 7, "branch": "dev", "operation": "merge", "user": "alice", "details": map[string]interface{}{"tables": 2}},
+		})
+	})
+
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries, err := c.GetAudit(context.Background(), "dev", since, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Operation != "merge" || entries[0].User != "alice" || entries[0].Details["tables"] != 2.0 {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q, want %q", got, "Bearer secret")
+		}
+		writeJSON(w, http.StatusOK, []interface{}{})
+	})
+	c.Token = "secret"
+
+	if _, err := c.ListBranches(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNoTokenNoAuthorizationHeader(t *testing.T) {
+	t.Setenv(TokenEnv, "")
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Errorf("Authorization = %q, want none", got)
+		}
+		writeJSON(w, http.StatusOK, []interface{}{})
+	})
+
+	if _, err := c.ListBranches(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokenFromEnv(t *testing.T) {
+	t.Setenv(TokenEnv, "from-env")
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer from-env" {
+			t.Errorf("Authorization = %q, want %q", got, "Bearer from-env")
+		}
+		writeJSON(w, http.StatusOK, []interface{}{})
+	})
+
+	if _, err := c.ListBranches(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if New("http://localhost", "explicit").Token != "explicit" {
+		t.Error("explicit token should take precedence over the environment")
+	}
+}
+
+func TestRetryOnTransientErrors(t *testing.T) {
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		var calls atomic.Int32
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) < 3 {
+				writeJSON(w, status, map[string]string{"error": "busy"})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"name": "dev"})
+		})
+
+		b, err := c.GetBranch(context.Background(), "dev")
+		if err != nil {
+			t.Fatalf("status %d: %v", status, err)
+		}
+		if b.Name != "dev" || calls.Load() != 3 {
+			t.Errorf("status %d: got %+v after %d calls", status, b, calls.Load())
+		}
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "not ready"})
+	})
+
+	_, err := c.GetBranch(context.Background(), "dev")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 error, got %v", err)
+	}
+	if got := calls.Load(); got != maxRetries+1 {
+		t.Errorf("expected %d attempts, got %d", maxRetries+1, got)
+	}
+}
+
+func TestNoRetryOnServerError(t *testing.T) {
+	var calls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "boom"})
+	})
+
+	if _, err := c.GetBranch(context.Background(), "dev"); err == nil {
+		t.Fatal("expected error")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("expected 1 attempt, got %d", got)
+	}
+}
+
+func TestRetryStopsOnContextCancel(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "slow down"})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.GetBranch(ctx, "dev")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("retry did not stop when the context was canceled")
+	}
+}
+
+func TestBranchNameEscaped(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/v1/branches/a%2Fb" {
+			t.Errorf("unexpected path %s", r.URL.EscapedPath())
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"name": "a/b"})
+	})
+
+	if _, err := c.GetBranch(context.Background(), "a/b"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,16 +4,23 @@ package integration
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
+	"strings"
 	"testing"
 	"time"
 
 	pgx "github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/riftdata/rift/internal/api"
 	"github.com/riftdata/rift/internal/cow"
 	"github.com/riftdata/rift/internal/server"
+	"github.com/riftdata/rift/internal/snapshot"
 	"github.com/riftdata/rift/internal/storage"
 )
 
@@ -161,6 +168,37 @@ func TestStorageBranchCRUD(t *testing.T) {
 		t.Errorf("after update, Database = %q, want %q", got2.Database, "updateddb")
 	}
 
+	// Soft delete hides the branch from listings until it is restored
+	if err := store.SetBranchDeleted(ctx, "test-branch", true); err != nil {
+		t.Fatalf("SetBranchDeleted: %v", err)
+	}
+	if got3, _ := store.GetBranch(ctx, "test-branch"); got3.DeletedAt == nil || got3.Status != "deleted" {
+		t.Errorf("after soft delete: deleted_at = %v, status = %q", got3.DeletedAt, got3.Status)
+	}
+	if live, _ := store.ListBranches(ctx); len(live) != 1 {
+		t.Errorf("ListBranches after soft delete returned %d branches, want 1", len(live))
+	}
+	if all, _ := store.ListBranchesFilter(ctx, storage.BranchFilter{IncludeDeleted: true}); len(all) != 2 {
+		t.Errorf("ListBranchesFilter with deleted returned %d branches, want 2", len(all))
+	}
+	if err := store.SetBranchDeleted(ctx, "test-branch", false); err != nil {
+		t.Fatalf("SetBranchDeleted(false): %v", err)
+	}
+	if got3, _ := store.GetBranch(ctx, "test-branch"); got3.DeletedAt != nil || got3.Status != "active" {
+		t.Errorf("after restore: deleted_at = %v, status = %q", got3.DeletedAt, got3.Status)
+	}
+
+	// Query activity
+	if err := store.SetBranchLastQueryAt(ctx, "test-branch", now.Add(time.Minute)); err != nil {
+		t.Fatalf("SetBranchLastQueryAt: %v", err)
+	}
+	if active, _ := store.ListBranchesFilter(ctx, storage.BranchFilter{ActiveAfter: now}); len(active) != 1 || active[0].Name != "test-branch" {
+		t.Errorf("ListBranchesFilter active after creation = %v, want test-branch", active)
+	}
+	if inactive, _ := store.ListBranchesFilter(ctx, storage.BranchFilter{InactiveBefore: now.Add(time.Minute)}); len(inactive) != 1 || inactive[0].Name != "main" {
+		t.Errorf("ListBranchesFilter inactive = %v, want main", inactive)
+	}
+
 	// Delete
 	if err := store.DeleteBranch(ctx, "test-branch"); err != nil {
 		t.Fatalf("DeleteBranch: %v", err)
@@ -249,6 +287,148 @@ func TestStoragePrimaryKeyCache(t *testing.T) {
 	}
 }
 
+func TestStorageBulkPrimaryKeyCache(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	// An existing entry is updated, not duplicated.
+	if err := store.CachePrimaryKeys(ctx, []storage.PrimaryKeyColumn{
+		{SourceSchema: "public", TableName: "users", ColumnName: "id", Ordinal: 2},
+	}); err != nil {
+		t.Fatalf("CachePrimaryKeys: %v", err)
+	}
+
+	var pks []storage.PrimaryKeyColumn
+	for i := 0; i < 200; i++ {
+		pks = append(pks, storage.PrimaryKeyColumn{SourceSchema: "public", TableName: fmt.Sprintf("t%d", i), ColumnName: "id", Ordinal: 1})
+	}
+	pks = append(pks,
+		storage.PrimaryKeyColumn{SourceSchema: "public", TableName: "users", ColumnName: "id", Ordinal: 1},
+		storage.PrimaryKeyColumn{SourceSchema: "public", TableName: "users", ColumnName: "tenant_id", Ordinal: 2},
+	)
+
+	if err := store.BulkCachePrimaryKeys(ctx, pks); err != nil {
+		t.Fatalf("BulkCachePrimaryKeys: %v", err)
+	}
+
+	got, err := store.GetPrimaryKeys(ctx, "public", "users")
+	if err != nil {
+		t.Fatalf("GetPrimaryKeys: %v", err)
+	}
+	if len(got) != 2 || got[0].ColumnName != "id" || got[1].ColumnName != "tenant_id" {
+		t.Errorf("GetPrimaryKeys = %+v", got)
+	}
+
+	got, err = store.GetPrimaryKeys(ctx, "public", "t199")
+	if err != nil || len(got) != 1 {
+		t.Errorf("GetPrimaryKeys(t199) = %+v, %v", got, err)
+	}
+}
+
+func TestStorageMigrations(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	now := time.Now()
+	if err := store.CreateBranch(ctx, &storage.Branch{
+		Name: "migrated", Parent: "main", Database: "testdb", CreatedAt: now, UpdatedAt: now, Status: "active",
+	}); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+
+	m := &storage.AppliedMigration{BranchName: "migrated", FileHash: "abc", FileName: "001.sql", SQL: "SELECT 1"}
+	if err := store.RecordMigration(ctx, m); err != nil {
+		t.Fatalf("RecordMigration: %v", err)
+	}
+	if err := store.RecordMigration(ctx, m); !errors.Is(err, storage.ErrMigrationApplied) {
+		t.Errorf("second RecordMigration = %v, want ErrMigrationApplied", err)
+	}
+
+	got, err := store.ListMigrations(ctx, "migrated")
+	if err != nil {
+		t.Fatalf("ListMigrations: %v", err)
+	}
+	if len(got) != 1 || got[0].FileName != "001.sql" || got[0].SQL != "SELECT 1" || got[0].MergedAt != nil {
+		t.Errorf("ListMigrations = %+v", got)
+	}
+}
+
+func TestStorageAuditLog(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	for _, op := range []string{"create", "merge", "delete"} {
+		if err := store.RecordAudit(ctx, &storage.AuditEntry{
+			BranchName: "audited", Operation: op, UserName: "alice", Details: map[string]any{"op": op},
+		}); err != nil {
+			t.Fatalf("RecordAudit: %v", err)
+		}
+	}
+	if err := store.RecordAudit(ctx, &storage.AuditEntry{BranchName: "other", Operation: "create"}); err != nil {
+		t.Fatalf("RecordAudit: %v", err)
+	}
+
+	all, err := store.ListAudit(ctx, storage.AuditQuery{BranchName: "audited"})
+	if err != nil {
+		t.Fatalf("ListAudit: %v", err)
+	}
+	if len(all) != 3 || all[0].Operation != "create" || all[2].Operation != "delete" || all[0].UserName != "alice" || all[1].Details["op"] != "merge" {
+		t.Errorf("ListAudit = %+v", all)
+	}
+
+	// Limit keeps the most recent entries, still oldest first.
+	recent, err := store.ListAudit(ctx, storage.AuditQuery{BranchName: "audited", Limit: 2})
+	if err != nil {
+		t.Fatalf("ListAudit with limit: %v", err)
+	}
+	if len(recent) != 2 || recent[0].Operation != "merge" || recent[1].Operation != "delete" {
+		t.Errorf("ListAudit with limit = %+v", recent)
+	}
+
+	future, err := store.ListAudit(ctx, storage.AuditQuery{Since: time.Now().Add(time.Hour)})
+	if err != nil || len(future) != 0 {
+		t.Errorf("ListAudit since future = %+v, %v", future, err)
+	}
+
+	after, err := store.ListAudit(ctx, storage.AuditQuery{AfterID: all[1].ID})
+	if err != nil || len(after) != 2 || after[0].Operation != "delete" || after[1].BranchName != "other" {
+		t.Errorf("ListAudit after the merge = %+v, %v", after, err)
+	}
+}
+
 func TestCowOverlayAndDiff(t *testing.T) {
 	testURL, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -295,7 +475,7 @@ func TestCowOverlayAndDiff(t *testing.T) {
 	}
 
 	// Create overlay table
-	if err := cow.EnsureOverlayTable(ctx, pool, branchSchema, "public", "users"); err != nil {
+	if err := cow.EnsureOverlayTable(ctx, pool, branchSchema, "public", "users", cow.OverlayOptions{}); err != nil {
 		t.Fatalf("EnsureOverlayTable: %v", err)
 	}
 
@@ -397,7 +577,7 @@ func TestCowMergeSQL(t *testing.T) {
 
 	branchSchema := store.BranchSchemaName("merge-test")
 	_ = store.CreateBranchSchema(ctx, "merge-test")
-	_ = cow.EnsureOverlayTable(ctx, pool, branchSchema, "public", "products")
+	_ = cow.EnsureOverlayTable(ctx, pool, branchSchema, "public", "products", cow.OverlayOptions{})
 
 	// Add overlay changes
 	_, _ = pool.Exec(ctx, fmt.Sprintf(
@@ -405,7 +585,7 @@ func TestCowMergeSQL(t *testing.T) {
 		pgQuoteIdent(branchSchema)))
 
 	// Generate merge SQL
-	mergeSQL, err := cow.GenerateMergeSQL(ctx, pool, branchSchema, "public", "products", []string{"id"})
+	mergeSQL, err := cow.GenerateMergeSQL(ctx, pool, branchSchema, "public", "products", []string{"id"}, cow.MergeAll)
 	if err != nil {
 		t.Fatalf("GenerateMergeSQL: %v", err)
 	}
@@ -475,6 +655,40 @@ func TestCowIntrospection(t *testing.T) {
 		t.Errorf("PKs = %v, want [id]", pks)
 	}
 
+	// A table that only exists in a branch schema is found via the fallback.
+	_, _ = pool.Exec(ctx, `CREATE SCHEMA IF NOT EXISTS _rift_branch_introspect`)
+	_, _ = pool.Exec(ctx, `CREATE TABLE _rift_branch_introspect.branch_only (code TEXT PRIMARY KEY, note TEXT)`)
+	pks, err = cow.GetTablePrimaryKeys(ctx, pool, "public", "branch_only", "_rift_branch_introspect")
+	if err != nil {
+		t.Fatalf("GetTablePrimaryKeys with fallback: %v", err)
+	}
+	if len(pks) != 1 || pks[0] != "code" {
+		t.Errorf("fallback PKs = %v, want [code]", pks)
+	}
+
+	// ListSearchPathTables + PrefetchPrimaryKeys
+	tables, err := cow.ListSearchPathTables(ctx, pool)
+	if err != nil {
+		t.Fatalf("ListSearchPathTables: %v", err)
+	}
+	var calls int
+	keys, err := cow.PrefetchPrimaryKeys(ctx, pool, tables, 4, func(int) { calls++ })
+	if err != nil {
+		t.Fatalf("PrefetchPrimaryKeys: %v", err)
+	}
+	if calls != len(tables) {
+		t.Errorf("progress called %d times, want %d", calls, len(tables))
+	}
+	found := false
+	for _, k := range keys {
+		if k.SourceSchema == "public" && k.TableName == "test_introspect" && k.ColumnName == "id" && k.Ordinal == 1 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("prefetched keys %+v missing public.test_introspect.id", keys)
+	}
+
 	// TableExists
 	exists, err := cow.TableExists(ctx, pool, "public", "test_introspect")
 	if err != nil || !exists {
@@ -527,7 +741,111 @@ func TestServerLifecycle(t *testing.T) {
 	}
 }
 
-func TestEngineCreateDeleteBranch(t *testing.T) {
+func TestServerHealthEndpoints(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	srv := server.New(&server.Config{
+		UpstreamURL:  testURL,
+		ListenAddr:   "127.0.0.1:0",
+		UpstreamAddr: "localhost:5432",
+		UpstreamUser: "postgres",
+		UpstreamPass: "postgres",
+		APIAddr:      "127.0.0.1:0",
+	})
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("server.Start: %v", err)
+	}
+	defer func() { _ = srv.Stop() }()
+
+	if err := srv.Engine().CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+
+	resp, err := http.Get("http://" + srv.APIAddr() + "/ready")
+	if err != nil {
+		t.Fatalf("GET /ready: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /ready = %d, want 200", resp.StatusCode)
+	}
+
+	resp, err = http.Get("http://" + srv.APIAddr() + "/health/deep")
+	if err != nil {
+		t.Fatalf("GET /health/deep: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var body struct {
+		Status string `json:"status"`
+		Checks []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		} `json:"checks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode /health/deep: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Status != "ok" {
+		t.Errorf("GET /health/deep = %d %q", resp.StatusCode, body.Status)
+	}
+	if len(body.Checks) != 4 {
+		t.Fatalf("got %d checks, want 4", len(body.Checks))
+	}
+	for _, c := range body.Checks {
+		if c.Status != "ok" {
+			t.Errorf("check %s = %s (%s), want ok", c.Name, c.Status, c.Error)
+		}
+	}
+}
+
+func TestServerDrainsInFlightQueries(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	srv := server.New(&server.Config{
+		UpstreamURL:  testURL,
+		ListenAddr:   "127.0.0.1:0",
+		UpstreamAddr: "localhost:5432",
+		UpstreamUser: "postgres",
+		UpstreamPass: "postgres",
+		DrainTimeout: 10 * time.Second,
+	})
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("server.Start: %v", err)
+	}
+	if err := srv.Engine().CreateBranch(ctx, "feature", "main", nil); err != nil {
+		_ = srv.Stop()
+		t.Fatalf("CreateBranch: %v", err)
+	}
+
+	conn, err := pgx.Connect(ctx, "postgres://postgres:postgres@"+srv.Addr()+"/feature?sslmode=disable")
+	if err != nil {
+		_ = srv.Stop()
+		t.Fatalf("connect to proxy: %v", err)
+	}
+	defer func() { _ = conn.Close(ctx) }()
+
+	queryErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Exec(ctx, "SELECT pg_sleep(1)")
+		queryErr <- err
+	}()
+	time.Sleep(200 * time.Millisecond) // let the query start
+
+	if err := srv.Stop(); err != nil {
+		t.Fatalf("server.Stop: %v", err)
+	}
+	if err := <-queryErr; err != nil {
+		t.Errorf("in-flight query failed during shutdown: %v", err)
+	}
+}
+
+func TestEngineRebaseBranch(t *testing.T) {
 	testURL, cleanup := setupTestDB(t)
 	defer cleanup()
 
@@ -542,49 +860,94 @@ func TestEngineCreateDeleteBranch(t *testing.T) {
 		t.Fatalf("store.Init: %v", err)
 	}
 
-	// Update main branch database
-	main, _ := store.GetBranch(ctx, "main")
-	main.Database = "testdb"
-	_ = store.UpdateBranch(ctx, main)
+	pool := store.Pool()
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL, email TEXT);
+		INSERT INTO public.users VALUES (1, 'Alice', 'alice@test.com'), (2, 'Bob', 'bob@test.com'),
+			(3, 'Carol', 'carol@test.com')`)
+	if err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
 
 	engine := cow.NewEngine(store)
-
-	// Create branch
 	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
 		t.Fatalf("CreateBranch: %v", err)
 	}
 
-	// Verify metadata
-	b, err := store.GetBranch(ctx, "feature")
+	branchSchema := store.BranchSchemaName("feature")
+	if err := cow.EnsureOverlayTable(ctx, pool, branchSchema, "public", "users", cow.OverlayOptions{}); err != nil {
+		t.Fatalf("EnsureOverlayTable: %v", err)
+	}
+	if err := store.TrackTable(ctx, &storage.TrackedTable{
+		BranchName: "feature", SourceSchema: "public", TableName: "users", OverlayTable: "users",
+	}); err != nil {
+		t.Fatalf("TrackTable: %v", err)
+	}
+
+	// Insert 100, rename Bob, delete Alice and Carol
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %s."users" (id, name, email, _rift_tombstone) VALUES
+			(100, 'Dave', 'dave@test.com', false),
+			(2, 'Robert', 'bob@test.com', false),
+			(1, 'Alice', 'alice@test.com', true),
+			(3, 'Carol', 'carol@test.com', true)`,
+		pgQuoteIdent(branchSchema)))
 	if err != nil {
-		t.Fatalf("GetBranch: %v", err)
+		t.Fatalf("insert overlay rows: %v", err)
 	}
-	if b.Parent != "main" {
-		t.Errorf("branch parent = %q, want %q", b.Parent, "main")
+
+	// Meanwhile on main: a column is added and Carol is removed
+	_, err = pool.Exec(ctx, `
+		ALTER TABLE public.users ADD COLUMN active BOOLEAN NOT NULL DEFAULT true;
+		DELETE FROM public.users WHERE id = 3`)
+	if err != nil {
+		t.Fatalf("change source: %v", err)
 	}
 
-	// Verify schema exists
-	schemaName := store.BranchSchemaName("feature")
-	var exists bool
-	store.Pool().QueryRow(ctx,
-		`SELECT EXISTS(SELECT 1 FROM information_schema.schemata WHERE schema_name = $1)`,
-		schemaName).Scan(&exists)
-	if !exists {
-		t.Error("branch schema should exist after create")
+	if err := engine.RebaseBranch(ctx, "feature"); err != nil {
+		t.Fatalf("RebaseBranch: %v", err)
 	}
 
-	// Delete branch
-	if err := engine.DeleteBranch(ctx, "feature"); err != nil {
-		t.Fatalf("DeleteBranch: %v", err)
+	var name string
+	var active bool
+	err = pool.QueryRow(ctx, fmt.Sprintf(
+		`SELECT name, active FROM %s."users" WHERE id = 2 AND NOT _rift_tombstone`,
+		pgQuoteIdent(branchSchema))).Scan(&name, &active)
+	if err != nil {
+		t.Fatalf("read rebased update: %v", err)
+	}
+	if name != "Robert" || !active {
+		t.Errorf("rebased row 2 = (%q, %v), want (Robert, true)", name, active)
 	}
 
-	_, err = store.GetBranch(ctx, "feature")
-	if err == nil {
-		t.Error("branch should not exist after delete")
+	// The inserted row gets the new column's default
+	err = pool.QueryRow(ctx, fmt.Sprintf(
+		`SELECT active FROM %s."users" WHERE id = 100`, pgQuoteIdent(branchSchema))).Scan(&active)
+	if err != nil || !active {
+		t.Errorf("rebased row 100 active = %v, %v; want true", active, err)
+	}
+
+	var rows, tombstones int
+	err = pool.QueryRow(ctx, fmt.Sprintf(
+		`SELECT count(*) FILTER (WHERE NOT _rift_tombstone), count(*) FILTER (WHERE _rift_tombstone)
+		 FROM %s."users"`, pgQuoteIdent(branchSchema))).Scan(&rows, &tombstones)
+	if err != nil {
+		t.Fatalf("count overlay rows: %v", err)
+	}
+	if rows != 2 || tombstones != 1 {
+		t.Errorf("overlay has %d rows and %d tombstones, want 2 and 1", rows, tombstones)
+	}
+
+	tables, err := store.ListTrackedTables(ctx, "feature", "")
+	if err != nil {
+		t.Fatalf("ListTrackedTables: %v", err)
+	}
+	if len(tables) != 1 || tables[0].RowCount != 3 {
+		t.Errorf("tracked tables = %+v, want users with 3 rows", tables)
 	}
 }
 
-func TestEngineProcessQueryMainBranch(t *testing.T) {
+func TestEngineDiffBranches(t *testing.T) {
 	testURL, cleanup := setupTestDB(t)
 	defer cleanup()
 
@@ -594,24 +957,78 @@ func TestEngineProcessQueryMainBranch(t *testing.T) {
 		t.Fatalf("storage.New: %v", err)
 	}
 	defer store.Close()
-	_ = store.Init(ctx)
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL);
+		INSERT INTO public.users VALUES (1, 'Alice'), (2, 'Bob'), (3, 'Carol')`)
+	if err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
 
 	engine := cow.NewEngine(store)
+	for _, name := range []string{"feat-a", "feat-b"} {
+		if err := engine.CreateBranch(ctx, name, "main", nil); err != nil {
+			t.Fatalf("CreateBranch %s: %v", name, err)
+		}
+		if err := cow.EnsureOverlayTable(ctx, pool, store.BranchSchemaName(name), "public", "users", cow.OverlayOptions{}); err != nil {
+			t.Fatalf("EnsureOverlayTable: %v", err)
+		}
+		if err := store.TrackTable(ctx, &storage.TrackedTable{
+			BranchName: name, SourceSchema: "public", TableName: "users", OverlayTable: "users",
+		}); err != nil {
+			t.Fatalf("TrackTable: %v", err)
+		}
+	}
+
+	// feat-a inserts 100 and renames Bob; feat-b renames Bob the same way
+	// and deletes Carol
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %s."users" (id, name, _rift_tombstone) VALUES (100, 'Dave', false), (2, 'Robert', false);
+		 INSERT INTO %s."users" (id, name, _rift_tombstone) VALUES (2, 'Robert', false), (3, 'Carol', true)`,
+		pgQuoteIdent(store.BranchSchemaName("feat-a")), pgQuoteIdent(store.BranchSchemaName("feat-b"))))
+	if err != nil {
+		t.Fatalf("insert overlay rows: %v", err)
+	}
 
-	// Main branch should always passthrough
-	pq, err := engine.ProcessQuery(ctx, "main", "SELECT * FROM users")
+	diff, err := engine.DiffBranches(ctx, "feat-a", "feat-b", "")
 	if err != nil {
-		t.Fatalf("ProcessQuery: %v", err)
+		t.Fatalf("DiffBranches: %v", err)
 	}
-	if !pq.IsPassthrough {
-		t.Error("main branch queries should be passthrough")
+	if diff.Parent != "main" || diff.Target != "feat-b" {
+		t.Errorf("diff parent/target = %q/%q, want main/feat-b", diff.Parent, diff.Target)
+	}
+	if len(diff.Tables) != 1 {
+		t.Fatalf("diff has %d tables, want 1", len(diff.Tables))
+	}
+	// 100 and Carol exist only on feat-a; Robert is the same on both
+	if td := diff.Tables[0]; td.Inserts != 2 || td.Updates != 0 || td.Deletes != 0 {
+		t.Errorf("diff = %+v, want 2 inserts", td)
+	}
+
+	diff, err = engine.DiffBranches(ctx, "feat-b", "main", "")
+	if err != nil {
+		t.Fatalf("DiffBranches against main: %v", err)
+	}
+	if td := diff.Tables[0]; td.Inserts != 0 || td.Updates != 1 || td.Deletes != 1 {
+		t.Errorf("diff against main = %+v, want 1 update and 1 delete", td)
+	}
+
+	// Only public is tracked, so another schema has nothing to compare
+	diff, err = engine.DiffBranches(ctx, "feat-a", "feat-b", "analytics")
+	if err != nil {
+		t.Fatalf("DiffBranches in analytics: %v", err)
 	}
-	if pq.RewrittenSQL != "SELECT * FROM users" {
-		t.Errorf("main branch query should be unchanged, got %q", pq.RewrittenSQL)
+	if len(diff.Tables) != 0 {
+		t.Errorf("diff in analytics has %d tables, want 0", len(diff.Tables))
 	}
 }
 
-func TestEngineProcessQueryTransactionControl(t *testing.T) {
+func TestEnginePreviewMerge(t *testing.T) {
 	testURL, cleanup := setupTestDB(t)
 	defer cleanup()
 
@@ -621,23 +1038,1810 @@ func TestEngineProcessQueryTransactionControl(t *testing.T) {
 		t.Fatalf("storage.New: %v", err)
 	}
 	defer store.Close()
-	_ = store.Init(ctx)
 
-	engine := cow.NewEngine(store)
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
 
-	// Transaction control should passthrough even on non-main
-	for _, sql := range []string{"BEGIN", "COMMIT", "ROLLBACK", "START TRANSACTION"} {
-		pq, err := engine.ProcessQuery(ctx, "feature", sql)
-		if err != nil {
-			t.Fatalf("ProcessQuery(%q): %v", sql, err)
+	pool := store.Pool()
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL);
+		CREATE TABLE public.orders (id BIGINT PRIMARY KEY, user_id BIGINT REFERENCES public.users (id));
+		INSERT INTO public.users VALUES (1, 'Alice'), (2, 'Bob');
+		INSERT INTO public.orders VALUES (10, 1)`)
+	if err != nil {
+		t.Fatalf("create source tables: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	branchSchema := store.BranchSchemaName("feature")
+	for _, table := range []string{"users", "orders"} {
+		if err := cow.EnsureOverlayTable(ctx, pool, branchSchema, "public", table, cow.OverlayOptions{}); err != nil {
+			t.Fatalf("EnsureOverlayTable %s: %v", table, err)
 		}
-		if !pq.IsPassthrough {
-			t.Errorf("%q should be passthrough on branch", sql)
+		if err := store.TrackTable(ctx, &storage.TrackedTable{
+			BranchName: "feature", SourceSchema: "public", TableName: table, OverlayTable: table,
+		}); err != nil {
+			t.Fatalf("TrackTable %s: %v", table, err)
 		}
 	}
+
+	// The branch deletes Alice, whose order stays, and adds an order for a
+	// user that doesn't exist
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %[1]s."users" (id, name, _rift_tombstone) VALUES (1, 'Alice', true);
+		 INSERT INTO %[1]s."orders" (id, user_id, _rift_tombstone) VALUES (11, 99, false)`,
+		pgQuoteIdent(branchSchema)))
+	if err != nil {
+		t.Fatalf("insert overlay rows: %v", err)
+	}
+
+	preview, err := engine.PreviewMerge(ctx, "feature")
+	if err != nil {
+		t.Fatalf("PreviewMerge: %v", err)
+	}
+	if preview.Parent != "main" || len(preview.Tables) != 2 {
+		t.Errorf("preview parent = %q with %d tables, want main with 2", preview.Parent, len(preview.Tables))
+	}
+	if preview.Inserts != 1 || preview.Updates != 0 || preview.Deletes != 1 {
+		t.Errorf("preview rows = +%d ~%d -%d, want +1 ~0 -1", preview.Inserts, preview.Updates, preview.Deletes)
+	}
+	if preview.EstimatedDuration <= 0 {
+		t.Errorf("EstimatedDuration = %v, want > 0", preview.EstimatedDuration)
+	}
+	if len(preview.FKRisks) != 1 {
+		t.Fatalf("got %d FK risks, want 1", len(preview.FKRisks))
+	}
+	if r := preview.FKRisks[0]; r.TableName != "orders" || r.RefTable != "users" || r.Rows != 2 {
+		t.Errorf("FK risk = %+v, want orders → users with 2 rows", r)
+	}
 }
 
-// pgQuoteIdent is duplicated here since the cow package version is unexported.
-func pgQuoteIdent(ident string) string {
-	return `"` + ident + `"`
+func TestEngineMergeOnlyTables(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL);
+		CREATE TABLE public.orders (id BIGINT PRIMARY KEY, total INT NOT NULL)`)
+	if err != nil {
+		t.Fatalf("create source tables: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	branchSchema := store.BranchSchemaName("feature")
+	for _, table := range []string{"users", "orders"} {
+		if err := cow.EnsureOverlayTable(ctx, pool, branchSchema, "public", table, cow.OverlayOptions{}); err != nil {
+			t.Fatalf("EnsureOverlayTable %s: %v", table, err)
+		}
+		if err := store.TrackTable(ctx, &storage.TrackedTable{
+			BranchName: "feature", SourceSchema: "public", TableName: table, OverlayTable: table,
+		}); err != nil {
+			t.Fatalf("TrackTable %s: %v", table, err)
+		}
+	}
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %[1]s."users" (id, name, _rift_tombstone) VALUES (1, 'Alice', false);
+		 INSERT INTO %[1]s."orders" (id, total, _rift_tombstone) VALUES (10, 5, false)`,
+		pgQuoteIdent(branchSchema)))
+	if err != nil {
+		t.Fatalf("insert overlay rows: %v", err)
+	}
+
+	if _, err := engine.ExecuteMerge(ctx, "feature", []string{"nope"}, cow.MergeAll, 0); !errors.Is(err, cow.ErrTableNotFound) {
+		t.Errorf("ExecuteMerge of an untracked table: got %v, want ErrTableNotFound", err)
+	}
+
+	result, err := engine.ExecuteMerge(ctx, "feature", []string{"users"}, cow.MergeAll, 0)
+	if err != nil {
+		t.Fatalf("ExecuteMerge: %v", err)
+	}
+	if result.Tables != 1 {
+		t.Errorf("merged %d tables, want 1", result.Tables)
+	}
+
+	var users, orders, overlayOrders int
+	if err := pool.QueryRow(ctx, `SELECT count(*) FROM public.users`).Scan(&users); err != nil {
+		t.Fatalf("count users: %v", err)
+	}
+	if err := pool.QueryRow(ctx, `SELECT count(*) FROM public.orders`).Scan(&orders); err != nil {
+		t.Fatalf("count orders: %v", err)
+	}
+	if err := pool.QueryRow(ctx, fmt.Sprintf(`SELECT count(*) FROM %s."orders"`, pgQuoteIdent(branchSchema))).Scan(&overlayOrders); err != nil {
+		t.Fatalf("count overlay orders: %v", err)
+	}
+	if users != 1 || orders != 0 || overlayOrders != 1 {
+		t.Errorf("after merge: %d users, %d orders, %d overlay orders; want 1, 0, 1", users, orders, overlayOrders)
+	}
+}
+
+func TestEngineMergeOnlyInserts(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL);
+		INSERT INTO public.users VALUES (1, 'Alice'), (2, 'Bob')`)
+	if err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	branchSchema := store.BranchSchemaName("feature")
+	if err := cow.EnsureOverlayTable(ctx, pool, branchSchema, "public", "users", cow.OverlayOptions{}); err != nil {
+		t.Fatalf("EnsureOverlayTable: %v", err)
+	}
+	if err := store.TrackTable(ctx, &storage.TrackedTable{
+		BranchName: "feature", SourceSchema: "public", TableName: "users", OverlayTable: "users",
+	}); err != nil {
+		t.Fatalf("TrackTable: %v", err)
+	}
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %s."users" (id, name, _rift_tombstone) VALUES (1, 'Alicia', false), (2, 'Bob', true), (3, 'Carol', false)`,
+		pgQuoteIdent(branchSchema)))
+	if err != nil {
+		t.Fatalf("insert overlay rows: %v", err)
+	}
+
+	merges, err := engine.GenerateMerge(ctx, "feature", nil, cow.MergeOptions{Inserts: true})
+	if err != nil {
+		t.Fatalf("GenerateMerge: %v", err)
+	}
+	if len(merges) != 1 || merges[0].InsertSQL == "" || merges[0].UpdateSQL != "" || merges[0].DeleteSQL != "" {
+		t.Fatalf("GenerateMerge with only inserts = %+v, want only an INSERT", merges)
+	}
+
+	if _, err := engine.ExecuteMerge(ctx, "feature", nil, cow.MergeOptions{Inserts: true}, 0); err != nil {
+		t.Fatalf("ExecuteMerge: %v", err)
+	}
+
+	rows, err := pool.Query(ctx, `SELECT id, name FROM public.users ORDER BY id`)
+	if err != nil {
+		t.Fatalf("query users: %v", err)
+	}
+	var got []string
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%d:%s", id, name))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows: %v", err)
+	}
+	// Carol is inserted; Alice keeps her name and Bob is not deleted
+	if want := []string{"1:Alice", "2:Bob", "3:Carol"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("after merging only inserts: users = %v, want %v", got, want)
+	}
+}
+
+func TestEngineMergeConflicts(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	var tracking string
+	if err := pool.QueryRow(ctx, "SHOW track_commit_timestamp").Scan(&tracking); err != nil {
+		t.Fatalf("SHOW track_commit_timestamp: %v", err)
+	}
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL);
+		INSERT INTO public.users VALUES (1, 'Alice'), (2, 'Bob'), (3, 'Carol'), (4, 'Dan')`)
+	if err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	if tracking != "on" {
+		if _, err := engine.DetectMergeConflicts(ctx, "feature", nil); !errors.Is(err, cow.ErrCommitTimestampsOff) {
+			t.Errorf("DetectMergeConflicts without commit timestamps = %v, want ErrCommitTimestampsOff", err)
+		}
+		t.Skip("track_commit_timestamp is off in the test database")
+	}
+
+	branchSchema := store.BranchSchemaName("feature")
+	if err := cow.EnsureOverlayTable(ctx, pool, branchSchema, "public", "users", cow.OverlayOptions{}); err != nil {
+		t.Fatalf("EnsureOverlayTable: %v", err)
+	}
+	if err := store.TrackTable(ctx, &storage.TrackedTable{
+		BranchName: "feature", SourceSchema: "public", TableName: "users", OverlayTable: "users",
+	}); err != nil {
+		t.Fatalf("TrackTable: %v", err)
+	}
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %s."users" (id, name, _rift_tombstone) VALUES (1, 'Alicia', false), (2, 'Bob', true), (3, 'Caroline', false), (4, 'Daniel', false)`,
+		pgQuoteIdent(branchSchema)))
+	if err != nil {
+		t.Fatalf("insert overlay rows: %v", err)
+	}
+	// Rows 1-3 also change in the parent; row 4 only on the branch
+	if _, err := pool.Exec(ctx, `UPDATE public.users SET name = name || ' (parent)' WHERE id <= 3`); err != nil {
+		t.Fatalf("update parent rows: %v", err)
+	}
+
+	conflicts, err := engine.DetectMergeConflicts(ctx, "feature", nil)
+	if err != nil {
+		t.Fatalf("DetectMergeConflicts: %v", err)
+	}
+	if len(conflicts) != 3 || string(conflicts[0].PK["id"]) != "1" || conflicts[1].Branch != nil ||
+		string(conflicts[2].Parent["name"]) != `"Carol (parent)"` {
+		t.Fatalf("DetectMergeConflicts = %+v, want rows 1, 2 (deleted on the branch) and 3", conflicts)
+	}
+
+	resolutions := []cow.ConflictResolution{
+		conflicts[0].Resolution(cow.ResolveBranch),
+		conflicts[1].Resolution(cow.ResolveParent),
+		conflicts[2].Resolution(cow.ResolveEdit),
+	}
+	resolutions[2].Values = map[string]json.RawMessage{"name": json.RawMessage(`"Caroline (edited)"`)}
+	if _, err := engine.ExecuteMergeResolved(ctx, "feature", nil, cow.MergeAll, 0, resolutions); err != nil {
+		t.Fatalf("ExecuteMergeResolved: %v", err)
+	}
+
+	rows, err := pool.Query(ctx, `SELECT id, name FROM public.users ORDER BY id`)
+	if err != nil {
+		t.Fatalf("query users: %v", err)
+	}
+	var got []string
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%d:%s", id, name))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows: %v", err)
+	}
+	want := []string{"1:Alicia", "2:Bob (parent)", "3:Caroline (edited)", "4:Daniel"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("after resolved merge: users = %v, want %v", got, want)
+	}
+}
+
+func TestEngineMergeTableOrder(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY);
+		CREATE TABLE public.accounts (id BIGINT PRIMARY KEY, user_id BIGINT REFERENCES public.users);
+		CREATE TABLE public.a_items (id BIGINT PRIMARY KEY, account_id BIGINT REFERENCES public.accounts)`)
+	if err != nil {
+		t.Fatalf("create source tables: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	if err := engine.TrackAllTables(ctx, "feature", "public"); err != nil {
+		t.Fatalf("TrackAllTables: %v", err)
+	}
+
+	order, err := engine.MergeTableOrder(ctx, "feature")
+	if err != nil {
+		t.Fatalf("MergeTableOrder: %v", err)
+	}
+	// Tracked tables are listed alphabetically; referenced tables go first
+	if want := "public.users,public.accounts,public.a_items"; strings.Join(order, ",") != want {
+		t.Errorf("MergeTableOrder = %v, want %s", order, want)
+	}
+}
+
+func TestEngineMaterializeBranch(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL);
+		INSERT INTO public.users VALUES (1, 'Alice'), (2, 'Bob'), (3, 'Carol')`)
+	if err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	branchSchema := store.BranchSchemaName("feature")
+	if err := cow.EnsureOverlayTable(ctx, pool, branchSchema, "public", "users", cow.OverlayOptions{}); err != nil {
+		t.Fatalf("EnsureOverlayTable: %v", err)
+	}
+	if err := store.TrackTable(ctx, &storage.TrackedTable{
+		BranchName: "feature", SourceSchema: "public", TableName: "users", OverlayTable: "users",
+	}); err != nil {
+		t.Fatalf("TrackTable: %v", err)
+	}
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %s."users" (id, name, _rift_tombstone) VALUES (2, 'Robert', false), (3, 'Carol', true), (4, 'Dave', false)`,
+		pgQuoteIdent(branchSchema)))
+	if err != nil {
+		t.Fatalf("insert overlay rows: %v", err)
+	}
+
+	schema, err := engine.MaterializeBranch(ctx, "feature")
+	if err != nil {
+		t.Fatalf("MaterializeBranch: %v", err)
+	}
+	if !strings.HasPrefix(schema, cow.SnapshotSchemaPrefix) {
+		t.Errorf("snapshot schema = %q", schema)
+	}
+
+	rows, err := pool.Query(ctx, fmt.Sprintf(`SELECT id, name FROM %s."users" ORDER BY id`, pgQuoteIdent(schema)))
+	if err != nil {
+		t.Fatalf("read snapshot: %v", err)
+	}
+	var got []string
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%d:%s", id, name))
+	}
+	rows.Close()
+	if want := "1:Alice,2:Robert,4:Dave"; strings.Join(got, ",") != want {
+		t.Errorf("snapshot rows = %s, want %s", strings.Join(got, ","), want)
+	}
+
+	if err := engine.DropSnapshotSchema(ctx, schema); err != nil {
+		t.Fatalf("DropSnapshotSchema: %v", err)
+	}
+	var exists bool
+	if err := pool.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = $1)`, schema).Scan(&exists); err != nil {
+		t.Fatalf("check schema: %v", err)
+	}
+	if exists {
+		t.Error("snapshot schema still exists after DropSnapshotSchema")
+	}
+}
+
+func TestEngineWatchBranch(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL);
+		INSERT INTO public.users VALUES (1, 'Alice')`)
+	if err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	branchSchema := store.BranchSchemaName("feature")
+	if err := cow.EnsureOverlayTable(ctx, pool, branchSchema, "public", "users", cow.OverlayOptions{}); err != nil {
+		t.Fatalf("EnsureOverlayTable: %v", err)
+	}
+	if err := store.TrackTable(ctx, &storage.TrackedTable{
+		BranchName: "feature", SourceSchema: "public", TableName: "users", OverlayTable: "users",
+	}); err != nil {
+		t.Fatalf("TrackTable: %v", err)
+	}
+
+	watchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	events := make(chan cow.OverlayEvent, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- engine.WatchBranch(watchCtx, "feature", "users", 50*time.Millisecond, func(ev cow.OverlayEvent) {
+			events <- ev
+		})
+	}()
+
+	// Give the watch time to take its start time
+	time.Sleep(200 * time.Millisecond)
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %s."users" (id, name, _rift_tombstone) VALUES (1, 'Alicia', false), (2, 'Bob', false)`,
+		pgQuoteIdent(branchSchema)))
+	if err != nil {
+		t.Fatalf("insert overlay rows: %v", err)
+	}
+
+	got := map[string]string{}
+	for len(got) < 2 {
+		select {
+		case ev := <-events:
+			got[strings.Join(ev.Key, ",")] = ev.Operation
+		case <-watchCtx.Done():
+			t.Fatalf("timed out waiting for events, got %v", got)
+		}
+	}
+	if got["id=1"] != cow.ChangeUpdate || got["id=2"] != cow.ChangeInsert {
+		t.Errorf("events = %v, want id=1 UPDATE and id=2 INSERT", got)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("WatchBranch: %v", err)
+	}
+}
+
+func TestEngineDeltaSizeLimit(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	if _, err := store.Pool().Exec(ctx,
+		`CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+
+	limit := int64(1024)
+	if err := store.SetBranchMaxDeltaBytes(ctx, "feature", &limit); err != nil {
+		t.Fatalf("SetBranchMaxDeltaBytes: %v", err)
+	}
+	b, err := store.GetBranch(ctx, "feature")
+	if err != nil {
+		t.Fatalf("GetBranch: %v", err)
+	}
+	if b.MaxDeltaBytes == nil || *b.MaxDeltaBytes != limit {
+		t.Fatalf("MaxDeltaBytes = %v, want %d", b.MaxDeltaBytes, limit)
+	}
+
+	insert := "INSERT INTO users (id, name) VALUES (1, 'Alice')"
+	if _, err := engine.ProcessQuery(ctx, "feature", insert); err != nil {
+		t.Fatalf("write under the limit: %v", err)
+	}
+
+	b.DeltaSize = limit
+	if err := store.UpdateBranch(ctx, b); err != nil {
+		t.Fatalf("UpdateBranch: %v", err)
+	}
+	if _, err := engine.ProcessQuery(ctx, "feature", insert); !errors.Is(err, cow.ErrDeltaSizeLimit) {
+		t.Errorf("write at the limit: err = %v, want ErrDeltaSizeLimit", err)
+	}
+	if _, err := engine.ProcessQuery(ctx, "feature", "SELECT * FROM users"); err != nil {
+		t.Errorf("read at the limit: %v", err)
+	}
+
+	if err := store.SetBranchMaxDeltaBytes(ctx, "feature", nil); err != nil {
+		t.Fatalf("remove limit: %v", err)
+	}
+	if _, err := engine.ProcessQuery(ctx, "feature", insert); err != nil {
+		t.Errorf("write after removing the limit: %v", err)
+	}
+}
+
+func TestEngineFreezeBranch(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	if _, err := store.Pool().Exec(ctx,
+		`CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+
+	insert := "INSERT INTO users (id, name) VALUES (1, 'Alice')"
+	if _, err := engine.ProcessQuery(ctx, "feature", insert); err != nil {
+		t.Fatalf("write before freezing: %v", err)
+	}
+
+	if err := engine.FreezeBranch(ctx, "feature"); err != nil {
+		t.Fatalf("FreezeBranch: %v", err)
+	}
+	b, err := store.GetBranch(ctx, "feature")
+	if err != nil {
+		t.Fatalf("GetBranch: %v", err)
+	}
+	if !b.Frozen {
+		t.Error("branch not marked frozen")
+	}
+
+	if _, err := engine.ProcessQuery(ctx, "feature", insert); !errors.Is(err, cow.ErrBranchFrozen) {
+		t.Errorf("write to frozen branch: err = %v, want ErrBranchFrozen", err)
+	}
+	if _, err := engine.ProcessQuery(ctx, "feature", "SELECT * FROM users"); err != nil {
+		t.Errorf("read from frozen branch: %v", err)
+	}
+
+	if err := engine.UnfreezeBranch(ctx, "feature"); err != nil {
+		t.Fatalf("UnfreezeBranch: %v", err)
+	}
+	if _, err := engine.ProcessQuery(ctx, "feature", insert); err != nil {
+		t.Errorf("write after unfreezing: %v", err)
+	}
+}
+
+func TestEngineAnalyzeOverlays(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	if _, err := store.Pool().Exec(ctx,
+		`CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	pq, err := engine.ProcessQuery(ctx, "feature", "INSERT INTO users (id, name) VALUES (1, 'Alice'), (2, 'Bob'), (3, 'Carol')")
+	if err != nil {
+		t.Fatalf("ProcessQuery: %v", err)
+	}
+	if _, err := store.Pool().Exec(ctx, pq.RewrittenSQL); err != nil {
+		t.Fatalf("insert into overlay: %v", err)
+	}
+
+	if err := engine.AnalyzeOverlays(ctx, "feature"); err != nil {
+		t.Fatalf("AnalyzeOverlays: %v", err)
+	}
+
+	// ANALYZE records the row count in pg_class (-1 means never analyzed)
+	var reltuples float64
+	err = store.Pool().QueryRow(ctx,
+		`SELECT c.reltuples FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		 WHERE n.nspname = $1 AND c.relname = 'users'`,
+		store.BranchSchemaName("feature")).Scan(&reltuples)
+	if err != nil {
+		t.Fatalf("read reltuples: %v", err)
+	}
+	if reltuples != 3 {
+		t.Errorf("overlay reltuples = %v after analyze, want 3", reltuples)
+	}
+
+	if err := engine.AnalyzeOverlays(ctx, "main"); err == nil {
+		t.Error("AnalyzeOverlays(main) should fail")
+	}
+}
+
+func TestEngineCopyFromCSV(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	if _, err := pool.Exec(ctx,
+		`CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT, note TEXT)`); err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+
+	// Row 1 is already in the overlay, so the COPY must upsert it
+	pq, err := engine.ProcessQuery(ctx, "feature", "INSERT INTO users (id, name) VALUES (1, 'old')")
+	if err != nil {
+		t.Fatalf("ProcessQuery insert: %v", err)
+	}
+	if _, err := pool.Exec(ctx, pq.RewrittenSQL); err != nil {
+		t.Fatalf("exec insert: %v", err)
+	}
+
+	const rowCount = 5000
+	var data strings.Builder
+	data.WriteString("id,name,note\n")
+	for i := 1; i <= rowCount; i++ {
+		fmt.Fprintf(&data, "%d,\"user, %d\",", i, i)
+		switch {
+		case i%3 == 0:
+			data.WriteString("NA\n") // NULL
+		case i%3 == 1:
+			data.WriteString("\"NA\"\n") // quoted, so the string NA
+		default:
+			data.WriteString("\"says \"\"hi\"\"\"\n")
+		}
+	}
+
+	pq, err = engine.ProcessQuery(ctx, "feature",
+		"COPY users (id, name, note) FROM STDIN WITH (FORMAT csv, HEADER, NULL 'NA')")
+	if err != nil {
+		t.Fatalf("ProcessQuery copy: %v", err)
+	}
+	if pq.CopyIn == nil {
+		t.Fatal("expected a COPY FROM STDIN plan")
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	n, err := pq.CopyIn.Load(ctx, tx, strings.NewReader(data.String()))
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		t.Fatalf("Load: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if n != rowCount {
+		t.Errorf("Load() = %d rows, want %d", n, rowCount)
+	}
+
+	overlay := store.BranchSchemaName("feature") + ".users"
+	var total, nulls, quoted, live int64
+	if err := pool.QueryRow(ctx, fmt.Sprintf(
+		`SELECT count(*), count(*) FILTER (WHERE note IS NULL), count(*) FILTER (WHERE note = 'NA'),
+		        count(*) FILTER (WHERE NOT _rift_tombstone)
+		 FROM %s`, overlay)).Scan(&total, &nulls, &quoted, &live); err != nil {
+		t.Fatalf("count overlay rows: %v", err)
+	}
+	if total != rowCount || live != rowCount {
+		t.Errorf("overlay has %d rows (%d live), want %d", total, live, rowCount)
+	}
+	if nulls != rowCount/3 {
+		t.Errorf("%d NULL notes, want %d", nulls, rowCount/3)
+	}
+	if quoted != (rowCount+2)/3 {
+		t.Errorf("%d 'NA' notes, want %d", quoted, (rowCount+2)/3)
+	}
+
+	var name, note string
+	if err := pool.QueryRow(ctx, fmt.Sprintf(`SELECT name, note FROM %s WHERE id = 2`, overlay)).
+		Scan(&name, &note); err != nil {
+		t.Fatalf("read row 2: %v", err)
+	}
+	if name != "user, 2" || note != `says "hi"` {
+		t.Errorf("row 2 = (%q, %q)", name, note)
+	}
+	if err := pool.QueryRow(ctx, fmt.Sprintf(`SELECT name FROM %s WHERE id = 1`, overlay)).Scan(&name); err != nil {
+		t.Fatalf("read row 1: %v", err)
+	}
+	if name != "user, 1" {
+		t.Errorf("row 1 name = %q, want the copied value", name)
+	}
+
+	var srcCount int64
+	if err := pool.QueryRow(ctx, `SELECT count(*) FROM public.users`).Scan(&srcCount); err != nil {
+		t.Fatalf("count source rows: %v", err)
+	}
+	if srcCount != 0 {
+		t.Errorf("source table has %d rows, want 0", srcCount)
+	}
+}
+
+// pgQuoteIdent is duplicated here since the cow package version is unexported.
+func pgQuoteIdent(ident string) string {
+	return `"` + ident + `"`
+}
+
+func TestEngineDiffSchema(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	if _, err := store.Pool().Exec(ctx,
+		`CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	pq, err := engine.ProcessQuery(ctx, "feature", "INSERT INTO users (id, name) VALUES (1, 'Alice')")
+	if err != nil {
+		t.Fatalf("ProcessQuery: %v", err)
+	}
+	if _, err := store.Pool().Exec(ctx, pq.RewrittenSQL); err != nil {
+		t.Fatalf("insert into overlay: %v", err)
+	}
+
+	diffs, err := engine.DiffSchema(ctx, "feature")
+	if err != nil {
+		t.Fatalf("DiffSchema: %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("DiffSchema before any DDL = %+v, want no changes", diffs)
+	}
+
+	if _, err := store.Pool().Exec(ctx, `ALTER TABLE `+store.BranchSchemaName("feature")+`.users ADD COLUMN email TEXT`); err != nil {
+		t.Fatalf("alter overlay: %v", err)
+	}
+	diffs, err = engine.DiffSchema(ctx, "feature")
+	if err != nil {
+		t.Fatalf("DiffSchema: %v", err)
+	}
+	if len(diffs) != 1 || len(diffs[0].Changes) != 1 || diffs[0].Changes[0].Column != "email" {
+		t.Fatalf("DiffSchema = %+v, want the added email column", diffs)
+	}
+	if len(diffs[0].Before) != 2 || len(diffs[0].After) != 3 {
+		t.Errorf("before/after columns = %d/%d, want 2/3", len(diffs[0].Before), len(diffs[0].After))
+	}
+}
+
+func TestEngineTrackAllTables(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	for _, stmt := range []string{
+		`CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE public.orders (id BIGINT PRIMARY KEY, user_id BIGINT)`,
+		`CREATE TABLE public._rift_scratch (id BIGINT PRIMARY KEY)`,
+	} {
+		if _, err := store.Pool().Exec(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	engine := cow.NewEngine(store)
+	engine.SetTrackAllOnCreate(true)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+
+	tables, err := store.ListTrackedTables(ctx, "feature", "")
+	if err != nil {
+		t.Fatalf("ListTrackedTables: %v", err)
+	}
+	var names []string
+	for _, tt := range tables {
+		names = append(names, tt.TableName)
+	}
+	if len(names) != 2 || strings.Join(names, ",") != "orders,users" && strings.Join(names, ",") != "users,orders" {
+		t.Errorf("tracked tables = %v, want orders and users", names)
+	}
+
+	exists, err := cow.TableExists(ctx, store.Pool(), store.BranchSchemaName("feature"), "orders")
+	if err != nil || !exists {
+		t.Errorf("overlay for orders exists = %v, %v; want true", exists, err)
+	}
+	pks, err := store.GetPrimaryKeys(ctx, "public", "orders")
+	if err != nil || len(pks) != 1 || pks[0].ColumnName != "id" {
+		t.Errorf("cached PKs for orders = %+v, %v; want id", pks, err)
+	}
+
+	// Tracking again leaves the existing overlays alone
+	if err := engine.TrackAllTables(ctx, "feature", "public"); err != nil {
+		t.Fatalf("TrackAllTables: %v", err)
+	}
+}
+
+func TestEngineRepairOverlays(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	if _, err := pool.Exec(ctx, `CREATE TABLE public.users (id BIGINT PRIMARY KEY, name VARCHAR(40))`); err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	if err := engine.TrackAllTables(ctx, "feature", "public"); err != nil {
+		t.Fatalf("TrackAllTables: %v", err)
+	}
+
+	// Break the overlay the way an interrupted migration might
+	overlay := store.BranchSchemaName("feature") + ".users"
+	for _, stmt := range []string{
+		`ALTER TABLE ` + overlay + ` DROP COLUMN _rift_tombstone`,
+		`ALTER TABLE ` + overlay + ` DROP CONSTRAINT users_pkey`,
+		`ALTER TABLE ` + overlay + ` ALTER COLUMN name TYPE TEXT`,
+	} {
+		if _, err := pool.Exec(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	repairs, err := engine.RepairOverlays(ctx, "feature", []string{"users"})
+	if err != nil {
+		t.Fatalf("RepairOverlays: %v", err)
+	}
+	if len(repairs) != 1 || len(repairs[0].Fixes) != 3 {
+		t.Fatalf("repairs = %+v, want 3 fixes to users", repairs)
+	}
+
+	errs, err := engine.ValidateBranch(ctx, "feature")
+	if err != nil {
+		t.Fatalf("ValidateBranch: %v", err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("drift after repair: %v", errs)
+	}
+	if _, err := pool.Exec(ctx, `INSERT INTO `+overlay+` (id, name, _rift_tombstone) VALUES (1, 'a', false)
+		ON CONFLICT (id) DO UPDATE SET _rift_tombstone = true`); err != nil {
+		t.Errorf("write to repaired overlay: %v", err)
+	}
+
+	// A healthy overlay needs nothing
+	if repairs, err := engine.RepairOverlays(ctx, "feature", nil); err != nil || len(repairs) != 0 {
+		t.Errorf("second RepairOverlays = %+v, %v; want nothing to repair", repairs, err)
+	}
+}
+
+func TestEngineRevertTable(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	for _, stmt := range []string{
+		`CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE public.orders (id BIGINT PRIMARY KEY, user_id BIGINT)`,
+	} {
+		if _, err := store.Pool().Exec(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	if err := engine.TrackAllTables(ctx, "feature", "public"); err != nil {
+		t.Fatalf("TrackAllTables: %v", err)
+	}
+	branchSchema := store.BranchSchemaName("feature")
+	if _, err := store.Pool().Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %s."orders" (id, user_id, _rift_tombstone) VALUES (1, 1, false)`, branchSchema)); err != nil {
+		t.Fatalf("insert overlay row: %v", err)
+	}
+
+	if err := engine.RevertTable(ctx, "feature", "public", "orders"); err != nil {
+		t.Fatalf("RevertTable: %v", err)
+	}
+
+	tables, err := store.ListTrackedTables(ctx, "feature", "")
+	if err != nil {
+		t.Fatalf("ListTrackedTables: %v", err)
+	}
+	if len(tables) != 1 || tables[0].TableName != "users" {
+		t.Errorf("tracked tables after revert = %+v, want only users", tables)
+	}
+	exists, err := cow.TableExists(ctx, store.Pool(), branchSchema, "orders")
+	if err != nil || exists {
+		t.Errorf("overlay for orders exists = %v, %v; want false", exists, err)
+	}
+	pks, err := store.GetPrimaryKeys(ctx, "public", "orders")
+	if err != nil || len(pks) != 0 {
+		t.Errorf("cached PKs for orders = %+v, %v; want none", pks, err)
+	}
+
+	if err := engine.RevertTable(ctx, "feature", "public", "orders"); !errors.Is(err, cow.ErrTableNotFound) {
+		t.Errorf("reverting an untracked table: err = %v, want ErrTableNotFound", err)
+	}
+
+	// The next write recreates the overlay
+	if _, err := engine.ProcessQuery(ctx, "feature", "INSERT INTO orders (id, user_id) VALUES (2, 1)"); err != nil {
+		t.Fatalf("write after revert: %v", err)
+	}
+	exists, err = cow.TableExists(ctx, store.Pool(), branchSchema, "orders")
+	if err != nil || !exists {
+		t.Errorf("overlay for orders exists after write = %v, %v; want true", exists, err)
+	}
+}
+
+func TestEngineCopyOverlayTable(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	if _, err := store.Pool().Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT);
+		INSERT INTO public.users VALUES (1, 'Alice'), (2, 'Bob')`); err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	for _, name := range []string{"seed", "feature"} {
+		if err := engine.CreateBranch(ctx, name, "main", nil); err != nil {
+			t.Fatalf("CreateBranch %s: %v", name, err)
+		}
+	}
+	run := func(branchName, q string) {
+		t.Helper()
+		pq, err := engine.ProcessQuery(ctx, branchName, q)
+		if err != nil {
+			t.Fatalf("%s: %s: %v", branchName, q, err)
+		}
+		if _, err := store.Pool().Exec(ctx, pq.RewrittenSQL); err != nil {
+			t.Fatalf("%s: %s: %v", branchName, pq.RewrittenSQL, err)
+		}
+	}
+	run("seed", "INSERT INTO users (id, name) VALUES (3, 'Carol')")
+	run("seed", "DELETE FROM users WHERE id = 2")
+	// Replaced by the copy
+	run("feature", "UPDATE users SET name = 'Alicia' WHERE id = 1")
+
+	if err := engine.CopyOverlayTable(ctx, "seed", "feature", "users"); err != nil {
+		t.Fatalf("CopyOverlayTable: %v", err)
+	}
+
+	pq, err := engine.ProcessQuery(ctx, "feature", "SELECT id, name FROM users ORDER BY id")
+	if err != nil {
+		t.Fatalf("select on feature: %v", err)
+	}
+	rows, err := store.Pool().Query(ctx, pq.RewrittenSQL)
+	if err != nil {
+		t.Fatalf("select on feature: %v", err)
+	}
+	var got []string
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%d:%s", id, name))
+	}
+	rows.Close()
+	if want := []string{"1:Alice", "3:Carol"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("feature after copy = %v, want %v", got, want)
+	}
+
+	if err := engine.CopyOverlayTable(ctx, "seed", "feature", "orders"); !errors.Is(err, cow.ErrTableNotFound) {
+		t.Errorf("copying an untracked table: err = %v, want ErrTableNotFound", err)
+	}
+	if err := engine.CopyOverlayTable(ctx, "seed", "main", "users"); err == nil {
+		t.Error("copying into main: expected error")
+	}
+}
+
+func TestEngineExecuteMergeUnbatched(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL);
+		INSERT INTO public.users SELECT g, 'user ' || g FROM generate_series(1, 10) g`)
+	if err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	if err := engine.TrackAllTables(ctx, "feature", "public"); err != nil {
+		t.Fatalf("TrackAllTables: %v", err)
+	}
+	// 5 updates, 5 inserts and 3 deletes
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %[1]s."users" (id, name, _rift_tombstone) SELECT g, 'renamed ' || g, false FROM generate_series(1, 5) g;
+		 INSERT INTO %[1]s."users" (id, name, _rift_tombstone) SELECT g, 'new ' || g, false FROM generate_series(11, 15) g;
+		 INSERT INTO %[1]s."users" (id, name, _rift_tombstone) SELECT g, 'user ' || g, true FROM generate_series(8, 10) g`,
+		pgQuoteIdent(store.BranchSchemaName("feature"))))
+	if err != nil {
+		t.Fatalf("insert overlay rows: %v", err)
+	}
+
+	if err := engine.ExecuteMergeUnbatched(ctx, "feature", 0, nil); err == nil {
+		t.Error("ExecuteMergeUnbatched with batch size 0: got nil error")
+	}
+
+	var reports []int64
+	var total int64
+	err = engine.ExecuteMergeUnbatched(ctx, "feature", 4, func(merged, all int64) {
+		reports = append(reports, merged)
+		total = all
+	})
+	if err != nil {
+		t.Fatalf("ExecuteMergeUnbatched: %v", err)
+	}
+	if total != 13 || len(reports) == 0 || reports[len(reports)-1] != 13 {
+		t.Errorf("progress reports = %v of %d, want to end at 13 of 13", reports, total)
+	}
+
+	var count, renamed int
+	if err := pool.QueryRow(ctx,
+		`SELECT count(*), count(*) FILTER (WHERE name LIKE 'renamed %') FROM public.users`).Scan(&count, &renamed); err != nil {
+		t.Fatalf("count users: %v", err)
+	}
+	if count != 12 || renamed != 5 {
+		t.Errorf("after merge: %d users, %d renamed; want 12, 5", count, renamed)
+	}
+}
+
+func TestEngineIndexDDL(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	for _, stmt := range []string{
+		`CREATE TABLE public.users (id BIGINT PRIMARY KEY, email TEXT)`,
+		`CREATE INDEX users_id_email ON public.users (id, email)`,
+	} {
+		if _, err := store.Pool().Exec(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	branchSchema := store.BranchSchemaName("feature")
+
+	run := func(sql string) error {
+		pq, err := engine.ProcessQuery(ctx, "feature", sql)
+		if err != nil {
+			return err
+		}
+		_, err = store.Pool().Exec(ctx, pq.RewrittenSQL)
+		return err
+	}
+
+	if err := run("CREATE INDEX users_email ON users (email)"); err != nil {
+		t.Fatalf("CREATE INDEX: %v", err)
+	}
+	schemas, err := cow.IndexSchemas(ctx, store.Pool(), "users_email")
+	if err != nil {
+		t.Fatalf("IndexSchemas: %v", err)
+	}
+	if len(schemas) != 1 || schemas[0] != branchSchema {
+		t.Errorf("users_email is in %v, want only %s", schemas, branchSchema)
+	}
+
+	if err := run("DROP INDEX users_id_email"); !errors.Is(err, cow.ErrSourceIndex) {
+		t.Errorf("dropping a source index: err = %v, want ErrSourceIndex", err)
+	}
+	if err := run("DROP INDEX IF EXISTS no_such_index"); err != nil {
+		t.Errorf("DROP INDEX IF EXISTS on a missing index: %v", err)
+	}
+
+	if err := run("DROP INDEX users_email"); err != nil {
+		t.Fatalf("DROP INDEX: %v", err)
+	}
+	schemas, err = cow.IndexSchemas(ctx, store.Pool(), "users_email")
+	if err != nil || len(schemas) != 0 {
+		t.Errorf("users_email after drop is in %v, %v; want nowhere", schemas, err)
+	}
+	schemas, err = cow.IndexSchemas(ctx, store.Pool(), "users_id_email")
+	if err != nil || len(schemas) != 1 || schemas[0] != "public" {
+		t.Errorf("users_id_email is in %v, %v; want public", schemas, err)
+	}
+}
+
+func TestEngineExplainMerge(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	if _, err := pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL);
+		INSERT INTO public.users VALUES (1, 'alice'), (2, 'bob')`); err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	for _, sql := range []string{
+		"UPDATE users SET name = 'carol' WHERE id = 1",
+		"DELETE FROM users WHERE id = 2",
+		"INSERT INTO users (id, name) VALUES (3, 'dave')",
+	} {
+		pq, err := engine.ProcessQuery(ctx, "feature", sql)
+		if err != nil {
+			t.Fatalf("ProcessQuery(%q): %v", sql, err)
+		}
+		if _, err := pool.Exec(ctx, pq.RewrittenSQL); err != nil {
+			t.Fatalf("exec %q: %v", sql, err)
+		}
+	}
+
+	merges, err := engine.GenerateMerge(ctx, "feature", nil, cow.MergeAll)
+	if err != nil {
+		t.Fatalf("GenerateMerge: %v", err)
+	}
+	plans, err := engine.ExplainMerge(ctx, merges)
+	if err != nil {
+		t.Fatalf("ExplainMerge: %v", err)
+	}
+
+	var steps []string
+	for _, p := range plans {
+		if p.TableName != "users" || p.Plan == "" {
+			t.Errorf("plan %+v: want a non-empty plan for users", p)
+		}
+		steps = append(steps, p.Step)
+	}
+	if got := strings.Join(steps, ","); got != "delete,update,insert" {
+		t.Errorf("steps = %s, want delete,update,insert", got)
+	}
+
+	// Explaining changes nothing
+	rows, err := pool.Query(ctx, "SELECT name FROM public.users ORDER BY id")
+	if err != nil {
+		t.Fatalf("query source: %v", err)
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		t.Fatalf("collect source rows: %v", err)
+	}
+	if got := strings.Join(names, ","); got != "alice,bob" {
+		t.Errorf("source rows after ExplainMerge = %s, want alice,bob", got)
+	}
+}
+
+func TestSnapshotCreate(t *testing.T) {
+	for _, bin := range []string{"pg_dump", "pg_restore"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not in PATH", bin)
+		}
+	}
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	if _, err := pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL);
+		INSERT INTO public.users VALUES (1, 'alice'), (2, E'bob\ttab');
+		CREATE TABLE public.orders (id BIGINT PRIMARY KEY, total INT)`); err != nil {
+		t.Fatalf("create source tables: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "audit", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	if err := snapshot.Create(ctx, store, testURL, testURL, "audit", []string{"users"}, cow.OverlayOptions{}); err != nil {
+		t.Fatalf("snapshot.Create: %v", err)
+	}
+
+	// The source changes after the snapshot; the branch keeps the old rows
+	if _, err := pool.Exec(ctx, `UPDATE public.users SET name = 'carol' WHERE id = 1; DELETE FROM public.users WHERE id = 2`); err != nil {
+		t.Fatalf("change source: %v", err)
+	}
+	pq, err := engine.ProcessQuery(ctx, "audit", "SELECT name FROM users ORDER BY id")
+	if err != nil {
+		t.Fatalf("ProcessQuery: %v", err)
+	}
+	rows, err := pool.Query(ctx, pq.RewrittenSQL)
+	if err != nil {
+		t.Fatalf("query branch: %v", err)
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		t.Fatalf("collect rows: %v", err)
+	}
+	if got := strings.Join(names, ","); got != "alice,bob\ttab" {
+		t.Errorf("branch rows = %q, want the snapshot's alice,bob\\ttab", got)
+	}
+
+	tables, err := store.ListTrackedTables(ctx, "audit", "")
+	if err != nil {
+		t.Fatalf("ListTrackedTables: %v", err)
+	}
+	if len(tables) != 1 || tables[0].TableName != "users" {
+		t.Errorf("tracked tables = %+v, want only users", tables)
+	}
+}
+
+func TestEngineEstimateMergeSize(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL);
+		INSERT INTO public.users SELECT g, 'user ' || g FROM generate_series(1, 1000) g;
+		ANALYZE public.users`)
+	if err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	branchSchema := store.BranchSchemaName("feature")
+	if err := cow.EnsureOverlayTable(ctx, pool, branchSchema, "public", "users", cow.OverlayOptions{}); err != nil {
+		t.Fatalf("EnsureOverlayTable: %v", err)
+	}
+	if err := store.TrackTable(ctx, &storage.TrackedTable{
+		BranchName: "feature", SourceSchema: "public", TableName: "users", OverlayTable: "users",
+	}); err != nil {
+		t.Fatalf("TrackTable: %v", err)
+	}
+	// Three inserts and one delete
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %s."users" (id, name, _rift_tombstone) VALUES (1, 'user 1', true), (1001, 'a', false), (1002, 'b', false), (1003, 'c', false)`,
+		pgQuoteIdent(branchSchema)))
+	if err != nil {
+		t.Fatalf("insert overlay rows: %v", err)
+	}
+
+	estimate, err := engine.EstimateMergeSize(ctx, "feature")
+	if err != nil {
+		t.Fatalf("EstimateMergeSize: %v", err)
+	}
+	if estimate.InsertBytes <= 0 || estimate.InsertBytes != 3*estimate.DeletedBytes {
+		t.Errorf("estimate = %+v, want inserts three times the deletes", estimate)
+	}
+	if estimate.NetChange != estimate.InsertBytes-estimate.DeletedBytes {
+		t.Errorf("NetChange = %d, want %d", estimate.NetChange, estimate.InsertBytes-estimate.DeletedBytes)
+	}
+
+	tablespace, err := engine.UpstreamTablespace(ctx)
+	if err != nil {
+		t.Fatalf("UpstreamTablespace: %v", err)
+	}
+	if tablespace.Name != "pg_default" {
+		t.Errorf("tablespace = %q, want pg_default", tablespace.Name)
+	}
+}
+
+func TestEngineExecuteMergeVerified(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL);
+		INSERT INTO public.users VALUES (1, 'Alice'), (2, 'Bob'), (3, 'Carol')`)
+	if err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	for _, name := range []string{"feature", "other"} {
+		if err := engine.CreateBranch(ctx, name, "main", nil); err != nil {
+			t.Fatalf("CreateBranch %s: %v", name, err)
+		}
+		if err := engine.TrackAllTables(ctx, name, "public"); err != nil {
+			t.Fatalf("TrackAllTables %s: %v", name, err)
+		}
+	}
+
+	// feature adds a row, renames Bob, deletes Carol and tombstones a row the
+	// parent never had, which deletes nothing
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %s."users" (id, name, _rift_tombstone)
+		 VALUES (4, 'Dave', false), (2, 'Robert', false), (3, 'Carol', true), (99, 'Gone', true)`,
+		pgQuoteIdent(store.BranchSchemaName("feature"))))
+	if err != nil {
+		t.Fatalf("insert overlay rows: %v", err)
+	}
+
+	_, verification, err := engine.ExecuteMergeVerified(ctx, "feature", nil, 0)
+	if err != nil {
+		t.Fatalf("ExecuteMergeVerified: %v", err)
+	}
+	if verification == nil || !verification.OK() || verification.Tables != 1 {
+		t.Fatalf("verification = %+v, want 1 table checked without errors", verification)
+	}
+
+	// A trigger adding a row of its own throws the count off
+	_, err = pool.Exec(ctx, `
+		CREATE FUNCTION public.users_extra() RETURNS trigger LANGUAGE plpgsql AS $$
+		BEGIN
+			IF NEW.id < 1000 THEN
+				INSERT INTO public.users VALUES (NEW.id + 1000, 'extra');
+			END IF;
+			RETURN NEW;
+		END $$;
+		CREATE TRIGGER users_extra AFTER INSERT ON public.users
+			FOR EACH ROW EXECUTE FUNCTION public.users_extra()`)
+	if err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+	if _, err := pool.Exec(ctx, fmt.Sprintf(
+		`INSERT INTO %s."users" (id, name, _rift_tombstone) VALUES (5, 'Eve', false)`,
+		pgQuoteIdent(store.BranchSchemaName("other")))); err != nil {
+		t.Fatalf("insert overlay row: %v", err)
+	}
+
+	_, verification, err = engine.ExecuteMergeVerified(ctx, "other", nil, 0)
+	if !errors.Is(err, cow.ErrMergeVerification) {
+		t.Fatalf("ExecuteMergeVerified with trigger: err = %v, want ErrMergeVerification", err)
+	}
+	if verification == nil || len(verification.Errors) != 1 {
+		t.Fatalf("verification = %+v, want 1 error", verification)
+	}
+	if v := verification.Errors[0]; v.Expected() != v.Before+1 || v.After != v.Before+2 {
+		t.Errorf("verification error = %+v, want 1 row expected and 2 added", v)
+	}
+
+	var rows int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM public.users WHERE id IN (5, 1005)`).Scan(&rows); err != nil {
+		t.Fatalf("count users: %v", err)
+	}
+	if rows != 0 {
+		t.Errorf("found %d merged rows, want the merge rolled back", rows)
+	}
+}
+
+func TestAPIMergeSQLETag(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	_, err = pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT);
+		CREATE TABLE public.orders (id BIGINT PRIMARY KEY, total NUMERIC)`)
+	if err != nil {
+		t.Fatalf("create source tables: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	track := func(table string) {
+		t.Helper()
+		if err := cow.EnsureOverlayTable(ctx, pool, store.BranchSchemaName("feature"), "public", table, cow.OverlayOptions{}); err != nil {
+			t.Fatalf("EnsureOverlayTable %s: %v", table, err)
+		}
+		if err := store.TrackTable(ctx, &storage.TrackedTable{
+			BranchName: "feature", SourceSchema: "public", TableName: table, OverlayTable: table,
+		}); err != nil {
+			t.Fatalf("TrackTable %s: %v", table, err)
+		}
+	}
+	track("users")
+
+	srv := api.New(&api.Config{ListenAddr: "127.0.0.1:0"}, store, engine, nil)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer func() { _ = srv.Stop(ctx) }()
+
+	// get requests the branch's merge SQL with If-None-Match etag and
+	// returns the status and ETag
+	get := func(query, etag string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, "http://"+srv.Addr()+"/api/v1/branches/feature/merge-sql"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET merge-sql%s: %v", query, err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode, resp.Header.Get("ETag")
+	}
+
+	status, etag := get("", "")
+	if status != http.StatusOK || etag == "" {
+		t.Fatalf("GET merge-sql = %d with ETag %q, want 200 with an ETag", status, etag)
+	}
+	if status, _ := get("", etag); status != http.StatusNotModified {
+		t.Errorf("GET merge-sql with matching ETag = %d, want 304", status)
+	}
+	if status, _ := get("?format=text", etag); status != http.StatusOK {
+		t.Errorf("GET merge-sql?format=text with the JSON ETag = %d, want 200", status)
+	}
+
+	// A write that first touches a table changes the merge SQL
+	track("orders")
+	status, fullETag := get("", etag)
+	if status != http.StatusOK || fullETag == etag {
+		t.Errorf("GET merge-sql after tracking orders = %d with ETag %q, want 200 with a new ETag", status, fullETag)
+	}
+
+	// Merging only some tables is different SQL, even with the full merge's ETag
+	status, usersETag := get("?tables=users", fullETag)
+	if status != http.StatusOK || usersETag == fullETag {
+		t.Errorf("GET merge-sql?tables=users with the full merge's ETag = %d with ETag %q, want 200 with a new ETag", status, usersETag)
+	}
+	if status, _ := get("?tables=users", usersETag); status != http.StatusNotModified {
+		t.Errorf("GET merge-sql?tables=users with its own ETag = %d, want 304", status)
+	}
+}
+
+func TestEngineWritesSkipTombstones(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	if _, err := pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL);
+		INSERT INTO public.users VALUES (1, 'Alice'), (2, 'Bob')`); err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+
+	// exec runs a write on the branch and returns the rows its RETURNING
+	// clause gave back.
+	exec := func(sql string) int {
+		t.Helper()
+		pq, err := engine.ProcessQuery(ctx, "feature", sql)
+		if err != nil {
+			t.Fatalf("ProcessQuery(%q): %v", sql, err)
+		}
+		stmts := strings.Split(pq.RewrittenSQL, ";\n")
+		for _, stmt := range stmts[:len(stmts)-1] {
+			if _, err := pool.Exec(ctx, stmt); err != nil {
+				t.Fatalf("%s: %v", stmt, err)
+			}
+		}
+		rows, err := pool.Query(ctx, stmts[len(stmts)-1])
+		if err != nil {
+			t.Fatalf("%s: %v", stmts[len(stmts)-1], err)
+		}
+		defer rows.Close()
+		n := 0
+		for rows.Next() {
+			n++
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		return n
+	}
+
+	if n := exec("DELETE FROM users WHERE id = 1 RETURNING id"); n != 1 {
+		t.Errorf("first DELETE returned %d rows, want 1", n)
+	}
+	if n := exec("DELETE FROM users WHERE id = 1 RETURNING id"); n != 0 {
+		t.Errorf("second DELETE returned %d rows, want 0", n)
+	}
+	if n := exec("UPDATE users SET name = 'Alicia' RETURNING id"); n != 1 {
+		t.Errorf("UPDATE after the DELETE returned %d rows, want only Bob", n)
+	}
+
+	var name string
+	var tombstone bool
+	err = pool.QueryRow(ctx, fmt.Sprintf(`SELECT name, _rift_tombstone FROM %s."users" WHERE id = 1`,
+		pgQuoteIdent(store.BranchSchemaName("feature")))).Scan(&name, &tombstone)
+	if err != nil {
+		t.Fatalf("read tombstone: %v", err)
+	}
+	if name != "Alice" || !tombstone {
+		t.Errorf("deleted row = %q (tombstone %v), want Alice's tombstone unchanged", name, tombstone)
+	}
+}
+
+func TestEngineMaxOverlayRows(t *testing.T) {
+	testURL, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store, err := storage.New(ctx, testURL)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("store.Init: %v", err)
+	}
+
+	pool := store.Pool()
+	if _, err := pool.Exec(ctx, `
+		CREATE TABLE public.users (id BIGINT PRIMARY KEY, name TEXT NOT NULL);
+		INSERT INTO public.users VALUES (1, 'Alice'), (2, 'Bob')`); err != nil {
+		t.Fatalf("create source table: %v", err)
+	}
+
+	engine := cow.NewEngine(store)
+	engine.SetMaxOverlayRows(2)
+	if err := engine.CreateBranch(ctx, "feature", "main", nil); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+
+	// selectIDs runs a SELECT on the branch and returns the ids it read and
+	// the notices it was given.
+	selectIDs := func() ([]int64, []string) {
+		t.Helper()
+		pq, err := engine.ProcessQuery(ctx, "feature", "SELECT id FROM users ORDER BY id")
+		if err != nil {
+			t.Fatalf("ProcessQuery: %v", err)
+		}
+		rows, err := pool.Query(ctx, pq.RewrittenSQL)
+		if err != nil {
+			t.Fatalf("%s: %v", pq.RewrittenSQL, err)
+		}
+		ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+		if err != nil {
+			t.Fatalf("read ids: %v", err)
+		}
+		return ids, pq.Notices
+	}
+
+	pq, err := engine.ProcessQuery(ctx, "feature", "INSERT INTO users (id, name) VALUES (20, 'Dave'), (10, 'Carol')")
+	if err != nil {
+		t.Fatalf("ProcessQuery insert: %v", err)
+	}
+	if _, err := pool.Exec(ctx, pq.RewrittenSQL); err != nil {
+		t.Fatalf("%s: %v", pq.RewrittenSQL, err)
+	}
+
+	// At the cap nothing is left out
+	ids, notices := selectIDs()
+	if fmt.Sprint(ids) != "[1 2 10 20]" || len(notices) != 0 {
+		t.Errorf("at the cap got ids %v and notices %q, want [1 2 10 20] and none", ids, notices)
+	}
+
+	pq, err = engine.ProcessQuery(ctx, "feature", "INSERT INTO users (id, name) VALUES (5, 'Eve')")
+	if err != nil {
+		t.Fatalf("ProcessQuery insert: %v", err)
+	}
+	if _, err := pool.Exec(ctx, pq.RewrittenSQL); err != nil {
+		t.Fatalf("%s: %v", pq.RewrittenSQL, err)
+	}
+
+	// Past the cap the overlay rows with the highest keys are left out
+	ids, notices = selectIDs()
+	if fmt.Sprint(ids) != "[1 2 5 10]" || len(notices) != 1 {
+		t.Errorf("past the cap got ids %v and notices %q, want [1 2 5 10] and one notice", ids, notices)
+	}
 }